@@ -0,0 +1,386 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// annotation is a problem a tool reported at a place in the source.
+type annotation struct {
+	File   string `json:"file"`
+	Line   int    `json:"line,omitempty"`
+	Column int    `json:"column,omitempty"`
+	// Severity is error, warning or notice.
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// annotationParsers are the -annotate-format parsers, each extracting the
+// annotations from a tool's output.
+var annotationParsers = map[string]func(string) []annotation{
+	"go-build":       parseGoAnnotations("error"),
+	"go-vet":         parseGoAnnotations("warning"),
+	"eslint-stylish": parseESLintStylish,
+	"eslint-json":    parseESLintJSON,
+	"tsc":            parseTSC,
+	"pytest":         parsePytest,
+	"cargo":          parseCargo,
+}
+
+// annotationFormats returns the names of the -annotate-format parsers.
+func annotationFormats() []string {
+	var names []string
+	for name := range annotationParsers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// parseAnnotationFormats splits the -annotate-format values, which may also
+// be comma separated, and checks each names a parser.
+func parseAnnotationFormats(values []string) ([]string, error) {
+	var formats []string
+	for _, value := range values {
+		for _, format := range strings.Split(value, ",") {
+			format = strings.TrimSpace(format)
+			if format == "" {
+				continue
+			}
+			if annotationParsers[format] == nil {
+				return nil, fmt.Errorf("Error: unknown -annotate-format %q; expected one of %s", format, strings.Join(annotationFormats(), ", "))
+			}
+			formats = append(formats, format)
+		}
+	}
+	return formats, nil
+}
+
+// lines splits output into lines without their line endings.
+func lines(output string) []string {
+	return strings.Split(strings.Replace(output, "\r\n", "\n", -1), "\n")
+}
+
+func atoi(s string) int {
+	n, _ := strconv.Atoi(s)
+	return n
+}
+
+// goDiagnosticPattern matches the file:line:column: message lines of the go
+// tool and compilers that use the same convention.
+var goDiagnosticPattern = regexp.MustCompile(`^(?:vet: )?([^\s:][^:]*\.go):(\d+)(?::(\d+))?: (.+)$`)
+
+func parseGoAnnotations(severity string) func(string) []annotation {
+	return func(output string) []annotation {
+		var annotations []annotation
+		for _, line := range lines(output) {
+			if match := goDiagnosticPattern.FindStringSubmatch(line); match != nil {
+				annotations = append(annotations, annotation{
+					File: match[1], Line: atoi(match[2]), Column: atoi(match[3]),
+					Severity: severity, Message: match[4],
+				})
+			}
+		}
+		return annotations
+	}
+}
+
+var eslintStylishPattern = regexp.MustCompile(`^\s+(\d+):(\d+)\s+(error|warning)\s+(.+?)(?:\s{2,}(\S+))?\s*$`)
+
+// parseESLintStylish parses eslint's default output: a line naming each
+// file, followed by an indented line per problem in it.
+func parseESLintStylish(output string) []annotation {
+	var annotations []annotation
+	file := ""
+	for _, line := range lines(output) {
+		if match := eslintStylishPattern.FindStringSubmatch(line); match != nil && file != "" {
+			message := match[4]
+			if match[5] != "" {
+				message += " (" + match[5] + ")"
+			}
+			annotations = append(annotations, annotation{
+				File: file, Line: atoi(match[1]), Column: atoi(match[2]),
+				Severity: match[3], Message: message,
+			})
+		} else if line != "" && !strings.HasPrefix(line, " ") && !strings.HasPrefix(line, "✖") {
+			file = strings.TrimSpace(line)
+		}
+	}
+	return annotations
+}
+
+// parseESLintJSON parses the output of eslint --format json, either the
+// whole output or any line of it that holds the results.
+func parseESLintJSON(output string) []annotation {
+	var results []struct {
+		FilePath string `json:"filePath"`
+		Messages []struct {
+			RuleID   string `json:"ruleId"`
+			Severity int    `json:"severity"`
+			Message  string `json:"message"`
+			Line     int    `json:"line"`
+			Column   int    `json:"column"`
+		} `json:"messages"`
+	}
+	candidates := append([]string{output}, lines(output)...)
+	for _, candidate := range candidates {
+		if candidate = strings.TrimSpace(candidate); strings.HasPrefix(candidate, "[") && json.Unmarshal([]byte(candidate), &results) == nil {
+			break
+		}
+	}
+
+	var annotations []annotation
+	for _, result := range results {
+		for _, message := range result.Messages {
+			severity := "warning"
+			if message.Severity == 2 {
+				severity = "error"
+			}
+			text := message.Message
+			if message.RuleID != "" {
+				text += " (" + message.RuleID + ")"
+			}
+			annotations = append(annotations, annotation{
+				File: result.FilePath, Line: message.Line, Column: message.Column,
+				Severity: severity, Message: text,
+			})
+		}
+	}
+	return annotations
+}
+
+var (
+	tscPattern       = regexp.MustCompile(`^(.+?)\((\d+),(\d+)\): (error|warning|message) (TS\d+: .+)$`)
+	tscPrettyPattern = regexp.MustCompile(`^(.+?):(\d+):(\d+) - (error|warning|message) (TS\d+: .+)$`)
+)
+
+// parseTSC parses tsc's output, in both its plain and --pretty forms.
+func parseTSC(output string) []annotation {
+	var annotations []annotation
+	for _, line := range lines(output) {
+		match := tscPattern.FindStringSubmatch(line)
+		if match == nil {
+			match = tscPrettyPattern.FindStringSubmatch(line)
+		}
+		if match == nil {
+			continue
+		}
+		severity := match[4]
+		if severity == "message" {
+			severity = "notice"
+		}
+		annotations = append(annotations, annotation{
+			File: match[1], Line: atoi(match[2]), Column: atoi(match[3]),
+			Severity: severity, Message: match[5],
+		})
+	}
+	return annotations
+}
+
+var (
+	pytestSectionPattern  = regexp.MustCompile(`^_{3,} (.+?) _{3,}$`)
+	pytestLocationPattern = regexp.MustCompile(`^(\S+\.py):(\d+): (\w+)$`)
+	pytestFailedPattern   = regexp.MustCompile(`^(?:FAILED|ERROR) (\S+?\.py)::(\S+)(?: - (.+))?$`)
+)
+
+// parsePytest parses pytest's report. The traceback of each failure gives
+// the line the exception was raised on, and the short test summary the
+// message; failures without a traceback are reported on their test file.
+func parsePytest(output string) []annotation {
+	locations := map[string]annotation{}
+	var annotations []annotation
+	test := ""
+	for _, line := range lines(output) {
+		if match := pytestSectionPattern.FindStringSubmatch(line); match != nil {
+			test = match[1]
+		} else if match := pytestLocationPattern.FindStringSubmatch(line); match != nil && test != "" {
+			locations[test] = annotation{File: match[1], Line: atoi(match[2]), Message: match[3]}
+		} else if match := pytestFailedPattern.FindStringSubmatch(line); match != nil {
+			name := match[2]
+			if i := strings.LastIndex(name, "::"); i >= 0 {
+				name = name[i+2:]
+			}
+			location, ok := locations[name]
+			if !ok {
+				location = annotation{File: match[1]}
+			}
+			detail := match[3]
+			if detail == "" {
+				detail = location.Message
+			}
+			location.Severity = "error"
+			location.Message = strings.TrimSuffix(name+" failed: "+detail, ": ")
+			annotations = append(annotations, location)
+		}
+	}
+	return annotations
+}
+
+var (
+	cargoHeadingPattern  = regexp.MustCompile(`^(error|warning)(?:\[\w+\])?: (.+)$`)
+	cargoLocationPattern = regexp.MustCompile(`^\s*--> (.+?):(\d+):(\d+)$`)
+)
+
+// parseCargo parses cargo's and rustc's diagnostics, both as rendered for
+// people, where a --> line locates the heading before it, and as the
+// compiler-message lines of --message-format json.
+func parseCargo(output string) []annotation {
+	var annotations []annotation
+	var heading []string
+	for _, line := range lines(output) {
+		if strings.HasPrefix(line, "{") {
+			if a, ok := parseCargoJSON(line); ok {
+				annotations = append(annotations, a)
+			}
+			continue
+		}
+		if match := cargoHeadingPattern.FindStringSubmatch(line); match != nil {
+			heading = match[1:]
+		} else if match := cargoLocationPattern.FindStringSubmatch(line); match != nil && heading != nil {
+			annotations = append(annotations, annotation{
+				File: match[1], Line: atoi(match[2]), Column: atoi(match[3]),
+				Severity: heading[0], Message: heading[1],
+			})
+			heading = nil
+		}
+	}
+	return annotations
+}
+
+func parseCargoJSON(line string) (annotation, bool) {
+	var message struct {
+		Reason  string `json:"reason"`
+		Message struct {
+			Level   string `json:"level"`
+			Message string `json:"message"`
+			Spans   []struct {
+				FileName    string `json:"file_name"`
+				LineStart   int    `json:"line_start"`
+				ColumnStart int    `json:"column_start"`
+				IsPrimary   bool   `json:"is_primary"`
+			} `json:"spans"`
+		} `json:"message"`
+	}
+	if json.Unmarshal([]byte(line), &message) != nil || message.Reason != "compiler-message" {
+		return annotation{}, false
+	}
+	if level := message.Message.Level; level != "error" && level != "warning" {
+		return annotation{}, false
+	}
+	for _, span := range message.Message.Spans {
+		if span.IsPrimary {
+			return annotation{
+				File: span.FileName, Line: span.LineStart, Column: span.ColumnStart,
+				Severity: message.Message.Level, Message: message.Message.Message,
+			}, true
+		}
+	}
+	return annotation{}, false
+}
+
+// relativePath makes absolute paths under dir relative to it, as
+// annotations need paths in the repository.
+func relativePath(path, dir string) string {
+	path = filepath.ToSlash(path)
+	if filepath.IsAbs(path) && dir != "" {
+		if rel, err := filepath.Rel(dir, path); err == nil && !strings.HasPrefix(rel, "..") {
+			return filepath.ToSlash(rel)
+		}
+	}
+	return strings.TrimPrefix(path, "./")
+}
+
+// parseAnnotations runs the parsers for formats over output, dropping
+// duplicates, such as the same problem found by two formats. Paths are made
+// relative to dir.
+func parseAnnotations(formats []string, output, dir string) []annotation {
+	var annotations []annotation
+	seen := map[string]bool{}
+	for _, format := range formats {
+		for _, a := range annotationParsers[format](output) {
+			a.File = relativePath(a.File, dir)
+			key := fmt.Sprintf("%s:%d:%d:%s", a.File, a.Line, a.Column, a.Message)
+			if !seen[key] {
+				seen[key] = true
+				annotations = append(annotations, a)
+			}
+		}
+	}
+	return annotations
+}
+
+// collectAnnotations parses the command's captured output and the
+// -annotate-file with the -annotate-format parsers. The captured output is
+// already masked; the file is masked with secrets here.
+func collectAnnotations(flags Flags, result *commandResult, secrets []string) ([]annotation, error) {
+	formats, err := parseAnnotationFormats(flags.AnnotateFormats)
+	if err != nil || len(formats) == 0 {
+		return nil, err
+	}
+	output := result.Output.String()
+	if flags.AnnotateFile != "" {
+		contents, err := ioutil.ReadFile(flags.AnnotateFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading -annotate-file: %s", err)
+		}
+		output += "\n" + maskText(string(contents), secrets)
+	}
+	dir := os.Getenv("GITHUB_WORKSPACE")
+	if dir == "" {
+		dir, _ = os.Getwd()
+	}
+	return parseAnnotations(formats, output, dir), nil
+}
+
+// escapeWorkflowData escapes s for a GitHub Actions workflow command; values
+// of properties also need : and , escaped.
+func escapeWorkflowData(s string, property bool) string {
+	s = strings.NewReplacer("%", "%25", "\r", "%0D", "\n", "%0A").Replace(s)
+	if property {
+		s = strings.NewReplacer(":", "%3A", ",", "%2C").Replace(s)
+	}
+	return s
+}
+
+// writeWorkflowAnnotations prints annotations as GitHub Actions workflow
+// commands, which show them on the run and the pull request's diff.
+func writeWorkflowAnnotations(out io.Writer, annotations []annotation) {
+	w := bufio.NewWriter(out)
+	defer w.Flush()
+	for _, a := range annotations {
+		properties := []string{"file=" + escapeWorkflowData(a.File, true)}
+		if a.Line > 0 {
+			properties = append(properties, "line="+strconv.Itoa(a.Line))
+		}
+		if a.Column > 0 {
+			properties = append(properties, "col="+strconv.Itoa(a.Column))
+		}
+		fmt.Fprintf(w, "::%s %s::%s\n", a.Severity, strings.Join(properties, ","), escapeWorkflowData(a.Message, false))
+	}
+}
+
+// reportAnnotations collects the annotations for the run and, on GitHub
+// Actions, prints them as workflow commands. Problems collecting them are
+// only warnings.
+func reportAnnotations(flags Flags, result *commandResult, secrets []string) []annotation {
+	annotations, err := collectAnnotations(flags, result, secrets)
+	if err != nil {
+		logger.Warnf("%s", err)
+	}
+	if len(flags.AnnotateFormats) > 0 {
+		logger.Infof("Found %d annotations", len(annotations))
+	}
+	if os.Getenv("GITHUB_ACTIONS") == "true" {
+		writeWorkflowAnnotations(os.Stdout, annotations)
+	}
+	return annotations
+}
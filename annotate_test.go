@@ -0,0 +1,139 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func annotationFixture(t *testing.T, name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join("testdata", "annotate", name))
+	if err != nil {
+		t.Fatalf("Error reading fixture: %s", err)
+	}
+	return string(contents)
+}
+
+func TestAnnotationParsers(t *testing.T) {
+	const workspace = "/home/runner/work/app/app"
+	for _, test := range []struct {
+		format   string
+		fixture  string
+		expected []annotation
+	}{
+		{"go-build", "go-build.txt", []annotation{
+			{File: "main.go", Line: 6, Column: 6, Severity: "error", Message: "declared and not used: x"},
+			{File: "main.go", Line: 6, Column: 14, Severity: "error", Message: `cannot use "hello" (untyped string constant) as int value in variable declaration`},
+			{File: "main.go", Line: 7, Column: 14, Severity: "error", Message: "undefined: y"},
+		}},
+		{"go-vet", "go-vet.txt", []annotation{
+			{File: "main.go", Line: 8, Column: 2, Severity: "warning", Message: "self-assignment of x"},
+			{File: "main.go", Line: 6, Column: 14, Severity: "warning", Message: `fmt.Printf format %d has arg "hello" of wrong type string`},
+		}},
+		{"eslint-stylish", "eslint-stylish.txt", []annotation{
+			{File: "src/app.js", Line: 1, Column: 10, Severity: "error", Message: "'fs' is defined but never used (no-unused-vars)"},
+			{File: "src/app.js", Line: 12, Column: 5, Severity: "warning", Message: "Unexpected console statement (no-console)"},
+			{File: "src/util.js", Line: 3, Column: 1, Severity: "error", Message: "Parsing error: Unexpected token }"},
+		}},
+		{"eslint-json", "eslint.json", []annotation{
+			{File: "src/app.js", Line: 1, Column: 10, Severity: "error", Message: "'fs' is defined but never used. (no-unused-vars)"},
+			{File: "src/app.js", Line: 12, Column: 5, Severity: "warning", Message: "Unexpected console statement. (no-console)"},
+		}},
+		{"tsc", "tsc.txt", []annotation{
+			{File: "src/index.ts", Line: 3, Column: 7, Severity: "error", Message: "TS2322: Type 'string' is not assignable to type 'number'."},
+			{File: "src/index.ts", Line: 8, Column: 1, Severity: "error", Message: "TS2304: Cannot find name 'foo'."},
+		}},
+		{"tsc", "tsc-pretty.txt", []annotation{
+			{File: "src/index.ts", Line: 3, Column: 7, Severity: "error", Message: "TS2322: Type 'string' is not assignable to type 'number'."},
+			{File: "src/index.ts", Line: 8, Column: 1, Severity: "error", Message: "TS2304: Cannot find name 'foo'."},
+		}},
+		{"pytest", "pytest.txt", []annotation{
+			{File: "tests/test_math.py", Line: 9, Severity: "error", Message: "test_divide failed: assert 2.0 == 3"},
+			{File: "app/math.py", Line: 6, Severity: "error", Message: "test_overflow failed: OverflowError: (34, 'Numerical result out of range')"},
+		}},
+		{"cargo", "cargo.txt", []annotation{
+			{File: "src/main.rs", Line: 2, Column: 9, Severity: "warning", Message: "unused variable: `unused`"},
+			{File: "src/main.rs", Line: 3, Column: 20, Severity: "error", Message: "cannot find value `missing` in this scope"},
+		}},
+		{"cargo", "cargo.json", []annotation{
+			{File: "src/main.rs", Line: 3, Column: 20, Severity: "error", Message: "cannot find value `missing` in this scope"},
+		}},
+	} {
+		got := parseAnnotations([]string{test.format}, annotationFixture(t, test.fixture), workspace)
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("Unexpected %s annotations for %s:\n%+v\nexpected:\n%+v", test.format, test.fixture, got, test.expected)
+		}
+	}
+}
+
+func TestParseAnnotationsCombinesAndDeduplicates(t *testing.T) {
+	output := annotationFixture(t, "go-vet.txt") + annotationFixture(t, "tsc.txt")
+	annotations := parseAnnotations([]string{"go-vet", "go-build", "tsc"}, output, "")
+	if len(annotations) != 4 {
+		t.Fatalf("Expected each problem once, got %+v", annotations)
+	}
+	if annotations[0].Severity != "warning" || annotations[3].File != "src/index.ts" {
+		t.Errorf("Expected the first format to win and the others to follow, got %+v", annotations)
+	}
+}
+
+func TestParseAnnotationFormats(t *testing.T) {
+	formats, err := parseAnnotationFormats([]string{"go-build, go-vet", "cargo"})
+	if err != nil || strings.Join(formats, " ") != "go-build go-vet cargo" {
+		t.Errorf("Expected the comma separated and repeated formats, got %q, %v", formats, err)
+	}
+	if _, err := parseAnnotationFormats([]string{"go-build,gcc"}); err == nil || !strings.Contains(err.Error(), `unknown -annotate-format "gcc"`) {
+		t.Errorf("Expected an unknown format to be rejected, got %v", err)
+	}
+}
+
+func TestCollectAnnotationsReadsAnnotateFile(t *testing.T) {
+	flags := defaultFlags()
+	flags.AnnotateFormats = []string{"eslint-json", "go-build"}
+	flags.AnnotateFile = filepath.Join("testdata", "annotate", "eslint.json")
+	result := outputResult("./main.go:7:14: undefined: y\n", nil)
+
+	annotations, err := collectAnnotations(*flags, result, nil)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(annotations) != 3 || annotations[2].Message != "undefined: y" {
+		t.Errorf("Expected the file's and the output's annotations, got %+v", annotations)
+	}
+
+	flags.AnnotateFile = filepath.Join(t.TempDir(), "missing.json")
+	if _, err := collectAnnotations(*flags, result, nil); err == nil {
+		t.Error("Expected an error for a missing -annotate-file")
+	}
+}
+
+func TestCollectAnnotationsMasksAnnotateFile(t *testing.T) {
+	flags := defaultFlags()
+	flags.AnnotateFormats = []string{"go-build"}
+	flags.AnnotateFile = writeTempFile(t, "build.log", "./main.go:7:14: bad token hunter2secret\n")
+	result := outputResult("", nil)
+
+	annotations, err := collectAnnotations(*flags, result, []string{"hunter2secret"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(annotations) != 1 || annotations[0].Message != "bad token ***" {
+		t.Errorf("Expected the secret to be masked, got %+v", annotations)
+	}
+}
+
+func TestWriteWorkflowAnnotations(t *testing.T) {
+	var out bytes.Buffer
+	writeWorkflowAnnotations(&out, []annotation{
+		{File: "src/a,b.ts", Line: 3, Column: 7, Severity: "error", Message: "100% broken\nsee above"},
+		{File: "tests/test_math.py", Severity: "warning", Message: "flaky"},
+	})
+	expected := "::error file=src/a%2Cb.ts,line=3,col=7::100%25 broken%0Asee above\n" +
+		"::warning file=tests/test_math.py::flaky\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"sync"
+)
+
+// apiCalls counts the requests this process has sent to the Github API, for
+// -max-api-calls.
+var apiCalls = struct {
+	sync.Mutex
+	sent      int
+	exhausted bool
+}{}
+
+// budgetTransport refuses to send more than max requests over the lifetime
+// of the process. Every request attempt counts, retries included.
+type budgetTransport struct {
+	next http.RoundTripper
+	max  int
+}
+
+func (b *budgetTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	apiCalls.Lock()
+	if apiCalls.sent >= b.max {
+		first := !apiCalls.exhausted
+		apiCalls.exhausted = true
+		apiCalls.Unlock()
+		if first {
+			logger.Errorf("Used up the -max-api-calls budget of %d API calls, not sending any more requests", b.max)
+		}
+		logger.Debugf("Not sending %s %s: -max-api-calls budget used up", req.Method, redactURL(req.URL.String()))
+		return nil, fmt.Errorf("-max-api-calls budget of %d API calls is used up", b.max)
+	}
+	apiCalls.sent++
+	apiCalls.Unlock()
+	return b.next.RoundTrip(req)
+}
+
+// apiBudgetExhausted reports whether a request has been refused for going
+// over -max-api-calls.
+func apiBudgetExhausted() bool {
+	apiCalls.Lock()
+	defer apiCalls.Unlock()
+	return apiCalls.exhausted
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+// withAPIBudget resets the API call count for the test.
+func withAPIBudget(t *testing.T) {
+	reset := func() {
+		apiCalls.Lock()
+		apiCalls.sent, apiCalls.exhausted = 0, false
+		apiCalls.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestMaxAPICallsStopsPolling(t *testing.T) {
+	withAPIBudget(t)
+	out := withLogger(t, logInfo)
+	var received int32
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.Write([]byte(`{"state": "pending", "statuses": []}`))
+	})()
+
+	flags := defaultFlags()
+	flags.MaxAPICalls = 3
+	target := statusTarget{OrgRepo: flags.OrgRepo, SHA: flags.SHA}
+	polls := 0
+	var err error
+	for ; polls < 100; polls++ {
+		if _, err = getCombinedStatus(target, *flags); err != nil {
+			break
+		}
+	}
+	if polls != 3 || received != 3 {
+		t.Errorf("Expected polling to stop after 3 requests, got %d polls and %d requests", polls, received)
+	}
+	if err == nil || !strings.Contains(err.Error(), "-max-api-calls budget of 3 API calls is used up") {
+		t.Errorf("Expected the budget error, got %v", err)
+	}
+	if strings.Count(out.String(), "Used up the -max-api-calls budget") != 1 {
+		t.Errorf("Expected the budget to be logged once, got:\n%s", out)
+	}
+}
+
+func TestMaxAPICallsCountsRetries(t *testing.T) {
+	withAPIBudget(t)
+	withLogger(t, logError)
+	original := retryBackoff
+	retryBackoff = 0
+	defer func() { retryBackoff = original }()
+	var received int32
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&received, 1)
+		w.WriteHeader(http.StatusBadGateway)
+	})()
+
+	flags := defaultFlags()
+	flags.MaxAPICalls, flags.Retries = 2, 5
+	if _, err := githubRequest("GET", githubAPIURL+"/", *flags, nil); err == nil {
+		t.Errorf("Expected the retries to run into the budget")
+	}
+	if received != 2 {
+		t.Errorf("Expected 2 requests, got %d", received)
+	}
+}
+
+func TestMaxAPICallsSoft(t *testing.T) {
+	withAPIBudget(t)
+	withLogger(t, logError)
+	targets := []statusTarget{{OrgRepo: "org/repo", SHA: "deadbeef"}}
+	errs := multiError{errors.New("Error executing request to Github: -max-api-calls budget of 1 API calls is used up")}
+
+	flags := defaultFlags()
+	flags.MaxAPICalls, flags.MaxAPICallsSoft = 1, true
+	if err := tolerateFailures(errs, targets, *flags, "success"); err == nil {
+		t.Errorf("Expected failures before the budget ran out to fail the run")
+	}
+	apiCalls.Lock()
+	apiCalls.exhausted = true
+	apiCalls.Unlock()
+	if err := tolerateFailures(errs, targets, *flags, "success"); err != nil {
+		t.Errorf("Expected -max-api-calls-soft to tolerate the failure, got %s", err)
+	}
+	flags.MaxAPICallsSoft = false
+	if err := tolerateFailures(errs, targets, *flags, "success"); err == nil {
+		t.Errorf("Expected the used up budget to fail the run without -max-api-calls-soft")
+	}
+}
+
+func TestValidateMaxAPICalls(t *testing.T) {
+	flags := defaultFlags()
+	flags.MaxAPICalls = -1
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-max-api-calls must not be negative") {
+		t.Errorf("Expected a negative budget to be rejected, got %v", err)
+	}
+	flags.MaxAPICalls, flags.MaxAPICallsSoft = 0, true
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-max-api-calls-soft requires -max-api-calls") {
+		t.Errorf("Expected -max-api-calls-soft alone to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,112 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// maxErrorBody bounds how much of an error response is read. A misbehaving
+// proxy can answer with an arbitrarily large page.
+const maxErrorBody = 64 << 10
+
+// defaultMaxBodyLogBytes is the default of -max-body-log-bytes.
+const defaultMaxBodyLogBytes = 2 << 10
+
+// defaultResponseBodyLimit is the default of -response-body-limit.
+const defaultResponseBodyLimit = 1 << 20
+
+// apiResponse is a response from the Github API.
+type apiResponse struct {
+	Method     string
+	URL        string
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	// Truncated is set when the body was longer than the limit it was read
+	// with, and Body holds only its start.
+	Truncated bool
+}
+
+// readAPIResponse reads resp, reading at most limit bytes of the body, or
+// all of it if limit is 0. Error responses are bounded by maxErrorBody too.
+func readAPIResponse(method, url string, resp *http.Response, limit int) (*apiResponse, error) {
+	response := &apiResponse{Method: method, URL: url, StatusCode: resp.StatusCode, Header: resp.Header}
+	if !response.ok() && (limit == 0 || limit > maxErrorBody) {
+		limit = maxErrorBody
+	}
+	var reader io.Reader = resp.Body
+	if limit > 0 {
+		reader = io.LimitReader(resp.Body, int64(limit)+1)
+	}
+	body, err := ioutil.ReadAll(reader)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading response body: %s", err)
+	}
+	if limit > 0 && len(body) > limit {
+		body, response.Truncated = body[:limit], true
+		if response.ok() {
+			logger.Warnf("%s %s responded with more than %d bytes, reading only the first %d (truncated)", method, redactURL(url), limit, limit)
+		}
+	}
+	response.Body = body
+	return response, nil
+}
+
+func (r *apiResponse) ok() bool {
+	return r.StatusCode >= 200 && r.StatusCode < 300
+}
+
+// error returns an *APIError for the response, described by message or, when
+// it is empty, by the request and status code.
+func (r *apiResponse) error(message string) *APIError {
+	if message == "" {
+		message = fmt.Sprintf("Error: %s %s responded with %d", r.Method, r.URL, r.StatusCode)
+	}
+	return &APIError{
+		Message:       message,
+		StatusCode:    r.StatusCode,
+		RequestID:     r.Header.Get("X-Github-Request-Id"),
+		RateLimitUsed: r.Header.Get("X-Ratelimit-Used"),
+		Body:          r.Body,
+		Truncated:     r.Truncated,
+	}
+}
+
+// APIError is an unsuccessful response from the Github API. The request ID is
+// what Github support asks for when investigating a failure.
+type APIError struct {
+	Message       string
+	StatusCode    int
+	RequestID     string
+	RateLimitUsed string
+	Body          []byte
+	Truncated     bool
+	// LogLimit is how much of Body the message shows, all of it if 0.
+	LogLimit int
+}
+
+func (e *APIError) Error() string {
+	message := e.Message
+	var details []string
+	if e.RequestID != "" {
+		details = append(details, "request ID "+e.RequestID)
+	}
+	if e.RateLimitUsed != "" {
+		details = append(details, "rate limit used "+e.RateLimitUsed)
+	}
+	if len(details) > 0 {
+		message += " (" + strings.Join(details, ", ") + ")"
+	}
+	if e.LogLimit > 0 && len(e.Body) > e.LogLimit {
+		message += ".\n" + string(e.Body[:e.LogLimit]) + fmt.Sprintf("\n(truncated %d bytes)", len(e.Body)-e.LogLimit)
+	} else {
+		message += ".\n" + string(e.Body)
+	}
+	if e.Truncated {
+		message += fmt.Sprintf("\n(response truncated to %d bytes)", len(e.Body))
+	}
+	return message
+}
@@ -0,0 +1,134 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestAPIErrorIncludesRequestID(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-GitHub-Request-Id", "CAFE:1234:5678")
+		w.Header().Set("X-RateLimit-Used", "42")
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message":"Validation Failed"}`))
+	})()
+
+	err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *defaultFlags(), "pending")
+	expected := "Error creating commit status on Github (request ID CAFE:1234:5678, rate limit used 42).\n{\"message\":\"Validation Failed\"}"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected error %q, got %v", expected, err)
+	}
+
+	err = getGithubJSON(githubAPIURL+"/repos/org/repo", *defaultFlags(), nil)
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected an *APIError, got %v", err)
+	}
+	if apiErr.StatusCode != http.StatusUnprocessableEntity || apiErr.RequestID != "CAFE:1234:5678" || apiErr.RateLimitUsed != "42" {
+		t.Errorf("Expected the status and headers to be captured, got %+v", apiErr)
+	}
+}
+
+func TestAPIErrorBoundsBody(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(strings.Repeat("x", 4*maxErrorBody)))
+	})()
+
+	err := getGithubJSON(githubAPIURL+"/repos/org/repo", *defaultFlags(), nil)
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected an *APIError, got %v", err)
+	}
+	if len(apiErr.Body) != maxErrorBody || !apiErr.Truncated {
+		t.Errorf("Expected the body to be truncated to %d bytes, got %d", maxErrorBody, len(apiErr.Body))
+	}
+	if !strings.HasSuffix(err.Error(), "(response truncated to 65536 bytes)") {
+		t.Errorf("Expected a truncation note, got %q", err.Error()[len(err.Error())-60:])
+	}
+}
+
+func TestSuccessfulResponsesAreNotTruncated(t *testing.T) {
+	large := `{"state":"` + strings.Repeat("x", 2*maxErrorBody) + `"}`
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(large))
+	})()
+
+	var status combinedStatus
+	if err := getGithubJSON(githubAPIURL+"/repos/org/repo", *defaultFlags(), &status); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(status.State) != 2*maxErrorBody {
+		t.Errorf("Expected the full response to be read, got %d bytes", len(status.State))
+	}
+}
+
+func TestResponseBodyLimit(t *testing.T) {
+	out := withLogger(t, logWarn)
+	body := `{"state":"` + strings.Repeat("x", 3<<20) + `"}`
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(body))
+	})()
+
+	flags := defaultFlags()
+	flags.ResponseBodyLimit = defaultResponseBodyLimit
+	if err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "success"); err != nil {
+		t.Errorf("Expected an oversized response to a created status to be tolerated, got %s", err)
+	}
+	if !strings.Contains(out.String(), "reading only the first 1048576 (truncated)") {
+		t.Errorf("Expected the truncation to be logged, got:\n%s", out)
+	}
+
+	var status combinedStatus
+	err := getGithubJSON(githubAPIURL+"/repos/org/repo/commits/deadbeef/status", *flags, &status)
+	if err == nil || !strings.Contains(err.Error(), "(truncated to -response-body-limit 1048576 bytes)") {
+		t.Errorf("Expected a truncated response to fail to parse, got %v", err)
+	}
+}
+
+func TestResponseBodyLimitBoundsErrors(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte("<html>" + strings.Repeat("x", maxErrorBody)))
+	})()
+
+	flags := defaultFlags()
+	flags.ResponseBodyLimit = 16
+	err := getGithubJSON(githubAPIURL+"/repos/org/repo", *flags, nil)
+	if apiErr, ok := err.(*APIError); !ok || string(apiErr.Body) != "<html>xxxxxxxxxx" || !strings.HasSuffix(err.Error(), "(response truncated to 16 bytes)") {
+		t.Errorf("Expected the error body to be cut at the limit, got %v", err)
+	}
+}
+
+func TestMaxBodyLogBytesShortensErrors(t *testing.T) {
+	page := "<html>" + strings.Repeat("x", 10000) + "</html>"
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+		w.Write([]byte(page))
+	})()
+
+	flags := defaultFlags()
+	flags.MaxBodyLogBytes = defaultMaxBodyLogBytes
+	err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "pending")
+	apiErr, ok := err.(*APIError)
+	if !ok {
+		t.Fatalf("Expected an *APIError, got %v", err)
+	}
+	if len(apiErr.Body) != len(page) {
+		t.Errorf("Expected the whole body to be kept, got %d bytes", len(apiErr.Body))
+	}
+	expected := fmt.Sprintf("\n(truncated %d bytes)", len(page)-defaultMaxBodyLogBytes)
+	if !strings.HasSuffix(err.Error(), expected) || !strings.Contains(err.Error(), page[:defaultMaxBodyLogBytes]) || strings.Contains(err.Error(), "</html>") {
+		t.Errorf("Expected the error to show %d bytes of the body, got %d bytes ending %q", defaultMaxBodyLogBytes, len(err.Error()), err.Error()[len(err.Error())-40:])
+	}
+
+	flags.MaxBodyLogBytes = 0
+	if err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "pending"); !strings.HasSuffix(err.Error(), "</html>") {
+		t.Errorf("Expected -max-body-log-bytes 0 to show the whole body")
+	}
+}
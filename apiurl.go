@@ -0,0 +1,21 @@
+package main
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+)
+
+// applyAPIURL points every API request at -api-url, e.g. a Github Enterprise
+// Server's https://github.example.com/api/v3. It runs before the token is
+// resolved, since Enterprise Server hosts read GH_ENTERPRISE_TOKEN too.
+func applyAPIURL(flags Flags) error {
+	if flags.APIURL == "" {
+		return nil
+	}
+	if parsed, err := url.Parse(flags.APIURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Error: -api-url %q is not an absolute http or https URL", redactURL(flags.APIURL))
+	}
+	githubAPIURL = strings.TrimSuffix(flags.APIURL, "/")
+	return nil
+}
@@ -0,0 +1,65 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestApplyAPIURL(t *testing.T) {
+	original := githubAPIURL
+	defer func() { githubAPIURL = original }()
+	flags := defaultFlags()
+
+	if err := applyAPIURL(*flags); err != nil || githubAPIURL != original {
+		t.Errorf("Expected the default API URL without -api-url, got %q, %v", githubAPIURL, err)
+	}
+	flags.APIURL = "https://github.example.com/api/v3/"
+	if err := applyAPIURL(*flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if githubAPIURL != "https://github.example.com/api/v3" {
+		t.Errorf("Expected the trailing slash to be dropped, got %q", githubAPIURL)
+	}
+	if url := graphqlURL(); url != "https://github.example.com/api/graphql" {
+		t.Errorf("Expected Enterprise Server's GraphQL endpoint, got %q", url)
+	}
+	if _, host := credentialHost(githubAPIURL); host != "github.example.com" {
+		t.Errorf("Expected the credential helper to get the Enterprise Server host, got %q", host)
+	}
+	env := map[string]string{"GITHUB_TOKEN": "github", "GH_ENTERPRISE_TOKEN": "enterprise"}
+	if auth := resolveAuth("", fakeEnv(env)); auth != "enterprise" {
+		t.Errorf("Expected GH_ENTERPRISE_TOKEN with an Enterprise Server -api-url, got %q", auth)
+	}
+
+	flags.APIURL = "github.example.com/api/v3"
+	if err := applyAPIURL(*flags); err == nil || !strings.Contains(err.Error(), "-api-url") {
+		t.Errorf("Expected a URL without a scheme to be rejected, got %v", err)
+	}
+}
+
+func TestCLIAPIURLFromEnvironment(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, token, _ := r.BasicAuth()
+		mu.Lock()
+		posted = append(posted, r.URL.Path+" "+token)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+
+	out, code := runCLIWithEnv(t, []string{"GITHUB_API_URL=" + server.URL + "/api/v3/", "GH_ENTERPRISE_TOKEN=enterprise"},
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "true")
+	if code != 0 {
+		t.Errorf("Expected the run to succeed, got %d:\n%s", code, out)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if len(posted) != 2 || posted[1] != "/api/v3/repos/org/repo/statuses/deadbeef enterprise" {
+		t.Errorf("Expected the statuses to go to $GITHUB_API_URL with GH_ENTERPRISE_TOKEN, got %q:\n%s", posted, out)
+	}
+}
@@ -0,0 +1,158 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"strings"
+)
+
+// artifact is a named link to something the build produced, such as a
+// coverage report, from -artifact or -artifacts-file.
+type artifact struct {
+	Name string `json:"name"`
+	URL  string `json:"url"`
+}
+
+// markdownEscaper escapes the characters that would turn an artifact's name
+// into something other than plain link text.
+var markdownEscaper = strings.NewReplacer(
+	`\`, `\\`, "`", "\\`", "*", `\*`, "_", `\_`, "[", `\[`, "]", `\]`,
+	"<", `\<`, ">", `\>`, "#", `\#`, "|", `\|`, "!", `\!`, "\n", " ", "\r", " ",
+)
+
+// markdownURLEscaper escapes the characters that would end a Markdown link's
+// URL early.
+var markdownURLEscaper = strings.NewReplacer("(", "%28", ")", "%29", " ", "%20", "<", "%3C", ">", "%3E")
+
+// newArtifact checks that name isn't blank and link is an absolute http or
+// https URL.
+func newArtifact(name, link string) (artifact, error) {
+	name, link = strings.TrimSpace(name), strings.TrimSpace(link)
+	if name == "" {
+		return artifact{}, fmt.Errorf("Error: artifact %q has no name", link)
+	}
+	if parsed, err := url.Parse(link); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return artifact{}, fmt.Errorf("Error: artifact %q has %q, which is not an absolute http or https URL", name, link)
+	}
+	return artifact{Name: name, URL: link}, nil
+}
+
+// parseArtifact parses an -artifact Name=URL value.
+func parseArtifact(value string) (artifact, error) {
+	parts := strings.SplitN(value, "=", 2)
+	if len(parts) != 2 {
+		return artifact{}, fmt.Errorf("Error: invalid -artifact %q, expected Name=URL", value)
+	}
+	return newArtifact(parts[0], parts[1])
+}
+
+// readArtifactsFile reads the -artifacts-file the command wrote: a JSON
+// array of {"name": ..., "url": ...} objects, a JSON object of name to URL,
+// or lines of a name and a URL separated by whitespace. Entries that aren't
+// valid are returned in skipped next to the valid ones; err is for a file
+// that can't be read or parsed at all.
+func readArtifactsFile(path string) (artifacts []artifact, skipped []error, err error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error reading -artifacts-file: %s", err)
+	}
+
+	add := func(name, link string) {
+		if entry, err := newArtifact(name, link); err != nil {
+			skipped = append(skipped, err)
+		} else {
+			artifacts = append(artifacts, entry)
+		}
+	}
+
+	trimmed := bytes.TrimSpace(contents)
+	switch {
+	case bytes.HasPrefix(trimmed, []byte("[")):
+		var entries []artifact
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, nil, fmt.Errorf("Error parsing -artifacts-file %s: %s", path, err)
+		}
+		for _, entry := range entries {
+			add(entry.Name, entry.URL)
+		}
+	case bytes.HasPrefix(trimmed, []byte("{")):
+		var entries map[string]string
+		if err := json.Unmarshal(trimmed, &entries); err != nil {
+			return nil, nil, fmt.Errorf("Error parsing -artifacts-file %s: %s", path, err)
+		}
+		names := make([]string, 0, len(entries))
+		for name := range entries {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			add(name, entries[name])
+		}
+	default:
+		scanner := bufio.NewScanner(bytes.NewReader(contents))
+		for number := 1; scanner.Scan(); number++ {
+			line := strings.TrimSpace(scanner.Text())
+			if line == "" || strings.HasPrefix(line, "#") {
+				continue
+			}
+			// Names may have spaces, URLs can't.
+			i := strings.LastIndexAny(line, " \t")
+			if i < 0 {
+				skipped = append(skipped, fmt.Errorf("Error: line %d is not a name and a URL", number))
+				continue
+			}
+			add(line[:i], line[i+1:])
+		}
+	}
+	return artifacts, skipped, nil
+}
+
+// collectArtifacts returns the -artifact links followed by those in the
+// -artifacts-file. Problems with the file are warnings, so a build that didn't
+// get to write it still reports its status.
+func collectArtifacts(flags Flags) []artifact {
+	var artifacts []artifact
+	for _, value := range flags.Artifacts {
+		// Already validated with the other flags.
+		entry, _ := parseArtifact(value)
+		artifacts = append(artifacts, entry)
+	}
+	if flags.ArtifactsFile != "" {
+		entries, skipped, err := readArtifactsFile(flags.ArtifactsFile)
+		if err != nil {
+			logger.Warnf("could not read the artifact links, posting without them: %s", err)
+		}
+		for _, problem := range skipped {
+			logger.Warnf("skipping an -artifacts-file entry: %s", strings.TrimPrefix(problem.Error(), "Error: "))
+		}
+		artifacts = append(artifacts, entries...)
+	}
+	return artifacts
+}
+
+// renderArtifacts renders artifacts as a Markdown list of links, or "" if
+// there are none.
+func renderArtifacts(artifacts []artifact) string {
+	if len(artifacts) == 0 {
+		return ""
+	}
+	var b strings.Builder
+	b.WriteString("**Artifacts**\n\n")
+	for _, entry := range artifacts {
+		fmt.Fprintf(&b, "- [%s](%s)\n", markdownEscaper.Replace(entry.Name), markdownURLEscaper.Replace(entry.URL))
+	}
+	return b.String()
+}
+
+// applyArtifactTargetURL links the status for state to the first artifact
+// when it would have no target URL otherwise.
+func applyArtifactTargetURL(flags *Flags, state string) {
+	if len(flags.ArtifactLinks) > 0 && statusTargetURL(*flags, state) == "" {
+		flags.TargetUrl = flags.ArtifactLinks[0].URL
+	}
+}
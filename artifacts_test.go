@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseArtifact(t *testing.T) {
+	if entry, err := parseArtifact("Coverage = https://ci.example.com/coverage?run=1"); err != nil || entry != (artifact{"Coverage", "https://ci.example.com/coverage?run=1"}) {
+		t.Errorf("Unexpected artifact %+v, %v", entry, err)
+	}
+	for value, expected := range map[string]string{
+		"Coverage":                    "expected Name=URL",
+		"=https://ci.example.com":     "has no name",
+		"Docs=/preview/index.html":    "not an absolute http or https URL",
+		"Docs=javascript:alert(1)":    "not an absolute http or https URL",
+		"Image=ghcr.io/org/app:1.2.3": "not an absolute http or https URL",
+	} {
+		if _, err := parseArtifact(value); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %q, got %v", expected, value, err)
+		}
+	}
+}
+
+func TestReadArtifactsFile(t *testing.T) {
+	expected := []artifact{{"Coverage report", "https://ci.example.com/coverage"}, {"Docs", "https://docs.example.com/pr/7"}}
+	for name, contents := range map[string]string{
+		"JSON list":   `[{"name": "Coverage report", "url": "https://ci.example.com/coverage"}, {"name": "Docs", "url": "https://docs.example.com/pr/7"}]`,
+		"JSON object": `{"Docs": "https://docs.example.com/pr/7", "Coverage report": "https://ci.example.com/coverage"}`,
+		"lines":       "# written by make\nCoverage report https://ci.example.com/coverage\n\nDocs\thttps://docs.example.com/pr/7\n",
+	} {
+		artifacts, skipped, err := readArtifactsFile(writeTempFile(t, "artifacts", contents))
+		if err != nil || len(skipped) != 0 || !reflect.DeepEqual(artifacts, expected) {
+			t.Errorf("%s: expected %v, got %v, %v, %v", name, expected, artifacts, skipped, err)
+		}
+	}
+}
+
+func TestCollectArtifactsWarnsAboutBadFile(t *testing.T) {
+	out := withLogger(t, logWarn)
+	flags := defaultFlags()
+	flags.Artifacts = stringSlice{"Image=https://ghcr.io/org/app"}
+	flags.ArtifactsFile = writeTempFile(t, "artifacts", "Docs https://docs.example.com\nbroken\nPreview ./preview\n")
+	artifacts := collectArtifacts(*flags)
+	if expected := []artifact{{"Image", "https://ghcr.io/org/app"}, {"Docs", "https://docs.example.com"}}; !reflect.DeepEqual(artifacts, expected) {
+		t.Errorf("Expected the valid entries %v, got %v", expected, artifacts)
+	}
+	if !strings.Contains(out.String(), "skipping an -artifacts-file entry: line 2 is not a name and a URL") || !strings.Contains(out.String(), `artifact "Preview" has "./preview"`) {
+		t.Errorf("Expected the bad entries to be warned about, got:\n%s", out)
+	}
+
+	out.Reset()
+	flags.ArtifactsFile = writeTempFile(t, "artifacts", `[{"name": "Docs"`)
+	if artifacts := collectArtifacts(*flags); len(artifacts) != 1 || !strings.Contains(out.String(), "could not read the artifact links") {
+		t.Errorf("Expected a malformed file to be a warning, got %v and:\n%s", artifacts, out)
+	}
+	out.Reset()
+	flags.ArtifactsFile = "testdata/missing-artifacts.json"
+	if artifacts := collectArtifacts(*flags); len(artifacts) != 1 || !strings.Contains(out.String(), "Error reading -artifacts-file") {
+		t.Errorf("Expected a missing file to be a warning, got %v and:\n%s", artifacts, out)
+	}
+}
+
+func TestRenderArtifacts(t *testing.T) {
+	if list := renderArtifacts(nil); list != "" {
+		t.Errorf("Expected nothing without artifacts, got %q", list)
+	}
+	list := renderArtifacts([]artifact{{"Coverage", "https://ci.example.com/coverage"}, {"[docs]_*preview*", "https://example.com/a (1)"}})
+	expected := "**Artifacts**\n\n- [Coverage](https://ci.example.com/coverage)\n- [\\[docs\\]\\_\\*preview\\*](https://example.com/a%20%281%29)\n"
+	if list != expected {
+		t.Errorf("Expected %q, got %q", expected, list)
+	}
+}
+
+func TestRenderPRCommentArtifacts(t *testing.T) {
+	flags := defaultFlags()
+	flags.ArtifactLinks = []artifact{{"Coverage", "https://ci.example.com/coverage"}}
+	body, err := renderPRComment(statusTarget{"org/repo", "deadbeef"}, *flags, "success", &commandResult{}, 7)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !strings.HasSuffix(body, "| Description | unit test |\n\n**Artifacts**\n\n- [Coverage](https://ci.example.com/coverage)\n") {
+		t.Errorf("Expected the artifacts at the end of the comment, got:\n%s", body)
+	}
+}
+
+func TestApplyArtifactTargetURL(t *testing.T) {
+	flags := defaultFlags()
+	flags.ArtifactLinks = []artifact{{"Coverage", "https://ci.example.com/coverage"}, {"Docs", "https://docs.example.com"}}
+	flags.TargetURLOnFailure = "https://ci.example.com/logs"
+	applyArtifactTargetURL(flags, "failure")
+	if flags.TargetUrl != "" {
+		t.Errorf("Expected the failure URL to be kept, got %q", flags.TargetUrl)
+	}
+	applyArtifactTargetURL(flags, "success")
+	if flags.TargetUrl != "https://ci.example.com/coverage" {
+		t.Errorf("Expected the first artifact as the target URL, got %q", flags.TargetUrl)
+	}
+}
+
+func TestValidateFlagsArtifacts(t *testing.T) {
+	flags := defaultFlags()
+	flags.Artifacts = stringSlice{"Docs=docs.example.com"}
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "not an absolute http or https URL") {
+		t.Errorf("Expected a relative -artifact URL to be rejected, got %v", err)
+	}
+}
+
+func TestCLIReportsArtifacts(t *testing.T) {
+	report := writeTempFile(t, "report.json", "")
+	artifacts := writeTempFile(t, "artifacts", "")
+	out, code := runCLI(t, "-dry-run", "-json-report", report, "-artifact", "Image=https://ghcr.io/org/app", "-artifacts-file", artifacts,
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"sh", "-c", "echo 'Coverage https://ci.example.com/coverage' > "+artifacts)
+	if code != 0 {
+		t.Fatalf("Expected success, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, `"target_url":"https://ghcr.io/org/app"`) {
+		t.Errorf("Expected the first artifact as the target URL, got:\n%s", out)
+	}
+	contents, _ := ioutil.ReadFile(report)
+	var parsed runReport
+	json.Unmarshal(contents, &parsed)
+	if expected := []artifact{{"Image", "https://ghcr.io/org/app"}, {"Coverage", "https://ci.example.com/coverage"}}; !reflect.DeepEqual(parsed.Artifacts, expected) {
+		t.Errorf("Expected %v in the report, got %s", expected, contents)
+	}
+}
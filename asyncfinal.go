@@ -0,0 +1,173 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// asyncFinalEnv names the payload file of a background helper started by
+// -async-final. A process started with it set posts the payload and exits.
+const asyncFinalEnv = "GH_STATUS_REPORTER_ASYNC_FINAL"
+
+// asyncFinalRetries is the least number of times the background helper
+// retries the final post, as nobody waits for it.
+const asyncFinalRetries = 5
+
+// asyncFinalPayload is what the background helper posts.
+type asyncFinalPayload struct {
+	Flags   Flags          `json:"flags"`
+	Targets []statusTarget `json:"targets"`
+	State   string         `json:"state"`
+}
+
+// asyncOutcome is a line of -async-log: the result of posting the final
+// status to one repository.
+type asyncOutcome struct {
+	Time    string `json:"time"`
+	OrgRepo string `json:"org_repo"`
+	SHA     string `json:"sha"`
+	Context string `json:"context"`
+	State   string `json:"state"`
+	Error   string `json:"error,omitempty"`
+}
+
+// asyncLogPath is -async-log, or a file in the temporary directory if it
+// isn't given.
+func asyncLogPath(flags Flags) string {
+	if flags.AsyncLog != "" {
+		return flags.AsyncLog
+	}
+	return filepath.Join(os.TempDir(), "gh-status-reporter-async.log")
+}
+
+// postInBackground hands posting state to targets to a detached copy of this
+// executable and reports whether it was started. On platforms that can't
+// detach a process, for dry runs, and if the helper can't be started, it
+// returns false so the status is posted synchronously instead.
+func postInBackground(targets []statusTarget, flags Flags, state string) bool {
+	if !canDetach || flags.DryRun {
+		logger.Debugf("Posting the final status synchronously")
+		return false
+	}
+	if err := startAsyncFinal(asyncFinalPayload{Flags: flags, Targets: targets, State: state}); err != nil {
+		logger.Warnf("could not post the final status in the background, posting it now: %s", err)
+		return false
+	}
+	logger.Infof("Posting the %s status in the background, the outcome is logged to %s", state, asyncLogPath(flags))
+	return true
+}
+
+// startAsyncFinal writes payload to a private file, as it holds the token,
+// and starts the helper that posts it without waiting for it.
+func startAsyncFinal(payload asyncFinalPayload) error {
+	encoded, err := json.Marshal(payload)
+	if err != nil {
+		return fmt.Errorf("Error converting the final status to json %s.", err)
+	}
+	file, err := ioutil.TempFile("", "gh-status-reporter-async")
+	if err != nil {
+		return fmt.Errorf("Error writing the final status: %s", err)
+	}
+	_, err = file.Write(encoded)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		os.Remove(file.Name())
+		return fmt.Errorf("Error writing the final status: %s", err)
+	}
+
+	executable, err := os.Executable()
+	if err == nil {
+		helper := exec.Command(executable)
+		helper.Env = append(os.Environ(), asyncFinalEnv+"="+file.Name())
+		detachProcess(helper)
+		if err = helper.Start(); err == nil {
+			return helper.Process.Release()
+		}
+	}
+	os.Remove(file.Name())
+	return err
+}
+
+// runAsyncFinal is the background helper: it posts the payload in path,
+// retrying more than a normal run would, and appends the outcome for each
+// repository to -async-log.
+func runAsyncFinal(path string) int {
+	contents, err := ioutil.ReadFile(path)
+	os.Remove(path)
+	if err != nil {
+		return 1
+	}
+	var payload asyncFinalPayload
+	if err := json.Unmarshal(contents, &payload); err != nil {
+		return 1
+	}
+
+	flags := payload.Flags
+	// The helper starts before main applies -api-url, so it does so itself.
+	if err := applyAPIURL(flags); err != nil {
+		return 1
+	}
+	if flags.Retries < asyncFinalRetries {
+		flags.Retries = asyncFinalRetries
+	}
+	if flags.StateFileCleanup {
+		// The run has exited and removed the state file already.
+		flags.StateFile = ""
+	}
+	failed := map[statusTarget]error{}
+	for _, err := range postEachStatus(payload.Targets, flags, payload.State) {
+		if failure, ok := err.(*targetError); ok {
+			failed[failure.Target] = failure.Err
+		}
+	}
+	if len(failed) < len(payload.Targets) {
+		recordState(flags, payload.State)
+	}
+
+	code := 0
+	var lines []string
+	for _, target := range payload.Targets {
+		outcome := asyncOutcome{
+			Time:    now().UTC().Format(time.RFC3339),
+			OrgRepo: target.OrgRepo,
+			SHA:     target.SHA,
+			Context: providerFlags(flags, target.OrgRepo).Context,
+			State:   payload.State,
+		}
+		if err := failed[target]; err != nil {
+			outcome.Error = maskText(err.Error(), []string{flags.Auth})
+			code = 1
+		}
+		encoded, _ := json.Marshal(outcome)
+		lines = append(lines, string(encoded)+"\n")
+	}
+	if err := appendAsyncLog(asyncLogPath(flags), strings.Join(lines, "")); err != nil {
+		return 1
+	}
+	return code
+}
+
+// appendAsyncLog appends lines to the -async-log file in a single write, so
+// concurrent helpers don't interleave their lines.
+func appendAsyncLog(path, lines string) error {
+	file, err := os.OpenFile(path, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0600)
+	if err != nil {
+		return fmt.Errorf("Error opening %s: %s", path, err)
+	}
+	_, err = file.WriteString(lines)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("Error writing %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,150 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// waitForAsyncLog returns the outcomes in path once it has lines, failing the
+// test if the background helper doesn't write any in time.
+func waitForAsyncLog(t *testing.T, path string) []asyncOutcome {
+	deadline := time.Now().Add(10 * time.Second)
+	for time.Now().Before(deadline) {
+		contents, _ := ioutil.ReadFile(path)
+		if strings.HasSuffix(string(contents), "\n") {
+			var outcomes []asyncOutcome
+			for _, line := range strings.Split(strings.TrimSpace(string(contents)), "\n") {
+				var outcome asyncOutcome
+				if err := json.Unmarshal([]byte(line), &outcome); err != nil {
+					t.Fatalf("Error parsing %q: %s", line, err)
+				}
+				outcomes = append(outcomes, outcome)
+			}
+			return outcomes
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+	t.Fatalf("The background helper wrote nothing to %s", path)
+	return nil
+}
+
+func TestRunAsyncFinal(t *testing.T) {
+	withLogger(t, logError)
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.Contains(r.URL.Path, "/broken/") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		posted = append(posted, r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.AsyncLog = filepath.Join(t.TempDir(), "async.log")
+	flags.StateFile = filepath.Join(t.TempDir(), "state.json")
+	payload := asyncFinalPayload{
+		Flags:   *flags,
+		Targets: []statusTarget{{OrgRepo: "org/repo", SHA: "deadbeef"}, {OrgRepo: "org/broken", SHA: "deadbeef"}},
+		State:   "success",
+	}
+	encoded, _ := json.Marshal(payload)
+	path := writeTempFile(t, "payload.json", string(encoded))
+
+	if code := runAsyncFinal(path); code != 1 {
+		t.Errorf("Expected a failed post to exit 1, got %d", code)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("Expected the payload holding the token to be removed, got %v", err)
+	}
+	if len(posted) != 1 || posted[0] != "/repos/org/repo/statuses/deadbeef" {
+		t.Errorf("Unexpected posts %q", posted)
+	}
+	outcomes := waitForAsyncLog(t, flags.AsyncLog)
+	if len(outcomes) != 2 || outcomes[0].Error != "" || outcomes[0].State != "success" || outcomes[1].OrgRepo != "org/broken" || outcomes[1].Error == "" {
+		t.Errorf("Unexpected outcomes %+v", outcomes)
+	}
+	if state := readStateFile(t, flags.StateFile); state.State != "success" {
+		t.Errorf("Expected the posted state to be recorded, got %+v", state)
+	}
+}
+
+func TestRunAsyncFinalAPIURL(t *testing.T) {
+	withLogger(t, logError)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected nothing to be posted to the default API, got %s %s", r.Method, r.URL.Path)
+	})()
+	var posted []string
+	enterprise := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		posted = append(posted, r.Method+" "+r.URL.Path)
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer enterprise.Close()
+
+	flags := defaultFlags()
+	flags.APIURL = enterprise.URL + "/api/v3"
+	flags.AsyncLog = filepath.Join(t.TempDir(), "async.log")
+	encoded, _ := json.Marshal(asyncFinalPayload{Flags: *flags, Targets: []statusTarget{{OrgRepo: "org/repo", SHA: "deadbeef"}}, State: "success"})
+
+	if code := runAsyncFinal(writeTempFile(t, "payload.json", string(encoded))); code != 0 {
+		t.Errorf("Expected the post to succeed, got %d", code)
+	}
+	if len(posted) != 1 || posted[0] != "POST /api/v3/repos/org/repo/statuses/deadbeef" {
+		t.Errorf("Expected the final status to go to -api-url, got %q", posted)
+	}
+}
+
+func TestPostInBackgroundDryRun(t *testing.T) {
+	withLogger(t, logError)
+	flags := defaultFlags()
+	flags.DryRun = true
+	if postInBackground(nil, *flags, "success") {
+		t.Errorf("Expected dry runs to post synchronously so their output is seen")
+	}
+}
+
+func TestValidateAsyncFinal(t *testing.T) {
+	flags := defaultFlags()
+	flags.AsyncFinal, flags.Watch = true, []string{"*.go"}
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-async-final can't be used with -watch") {
+		t.Errorf("Expected -async-final with -watch to be rejected, got %v", err)
+	}
+}
+
+func TestCLIAsyncFinal(t *testing.T) {
+	if !canDetach {
+		t.Skip("the final status is posted synchronously on this platform")
+	}
+	log := filepath.Join(t.TempDir(), "async.log")
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"), "-async-final", "-async-log", log,
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", "exit 3")
+	if code != 1 || !strings.Contains(out, "Posting the failure status in the background") {
+		t.Errorf("Expected the run to exit without waiting for the final post, got %d:\n%s", code, out)
+	}
+	outcomes := waitForAsyncLog(t, log)
+	if len(outcomes) != 1 || outcomes[0].State != "failure" || outcomes[0].Error != "" {
+		t.Errorf("Expected the helper to post the failure, got %+v", outcomes)
+	}
+
+	pendingOnly := filepath.Join(t.TempDir(), "pending.json")
+	contents, _ := ioutil.ReadFile(filepath.Join("testdata", "replay-failure.json"))
+	var interactions []interaction
+	json.Unmarshal(contents, &interactions)
+	encoded, _ := json.Marshal(interactions[:1])
+	ioutil.WriteFile(pendingOnly, encoded, 0644)
+	log = filepath.Join(t.TempDir(), "async.log")
+	if out, code := runCLI(t, "-replay", pendingOnly, "-async-final", "-async-log", log,
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "true"); code != 0 {
+		t.Errorf("Expected a failed background post not to fail the run, got %d:\n%s", code, out)
+	}
+	if outcomes := waitForAsyncLog(t, log); len(outcomes) != 1 || !strings.Contains(outcomes[0].Error, "no recorded response") {
+		t.Errorf("Expected the failed post in the log, got %+v", outcomes)
+	}
+}
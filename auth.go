@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"net/url"
+	"strings"
+)
+
+// tokenSource provides the Github token from a secret store, so it doesn't
+// have to pass through flags or the environment. Each store implements it.
+type tokenSource interface {
+	// String describes the secret for messages.
+	String() string
+	Token() (string, error)
+}
+
+// configuredTokenSource returns the secret store selected by the flags, or
+// nil if there is none.
+func configuredTokenSource(flags Flags) (tokenSource, error) {
+	var sources []tokenSource
+	if flags.VaultPath != "" {
+		sources = append(sources, &vaultSource{flags})
+	}
+	if flags.AWSSecretID != "" || flags.AWSSSMParameter != "" {
+		if flags.AWSSecretID != "" && flags.AWSSSMParameter != "" {
+			return nil, errors.New("Error: -aws-secret-id and -aws-ssm-parameter can't be used together")
+		}
+		source, err := newAWSSecretSource(flags)
+		if err != nil {
+			return nil, err
+		}
+		sources = append(sources, source)
+	}
+	switch len(sources) {
+	case 0:
+		return nil, nil
+	case 1:
+		return sources[0], nil
+	}
+	return nil, errors.New("Error: only one of -vault-path, -aws-secret-id and -aws-ssm-parameter can be used")
+}
+
+// authEnvNames lists the environment variables a token is taken from when
+// neither -a nor its environment variables are set, in order. Github Enterprise Server hosts
+// check GH_ENTERPRISE_TOKEN first, like the gh CLI.
+func authEnvNames(apiURL string) []string {
+	names := []string{"GH_TOKEN", "GITHUB_TOKEN"}
+	if parsed, err := url.Parse(apiURL); err == nil && parsed.Hostname() != "api.github.com" {
+		names = append([]string{"GH_ENTERPRISE_TOKEN"}, names...)
+	}
+	return names
+}
+
+// resolveAuth returns auth, or else the first token found in the
+// authEnvNames variables.
+func resolveAuth(auth string, getenv func(string) string) string {
+	if auth != "" {
+		return auth
+	}
+	for _, name := range authEnvNames(githubAPIURL) {
+		if token := getenv(name); token != "" {
+			logger.Debugf("Using the token from %s", name)
+			return token
+		}
+	}
+	return ""
+}
+
+// authSourcesChecked describes every place resolveAuth looks, for the error
+// when none of them has a token.
+func authSourcesChecked(flags Flags) string {
+	sources := append([]string{"-a", ghsrEnvPrefix + "AUTH", flags.envName("AUTH")}, authEnvNames(githubAPIURL)...)
+	return strings.Join(sources[:len(sources)-1], ", ") + " and " + sources[len(sources)-1]
+}
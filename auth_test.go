@@ -0,0 +1,51 @@
+package main
+
+import "testing"
+
+func fakeEnv(env map[string]string) func(string) string {
+	return func(name string) string { return env[name] }
+}
+
+func TestResolveAuthOrder(t *testing.T) {
+	env := map[string]string{"GH_TOKEN": "gh", "GITHUB_TOKEN": "github", "GH_ENTERPRISE_TOKEN": "enterprise"}
+	if auth := resolveAuth("explicit", fakeEnv(env)); auth != "explicit" {
+		t.Errorf("Expected -a to take precedence, got %q", auth)
+	}
+	logs := withLogger(t, logDebug)
+	if auth := resolveAuth("", fakeEnv(env)); auth != "gh" {
+		t.Errorf("Expected GH_TOKEN before GITHUB_TOKEN, got %q", auth)
+	}
+	if logs.String() != "Debug: Using the token from GH_TOKEN\n" {
+		t.Errorf("Expected the token source at debug level, got %q", logs)
+	}
+	delete(env, "GH_TOKEN")
+	if auth := resolveAuth("", fakeEnv(env)); auth != "github" {
+		t.Errorf("Expected GITHUB_TOKEN, got %q", auth)
+	}
+	if auth := resolveAuth("", fakeEnv(nil)); auth != "" {
+		t.Errorf("Expected no token, got %q", auth)
+	}
+}
+
+func TestResolveAuthEnterprise(t *testing.T) {
+	original := githubAPIURL
+	defer func() { githubAPIURL = original }()
+	env := map[string]string{"GITHUB_TOKEN": "github", "GH_ENTERPRISE_TOKEN": "enterprise"}
+
+	if auth := resolveAuth("", fakeEnv(env)); auth != "github" {
+		t.Errorf("Expected GH_ENTERPRISE_TOKEN to be ignored for github.com, got %q", auth)
+	}
+	githubAPIURL = "https://github.example.com/api/v3"
+	if auth := resolveAuth("", fakeEnv(env)); auth != "enterprise" {
+		t.Errorf("Expected GH_ENTERPRISE_TOKEN for an Enterprise Server host, got %q", auth)
+	}
+}
+
+func TestMissingAuthListsSources(t *testing.T) {
+	flags := defaultFlags()
+	flags.Auth = ""
+	expected := "Error: No auth token or password provided; checked -a, GHSR_AUTH, BUILD_AUTH, GH_TOKEN and GITHUB_TOKEN"
+	if err := validateRequiredFlags(*flags); err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+}
@@ -0,0 +1,393 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// awsEndpoint returns the API endpoint of service in region. Tests point it
+// at a fake server.
+var awsEndpoint = func(service, region string) string {
+	return "https://" + service + "." + region + ".amazonaws.com/"
+}
+
+// imdsURL is the EC2 instance metadata service, and ecsCredentialsURL the
+// ECS task credentials endpoint AWS_CONTAINER_CREDENTIALS_RELATIVE_URI is
+// relative to.
+var (
+	imdsURL           = "http://169.254.169.254"
+	ecsCredentialsURL = "http://169.254.170.2"
+)
+
+// awsCredentials are the keys requests are signed with.
+type awsCredentials struct {
+	AccessKeyID     string
+	SecretAccessKey string
+	SessionToken    string
+}
+
+// iniSection returns the keys of section in an AWS credentials or config
+// file, or nil if the file or section doesn't exist.
+func iniSection(path, section string) map[string]string {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil
+	}
+	defer f.Close()
+
+	var values map[string]string
+	current := ""
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "" || strings.HasPrefix(line, "#") || strings.HasPrefix(line, ";"):
+		case strings.HasPrefix(line, "[") && strings.HasSuffix(line, "]"):
+			current = strings.TrimSpace(line[1 : len(line)-1])
+			if current == section && values == nil {
+				values = map[string]string{}
+			}
+		case current == section:
+			if parts := strings.SplitN(line, "=", 2); len(parts) == 2 {
+				values[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+			}
+		}
+	}
+	return values
+}
+
+func awsProfile(getenv func(string) string) string {
+	if profile := getenv("AWS_PROFILE"); profile != "" {
+		return profile
+	}
+	return "default"
+}
+
+func awsConfigPath(getenv func(string) string, variable, name string) string {
+	if path := getenv(variable); path != "" {
+		return path
+	}
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".aws", name)
+}
+
+// imds reads path from the EC2 instance metadata service with an IMDSv2
+// session token.
+func imds(client *http.Client, path string) (string, error) {
+	req, _ := http.NewRequest("PUT", imdsURL+"/latest/api/token", nil)
+	req.Header.Set("X-aws-ec2-metadata-token-ttl-seconds", "60")
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	token, _ := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("instance metadata token request responded with %d", resp.StatusCode)
+	}
+
+	req, _ = http.NewRequest("GET", imdsURL+path, nil)
+	req.Header.Set("X-aws-ec2-metadata-token", string(token))
+	return readCredentialsEndpoint(client, req)
+}
+
+func readCredentialsEndpoint(client *http.Client, req *http.Request) (string, error) {
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return "", err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", fmt.Errorf("%s responded with %d", req.URL.Path, resp.StatusCode)
+	}
+	return strings.TrimSpace(string(body)), nil
+}
+
+// parseRoleCredentials decodes the credentials the ECS and EC2 metadata
+// endpoints return.
+func parseRoleCredentials(body string) (*awsCredentials, error) {
+	var role struct {
+		AccessKeyId     string
+		SecretAccessKey string
+		Token           string
+	}
+	if err := json.Unmarshal([]byte(body), &role); err != nil || role.AccessKeyId == "" {
+		return nil, fmt.Errorf("unexpected credentials response")
+	}
+	return &awsCredentials{role.AccessKeyId, role.SecretAccessKey, role.Token}, nil
+}
+
+// resolveAWSCredentials follows the standard AWS credential chain:
+// environment variables, the shared credentials file, ECS task credentials
+// and finally the EC2 instance role.
+func resolveAWSCredentials(client *http.Client, getenv func(string) string) (*awsCredentials, error) {
+	if id := getenv("AWS_ACCESS_KEY_ID"); id != "" {
+		logger.Debugf("Using AWS credentials from AWS_ACCESS_KEY_ID")
+		return &awsCredentials{id, getenv("AWS_SECRET_ACCESS_KEY"), getenv("AWS_SESSION_TOKEN")}, nil
+	}
+	profile := awsProfile(getenv)
+	if values := iniSection(awsConfigPath(getenv, "AWS_SHARED_CREDENTIALS_FILE", "credentials"), profile); values["aws_access_key_id"] != "" {
+		logger.Debugf("Using AWS credentials from the %s profile", profile)
+		return &awsCredentials{values["aws_access_key_id"], values["aws_secret_access_key"], values["aws_session_token"]}, nil
+	}
+	if uri := getenv("AWS_CONTAINER_CREDENTIALS_RELATIVE_URI"); uri != "" {
+		logger.Debugf("Using ECS task credentials")
+		req, _ := http.NewRequest("GET", ecsCredentialsURL+uri, nil)
+		body, err := readCredentialsEndpoint(client, req)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading ECS task credentials: %s", err)
+		}
+		return parseRoleCredentials(body)
+	}
+
+	role, err := imds(client, "/latest/meta-data/iam/security-credentials/")
+	if err != nil {
+		return nil, fmt.Errorf("Error: no AWS credentials found in the environment, %s profile or instance metadata", profile)
+	}
+	logger.Debugf("Using the instance role %s", strings.SplitN(role, "\n", 2)[0])
+	body, err := imds(client, "/latest/meta-data/iam/security-credentials/"+strings.SplitN(role, "\n", 2)[0])
+	if err != nil {
+		return nil, fmt.Errorf("Error reading instance role credentials: %s", err)
+	}
+	return parseRoleCredentials(body)
+}
+
+// resolveAWSRegion finds the region the same way the AWS CLI does, falling
+// back to the region of the EC2 instance.
+func resolveAWSRegion(client *http.Client, getenv func(string) string) (string, error) {
+	for _, name := range []string{"AWS_REGION", "AWS_DEFAULT_REGION"} {
+		if region := getenv(name); region != "" {
+			return region, nil
+		}
+	}
+	profile := awsProfile(getenv)
+	section := "profile " + profile
+	if profile == "default" {
+		section = "default"
+	}
+	if region := iniSection(awsConfigPath(getenv, "AWS_CONFIG_FILE", "config"), section)["region"]; region != "" {
+		return region, nil
+	}
+	region, err := imds(client, "/latest/meta-data/placement/region")
+	if err != nil {
+		return "", fmt.Errorf("Error: no AWS region set; set AWS_REGION")
+	}
+	return region, nil
+}
+
+func sha256Hex(data []byte) string {
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+func hmacSHA256(key []byte, data string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(data))
+	return mac.Sum(nil)
+}
+
+// signAWSRequest adds a Signature Version 4 Authorization header to req,
+// signing every header already set plus Host and X-Amz-Date.
+func signAWSRequest(req *http.Request, body []byte, credentials *awsCredentials, region, service string, at time.Time) {
+	amzDate := at.UTC().Format("20060102T150405Z")
+	date := amzDate[:8]
+	req.Header.Set("X-Amz-Date", amzDate)
+	if credentials.SessionToken != "" {
+		req.Header.Set("X-Amz-Security-Token", credentials.SessionToken)
+	}
+
+	headers := map[string]string{"host": req.URL.Host}
+	for name, values := range req.Header {
+		headers[strings.ToLower(name)] = strings.TrimSpace(strings.Join(values, ","))
+	}
+	names := make([]string, 0, len(headers))
+	for name := range headers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var canonicalHeaders strings.Builder
+	for _, name := range names {
+		canonicalHeaders.WriteString(name + ":" + headers[name] + "\n")
+	}
+	signedHeaders := strings.Join(names, ";")
+
+	path := req.URL.EscapedPath()
+	if path == "" {
+		path = "/"
+	}
+	query := req.URL.Query()
+	keys := make([]string, 0, len(query))
+	for key := range query {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	var canonicalQuery []string
+	for _, key := range keys {
+		for _, value := range query[key] {
+			canonicalQuery = append(canonicalQuery, awsEscape(key)+"="+awsEscape(value))
+		}
+	}
+
+	canonicalRequest := strings.Join([]string{
+		req.Method, path, strings.Join(canonicalQuery, "&"), canonicalHeaders.String(), signedHeaders, sha256Hex(body),
+	}, "\n")
+	scope := date + "/" + region + "/" + service + "/aws4_request"
+	stringToSign := "AWS4-HMAC-SHA256\n" + amzDate + "\n" + scope + "\n" + sha256Hex([]byte(canonicalRequest))
+
+	key := hmacSHA256([]byte("AWS4"+credentials.SecretAccessKey), date)
+	for _, part := range []string{region, service, "aws4_request"} {
+		key = hmacSHA256(key, part)
+	}
+	signature := hex.EncodeToString(hmacSHA256(key, stringToSign))
+	req.Header.Set("Authorization", fmt.Sprintf("AWS4-HMAC-SHA256 Credential=%s/%s, SignedHeaders=%s, Signature=%s",
+		credentials.AccessKeyID, scope, signedHeaders, signature))
+}
+
+// awsEscape percent-encodes s as SigV4 requires: everything but unreserved
+// characters.
+func awsEscape(s string) string {
+	var escaped strings.Builder
+	for _, b := range []byte(s) {
+		if 'A' <= b && b <= 'Z' || 'a' <= b && b <= 'z' || '0' <= b && b <= '9' || strings.IndexByte("-_.~", b) >= 0 {
+			escaped.WriteByte(b)
+		} else {
+			fmt.Fprintf(&escaped, "%%%02X", b)
+		}
+	}
+	return escaped.String()
+}
+
+// awsSecretSource reads the Github token from Secrets Manager, or from SSM
+// Parameter Store when Parameter is set.
+type awsSecretSource struct {
+	SecretID  string
+	Parameter string
+	// Key selects a field when the secret is a JSON object.
+	Key string
+
+	client *http.Client
+	// metadata is used for the instance metadata and ECS endpoints, with a
+	// short timeout so running outside AWS fails fast.
+	metadata *http.Client
+	getenv   func(string) string
+}
+
+func newAWSSecretSource(flags Flags) (*awsSecretSource, error) {
+	transport, err := baseTransport(flags)
+	if err != nil {
+		return nil, err
+	}
+	return &awsSecretSource{
+		SecretID:  flags.AWSSecretID,
+		Parameter: flags.AWSSSMParameter,
+		Key:       flags.AWSSecretKey,
+		client:    &http.Client{Transport: transport, Timeout: flags.HTTPTimeout},
+		metadata:  &http.Client{Timeout: 2 * time.Second},
+		getenv:    os.Getenv,
+	}, nil
+}
+
+func (s *awsSecretSource) String() string {
+	if s.Parameter != "" {
+		return "SSM parameter " + s.Parameter
+	}
+	return "AWS secret " + s.SecretID
+}
+
+// call makes a JSON API call to service and decodes the response into v.
+func (s *awsSecretSource) call(service, target string, input interface{}, v interface{}) error {
+	credentials, err := resolveAWSCredentials(s.metadata, s.getenv)
+	if err != nil {
+		return err
+	}
+	region, err := resolveAWSRegion(s.metadata, s.getenv)
+	if err != nil {
+		return err
+	}
+
+	body, _ := json.Marshal(input)
+	req, err := http.NewRequest("POST", awsEndpoint(service, region), bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating request to AWS: %s", err)
+	}
+	req.Header.Set("Content-Type", "application/x-amz-json-1.1")
+	req.Header.Set("X-Amz-Target", target)
+	signAWSRequest(req, body, credentials, region, service, now())
+
+	resp, err := s.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error executing request to AWS: %s", err)
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return fmt.Errorf("Error reading AWS response: %s", err)
+	}
+	if resp.StatusCode == http.StatusOK {
+		return json.Unmarshal(contents, v)
+	}
+
+	var failure struct {
+		Type    string `json:"__type"`
+		Message string `json:"message"`
+	}
+	json.Unmarshal(contents, &failure)
+	errorType := failure.Type[strings.LastIndex(failure.Type, "#")+1:]
+	requestID := resp.Header.Get("X-Amzn-Requestid")
+	switch errorType {
+	case "AccessDeniedException", "UnrecognizedClientException", "InvalidSignatureException", "ExpiredTokenException":
+		return fmt.Errorf("Error: not allowed to read %s in %s (request ID %s): %s", s, region, requestID, failure.Message)
+	case "ResourceNotFoundException", "ParameterNotFound":
+		return fmt.Errorf("Error: %s doesn't exist in %s (request ID %s)", s, region, requestID)
+	}
+	return fmt.Errorf("Error reading %s: AWS responded with %d %s (request ID %s): %s", s, resp.StatusCode, errorType, requestID, failure.Message)
+}
+
+func (s *awsSecretSource) Token() (string, error) {
+	var value string
+	if s.Parameter != "" {
+		var output struct {
+			Parameter struct{ Value string }
+		}
+		if err := s.call("ssm", "AmazonSSM.GetParameter", map[string]interface{}{"Name": s.Parameter, "WithDecryption": true}, &output); err != nil {
+			return "", err
+		}
+		value = output.Parameter.Value
+	} else {
+		var output struct{ SecretString string }
+		if err := s.call("secretsmanager", "secretsmanager.GetSecretValue", map[string]string{"SecretId": s.SecretID}, &output); err != nil {
+			return "", err
+		}
+		value = output.SecretString
+	}
+
+	if s.Key == "" {
+		return strings.TrimSpace(value), nil
+	}
+	var fields map[string]interface{}
+	if err := json.Unmarshal([]byte(value), &fields); err != nil {
+		return "", fmt.Errorf("Error: %s isn't a JSON object, so -aws-secret-key can't select %q", s, s.Key)
+	}
+	token, ok := fields[s.Key].(string)
+	if !ok || token == "" {
+		return "", fmt.Errorf("Error: %s has no %q field", s, s.Key)
+	}
+	return token, nil
+}
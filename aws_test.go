@@ -0,0 +1,158 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSignAWSRequest(t *testing.T) {
+	// The example from the AWS Signature Version 4 documentation.
+	req, _ := http.NewRequest("GET", "https://iam.amazonaws.com/?Action=ListUsers&Version=2010-05-08", nil)
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded; charset=utf-8")
+	credentials := &awsCredentials{AccessKeyID: "AKIDEXAMPLE", SecretAccessKey: "wJalrXUtnFEMI/K7MDENG+bPxRfiCYEXAMPLEKEY"}
+	signAWSRequest(req, nil, credentials, "us-east-1", "iam", time.Date(2015, 8, 30, 12, 36, 0, 0, time.UTC))
+
+	expected := "AWS4-HMAC-SHA256 Credential=AKIDEXAMPLE/20150830/us-east-1/iam/aws4_request, SignedHeaders=content-type;host;x-amz-date, Signature=5d672d79c15b13162d9279b0855cfba6789a8edb4c82c400e06b5924a6f2b5d7"
+	if got := req.Header.Get("Authorization"); got != expected {
+		t.Errorf("Expected %q, got %q", expected, got)
+	}
+}
+
+// withAWS points the AWS endpoints at handler and returns a source using
+// static credentials from the environment.
+func withAWS(t *testing.T, handler http.HandlerFunc) (*awsSecretSource, func()) {
+	ts := httptest.NewServer(handler)
+	original := awsEndpoint
+	awsEndpoint = func(service, region string) string { return ts.URL + "/" + service + "/" + region }
+	env := map[string]string{"AWS_ACCESS_KEY_ID": "AKID", "AWS_SECRET_ACCESS_KEY": "secret", "AWS_REGION": "eu-west-1"}
+	source := &awsSecretSource{client: ts.Client(), metadata: ts.Client(), getenv: fakeEnv(env)}
+	return source, func() {
+		awsEndpoint = original
+		ts.Close()
+	}
+}
+
+func TestAWSSecretsManagerToken(t *testing.T) {
+	source, done := withAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/secretsmanager/eu-west-1" || r.Header.Get("X-Amz-Target") != "secretsmanager.GetSecretValue" {
+			t.Errorf("Unexpected request to %s for %s", r.URL.Path, r.Header.Get("X-Amz-Target"))
+		}
+		if !strings.HasPrefix(r.Header.Get("Authorization"), "AWS4-HMAC-SHA256 Credential=AKID/") {
+			t.Errorf("Expected a signed request, got %q", r.Header.Get("Authorization"))
+		}
+		var input map[string]string
+		json.NewDecoder(r.Body).Decode(&input)
+		fmt.Fprintf(w, `{"SecretString":%q}`, `{"github":"ghp_json","other":"x"}`)
+	})
+	defer done()
+
+	source.SecretID, source.Key = "ci/github", "github"
+	if token, err := source.Token(); err != nil || token != "ghp_json" {
+		t.Errorf("Expected ghp_json, got %q %v", token, err)
+	}
+	source.Key = "missing"
+	if _, err := source.Token(); err == nil {
+		t.Errorf("Expected an error for a missing JSON field")
+	}
+}
+
+func TestAWSSSMParameterToken(t *testing.T) {
+	source, done := withAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		var input map[string]interface{}
+		json.NewDecoder(r.Body).Decode(&input)
+		if r.Header.Get("X-Amz-Target") != "AmazonSSM.GetParameter" || input["Name"] != "/ci/github" || input["WithDecryption"] != true {
+			t.Errorf("Unexpected SSM request %s %v", r.Header.Get("X-Amz-Target"), input)
+		}
+		fmt.Fprint(w, `{"Parameter":{"Value":"ghp_ssm\n"}}`)
+	})
+	defer done()
+
+	source.Parameter = "/ci/github"
+	if token, err := source.Token(); err != nil || token != "ghp_ssm" {
+		t.Errorf("Expected ghp_ssm, got %q %v", token, err)
+	}
+}
+
+func TestAWSErrorsDistinguishPermissions(t *testing.T) {
+	source, done := withAWS(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("X-Amzn-RequestId", "aws-req-1")
+		w.WriteHeader(http.StatusBadRequest)
+		if strings.Contains(r.URL.Path, "ssm") {
+			fmt.Fprint(w, `{"__type":"ParameterNotFound"}`)
+			return
+		}
+		fmt.Fprint(w, `{"__type":"AccessDeniedException","message":"not authorized to perform secretsmanager:GetSecretValue"}`)
+	})
+	defer done()
+
+	source.SecretID = "ci/github"
+	_, err := source.Token()
+	if err == nil || !strings.HasPrefix(err.Error(), "Error: not allowed to read AWS secret ci/github in eu-west-1 (request ID aws-req-1)") {
+		t.Errorf("Expected a permission error, got %v", err)
+	}
+	source.Parameter = "/ci/github"
+	_, err = source.Token()
+	if err == nil || err.Error() != "Error: SSM parameter /ci/github doesn't exist in eu-west-1 (request ID aws-req-1)" {
+		t.Errorf("Expected a missing parameter error, got %v", err)
+	}
+}
+
+func TestResolveAWSCredentialsFromInstanceRole(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path == "/latest/api/token" {
+			fmt.Fprint(w, "session")
+			return
+		}
+		if r.Header.Get("X-aws-ec2-metadata-token") != "session" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		switch r.URL.Path {
+		case "/latest/meta-data/iam/security-credentials/":
+			fmt.Fprint(w, "ci-role")
+		case "/latest/meta-data/iam/security-credentials/ci-role":
+			fmt.Fprint(w, `{"AccessKeyId":"ASIA","SecretAccessKey":"secret","Token":"session-token"}`)
+		case "/latest/meta-data/placement/region":
+			fmt.Fprint(w, "ap-southeast-2")
+		}
+	}))
+	defer ts.Close()
+	original := imdsURL
+	imdsURL = ts.URL
+	defer func() { imdsURL = original }()
+
+	env := fakeEnv(map[string]string{"AWS_SHARED_CREDENTIALS_FILE": "/nonexistent", "AWS_CONFIG_FILE": "/nonexistent"})
+	credentials, err := resolveAWSCredentials(ts.Client(), env)
+	if err != nil || *credentials != (awsCredentials{"ASIA", "secret", "session-token"}) {
+		t.Errorf("Expected the instance role credentials, got %+v %v", credentials, err)
+	}
+	if region, err := resolveAWSRegion(ts.Client(), env); err != nil || region != "ap-southeast-2" {
+		t.Errorf("Expected the instance region, got %q %v", region, err)
+	}
+}
+
+func TestResolveAWSCredentialsFromSharedFile(t *testing.T) {
+	path := writeTempFile(t, "credentials", "[default]\naws_access_key_id = AKIDDEFAULT\n\n[ci]\naws_access_key_id = AKIDCI\naws_secret_access_key = secret\n")
+	env := fakeEnv(map[string]string{"AWS_SHARED_CREDENTIALS_FILE": path, "AWS_PROFILE": "ci"})
+	credentials, err := resolveAWSCredentials(nil, env)
+	if err != nil || credentials.AccessKeyID != "AKIDCI" || credentials.SecretAccessKey != "secret" {
+		t.Errorf("Expected the ci profile, got %+v %v", credentials, err)
+	}
+}
+
+func TestConfiguredTokenSource(t *testing.T) {
+	if source, err := configuredTokenSource(Flags{}); source != nil || err != nil {
+		t.Errorf("Expected no token source, got %v %v", source, err)
+	}
+	if source, err := configuredTokenSource(Flags{AWSSSMParameter: "/ci/github"}); err != nil || source.String() != "SSM parameter /ci/github" {
+		t.Errorf("Expected the SSM source, got %v %v", source, err)
+	}
+	if _, err := configuredTokenSource(Flags{VaultPath: "secret/github", AWSSecretID: "ci/github"}); err == nil {
+		t.Errorf("Expected an error for two token sources")
+	}
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+	"math"
+	"os"
+)
+
+// verdanaWidths are the advance widths of printable ASCII characters in 11px
+// Verdana, the font badges are rendered with, starting at ' '.
+var verdanaWidths = [...]float64{
+	3.87, 4.33, 5.05, 9.00, 6.99, 11.84, 7.99, 2.95, 4.99, 4.99, 6.99, 9.00, 4.00, 4.99, 4.00, 4.99,
+	6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 6.99, 4.99, 4.99, 9.00, 9.00, 9.00, 5.99,
+	10.99, 7.52, 7.54, 7.68, 8.48, 6.96, 6.32, 8.53, 8.27, 4.63, 5.00, 7.62, 6.12, 9.27, 8.23, 8.66,
+	6.63, 8.66, 7.65, 7.52, 6.78, 8.05, 7.52, 10.88, 7.54, 6.77, 7.54, 4.99, 4.99, 4.99, 9.00, 6.99,
+	6.99, 6.61, 6.85, 5.73, 6.85, 6.55, 3.87, 6.85, 6.96, 3.02, 3.79, 6.51, 3.02, 10.69, 6.96, 6.68,
+	6.85, 6.85, 4.69, 5.73, 4.33, 6.96, 6.51, 8.98, 6.51, 6.51, 5.77, 6.98, 4.99, 6.98, 9.00,
+}
+
+// fallbackWidth is used for characters outside printable ASCII.
+const fallbackWidth = 7.0
+
+// badgePadding is the horizontal space around the text of each badge half.
+const badgePadding = 10
+
+const badgeTemplate = `<svg xmlns="http://www.w3.org/2000/svg" width="%[1]d" height="20" role="img" aria-label="%[4]s: %[5]s">` +
+	`<title>%[4]s: %[5]s</title>` +
+	`<linearGradient id="s" x2="0" y2="100%%"><stop offset="0" stop-color="#bbb" stop-opacity=".1"/><stop offset="1" stop-opacity=".1"/></linearGradient>` +
+	`<clipPath id="r"><rect width="%[1]d" height="20" rx="3" fill="#fff"/></clipPath>` +
+	`<g clip-path="url(#r)"><rect width="%[2]d" height="20" fill="#555"/><rect x="%[2]d" width="%[3]d" height="20" fill="%[6]s"/><rect width="%[1]d" height="20" fill="url(#s)"/></g>` +
+	`<g fill="#fff" text-anchor="middle" font-family="Verdana,Geneva,DejaVu Sans,sans-serif" font-size="11">` +
+	`<text x="%[7]s" y="15" fill="#010101" fill-opacity=".3">%[4]s</text><text x="%[7]s" y="14">%[4]s</text>` +
+	`<text x="%[8]s" y="15" fill="#010101" fill-opacity=".3">%[5]s</text><text x="%[8]s" y="14">%[5]s</text>` +
+	`</g></svg>
+`
+
+// textWidth estimates the rendered width of s in pixels.
+func textWidth(s string) float64 {
+	var width float64
+	for _, r := range s {
+		if i := int(r) - ' '; i >= 0 && i < len(verdanaWidths) {
+			width += verdanaWidths[i]
+		} else {
+			width += fallbackWidth
+		}
+	}
+	return width
+}
+
+// badgeValue returns the text and color shown for state.
+func badgeValue(state string) (string, string) {
+	switch state {
+	case "success":
+		return "passing", "#4c1"
+	case "failure":
+		return "failing", "#e05d44"
+	case "error":
+		return "error", "#e05d44"
+	case "pending":
+		return "pending", "#dfb317"
+	}
+	return state, "#9f9f9f"
+}
+
+func escapeXML(s string) string {
+	var escaped bytes.Buffer
+	xml.EscapeText(&escaped, []byte(s))
+	return escaped.String()
+}
+
+// renderBadge renders a flat badge labelled with context showing state. The
+// output only depends on its arguments.
+func renderBadge(context, state string) []byte {
+	if context == "" {
+		context = defaultContext
+	}
+	value, color := badgeValue(state)
+
+	labelWidth := int(math.Ceil(textWidth(context))) + badgePadding
+	valueWidth := int(math.Ceil(textWidth(value))) + badgePadding
+	labelX := fmt.Sprintf("%.1f", float64(labelWidth)/2)
+	valueX := fmt.Sprintf("%.1f", float64(labelWidth)+float64(valueWidth)/2)
+
+	return []byte(fmt.Sprintf(badgeTemplate, labelWidth+valueWidth, labelWidth, valueWidth,
+		escapeXML(context), escapeXML(value), color, labelX, valueX))
+}
+
+// writeBadge writes the badge for state to path, or to stdout if path is
+// empty.
+func writeBadge(path, context, state string) error {
+	badge := renderBadge(context, state)
+	if path == "" {
+		_, err := os.Stdout.Write(badge)
+		return err
+	}
+	if err := ioutil.WriteFile(path, badge, 0644); err != nil {
+		return fmt.Errorf("Error writing badge %s: %s", path, err)
+	}
+	return nil
+}
+
+// runBadgeCommand implements the badge subcommand: it writes a badge for the
+// context's current state on the SHA, which may also be a branch or tag.
+// Contexts without a status are shown as unknown.
+func runBadgeCommand(flags Flags) error {
+	if flags.OrgRepo == "" {
+		return fmt.Errorf("Error: No Github organization/repository provided")
+	}
+	if flags.SHA == "" {
+		return fmt.Errorf("Error: No SHA provided")
+	}
+
+	current, err := getCombinedStatus(statusTarget{OrgRepo: flags.OrgRepo, SHA: flags.SHA}, flags)
+	if err != nil {
+		return err
+	}
+	state := "unknown"
+	if existing := current.find(flags.Context); existing != nil {
+		state = existing.State
+	}
+	return writeBadge(flags.BadgeFile, flags.Context, state)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+var updateGolden = flag.Bool("update", false, "rewrite golden files in testdata")
+
+func TestRenderBadgeGolden(t *testing.T) {
+	for _, test := range []struct {
+		context, state, golden string
+	}{
+		{"ci", "success", "badge-passing.svg"},
+		{"ci/test", "failure", "badge-failing.svg"},
+		{"lint & <vet>", "pending", "badge-escaped.svg"},
+	} {
+		path := filepath.Join("testdata", test.golden)
+		badge := renderBadge(test.context, test.state)
+		if *updateGolden {
+			if err := ioutil.WriteFile(path, badge, 0644); err != nil {
+				t.Fatal(err)
+			}
+		}
+		expected, err := ioutil.ReadFile(path)
+		if err != nil {
+			t.Fatalf("Error reading golden file: %s", err)
+		}
+		if string(badge) != string(expected) {
+			t.Errorf("Badge for %q %s doesn't match %s:\n%s", test.context, test.state, path, badge)
+		}
+	}
+}
+
+func TestRenderBadgeEscapesContext(t *testing.T) {
+	badge := string(renderBadge(`a<b>&"c"`, "success"))
+	if strings.Contains(badge, "<b>") || !strings.Contains(badge, "a&lt;b&gt;&amp;&#34;c&#34;") {
+		t.Errorf("Expected the context to be escaped, got %s", badge)
+	}
+}
+
+func TestRenderBadgeWidthGrowsWithText(t *testing.T) {
+	if textWidth("ci") >= textWidth("integration") {
+		t.Errorf("Expected longer text to be wider")
+	}
+	if textWidth("WWW") <= textWidth("iii") {
+		t.Errorf("Expected wide characters to measure wider than narrow ones")
+	}
+}
+
+func TestRunBadgeCommand(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprintln(w, `{"state":"failure","statuses":[{"context":"ci","state":"failure"}]}`)
+	})()
+
+	flags := defaultFlags()
+	flags.BadgeFile = filepath.Join(t.TempDir(), "badge.svg")
+	if err := runBadgeCommand(*flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	badge, _ := ioutil.ReadFile(flags.BadgeFile)
+	if string(badge) != string(renderBadge("ci", "failure")) {
+		t.Errorf("Expected a failing badge, got %s", badge)
+	}
+
+	flags.Context = "deploy"
+	runBadgeCommand(*flags)
+	badge, _ = ioutil.ReadFile(flags.BadgeFile)
+	if !strings.Contains(string(badge), ">unknown</text>") {
+		t.Errorf("Expected an unknown badge for a context without status, got %s", badge)
+	}
+}
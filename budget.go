@@ -0,0 +1,54 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// shortDuration formats d like time.Duration.String but without trailing
+// zero units, e.g. "10m" instead of "10m0s".
+func shortDuration(d time.Duration) string {
+	s := d.String()
+	if strings.HasSuffix(s, "m0s") {
+		s = s[:len(s)-2]
+	}
+	if strings.HasSuffix(s, "h0m") {
+		s = s[:len(s)-2]
+	}
+	return s
+}
+
+// budgetDuration is the time measured against -max-duration: the successful
+// attempt, or with -budget-total every attempt.
+func budgetDuration(flags Flags, result *commandResult) time.Duration {
+	if !flags.BudgetTotal || len(result.Attempts) == 0 {
+		return result.Duration
+	}
+	var total float64
+	for _, attempt := range result.Attempts {
+		total += attempt.DurationSeconds
+	}
+	return time.Duration(total * float64(time.Second))
+}
+
+// applyDurationBudget checks a successful command against -max-duration and
+// returns the description to report. Over budget, the result is turned into a
+// failure, or with -max-duration-warn only noted in the description. The
+// command is never stopped early; that is -cmd-timeout's job.
+func applyDurationBudget(flags Flags, result *commandResult) string {
+	duration := budgetDuration(flags, result)
+	if flags.MaxDuration <= 0 || result.Err != nil || duration <= flags.MaxDuration {
+		return flags.Description
+	}
+
+	took := shortDuration(duration.Round(time.Second))
+	budget := shortDuration(flags.MaxDuration)
+	if flags.MaxDurationWarn {
+		return appendSuffix(flags.Description, fmt.Sprintf("warning: exceeded %s budget, took %s", budget, took))
+	}
+	note := fmt.Sprintf("passed but exceeded %s budget (took %s)", budget, took)
+	result.Err = errors.New(note)
+	return note
+}
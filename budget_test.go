@@ -0,0 +1,86 @@
+package main
+
+import (
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestShortDuration(t *testing.T) {
+	for d, expected := range map[time.Duration]string{
+		10 * time.Minute:                "10m",
+		2 * time.Hour:                   "2h",
+		13*time.Minute + 42*time.Second: "13m42s",
+		90 * time.Second:                "1m30s",
+		1500 * time.Millisecond:         "1.5s",
+		time.Hour + 30*time.Second:      "1h0m30s",
+		time.Hour + 5*time.Minute:       "1h5m",
+	} {
+		if got := shortDuration(d); got != expected {
+			t.Errorf("Expected %s to format as %q, got %q", d, expected, got)
+		}
+	}
+}
+
+func TestApplyDurationBudgetFailsSlowSuccess(t *testing.T) {
+	flags := defaultFlags()
+	flags.MaxDuration = 10 * time.Minute
+	result := &commandResult{Duration: 13*time.Minute + 42*time.Second + 300*time.Millisecond}
+
+	description := applyDurationBudget(*flags, result)
+	if description != "passed but exceeded 10m budget (took 13m42s)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if commandState(result) != "failure" {
+		t.Errorf("Expected the run to fail, got %s", commandState(result))
+	}
+}
+
+func TestApplyDurationBudgetWarn(t *testing.T) {
+	flags := defaultFlags()
+	flags.MaxDuration = 10 * time.Minute
+	flags.MaxDurationWarn = true
+	result := &commandResult{Duration: 11 * time.Minute}
+
+	description := applyDurationBudget(*flags, result)
+	if description != "unit test (warning: exceeded 10m budget, took 11m)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if result.Err != nil {
+		t.Errorf("Expected the run to stay successful, got %s", result.Err)
+	}
+}
+
+func TestApplyDurationBudgetLeavesOthersAlone(t *testing.T) {
+	flags := defaultFlags()
+	flags.MaxDuration = 10 * time.Minute
+
+	fast := &commandResult{Duration: 9 * time.Minute}
+	failed := &commandResult{Duration: 20 * time.Minute, Err: errors.New("exit status 1")}
+	for _, result := range []*commandResult{fast, failed} {
+		if description := applyDurationBudget(*flags, result); description != "unit test" {
+			t.Errorf("Expected the description to be unchanged, got %q", description)
+		}
+	}
+	if fast.Err != nil || failed.Err.Error() != "exit status 1" {
+		t.Errorf("Expected the results to be unchanged, got %v and %v", fast.Err, failed.Err)
+	}
+}
+
+func TestApplyDurationBudgetTotal(t *testing.T) {
+	flags := defaultFlags()
+	flags.MaxDuration = 10 * time.Minute
+	result := &commandResult{
+		Duration: 6 * time.Minute,
+		Attempts: []commandAttempt{{ExitCode: 1, DurationSeconds: 300}, {DurationSeconds: 360}},
+	}
+
+	if description := applyDurationBudget(*flags, result); description != "unit test" {
+		t.Errorf("Expected only the successful attempt to count, got %q", description)
+	}
+
+	flags.BudgetTotal = true
+	if description := applyDurationBudget(*flags, result); description != "passed but exceeded 10m budget (took 11m)" {
+		t.Errorf("Expected every attempt to count with -budget-total, got %q", description)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// cachedResponse is a GET response kept for conditional requests, in memory
+// for the rest of the run and in -cache-dir across runs.
+type cachedResponse struct {
+	URL          string `json:"url"`
+	ETag         string `json:"etag"`
+	LastModified string `json:"last_modified,omitempty"`
+	Body         []byte `json:"body"`
+}
+
+// responseCacheKey identifies the response for url. The credentials are part
+// of the key so responses are never shared between tokens with different
+// access.
+func responseCacheKey(flags Flags, url string) string {
+	sum := sha256.Sum256([]byte(flags.Username + "\x00" + flags.Auth + "\x00" + url))
+	return hex.EncodeToString(sum[:])
+}
+
+// responseCachePath returns where the response for url is cached.
+func responseCachePath(flags Flags, url string) string {
+	return filepath.Join(flags.CacheDir, responseCacheKey(flags, url)+".json")
+}
+
+// runCache keeps the validated GET responses of this run, so reading the
+// same status again, as -skip-if-same and -watch do, costs a 304 that
+// doesn't count against the rate limit.
+var runCache = struct {
+	sync.Mutex
+	responses map[string]*cachedResponse
+}{responses: map[string]*cachedResponse{}}
+
+// loadRunCachedResponse returns the response for url cached earlier in this
+// run, or nil.
+func loadRunCachedResponse(flags Flags, url string) *cachedResponse {
+	runCache.Lock()
+	defer runCache.Unlock()
+	return runCache.responses[responseCacheKey(flags, url)]
+}
+
+// storeRunCachedResponse keeps a response that has a validator for the rest
+// of the run.
+func storeRunCachedResponse(flags Flags, cached *cachedResponse) {
+	runCache.Lock()
+	defer runCache.Unlock()
+	runCache.responses[responseCacheKey(flags, cached.URL)] = cached
+}
+
+// loadCachedResponse returns the cached response for url, or nil if there is
+// none or it can't be read.
+func loadCachedResponse(flags Flags, url string) *cachedResponse {
+	contents, err := ioutil.ReadFile(responseCachePath(flags, url))
+	if err != nil {
+		return nil
+	}
+	var cached cachedResponse
+	if json.Unmarshal(contents, &cached) != nil || cached.URL != url || cached.ETag == "" {
+		return nil
+	}
+	return &cached
+}
+
+// storeCachedResponse saves a response with an ETag. The cache is only an
+// optimization, so failures are ignored.
+func storeCachedResponse(flags Flags, cached *cachedResponse) {
+	contents, err := json.Marshal(cached)
+	if err != nil {
+		return
+	}
+	if err := os.MkdirAll(flags.CacheDir, 0700); err != nil {
+		return
+	}
+	path := responseCachePath(flags, cached.URL)
+	tmp := path + ".tmp"
+	if ioutil.WriteFile(tmp, contents, 0600) == nil {
+		os.Rename(tmp, path)
+	}
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGithubRequestUsesETagCache(t *testing.T) {
+	var conditional []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		if r.Header.Get("If-None-Match") == `"v1"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{"state":"success","statuses":[{"context":"ci","state":"success"}]}`)
+	})()
+
+	flags := defaultFlags()
+	flags.CacheDir = t.TempDir()
+	target := statusTarget{flags.OrgRepo, flags.SHA}
+	for i := 0; i < 2; i++ {
+		status, err := getCombinedStatus(target, *flags)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		if ci := status.find("ci"); ci == nil || ci.State != "success" {
+			t.Errorf("Expected the cached status on request %d, got %+v", i+1, status)
+		}
+	}
+
+	if len(conditional) != 2 || conditional[0] != "" || conditional[1] != `"v1"` {
+		t.Errorf("Expected the second request to send the stored ETag, got %q", conditional)
+	}
+}
+
+func TestGithubRequestCacheIsPerCredential(t *testing.T) {
+	var conditional []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		fmt.Fprint(w, `{}`)
+	})()
+
+	flags := defaultFlags()
+	flags.CacheDir = t.TempDir()
+	githubRequest("GET", githubAPIURL+"/repos/org/repo", *flags, nil)
+	flags.Auth = "other-token"
+	githubRequest("GET", githubAPIURL+"/repos/org/repo", *flags, nil)
+
+	if conditional[1] != "" {
+		t.Errorf("Expected no ETag to be sent for another token, got %q", conditional[1])
+	}
+}
+
+func TestGithubRequestDoesNotCacheWrites(t *testing.T) {
+	var conditional []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		conditional = append(conditional, r.Header.Get("If-None-Match"))
+		w.Header().Set("ETag", `"v1"`)
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.CacheDir = t.TempDir()
+	for i := 0; i < 2; i++ {
+		githubRequest("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, map[string]string{})
+	}
+	if conditional[1] != "" {
+		t.Errorf("Expected POST requests never to be conditional, got %q", conditional)
+	}
+}
+
+// withRunCache starts the test with an empty in-memory cache.
+func withRunCache(t *testing.T) {
+	runCache.Lock()
+	runCache.responses = map[string]*cachedResponse{}
+	runCache.Unlock()
+}
+
+func TestGithubRequestRunCacheWithoutCacheDir(t *testing.T) {
+	withRunCache(t)
+	logs := withLogger(t, logDebug)
+	var requests []string
+	version := "v1"
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Header.Get("If-None-Match")+"|"+r.Header.Get("If-Modified-Since"))
+		if r.Header.Get("If-None-Match") == `"`+version+`"` {
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		w.Header().Set("ETag", `"`+version+`"`)
+		w.Header().Set("Last-Modified", "Wed, 14 Oct 2026 10:00:00 GMT")
+		fmt.Fprintf(w, `{"version":%q}`, version)
+	})()
+
+	flags := defaultFlags()
+	url := githubAPIURL + "/repos/org/repo/commits/deadbeef/status"
+	var bodies []string
+	for _, v := range []string{"v1", "v1", "v2"} {
+		version = v
+		response, err := githubRequest("GET", url, *flags, nil)
+		if err != nil || response.StatusCode != http.StatusOK {
+			t.Fatalf("Expected a 200, got %+v %v", response, err)
+		}
+		bodies = append(bodies, string(response.Body))
+	}
+
+	lastModified := "Wed, 14 Oct 2026 10:00:00 GMT"
+	if requests[0] != "|" || requests[1] != `"v1"|`+lastModified || requests[2] != `"v1"|`+lastModified {
+		t.Errorf("Expected the later polls to be conditional, got %q", requests)
+	}
+	if bodies[1] != `{"version":"v1"}` || bodies[2] != `{"version":"v2"}` {
+		t.Errorf("Expected the cached body on 304 and the new one on 200, got %q", bodies)
+	}
+	if !strings.Contains(logs.String(), "Debug: Cache hit for "+url) || !strings.Contains(logs.String(), "Debug: Cache miss for "+url) {
+		t.Errorf("Expected cache hits and misses at debug level, got:\n%s", logs)
+	}
+}
@@ -0,0 +1,247 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"os"
+	"os/exec"
+	"path"
+	"strings"
+)
+
+// ciBranch returns the branch being built according to well known CI
+// environment variables, or "" if none are set.
+func ciBranch(getenv func(string) string) string {
+	if getenv("GITHUB_ACTIONS") == "true" {
+		if head := getenv("GITHUB_HEAD_REF"); head != "" {
+			logger.Debugf("Detected GitHub Actions, branch %s from GITHUB_HEAD_REF", head)
+			return head
+		}
+		if strings.HasPrefix(getenv("GITHUB_REF"), "refs/heads/") {
+			logger.Debugf("Detected GitHub Actions, branch from GITHUB_REF %s", getenv("GITHUB_REF"))
+			return strings.TrimPrefix(getenv("GITHUB_REF"), "refs/heads/")
+		}
+		logger.Debugf("Detected GitHub Actions, but GITHUB_REF %q is not a branch", getenv("GITHUB_REF"))
+		return ""
+	}
+
+	for _, name := range []string{
+		"CI_COMMIT_REF_NAME",         // GitLab CI
+		"TRAVIS_PULL_REQUEST_BRANCH", // Travis CI, pull requests
+		"TRAVIS_BRANCH",              // Travis CI
+		"CIRCLE_BRANCH",              // CircleCI
+		"BUILDKITE_BRANCH",           // Buildkite
+		"BRANCH_NAME",                // Jenkins multibranch
+		"GIT_BRANCH",                 // Jenkins git plugin
+	} {
+		if branch := getenv(name); branch != "" {
+			logger.Debugf("Detected the branch %s from %s", branch, name)
+			return strings.TrimPrefix(branch, "origin/")
+		}
+	}
+	return ""
+}
+
+// gitBranch returns the branch checked out in the working directory, or "" if
+// HEAD is detached or git is unavailable.
+func gitBranch() string {
+	out, err := exec.Command("git", "rev-parse", "--abbrev-ref", "HEAD").Output()
+	if err != nil {
+		return ""
+	}
+	branch := strings.TrimSpace(string(out))
+	if branch == "HEAD" {
+		return ""
+	}
+	return branch
+}
+
+// resolveBranch determines the branch being built: -branch, then CI
+// detection, then git. An empty result means no branch is known.
+func resolveBranch(flags Flags) string {
+	if flags.Branch != "" {
+		return flags.Branch
+	}
+	if branch := ciBranch(os.Getenv); branch != "" {
+		return branch
+	}
+	branch := gitBranch()
+	logger.Debugf("No CI branch variable is set, git has %q checked out", branch)
+	return branch
+}
+
+// splitList splits a comma separated list, dropping empty entries.
+func splitList(list string) []string {
+	var split []string
+	for _, item := range strings.Split(list, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			split = append(split, item)
+		}
+	}
+	return split
+}
+
+func matchAny(patterns []string, branch string) (string, bool) {
+	for _, pattern := range patterns {
+		if matched, _ := path.Match(pattern, branch); matched {
+			return pattern, true
+		}
+	}
+	return "", false
+}
+
+// validateBranchPatterns checks that every -only-branches and -skip-branches
+// pattern is a valid glob.
+func validateBranchPatterns(flags Flags) error {
+	for _, pattern := range append(splitList(flags.OnlyBranches), splitList(flags.SkipBranches)...) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Error: invalid branch pattern %q", pattern)
+		}
+	}
+	return nil
+}
+
+// shouldReportBranch decides whether statuses are reported for branch.
+// -skip-branches takes precedence over -only-branches. When no branch is
+// known, only -only-branches can prevent reporting. The returned reason
+// explains why reporting is skipped.
+func shouldReportBranch(flags Flags, branch string) (bool, string) {
+	only := splitList(flags.OnlyBranches)
+	skip := splitList(flags.SkipBranches)
+
+	if branch == "" {
+		if len(only) > 0 {
+			return false, "no branch could be determined and -only-branches is set"
+		}
+		return true, ""
+	}
+	if pattern, ok := matchAny(skip, branch); ok {
+		return false, fmt.Sprintf("branch %q matches -skip-branches pattern %q", branch, pattern)
+	}
+	if len(only) > 0 {
+		if _, ok := matchAny(only, branch); !ok {
+			return false, fmt.Sprintf("branch %q doesn't match any -only-branches pattern", branch)
+		}
+	}
+	return true, ""
+}
+
+// ciMarkers are environment variables set by well known CI systems.
+var ciMarkers = []string{
+	"CI",                     // most CI systems
+	"GITHUB_ACTIONS",         // GitHub Actions
+	"GITLAB_CI",              // GitLab CI
+	"TRAVIS",                 // Travis CI
+	"CIRCLECI",               // CircleCI
+	"BUILDKITE",              // Buildkite
+	"JENKINS_URL",            // Jenkins
+	"TF_BUILD",               // Azure Pipelines
+	"TEAMCITY_VERSION",       // TeamCity
+	"BITBUCKET_BUILD_NUMBER", // Bitbucket Pipelines
+	"CODEBUILD_BUILD_ID",     // AWS CodeBuild
+	"DRONE",                  // Drone
+	"APPVEYOR",               // AppVeyor
+}
+
+// detectCI returns the first of ciMarkers that is set, or "" if none is.
+// Values like CI=false, which some tools set to opt out, don't count.
+func detectCI(getenv func(string) string) string {
+	for _, name := range ciMarkers {
+		switch strings.ToLower(getenv(name)) {
+		case "", "false", "0":
+			continue
+		}
+		return name
+	}
+	return ""
+}
+
+// checkOnlyOnCI returns why statuses aren't posted under -only-on-ci, or ""
+// if they are. With -strict, not being on CI is an error instead.
+func checkOnlyOnCI(flags Flags, getenv func(string) string) (string, error) {
+	if !flags.OnlyOnCI {
+		return "", nil
+	}
+	if marker := detectCI(getenv); marker != "" {
+		logger.Debugf("Detected CI from %s", marker)
+		return "", nil
+	}
+	reason := "-only-on-ci is set and no CI environment variable such as CI=true is"
+	if flags.Strict {
+		return "", fmt.Errorf("Error: %s", reason)
+	}
+	return reason, nil
+}
+
+// pullRequestEvent is the part of a GitHub Actions pull_request event payload
+// needed to find the pull request's head commit.
+type pullRequestEvent struct {
+	PullRequest *struct {
+		Head struct {
+			SHA string `json:"sha"`
+		} `json:"head"`
+	} `json:"pull_request"`
+}
+
+// eventHeadSHA returns the head SHA of the pull request in the event payload
+// at path, or "" if there is no event file or it isn't a pull request event.
+// On pull_request events Actions checks out a merge commit, but statuses must
+// be posted to the head commit to show up on the pull request.
+func eventHeadSHA(path string) (string, error) {
+	if path == "" {
+		return "", nil
+	}
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading GITHUB_EVENT_PATH: %s", err)
+	}
+	var event pullRequestEvent
+	if err := json.Unmarshal(contents, &event); err != nil {
+		return "", fmt.Errorf("Error parsing GITHUB_EVENT_PATH %s: %s", path, err)
+	}
+	if event.PullRequest == nil {
+		return "", nil
+	}
+	return event.PullRequest.Head.SHA, nil
+}
+
+// actionsRunAttemptURL returns the page of the GitHub Actions run attempt
+// the variables describe, or "" and the missing variables. Without
+// GITHUB_RUN_ATTEMPT it links to the run, which shows the latest attempt.
+func actionsRunAttemptURL(getenv func(string) string) (string, []string) {
+	var missing []string
+	for _, name := range []string{"GITHUB_SERVER_URL", "GITHUB_REPOSITORY", "GITHUB_RUN_ID"} {
+		if getenv(name) == "" {
+			missing = append(missing, name)
+		}
+	}
+	if len(missing) > 0 {
+		return "", missing
+	}
+	link := strings.TrimSuffix(getenv("GITHUB_SERVER_URL"), "/") + "/" + getenv("GITHUB_REPOSITORY") + "/actions/runs/" + url.PathEscape(getenv("GITHUB_RUN_ID"))
+	if attempt := getenv("GITHUB_RUN_ATTEMPT"); attempt != "" {
+		link += "/attempts/" + url.PathEscape(attempt)
+	}
+	return link, nil
+}
+
+// applyRunAttemptURL links the statuses to the Actions run attempt for
+// -use-run-attempt-url when -t is empty. Outside Actions the statuses are
+// posted without a target URL, as they would be without the flag.
+func applyRunAttemptURL(flags *Flags, getenv func(string) string) {
+	if !flags.UseRunAttemptURL || flags.TargetUrl != "" {
+		return
+	}
+	link, missing := actionsRunAttemptURL(getenv)
+	if link == "" {
+		logger.Warnf("-use-run-attempt-url found no %s, posting without a target URL", strings.Join(missing, ", "))
+		return
+	}
+	logger.Debugf("Linking the statuses to the run attempt %s", link)
+	flags.TargetUrl = link
+}
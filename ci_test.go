@@ -0,0 +1,227 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func envMap(values map[string]string) func(string) string {
+	return func(name string) string {
+		return values[name]
+	}
+}
+
+func TestCIBranch(t *testing.T) {
+	cases := []struct {
+		env      map[string]string
+		expected string
+	}{
+		{map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/heads/main"}, "main"},
+		{map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/pull/7/merge", "GITHUB_HEAD_REF": "feature/x"}, "feature/x"},
+		{map[string]string{"GITHUB_ACTIONS": "true", "GITHUB_REF": "refs/tags/v1.0"}, ""},
+		{map[string]string{"CI_COMMIT_REF_NAME": "release/1.2"}, "release/1.2"},
+		{map[string]string{"TRAVIS_BRANCH": "main", "TRAVIS_PULL_REQUEST_BRANCH": "fix"}, "fix"},
+		{map[string]string{"GIT_BRANCH": "origin/main"}, "main"},
+		{map[string]string{}, ""},
+	}
+
+	for _, c := range cases {
+		if branch := ciBranch(envMap(c.env)); branch != c.expected {
+			t.Errorf("Expected branch %q for %v, got %q", c.expected, c.env, branch)
+		}
+	}
+}
+
+func TestShouldReportBranch(t *testing.T) {
+	cases := []struct {
+		only, skip, branch string
+		expected           bool
+	}{
+		{"", "", "anything", true},
+		{"main,release/*", "", "main", true},
+		{"main,release/*", "", "release/1.0", true},
+		{"main,release/*", "", "release/1.0/hotfix", false},
+		{"main,release/*", "", "feature/x", false},
+		{"", "feature/*", "feature/x", false},
+		{"", "feature/*", "main", true},
+		// -skip-branches wins over -only-branches.
+		{"release/*", "release/old", "release/old", false},
+		// Detached HEAD: no branch is known.
+		{"main", "", "", false},
+		{"", "feature/*", "", true},
+		{"", "", "", true},
+	}
+
+	for _, c := range cases {
+		flags := defaultFlags()
+		flags.OnlyBranches, flags.SkipBranches = c.only, c.skip
+		ok, reason := shouldReportBranch(*flags, c.branch)
+		if ok != c.expected {
+			t.Errorf("Expected only=%q skip=%q branch=%q to report=%t, got %t (%s)", c.only, c.skip, c.branch, c.expected, ok, reason)
+		}
+		if !ok && reason == "" {
+			t.Errorf("Expected a reason when skipping branch %q", c.branch)
+		}
+	}
+}
+
+func TestResolveBranchPrefersFlag(t *testing.T) {
+	flags := defaultFlags()
+	flags.Branch = "explicit"
+	if branch := resolveBranch(*flags); branch != "explicit" {
+		t.Errorf("Expected -branch to win, got %q", branch)
+	}
+}
+
+func TestValidateBranchPatterns(t *testing.T) {
+	flags := defaultFlags()
+	flags.OnlyBranches = "main,[broken"
+	if err := validateBranchPatterns(*flags); err == nil {
+		t.Error("Expected an error for a malformed pattern")
+	}
+}
+
+func TestEventHeadSHA(t *testing.T) {
+	pullRequest := writeTempFile(t, "pull_request.json", `{
+  "action": "synchronize",
+  "number": 42,
+  "pull_request": {
+    "number": 42,
+    "head": {"ref": "feature", "sha": "0123456789abcdef0123456789abcdef01234567"},
+    "base": {"ref": "master", "sha": "fedcba9876543210fedcba9876543210fedcba98"}
+  }
+}`)
+	sha, err := eventHeadSHA(pullRequest)
+	if err != nil || sha != "0123456789abcdef0123456789abcdef01234567" {
+		t.Errorf("Expected the pull request head SHA, got %q %v", sha, err)
+	}
+
+	push := writeTempFile(t, "push.json", `{"ref": "refs/heads/master", "after": "abc"}`)
+	for _, path := range []string{"", push, push + ".missing"} {
+		if sha, err := eventHeadSHA(path); sha != "" || err != nil {
+			t.Errorf("Expected no head SHA for %q, got %q %v", path, sha, err)
+		}
+	}
+
+	if _, err := eventHeadSHA(writeTempFile(t, "broken.json", "{")); err == nil {
+		t.Errorf("Expected an error for an unparsable event")
+	}
+}
+
+func TestDetectCI(t *testing.T) {
+	for _, test := range []struct {
+		env      map[string]string
+		expected string
+	}{
+		{map[string]string{"CI": "true"}, "CI"},
+		{map[string]string{"GITHUB_ACTIONS": "true"}, "GITHUB_ACTIONS"},
+		{map[string]string{"JENKINS_URL": "https://jenkins.example.com"}, "JENKINS_URL"},
+		{map[string]string{"TF_BUILD": "True"}, "TF_BUILD"},
+		{map[string]string{"CI": "false", "BUILDKITE": "true"}, "BUILDKITE"},
+		{map[string]string{"CI": "0"}, ""},
+		{map[string]string{"HOME": "/home/dev"}, ""},
+	} {
+		if marker := detectCI(envMap(test.env)); marker != test.expected {
+			t.Errorf("%v: expected %q, got %q", test.env, test.expected, marker)
+		}
+	}
+}
+
+func TestCheckOnlyOnCI(t *testing.T) {
+	flags := defaultFlags()
+	laptop := envMap(map[string]string{})
+	if reason, err := checkOnlyOnCI(*flags, laptop); reason != "" || err != nil {
+		t.Errorf("Expected statuses to be posted without -only-on-ci, got %q %v", reason, err)
+	}
+	flags.OnlyOnCI = true
+	if reason, err := checkOnlyOnCI(*flags, envMap(map[string]string{"CI": "true"})); reason != "" || err != nil {
+		t.Errorf("Expected statuses to be posted on CI, got %q %v", reason, err)
+	}
+	if reason, err := checkOnlyOnCI(*flags, laptop); !strings.Contains(reason, "no CI environment variable") || err != nil {
+		t.Errorf("Expected reporting to be skipped off CI, got %q %v", reason, err)
+	}
+	flags.Strict = true
+	if _, err := checkOnlyOnCI(*flags, laptop); err == nil {
+		t.Errorf("Expected an error off CI with -strict")
+	}
+}
+
+// notOnCI clears every CI marker for runCLIWithEnv.
+func notOnCI() []string {
+	var env []string
+	for _, name := range ciMarkers {
+		env = append(env, name+"=")
+	}
+	return env
+}
+
+func TestCLIOnlyOnCI(t *testing.T) {
+	args := []string{"-only-on-ci", "-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", "echo ran; exit 3"}
+	out, code := runCLIWithEnv(t, notOnCI(), args...)
+	if code != 3 || !strings.Contains(out, "ran") || !strings.Contains(out, "Not reporting statuses: -only-on-ci is set") {
+		t.Errorf("Expected the command to run without reporting off CI, got %d:\n%s", code, out)
+	}
+
+	out, code = runCLIWithEnv(t, append(notOnCI(), "CI=true"), args...)
+	if code != 1 || strings.Contains(out, "Not reporting") || strings.Contains(out, "Error") {
+		t.Errorf("Expected the failure to be posted on CI, got %d:\n%s", code, out)
+	}
+
+	out, code = runCLIWithEnv(t, notOnCI(), append([]string{"-strict"}, args...)...)
+	if code != 1 || strings.Contains(out, "ran") || !strings.Contains(out, "Error: -only-on-ci is set") {
+		t.Errorf("Expected -strict to fail without running the command off CI, got %d:\n%s", code, out)
+	}
+}
+
+func TestActionsRunAttemptURL(t *testing.T) {
+	actions := map[string]string{"GITHUB_SERVER_URL": "https://github.com/", "GITHUB_REPOSITORY": "org/repo", "GITHUB_RUN_ID": "1234", "GITHUB_RUN_ATTEMPT": "2"}
+	if link, missing := actionsRunAttemptURL(envMap(actions)); link != "https://github.com/org/repo/actions/runs/1234/attempts/2" || missing != nil {
+		t.Errorf("Unexpected run attempt URL %q, missing %q", link, missing)
+	}
+	delete(actions, "GITHUB_RUN_ATTEMPT")
+	if link, _ := actionsRunAttemptURL(envMap(actions)); link != "https://github.com/org/repo/actions/runs/1234" {
+		t.Errorf("Expected the run URL without GITHUB_RUN_ATTEMPT, got %q", link)
+	}
+	delete(actions, "GITHUB_RUN_ID")
+	delete(actions, "GITHUB_SERVER_URL")
+	if link, missing := actionsRunAttemptURL(envMap(actions)); link != "" || strings.Join(missing, ",") != "GITHUB_SERVER_URL,GITHUB_RUN_ID" {
+		t.Errorf("Expected the missing variables, got %q and %q", link, missing)
+	}
+}
+
+func TestApplyRunAttemptURL(t *testing.T) {
+	actions := envMap(map[string]string{"GITHUB_SERVER_URL": "https://github.example.com", "GITHUB_REPOSITORY": "org/repo", "GITHUB_RUN_ID": "99", "GITHUB_RUN_ATTEMPT": "3"})
+
+	flags := defaultFlags()
+	flags.UseRunAttemptURL = true
+	applyRunAttemptURL(flags, actions)
+	if flags.TargetUrl != "https://github.example.com/org/repo/actions/runs/99/attempts/3" {
+		t.Errorf("Unexpected target URL %q", flags.TargetUrl)
+	}
+
+	flags.TargetUrl = "https://ci.example.com/build/1"
+	applyRunAttemptURL(flags, actions)
+	if flags.TargetUrl != "https://ci.example.com/build/1" {
+		t.Errorf("Expected -t to win, got %q", flags.TargetUrl)
+	}
+
+	out := withLogger(t, logWarn)
+	flags.TargetUrl = ""
+	applyRunAttemptURL(flags, envMap(nil))
+	if flags.TargetUrl != "" || !strings.Contains(out.String(), "found no GITHUB_SERVER_URL, GITHUB_REPOSITORY, GITHUB_RUN_ID,") {
+		t.Errorf("Expected no target URL and a warning, got %q:\n%s", flags.TargetUrl, out)
+	}
+}
+
+func TestCLIUsesRunAttemptURL(t *testing.T) {
+	out, code := runCLIWithEnv(t, []string{"GITHUB_SERVER_URL=https://github.com", "GITHUB_REPOSITORY=org/repo", "GITHUB_RUN_ID=1234", "GITHUB_RUN_ATTEMPT=2"},
+		"-dry-run", "-use-run-attempt-url", "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "true")
+	if code != 0 {
+		t.Fatalf("Expected success, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, `"target_url":"https://github.com/org/repo/actions/runs/1234/attempts/2"`) {
+		t.Errorf("Expected the run attempt as the target URL, got:\n%s", out)
+	}
+}
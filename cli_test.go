@@ -0,0 +1,84 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// TestMain runs main instead of the tests when re-executed by runCLI.
+func TestMain(m *testing.M) {
+	if os.Getenv("GHSR_TEST_MAIN") == "1" {
+		main()
+	}
+	os.Exit(m.Run())
+}
+
+// runCLI runs gh-status-reporter with args and returns its output and exit
+// code.
+func runCLI(t *testing.T, args ...string) (string, int) {
+	return runCLIWithEnv(t, nil, args...)
+}
+
+// runCLIWithEnv is runCLI with the KEY=VALUE entries of env added to the
+// environment.
+func runCLIWithEnv(t *testing.T, env []string, args ...string) (string, int) {
+	cmd := exec.Command(os.Args[0], args...)
+	cmd.Env = append(append(os.Environ(), "GHSR_TEST_MAIN=1"), env...)
+	out, err := cmd.CombinedOutput()
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		return string(out), exitErr.ExitCode()
+	} else if err != nil {
+		t.Fatalf("Error running the CLI: %s", err)
+	}
+	return string(out), 0
+}
+
+func TestCLIReportsCommandResult(t *testing.T) {
+	report := filepath.Join(t.TempDir(), "report.json")
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"-json-report", report, "sh", "-c", "echo running; exit 3")
+
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "running") {
+		t.Errorf("Expected the command's output, got:\n%s", out)
+	}
+	contents, _ := ioutil.ReadFile(report)
+	if !strings.Contains(string(contents), `"state": "failure"`) || !strings.Contains(string(contents), `"exit_code": 3`) {
+		t.Errorf("Unexpected report:\n%s", contents)
+	}
+}
+
+func TestCLIFailsOnUnexpectedRequest(t *testing.T) {
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "lint", "-d", "unit test", "-a", "token", "true")
+
+	if code == 0 || !strings.Contains(out, "no recorded response") {
+		t.Errorf("Expected the unrecorded status post to fail, got %d:\n%s", code, out)
+	}
+}
+
+func TestCLIReportsAllValidationErrors(t *testing.T) {
+	out, code := runCLI(t, "-r", "org/repo", "true")
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	for _, expected := range []string{"set -s or BUILD_SHA", "set -c or BUILD_CONTEXT", "Run with -help to see all flags."} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in the output, got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestCLIPrintsUsageWithoutArguments(t *testing.T) {
+	out, code := runCLI(t)
+	if code != 1 || !strings.Contains(out, "Usage of") || !strings.Contains(out, "-allow-empty-context") {
+		t.Errorf("Expected usage and exit code 1, got %d:\n%s", code, out)
+	}
+}
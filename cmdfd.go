@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// parseCmdFD returns the file descriptor -cmd-fd names.
+func parseCmdFD(value string) (int, error) {
+	fd, err := strconv.Atoi(value)
+	if err != nil || fd < 0 {
+		return 0, fmt.Errorf("Error: -cmd-fd must be a file descriptor number, got %q", value)
+	}
+	return fd, nil
+}
+
+// validateCmdFD checks -cmd-fd against the other ways of giving the command.
+// Whether the descriptor is open is checked when it is read, since an
+// *os.File for it would close it when garbage collected.
+func validateCmdFD(flags Flags, command []string) error {
+	fd, err := parseCmdFD(flags.CmdFD)
+	if err != nil {
+		return err
+	}
+	if len(command) > 0 || flags.ScriptFile != "" {
+		return errors.New("Error: -cmd-fd reads the command, so it can't be combined with a command or -f")
+	}
+	if fd == 0 && flags.Stdin == stdinInherit {
+		return errors.New("Error: -cmd-fd 0 reads the command from stdin, so the command can't inherit it with -stdin inherit")
+	}
+	return nil
+}
+
+// readCommandFD reads the command from the -cmd-fd descriptor until it is
+// closed, then closes it.
+func readCommandFD(value string) ([]string, error) {
+	fd, err := parseCmdFD(value)
+	if err != nil {
+		return nil, err
+	}
+	file := os.NewFile(uintptr(fd), "fd "+value)
+	defer file.Close()
+	if _, err := file.Stat(); err != nil {
+		return nil, fmt.Errorf("Error: -cmd-fd %d is not an open file descriptor: %s", fd, err)
+	}
+	command, err := parseCommandLines(file)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the command from -cmd-fd %d: %s", fd, err)
+	}
+	return command, nil
+}
+
+// parseCommandLines parses a command given one argument per line. Lines are
+// taken as is, so arguments may contain spaces and quotes, and an empty line
+// is an empty argument; only the line endings are dropped.
+func parseCommandLines(r io.Reader) ([]string, error) {
+	var command []string
+	reader := bufio.NewReader(r)
+	for {
+		line, err := reader.ReadString('\n')
+		if line != "" {
+			command = append(command, strings.TrimSuffix(strings.TrimSuffix(line, "\n"), "\r"))
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(command) == 0 || command[0] == "" {
+		return nil, errors.New("no command, the first line must be the program to run")
+	}
+	return command, nil
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"os"
+	"os/exec"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseCommandLines(t *testing.T) {
+	for _, test := range []struct {
+		input   string
+		command []string
+		message string
+	}{
+		{"make\ntest\n", []string{"make", "test"}, ""},
+		{"sh\n-c\necho 'a b' \"c\"\n", []string{"sh", "-c", "echo 'a b' \"c\""}, ""},
+		{"printf\r\n%s|\r\n\r\nlast", []string{"printf", "%s|", "", "last"}, ""},
+		{"", nil, "no command"},
+		{"\nmake\n", nil, "no command"},
+	} {
+		command, err := parseCommandLines(strings.NewReader(test.input))
+		if test.message != "" {
+			if err == nil || !strings.Contains(err.Error(), test.message) {
+				t.Errorf("Expected %q for %q, got %v", test.message, test.input, err)
+			}
+			continue
+		}
+		if err != nil || !reflect.DeepEqual(command, test.command) {
+			t.Errorf("Expected %q for %q, got %q, %v", test.command, test.input, command, err)
+		}
+	}
+}
+
+func TestValidateCmdFD(t *testing.T) {
+	for _, test := range []struct {
+		flags   Flags
+		command []string
+		message string
+	}{
+		{Flags{CmdFD: "2"}, nil, ""},
+		{Flags{CmdFD: "three"}, nil, "must be a file descriptor number"},
+		{Flags{CmdFD: "-1"}, nil, "must be a file descriptor number"},
+		{Flags{CmdFD: "2"}, []string{"make"}, "can't be combined"},
+		{Flags{CmdFD: "2", ScriptFile: "build.sh"}, nil, "can't be combined"},
+		{Flags{CmdFD: "0", Stdin: stdinInherit}, nil, "-stdin inherit"},
+	} {
+		err := validateCmdFD(test.flags, test.command)
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %+v, got %v", test.message, test.flags, err)
+		}
+	}
+}
+
+func TestReadCommandFDRejectsClosedFD(t *testing.T) {
+	_, err := readCommandFD("987")
+	if err == nil || !strings.Contains(err.Error(), "not an open file descriptor") {
+		t.Errorf("Expected an error for a closed fd, got %v", err)
+	}
+}
+
+func TestCLIReadsCommandFromCmdFD(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	go func() {
+		writer.WriteString("sh\n-c\necho \"$1 from the pipe\"\n--\nhello world\n")
+		writer.Close()
+	}()
+
+	cmd := exec.Command(os.Args[0], "-dry-run", "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "-cmd-fd", "3")
+	cmd.Env = append(os.Environ(), "GHSR_TEST_MAIN=1")
+	// The first of ExtraFiles is fd 3 in the child.
+	cmd.ExtraFiles = []*os.File{reader}
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Expected the command to pass, got %s:\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "hello world from the pipe") {
+		t.Errorf("Expected the command read from the fd to run, got:\n%s", out)
+	}
+}
@@ -0,0 +1,369 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// outputTailSize is how much of the command's combined output is kept in
+// memory for reports.
+const outputTailSize = 64 * 1024
+
+// tailBuffer is an io.Writer that keeps only the last size bytes written to it.
+// It is safe for concurrent use so stdout and stderr can share one buffer.
+type tailBuffer struct {
+	mu   sync.Mutex
+	size int
+	buf  []byte
+}
+
+func newTailBuffer(size int) *tailBuffer {
+	return &tailBuffer{size: size}
+}
+
+func (t *tailBuffer) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if len(p) >= t.size {
+		t.buf = append(t.buf[:0], p[len(p)-t.size:]...)
+		return len(p), nil
+	}
+	if overflow := len(t.buf) + len(p) - t.size; overflow > 0 {
+		t.buf = append(t.buf[:0], t.buf[overflow:]...)
+	}
+	t.buf = append(t.buf, p...)
+	return len(p), nil
+}
+
+func (t *tailBuffer) String() string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	return string(t.buf)
+}
+
+// commandResult describes how the wrapped command finished. With retries it
+// describes the last attempt, and Attempts holds the history of all of them.
+type commandResult struct {
+	Err      error
+	ExitCode int
+	TimedOut bool
+	Started  time.Time
+	Duration time.Duration
+	Output   *tailBuffer
+	Attempts []commandAttempt
+	// Errored means the command couldn't be run at all, as opposed to
+	// running and failing.
+	Errored bool
+	// StderrBytes is how much the command wrote to stderr.
+	StderrBytes int64
+	// StatusOnly means Err fails the status but not the exit code.
+	StatusOnly bool
+	// Interrupted means the command was stopped because gh-status-reporter
+	// is shutting down.
+	Interrupted bool
+}
+
+// commandAttempt is the outcome of one run of the command.
+type commandAttempt struct {
+	ExitCode        int     `json:"exit_code"`
+	DurationSeconds float64 `json:"duration_seconds"`
+	TimedOut        bool    `json:"timed_out,omitempty"`
+	// RetriedOn is the -retry-on-output pattern that matched this attempt's
+	// output and caused the retry after it.
+	RetriedOn string `json:"retried_on,omitempty"`
+}
+
+// commandOptions controls how the command is run and how its output is
+// relayed and captured.
+type commandOptions struct {
+	// Timeout stops the command once it has run this long. Zero means no limit.
+	Timeout time.Duration
+	// TimeoutGrace is how long a timed out command has between SIGTERM and
+	// SIGKILL. Zero kills it immediately.
+	TimeoutGrace time.Duration
+	// Secrets are replaced with "***" before output reaches any destination.
+	Secrets []string
+	// Timestamps prefixes each relayed line. Captured output is unaffected.
+	Timestamps timestampMode
+	// OutputPrefix starts each relayed line, before any timestamp. Captured
+	// output is unaffected.
+	OutputPrefix string
+	// Retries is how many times a failed command is run again.
+	Retries int
+	// RetryOnOutput, if set, limits retries to failed attempts whose output
+	// matches one of the patterns.
+	RetryOnOutput []*regexp.Regexp
+	// RetryOnExitCodes, if set, limits retries to failed attempts that
+	// exited with one of the codes, with 128+n for a signal n.
+	RetryOnExitCodes map[int]bool
+	// EchoMaxLines limits the relayed output of each stream to its first and
+	// last EchoMaxLines lines. Zero relays everything. Captured output is
+	// unaffected.
+	EchoMaxLines int
+	// Container means the command is a container runtime client, whose
+	// exit codes are translated by interpretContainerExit.
+	Container bool
+	// AfterStart, if set, is called once the command has started. An error
+	// stops the command.
+	AfterStart func(*os.Process) error
+	// Kill, if set, is called when a timed out command is killed, for
+	// processes outside the process group such as containers.
+	Kill func()
+	// Interrupt, if set, stops the command like a timeout when it is closed.
+	Interrupt <-chan struct{}
+	// Progress, if set, watches the masked output for progress updates.
+	Progress *progressReporter
+	// Live, if set, keeps the tail of the masked output for -live-output.
+	Live *liveOutput
+	// OutputFile, if set, receives the complete masked output of both
+	// streams, with Timestamps but without OutputPrefix.
+	OutputFile io.Writer
+}
+
+// relayStream builds the writer chain for one of the command's output
+// streams. The returned flushers must be flushed in order once it exits.
+func relayStream(echo io.Writer, result *commandResult, options commandOptions) (io.Writer, []flusher) {
+	var flushers []flusher
+	if options.EchoMaxLines > 0 {
+		limited := newHeadTailWriter(echo, options.EchoMaxLines)
+		flushers = append(flushers, limited)
+		echo = limited
+	}
+	if prefix := linePrefix(options, result.Started); prefix != nil {
+		lines := newLinePrefixWriter(echo, prefix)
+		flushers = append([]flusher{lines}, flushers...)
+		echo = lines
+	}
+
+	destinations := []io.Writer{echo, result.Output}
+	if options.Progress != nil {
+		destinations = append(destinations, options.Progress.stream())
+	}
+	if options.Live != nil {
+		destinations = append(destinations, options.Live.stream())
+	}
+	if options.OutputFile != nil {
+		file := options.OutputFile
+		if timestamp := options.Timestamps.prefixFunc(result.Started); timestamp != nil {
+			lines := newLinePrefixWriter(file, timestamp)
+			flushers = append(flushers, lines)
+			file = lines
+		}
+		destinations = append(destinations, file)
+	}
+	masked := newMaskingWriter(io.MultiWriter(destinations...), options.Secrets)
+	return masked, append([]flusher{masked}, flushers...)
+}
+
+// linePrefix returns what starts each relayed line: the -output-prefix
+// followed by the -timestamps timestamp, or nil when there is neither.
+func linePrefix(options commandOptions, start time.Time) func() string {
+	timestamp := options.Timestamps.prefixFunc(start)
+	switch {
+	case options.OutputPrefix == "":
+		return timestamp
+	case timestamp == nil:
+		return func() string { return options.OutputPrefix }
+	}
+	return func() string { return options.OutputPrefix + timestamp() }
+}
+
+// byteCounter is an io.Writer that counts the bytes written to it.
+type byteCounter int64
+
+func (c *byteCounter) Write(p []byte) (int, error) {
+	*c += byteCounter(len(p))
+	return len(p), nil
+}
+
+// runCommand runs subprocess to completion, echoing its output to stdout and
+// stderr while capturing the tail of it.
+func runCommand(subprocess *exec.Cmd, options commandOptions) *commandResult {
+	result := &commandResult{Output: newTailBuffer(outputTailSize)}
+
+	stdout, stderr := io.Writer(os.Stdout), io.Writer(os.Stderr)
+	if subprocess.Stdout != nil {
+		stdout = subprocess.Stdout
+	}
+	if subprocess.Stderr != nil {
+		stderr = subprocess.Stderr
+	}
+
+	result.Started = time.Now()
+	var stdoutFlushers, stderrFlushers []flusher
+	subprocess.Stdout, stdoutFlushers = relayStream(stdout, result, options)
+	subprocess.Stderr, stderrFlushers = relayStream(stderr, result, options)
+	subprocess.Stderr = io.MultiWriter(subprocess.Stderr, (*byteCounter)(&result.StderrBytes))
+
+	logger.Debugf("Running %s", maskText(strings.Join(subprocess.Args, " "), options.Secrets))
+	result.Err = waitCommand(subprocess, result, options)
+	result.Duration = time.Since(result.Started)
+	for _, f := range append(stdoutFlushers, stderrFlushers...) {
+		f.Flush()
+	}
+	result.ExitCode = exitCode(result.Err)
+	logger.Debugf("The command exited with %d after %s", result.ExitCode, result.Duration)
+
+	return result
+}
+
+// runCommandAttempts runs subprocess like runCommand, running a fresh copy of
+// it again after each failure until it succeeds or options.Retries retries
+// have been used.
+func runCommandAttempts(subprocess *exec.Cmd, options commandOptions) *commandResult {
+	template := cloneCommand(subprocess)
+	var attempts []commandAttempt
+	for attempt := 1; ; attempt++ {
+		result := runCommand(subprocess, options)
+		if options.Container {
+			interpretContainerExit(result)
+		}
+		attempts = append(attempts, commandAttempt{
+			ExitCode:        result.ExitCode,
+			DurationSeconds: result.Duration.Seconds(),
+			TimedOut:        result.TimedOut,
+		})
+		result.Attempts = attempts
+		if result.Err == nil || result.Interrupted || attempt > options.Retries {
+			return result
+		}
+		if _, ok := commandNotFound(result.Err); ok {
+			// Retrying can't make a missing command appear.
+			return result
+		}
+		if len(options.RetryOnExitCodes) > 0 {
+			if code := commandExitCode(result.Err); !options.RetryOnExitCodes[code] {
+				logger.Infof("Attempt %d failed: %s; not retrying, exit code %d isn't one of -retry-command-on", attempt, result.Err, code)
+				return result
+			}
+		}
+		if len(options.RetryOnOutput) > 0 {
+			pattern := retryPattern(options.RetryOnOutput, result.Output.String())
+			if pattern == nil {
+				logger.Infof("Attempt %d failed: %s; not retrying, its output matches no -retry-on-output pattern", attempt, result.Err)
+				return result
+			}
+			attempts[len(attempts)-1].RetriedOn = pattern.String()
+			logger.Debugf("The output of attempt %d matches %q", attempt, pattern)
+		}
+		logger.Infof("Attempt %d of %d failed: %s, retrying", attempt, options.Retries+1, result.Err)
+		subprocess = cloneCommand(template)
+	}
+}
+
+// cloneCommand copies the configuration of cmd into a command that hasn't
+// been started.
+func cloneCommand(cmd *exec.Cmd) *exec.Cmd {
+	clone := exec.Command(cmd.Path, cmd.Args[1:]...)
+	clone.Args = cmd.Args
+	clone.Env = cmd.Env
+	clone.Dir = cmd.Dir
+	clone.Stdin = cmd.Stdin
+	if input, ok := cmd.Stdin.(*bytes.Reader); ok {
+		// -stdin-file and -stdin-string are replayed to every attempt.
+		input.Seek(0, io.SeekStart)
+	}
+	clone.Stdout = cmd.Stdout
+	clone.Stderr = cmd.Stderr
+	clone.SysProcAttr = cmd.SysProcAttr
+	return clone
+}
+
+// waitCommand starts subprocess and waits for it, stopping it if it runs past
+// options.Timeout or options.Interrupt is closed.
+func waitCommand(subprocess *exec.Cmd, result *commandResult, options commandOptions) error {
+	// Stopping the command for shutdown needs a process group too, to reach
+	// what it spawned, unless it reads from the terminal, which it couldn't
+	// from a background process group. Ctrl-C reaches all of it then anyway.
+	if options.Timeout > 0 || (options.Interrupt != nil && !readsTerminal(subprocess)) {
+		setProcessGroup(subprocess)
+	}
+	if err := subprocess.Start(); err != nil {
+		return err
+	}
+	if options.AfterStart != nil {
+		if err := options.AfterStart(subprocess.Process); err != nil {
+			subprocess.Process.Kill()
+			subprocess.Wait()
+			return err
+		}
+	}
+	if options.Timeout <= 0 && options.Interrupt == nil {
+		return subprocess.Wait()
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- subprocess.Wait()
+	}()
+
+	var timeout <-chan time.Time
+	if options.Timeout > 0 {
+		timer := time.NewTimer(options.Timeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	select {
+	case err := <-done:
+		return err
+	case <-options.Interrupt:
+		result.Interrupted, result.Errored = true, true
+		logger.Debugf("Stopping the command for shutdown")
+		stopCommand(subprocess, done, options.TimeoutGrace, options.Kill)
+		return errors.New("command cancelled")
+	case <-timeout:
+	}
+
+	result.TimedOut = true
+	logger.Debugf("The command ran past its %s timeout, stopping it", options.Timeout)
+	stopCommand(subprocess, done, options.TimeoutGrace, options.Kill)
+	return fmt.Errorf("command timed out after %s", options.Timeout)
+}
+
+// readsTerminal reports whether subprocess's stdin is a terminal.
+func readsTerminal(subprocess *exec.Cmd) bool {
+	file, ok := subprocess.Stdin.(*os.File)
+	return ok && isInteractive(file)
+}
+
+// stopCommand sends SIGTERM to the command's process group and, if it is still
+// running after grace, SIGKILL, calling kill first if it is set. It returns
+// once the command has exited.
+func stopCommand(subprocess *exec.Cmd, done <-chan error, grace time.Duration, kill func()) {
+	if grace > 0 {
+		logger.Debugf("Sending SIGTERM to the command, SIGKILL follows in %s", grace)
+		terminateProcessGroup(subprocess)
+		select {
+		case <-done:
+			return
+		case <-time.After(grace):
+		}
+	}
+	logger.Debugf("Sending SIGKILL to the command")
+	if kill != nil {
+		kill()
+	}
+	killProcessGroup(subprocess)
+	<-done
+}
+
+// exitCode extracts the exit status from the error returned by exec.Cmd.Run.
+// Errors that are not exit statuses, such as a missing executable, map to 1.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	if exitErr, ok := err.(*exec.ExitError); ok && exitErr.ExitCode() > 0 {
+		return exitErr.ExitCode()
+	}
+	return 1
+}
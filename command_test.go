@@ -0,0 +1,212 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// lockedBuffer is a bytes.Buffer that a command's stdout and stderr, which
+// are relayed concurrently, can both write to.
+type lockedBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *lockedBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *lockedBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func TestTailBufferKeepsLastBytes(t *testing.T) {
+	tail := newTailBuffer(5)
+	tail.Write([]byte("abc"))
+	tail.Write([]byte("defg"))
+	if tail.String() != "cdefg" {
+		t.Errorf("Expected tail to be %q, got %q", "cdefg", tail.String())
+	}
+
+	tail.Write([]byte("0123456789"))
+	if tail.String() != "56789" {
+		t.Errorf("Expected tail to be %q, got %q", "56789", tail.String())
+	}
+}
+
+func TestRunCommandCapturesOutputAndExitCode(t *testing.T) {
+	var stdout lockedBuffer
+	subprocess := exec.Command("sh", "-c", "echo out; echo err 1>&2; exit 3")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stdout
+
+	result := runCommand(subprocess, commandOptions{})
+	if result.ExitCode != 3 {
+		t.Errorf("Expected exit code 3, got %d", result.ExitCode)
+	}
+	for _, line := range []string{"out\n", "err\n"} {
+		if !strings.Contains(result.Output.String(), line) {
+			t.Errorf("Expected captured output to contain %q, got %q", line, result.Output.String())
+		}
+		if !strings.Contains(stdout.String(), line) {
+			t.Errorf("Expected echoed output to contain %q, got %q", line, stdout.String())
+		}
+	}
+	if result.Duration <= 0 {
+		t.Errorf("Expected a positive duration, got %s", result.Duration)
+	}
+}
+
+func TestRunCommandMissingExecutable(t *testing.T) {
+	result := runCommand(exec.Command("gh-status-reporter-does-not-exist"), commandOptions{})
+	if result.Err == nil || result.ExitCode != 1 {
+		t.Errorf("Expected an error and exit code 1, got %v and %d", result.Err, result.ExitCode)
+	}
+}
+
+func TestRunCommandMasksSecrets(t *testing.T) {
+	var stdout bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "printf 'token is sup3rs'; printf 'ecret\\n'")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stdout
+
+	result := runCommand(subprocess, commandOptions{Secrets: []string{"sup3rsecret"}})
+	if stdout.String() != "token is ***\n" {
+		t.Errorf("Expected echoed output to be masked, got %q", stdout.String())
+	}
+	if result.Output.String() != "token is ***\n" {
+		t.Errorf("Expected captured output to be masked, got %q", result.Output.String())
+	}
+}
+
+func TestRunCommandTimeoutGrace(t *testing.T) {
+	var stdout bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "trap 'echo cleaned up; exit 0' TERM; sleep 10 & wait")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stdout
+
+	result := runCommand(subprocess, commandOptions{Timeout: 200 * time.Millisecond, TimeoutGrace: 5 * time.Second})
+	if !result.TimedOut || result.Err == nil {
+		t.Errorf("Expected the command to time out, got %+v", result)
+	}
+	if !strings.Contains(stdout.String(), "cleaned up") {
+		t.Errorf("Expected the command to handle SIGTERM, got %q", stdout.String())
+	}
+	if result.Duration > 4*time.Second {
+		t.Errorf("Expected the command to exit once it handled SIGTERM, took %s", result.Duration)
+	}
+}
+
+func TestRunCommandTimeoutKillsIgnoringCommand(t *testing.T) {
+	subprocess := exec.Command("sh", "-c", "trap '' TERM; sleep 10")
+
+	result := runCommand(subprocess, commandOptions{Timeout: 100 * time.Millisecond, TimeoutGrace: 200 * time.Millisecond})
+	if !result.TimedOut {
+		t.Error("Expected the command to time out")
+	}
+	if result.Duration > 5*time.Second {
+		t.Errorf("Expected SIGKILL after the grace period, took %s", result.Duration)
+	}
+}
+
+func TestRunCommandAttemptsRetriesFailures(t *testing.T) {
+	counter := writeTempFile(t, "attempts", "")
+	var stdout bytes.Buffer
+	subprocess := exec.Command("sh", "-c", `echo x >> "$0"; echo attempt; [ $(wc -l < "$0") -ge 3 ]`, counter)
+	subprocess.Stdout = &stdout
+
+	logs := withLogger(t, logInfo)
+	result := runCommandAttempts(subprocess, commandOptions{Retries: 5})
+	if result.Err != nil {
+		t.Errorf("Expected the third attempt to succeed, got %s", result.Err)
+	}
+	if logs.String() != "Attempt 1 of 6 failed: exit status 1, retrying\nAttempt 2 of 6 failed: exit status 1, retrying\n" {
+		t.Errorf("Expected each retry at info level, got %q", logs)
+	}
+	if len(result.Attempts) != 3 || result.Attempts[0].ExitCode != 1 || result.Attempts[2].ExitCode != 0 {
+		t.Errorf("Unexpected attempts %+v", result.Attempts)
+	}
+	if strings.Count(stdout.String(), "attempt\n") != 3 {
+		t.Errorf("Expected every attempt to be echoed, got %q", stdout.String())
+	}
+}
+
+func TestRunCommandAttemptsRetriesOnMatchingOutput(t *testing.T) {
+	counter := writeTempFile(t, "attempts", "")
+	// The first attempt fails transiently, the second for real.
+	subprocess := exec.Command("sh", "-c", `echo x >> "$0"; if [ $(wc -l < "$0") -eq 1 ]; then echo "read: connection reset by peer"; else echo "2 tests failed"; fi; exit 1`, counter)
+	subprocess.Stdout = &bytes.Buffer{}
+
+	patterns, _ := compilePatterns("-retry-on-output", []string{"connection reset by peer", "download timed out"})
+	logs := withLogger(t, logInfo)
+	result := runCommandAttempts(subprocess, commandOptions{Retries: 5, RetryOnOutput: patterns})
+	if len(result.Attempts) != 2 {
+		t.Fatalf("Expected a retry only after the transient failure, got %+v", result.Attempts)
+	}
+	if result.Attempts[0].RetriedOn != "connection reset by peer" || result.Attempts[1].RetriedOn != "" {
+		t.Errorf("Expected the matching pattern in the attempt history, got %+v", result.Attempts)
+	}
+	if !strings.Contains(logs.String(), "not retrying, its output matches no -retry-on-output pattern") {
+		t.Errorf("Expected the unmatched failure to be logged, got %q", logs)
+	}
+}
+
+func TestRunCommandAttemptsRetriesOnListedExitCodes(t *testing.T) {
+	counter := writeTempFile(t, "attempts", "")
+	// The first attempt is killed like an OOM kill, the second fails tests.
+	subprocess := exec.Command("sh", "-c", `echo x >> "$0"; if [ $(wc -l < "$0") -eq 1 ]; then kill -KILL $$; fi; exit 1`, counter)
+
+	logs := withLogger(t, logInfo)
+	result := runCommandAttempts(subprocess, commandOptions{Retries: 5, RetryOnExitCodes: map[int]bool{2: true, 137: true}})
+	if len(result.Attempts) != 2 || result.ExitCode != 1 {
+		t.Fatalf("Expected a retry only after the kill, got exit code %d after %+v", result.ExitCode, result.Attempts)
+	}
+	if !strings.Contains(logs.String(), "not retrying, exit code 1 isn't one of -retry-command-on") {
+		t.Errorf("Expected the unlisted exit code to be logged, got %q", logs)
+	}
+}
+
+func TestRunCommandAttemptsCombinesExitCodesAndOutput(t *testing.T) {
+	patterns, _ := compilePatterns("-retry-on-output", []string{"connection reset"})
+	options := commandOptions{Retries: 2, RetryOnExitCodes: map[int]bool{2: true}, RetryOnOutput: patterns}
+	withLogger(t, logInfo)
+	for _, test := range []struct {
+		script   string
+		attempts int
+	}{
+		{"echo connection reset; exit 2", 3},
+		{"echo connection reset; exit 1", 1},
+		{"echo tests failed; exit 2", 1},
+	} {
+		result := runCommandAttempts(exec.Command("sh", "-c", test.script), options)
+		if len(result.Attempts) != test.attempts {
+			t.Errorf("Expected %d attempts for %q, got %+v", test.attempts, test.script, result.Attempts)
+		}
+	}
+}
+
+func TestRunCommandAttemptsGivesUp(t *testing.T) {
+	result := runCommandAttempts(exec.Command("sh", "-c", "exit 4"), commandOptions{Retries: 2})
+	if result.ExitCode != 4 || len(result.Attempts) != 3 {
+		t.Errorf("Expected three failed attempts, got exit code %d after %+v", result.ExitCode, result.Attempts)
+	}
+}
+
+func TestReadsTerminalIgnoresNullDevice(t *testing.T) {
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skipf("No null device: %s", err)
+	}
+	defer null.Close()
+	subprocess := exec.Command("true")
+	subprocess.Stdin = null
+	if readsTerminal(subprocess) {
+		t.Error("Expected the null device not to count as a terminal")
+	}
+}
@@ -0,0 +1,193 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+// subcommands are the words that select a subcommand instead of a command
+// to run.
+var subcommands = []string{"badge", "doctor", "pipeline", "verify", "completion"}
+
+// completionShells are the shells the completion subcommand writes scripts
+// for.
+var completionShells = []string{"bash", "zsh", "fish"}
+
+// enumValue is implemented by flag values that accept a fixed set of words,
+// so completion offers them.
+type enumValue interface {
+	completionValues() []string
+}
+
+// stringFlagValues lists the accepted words of plain string flags that
+// validate their value after parsing.
+var stringFlagValues = map[string][]string{
+	"container-runtime": {"docker", "podman"},
+	"log-format":        {"text", "json"},
+	"output":            {"text", "json"},
+}
+
+// completionFlag is a registered flag as completion needs it.
+type completionFlag struct {
+	Name        string
+	Description string
+	TakesValue  bool
+	Values      []string
+}
+
+// completionFlags describes every flag registered on flags, sorted by name.
+func completionFlags(flags *flag.FlagSet) []completionFlag {
+	var described []completionFlag
+	flags.VisitAll(func(f *flag.Flag) {
+		described = append(described, describeFlag(f))
+	})
+	sort.Slice(described, func(i, j int) bool { return described[i].Name < described[j].Name })
+	return described
+}
+
+func describeFlag(f *flag.Flag) completionFlag {
+	description := f.Usage
+	for _, prefix := range []string{"Optional: ", "Required: "} {
+		description = strings.TrimPrefix(description, prefix)
+	}
+	// The part before the first semicolon reads best as a one line summary.
+	description = strings.TrimSpace(strings.SplitN(description, ";", 2)[0])
+
+	described := completionFlag{Name: f.Name, Description: description, TakesValue: true}
+	if boolFlag, ok := f.Value.(interface{ IsBoolFlag() bool }); ok && boolFlag.IsBoolFlag() {
+		described.TakesValue = false
+	}
+	if enum, ok := f.Value.(enumValue); ok {
+		described.Values = enum.completionValues()
+	} else if values, ok := stringFlagValues[f.Name]; ok {
+		described.Values = values
+	}
+	return described
+}
+
+// writeCompletion writes the completion script for shell, generated from the
+// flags registered on flags.
+func writeCompletion(flags *flag.FlagSet, shell string, out io.Writer) error {
+	described := completionFlags(flags)
+	switch shell {
+	case "bash":
+		writeBashCompletion(described, out)
+	case "zsh":
+		writeZshCompletion(described, out)
+	case "fish":
+		writeFishCompletion(described, out)
+	default:
+		return fmt.Errorf("Error: completion needs a shell: %s, got %q", strings.Join(completionShells, ", "), shell)
+	}
+	return nil
+}
+
+func writeBashCompletion(flags []completionFlag, out io.Writer) {
+	var names, valueFlags []string
+	for _, f := range flags {
+		names = append(names, "-"+f.Name)
+		if f.TakesValue && f.Values == nil {
+			valueFlags = append(valueFlags, "-"+f.Name)
+		}
+	}
+
+	fmt.Fprintf(out, "# bash completion for gh-status-reporter\n")
+	fmt.Fprintf(out, "_gh_status_reporter() {\n")
+	fmt.Fprintf(out, "    local cur=\"${COMP_WORDS[COMP_CWORD]}\" prev=\"${COMP_WORDS[COMP_CWORD-1]}\"\n")
+	fmt.Fprintf(out, "    case \"$prev\" in\n")
+	fmt.Fprintf(out, "    completion)\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n        return ;;\n", strings.Join(completionShells, " "))
+	for _, f := range flags {
+		if f.TakesValue && f.Values != nil {
+			fmt.Fprintf(out, "    -%s)\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n        return ;;\n", f.Name, strings.Join(f.Values, " "))
+		}
+	}
+	if len(valueFlags) > 0 {
+		fmt.Fprintf(out, "    %s)\n        COMPREPLY=()\n        return ;;\n", strings.Join(valueFlags, "|"))
+	}
+	fmt.Fprintf(out, "    esac\n")
+	fmt.Fprintf(out, "    if [[ \"$cur\" == -* ]]; then\n        COMPREPLY=($(compgen -W %q -- \"$cur\"))\n", strings.Join(names, " "))
+	fmt.Fprintf(out, "    elif [[ $COMP_CWORD -eq 1 ]]; then\n        COMPREPLY=($(compgen -W %q -- \"$cur\") $(compgen -c -- \"$cur\"))\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(out, "    else\n        COMPREPLY=($(compgen -c -- \"$cur\"))\n    fi\n")
+	fmt.Fprintf(out, "}\n")
+	fmt.Fprintf(out, "complete -o default -F _gh_status_reporter gh-status-reporter\n")
+}
+
+// zshEscape escapes text for a zsh _arguments description.
+func zshEscape(text string) string {
+	return strings.NewReplacer("'", `'\''`, "[", `\[`, "]", `\]`, ":", `\:`).Replace(text)
+}
+
+func writeZshCompletion(flags []completionFlag, out io.Writer) {
+	fmt.Fprintf(out, "#compdef gh-status-reporter\n\n")
+	fmt.Fprintf(out, "_gh_status_reporter() {\n")
+	fmt.Fprintf(out, "    local state\n")
+	fmt.Fprintf(out, "    _arguments \\\n")
+	for _, f := range flags {
+		spec := "-" + f.Name + "[" + zshEscape(f.Description) + "]"
+		switch {
+		case f.Values != nil:
+			spec += ":" + f.Name + ":(" + strings.Join(f.Values, " ") + ")"
+		case f.TakesValue:
+			spec += ":" + f.Name + ":_files"
+		}
+		fmt.Fprintf(out, "        '%s' \\\n", spec)
+	}
+	fmt.Fprintf(out, "        '1: :->first' \\\n")
+	fmt.Fprintf(out, "        '*: :_files'\n")
+	fmt.Fprintf(out, "    case $state in\n")
+	fmt.Fprintf(out, "    first)\n")
+	fmt.Fprintf(out, "        _alternative 'subcommands:subcommand:(%s)' 'commands:command:_command_names -e' ;;\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(out, "    esac\n")
+	fmt.Fprintf(out, "}\n\n")
+	fmt.Fprintf(out, "compdef _gh_status_reporter gh-status-reporter\n")
+}
+
+func writeFishCompletion(flags []completionFlag, out io.Writer) {
+	quote := strings.NewReplacer(`\`, `\\`, "'", `\'`)
+	fmt.Fprintf(out, "# fish completion for gh-status-reporter\n")
+	fmt.Fprintf(out, "complete -c gh-status-reporter -n '__fish_use_subcommand' -a '%s'\n", strings.Join(subcommands, " "))
+	fmt.Fprintf(out, "complete -c gh-status-reporter -n '__fish_seen_subcommand_from completion' -x -a '%s'\n", strings.Join(completionShells, " "))
+	for _, f := range flags {
+		line := fmt.Sprintf("complete -c gh-status-reporter -o %s -d '%s'", f.Name, quote.Replace(f.Description))
+		switch {
+		case f.Values != nil:
+			line += " -x -a '" + strings.Join(f.Values, " ") + "'"
+		case f.TakesValue:
+			line += " -r"
+		}
+		fmt.Fprintf(out, "%s\n", line)
+	}
+}
+
+func isSubcommand(word string) bool {
+	for _, subcommand := range subcommands {
+		if word == subcommand {
+			return true
+		}
+	}
+	return false
+}
+
+// usage prints the flags followed by the subcommands and how to enable
+// completion.
+func usage() {
+	out := flag.CommandLine.Output()
+	fmt.Fprintf(out, "Usage of %s:\n", flag.CommandLine.Name())
+	flag.PrintDefaults()
+	fmt.Fprintf(out, `
+Subcommands:
+  badge       Write a badge of the context's current status
+  doctor      Check the configuration, token and connection to Github
+  pipeline    Run the stages of the -f pipeline file, each reporting its own status
+  verify      Check once whether the context's current state passes
+  completion  Print a completion script for bash, zsh or fish
+
+To enable completion, add one of these to your shell's startup file:
+  bash: source <(gh-status-reporter completion bash)
+  zsh:  source <(gh-status-reporter completion zsh)
+  fish: gh-status-reporter completion fish | source
+`)
+}
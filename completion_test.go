@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestCompletionBashParses(t *testing.T) {
+	if _, err := exec.LookPath("bash"); err != nil {
+		t.Skip("bash is not installed")
+	}
+	out, code := runCLI(t, "completion", "bash")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out)
+	}
+	for _, expected := range []string{"-status-context-suffix)", `"inherit null close"`, `"badge doctor pipeline verify completion"`, " -dry-run "} {
+		if !strings.Contains(out, expected) {
+			t.Errorf("Expected %q in the bash script", expected)
+		}
+	}
+
+	script := writeTempFile(t, "completion.bash", out)
+	if output, err := exec.Command("bash", "-n", script).CombinedOutput(); err != nil {
+		t.Errorf("Expected the bash script to parse, got %s: %s", err, output)
+	}
+}
+
+func TestCompletionFromFlagSet(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	flags.Bool("dry-run", false, "Optional: Print the statuses instead of posting them")
+	flags.String("c", "", "Required: Github status context; use -allow-empty-context to omit it")
+	var stdin stdinMode
+	flags.Var(&stdin, "stdin", "Optional: The command's stdin")
+	var timestamps timestampMode
+	flags.Var(&timestamps, "timestamps", "Optional: Prefix lines with timestamps")
+
+	var fish bytes.Buffer
+	if err := writeCompletion(flags, "fish", &fish); err != nil {
+		t.Fatal(err)
+	}
+	for _, expected := range []string{
+		"complete -c gh-status-reporter -o c -d 'Github status context' -r\n",
+		"complete -c gh-status-reporter -o dry-run -d 'Print the statuses instead of posting them'\n",
+		"complete -c gh-status-reporter -o stdin -d 'The command\\'s stdin' -x -a 'inherit null close'\n",
+		"complete -c gh-status-reporter -o timestamps -d 'Prefix lines with timestamps'\n",
+	} {
+		if !strings.Contains(fish.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, fish.String())
+		}
+	}
+
+	var zsh bytes.Buffer
+	writeCompletion(flags, "zsh", &zsh)
+	if !strings.Contains(zsh.String(), `'-stdin[The command'\''s stdin]:stdin:(inherit null close)'`) {
+		t.Errorf("Expected the stdin values in:\n%s", zsh.String())
+	}
+
+	if err := writeCompletion(flags, "ksh", &zsh); err == nil {
+		t.Errorf("Expected an unsupported shell to be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// containerStatusEnv are passed into the container when set, alongside the
+// variables from -env-file and -env. The rest of the host environment,
+// including the BUILD_* configuration, stays outside.
+var containerStatusEnv = []string{"STATUS_PR_NUMBER", "STATUS_PR_URL", "STATUS_PR_NUMBERS", "STATUS_PR_URLS",
+	"STATUS_CONTEXT", "STATUS_SHA", "STATUS_ORG_REPO", "STATUS_TARGET_URL"}
+
+// validateContainerFlags checks -container-runtime.
+func validateContainerFlags(flags Flags) error {
+	switch flags.ContainerRuntime {
+	case "docker", "podman":
+		return nil
+	}
+	return fmt.Errorf("Error: -container-runtime must be docker or podman, got %q", flags.ContainerRuntime)
+}
+
+// curatedEnvNames returns the names of the variables env sets or changes
+// compared to base.
+func curatedEnvNames(base, env []string) []string {
+	var names []string
+	for _, entry := range env {
+		name := strings.SplitN(entry, "=", 2)[0]
+		if value, ok := lookupEnv(base, name); ok && name+"="+value == entry {
+			continue
+		}
+		names = append(names, name)
+	}
+	return names
+}
+
+// containerName returns a name for the container that is unique enough to
+// kill it later.
+func containerName() string {
+	return fmt.Sprintf("gh-status-reporter-%d-%d", os.Getpid(), time.Now().UnixNano())
+}
+
+// containerArgs builds the runtime arguments that run command in
+// flags.Image with the working directory mounted at the same path. Variables
+// are passed by name only, so their values never show up in the process list.
+func containerArgs(flags Flags, name, workdir string, envNames []string, command []string) []string {
+	args := []string{"run", "--rm", "-i", "--name", name, "-v", workdir + ":" + workdir, "-w", workdir}
+	for _, volume := range flags.Volumes {
+		args = append(args, "-v", volume)
+	}
+	for _, envName := range append(envNames, containerStatusEnv...) {
+		args = append(args, "-e", envName)
+	}
+	args = append(args, flags.DockerArgs...)
+	args = append(args, flags.Image)
+	return append(args, command...)
+}
+
+// containerCommand wraps command so it runs in flags.Image. The returned
+// kill function stops the container, which killing the runtime client alone
+// doesn't do.
+func containerCommand(flags Flags, baseEnv, env []string, command []string) (*exec.Cmd, func(), error) {
+	workdir, err := os.Getwd()
+	if err != nil {
+		return nil, nil, fmt.Errorf("Error finding the working directory: %s", err)
+	}
+	name := containerName()
+	subprocess := exec.Command(flags.ContainerRuntime, containerArgs(flags, name, workdir, curatedEnvNames(baseEnv, env), command)...)
+	kill := func() {
+		exec.Command(flags.ContainerRuntime, "kill", name).Run()
+	}
+	return subprocess, kill, nil
+}
+
+// Exit codes docker and podman use for failures of the runtime itself
+// rather than of the command.
+const (
+	containerRuntimeFailed = 125
+	containerCannotInvoke  = 126
+	containerNotFound      = 127
+	containerKilled        = 137
+)
+
+// interpretContainerExit translates container specific exit codes. Runtime
+// failures mean the command never ran, which is reported as an error rather
+// than a failure; 137 means the container was killed with SIGKILL, often
+// for running out of memory.
+func interpretContainerExit(result *commandResult) {
+	switch result.ExitCode {
+	case containerRuntimeFailed:
+		result.Err, result.Errored = errors.New("the container runtime failed to start the container"), true
+	case containerCannotInvoke:
+		result.Err, result.Errored = errors.New("the command in the container could not be invoked"), true
+	case containerNotFound:
+		result.Err, result.Errored = errors.New("the command was not found in the container"), true
+	case containerKilled:
+		if !result.TimedOut {
+			result.Err = errors.New("the container was killed by SIGKILL (exit code 137), possibly for running out of memory")
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestContainerArgs(t *testing.T) {
+	flags := defaultFlags()
+	flags.Image = "golang:1.22"
+	flags.Volumes = []string{"/cache:/cache"}
+	flags.DockerArgs = []string{"--network=host"}
+
+	args := containerArgs(*flags, "ghsr-1", "/src", []string{"GOFLAGS"}, []string{"go", "test", "./..."})
+	expected := "run --rm -i --name ghsr-1 -v /src:/src -w /src -v /cache:/cache -e GOFLAGS" +
+		" -e STATUS_PR_NUMBER -e STATUS_PR_URL -e STATUS_PR_NUMBERS -e STATUS_PR_URLS" +
+		" -e STATUS_CONTEXT -e STATUS_SHA -e STATUS_ORG_REPO -e STATUS_TARGET_URL" +
+		" --network=host golang:1.22 go test ./..."
+	if strings.Join(args, " ") != expected {
+		t.Errorf("Expected %q, got %q", expected, strings.Join(args, " "))
+	}
+}
+
+func TestCuratedEnvNames(t *testing.T) {
+	base := []string{"PATH=/bin", "HOME=/root", "MODE=dev"}
+	env := []string{"PATH=/bin", "HOME=/root", "MODE=prod", "EXTRA=1"}
+	if names := curatedEnvNames(base, env); strings.Join(names, ",") != "MODE,EXTRA" {
+		t.Errorf("Expected only changed and added variables, got %q", names)
+	}
+}
+
+func TestInterpretContainerExit(t *testing.T) {
+	for code, expected := range map[int]string{125: "error", 126: "error", 127: "error", 137: "failure", 1: "failure"} {
+		result := &commandResult{ExitCode: code, Err: &os.PathError{}}
+		interpretContainerExit(result)
+		if state := commandState(result); state != expected {
+			t.Errorf("Expected exit code %d to be reported as %s, got %s", code, expected, state)
+		}
+	}
+
+	killed := &commandResult{ExitCode: 137, Err: &os.PathError{}}
+	interpretContainerExit(killed)
+	if !strings.Contains(killed.Err.Error(), "SIGKILL") {
+		t.Errorf("Expected exit code 137 to be explained, got %s", killed.Err)
+	}
+}
+
+func TestValidateContainerFlags(t *testing.T) {
+	flags := defaultFlags()
+	for runtime, ok := range map[string]bool{"docker": true, "podman": true, "nerdctl": false, "": false} {
+		flags.ContainerRuntime = runtime
+		if err := validateContainerFlags(*flags); (err == nil) != ok {
+			t.Errorf("Unexpected validation result for %q: %v", runtime, err)
+		}
+	}
+}
+
+// withFakeRuntime puts a fake docker on PATH that logs its arguments and,
+// for run, executes the command after the image.
+func withFakeRuntime(t *testing.T) (string, func()) {
+	dir := t.TempDir()
+	log := filepath.Join(dir, "docker.log")
+	script := `#!/bin/sh
+echo "$@" >> "` + log + `"
+[ "$1" = kill ] && exit 0
+while [ "$1" != "image" ]; do shift; done
+shift
+exec "$@"
+`
+	ioutil.WriteFile(filepath.Join(dir, "docker"), []byte(script), 0755)
+	original := os.Getenv("PATH")
+	os.Setenv("PATH", dir+string(os.PathListSeparator)+original)
+	return log, func() {
+		os.Setenv("PATH", original)
+	}
+}
+
+func TestContainerCommandRunsAndKills(t *testing.T) {
+	log, restore := withFakeRuntime(t)
+	defer restore()
+
+	flags := defaultFlags()
+	flags.Image = "image"
+	flags.ContainerRuntime = "docker"
+	subprocess, kill, err := containerCommand(*flags, nil, nil, []string{"sh", "-c", "exit 127"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	result := runCommandAttempts(subprocess, commandOptions{Container: true, Kill: kill})
+	if commandState(result) != "error" {
+		t.Errorf("Expected a missing command in the container to be an error, got %s", commandState(result))
+	}
+
+	subprocess, kill, _ = containerCommand(*flags, nil, nil, []string{"sleep", "5"})
+	result = runCommandAttempts(subprocess, commandOptions{Container: true, Kill: kill, Timeout: 100 * time.Millisecond})
+	if !result.TimedOut {
+		t.Errorf("Expected the command to time out")
+	}
+	contents, _ := ioutil.ReadFile(log)
+	if !strings.Contains(string(contents), "kill gh-status-reporter-") {
+		t.Errorf("Expected the container to be killed, got:\n%s", contents)
+	}
+}
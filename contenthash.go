@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// contentHashPattern matches -content-hash: a hex digest, optionally with
+// its algorithm as in sha256:....
+var contentHashPattern = regexp.MustCompile(`^([a-z0-9]+:)?[0-9a-fA-F]{12,128}$`)
+
+// contentMarkerPattern finds the short content hash statusDescription adds.
+var contentMarkerPattern = regexp.MustCompile(`\(content ([0-9a-f]{12})\)$`)
+
+// shortContentHash returns the first 12 hex digits of -content-hash, which
+// is what the description and context carry.
+func shortContentHash(hash string) string {
+	if i := strings.Index(hash, ":"); i >= 0 {
+		hash = hash[i+1:]
+	}
+	hash = strings.ToLower(hash)
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	return hash
+}
+
+// contentMarker is the description suffix recording the content hash.
+func contentMarker(hash string) string {
+	return "(content " + shortContentHash(hash) + ")"
+}
+
+// validateContentHashFlags checks -content-hash and -skip-if-unchanged.
+func validateContentHashFlags(flags Flags) error {
+	if flags.ContentHash == "" {
+		if flags.SkipIfUnchanged {
+			return errors.New("Error: -skip-if-unchanged requires -content-hash")
+		}
+		if flags.ContextSuffix == contextSuffixContentHash {
+			return errors.New("Error: -status-context-suffix=content-hash requires -content-hash")
+		}
+		return nil
+	}
+	if !contentHashPattern.MatchString(flags.ContentHash) {
+		return fmt.Errorf("Error: -content-hash must be a hex digest of at least 12 digits, optionally prefixed with its algorithm as in sha256:..., got %q", flags.ContentHash)
+	}
+	return nil
+}
+
+// contentUnchanged reports whether every target already has a success
+// status on the context for the same -content-hash, so running the command
+// again would only repeat a build of identical content.
+func contentUnchanged(targets []statusTarget, flags Flags) (bool, error) {
+	short := shortContentHash(flags.ContentHash)
+	for _, target := range targets {
+		current, err := getCombinedStatus(target, flags)
+		if err != nil {
+			return false, err
+		}
+		context := providerFlags(flags, target.OrgRepo).Context
+		existing := current.find(context)
+		if existing == nil || existing.State != "success" {
+			return false, nil
+		}
+		match := contentMarkerPattern.FindStringSubmatch(existing.Description)
+		if match == nil || match[1] != short {
+			logger.Debugf("%s on %s@%s passed for other content, running the command", context, target.OrgRepo, target.SHA)
+			return false, nil
+		}
+	}
+	return true, nil
+}
@@ -0,0 +1,82 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const testContentHash = "sha256:9F86D081884C7D659A2FEAA0C55AD015A3BF4F1B2B0B822CD15D6C15B0F00A08"
+
+func TestStatusDescriptionContentHash(t *testing.T) {
+	flags := defaultFlags()
+	flags.ContentHash, flags.ReporterID = testContentHash, "ci-runner-7"
+	if description := statusDescription(*flags, "success"); description != "unit test [ci-runner-7] (content 9f86d081884c)" {
+		t.Errorf("Expected the content hash at the end, got %q", description)
+	}
+	flags.Description = strings.Repeat("x", 200)
+	if description := statusDescription(*flags, "success"); !strings.HasSuffix(description, "(content 9f86d081884c)") {
+		t.Errorf("Expected a long description to keep the content hash, got %q", description)
+	}
+}
+
+func TestContentHashContextSuffix(t *testing.T) {
+	flags := defaultFlags()
+	flags.ContentHash, flags.ContextSuffix = testContentHash, contextSuffixContentHash
+	if err := applyContextSuffix(flags, nil); err != nil || flags.Context != "ci@9f86d081884c" {
+		t.Errorf("Expected the short hash in the context, got %q %v", flags.Context, err)
+	}
+}
+
+func TestValidateContentHashFlags(t *testing.T) {
+	for _, test := range []struct {
+		flags   Flags
+		message string
+	}{
+		{Flags{}, ""},
+		{Flags{ContentHash: testContentHash, SkipIfUnchanged: true}, ""},
+		{Flags{ContentHash: "9f86d081884c7d65"}, ""},
+		{Flags{SkipIfUnchanged: true}, "-skip-if-unchanged requires -content-hash"},
+		{Flags{ContextSuffix: contextSuffixContentHash}, "-status-context-suffix=content-hash requires -content-hash"},
+		{Flags{ContentHash: "abc123"}, "-content-hash must be a hex digest"},
+		{Flags{ContentHash: "sha256:not-hex-at-all"}, "-content-hash must be a hex digest"},
+	} {
+		err := validateContentHashFlags(test.flags)
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %+v, got %v", test.message, test.flags, err)
+		}
+	}
+}
+
+func TestContentUnchanged(t *testing.T) {
+	var existing []map[string]string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"state": "success", "statuses": existing})
+	})()
+
+	flags := defaultFlags()
+	flags.ContentHash, flags.SkipIfUnchanged = testContentHash, true
+	targets := []statusTarget{{flags.OrgRepo, flags.SHA}}
+	for _, test := range []struct {
+		name     string
+		statuses []map[string]string
+		expected bool
+	}{
+		{"no status", nil, false},
+		{"same content passed", []map[string]string{{"context": "ci", "state": "success", "description": "unit test (content 9f86d081884c)"}}, true},
+		{"same content failed", []map[string]string{{"context": "ci", "state": "failure", "description": "unit test (content 9f86d081884c)"}}, false},
+		{"other content passed", []map[string]string{{"context": "ci", "state": "success", "description": "unit test (content 0123456789ab)"}}, false},
+		{"no content hash", []map[string]string{{"context": "ci", "state": "success", "description": "unit test"}}, false},
+		{"other context", []map[string]string{{"context": "lint", "state": "success", "description": "unit test (content 9f86d081884c)"}}, false},
+	} {
+		existing = test.statuses
+		unchanged, err := contentUnchanged(targets, *flags)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		if unchanged != test.expected {
+			t.Errorf("Expected %v for %s, got %v", test.expected, test.name, unchanged)
+		}
+	}
+}
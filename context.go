@@ -0,0 +1,255 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxContextLength is the longest context Github accepts.
+const maxContextLength = 255
+
+// contextSuffixMode is the value of the -status-context-suffix flag: what
+// distinguishes the contexts of different runs of the same check.
+type contextSuffixMode string
+
+const (
+	contextSuffixOff       contextSuffixMode = ""
+	contextSuffixAttempt   contextSuffixMode = "attempt"
+	contextSuffixCommitter contextSuffixMode = "committer"
+	// contextSuffixContentHash adds the short -content-hash.
+	contextSuffixContentHash contextSuffixMode = "content-hash"
+)
+
+func (m *contextSuffixMode) String() string {
+	return string(*m)
+}
+
+func (m *contextSuffixMode) Set(value string) error {
+	switch contextSuffixMode(value) {
+	case contextSuffixAttempt, contextSuffixCommitter, contextSuffixContentHash:
+		*m = contextSuffixMode(value)
+	default:
+		return fmt.Errorf("expected attempt, committer or content-hash, got %q", value)
+	}
+	return nil
+}
+
+func (m *contextSuffixMode) completionValues() []string {
+	return []string{"attempt", "committer", "content-hash"}
+}
+
+// gitCommitter returns the committer name of sha in the working directory.
+func gitCommitter(sha string) (string, error) {
+	out, err := exec.Command("git", "show", "-s", "--format=%cn", sha).Output()
+	if err != nil {
+		return "", fmt.Errorf("Error: can't read the committer of %s for -status-context-suffix=committer", sha)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// contextSuffix returns the suffix -status-context-suffix adds to the
+// context: #N for the run attempt, the committer's name in parentheses, or
+// @ and the short content hash.
+func contextSuffix(flags Flags, committer func(string) (string, error)) (string, error) {
+	switch flags.ContextSuffix {
+	case contextSuffixAttempt:
+		attempt, err := strconv.Atoi(flags.RunAttempt)
+		if err != nil || attempt < 1 {
+			return "", fmt.Errorf("Error: -status-context-suffix=attempt needs a run attempt number from -run-attempt or GITHUB_RUN_ATTEMPT, got %q", flags.RunAttempt)
+		}
+		return "#" + strconv.Itoa(attempt), nil
+	case contextSuffixCommitter:
+		name, err := committer(flags.SHA)
+		if err != nil {
+			return "", err
+		}
+		return " (" + name + ")", nil
+	case contextSuffixContentHash:
+		return "@" + shortContentHash(flags.ContentHash), nil
+	}
+	return "", nil
+}
+
+// applyContextSuffix adds the -status-context-suffix suffix to flags.Context
+// and checks the result still fits Github's limit.
+func applyContextSuffix(flags *Flags, committer func(string) (string, error)) error {
+	suffix, err := contextSuffix(*flags, committer)
+	if err != nil || suffix == "" {
+		return err
+	}
+	context := flags.Context + suffix
+	if length := utf8.RuneCountInString(context); length > maxContextLength {
+		return fmt.Errorf("Error: context %q is %d characters with -status-context-suffix, more than Github's %d", context, length, maxContextLength)
+	}
+	flags.Context = context
+	return nil
+}
+
+// validateContext checks context against what Github accepts, so a bad value
+// fails at startup rather than with a 422 once the command has finished.
+func validateContext(context string) error {
+	if strings.TrimSpace(context) == "" {
+		return fmt.Errorf("Error: context %q is blank", context)
+	}
+	if length := utf8.RuneCountInString(context); length > maxContextLength {
+		return fmt.Errorf("Error: context %q is %d characters, more than Github's %d", context, length, maxContextLength)
+	}
+	for _, r := range context {
+		if unicode.IsControl(r) {
+			return fmt.Errorf("Error: context %q contains a newline or control character", context)
+		}
+	}
+	return nil
+}
+
+// contextWhitespaceWarning returns a warning when context has leading or
+// trailing whitespace, which branch protection rules silently fail to match.
+func contextWhitespaceWarning(context string) string {
+	if context != strings.TrimSpace(context) {
+		return fmt.Sprintf("context %q has leading or trailing whitespace; branch protection rules won't match it unless they have the same", context)
+	}
+	return ""
+}
+
+// sanitizeContext trims context, collapses runs of whitespace inside it to a
+// single space and strips control characters, for -sanitize-context. Tabs
+// and newlines count as whitespace rather than being stripped, so
+// "unit\ttests" becomes "unit tests".
+func sanitizeContext(context string) string {
+	var b strings.Builder
+	space := false
+	for _, r := range context {
+		switch {
+		case unicode.IsSpace(r):
+			space = true
+		case unicode.IsControl(r):
+		default:
+			if space && b.Len() > 0 {
+				b.WriteByte(' ')
+			}
+			space = false
+			b.WriteRune(r)
+		}
+	}
+	return b.String()
+}
+
+// applySanitizeContext replaces flags.Context with its sanitized form,
+// logging the change so a renamed required check can be traced.
+func applySanitizeContext(flags *Flags) {
+	if sanitized := sanitizeContext(flags.Context); sanitized != flags.Context {
+		logger.Infof("Sanitized context %q to %q", flags.Context, sanitized)
+		flags.Context = sanitized
+	}
+}
+
+// pluginsProvider is the -context-map provider for notify plugin events;
+// every other provider is a repository.
+const pluginsProvider = "plugins"
+
+// parseContextMap parses -context-map: comma separated provider:from=to
+// entries, or a JSON object of provider to {from: to}. Mapped contexts must be
+// valid for their provider.
+func parseContextMap(value string) (map[string]map[string]string, error) {
+	mappings := map[string]map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		if err := json.Unmarshal([]byte(value), &mappings); err != nil {
+			return nil, fmt.Errorf("Error: invalid -context-map JSON: %s", err)
+		}
+	} else {
+		for _, entry := range strings.Split(value, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			parts := strings.SplitN(strings.TrimSpace(entry), ":", 2)
+			if len(parts) != 2 || parts[0] == "" || !strings.Contains(parts[1], "=") || strings.HasPrefix(parts[1], "=") {
+				return nil, fmt.Errorf("Error: -context-map entry %q is not in the form provider:from=to", entry)
+			}
+			provider, mapping := parts[0], strings.SplitN(parts[1], "=", 2)
+			if mappings[provider] == nil {
+				mappings[provider] = map[string]string{}
+			}
+			mappings[provider][mapping[0]] = mapping[1]
+		}
+	}
+
+	var errs multiError
+	for provider, contexts := range mappings {
+		if provider != pluginsProvider && !orgRepoPattern.MatchString(provider) {
+			errs = append(errs, fmt.Errorf("Error: -context-map provider %q is neither a repository nor %s", provider, pluginsProvider))
+			continue
+		}
+		for from, to := range contexts {
+			err := validateContext(to)
+			if provider == pluginsProvider {
+				// Plugins have no limits beyond a context being there.
+				err = nil
+				if strings.TrimSpace(to) == "" {
+					err = fmt.Errorf("Error: context %q is blank", to)
+				}
+			}
+			if err != nil {
+				errs = append(errs, fmt.Errorf("Error: -context-map %s:%s: %s", provider, from, strings.TrimPrefix(err.Error(), "Error: ")))
+			}
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return mappings, nil
+}
+
+// providerFlags returns flags with the context renamed by -context-map for
+// provider.
+func providerFlags(flags Flags, provider string) Flags {
+	if to, ok := flags.ContextMappings[provider][flags.Context]; ok {
+		flags.Context = to
+	}
+	return flags
+}
+
+// parseContextDescriptions parses -context-descriptions: comma separated
+// context=description entries, split at the first = so descriptions may
+// contain more, or a JSON object of context to description for descriptions
+// with commas. Each description must fit in a commit status.
+func parseContextDescriptions(value string) (map[string]string, error) {
+	descriptions := map[string]string{}
+	if strings.HasPrefix(strings.TrimSpace(value), "{") {
+		if err := json.Unmarshal([]byte(value), &descriptions); err != nil {
+			return nil, fmt.Errorf("Error: invalid -context-descriptions JSON: %s", err)
+		}
+	} else {
+		for _, entry := range strings.Split(value, ",") {
+			if strings.TrimSpace(entry) == "" {
+				continue
+			}
+			parts := strings.SplitN(entry, "=", 2)
+			if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+				return nil, fmt.Errorf("Error: -context-descriptions entry %q is not in the form context=description", entry)
+			}
+			descriptions[strings.TrimSpace(parts[0])] = strings.TrimSpace(parts[1])
+		}
+	}
+
+	contexts := make([]string, 0, len(descriptions))
+	for context := range descriptions {
+		contexts = append(contexts, context)
+	}
+	sort.Strings(contexts)
+	var errs multiError
+	for _, context := range contexts {
+		if length := utf8.RuneCountInString(descriptions[context]); length > maxDescriptionLength {
+			errs = append(errs, fmt.Errorf("Error: -context-descriptions %s: description is %d characters, longer than the %d GitHub accepts", context, length, maxDescriptionLength))
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return descriptions, nil
+}
@@ -0,0 +1,233 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func fakeCommitter(name string) func(string) (string, error) {
+	return func(string) (string, error) { return name, nil }
+}
+
+func TestApplyContextSuffix(t *testing.T) {
+	for _, test := range []struct {
+		mode     contextSuffixMode
+		attempt  string
+		expected string
+	}{
+		{contextSuffixOff, "2", "ci"},
+		{contextSuffixAttempt, "2", "ci#2"},
+		{contextSuffixAttempt, "1", "ci#1"},
+		{contextSuffixCommitter, "", "ci (Jane Doe)"},
+	} {
+		flags := defaultFlags()
+		flags.ContextSuffix = test.mode
+		flags.RunAttempt = test.attempt
+		if err := applyContextSuffix(flags, fakeCommitter("Jane Doe")); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		if flags.Context != test.expected {
+			t.Errorf("Expected context %q for %q, got %q", test.expected, test.mode, flags.Context)
+		}
+	}
+}
+
+func TestApplyContextSuffixErrors(t *testing.T) {
+	flags := defaultFlags()
+	flags.ContextSuffix = contextSuffixAttempt
+	if err := applyContextSuffix(flags, fakeCommitter("")); err == nil {
+		t.Errorf("Expected an error without a run attempt")
+	}
+
+	flags.RunAttempt = "3"
+	flags.Context = strings.Repeat("c", maxContextLength-1)
+	if err := applyContextSuffix(flags, fakeCommitter("")); err == nil {
+		t.Errorf("Expected an error for a context over %d characters", maxContextLength)
+	}
+
+	flags = defaultFlags()
+	flags.ContextSuffix = contextSuffixCommitter
+	failing := func(string) (string, error) { return "", errors.New("Error: no git") }
+	if err := applyContextSuffix(flags, failing); err == nil || flags.Context != "ci" {
+		t.Errorf("Expected the committer lookup error and an unchanged context, got %v %q", err, flags.Context)
+	}
+}
+
+func TestContextSuffixModeSet(t *testing.T) {
+	var mode contextSuffixMode
+	if err := mode.Set("attempt"); err != nil || mode != contextSuffixAttempt {
+		t.Errorf("Expected attempt to be accepted, got %q %v", mode, err)
+	}
+	if err := mode.Set("author"); err == nil {
+		t.Errorf("Expected author to be rejected")
+	}
+}
+
+func TestValidateContext(t *testing.T) {
+	for _, context := range []string{"ci", "ci/test (linux)", " ci ", strings.Repeat("c", maxContextLength)} {
+		if err := validateContext(context); err != nil {
+			t.Errorf("Expected %q to be valid, got %s", context, err)
+		}
+	}
+	for _, context := range []string{"  ", "ci\ntest", "ci\x00", strings.Repeat("c", maxContextLength+1)} {
+		if err := validateContext(context); err == nil {
+			t.Errorf("Expected %q to be rejected", context)
+		}
+	}
+	err := validateContext("ci\ttest")
+	if err == nil || !strings.Contains(err.Error(), `"ci\ttest"`) {
+		t.Errorf("Expected the offending context to be shown escaped, got %v", err)
+	}
+}
+
+func TestContextWhitespaceWarning(t *testing.T) {
+	if warning := contextWhitespaceWarning("ci/test "); !strings.Contains(warning, "has leading or trailing whitespace") {
+		t.Errorf("Expected a warning for trailing whitespace, got %q", warning)
+	}
+	if warning := contextWhitespaceWarning("ci/test"); warning != "" {
+		t.Errorf("Expected no warning, got %q", warning)
+	}
+}
+
+func TestParseContextMap(t *testing.T) {
+	expected := map[string]map[string]string{
+		"org/a":   {"ci/test": "ci-test", "ci/lint": "lint"},
+		"plugins": {"ci/test": "test"},
+	}
+	for _, value := range []string{
+		"org/a:ci/test=ci-test, org/a:ci/lint=lint,plugins:ci/test=test",
+		`{"org/a": {"ci/test": "ci-test", "ci/lint": "lint"}, "plugins": {"ci/test": "test"}}`,
+	} {
+		mappings, err := parseContextMap(value)
+		if err != nil {
+			t.Fatalf("Got unexpected error for %q: %s", value, err)
+		}
+		if !reflect.DeepEqual(mappings, expected) {
+			t.Errorf("Expected %v for %q, got %v", expected, value, mappings)
+		}
+	}
+}
+
+func TestParseContextMapRejectsInvalid(t *testing.T) {
+	for value, message := range map[string]string{
+		"org/a:ci/test":                             "not in the form provider:from=to",
+		"ci/test=ci-test":                           "not in the form provider:from=to",
+		"gitlab:ci/test=ci-test":                    `provider "gitlab" is neither a repository nor plugins`,
+		"org/a:ci/test=" + strings.Repeat("c", 256): "more than Github's 255",
+		"org/a:ci/test=ci\ntest":                    "control character",
+		"plugins:ci/test= ":                         "is blank",
+		`{"org/a": ["ci-test"]}`:                    "invalid -context-map JSON",
+	} {
+		if _, err := parseContextMap(value); err == nil || !strings.Contains(err.Error(), message) {
+			t.Errorf("Expected an error containing %q for %q, got %v", message, value, err)
+		}
+	}
+}
+
+func TestContextMapAppliedPerProvider(t *testing.T) {
+	var mu sync.Mutex
+	contexts := map[string]string{}
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		contexts[strings.Split(r.URL.Path, "/")[3]] = params.Context
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Context = "ci/test"
+	flags.ContextMappings, _ = parseContextMap("org/internal:ci/test=ci-test,plugins:ci/test=test")
+	targets := []statusTarget{{"org/public", "deadbeef"}, {"org/internal", "deadbeef"}}
+	if err := postStatus(targets, *flags, "pending"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if contexts["public"] != "ci/test" || contexts["internal"] != "ci-test" {
+		t.Errorf("Expected only org/internal's context to be renamed, got %v", contexts)
+	}
+	if event := newStatusEvent(providerFlags(*flags, pluginsProvider), targets[0], "pending", nil); event.Context != "test" {
+		t.Errorf("Expected the plugins mapping for plugin events, got %q", event.Context)
+	}
+	if other := providerFlags(*flags, "org/public"); other.Context != "ci/test" {
+		t.Errorf("Expected unmapped providers to keep the context, got %q", other.Context)
+	}
+}
+
+func TestSanitizeContext(t *testing.T) {
+	for context, expected := range map[string]string{
+		"ci/test\n":           "ci/test",
+		"\tci/test\r\n":       "ci/test",
+		"ci/unit\ttests":      "ci/unit tests",
+		"ci/unit \t\n  tests": "ci/unit tests",
+		"ci\x00/te\x7fst":     "ci/test",
+		"ci/test (linux)":     "ci/test (linux)",
+		" \t\n":               "",
+	} {
+		if sanitized := sanitizeContext(context); sanitized != expected {
+			t.Errorf("Expected %q to become %q, got %q", context, expected, sanitized)
+		}
+	}
+}
+
+func TestApplySanitizeContext(t *testing.T) {
+	out := withLogger(t, logInfo)
+	flags := defaultFlags()
+	flags.Context = "ci/test\n"
+	applySanitizeContext(flags)
+	if flags.Context != "ci/test" || !strings.Contains(out.String(), `Sanitized context "ci/test\n" to "ci/test"`) {
+		t.Errorf("Expected the change to be logged, got %q and:\n%s", flags.Context, out)
+	}
+	out.Reset()
+	applySanitizeContext(flags)
+	if out.Len() != 0 {
+		t.Errorf("Expected nothing to be logged for a clean context, got:\n%s", out)
+	}
+}
+
+func TestValidateFlagsSanitizeContext(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = "ci/unit\ttests\n"
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil {
+		t.Errorf("Expected a control character to be rejected without -sanitize-context")
+	}
+	flags.SanitizeContext = true
+	if err := validateFlags(*flags, []string{"true"}, ""); err != nil {
+		t.Errorf("Expected -sanitize-context to accept the context, got %s", err)
+	}
+	flags.Context = "\n\t"
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "is blank") {
+		t.Errorf("Expected a context that sanitizes to nothing to be rejected, got %v", err)
+	}
+}
+
+func TestParseContextDescriptions(t *testing.T) {
+	descriptions, err := parseContextDescriptions(" ci/lint=Linting , ci/test=Tests: a=b,,")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if expected := map[string]string{"ci/lint": "Linting", "ci/test": "Tests: a=b"}; !reflect.DeepEqual(descriptions, expected) {
+		t.Errorf("Expected %v, got %v", expected, descriptions)
+	}
+
+	descriptions, err = parseContextDescriptions(`{"ci/lint": "Lint, format and vet"}`)
+	if err != nil || descriptions["ci/lint"] != "Lint, format and vet" {
+		t.Errorf("Expected the JSON form to allow commas, got %v, %v", descriptions, err)
+	}
+
+	for value, expected := range map[string]string{
+		"ci/lint":                          `entry "ci/lint" is not in the form context=description`,
+		"=Linting":                         `entry "=Linting" is not in the form context=description`,
+		`{"ci/lint": 1}`:                   "invalid -context-descriptions JSON",
+		"ci/x=" + strings.Repeat("a", 141): "ci/x: description is 141 characters, longer than the 140 GitHub accepts",
+	} {
+		if _, err := parseContextDescriptions(value); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected an error containing %q for %q, got %v", expected, value, err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+// resultCounter keeps running counts of the output lines matching each
+// named group of -count-regex, such as passed and failed tests.
+type resultCounter struct {
+	pattern *regexp.Regexp
+	names   []string
+
+	mu     sync.Mutex
+	counts map[string]int
+}
+
+// compileCountRegex validates -count-regex, which needs at least one named
+// group. It returns nil when value is empty.
+func compileCountRegex(value string) (*resultCounter, error) {
+	if value == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(value)
+	if err != nil {
+		return nil, fmt.Errorf("Error: invalid -count-regex %q: %s", value, err)
+	}
+	counter := &resultCounter{pattern: pattern, counts: map[string]int{}}
+	seen := map[string]bool{}
+	for _, name := range pattern.SubexpNames() {
+		if name != "" && !seen[name] {
+			seen[name] = true
+			counter.names = append(counter.names, name)
+		}
+	}
+	if len(counter.names) == 0 {
+		return nil, fmt.Errorf("Error: -count-regex %q has no named groups to count, e.g. (?P<passed>--- PASS)|(?P<failed>--- FAIL)", value)
+	}
+	return counter, nil
+}
+
+// observe counts line under each named group that took part in the match.
+func (c *resultCounter) observe(line string) {
+	match := c.pattern.FindStringSubmatchIndex(line)
+	if match == nil {
+		return
+	}
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	for i, name := range c.pattern.SubexpNames() {
+		if name != "" && match[2*i] >= 0 {
+			c.counts[name]++
+		}
+	}
+}
+
+// summary describes the counts in the order of the groups, e.g. "120
+// passed, 3 failed", or returns "" before the first match.
+func (c *resultCounter) summary() string {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if len(c.counts) == 0 {
+		return ""
+	}
+	parts := make([]string, len(c.names))
+	for i, name := range c.names {
+		parts[i] = fmt.Sprintf("%d %s", c.counts[name], name)
+	}
+	return strings.Join(parts, ", ")
+}
+
+// applyCounts adds the final -count-regex counts to the description.
+func applyCounts(flags Flags, progress *progressReporter) string {
+	if progress == nil || progress.counter == nil {
+		return flags.Description
+	}
+	if summary := progress.counter.summary(); summary != "" {
+		return appendSuffix(flags.Description, summary)
+	}
+	return flags.Description
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+const goTestCounts = `^\s*--- (?:(?P<passed>PASS)|(?P<failed>FAIL)|(?P<skipped>SKIP)):`
+
+func TestCompileCountRegex(t *testing.T) {
+	if counter, err := compileCountRegex(""); counter != nil || err != nil {
+		t.Errorf("Expected no counter without -count-regex, got %v, %v", counter, err)
+	}
+	if _, err := compileCountRegex("--- (PASS|FAIL)"); err == nil || !strings.Contains(err.Error(), "no named groups") {
+		t.Errorf("Expected an error for a pattern without named groups, got %v", err)
+	}
+	if _, err := compileCountRegex("(?P<passed>ok"); err == nil || !strings.Contains(err.Error(), "invalid -count-regex") {
+		t.Errorf("Expected an error for an invalid pattern, got %v", err)
+	}
+}
+
+func TestResultCounterCountsLines(t *testing.T) {
+	counter, err := compileCountRegex(goTestCounts)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if summary := counter.summary(); summary != "" {
+		t.Errorf("Expected no summary before a match, got %q", summary)
+	}
+	progress := newProgressReporter(nil, time.Second, nil)
+	progress.counter = counter
+	stream := progress.stream()
+	for i := 0; i < 120; i++ {
+		stream.Write([]byte("--- PASS: TestSomething (0.00s)\n"))
+	}
+	stream.Write([]byte("=== RUN   TestBroken\n--- FAIL: TestBroken (0.01s)\n    --- FAIL: TestBroken/sub (0.00s)\nok  \tpkg\n--- FA"))
+	stream.Write([]byte("IL: TestLast (0.00s)\n"))
+
+	if summary := counter.summary(); summary != "120 passed, 3 failed, 0 skipped" {
+		t.Errorf("Unexpected summary %q", summary)
+	}
+}
+
+func TestProgressUpdatesWithCounts(t *testing.T) {
+	var posted []string
+	counter, _ := compileCountRegex(`(?P<passed>^ok)|(?P<failed>^not ok)`)
+	progress := newProgressReporter(nil, time.Second, func(p string) {
+		posted = append(posted, p)
+	})
+	progress.counter = counter
+
+	progress.update()
+	progress.observe("ok 1")
+	progress.observe("not ok 2")
+	progress.update()
+	progress.update()
+	progress.observe("ok 3")
+	progress.update()
+
+	expected := "1 passed, 1 failed so far|2 passed, 1 failed so far"
+	if strings.Join(posted, "|") != expected {
+		t.Errorf("Expected updates %q, got %q", expected, posted)
+	}
+
+	flags := defaultFlags()
+	if description := applyCounts(*flags, progress); description != "unit test (2 passed, 1 failed)" {
+		t.Errorf("Expected the final counts in the description, got %q", description)
+	}
+	if description := applyCounts(*flags, nil); description != "unit test" {
+		t.Errorf("Expected the description unchanged without -count-regex, got %q", description)
+	}
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+)
+
+// credentialHelperTimeout bounds how long -credential-helper may take, so a
+// helper waiting for input nobody gives can't hang the build.
+var credentialHelperTimeout = 30 * time.Second
+
+// credentialHelperSource reads the Github token from a git style credential
+// helper: the command gets protocol= and host= lines on stdin and prints
+// username= and password= lines, the password being the token.
+type credentialHelperSource struct {
+	command string
+	apiURL  string
+	// username is the username= the helper printed, set by Token.
+	username string
+}
+
+func (c *credentialHelperSource) String() string {
+	return "-credential-helper " + c.command
+}
+
+// credentialHost returns the host a helper knows the Github API's
+// credentials by: github.com for api.github.com, as git has them, and the
+// Enterprise Server's own host otherwise.
+func credentialHost(apiURL string) (protocol, host string) {
+	parsed, err := url.Parse(apiURL)
+	if err != nil || parsed.Host == "" {
+		return "https", "github.com"
+	}
+	if parsed.Host == "api.github.com" {
+		return parsed.Scheme, "github.com"
+	}
+	return parsed.Scheme, parsed.Host
+}
+
+func (c *credentialHelperSource) Token() (string, error) {
+	protocol, host := credentialHost(c.apiURL)
+	ctx, cancel := context.WithTimeout(context.Background(), credentialHelperTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", c.command)
+	cmd.Stdin = strings.NewReader("protocol=" + protocol + "\nhost=" + host + "\n\n")
+	// git credential fill would otherwise ask on the terminal.
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("Error: %s timed out after %s", c, credentialHelperTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error running %s: %s: %s", c, err, strings.TrimSpace(stderr.String()))
+	}
+
+	username, password := parseCredentials(out)
+	if password == "" {
+		return "", fmt.Errorf("Error: %s printed no password= line for %s", c, host)
+	}
+	if username != "" {
+		logger.Debugf("%s has credentials for %s on %s", c, username, host)
+	}
+	c.username = username
+	return password, nil
+}
+
+// parseCredentials returns the username and password from the key=value
+// lines a credential helper prints, ignoring other keys.
+func parseCredentials(out []byte) (username, password string) {
+	scanner := bufio.NewScanner(bytes.NewReader(out))
+	for scanner.Scan() {
+		parts := strings.SplitN(strings.TrimRight(scanner.Text(), "\r"), "=", 2)
+		if len(parts) != 2 {
+			continue
+		}
+		switch parts[0] {
+		case "username":
+			username = parts[1]
+		case "password":
+			password = parts[1]
+		}
+	}
+	return username, password
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// writeCredentialHelper writes an executable helper script with body and
+// returns its path.
+func writeCredentialHelper(t *testing.T, body string) string {
+	path := writeTempFile(t, "helper.sh", "#!/bin/sh\n"+body+"\n")
+	if err := os.Chmod(path, 0700); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCredentialHelperSourceToken(t *testing.T) {
+	input := filepath.Join(t.TempDir(), "input")
+	helper := writeCredentialHelper(t, `cat > `+input+`
+echo "username=x-access-token"
+echo "password=ghp_helpersecret"
+echo "quit=0"`)
+
+	source := &credentialHelperSource{command: helper + " get", apiURL: "https://api.github.com"}
+	token, err := source.Token()
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if token != "ghp_helpersecret" || source.username != "x-access-token" {
+		t.Errorf("Expected the password as the token and the username, got %q and %q", token, source.username)
+	}
+	if contents, _ := ioutil.ReadFile(input); string(contents) != "protocol=https\nhost=github.com\n\n" {
+		t.Errorf("Unexpected helper input %q", contents)
+	}
+
+	source.apiURL = "https://ghe.example.com/api/v3"
+	source.Token()
+	if contents, _ := ioutil.ReadFile(input); string(contents) != "protocol=https\nhost=ghe.example.com\n\n" {
+		t.Errorf("Expected the Enterprise Server host, got %q", contents)
+	}
+}
+
+func TestCredentialHelperSourceErrors(t *testing.T) {
+	for _, test := range []struct {
+		body, expected string
+	}{
+		{"echo username=me", "printed no password= line for github.com"},
+		{"echo 'no credentials' >&2; exit 1", "exit status 1: no credentials"},
+	} {
+		source := &credentialHelperSource{command: writeCredentialHelper(t, test.body), apiURL: "https://api.github.com"}
+		if _, err := source.Token(); err == nil || !strings.Contains(err.Error(), test.expected) {
+			t.Errorf("Expected an error containing %q, got %v", test.expected, err)
+		}
+	}
+}
+
+func TestCredentialHelperSourceTimesOut(t *testing.T) {
+	original := credentialHelperTimeout
+	credentialHelperTimeout = 100 * time.Millisecond
+	defer func() { credentialHelperTimeout = original }()
+
+	source := &credentialHelperSource{command: "sleep 10", apiURL: "https://api.github.com"}
+	started := time.Now()
+	if _, err := source.Token(); err == nil || !strings.Contains(err.Error(), "timed out after 100ms") {
+		t.Errorf("Expected a timeout, got %v", err)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the helper to be killed, took %s", elapsed)
+	}
+}
+
+func TestCLIUsesCredentialHelper(t *testing.T) {
+	helper := writeCredentialHelper(t, "echo password=ghp_helpersecret")
+	out, code := runCLIWithEnv(t, []string{"GH_TOKEN=", "GITHUB_TOKEN=", "GH_ENTERPRISE_TOKEN="},
+		"-replay", filepath.Join("testdata", "replay-failure.json"), "-credential-helper", helper,
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "sh", "-c", "echo leaked ghp_helpersecret; exit 3")
+	if code != 1 {
+		t.Errorf("Expected the failed command's exit code 1, got %d:\n%s", code, out)
+	}
+	if strings.Contains(out, "ghp_helpersecret") || !strings.Contains(out, "leaked ***") {
+		t.Errorf("Expected the helper's token to be masked, got:\n%s", out)
+	}
+}
+
+func TestCLICredentialHelperUsername(t *testing.T) {
+	var mu sync.Mutex
+	var users []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user, token, _ := r.BasicAuth()
+		mu.Lock()
+		users = append(users, user+":"+token)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer server.Close()
+	helper := writeCredentialHelper(t, "echo username=ci-bot; echo password=ghp_helpersecret")
+	env := []string{"GITHUB_API_URL=" + server.URL, "GH_TOKEN=", "GITHUB_TOKEN=", "GH_ENTERPRISE_TOKEN="}
+
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{nil, "ci-bot:ghp_helpersecret"},
+		{[]string{"-u", "release-bot"}, "release-bot:ghp_helpersecret"},
+	} {
+		mu.Lock()
+		users = nil
+		mu.Unlock()
+		args := append(append([]string{"-credential-helper", helper}, test.args...), "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "true")
+		if out, code := runCLIWithEnv(t, env, args...); code != 0 {
+			t.Errorf("Expected the run to succeed, got %d:\n%s", code, out)
+		}
+		mu.Lock()
+		if len(users) != 2 || users[0] != test.expected || users[1] != test.expected {
+			t.Errorf("Expected every request to authenticate as %q, got %q", test.expected, users)
+		}
+		mu.Unlock()
+	}
+}
@@ -0,0 +1,39 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// postedStatuses remembers when each status was last posted by this process,
+// for -dedupe-window.
+var postedStatuses = struct {
+	sync.Mutex
+	at map[string]time.Time
+}{at: map[string]time.Time{}}
+
+// dedupeKey identifies a status post: the same commit, context, state and
+// description.
+func dedupeKey(url string, params *CommitStatusParams) string {
+	return url + "\x00" + params.Context + "\x00" + params.State + "\x00" + params.Description
+}
+
+// recentlyPosted reports how long ago the same status was posted, if that
+// was within window.
+func recentlyPosted(key string, window time.Duration) (time.Duration, bool) {
+	postedStatuses.Lock()
+	defer postedStatuses.Unlock()
+	at, ok := postedStatuses.at[key]
+	if !ok {
+		return 0, false
+	}
+	ago := now().Sub(at)
+	return ago, ago < window
+}
+
+// rememberPost records that the status identified by key was just posted.
+func rememberPost(key string) {
+	postedStatuses.Lock()
+	defer postedStatuses.Unlock()
+	postedStatuses.at[key] = now()
+}
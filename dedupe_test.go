@@ -0,0 +1,85 @@
+package main
+
+import (
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// withPostedStatuses starts the test with no remembered status posts.
+func withPostedStatuses(t *testing.T) {
+	postedStatuses.Lock()
+	original := postedStatuses.at
+	postedStatuses.at = map[string]time.Time{}
+	postedStatuses.Unlock()
+	t.Cleanup(func() {
+		postedStatuses.Lock()
+		postedStatuses.at = original
+		postedStatuses.Unlock()
+	})
+}
+
+func TestDedupeWindowCollapsesIdenticalPosts(t *testing.T) {
+	withPostedStatuses(t)
+	out := withLogger(t, logInfo)
+	start := time.Date(2017, 6, 1, 12, 0, 0, 0, time.UTC)
+	defer withClock(start)()
+
+	posts := 0
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.DedupeWindow = 10 * time.Second
+	url := githubAPIURL + "/repos/org/repo/statuses/deadbeef"
+	for i := 0; i < 2; i++ {
+		if err := setGithubCommitStatus("POST", url, *flags, "success"); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+	if posts != 1 {
+		t.Errorf("Expected the second identical post to be skipped, got %d posts", posts)
+	}
+	if !strings.Contains(out.String(), "the same status was posted 0s ago") {
+		t.Errorf("Expected the skipped post to be logged, got %q", out.String())
+	}
+
+	// A different state isn't a duplicate.
+	if err := setGithubCommitStatus("POST", url, *flags, "failure"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if posts != 2 {
+		t.Errorf("Expected a different state to be posted, got %d posts", posts)
+	}
+
+	// Nor is the same status once the window has passed.
+	defer withClock(start.Add(11 * time.Second))()
+	if err := setGithubCommitStatus("POST", url, *flags, "success"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if posts != 3 {
+		t.Errorf("Expected the status to be posted again after the window, got %d posts", posts)
+	}
+}
+
+func TestDedupeWindowDefaultPostsEveryTime(t *testing.T) {
+	withPostedStatuses(t)
+	posts := 0
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		posts++
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	url := githubAPIURL + "/repos/org/repo/statuses/deadbeef"
+	for i := 0; i < 2; i++ {
+		if err := setGithubCommitStatus("POST", url, *defaultFlags(), "success"); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+	if posts != 2 {
+		t.Errorf("Expected both posts without -dedupe-window, got %d", posts)
+	}
+}
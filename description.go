@@ -0,0 +1,165 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+	"unicode"
+	"unicode/utf8"
+)
+
+// maxDescriptionLength is the longest commit status description GitHub accepts.
+const maxDescriptionLength = 140
+
+const ellipsis = "..."
+
+// truncateAtWords is -truncate-word-boundary: truncateDescription cuts after
+// the last whole word that fits.
+var truncateAtWords bool
+
+// now is the clock used for timestamps in descriptions.
+var now = time.Now
+
+// builtinDescriptions are posted for each state when neither -d nor the
+// state's -default-description-* flag is set, so GitHub never shows a blank.
+var builtinDescriptions = map[string]string{
+	"pending": "Waiting for build...",
+	"success": "Build passed",
+	"failure": "Build failed",
+	"error":   "Build errored",
+}
+
+// defaultDescription returns the description for state when -d is empty:
+// its -default-description-* flag, or the built-in one.
+func defaultDescription(flags Flags, state string) string {
+	if description := flags.DefaultDescriptions[state]; description != "" {
+		return description
+	}
+	return builtinDescriptions[state]
+}
+
+// statusDescription assembles the description posted for state.
+func statusDescription(flags Flags, state string) string {
+	description := flags.Description
+	if description == "" {
+		description = defaultDescription(flags, state)
+	}
+	description = handleNewlines(description, flags.DescriptionNewlines)
+	if flags.TimestampDescription && state != "pending" {
+		description = appendSuffix(description, now().Format(time.RFC3339))
+	}
+	if flags.ReporterID != "" {
+		description = appendTag(description, "["+flags.ReporterID+"]")
+	}
+	// The content hash goes last, so shortening for it never cuts it off
+	// and -skip-if-unchanged finds it at the end.
+	if flags.ContentHash != "" {
+		description = appendTag(description, contentMarker(flags.ContentHash))
+	}
+	return truncateDescription(description, maxDescriptionLength)
+}
+
+// newlineMode is the value of the -description-newlines flag: what happens
+// to newlines in the description.
+type newlineMode string
+
+const (
+	// newlinesSpace replaces each line break with a space, as GitHub shows
+	// descriptions on a single line.
+	newlinesSpace newlineMode = ""
+	newlinesKeep  newlineMode = "keep"
+	newlinesStrip newlineMode = "strip"
+)
+
+func (m *newlineMode) String() string {
+	return string(*m)
+}
+
+func (m *newlineMode) Set(value string) error {
+	switch value {
+	case "space":
+		*m = newlinesSpace
+	case "keep", "strip":
+		*m = newlineMode(value)
+	default:
+		return fmt.Errorf("expected keep, space or strip, got %q", value)
+	}
+	return nil
+}
+
+func (m *newlineMode) completionValues() []string {
+	return []string{"keep", "space", "strip"}
+}
+
+// lineBreaks turns every line break into \n, \r\n counting as one.
+var lineBreaks = strings.NewReplacer("\r\n", "\n", "\r", "\n")
+
+// handleNewlines applies mode to the newlines in description: replacing each
+// line break with a space, removing it, or keeping it.
+func handleNewlines(description string, mode newlineMode) string {
+	if mode == newlinesKeep || !strings.ContainsAny(description, "\r\n") {
+		return description
+	}
+	description = lineBreaks.Replace(description)
+	if mode == newlinesStrip {
+		return strings.Replace(description, "\n", "", -1)
+	}
+	return strings.Replace(description, "\n", " ", -1)
+}
+
+// maxReporterIDLength bounds -reporter-id so its tag leaves room for the
+// description.
+const maxReporterIDLength = 40
+
+// appendSuffix appends suffix in parentheses, shortening description so the
+// result stays within maxDescriptionLength.
+func appendSuffix(description, suffix string) string {
+	return appendTag(description, "("+suffix+")")
+}
+
+// appendTag appends suffix after a space, shortening description so the
+// result stays within maxDescriptionLength.
+func appendTag(description, suffix string) string {
+	if description == "" {
+		return suffix
+	}
+	room := maxDescriptionLength - utf8.RuneCountInString(suffix) - 1
+	return truncateDescription(description, room) + " " + suffix
+}
+
+// truncateDescription shortens s to at most limit runes, marking the cut with
+// an ellipsis. With truncateAtWords the cut is moved back to the last
+// whitespace, unless the first word alone is too long.
+func truncateDescription(s string, limit int) string {
+	if utf8.RuneCountInString(s) <= limit {
+		return s
+	}
+	runes := []rune(s)
+	if limit <= len(ellipsis) {
+		return string(runes[:limit])
+	}
+	cut := limit - len(ellipsis)
+	if truncateAtWords {
+		cut = wordBoundary(runes, cut)
+	}
+	return string(runes[:cut]) + ellipsis
+}
+
+// wordBoundary returns where to cut runes at or before cut without splitting
+// a word, dropping the whitespace before it, or cut if there is no earlier
+// whitespace.
+func wordBoundary(runes []rune, cut int) int {
+	end := cut
+	if !unicode.IsSpace(runes[cut]) {
+		for end > 0 && !unicode.IsSpace(runes[end-1]) {
+			end--
+		}
+	}
+	for end > 0 && unicode.IsSpace(runes[end-1]) {
+		end--
+	}
+	if end == 0 {
+		return cut
+	}
+	return end
+}
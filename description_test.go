@@ -0,0 +1,183 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func withClock(t time.Time) func() {
+	original := now
+	now = func() time.Time { return t }
+	return func() { now = original }
+}
+
+func TestStatusDescriptionTimestamp(t *testing.T) {
+	defer withClock(time.Date(2017, 6, 1, 12, 30, 0, 0, time.FixedZone("CEST", 2*60*60)))()
+
+	flags := defaultFlags()
+	flags.TimestampDescription = true
+
+	if description := statusDescription(*flags, "pending"); description != "unit test" {
+		t.Errorf("Expected pending description without a timestamp, got %q", description)
+	}
+	for _, state := range []string{"success", "failure", "error"} {
+		expected := "unit test (2017-06-01T12:30:00+02:00)"
+		if description := statusDescription(*flags, state); description != expected {
+			t.Errorf("Expected %s description %q, got %q", state, expected, description)
+		}
+	}
+
+	flags.Description = ""
+	if description := statusDescription(*flags, "success"); description != "Build passed (2017-06-01T12:30:00+02:00)" {
+		t.Errorf("Expected the timestamp after the default description, got %q", description)
+	}
+}
+
+func TestStatusDescriptionLengthLimit(t *testing.T) {
+	defer withClock(time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC))()
+
+	flags := defaultFlags()
+	flags.Description = strings.Repeat("é", 200)
+	flags.TimestampDescription = true
+
+	description := statusDescription(*flags, "success")
+	if utf8.RuneCountInString(description) != maxDescriptionLength {
+		t.Errorf("Expected %d runes, got %d: %q", maxDescriptionLength, utf8.RuneCountInString(description), description)
+	}
+	if !strings.HasSuffix(description, "... (2017-06-01T12:30:00Z)") {
+		t.Errorf("Expected the timestamp to survive truncation, got %q", description)
+	}
+
+	flags.TimestampDescription = false
+	description = statusDescription(*flags, "success")
+	if utf8.RuneCountInString(description) != maxDescriptionLength || !strings.HasSuffix(description, "é...") {
+		t.Errorf("Expected a truncated description, got %q", description)
+	}
+}
+
+func TestStatusDescriptionDefaults(t *testing.T) {
+	flags := defaultFlags()
+	flags.Description = ""
+	for state, expected := range map[string]string{
+		"pending": "Waiting for build...",
+		"success": "Build passed",
+		"failure": "Build failed",
+		"error":   "Build errored",
+	} {
+		if description := statusDescription(*flags, state); description != expected {
+			t.Errorf("Expected the built-in %s description %q, got %q", state, expected, description)
+		}
+	}
+
+	flags.DefaultDescriptions = map[string]string{"failure": "Tests failed, see the log"}
+	if description := statusDescription(*flags, "failure"); description != "Tests failed, see the log" {
+		t.Errorf("Expected -default-description-failure to override the built-in one, got %q", description)
+	}
+	if description := statusDescription(*flags, "success"); description != "Build passed" {
+		t.Errorf("Expected the other states to keep the built-in ones, got %q", description)
+	}
+
+	flags.DefaultDescriptions["failure"] = strings.Repeat("x", 200)
+	if description := statusDescription(*flags, "failure"); len(description) != maxDescriptionLength {
+		t.Errorf("Expected the default to respect the length limit, got %d characters", len(description))
+	}
+
+	flags.Description = "unit test"
+	for _, state := range []string{"pending", "success", "failure", "error"} {
+		if description := statusDescription(*flags, state); description != "unit test" {
+			t.Errorf("Expected -d to replace the %s default, got %q", state, description)
+		}
+	}
+}
+
+func TestTruncateDescriptionAtWordBoundary(t *testing.T) {
+	for _, test := range []struct {
+		s        string
+		limit    int
+		words    bool
+		expected string
+	}{
+		{"Build failed on linux", 15, false, "Build failed..."},
+		{"Build failed on linux", 14, false, "Build faile..."},
+		{"Build failed on linux", 14, true, "Build..."},
+		{"Build failed on linux", 15, true, "Build failed..."},
+		{"Build failed  on linux", 16, true, "Build failed..."},
+		{"Supercalifragilistic tests", 10, true, "Superca..."},
+		{"   Supercalifragilistic", 10, true, "   Supe..."},
+		{"Déploiement échoué sur linux", 22, true, "Déploiement échoué..."},
+		{"short", 20, true, "short"},
+	} {
+		truncateAtWords = test.words
+		description := truncateDescription(test.s, test.limit)
+		truncateAtWords = false
+		if description != test.expected {
+			t.Errorf("Expected %q cut to %d (words %t) to be %q, got %q", test.s, test.limit, test.words, test.expected, description)
+		}
+		if utf8.RuneCountInString(description) > test.limit {
+			t.Errorf("Expected %q within %d runes", description, test.limit)
+		}
+	}
+}
+
+func TestAppendSuffixAtWordBoundary(t *testing.T) {
+	truncateAtWords = true
+	defer func() { truncateAtWords = false }()
+	description := appendSuffix(strings.Repeat("flaky test ", 20), "retried 2 times")
+	if !strings.HasSuffix(description, " test flaky... (retried 2 times)") || utf8.RuneCountInString(description) > maxDescriptionLength {
+		t.Errorf("Expected the description cut after a whole word, got %q", description)
+	}
+}
+
+func TestStatusDescriptionReporterID(t *testing.T) {
+	defer withClock(time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC))()
+
+	flags := defaultFlags()
+	flags.ReporterID = "ci-runner-7"
+	if description := statusDescription(*flags, "pending"); description != "unit test [ci-runner-7]" {
+		t.Errorf("Expected the reporter ID tag, got %q", description)
+	}
+	flags.TimestampDescription = true
+	if description := statusDescription(*flags, "success"); description != "unit test (2017-06-01T12:30:00Z) [ci-runner-7]" {
+		t.Errorf("Expected the tag after the timestamp, got %q", description)
+	}
+
+	flags.TimestampDescription = false
+	flags.Description = strings.Repeat("x", 200)
+	description := statusDescription(*flags, "failure")
+	if utf8.RuneCountInString(description) != maxDescriptionLength || !strings.HasSuffix(description, "... [ci-runner-7]") {
+		t.Errorf("Expected the description to be shortened to keep the tag, got %q", description)
+	}
+
+	flags.ReporterID = strings.Repeat("x", maxReporterIDLength+1)
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-reporter-id must be at most 40 characters") {
+		t.Errorf("Expected a long -reporter-id to be rejected, got %v", err)
+	}
+}
+
+func TestStatusDescriptionNewlines(t *testing.T) {
+	flags := defaultFlags()
+	flags.Description = "3 tests failed:\r\nTestA\nTestB\r"
+	for _, test := range []struct {
+		mode     newlineMode
+		expected string
+	}{
+		{newlinesSpace, "3 tests failed: TestA TestB "},
+		{newlinesStrip, "3 tests failed:TestATestB"},
+		{newlinesKeep, "3 tests failed:\r\nTestA\nTestB\r"},
+	} {
+		flags.DescriptionNewlines = test.mode
+		if description := statusDescription(*flags, "failure"); description != test.expected {
+			t.Errorf("Expected %q with -description-newlines %q, got %q", test.expected, test.mode, description)
+		}
+	}
+
+	var mode newlineMode
+	if err := mode.Set("space"); err != nil || mode != newlinesSpace {
+		t.Errorf("Expected space to be the default, got %q %v", mode, err)
+	}
+	if err := mode.Set("escape"); err == nil {
+		t.Errorf("Expected an unknown mode to be rejected")
+	}
+}
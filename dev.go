@@ -0,0 +1,40 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// devMode is the value of -dev and -no-report. It is a boolean flag, but
+// BUILD_DEV used to turn dev mode on with any non-empty value, so a value
+// that isn't a boolean still does, with a deprecation warning.
+type devMode struct {
+	enabled bool
+	legacy  string
+}
+
+func (d *devMode) String() string {
+	return strconv.FormatBool(d.enabled)
+}
+
+func (d *devMode) IsBoolFlag() bool {
+	return true
+}
+
+func (d *devMode) Set(value string) error {
+	enabled, err := strconv.ParseBool(value)
+	d.enabled, d.legacy = enabled, ""
+	if err != nil {
+		d.enabled, d.legacy = true, value
+	}
+	return nil
+}
+
+// deprecationWarning returns the warning for a legacy value given with -dev
+// or env, or "" for a boolean.
+func (d *devMode) deprecationWarning(env string) string {
+	if d.legacy == "" {
+		return ""
+	}
+	return fmt.Sprintf("-dev (%s) %q is treated as true; use -dev or %s=true, as values that aren't booleans will be rejected in a future release", env, d.legacy, env)
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"flag"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestDevModeSet(t *testing.T) {
+	for _, test := range []struct {
+		value   string
+		enabled bool
+		legacy  bool
+	}{
+		{"true", true, false},
+		{"1", true, false},
+		{"false", false, false},
+		{"0", false, false},
+		{"yes", true, true},
+		{"on", true, true},
+	} {
+		var dev devMode
+		if err := dev.Set(test.value); err != nil {
+			t.Fatalf("Got unexpected error for %q: %s", test.value, err)
+		}
+		warning := dev.deprecationWarning("BUILD_DEV")
+		if dev.enabled != test.enabled || (warning != "") != test.legacy {
+			t.Errorf("Expected %q to give %t with a warning %t, got %t, %q", test.value, test.enabled, test.legacy, dev.enabled, warning)
+		}
+	}
+}
+
+func TestDevModeLegacyEnvValue(t *testing.T) {
+	withFlagEnvSuffixes(t, map[string]string{"dev": "DEV"})
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var dev devMode
+	flags.Var(&dev, "dev", "")
+	flags.Parse(nil)
+
+	if _, err := applyEnvSettings(flags, "BUILD_", fakeEnv(map[string]string{"BUILD_DEV": "local"})); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !dev.enabled {
+		t.Error("Expected a non-empty BUILD_DEV to still turn on dev mode")
+	}
+	if warning := dev.deprecationWarning("BUILD_DEV"); !strings.Contains(warning, `BUILD_DEV) "local" is treated as true`) {
+		t.Errorf("Expected a deprecation warning, got %q", warning)
+	}
+}
+
+func TestDevModeIsBoolFlag(t *testing.T) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var dev devMode
+	flags.Var(&dev, "dev", "")
+	if err := flags.Parse([]string{"-dev", "make", "test"}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !dev.enabled || strings.Join(flags.Args(), " ") != "make test" {
+		t.Errorf("Expected -dev to take no value, got %t and %q", dev.enabled, flags.Args())
+	}
+}
+
+func TestValidateFlagsDevOnlyChecksCommandFlags(t *testing.T) {
+	flags := Flags{Dev: true, TargetUrl: "not a url", Range: "nonsense"}
+	if err := validateFlags(flags, []string{"true"}, ""); err != nil {
+		t.Errorf("Expected -dev to skip reporting flags, got %s", err)
+	}
+	flags.Watch = []string{"*.go"}
+	if err := validateFlags(flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-watch and -dev") {
+		t.Errorf("Expected the command flags to still be checked, got %v", err)
+	}
+}
+
+func TestCLIDevPassesExitCodeThrough(t *testing.T) {
+	if out, code := runCLI(t, "-dev", "sh", "-c", "exit 42"); code != 42 {
+		t.Errorf("Expected the command's exit code, got %d:\n%s", code, out)
+	}
+	if out, code := runCLI(t, "-no-report", "-u", "http://127.0.0.1:1", "-a", "token", "true"); code != 0 {
+		t.Errorf("Expected -no-report to make no API calls, got %d:\n%s", code, out)
+	}
+	if runtime.GOOS == "windows" {
+		return
+	}
+	if out, code := runCLI(t, "-dev", "sh", "-c", "kill -TERM $$"); code != 143 {
+		t.Errorf("Expected 128+SIGTERM for a signaled command, got %d:\n%s", code, out)
+	}
+}
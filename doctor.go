@@ -0,0 +1,292 @@
+package main
+
+import (
+	"crypto/tls"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// doctorContext is the disposable context -write-test posts to.
+const doctorContext = "gh-status-reporter/doctor"
+
+// doctorCheck is the outcome of one doctor check.
+type doctorCheck struct {
+	Name   string `json:"name"`
+	OK     bool   `json:"ok"`
+	Detail string `json:"detail"`
+	Hint   string `json:"hint,omitempty"`
+}
+
+// flagOrigins returns where each flag that has a value got it: "-name" for
+// the command line, or the environment variable from envOrigins.
+func flagOrigins(envOrigins map[string]string) map[string]string {
+	origins := map[string]string{}
+	flag.Visit(func(f *flag.Flag) { origins[f.Name] = "-" + f.Name })
+	for name, env := range envOrigins {
+		origins[name] = env
+	}
+	return origins
+}
+
+// valueOrigin describes where the value of the flag name came from.
+func (d *doctor) valueOrigin(name string) string {
+	if origin, ok := d.origins[name]; ok {
+		return origin
+	}
+	return "unset"
+}
+
+// doctor runs the checks for flags. Each check that needs the API is skipped
+// once an earlier one shows it can't work.
+type doctor struct {
+	flags   Flags
+	origins map[string]string
+	getenv  func(string) string
+	checks  []doctorCheck
+}
+
+func (d *doctor) add(name string, ok bool, detail, hint string) bool {
+	d.checks = append(d.checks, doctorCheck{Name: name, OK: ok, Detail: detail, Hint: hint})
+	return ok
+}
+
+// configuration shows where each value came from and resolves the token the
+// way a normal run would.
+func (d *doctor) configuration() bool {
+	var origins []string
+	for _, name := range []string{"r", "s", "c", "t", "u"} {
+		origins = append(origins, "-"+name+" from "+d.valueOrigin(name))
+	}
+
+	authOrigin := d.valueOrigin("a")
+	if authOrigin == "unset" {
+		source, err := configuredTokenSource(d.flags)
+		if err != nil {
+			return d.add("configuration", false, strings.Join(origins, ", "), err.Error())
+		}
+		if source != nil {
+			token, err := source.Token()
+			if err != nil {
+				return d.add("configuration", false, "reading the token from "+source.String(), err.Error())
+			}
+			d.flags.Auth, authOrigin = token, source.String()
+		} else {
+			for _, name := range authEnvNames(githubAPIURL) {
+				if d.getenv(name) != "" {
+					authOrigin = name
+					break
+				}
+			}
+		}
+	}
+	origins = append(origins, "token from "+authOrigin)
+
+	var missing []string
+	if d.flags.OrgRepo == "" {
+		missing = append(missing, "-r or "+d.flags.envName("ORG_REPO"))
+	}
+	if d.flags.Auth == "" {
+		missing = append(missing, "a token ("+authSourcesChecked(d.flags)+")")
+	}
+	if len(missing) > 0 {
+		return d.add("configuration", false, strings.Join(origins, ", "), "set "+strings.Join(missing, " and "))
+	}
+	return d.add("configuration", true, strings.Join(origins, ", "), "")
+}
+
+// connectivity resolves the API host and completes a TLS handshake with it.
+func (d *doctor) connectivity() bool {
+	api, err := url.Parse(githubAPIURL)
+	if err != nil {
+		return d.add("dns", false, err.Error(), "")
+	}
+	host := api.Hostname()
+	addrs, err := net.LookupHost(host)
+	if err != nil {
+		return d.add("dns", false, fmt.Sprintf("%s doesn't resolve: %s", host, err), "check the machine's DNS configuration")
+	}
+	d.add("dns", true, fmt.Sprintf("%s resolves to %s", host, strings.Join(addrs, ", ")), "")
+
+	if api.Scheme != "https" {
+		return true
+	}
+	if proxy, _ := proxyURL(d.flags); proxy != nil {
+		return d.add("tls", true, "skipped, connections go through the proxy "+redactURL(proxy.String()), "")
+	}
+	port := api.Port()
+	if port == "" {
+		port = "443"
+	}
+	dialer := newDialer(d.flags)
+	dialer.Timeout = 10 * time.Second
+	conn, err := tls.DialWithDialer(dialer, dialNetwork(d.flags, "tcp"), net.JoinHostPort(host, port), &tls.Config{ServerName: host})
+	if err != nil {
+		return d.add("tls", false, fmt.Sprintf("TLS handshake with %s failed: %s", host, err), "check firewalls, proxies (-proxy) and the system's CA certificates")
+	}
+	state := conn.ConnectionState()
+	conn.Close()
+	return d.add("tls", true, fmt.Sprintf("%s presented a certificate for %s", host, state.PeerCertificates[0].Subject.CommonName), "")
+}
+
+// auth checks that the token is accepted and reports its scopes.
+func (d *doctor) auth() bool {
+	response, err := githubRequest("GET", githubAPIURL+"/user", d.flags, nil)
+	if err != nil {
+		return d.add("auth", false, err.Error(), "")
+	}
+	switch {
+	case response.StatusCode == http.StatusUnauthorized:
+		return d.add("auth", false, "Github rejected the token", "check the token hasn't expired or been revoked")
+	case response.StatusCode == http.StatusForbidden && strings.Contains(string(response.Body), "Resource not accessible by integration"):
+		// Github App installation tokens can't read /user.
+		return d.add("auth", true, "the token is a Github App token", "")
+	case !response.ok():
+		return d.add("auth", false, response.error("").Error(), "")
+	}
+
+	var user struct {
+		Login string `json:"login"`
+	}
+	json.Unmarshal(response.Body, &user)
+	header, reported := response.Header["X-Oauth-Scopes"]
+	detail := "authenticated as " + user.Login
+	if reported {
+		detail += " with scopes: " + strings.Join(parseScopes(strings.Join(header, ",")), ", ")
+	}
+	return d.add("auth", true, detail, "")
+}
+
+// repository checks that the token can write statuses to the repository.
+func (d *doctor) repository(repo string) bool {
+	var repository struct {
+		Private     bool `json:"private"`
+		Permissions *struct {
+			Push bool `json:"push"`
+		} `json:"permissions"`
+	}
+	if err := getGithubJSON(githubAPIURL+"/repos/"+repo, d.flags, &repository); err != nil {
+		return d.add("repository", false, err.Error(), "check -r and that the token has access to the repository; private repositories need the repo scope")
+	}
+	visibility := "public"
+	if repository.Private {
+		visibility = "private"
+	}
+	if repository.Permissions != nil && !repository.Permissions.Push {
+		return d.add("repository", false, fmt.Sprintf("%s is %s, but the token can't write to it", repo, visibility), "statuses need write access; grant it to the token's user or app")
+	}
+	return d.add("repository", true, fmt.Sprintf("%s is %s and writable", repo, visibility), "")
+}
+
+// commit checks that the SHA exists in the repository.
+func (d *doctor) commit(repo string) bool {
+	if d.flags.SHA == "" {
+		return d.add("commit", false, "no SHA given", "set -s or "+d.flags.envName("SHA"))
+	}
+	var commit struct {
+		SHA string `json:"sha"`
+	}
+	if err := getGithubJSON(githubAPIURL+"/repos/"+repo+"/commits/"+d.flags.SHA, d.flags, &commit); err != nil {
+		return d.add("commit", false, err.Error(), "check -s is a commit that has been pushed to "+repo)
+	}
+	return d.add("commit", true, commit.SHA+" exists", "")
+}
+
+// rateLimit checks how many core API requests are left.
+func (d *doctor) rateLimit() bool {
+	var limits struct {
+		Resources struct {
+			Core struct {
+				Limit     int   `json:"limit"`
+				Remaining int   `json:"remaining"`
+				Reset     int64 `json:"reset"`
+			} `json:"core"`
+		} `json:"resources"`
+	}
+	if err := getGithubJSON(githubAPIURL+"/rate_limit", d.flags, &limits); err != nil {
+		return d.add("rate limit", false, err.Error(), "")
+	}
+	core := limits.Resources.Core
+	detail := fmt.Sprintf("%d of %d requests left, resets at %s", core.Remaining, core.Limit, time.Unix(core.Reset, 0).UTC().Format(time.RFC3339))
+	// A run makes a handful of requests at the very least.
+	if core.Remaining < 10 {
+		return d.add("rate limit", false, detail, "wait for the reset before running")
+	}
+	return d.add("rate limit", true, detail, "")
+}
+
+// writeTest posts a pending status on the disposable doctor context and
+// immediately supersedes it with success.
+func (d *doctor) writeTest(repo string) bool {
+	flags := d.flags
+	flags.Context, flags.TargetUrl = doctorContext, ""
+	target := statusTarget{OrgRepo: repo, SHA: d.flags.SHA}
+	for _, state := range []string{"pending", "success"} {
+		flags.Description = "gh-status-reporter doctor write test"
+		if err := setGithubCommitStatus(commitStatusMethod, target.url(), flags, state); err != nil {
+			return d.add("write test", false, err.Error(), "the token needs the repo:status scope or statuses write permission")
+		}
+	}
+	return d.add("write test", true, "posted and superseded a status on "+doctorContext, "")
+}
+
+func (d *doctor) run(writeTest bool) {
+	if !d.configuration() {
+		return
+	}
+	if !d.connectivity() || !d.auth() {
+		return
+	}
+	repo := strings.TrimSpace(strings.Split(d.flags.OrgRepo, ",")[0])
+	repositoryOK := d.repository(repo)
+	commitOK := d.commit(repo)
+	d.rateLimit()
+	if writeTest && repositoryOK && commitOK {
+		d.writeTest(repo)
+	}
+}
+
+// runDoctorCommand diagnoses the configuration and prints each check as
+// text or JSON. It returns the exit code: non-zero if any check failed.
+func runDoctorCommand(flags Flags, output string, writeTest bool, origins map[string]string, out io.Writer) int {
+	if output != "text" && output != "json" {
+		fmt.Fprintf(out, "Error: -output must be text or json, got %q\n", output)
+		return 1
+	}
+	d := &doctor{flags: flags, origins: origins, getenv: os.Getenv}
+	d.run(writeTest)
+
+	ok := true
+	for _, check := range d.checks {
+		ok = ok && check.OK
+	}
+	if output == "json" {
+		encoded, _ := json.MarshalIndent(struct {
+			OK     bool          `json:"ok"`
+			Checks []doctorCheck `json:"checks"`
+		}{ok, d.checks}, "", "  ")
+		fmt.Fprintf(out, "%s\n", encoded)
+	} else {
+		for _, check := range d.checks {
+			result := "PASS"
+			if !check.OK {
+				result = "FAIL"
+			}
+			fmt.Fprintf(out, "%s  %s: %s\n", result, check.Name, check.Detail)
+			if check.Hint != "" {
+				fmt.Fprintf(out, "      hint: %s\n", check.Hint)
+			}
+		}
+	}
+	if !ok {
+		return 1
+	}
+	return 0
+}
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func doctorAPI(t *testing.T, push bool, posted *[]string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.URL.Path == "/user":
+			w.Header().Set("X-OAuth-Scopes", "repo:status, read:org")
+			fmt.Fprint(w, `{"login":"octocat"}`)
+		case r.URL.Path == "/repos/christopher-bui/gh-status-reporter":
+			fmt.Fprintf(w, `{"private":true,"permissions":{"push":%t}}`, push)
+		case r.URL.Path == "/repos/christopher-bui/gh-status-reporter/commits/deadbeef":
+			fmt.Fprint(w, `{"sha":"deadbeef00000000000000000000000000000000"}`)
+		case r.URL.Path == "/rate_limit":
+			fmt.Fprint(w, `{"resources":{"core":{"limit":5000,"remaining":4990,"reset":0}}}`)
+		case r.Method == "POST" && strings.HasPrefix(r.URL.Path, "/repos/christopher-bui/gh-status-reporter/statuses/"):
+			var status map[string]string
+			json.NewDecoder(r.Body).Decode(&status)
+			*posted = append(*posted, status["context"]+"="+status["state"])
+			w.WriteHeader(http.StatusCreated)
+			fmt.Fprint(w, `{}`)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestRunDoctorCommandPasses(t *testing.T) {
+	var posted []string
+	defer withGithubAPI(t, doctorAPI(t, true, &posted))()
+
+	var out bytes.Buffer
+	code := runDoctorCommand(*defaultFlags(), "text", true, map[string]string{"r": "-r", "a": "-a"}, &out)
+	if code != 0 {
+		t.Fatalf("Expected every check to pass, got %d:\n%s", code, out.String())
+	}
+	for _, expected := range []string{
+		"PASS  configuration: -r from -r",
+		"token from -a",
+		"PASS  auth: authenticated as octocat with scopes: repo:status, read:org",
+		"PASS  repository: christopher-bui/gh-status-reporter is private and writable",
+		"PASS  commit: deadbeef00000000000000000000000000000000 exists",
+		"PASS  rate limit: 4990 of 5000 requests left",
+		"PASS  write test",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+	if strings.Join(posted, " ") != doctorContext+"=pending "+doctorContext+"=success" {
+		t.Errorf("Expected a pending then success status on the doctor context, got %v", posted)
+	}
+}
+
+func TestRunDoctorCommandJSONFailure(t *testing.T) {
+	var posted []string
+	defer withGithubAPI(t, doctorAPI(t, false, &posted))()
+
+	var out bytes.Buffer
+	code := runDoctorCommand(*defaultFlags(), "json", true, map[string]string{}, &out)
+	if code != 1 {
+		t.Errorf("Expected exit code 1 without push access, got %d", code)
+	}
+	var report struct {
+		OK     bool
+		Checks []doctorCheck
+	}
+	if err := json.Unmarshal(out.Bytes(), &report); err != nil {
+		t.Fatalf("Expected JSON output, got %s: %v", out.String(), err)
+	}
+	if report.OK {
+		t.Errorf("Expected ok to be false")
+	}
+	for _, check := range report.Checks {
+		if check.Name == "repository" && (check.OK || check.Hint == "") {
+			t.Errorf("Expected the repository check to fail with a hint, got %+v", check)
+		}
+		if check.Name == "write test" {
+			t.Errorf("Expected no write test without push access")
+		}
+	}
+	if len(posted) != 0 {
+		t.Errorf("Expected no statuses to be posted, got %v", posted)
+	}
+}
+
+func TestRunDoctorCommandBadToken(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+		fmt.Fprint(w, `{"message":"Bad credentials"}`)
+	})()
+
+	var out bytes.Buffer
+	if code := runDoctorCommand(*defaultFlags(), "text", false, map[string]string{}, &out); code != 1 {
+		t.Errorf("Expected exit code 1, got %d", code)
+	}
+	if !strings.Contains(out.String(), "FAIL  auth: Github rejected the token") || strings.Contains(out.String(), "repository:") {
+		t.Errorf("Expected the auth check to fail and stop the API checks, got:\n%s", out.String())
+	}
+}
+
+func TestRunDoctorCommandOutput(t *testing.T) {
+	var out bytes.Buffer
+	if code := runDoctorCommand(*defaultFlags(), "yaml", false, nil, &out); code != 1 || !strings.Contains(out.String(), "-output must be text or json") {
+		t.Errorf("Expected -output yaml to be rejected, got %d %q", code, out.String())
+	}
+}
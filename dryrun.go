@@ -0,0 +1,120 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+)
+
+// plannedPost is a request -dry-run didn't send, as listed in the JSON plan
+// printed at exit with -log-format json.
+type plannedPost struct {
+	Method  string          `json:"method"`
+	URL     string          `json:"url"`
+	Context string          `json:"context,omitempty"`
+	State   string          `json:"state,omitempty"`
+	Body    json.RawMessage `json:"body,omitempty"`
+}
+
+// dryRunPlan collects the requests -dry-run didn't send, in order.
+var dryRunPlan struct {
+	sync.Mutex
+	posts []plannedPost
+}
+
+// recordPlannedPost adds a request to the plan. The body is included as
+// JSON, or as a JSON string when it isn't JSON, with secrets masked.
+func recordPlannedPost(method, url, body string) {
+	body = maskText(body, fatalErrors.Secrets)
+	post := plannedPost{Method: method, URL: redactURL(url)}
+	var status struct {
+		Context string `json:"context"`
+		State   string `json:"state"`
+	}
+	if body != "" && json.Unmarshal([]byte(body), &status) == nil {
+		post.Body, post.Context, post.State = json.RawMessage(body), status.Context, status.State
+	} else if body != "" {
+		post.Body, _ = json.Marshal(body)
+	}
+	dryRunPlan.Lock()
+	defer dryRunPlan.Unlock()
+	dryRunPlan.posts = append(dryRunPlan.posts, post)
+}
+
+// printDryRunPlan writes the plan as a single JSON line.
+func printDryRunPlan(out io.Writer) {
+	dryRunPlan.Lock()
+	posts := append([]plannedPost{}, dryRunPlan.posts...)
+	dryRunPlan.Unlock()
+	var plan struct {
+		DryRun struct {
+			Posts []plannedPost `json:"posts"`
+		} `json:"dry_run"`
+	}
+	plan.DryRun.Posts = posts
+	encoded, _ := json.Marshal(plan)
+	fmt.Fprintf(out, "%s\n", encoded)
+}
+
+// dryRunTransport prints requests that would change anything on Github
+// instead of sending them, answering them as if they had succeeded. Reads go
+// to next, so -skip-if-same and friends still see the real state.
+type dryRunTransport struct {
+	next http.RoundTripper
+}
+
+func (d *dryRunTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.Method == "GET" || req.Method == "HEAD" {
+		return d.next.RoundTrip(req)
+	}
+	requestBody, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	normalized := normalizeBody(requestBody, req.Header.Get("Content-Encoding"))
+	logger.Infof("Dry run: would %s %s %s", req.Method, req.URL.RequestURI(), normalized)
+	recordPlannedPost(req.Method, req.URL.String(), normalized)
+
+	// Echoing the request back is enough for the callers that decode a
+	// response, such as the ones looking for a created comment's ID.
+	status, body := http.StatusOK, []byte(normalized)
+	switch req.Method {
+	case "POST":
+		status = http.StatusCreated
+	case "DELETE":
+		status, body = http.StatusNoContent, nil
+	}
+	return &http.Response{
+		StatusCode: status,
+		Status:     fmt.Sprintf("%d %s", status, http.StatusText(status)),
+		Proto:      "HTTP/1.1",
+		ProtoMajor: 1,
+		ProtoMinor: 1,
+		Header:     http.Header{},
+		Body:       ioutil.NopCloser(bytes.NewReader(body)),
+		Request:    req,
+	}, nil
+}
+
+// dryRunExitCode is the exit code for a finished command. With -dry-run and
+// -dry-run-exit-zero the command's failure doesn't fail the run, so the
+// reporting wiring of a pipeline can be tested whatever the command does.
+func dryRunExitCode(flags Flags, code int) int {
+	if code != 0 && flags.DryRun && flags.DryRunExitZero {
+		logger.Infof("Dry run: exiting 0 instead of %d", code)
+		return 0
+	}
+	return code
+}
+
+// validateDryRunFlags rejects -dry-run-exit-zero without -dry-run.
+func validateDryRunFlags(flags Flags) error {
+	if flags.DryRunExitZero && !flags.DryRun {
+		return fmt.Errorf("Error: -dry-run-exit-zero requires -dry-run")
+	}
+	return nil
+}
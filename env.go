@@ -0,0 +1,332 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"path"
+	"regexp"
+	"strings"
+)
+
+var envKeyPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+
+// stringSlice is a flag.Value that collects every occurrence of a repeatable flag.
+type stringSlice []string
+
+func (s *stringSlice) String() string {
+	return strings.Join(*s, ",")
+}
+
+func (s *stringSlice) Set(value string) error {
+	*s = append(*s, value)
+	return nil
+}
+
+// setEnv returns env with key set to value, replacing any existing entry.
+func setEnv(env []string, key, value string) []string {
+	prefix := key + "="
+	for i, entry := range env {
+		if strings.HasPrefix(entry, prefix) {
+			env[i] = prefix + value
+			return env
+		}
+	}
+	return append(env, prefix+value)
+}
+
+// statusEnv describes the status reported on target to the command, for
+// -inject-status-env.
+func statusEnv(target statusTarget, flags Flags) []string {
+	flags = providerFlags(flags, target.OrgRepo)
+	return []string{
+		"STATUS_CONTEXT=" + flags.Context,
+		"STATUS_SHA=" + target.SHA,
+		"STATUS_ORG_REPO=" + target.OrgRepo,
+		"STATUS_TARGET_URL=" + statusTargetURL(flags, "pending"),
+	}
+}
+
+// lookupEnv finds key in an environment list of KEY=VALUE entries.
+func lookupEnv(env []string, key string) (string, bool) {
+	prefix := key + "="
+	for i := len(env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(env[i], prefix) {
+			return env[i][len(prefix):], true
+		}
+	}
+	return "", false
+}
+
+// parseEnvAssignment splits a KEY=VALUE pair as given to the -env flag.
+func parseEnvAssignment(assignment string) (string, string, error) {
+	parts := strings.SplitN(assignment, "=", 2)
+	if len(parts) != 2 || !envKeyPattern.MatchString(parts[0]) {
+		return "", "", fmt.Errorf("Error: invalid -env value %q, expected KEY=VALUE", assignment)
+	}
+	return parts[0], parts[1], nil
+}
+
+// parseEnvFile reads a dotenv-style file and applies its assignments to env.
+// Values are taken literally unless expand is set, in which case $VAR and
+// ${VAR} in unquoted and double-quoted values are expanded against env.
+func parseEnvFile(path string, env []string, expand bool) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return env, fmt.Errorf("Error reading env file %s: %s", path, err)
+	}
+	defer file.Close()
+
+	scanner := bufio.NewScanner(file)
+	lineNumber := 0
+	for scanner.Scan() {
+		lineNumber++
+		key, value, ok, err := parseEnvLine(scanner.Text(), env, expand)
+		if err != nil {
+			return env, fmt.Errorf("Error parsing env file %s line %d: %s", path, lineNumber, err)
+		}
+		if ok {
+			env = setEnv(env, key, value)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return env, fmt.Errorf("Error reading env file %s: %s", path, err)
+	}
+
+	return env, nil
+}
+
+// parseEnvLine parses a single dotenv line. ok is false for blank lines and comments.
+func parseEnvLine(line string, env []string, expand bool) (key string, value string, ok bool, err error) {
+	line = strings.TrimSpace(line)
+	if line == "" || strings.HasPrefix(line, "#") {
+		return "", "", false, nil
+	}
+	line = strings.TrimPrefix(line, "export ")
+
+	parts := strings.SplitN(line, "=", 2)
+	if len(parts) != 2 {
+		return "", "", false, errors.New("expected KEY=VALUE")
+	}
+	key = strings.TrimSpace(parts[0])
+	if !envKeyPattern.MatchString(key) {
+		return "", "", false, fmt.Errorf("invalid variable name %q", key)
+	}
+
+	raw := strings.TrimSpace(parts[1])
+	switch {
+	case strings.HasPrefix(raw, "'"):
+		end := strings.Index(raw[1:], "'")
+		if end < 0 {
+			return "", "", false, errors.New("unterminated single quote")
+		}
+		if err := checkTrailing(raw[end+2:]); err != nil {
+			return "", "", false, err
+		}
+		return key, raw[1 : end+1], true, nil
+	case strings.HasPrefix(raw, "\""):
+		value, rest, err := unquoteDouble(raw[1:])
+		if err != nil {
+			return "", "", false, err
+		}
+		if err := checkTrailing(rest); err != nil {
+			return "", "", false, err
+		}
+		if expand {
+			value = expandEnv(value, env)
+		}
+		return key, value, true, nil
+	default:
+		if i := strings.IndexAny(raw, "#"); i >= 0 && (i == 0 || raw[i-1] == ' ' || raw[i-1] == '\t') {
+			raw = strings.TrimSpace(raw[:i])
+		}
+		if expand {
+			raw = expandEnv(raw, env)
+		}
+		return key, raw, true, nil
+	}
+}
+
+// unquoteDouble decodes the body of a double-quoted value, returning the
+// decoded value and whatever follows the closing quote.
+func unquoteDouble(s string) (string, string, error) {
+	var value bytes.Buffer
+	for i := 0; i < len(s); i++ {
+		switch c := s[i]; c {
+		case '"':
+			return value.String(), s[i+1:], nil
+		case '\\':
+			if i+1 == len(s) {
+				return "", "", errors.New("unterminated double quote")
+			}
+			i++
+			switch s[i] {
+			case 'n':
+				value.WriteByte('\n')
+			case 't':
+				value.WriteByte('\t')
+			case 'r':
+				value.WriteByte('\r')
+			default:
+				value.WriteByte(s[i])
+			}
+		default:
+			value.WriteByte(c)
+		}
+	}
+	return "", "", errors.New("unterminated double quote")
+}
+
+// checkTrailing allows only whitespace or a comment after a quoted value.
+func checkTrailing(rest string) error {
+	rest = strings.TrimSpace(rest)
+	if rest != "" && !strings.HasPrefix(rest, "#") {
+		return fmt.Errorf("unexpected characters after quoted value: %q", rest)
+	}
+	return nil
+}
+
+func expandEnv(value string, env []string) string {
+	return os.Expand(value, func(name string) string {
+		v, _ := lookupEnv(env, name)
+		return v
+	})
+}
+
+// isolatedEnvBaseline are the variables -env-isolate always passes to the
+// command.
+var isolatedEnvBaseline = []string{"PATH", "HOME", "TMPDIR", "LANG"}
+
+// envAllowPattern matches an -env-allow value: a name, or a prefix followed
+// by *.
+var envAllowPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*\*?$`)
+
+// validateEnvAllow checks the -env-allow patterns.
+func validateEnvAllow(flags Flags) error {
+	if len(flags.EnvAllow) > 0 && !flags.EnvIsolate {
+		return errors.New("Error: -env-allow requires -env-isolate")
+	}
+	for _, pattern := range flags.EnvAllow {
+		if !envAllowPattern.MatchString(pattern) {
+			return fmt.Errorf("Error: -env-allow must be a variable name or a PREFIX_* pattern, got %q", pattern)
+		}
+	}
+	return nil
+}
+
+// isolateEnv returns the entries of env the baseline or one of the allow
+// patterns names. Only the names that are passed are logged, never their
+// values.
+func isolateEnv(env []string, allow []string) []string {
+	patterns := append(append([]string{}, isolatedEnvBaseline...), allow...)
+	var isolated []string
+	var names []string
+	for _, entry := range env {
+		name := strings.SplitN(entry, "=", 2)[0]
+		for _, pattern := range patterns {
+			if name == pattern || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))) {
+				isolated = append(isolated, entry)
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	logger.Debugf("Passing only %s from the environment to the command", strings.Join(names, ", "))
+	return isolated
+}
+
+// parseEnvAllowlist splits the comma separated -env-allowlist patterns.
+func parseEnvAllowlist(value string) []string {
+	var patterns []string
+	for _, pattern := range strings.Split(value, ",") {
+		if pattern = strings.TrimSpace(pattern); pattern != "" {
+			patterns = append(patterns, pattern)
+		}
+	}
+	return patterns
+}
+
+// validateEnvAllowlist checks the -env-allowlist patterns are valid globs.
+func validateEnvAllowlist(flags Flags) error {
+	if flags.EnvAllowlist == "" {
+		return nil
+	}
+	if flags.EnvIsolate {
+		return errors.New("Error: -env-allowlist can't be used with -env-isolate; use -env-allow to add to -env-isolate's variables")
+	}
+	for _, pattern := range parseEnvAllowlist(flags.EnvAllowlist) {
+		if _, err := path.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Error: invalid -env-allowlist pattern %q: %s", pattern, err)
+		}
+	}
+	return nil
+}
+
+// tokenEnvNames are the variables the reporter reads its token from, which
+// an -env-allowlist glob never passes; only naming one exactly does.
+func tokenEnvNames(flags Flags) []string {
+	return append([]string{ghsrEnvPrefix + "AUTH", flags.envName("AUTH")}, authEnvNames(githubAPIURL)...)
+}
+
+// allowlistEnv returns the entries of env one of the -env-allowlist globs
+// matches, leaving out the tokens unless they are named exactly. Only the
+// names that are passed are logged, never their values.
+func allowlistEnv(env []string, patterns []string, tokens []string) []string {
+	isToken := map[string]bool{}
+	for _, name := range tokens {
+		isToken[name] = true
+	}
+	var allowed []string
+	var names []string
+	for _, entry := range env {
+		name := strings.SplitN(entry, "=", 2)[0]
+		for _, pattern := range patterns {
+			if matched, _ := path.Match(pattern, name); name == pattern || (matched && !isToken[name]) {
+				allowed = append(allowed, entry)
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	logger.Debugf("Passing only %s from the environment to the command", strings.Join(names, ", "))
+	return allowed
+}
+
+// filterCommandEnv applies -env-isolate or -env-allowlist to env, the
+// reporter's own environment, before it is passed to a command.
+func filterCommandEnv(flags Flags, env []string) []string {
+	if flags.EnvIsolate {
+		return isolateEnv(env, flags.EnvAllow)
+	}
+	if flags.EnvAllowlist != "" {
+		return allowlistEnv(env, parseEnvAllowlist(flags.EnvAllowlist), tokenEnvNames(flags))
+	}
+	return env
+}
+
+// buildCommandEnv returns the environment for the wrapped command: the
+// inherited environment, then each env file in order, then explicit -env
+// assignments. The reporter's own environment is never modified.
+func buildCommandEnv(base []string, envFiles []string, assignments []string, expand bool) ([]string, error) {
+	env := append([]string{}, base...)
+
+	var err error
+	for _, path := range envFiles {
+		env, err = parseEnvFile(path, env, expand)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	for _, assignment := range assignments {
+		key, value, err := parseEnvAssignment(assignment)
+		if err != nil {
+			return nil, err
+		}
+		env = setEnv(env, key, value)
+	}
+
+	return env, nil
+}
@@ -0,0 +1,253 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func writeTempFile(t *testing.T, name, contents string) string {
+	dir, err := ioutil.TempDir("", "gh-status-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(dir, name)
+	if err := ioutil.WriteFile(path, []byte(contents), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestBuildCommandEnvPrecedence(t *testing.T) {
+	first := writeTempFile(t, "first.env", "A=from-first\nB=from-first\nC=from-first\n")
+	second := writeTempFile(t, "second.env", "B=from-second\nC=from-second\n")
+
+	env, err := buildCommandEnv([]string{"A=inherited", "D=inherited"}, []string{first, second}, []string{"C=from-flag"}, false)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	expected := []string{"A=from-first", "D=inherited", "B=from-second", "C=from-flag"}
+	if !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected env to be %q, got %q", expected, env)
+	}
+}
+
+func TestParseEnvFileExpansion(t *testing.T) {
+	path := writeTempFile(t, "expand.env", "GREETING=hello $NAME\nQUOTED=\"${NAME}!\"\nLITERAL='$NAME'\n")
+	base := []string{"NAME=octocat"}
+
+	env, err := parseEnvFile(path, append([]string{}, base...), false)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if v, _ := lookupEnv(env, "GREETING"); v != "hello $NAME" {
+		t.Errorf("Expected no expansion without -env-expand, got %q", v)
+	}
+
+	env, err = parseEnvFile(path, append([]string{}, base...), true)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := map[string]string{"GREETING": "hello octocat", "QUOTED": "octocat!", "LITERAL": "$NAME"}
+	for key, want := range expected {
+		if v, _ := lookupEnv(env, key); v != want {
+			t.Errorf("Expected %s to be %q, got %q", key, want, v)
+		}
+	}
+}
+
+func TestParseEnvFileErrorNamesFileAndLine(t *testing.T) {
+	path := writeTempFile(t, "broken.env", "# comment\nGOOD=1\nBAD=\"unterminated\n")
+
+	_, err := parseEnvFile(path, nil, false)
+	if err == nil {
+		t.Fatal("Expected an error for an unterminated quote")
+	}
+	if !strings.Contains(err.Error(), path) || !strings.Contains(err.Error(), "line 3") {
+		t.Errorf("Expected error to name %s and line 3, got %q", path, err.Error())
+	}
+}
+
+func TestBuildCommandEnvDoesNotTouchReporterEnv(t *testing.T) {
+	os.Setenv("BUILD_CONTEXT", "ci")
+	defer os.Unsetenv("BUILD_CONTEXT")
+	path := writeTempFile(t, "build.env", "BUILD_CONTEXT=overridden\n")
+
+	env, err := buildCommandEnv(os.Environ(), []string{path}, nil, false)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if v, _ := lookupEnv(env, "BUILD_CONTEXT"); v != "overridden" {
+		t.Errorf("Expected child BUILD_CONTEXT to be overridden, got %q", v)
+	}
+	if os.Getenv("BUILD_CONTEXT") != "ci" {
+		t.Errorf("Expected reporter BUILD_CONTEXT to be untouched, got %q", os.Getenv("BUILD_CONTEXT"))
+	}
+}
+
+func TestParseEnvAssignmentRejectsMalformed(t *testing.T) {
+	for _, value := range []string{"NOEQUALS", "=value", "1BAD=x"} {
+		if _, _, err := parseEnvAssignment(value); err == nil {
+			t.Errorf("Expected error for -env %q", value)
+		}
+	}
+}
+
+func TestParseEnvFileQuotesAndComments(t *testing.T) {
+	path := writeTempFile(t, "quotes.env", `
+# full line comment
+export PLAIN=value # trailing comment
+TABBED=value	# tab before comment
+HASH=abc#def
+EMPTY=
+DOUBLE="two words # not a comment"
+ESCAPED="line1\nline2 \"quoted\""
+SINGLE='literal \n "x"'  # comment after quote
+   SPACED   =   padded
+`)
+
+	env, err := parseEnvFile(path, nil, false)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	expected := map[string]string{
+		"PLAIN":   "value",
+		"TABBED":  "value",
+		"HASH":    "abc#def",
+		"EMPTY":   "",
+		"DOUBLE":  "two words # not a comment",
+		"ESCAPED": "line1\nline2 \"quoted\"",
+		"SPACED":  "padded",
+		"SINGLE":  `literal \n "x"`,
+	}
+	for key, want := range expected {
+		if v, ok := lookupEnv(env, key); !ok || v != want {
+			t.Errorf("Expected %s to be %q, got %q", key, want, v)
+		}
+	}
+}
+
+func TestParseEnvFileRejectsTextAfterQuote(t *testing.T) {
+	path := writeTempFile(t, "trailing.env", "KEY=\"value\" extra\n")
+	if _, err := parseEnvFile(path, nil, false); err == nil {
+		t.Error("Expected an error for text after a closing quote")
+	}
+}
+
+func TestIsolateEnv(t *testing.T) {
+	out := withLogger(t, logDebug)
+	env := []string{"PATH=/bin", "HOME=/home/ci", "AWS_SECRET_ACCESS_KEY=hunter2", "CI_BUILD_ID=42", "CI=true", "NPM_TOKEN=abc"}
+	isolated := isolateEnv(env, []string{"CI_*", "CI"})
+	if strings.Join(isolated, " ") != "PATH=/bin HOME=/home/ci CI_BUILD_ID=42 CI=true" {
+		t.Errorf("Expected only the baseline and allowed variables, got %q", isolated)
+	}
+	if !strings.Contains(out.String(), "PATH, HOME, CI_BUILD_ID, CI") || strings.Contains(out.String(), "/home/ci") {
+		t.Errorf("Expected the passed names without their values in the log, got %q", out.String())
+	}
+}
+
+func TestValidateEnvAllow(t *testing.T) {
+	if err := validateEnvAllow(Flags{EnvIsolate: true, EnvAllow: []string{"CI_*", "GOPATH"}}); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	if err := validateEnvAllow(Flags{EnvAllow: []string{"CI_*"}}); err == nil || !strings.Contains(err.Error(), "requires -env-isolate") {
+		t.Errorf("Expected -env-allow to need -env-isolate, got %v", err)
+	}
+	if err := validateEnvAllow(Flags{EnvIsolate: true, EnvAllow: []string{"*_TOKEN"}}); err == nil {
+		t.Error("Expected an error for a pattern that isn't a prefix")
+	}
+}
+
+func TestCLIIsolatesEnv(t *testing.T) {
+	out, code := runCLIWithEnv(t, []string{"DEPLOY_SECRET=hunter2", "CI_BUILD_ID=42"},
+		"-dev", "-v", "-env-isolate", "-env-allow", "CI_*", "-env", "EXTRA=1",
+		"sh", "-c", `echo "secret=${DEPLOY_SECRET-unset} build=$CI_BUILD_ID extra=$EXTRA"`)
+	if code != 0 {
+		t.Fatalf("Expected the command to succeed, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "secret=unset build=42 extra=1") {
+		t.Errorf("Expected only the allowed and added variables in the command, got:\n%s", out)
+	}
+	if strings.Contains(out, "hunter2") {
+		t.Errorf("Expected the secret never to be printed, got:\n%s", out)
+	}
+}
+
+func TestStatusEnv(t *testing.T) {
+	flags := defaultFlags()
+	flags.TargetUrl = "https://ci.example.com/builds/42"
+	flags.TargetURLOnFailure = "https://ci.example.com/builds/42/log"
+	flags.ContextMappings, _ = parseContextMap("org/internal:ci=ci-internal")
+
+	expected := []string{
+		"STATUS_CONTEXT=ci-internal",
+		"STATUS_SHA=cafebabe",
+		"STATUS_ORG_REPO=org/internal",
+		"STATUS_TARGET_URL=https://ci.example.com/builds/42",
+	}
+	if env := statusEnv(statusTarget{"org/internal", "cafebabe"}, *flags); !reflect.DeepEqual(env, expected) {
+		t.Errorf("Expected env %q, got %q", expected, env)
+	}
+}
+
+func TestCLIInjectsStatusEnv(t *testing.T) {
+	script := `echo "context=$STATUS_CONTEXT sha=$STATUS_SHA repo=$STATUS_ORG_REPO url=${STATUS_TARGET_URL-unset}"; exit 3`
+	out, _ := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", script)
+	if !strings.Contains(out, "context= sha= repo= url=unset") {
+		t.Errorf("Expected no status variables without -inject-status-env, got:\n%s", out)
+	}
+
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"), "-inject-status-env",
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", script)
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "context=ci sha=deadbeef repo=org/repo url=\n") {
+		t.Errorf("Expected the command to see the status it reports under, got:\n%s", out)
+	}
+}
+
+func TestAllowlistEnv(t *testing.T) {
+	env := []string{"PATH=/bin", "HOME=/root", "CI_BUILD_ID=42", "GO_VERSION=1.22", "NODE_VERSION=20",
+		"DEPLOY_SECRET=hunter2", "GITHUB_TOKEN=ghp_secret", "GH_TOKEN=ghp_other", "CI_TOKEN=abc"}
+	allowed := allowlistEnv(env, parseEnvAllowlist("PATH, CI_*,*_VERSION,G?_TOKEN,,"), []string{"GITHUB_TOKEN", "GH_TOKEN"})
+	expected := []string{"PATH=/bin", "CI_BUILD_ID=42", "GO_VERSION=1.22", "NODE_VERSION=20", "CI_TOKEN=abc"}
+	if !reflect.DeepEqual(allowed, expected) {
+		t.Errorf("Expected %q, got %q", expected, allowed)
+	}
+
+	allowed = allowlistEnv(env, []string{"PATH", "GITHUB_TOKEN"}, []string{"GITHUB_TOKEN", "GH_TOKEN"})
+	if !reflect.DeepEqual(allowed, []string{"PATH=/bin", "GITHUB_TOKEN=ghp_secret"}) {
+		t.Errorf("Expected a token named exactly to pass, got %q", allowed)
+	}
+}
+
+func TestValidateEnvAllowlist(t *testing.T) {
+	if err := validateEnvAllowlist(Flags{EnvAllowlist: "PATH,CI_*,[A-Z]*_VERSION"}); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	if err := validateEnvAllowlist(Flags{EnvAllowlist: "PATH,CI_[*"}); err == nil || !strings.Contains(err.Error(), `invalid -env-allowlist pattern "CI_[*"`) {
+		t.Errorf("Expected an error for a bad glob, got %v", err)
+	}
+	if err := validateEnvAllowlist(Flags{EnvAllowlist: "PATH", EnvIsolate: true}); err == nil || !strings.Contains(err.Error(), "can't be used with -env-isolate") {
+		t.Errorf("Expected an error with -env-isolate, got %v", err)
+	}
+}
+
+func TestCLIAllowlistsEnv(t *testing.T) {
+	out, code := runCLIWithEnv(t, []string{"DEPLOY_SECRET=hunter2", "CI_BUILD_ID=42", "GO_VERSION=1.22", "GH_TOKEN=ghp_secret"},
+		"-dev", "-env-allowlist", "PATH,CI_*,*_VERSION,*_TOKEN", "-env", "EXTRA=1",
+		"sh", "-c", `echo "secret=${DEPLOY_SECRET-unset} build=$CI_BUILD_ID go=$GO_VERSION token=${GH_TOKEN-unset} home=${HOME-unset} extra=$EXTRA"`)
+	if code != 0 {
+		t.Fatalf("Expected the command to succeed, got %d:\n%s", code, out)
+	}
+	if !strings.Contains(out, "secret=unset build=42 go=1.22 token=unset home=unset extra=1") {
+		t.Errorf("Expected only the allowlisted and added variables in the command, got:\n%s", out)
+	}
+}
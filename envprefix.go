@@ -0,0 +1,86 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+)
+
+// defaultEnvPrefix is the prefix of the environment variables that set flags
+// unless -env-prefix or GH_STATUS_REPORTER_ENV_PREFIX say otherwise.
+const defaultEnvPrefix = "BUILD_"
+
+// ghsrEnvPrefix is always read, ahead of the configurable prefix, so there
+// is a namespace no other tool uses.
+const ghsrEnvPrefix = "GHSR_"
+
+// flagEnvSuffixes maps flags to the environment variable that sets them,
+// without its prefix.
+var flagEnvSuffixes = map[string]string{}
+
+// envString defines a string flag that can also be set with the variable
+// suffix under either prefix.
+func envString(name, suffix, usage string) *string {
+	flagEnvSuffixes[name] = suffix
+	return flag.String(name, "", usage)
+}
+
+// envBool defines a bool flag that can also be set with the variable suffix
+// under either prefix.
+func envBool(name, suffix, usage string) *bool {
+	flagEnvSuffixes[name] = suffix
+	return flag.Bool(name, false, usage)
+}
+
+// envVar defines a flag.Value flag that can also be set with the variable
+// suffix under either prefix.
+func envVar(value flag.Value, name, suffix, usage string) {
+	flagEnvSuffixes[name] = suffix
+	flag.Var(value, name, usage)
+}
+
+// envPrefixDefault returns the default of -env-prefix.
+func envPrefixDefault(getenv func(string) string) string {
+	if prefix := getenv("GH_STATUS_REPORTER_ENV_PREFIX"); prefix != "" {
+		return prefix
+	}
+	return defaultEnvPrefix
+}
+
+// envName returns the variable that sets the flag with suffix, for messages.
+func (flags Flags) envName(suffix string) string {
+	if flags.EnvPrefix == "" {
+		return defaultEnvPrefix + suffix
+	}
+	return flags.EnvPrefix + suffix
+}
+
+// applyEnvSettings sets every flag in flags that wasn't given on the command
+// line from its environment variable, GHSR_ first and then prefix. It
+// returns the variable each value came from, by flag name.
+func applyEnvSettings(flags *flag.FlagSet, prefix string, getenv func(string) string) (map[string]string, error) {
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+
+	origins := map[string]string{}
+	var errs multiError
+	for name, suffix := range flagEnvSuffixes {
+		if given[name] || flags.Lookup(name) == nil {
+			continue
+		}
+		for _, env := range []string{ghsrEnvPrefix + suffix, prefix + suffix} {
+			value := getenv(env)
+			if value == "" {
+				continue
+			}
+			if err := flags.Set(name, value); err != nil {
+				errs = append(errs, fmt.Errorf("Error: invalid %s: %s", env, err))
+			}
+			origins[name] = env
+			break
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return origins, nil
+}
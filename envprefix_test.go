@@ -0,0 +1,78 @@
+package main
+
+import (
+	"flag"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func withFlagEnvSuffixes(t *testing.T, suffixes map[string]string) {
+	original := flagEnvSuffixes
+	flagEnvSuffixes = suffixes
+	t.Cleanup(func() { flagEnvSuffixes = original })
+}
+
+func TestApplyEnvSettings(t *testing.T) {
+	withFlagEnvSuffixes(t, map[string]string{"r": "ORG_REPO", "c": "CONTEXT", "s": "SHA", "t": "TARGET_URL"})
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	repo, context, sha, target := flags.String("r", "", ""), flags.String("c", "", ""), flags.String("s", "", ""), flags.String("t", "", "")
+	flags.Parse([]string{"-s", "cafef00d"})
+
+	env := map[string]string{
+		"BUILD_CONTEXT":  "docker-build",
+		"CI_CONTEXT":     "ci",
+		"CI_ORG_REPO":    "org/repo",
+		"GHSR_ORG_REPO":  "org/preferred",
+		"CI_SHA":         "deadbeef",
+		"BUILD_ORG_REPO": "org/ignored",
+	}
+	origins, err := applyEnvSettings(flags, "CI_", fakeEnv(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *context != "ci" || *repo != "org/preferred" || *sha != "cafef00d" || *target != "" {
+		t.Errorf("Unexpected settings -c %q -r %q -s %q -t %q", *context, *repo, *sha, *target)
+	}
+	if origins["c"] != "CI_CONTEXT" || origins["r"] != "GHSR_ORG_REPO" || len(origins) != 2 {
+		t.Errorf("Unexpected origins %v", origins)
+	}
+}
+
+func TestApplyEnvSettingsInvalidValue(t *testing.T) {
+	withFlagEnvSuffixes(t, map[string]string{"log-level": "LOG_LEVEL"})
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	var level logLevel
+	flags.Var(&level, "log-level", "")
+
+	_, err := applyEnvSettings(flags, "BUILD_", fakeEnv(map[string]string{"BUILD_LOG_LEVEL": "loud"}))
+	if err == nil || err.Error() != `Error: invalid BUILD_LOG_LEVEL: expected error, warn, info, debug, trace, got "loud"` {
+		t.Errorf("Expected the variable to be named, got %v", err)
+	}
+}
+
+func TestEnvPrefixDefault(t *testing.T) {
+	if prefix := envPrefixDefault(fakeEnv(nil)); prefix != "BUILD_" {
+		t.Errorf("Expected BUILD_, got %q", prefix)
+	}
+	if prefix := envPrefixDefault(fakeEnv(map[string]string{"GH_STATUS_REPORTER_ENV_PREFIX": "STATUS_"})); prefix != "STATUS_" {
+		t.Errorf("Expected STATUS_, got %q", prefix)
+	}
+	flags := Flags{EnvPrefix: "STATUS_"}
+	if name := flags.envName("SHA"); name != "STATUS_SHA" {
+		t.Errorf("Expected STATUS_SHA, got %q", name)
+	}
+}
+
+func TestCLIEnvPrefix(t *testing.T) {
+	t.Setenv("BUILD_CONTEXT", "docker-build")
+	t.Setenv("GH_STATUS_REPORTER_ENV_PREFIX", "CI_STATUS_")
+	t.Setenv("CI_STATUS_CONTEXT", "ci")
+	t.Setenv("CI_STATUS_SHA", "deadbeef")
+	t.Setenv("GHSR_ORG_REPO", "org/repo")
+
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"), "-d", "unit test", "-a", "token", "false")
+	if code != 1 || strings.Contains(out, "no recorded response") {
+		t.Errorf("Expected the replayed failure, got %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runEvent is one line of the -events-file timeline. Which fields are set
+// depends on the event.
+type runEvent struct {
+	Event           string   `json:"event"`
+	Timestamp       string   `json:"timestamp"`
+	Repository      string   `json:"repository,omitempty"`
+	SHA             string   `json:"sha,omitempty"`
+	Context         string   `json:"context,omitempty"`
+	State           string   `json:"state,omitempty"`
+	Command         []string `json:"command,omitempty"`
+	ExitCode        *int     `json:"exit_code,omitempty"`
+	DurationSeconds float64  `json:"duration_seconds,omitempty"`
+	Attempts        int      `json:"attempts,omitempty"`
+	Error           string   `json:"error,omitempty"`
+}
+
+// events is the -events-file log, nil when it isn't set. Its methods do
+// nothing when it is nil.
+var events *eventLog
+
+// eventLog writes the run's lifecycle events to a file as JSON lines, each
+// written as soon as it happens so a run that is killed still leaves the
+// timeline up to that point.
+type eventLog struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+// openEventLog creates or truncates the -events-file at path. It is closed
+// when the process exits.
+func openEventLog(path string) (*eventLog, error) {
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_TRUNC|os.O_WRONLY, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening -events-file %s: %s", path, err)
+	}
+	log := &eventLog{file: file}
+	onExit(log.close)
+	return log, nil
+}
+
+// record appends event to the log, stamped with the current time. Failing to
+// write is only a warning.
+func (l *eventLog) record(event runEvent) {
+	if l == nil {
+		return
+	}
+	event.Timestamp = time.Now().UTC().Format(time.RFC3339Nano)
+	line, err := json.Marshal(event)
+	if err != nil {
+		logger.Warnf("could not convert the %s event to json: %s", event.Event, err)
+		return
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file == nil {
+		return
+	}
+	if _, err := l.file.Write(append(line, '\n')); err != nil {
+		logger.Warnf("could not write the %s event to -events-file: %s", event.Event, err)
+	}
+}
+
+func (l *eventLog) close() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if l.file != nil {
+		l.file.Close()
+		l.file = nil
+	}
+}
+
+// postedEvent is the pending_posted or terminal_posted event for posting
+// state, with err when the post failed.
+func postedEvent(flags Flags, state string, err error) runEvent {
+	event := runEvent{Event: "terminal_posted", Repository: flags.OrgRepo, SHA: flags.SHA, Context: flags.Context, State: state}
+	if state == "pending" {
+		event.Event = "pending_posted"
+	}
+	if err != nil {
+		event.Error = strings.TrimPrefix(err.Error(), "Error: ")
+	}
+	return event
+}
+
+// startedEvent is the command_started event for running args, with secrets
+// masked.
+func startedEvent(args, secrets []string) runEvent {
+	masked := make([]string, len(args))
+	for i, arg := range args {
+		masked[i] = maskText(arg, secrets)
+	}
+	return runEvent{Event: "command_started", Command: masked}
+}
+
+// exitedEvent is the command_exited event for result.
+func exitedEvent(result *commandResult) runEvent {
+	exitCode := result.ExitCode
+	event := runEvent{Event: "command_exited", ExitCode: &exitCode, DurationSeconds: result.Duration.Seconds(), Attempts: len(result.Attempts)}
+	if result.Err != nil {
+		event.Error = result.Err.Error()
+	}
+	return event
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// readEvents returns the events in an -events-file, in order.
+func readEvents(t *testing.T, path string) []runEvent {
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var lines []runEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event runEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Expected a JSON event, got %s", scanner.Text())
+		}
+		lines = append(lines, event)
+	}
+	return lines
+}
+
+func TestCLIEventsFileTimeline(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events.jsonl")
+	out, code := runCLI(t, "-dry-run", "-events-file", path, "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"-mask-string", "hunter22", "sh", "-c", "echo hunter22")
+	if code != 0 {
+		t.Fatalf("Expected exit code 0, got %d:\n%s", code, out)
+	}
+
+	timeline := readEvents(t, path)
+	var names []string
+	for _, event := range timeline {
+		names = append(names, event.Event)
+		if event.Timestamp == "" {
+			t.Errorf("Expected a timestamp on %+v", event)
+		}
+	}
+	expected := "resolved_sha pending_posted command_started command_exited terminal_posted"
+	if strings.Join(names, " ") != expected {
+		t.Fatalf("Expected the events %q, got %q", expected, names)
+	}
+	if timeline[0].SHA != "deadbeef" || timeline[0].Repository != "org/repo" {
+		t.Errorf("Unexpected resolved_sha event %+v", timeline[0])
+	}
+	if timeline[1].State != "pending" || timeline[4].State != "success" || timeline[4].Error != "" {
+		t.Errorf("Unexpected posted events %+v and %+v", timeline[1], timeline[4])
+	}
+	if strings.Join(timeline[2].Command, " ") != "sh -c echo ***" {
+		t.Errorf("Expected the masked command, got %q", timeline[2].Command)
+	}
+	if timeline[3].ExitCode == nil || *timeline[3].ExitCode != 0 || timeline[3].Attempts != 1 {
+		t.Errorf("Unexpected command_exited event %+v", timeline[3])
+	}
+	var last time.Time
+	for _, event := range timeline {
+		stamp, err := time.Parse(time.RFC3339Nano, event.Timestamp)
+		if err != nil || stamp.Before(last) {
+			t.Errorf("Expected the events in time order, got %s after %s", event.Timestamp, last)
+		}
+		last = stamp
+	}
+}
+
+func TestEventLogIsNilSafe(t *testing.T) {
+	var log *eventLog
+	log.record(runEvent{Event: "resolved_sha"})
+}
@@ -0,0 +1,25 @@
+#!/bin/sh
+# Example gh-status-reporter notify plugin.
+#
+# gh-status-reporter runs each -notify-plugin at every status transition with
+# the JSON event on stdin and these variables set:
+#
+#   STATUS_EVENT       pending or completed
+#   STATUS_STATE       pending, success, failure or error
+#   STATUS_ORG_REPO    organization/repository the status was posted to
+#   STATUS_SHA         commit SHA
+#   STATUS_CONTEXT     commit status context
+#   STATUS_TARGET_URL  commit status target_url, possibly empty
+#
+# A non-zero exit is reported as a warning and only fails the run when
+# -plugin-strict is set.
+#
+# This plugin appends each event to the file named by NOTIFY_PLUGIN_LOG,
+# defaulting to ./status-events.jsonl.
+
+set -e
+
+log="${NOTIFY_PLUGIN_LOG:-status-events.jsonl}"
+cat >> "$log"
+echo >> "$log"
+echo "notify-plugin: $STATUS_CONTEXT is $STATUS_STATE on $STATUS_ORG_REPO@$STATUS_SHA" 1>&2
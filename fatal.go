@@ -0,0 +1,87 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// errorKind classifies fatal errors for -json-errors.
+type errorKind string
+
+const (
+	errorKindGithubAPI errorKind = "github_api"
+	errorKindConfig    errorKind = "config"
+	errorKindCommand   errorKind = "command"
+)
+
+// kindError marks err as being of a kind that can't be told from its type.
+type kindError struct {
+	Kind errorKind
+	Err  error
+}
+
+func (e *kindError) Error() string {
+	return e.Err.Error()
+}
+
+// githubAPIError marks err as a failure to talk to the Github API.
+func githubAPIError(err error) error {
+	return &kindError{errorKindGithubAPI, err}
+}
+
+// errorKindOf classifies err. Anything that isn't a Github API or command
+// failure is a problem with the configuration.
+func errorKindOf(err error) errorKind {
+	switch e := err.(type) {
+	case *kindError:
+		return e.Kind
+	case *APIError:
+		return errorKindGithubAPI
+	case *targetError:
+		return errorKindGithubAPI
+	case multiError:
+		if len(e) > 0 {
+			return errorKindOf(e[0])
+		}
+	}
+	return errorKindConfig
+}
+
+// fatalErrors configures how fatal errors are reported: as text through the
+// logger, or with -json-errors as a single JSON object on stderr with
+// Secrets redacted.
+var fatalErrors struct {
+	JSON    bool
+	Secrets []string
+}
+
+// fatalErrorJSON is the object -json-errors prints.
+type fatalErrorJSON struct {
+	Error    string    `json:"error"`
+	Kind     errorKind `json:"kind"`
+	ExitCode int       `json:"exit_code"`
+}
+
+// formatFatalError renders err for -json-errors.
+func formatFatalError(err error, code int, secrets []string) string {
+	encoded, _ := json.Marshal(fatalErrorJSON{
+		Error:    strings.TrimPrefix(maskText(err.Error(), secrets), "Error: "),
+		Kind:     errorKindOf(err),
+		ExitCode: code,
+	})
+	return string(encoded)
+}
+
+// exitWithError reports err and exits with code. text is the message printed
+// without -json-errors; an empty text prints nothing, for failures the
+// command's own output already explains.
+func exitWithError(err error, code int, text string) {
+	if fatalErrors.JSON {
+		fmt.Fprintf(os.Stderr, "%s\n", formatFatalError(err, code, fatalErrors.Secrets))
+	} else if text != "" {
+		logger.Errorf("%s", text)
+	}
+	exit(code)
+}
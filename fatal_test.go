@@ -0,0 +1,75 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestFormatFatalErrorKinds(t *testing.T) {
+	apiError := &APIError{Message: "Error creating commit status on Github", StatusCode: 403}
+	for _, test := range []struct {
+		err  error
+		kind errorKind
+	}{
+		{apiError, errorKindGithubAPI},
+		{multiError{&targetError{statusTarget{"org/repo", "deadbeef"}, apiError}}, errorKindGithubAPI},
+		{githubAPIError(errors.New("Error executing request to Github: connection refused")), errorKindGithubAPI},
+		{&kindError{errorKindConfig, multiError{errors.New("Error: no command given")}}, errorKindConfig},
+		{errors.New("Error reading env file: missing.env"), errorKindConfig},
+		{&kindError{errorKindCommand, errors.New("exit status 3")}, errorKindCommand},
+	} {
+		var decoded map[string]interface{}
+		if err := json.Unmarshal([]byte(formatFatalError(test.err, 1, nil)), &decoded); err != nil {
+			t.Fatalf("Expected JSON for %v: %s", test.err, err)
+		}
+		if decoded["kind"] != string(test.kind) || decoded["exit_code"] != 1.0 || decoded["error"] == "" || len(decoded) != 3 {
+			t.Errorf("Expected kind %s for %v, got %v", test.kind, test.err, decoded)
+		}
+	}
+}
+
+func TestFormatFatalErrorRedactsSecrets(t *testing.T) {
+	err := fmt.Errorf("Error: invalid -proxy-auth user:hunter2 for ghp_secret")
+	formatted := formatFatalError(err, 1, []string{"ghp_secret", "", "hunter2"})
+	if formatted != `{"error":"invalid -proxy-auth user:*** for ***","kind":"config","exit_code":1}` {
+		t.Errorf("Unexpected formatted error %s", formatted)
+	}
+}
+
+// lastJSONLine decodes the last line of out, where -json-errors prints.
+func lastJSONLine(t *testing.T, out string) fatalErrorJSON {
+	lines := strings.Split(strings.TrimSpace(out), "\n")
+	var decoded fatalErrorJSON
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &decoded); err != nil {
+		t.Fatalf("Expected a JSON error as the last line, got:\n%s", out)
+	}
+	return decoded
+}
+
+func TestCLIJSONErrors(t *testing.T) {
+	replay := filepath.Join("testdata", "replay-failure.json")
+	for _, test := range []struct {
+		args []string
+		kind errorKind
+	}{
+		{[]string{"-r", "org/repo", "true"}, errorKindConfig},
+		{[]string{"-replay", replay, "-r", "org/repo", "-s", "deadbeef", "-c", "lint", "-d", "unit test", "-a", "ghp_cli_secret", "true"}, errorKindGithubAPI},
+		{[]string{"-replay", replay, "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "ghp_cli_secret", "sh", "-c", "exit 3"}, errorKindCommand},
+	} {
+		out, code := runCLI(t, append([]string{"-json-errors"}, test.args...)...)
+		decoded := lastJSONLine(t, out)
+		if decoded.Kind != test.kind || decoded.ExitCode != code || code != 1 || decoded.Error == "" {
+			t.Errorf("Expected a %s error with exit code %d, got %+v:\n%s", test.kind, code, decoded, out)
+		}
+		if strings.Contains(out, "ghp_cli_secret") {
+			t.Errorf("Expected the token to be redacted, got:\n%s", out)
+		}
+		if test.kind == errorKindConfig && strings.Contains(out, "Run with -help") {
+			t.Errorf("Expected only the JSON error, got:\n%s", out)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"fmt"
+)
+
+// commitParents returns the SHAs of the parents of target's commit, first
+// parent first.
+func commitParents(target statusTarget, flags Flags) ([]string, error) {
+	var commit struct {
+		Parents []struct {
+			SHA string `json:"sha"`
+		} `json:"parents"`
+	}
+	url := githubAPIURL + "/repos/" + target.OrgRepo + "/commits/" + target.SHA
+	if err := getGithubJSON(url, flags, &commit); err != nil {
+		return nil, fmt.Errorf("Error getting the parents of %s in %s: %s", target.SHA, target.OrgRepo, err)
+	}
+	parents := make([]string, len(commit.Parents))
+	for i, parent := range commit.Parents {
+		parents[i] = parent.SHA
+	}
+	return parents, nil
+}
+
+// firstParentTargets adds, after targets, a target for the first parent of
+// each target that is a merge commit, for -include-first-parent. Commits with
+// a single parent add nothing, as their parent isn't what was pushed. Every
+// target is looked up even when some fail, and the failures are returned
+// together.
+func firstParentTargets(targets []statusTarget, flags Flags) ([]statusTarget, error) {
+	all := append([]statusTarget{}, targets...)
+	seen := map[statusTarget]bool{}
+	for _, target := range targets {
+		seen[target] = true
+	}
+	var errs multiError
+	for _, target := range targets {
+		parents, err := commitParents(target, flags)
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if len(parents) < 2 {
+			logger.Debugf("%s in %s is not a merge commit, not posting to its parent", target.SHA, target.OrgRepo)
+			continue
+		}
+		parent := statusTarget{OrgRepo: target.OrgRepo, SHA: parents[0]}
+		if !seen[parent] {
+			seen[parent] = true
+			logger.Infof("Also posting to %s, the first parent of merge commit %s in %s", parent.SHA, target.SHA, target.OrgRepo)
+			all = append(all, parent)
+		}
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	return all, nil
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestFirstParentTargets(t *testing.T) {
+	withLogger(t, logWarn)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		switch r.URL.Path {
+		case "/repos/org/repo/commits/merge":
+			fmt.Fprint(w, `{"sha": "merge", "parents": [{"sha": "pushed", "url": "x"}, {"sha": "main", "url": "y"}]}`)
+		case "/repos/org/mirror/commits/merge":
+			fmt.Fprint(w, `{"sha": "merge", "parents": [{"sha": "pushed"}, {"sha": "main"}]}`)
+		case "/repos/org/repo/commits/plain":
+			fmt.Fprint(w, `{"sha": "plain", "parents": [{"sha": "before"}]}`)
+		default:
+			w.WriteHeader(http.StatusNotFound)
+			fmt.Fprint(w, `{"message": "Not Found"}`)
+		}
+	})()
+
+	targets := []statusTarget{{"org/repo", "merge"}, {"org/mirror", "merge"}, {"org/repo", "plain"}}
+	all, err := firstParentTargets(targets, *defaultFlags())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := append(append([]statusTarget{}, targets...), statusTarget{"org/repo", "pushed"}, statusTarget{"org/mirror", "pushed"})
+	if !reflect.DeepEqual(all, expected) {
+		t.Errorf("Expected %v, got %v", expected, all)
+	}
+
+	// Every lookup is made and the failures come back together.
+	_, err = firstParentTargets([]statusTarget{{"org/repo", "missing"}, {"org/repo", "merge"}, {"org/other", "merge"}}, *defaultFlags())
+	errs, ok := err.(multiError)
+	if !ok || len(errs) != 2 || !strings.Contains(errs[0].Error(), "missing in org/repo") || !strings.Contains(errs[1].Error(), "merge in org/other") {
+		t.Errorf("Expected both failures, got %v", err)
+	}
+}
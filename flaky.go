@@ -0,0 +1,77 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+// flakyAttempt returns the attempt the command finally passed on, or 0 if it
+// didn't need a retry to pass.
+func flakyAttempt(result *commandResult) int {
+	if result.Err != nil || len(result.Attempts) < 2 {
+		return 0
+	}
+	return len(result.Attempts)
+}
+
+// applyFlakiness notes in the description that a successful command needed
+// retries, and returns the description to report. With -fail-on-flaky the
+// result is turned into a failure instead.
+func applyFlakiness(flags Flags, result *commandResult) string {
+	attempt := flakyAttempt(result)
+	if attempt == 0 {
+		return flags.Description
+	}
+	note := fmt.Sprintf("flaky: passed on attempt %d", attempt)
+	if flags.FailOnFlaky {
+		result.Err = errors.New(note)
+	}
+	return appendSuffix(flags.Description, note)
+}
+
+// retryPattern returns the first of patterns that matches output, or nil.
+func retryPattern(patterns []*regexp.Regexp, output string) *regexp.Regexp {
+	for _, pattern := range patterns {
+		if pattern.MatchString(output) {
+			return pattern
+		}
+	}
+	return nil
+}
+
+// parseRetryExitCodes parses -retry-command-on, a comma separated list of
+// exit codes.
+func parseRetryExitCodes(list string) (map[int]bool, error) {
+	codes := map[int]bool{}
+	for _, item := range splitList(list) {
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 0 || code > 255 {
+			return nil, fmt.Errorf("Error: invalid -retry-command-on exit code %q", item)
+		}
+		if code == 0 {
+			return nil, errors.New("Error: -retry-command-on can't retry exit code 0, which is a success")
+		}
+		codes[code] = true
+	}
+	return codes, nil
+}
+
+// applyRetryReasons notes in the description which -retry-on-output
+// patterns caused retries, and returns the description to report.
+func applyRetryReasons(flags Flags, result *commandResult) string {
+	var reasons []string
+	seen := map[string]bool{}
+	for _, attempt := range result.Attempts {
+		if attempt.RetriedOn != "" && !seen[attempt.RetriedOn] {
+			seen[attempt.RetriedOn] = true
+			reasons = append(reasons, fmt.Sprintf("%q", attempt.RetriedOn))
+		}
+	}
+	if len(reasons) == 0 {
+		return flags.Description
+	}
+	return appendSuffix(flags.Description, "retried due to output matching "+strings.Join(reasons, ", "))
+}
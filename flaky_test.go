@@ -0,0 +1,108 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestApplyFlakinessNotesRetriedSuccess(t *testing.T) {
+	flags := defaultFlags()
+	result := &commandResult{Attempts: []commandAttempt{{ExitCode: 1}, {ExitCode: 1}, {ExitCode: 0}}}
+
+	if description := applyFlakiness(*flags, result); description != "unit test (flaky: passed on attempt 3)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if result.Err != nil {
+		t.Errorf("Expected a flaky run to stay successful, got %s", result.Err)
+	}
+}
+
+func TestApplyFlakinessFailOnFlaky(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnFlaky = true
+	result := &commandResult{Attempts: []commandAttempt{{ExitCode: 1}, {ExitCode: 0}}}
+
+	applyFlakiness(*flags, result)
+	if commandState(result) != "failure" {
+		t.Errorf("Expected -fail-on-flaky to fail the run, got %s", commandState(result))
+	}
+}
+
+func TestApplyFlakinessIgnoresOtherRuns(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnFlaky = true
+	for _, result := range []*commandResult{
+		{Attempts: []commandAttempt{{ExitCode: 0}}},
+		{Attempts: []commandAttempt{{ExitCode: 1}, {ExitCode: 1}}, Err: errors.New("exit status 1"), ExitCode: 1},
+	} {
+		before := result.Err
+		if description := applyFlakiness(*flags, result); description != "unit test" || result.Err != before {
+			t.Errorf("Expected %+v to be left alone, got %q", result, description)
+		}
+	}
+}
+
+func TestApplyRetryReasons(t *testing.T) {
+	flags := defaultFlags()
+	result := &commandResult{Attempts: []commandAttempt{
+		{ExitCode: 1, RetriedOn: "connection reset by peer"},
+		{ExitCode: 1, RetriedOn: "connection reset by peer"},
+		{ExitCode: 0},
+	}}
+	expected := `unit test (retried due to output matching "connection reset by peer")`
+	if description := applyRetryReasons(*flags, result); description != expected {
+		t.Errorf("Expected %q, got %q", expected, description)
+	}
+
+	result = &commandResult{Attempts: []commandAttempt{{ExitCode: 1}, {ExitCode: 0}}}
+	if description := applyRetryReasons(*flags, result); description != "unit test" {
+		t.Errorf("Expected plain retries to be left alone, got %q", description)
+	}
+}
+
+func TestCompilePatternsRejectsInvalid(t *testing.T) {
+	if _, err := compilePatterns("-retry-on-output", []string{"timed out", "(unclosed"}); err == nil || !strings.Contains(err.Error(), `invalid -retry-on-output "(unclosed"`) {
+		t.Errorf("Expected the invalid pattern to be named, got %v", err)
+	}
+}
+
+func TestValidateFlagsRetryOnOutputNeedsRetries(t *testing.T) {
+	flags := defaultFlags()
+	flags.RetryOnOutput = []string{"connection reset by peer"}
+	if err := validateFlags(*flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), "-retry-on-output requires -command-retries") {
+		t.Errorf("Expected -retry-on-output without retries to be rejected, got %v", err)
+	}
+	flags.CommandRetries = 2
+	if err := validateFlags(*flags, []string{"make"}, ""); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+}
+
+func TestParseRetryExitCodes(t *testing.T) {
+	codes, err := parseRetryExitCodes("2, 137")
+	if err != nil || len(codes) != 2 || !codes[2] || !codes[137] {
+		t.Errorf("Expected codes 2 and 137, got %v, %v", codes, err)
+	}
+	for list, expected := range map[string]string{
+		"2,oom": `invalid -retry-command-on exit code "oom"`,
+		"256":   `invalid -retry-command-on exit code "256"`,
+		"0":     "can't retry exit code 0",
+	} {
+		if _, err := parseRetryExitCodes(list); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %q, got %v", expected, list, err)
+		}
+	}
+}
+
+func TestValidateFlagsRetryCommandOnNeedsRetries(t *testing.T) {
+	flags := defaultFlags()
+	flags.RetryCommandOn = "137"
+	if err := validateFlags(*flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), "-retry-command-on requires -command-retries") {
+		t.Errorf("Expected -retry-command-on without retries to be rejected, got %v", err)
+	}
+	flags.CommandRetries = 2
+	if err := validateFlags(*flags, []string{"make"}, ""); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+}
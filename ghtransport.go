@@ -0,0 +1,155 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/textproto"
+	"net/url"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// transportMode is the value of the -transport flag: how requests reach the
+// Github API.
+type transportMode string
+
+const (
+	transportHTTP transportMode = ""
+	transportGH   transportMode = "gh"
+)
+
+func (m *transportMode) String() string {
+	return string(*m)
+}
+
+func (m *transportMode) Set(value string) error {
+	switch value {
+	case "http":
+		*m = transportHTTP
+	case "gh":
+		*m = transportGH
+	default:
+		return fmt.Errorf("expected http or gh, got %q", value)
+	}
+	return nil
+}
+
+func (m *transportMode) completionValues() []string {
+	return []string{"http", "gh"}
+}
+
+// ghPath returns the gh executable -transport gh runs.
+func ghPath() (string, error) {
+	path, err := exec.LookPath("gh")
+	if err != nil {
+		return "", errors.New("Error: -transport gh needs the GitHub CLI, but gh is not on PATH; install it from https://cli.github.com and run gh auth login")
+	}
+	return path, nil
+}
+
+// ghTransport sends Github API requests with gh api, so they are made with
+// the host and credentials gh is logged in with instead of -a.
+type ghTransport struct {
+	gh string
+}
+
+func (g *ghTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	hostname, endpoint := ghEndpoint(req.URL)
+	args := []string{"api", endpoint, "--method", req.Method, "--include"}
+	if hostname != "" {
+		args = append(args, "--hostname", hostname)
+	}
+	names := make([]string, 0, len(req.Header))
+	for name := range req.Header {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		// gh authenticates the request itself.
+		if name == "Authorization" {
+			continue
+		}
+		for _, value := range req.Header[name] {
+			args = append(args, "-H", name+": "+value)
+		}
+	}
+	if req.Body != nil {
+		args = append(args, "--input", "-")
+	}
+
+	cmd := exec.CommandContext(req.Context(), g.gh, args...)
+	if req.Body != nil {
+		cmd.Stdin = req.Body
+	}
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout, cmd.Stderr = &stdout, &stderr
+	runErr := cmd.Run()
+	// gh exits non-zero for error responses but still prints them, which
+	// the callers handle like any other response.
+	resp, err := parseGHResponse(stdout.Bytes(), req)
+	if err != nil && runErr != nil {
+		return nil, fmt.Errorf("gh api %s failed: %s: %s", endpoint, runErr, strings.TrimSpace(stderr.String()))
+	}
+	return resp, err
+}
+
+// ghEndpoint returns the gh api --hostname and endpoint for a Github API
+// URL. The hostname is empty for github.com, and Enterprise Server's /api/v3
+// prefix is left to gh.
+func ghEndpoint(u *url.URL) (string, string) {
+	hostname, path := "", u.Path
+	if u.Host != "api.github.com" {
+		hostname = u.Host
+		if path == "/api/graphql" {
+			path = "/graphql"
+		}
+		path = strings.TrimPrefix(path, "/api/v3")
+	}
+	endpoint := strings.TrimPrefix(path, "/")
+	if endpoint == "" {
+		endpoint = "/"
+	}
+	if u.RawQuery != "" {
+		endpoint += "?" + u.RawQuery
+	}
+	return hostname, endpoint
+}
+
+// parseGHResponse parses the response gh api --include printed: the status
+// line, the headers and the body, which gh has already decoded.
+func parseGHResponse(out []byte, req *http.Request) (*http.Response, error) {
+	reader := bufio.NewReader(bytes.NewReader(out))
+	headers := textproto.NewReader(reader)
+	line, err := headers.ReadLine()
+	parts := strings.SplitN(line, " ", 3)
+	if err != nil || len(parts) < 2 || !strings.HasPrefix(parts[0], "HTTP/") {
+		return nil, fmt.Errorf("Error: gh api printed no HTTP response for %s %s", req.Method, redactURL(req.URL.String()))
+	}
+	code, err := strconv.Atoi(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("Error: gh api printed an invalid status line %q", line)
+	}
+	header, err := headers.ReadMIMEHeader()
+	if err != nil && len(header) == 0 {
+		return nil, fmt.Errorf("Error reading the headers gh api printed: %s", err)
+	}
+	body, _ := ioutil.ReadAll(reader)
+	resp := &http.Response{
+		Status:        strings.Join(parts[1:], " "),
+		StatusCode:    code,
+		Proto:         parts[0],
+		Header:        http.Header(header),
+		Body:          ioutil.NopCloser(bytes.NewReader(body)),
+		ContentLength: int64(len(body)),
+		Request:       req,
+	}
+	resp.Header.Del("Content-Length")
+	resp.Header.Del("Content-Encoding")
+	return resp, nil
+}
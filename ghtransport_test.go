@@ -0,0 +1,144 @@
+package main
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// withFakeGH puts a gh on PATH that records its arguments and stdin in dir
+// and prints response, exiting with code.
+func withFakeGH(t *testing.T, response string, code int) (dir string) {
+	dir = t.TempDir()
+	script := "#!/bin/sh\nprintf '%s\\n' \"$@\" > \"" + dir + "/args\"\ncat > \"" + dir + "/stdin\"\ncat \"" + dir + "/response\"\nexit " + string(rune('0'+code)) + "\n"
+	if err := ioutil.WriteFile(filepath.Join(dir, "gh"), []byte(script), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(filepath.Join(dir, "response"), []byte(response), 0644); err != nil {
+		t.Fatal(err)
+	}
+	withPath(t, dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	return dir
+}
+
+// withPath sets PATH for the test.
+func withPath(t *testing.T, path string) {
+	original := os.Getenv("PATH")
+	os.Setenv("PATH", path)
+	t.Cleanup(func() { os.Setenv("PATH", original) })
+}
+
+func readFakeGH(t *testing.T, dir, name string) string {
+	contents, err := ioutil.ReadFile(filepath.Join(dir, name))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(contents)
+}
+
+func TestGHEndpoint(t *testing.T) {
+	for raw, expected := range map[string]string{
+		"https://api.github.com/repos/org/repo/statuses/deadbeef":   " repos/org/repo/statuses/deadbeef",
+		"https://api.github.com/graphql":                            " graphql",
+		"https://api.github.com/repos/org/repo/pulls?state=open":    " repos/org/repo/pulls?state=open",
+		"https://ghe.example.com/api/v3/repos/org/repo/commits/abc": "ghe.example.com repos/org/repo/commits/abc",
+		"https://ghe.example.com/api/graphql":                       "ghe.example.com graphql",
+	} {
+		u, _ := url.Parse(raw)
+		if hostname, endpoint := ghEndpoint(u); hostname+" "+endpoint != expected {
+			t.Errorf("Expected %q for %s, got %q and %q", expected, raw, hostname, endpoint)
+		}
+	}
+}
+
+func TestParseGHResponse(t *testing.T) {
+	req, _ := http.NewRequest("GET", "https://api.github.com/user", nil)
+	resp, err := parseGHResponse([]byte("HTTP/2.0 200 OK\r\nX-Oauth-Scopes: repo\r\nContent-Length: 999\r\n\r\n{\"login\": \"octocat\"}"), req)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != 200 || resp.Header.Get("X-OAuth-Scopes") != "repo" || resp.Header.Get("Content-Length") != "" || string(body) != `{"login": "octocat"}` {
+		t.Errorf("Unexpected response %d %v %q", resp.StatusCode, resp.Header, body)
+	}
+	if _, err := parseGHResponse([]byte("gh: not logged in"), req); err == nil || !strings.Contains(err.Error(), "printed no HTTP response") {
+		t.Errorf("Expected output without a response to be an error, got %v", err)
+	}
+}
+
+func TestGHTransportPostsStatus(t *testing.T) {
+	dir := withFakeGH(t, "HTTP/2.0 201 Created\nContent-Type: application/json\n\n{}", 0)
+	flags := defaultFlags()
+	flags.Transport, flags.Auth = transportGH, ""
+	if err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "success"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	args := readFakeGH(t, dir, "args")
+	if !strings.HasPrefix(args, "api\nrepos/org/repo/statuses/deadbeef\n--method\nPOST\n--include\n") || !strings.HasSuffix(args, "--input\n-\n") {
+		t.Errorf("Unexpected gh arguments:\n%s", args)
+	}
+	if strings.Contains(args, "Authorization") {
+		t.Errorf("Expected gh to authenticate the request, got:\n%s", args)
+	}
+	if stdin := readFakeGH(t, dir, "stdin"); !strings.Contains(stdin, `"state":"success"`) || !strings.Contains(stdin, `"context":"ci"`) {
+		t.Errorf("Expected the status on stdin, got %q", stdin)
+	}
+}
+
+func TestGHTransportPassesHeaders(t *testing.T) {
+	dir := withFakeGH(t, "HTTP/2.0 200 OK\n\n{\"state\": \"success\"}", 0)
+	flags := defaultFlags()
+	flags.Transport = transportGH
+	response, err := githubRequest("GET", githubAPIURL+"/repos/org/repo/commits/deadbeef/status", *flags, nil)
+	if err != nil || response.StatusCode != 200 || string(response.Body) != `{"state": "success"}` {
+		t.Fatalf("Unexpected response %+v, %v", response, err)
+	}
+	if args := readFakeGH(t, dir, "args"); !strings.Contains(args, "-H\nAccept: application/vnd.github+json\n") || strings.Contains(args, "--input") {
+		t.Errorf("Expected the Accept header and no input, got:\n%s", args)
+	}
+}
+
+func TestGHTransportErrorResponse(t *testing.T) {
+	withFakeGH(t, "HTTP/2.0 422 Unprocessable Entity\n\n{\"message\": \"Validation Failed\"}", 1)
+	flags := defaultFlags()
+	flags.Transport = transportGH
+	err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "success")
+	if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != 422 || !strings.Contains(err.Error(), "Validation Failed") {
+		t.Errorf("Expected gh's error response as an API error, got %v", err)
+	}
+
+	withFakeGH(t, "", 1)
+	if err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *flags, "success"); err == nil || !strings.Contains(err.Error(), "gh api repos/org/repo/statuses/deadbeef failed") {
+		t.Errorf("Expected gh failing without a response to be an error, got %v", err)
+	}
+}
+
+func TestValidateFlagsTransportGH(t *testing.T) {
+	flags := defaultFlags()
+	flags.Transport, flags.Auth = transportGH, ""
+	withPath(t, t.TempDir())
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "gh is not on PATH") {
+		t.Errorf("Expected a missing gh to be an error, got %v", err)
+	}
+	withFakeGH(t, "", 0)
+	if err := validateFlags(*flags, []string{"true"}, ""); err != nil {
+		t.Errorf("Expected gh to need no -a, got %s", err)
+	}
+}
+
+func TestTransportModeSet(t *testing.T) {
+	var mode transportMode
+	if err := mode.Set("gh"); err != nil || mode != transportGH {
+		t.Errorf("Expected gh, got %q, %v", mode, err)
+	}
+	if err := mode.Set("http"); err != nil || mode != transportHTTP {
+		t.Errorf("Expected http, got %q, %v", mode, err)
+	}
+	if err := mode.Set("curl"); err == nil {
+		t.Errorf("Expected an unknown transport to be rejected")
+	}
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+)
+
+// combinedStatus is the response of the combined status endpoint: the
+// overall state of a commit and the latest status for each context.
+type combinedStatus struct {
+	State    string         `json:"state"`
+	SHA      string         `json:"sha"`
+	Statuses []commitStatus `json:"statuses"`
+	// CheckRuns is only filled in by -graphql; the combined status endpoint
+	// knows nothing about checks.
+	CheckRuns []checkRun `json:"check_runs,omitempty"`
+}
+
+// checkRun is a check run on a commit, with State giving the commit status
+// state it corresponds to.
+type checkRun struct {
+	Name       string `json:"name"`
+	Status     string `json:"status"`
+	Conclusion string `json:"conclusion"`
+	DetailsURL string `json:"details_url"`
+	State      string `json:"state"`
+}
+
+// commitStatus is a single status as returned by the GitHub API.
+type commitStatus struct {
+	State       string `json:"state"`
+	Description string `json:"description"`
+	TargetUrl   string `json:"target_url"`
+	Context     string `json:"context"`
+	CreatedAt   string `json:"created_at"`
+	UpdatedAt   string `json:"updated_at"`
+}
+
+// githubRequest sends a request with an optional JSON body to the GitHub API
+// and returns the response. Non-2xx responses are not treated as errors; that
+// is up to the caller. GET requests are made conditional on the ETag or
+// Last-Modified of an earlier response in this run, or with -cache-dir a
+// previous run, and a 304 response returns the cached body as a 200.
+func githubRequest(method, url string, flags Flags, body interface{}) (*apiResponse, error) {
+	var requestBody io.Reader
+	var contentEncoding string
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("Error converting %+v to json %s.", body, err)
+		}
+		encoded, contentEncoding = compressBody(flags, encoded)
+		requestBody = bytes.NewReader(encoded)
+	}
+
+	req, err := http.NewRequest(method, url, requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request to Github: %s", err)
+	}
+	req.SetBasicAuth(flags.Username, flags.Auth)
+	req.Header.Set("Accept", "application/vnd.github+json")
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if contentEncoding != "" {
+		req.Header.Set("Content-Encoding", contentEncoding)
+	}
+
+	var cached *cachedResponse
+	cacheable := method == "GET"
+	if cacheable {
+		cached = loadRunCachedResponse(flags, url)
+		if cached == nil && flags.CacheDir != "" {
+			cached = loadCachedResponse(flags, url)
+		}
+		if cached != nil && cached.ETag != "" {
+			req.Header.Set("If-None-Match", cached.ETag)
+		}
+		if cached != nil && cached.LastModified != "" {
+			req.Header.Set("If-Modified-Since", cached.LastModified)
+		}
+	}
+
+	client, err := newHTTPClient(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, githubAPIError(fmt.Errorf("Error executing request to Github: %s", err))
+	}
+	defer resp.Body.Close()
+
+	response, err := readAPIResponse(method, url, resp, flags.ResponseBodyLimit)
+	if err != nil {
+		return nil, err
+	}
+
+	if cacheable {
+		if resp.StatusCode == http.StatusNotModified && cached != nil {
+			logger.Debugf("Cache hit for %s: not modified", redactURL(url))
+			response.StatusCode, response.Body, response.Truncated = http.StatusOK, cached.Body, false
+			return response, nil
+		}
+		etag, lastModified := resp.Header.Get("ETag"), resp.Header.Get("Last-Modified")
+		if resp.StatusCode == http.StatusOK && !response.Truncated && (etag != "" || lastModified != "") {
+			if cached != nil {
+				logger.Debugf("Cache miss for %s: changed", redactURL(url))
+			}
+			fresh := &cachedResponse{URL: url, ETag: etag, LastModified: lastModified, Body: response.Body}
+			storeRunCachedResponse(flags, fresh)
+			if flags.CacheDir != "" && etag != "" {
+				storeCachedResponse(flags, fresh)
+			}
+		}
+	}
+	return response, nil
+}
+
+// githubJSON sends body to url and decodes a successful response into v,
+// which may be nil.
+func githubJSON(method, url string, flags Flags, body interface{}, v interface{}) error {
+	response, err := githubRequest(method, url, flags, body)
+	if err != nil {
+		return err
+	}
+	if !response.ok() {
+		return response.error("")
+	}
+	if v == nil {
+		return nil
+	}
+	if err := json.Unmarshal(response.Body, v); err != nil {
+		if response.Truncated {
+			return fmt.Errorf("Error parsing response from Github: %s (truncated to -response-body-limit %d bytes)", err, len(response.Body))
+		}
+		return fmt.Errorf("Error parsing response from Github: %s", err)
+	}
+	return nil
+}
+
+// getGithubJSON GETs url and decodes the JSON response into v.
+func getGithubJSON(url string, flags Flags, v interface{}) error {
+	return githubJSON("GET", url, flags, nil, v)
+}
+
+// getCombinedStatus fetches the latest status of every context on target.
+func getCombinedStatus(target statusTarget, flags Flags) (*combinedStatus, error) {
+	if flags.GraphQL {
+		return getCombinedStatusGraphQL(target, flags)
+	}
+	url := githubAPIURL + "/repos/" + target.OrgRepo + "/commits/" + target.SHA + "/status?per_page=100"
+
+	var status combinedStatus
+	if err := getGithubJSON(url, flags, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// defaultContext is the context Github assigns to statuses posted without
+// one.
+const defaultContext = "default"
+
+// find returns the latest status for context, or nil if there is none. An
+// empty context finds the default one.
+func (c *combinedStatus) find(context string) *commitStatus {
+	if context == "" {
+		context = defaultContext
+	}
+	for i := range c.Statuses {
+		if c.Statuses[i].Context == context {
+			return &c.Statuses[i]
+		}
+	}
+	return nil
+}
+
+// sameStatus reports whether posting params would leave existing unchanged.
+func sameStatus(existing *commitStatus, params CommitStatusParams) bool {
+	return existing != nil &&
+		existing.State == params.State &&
+		existing.Description == params.Description &&
+		existing.TargetUrl == params.TargetUrl
+}
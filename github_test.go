@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+const combinedStatusResponse = `{
+  "state": "success",
+  "sha": "deadbeef",
+  "statuses": [
+    {"state": "success", "description": "unit test", "target_url": "", "context": "ci"},
+    {"state": "failure", "description": "lint", "target_url": "https://example.com", "context": "lint"}
+  ]
+}`
+
+func TestGetCombinedStatus(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		expectedPath := "/repos/christopher-bui/gh-status-reporter/commits/deadbeef/status"
+		if r.Method != "GET" || r.URL.Path != expectedPath {
+			t.Errorf("Expected GET %s, got %s %s", expectedPath, r.Method, r.URL.Path)
+		}
+		fmt.Fprintln(w, combinedStatusResponse)
+	})()
+
+	flags := defaultFlags()
+	status, err := getCombinedStatus(statusTarget{flags.OrgRepo, flags.SHA}, *flags)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if status.State != "success" || len(status.Statuses) != 2 {
+		t.Errorf("Unexpected combined status %+v", status)
+	}
+	if lint := status.find("lint"); lint == nil || lint.State != "failure" {
+		t.Errorf("Expected to find the lint status, got %+v", lint)
+	}
+	if missing := status.find("deploy"); missing != nil {
+		t.Errorf("Expected no deploy status, got %+v", missing)
+	}
+	if missing := status.find(""); missing != nil {
+		t.Errorf("Expected no default status, got %+v", missing)
+	}
+
+	status.Statuses = append(status.Statuses, commitStatus{State: "pending", Context: "default"})
+	if fallback := status.find(""); fallback == nil || fallback.State != "pending" {
+		t.Errorf("Expected an empty context to find the default status, got %+v", fallback)
+	}
+}
+
+func TestSameStatus(t *testing.T) {
+	existing := &commitStatus{State: "success", Description: "unit test", Context: "ci"}
+	flags := defaultFlags()
+
+	if !sameStatus(existing, *statusParams(*flags, "success")) {
+		t.Error("Expected identical statuses to be the same")
+	}
+	if sameStatus(existing, *statusParams(*flags, "failure")) {
+		t.Error("Expected a different state to differ")
+	}
+	flags.TargetUrl = "https://example.com"
+	if sameStatus(existing, *statusParams(*flags, "success")) {
+		t.Error("Expected a different target_url to differ")
+	}
+	if sameStatus(nil, *statusParams(*flags, "success")) {
+		t.Error("Expected a missing status to differ")
+	}
+}
+
+func TestPostStatusSkipIfSame(t *testing.T) {
+	posts := 0
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == "GET" {
+			fmt.Fprintln(w, combinedStatusResponse)
+			return
+		}
+		posts++
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.SkipIfSame = true
+	targets := []statusTarget{{flags.OrgRepo, flags.SHA}}
+
+	if err := postStatus(targets, *flags, "success"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if posts != 0 {
+		t.Errorf("Expected an unchanged status not to be posted, got %d posts", posts)
+	}
+
+	if err := postStatus(targets, *flags, "failure"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if posts != 1 {
+		t.Errorf("Expected a changed status to be posted, got %d posts", posts)
+	}
+}
@@ -0,0 +1,183 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// graphqlURL is the GraphQL endpoint matching githubAPIURL. Github Enterprise
+// Server serves REST under /api/v3 and GraphQL under /api/graphql.
+func graphqlURL() string {
+	if strings.HasSuffix(githubAPIURL, "/api/v3") {
+		return strings.TrimSuffix(githubAPIURL, "/v3") + "/graphql"
+	}
+	return githubAPIURL + "/graphql"
+}
+
+// graphqlRateLimit is the cost accounting Github returns for a query.
+type graphqlRateLimit struct {
+	Cost      int `json:"cost"`
+	Remaining int `json:"remaining"`
+}
+
+// lowRateLimit is the remaining GraphQL budget below which a warning is
+// printed.
+const lowRateLimit = 100
+
+type graphqlError struct {
+	Message string `json:"message"`
+	Type    string `json:"type"`
+}
+
+// graphqlQuery runs query and decodes its data into v. Github reports most
+// GraphQL errors with a 200 response, so the errors field is checked too.
+func graphqlQuery(flags Flags, query string, variables map[string]interface{}, v interface{}) error {
+	var response struct {
+		Data   json.RawMessage `json:"data"`
+		Errors []graphqlError  `json:"errors"`
+	}
+	body := map[string]interface{}{"query": query, "variables": variables}
+	if err := githubJSON("POST", graphqlURL(), flags, body, &response); err != nil {
+		return err
+	}
+	if len(response.Errors) > 0 {
+		messages := make([]string, len(response.Errors))
+		for i, graphqlErr := range response.Errors {
+			messages[i] = graphqlErr.Message
+		}
+		return fmt.Errorf("Error: Github GraphQL query failed: %s", strings.Join(messages, "; "))
+	}
+	if err := json.Unmarshal(response.Data, v); err != nil {
+		return fmt.Errorf("Error parsing response from Github: %s", err)
+	}
+	return nil
+}
+
+const statusRollupQuery = `query($owner: String!, $name: String!, $ref: String!, $cursor: String) {
+  rateLimit { cost remaining }
+  repository(owner: $owner, name: $name) {
+    object(expression: $ref) {
+      ... on Commit {
+        oid
+        statusCheckRollup {
+          state
+          contexts(first: 100, after: $cursor) {
+            pageInfo { hasNextPage endCursor }
+            nodes {
+              __typename
+              ... on StatusContext { context state description targetUrl createdAt }
+              ... on CheckRun { name status conclusion detailsUrl startedAt completedAt }
+            }
+          }
+        }
+      }
+    }
+  }
+}`
+
+// rollupContext is a StatusContext or CheckRun in a statusCheckRollup.
+type rollupContext struct {
+	Typename    string `json:"__typename"`
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	TargetUrl   string `json:"targetUrl"`
+	CreatedAt   string `json:"createdAt"`
+	Name        string `json:"name"`
+	Status      string `json:"status"`
+	Conclusion  string `json:"conclusion"`
+	DetailsUrl  string `json:"detailsUrl"`
+	StartedAt   string `json:"startedAt"`
+	CompletedAt string `json:"completedAt"`
+}
+
+type statusRollupData struct {
+	RateLimit  graphqlRateLimit `json:"rateLimit"`
+	Repository *struct {
+		Object *struct {
+			OID               string `json:"oid"`
+			StatusCheckRollup *struct {
+				State    string `json:"state"`
+				Contexts struct {
+					PageInfo struct {
+						HasNextPage bool   `json:"hasNextPage"`
+						EndCursor   string `json:"endCursor"`
+					} `json:"pageInfo"`
+					Nodes []rollupContext `json:"nodes"`
+				} `json:"contexts"`
+			} `json:"statusCheckRollup"`
+		} `json:"object"`
+	} `json:"repository"`
+}
+
+// checkRunState maps a check run to the commit status state it corresponds
+// to.
+func checkRunState(run rollupContext) string {
+	if run.Status != "COMPLETED" {
+		return "pending"
+	}
+	switch run.Conclusion {
+	case "SUCCESS", "NEUTRAL", "SKIPPED":
+		return "success"
+	}
+	return "failure"
+}
+
+// getCombinedStatusGraphQL fetches the same information as the combined
+// status endpoint, plus check runs, in one query per 100 contexts.
+func getCombinedStatusGraphQL(target statusTarget, flags Flags) (*combinedStatus, error) {
+	parts := strings.SplitN(target.OrgRepo, "/", 2)
+	if len(parts) != 2 {
+		return nil, fmt.Errorf("Error: invalid repository %q", target.OrgRepo)
+	}
+
+	status := &combinedStatus{}
+	variables := map[string]interface{}{"owner": parts[0], "name": parts[1], "ref": target.SHA}
+	for {
+		var data statusRollupData
+		if err := graphqlQuery(flags, statusRollupQuery, variables, &data); err != nil {
+			return nil, err
+		}
+		if data.RateLimit.Remaining > 0 && data.RateLimit.Remaining < lowRateLimit {
+			logger.Warnf("only %d Github GraphQL rate limit points left", data.RateLimit.Remaining)
+		}
+		if data.Repository == nil || data.Repository.Object == nil {
+			return nil, fmt.Errorf("Error: %s has no commit %s", target.OrgRepo, target.SHA)
+		}
+		status.SHA = data.Repository.Object.OID
+		rollup := data.Repository.Object.StatusCheckRollup
+		if rollup == nil {
+			// Commits without any statuses or checks have no rollup.
+			status.State = "pending"
+			return status, nil
+		}
+		status.State = strings.ToLower(rollup.State)
+
+		for _, node := range rollup.Contexts.Nodes {
+			switch node.Typename {
+			case "StatusContext":
+				status.Statuses = append(status.Statuses, commitStatus{
+					State:       strings.ToLower(node.State),
+					Description: node.Description,
+					TargetUrl:   node.TargetUrl,
+					Context:     node.Context,
+					CreatedAt:   node.CreatedAt,
+				})
+			case "CheckRun":
+				status.CheckRuns = append(status.CheckRuns, checkRun{
+					Name:       node.Name,
+					Status:     strings.ToLower(node.Status),
+					Conclusion: strings.ToLower(node.Conclusion),
+					DetailsURL: node.DetailsUrl,
+					State:      checkRunState(node),
+				})
+			}
+		}
+
+		if !rollup.Contexts.PageInfo.HasNextPage {
+			return status, nil
+		}
+		variables["cursor"] = rollup.Contexts.PageInfo.EndCursor
+	}
+}
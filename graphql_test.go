@@ -0,0 +1,102 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestGraphqlURL(t *testing.T) {
+	original := githubAPIURL
+	defer func() { githubAPIURL = original }()
+
+	githubAPIURL = "https://api.github.com"
+	if graphqlURL() != "https://api.github.com/graphql" {
+		t.Errorf("Unexpected GraphQL URL %s", graphqlURL())
+	}
+	githubAPIURL = "https://github.example.com/api/v3"
+	if graphqlURL() != "https://github.example.com/api/graphql" {
+		t.Errorf("Unexpected GHES GraphQL URL %s", graphqlURL())
+	}
+}
+
+func TestGetCombinedStatusGraphQLPaginates(t *testing.T) {
+	var cursors []interface{}
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "POST" || r.URL.Path != "/graphql" {
+			t.Errorf("Expected POST /graphql, got %s %s", r.Method, r.URL.Path)
+		}
+		var request struct {
+			Variables map[string]interface{} `json:"variables"`
+		}
+		json.NewDecoder(r.Body).Decode(&request)
+		cursors = append(cursors, request.Variables["cursor"])
+		if request.Variables["owner"] != "org" || request.Variables["name"] != "repo" || request.Variables["ref"] != "deadbeef" {
+			t.Errorf("Unexpected variables %v", request.Variables)
+		}
+
+		if request.Variables["cursor"] == nil {
+			fmt.Fprint(w, `{"data":{"rateLimit":{"cost":1,"remaining":4999},"repository":{"object":{"oid":"deadbeef00","statusCheckRollup":{"state":"FAILURE","contexts":{
+				"pageInfo":{"hasNextPage":true,"endCursor":"page2"},
+				"nodes":[{"__typename":"StatusContext","context":"ci","state":"SUCCESS","description":"unit test"}]}}}}}}`)
+			return
+		}
+		fmt.Fprint(w, `{"data":{"rateLimit":{"cost":1,"remaining":4998},"repository":{"object":{"oid":"deadbeef00","statusCheckRollup":{"state":"FAILURE","contexts":{
+			"pageInfo":{"hasNextPage":false,"endCursor":"page3"},
+			"nodes":[
+				{"__typename":"CheckRun","name":"build","status":"COMPLETED","conclusion":"FAILURE"},
+				{"__typename":"CheckRun","name":"lint","status":"IN_PROGRESS"},
+				{"__typename":"StatusContext","context":"deploy","state":"PENDING"}]}}}}}}`)
+	})()
+
+	flags := defaultFlags()
+	flags.GraphQL = true
+	status, err := getCombinedStatus(statusTarget{"org/repo", "deadbeef"}, *flags)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if len(cursors) != 2 || cursors[1] != "page2" {
+		t.Errorf("Expected the second page to be requested with the cursor, got %v", cursors)
+	}
+	if status.State != "failure" || status.SHA != "deadbeef00" {
+		t.Errorf("Unexpected combined status %+v", status)
+	}
+	if ci := status.find("ci"); ci == nil || ci.State != "success" || ci.Description != "unit test" {
+		t.Errorf("Expected the ci status, got %+v", ci)
+	}
+	if deploy := status.find("deploy"); deploy == nil || deploy.State != "pending" {
+		t.Errorf("Expected the deploy status from the second page, got %+v", deploy)
+	}
+	if len(status.CheckRuns) != 2 || status.CheckRuns[0].State != "failure" || status.CheckRuns[1].State != "pending" {
+		t.Errorf("Unexpected check runs %+v", status.CheckRuns)
+	}
+}
+
+func TestGraphqlQueryReportsErrorsIn200(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":null,"errors":[{"type":"NOT_FOUND","message":"Could not resolve to a Repository"}]}`)
+	})()
+
+	flags := defaultFlags()
+	flags.GraphQL = true
+	_, err := getCombinedStatus(statusTarget{"org/missing", "deadbeef"}, *flags)
+	if err == nil || !strings.Contains(err.Error(), "Could not resolve to a Repository") {
+		t.Errorf("Expected the GraphQL error to be reported, got %v", err)
+	}
+}
+
+func TestGetCombinedStatusGraphQLWithoutChecks(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, `{"data":{"rateLimit":{"cost":1,"remaining":4999},"repository":{"object":{"oid":"deadbeef","statusCheckRollup":null}}}}`)
+	})()
+
+	flags := defaultFlags()
+	flags.GraphQL = true
+	status, err := getCombinedStatus(statusTarget{"org/repo", "deadbeef"}, *flags)
+	if err != nil || status.find("ci") != nil {
+		t.Errorf("Expected an empty status, got %+v %v", status, err)
+	}
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+)
+
+// apiRoot checks that the API base URL answers a request.
+func (d *doctor) apiRoot() bool {
+	response, err := githubRequest("GET", githubAPIURL+"/", d.flags, nil)
+	if err != nil {
+		return d.add("api", false, err.Error(), "check -u, -proxy and that the machine can reach "+githubAPIURL)
+	}
+	if response.StatusCode >= 500 {
+		return d.add("api", false, fmt.Sprintf("%s answered HTTP %d", githubAPIURL, response.StatusCode), "Github may be having an outage; see https://www.githubstatus.com")
+	}
+	return d.add("api", true, fmt.Sprintf("%s answered HTTP %d", githubAPIURL, response.StatusCode), "")
+}
+
+// scopes checks that the token may post statuses, like a normal run does
+// before posting.
+func (d *doctor) scopes() bool {
+	if err := checkTokenScopes(d.flags); err != nil {
+		return d.add("scopes", false, err.Error(), "grant the token the repo:status scope")
+	}
+	return d.add("scopes", true, "the token can post statuses", "")
+}
+
+// healthCheck checks the connection to the API and the token, without
+// needing a repository or SHA.
+func (d *doctor) healthCheck() {
+	if !d.connectivity() || !d.apiRoot() {
+		return
+	}
+	if d.flags.Auth == "" {
+		d.add("auth", false, "no token; checked "+authSourcesChecked(d.flags), "")
+		return
+	}
+	if d.auth() {
+		d.scopes()
+	}
+}
+
+// runHealthCheck implements -healthcheck: it prints each check as an OK or
+// FAIL line, or with -log-format json as a JSON object per line, and returns
+// the exit code: non-zero if any check failed. Nothing is posted.
+func runHealthCheck(flags Flags, format string, out io.Writer) int {
+	d := &doctor{flags: flags}
+	d.healthCheck()
+
+	code := 0
+	for _, check := range d.checks {
+		if !check.OK {
+			code = 1
+		}
+		if format == "json" {
+			encoded, _ := json.Marshal(check)
+			fmt.Fprintf(out, "%s\n", encoded)
+			continue
+		}
+		result := "OK  "
+		if !check.OK {
+			result = "FAIL"
+		}
+		fmt.Fprintf(out, "%s  %s: %s\n", result, check.Name, check.Detail)
+		if check.Hint != "" {
+			fmt.Fprintf(out, "      hint: %s\n", check.Hint)
+		}
+	}
+	return code
+}
@@ -0,0 +1,80 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func healthCheckAPI(t *testing.T, scopes string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/":
+			fmt.Fprint(w, `{"current_user_url":"https://api.github.com/user"}`)
+		case "/user":
+			w.Header().Set("X-OAuth-Scopes", scopes)
+			fmt.Fprint(w, `{"login":"octocat"}`)
+		default:
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}
+}
+
+func TestRunHealthCheckPasses(t *testing.T) {
+	defer withGithubAPI(t, healthCheckAPI(t, "repo:status"))()
+
+	var out bytes.Buffer
+	if code := runHealthCheck(Flags{Auth: "token"}, "text", &out); code != 0 {
+		t.Fatalf("Expected every check to pass, got %d:\n%s", code, out.String())
+	}
+	for _, expected := range []string{
+		"OK    dns: ",
+		"OK    api: " + githubAPIURL + " answered HTTP 200",
+		"OK    auth: authenticated as octocat with scopes: repo:status",
+		"OK    scopes: the token can post statuses",
+	} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q in:\n%s", expected, out.String())
+		}
+	}
+}
+
+func TestRunHealthCheckMissingScope(t *testing.T) {
+	defer withGithubAPI(t, healthCheckAPI(t, "read:org"))()
+
+	var out bytes.Buffer
+	if code := runHealthCheck(Flags{Auth: "token"}, "json", &out); code != 1 {
+		t.Fatalf("Expected the scope check to fail, got %d:\n%s", code, out.String())
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	var last doctorCheck
+	if err := json.Unmarshal([]byte(lines[len(lines)-1]), &last); err != nil {
+		t.Fatalf("Expected a JSON object per check, got:\n%s", out.String())
+	}
+	if last.Name != "scopes" || last.OK || !strings.Contains(last.Detail, "(it has: read:org)") {
+		t.Errorf("Expected a failed scopes check, got %+v", last)
+	}
+}
+
+func TestRunHealthCheckWithoutToken(t *testing.T) {
+	defer withGithubAPI(t, healthCheckAPI(t, ""))()
+
+	var out bytes.Buffer
+	if code := runHealthCheck(Flags{}, "text", &out); code != 1 || !strings.Contains(out.String(), "FAIL  auth: no token; checked -a") {
+		t.Errorf("Expected the missing token to fail the check, got %d:\n%s", code, out.String())
+	}
+}
+
+func TestValidateFlagsHealthCheck(t *testing.T) {
+	flags := Flags{HealthCheck: true}
+	if err := validateFlags(flags, nil, ""); err != nil {
+		t.Errorf("Expected -healthcheck to need no command or repository, got %s", err)
+	}
+	if err := validateFlags(flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), "-healthcheck runs no command") {
+		t.Errorf("Expected -healthcheck with a command to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,428 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// newHTTPClient returns the client used for requests to the GitHub API.
+// -connect-timeout bounds dialing and -response-header-timeout the wait for
+// a response once the request is sent, while -http-timeout bounds the whole
+// request including retries and reading the body.
+func newHTTPClient(flags Flags) (*http.Client, error) {
+	transport, err := baseTransport(flags)
+	if err != nil {
+		return nil, err
+	}
+
+	var next http.RoundTripper = transport
+	if flags.Transport == transportGH {
+		gh, err := ghPath()
+		if err != nil {
+			return nil, err
+		}
+		next = &ghTransport{gh: gh}
+	}
+	fixtures, err := fixtureTransport(flags, next)
+	if err != nil {
+		return nil, err
+	}
+	if fixtures != nil {
+		next = fixtures
+	}
+	if tracer != nil {
+		next = &tracingTransport{next: next}
+	}
+	if flags.MaxAPICalls > 0 {
+		next = &budgetTransport{next: next, max: flags.MaxAPICalls}
+	}
+	if flags.DryRun {
+		next = &dryRunTransport{next: next}
+	}
+	if logger.enabled(logDebug) {
+		next = &loggingTransport{next: next}
+	}
+
+	if flags.Retries > 0 || flags.HTTPAttemptTimeout > 0 {
+		statuses, err := parseRetryStatuses(flags.RetryOnStatus)
+		if err != nil {
+			return nil, err
+		}
+		next = &retryTransport{next: next, retries: flags.Retries, statuses: statuses, shared: flags.SharedRetries, cap: flags.BackoffCap, jitter: flags.Jitter, attemptTimeout: flags.HTTPAttemptTimeout, networkErrors: !flags.NoRetryOnNetworkErrors}
+	}
+	return &http.Client{Transport: next, Timeout: flags.HTTPTimeout}, nil
+}
+
+// baseTransport is the transport every outgoing connection uses, with the
+// proxy and network timeout flags applied.
+func baseTransport(flags Flags) (*http.Transport, error) {
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	dialer := newDialer(flags)
+	transport.DialContext = func(ctx context.Context, network, address string) (net.Conn, error) {
+		return dialer.DialContext(ctx, dialNetwork(flags, network), address)
+	}
+	transport.ResponseHeaderTimeout = flags.ResponseHeaderTimeout
+
+	proxy, err := proxyURL(flags)
+	if err != nil {
+		return nil, err
+	}
+	if proxy != nil {
+		transport.Proxy = http.ProxyURL(proxy)
+		if proxy.User != nil {
+			password, _ := proxy.User.Password()
+			credentials := base64.StdEncoding.EncodeToString([]byte(proxy.User.Username() + ":" + password))
+			transport.ProxyConnectHeader = http.Header{"Proxy-Authorization": {"Basic " + credentials}}
+		}
+	}
+	return transport, nil
+}
+
+// newDialer returns the dialer for -connect-timeout, -dial-dual-stack and
+// -fallback-delay. Its defaults are those of http.DefaultTransport.
+func newDialer(flags Flags) *net.Dialer {
+	dialer := &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}
+	if flags.ConnectTimeout > 0 {
+		dialer.Timeout = flags.ConnectTimeout
+	}
+	if flags.NoDualStack {
+		// A negative delay turns off racing IPv4 against IPv6.
+		dialer.FallbackDelay = -1
+	} else if flags.FallbackDelay > 0 {
+		dialer.FallbackDelay = flags.FallbackDelay
+	}
+	return dialer
+}
+
+// dialNetwork returns the network to dial for network, only IPv4 with
+// -prefer-ipv4.
+func dialNetwork(flags Flags, network string) string {
+	if flags.PreferIPv4 && network == "tcp" {
+		return "tcp4"
+	}
+	return network
+}
+
+// loggingTransport logs each request attempt at debug level and the rate
+// limit left after it at trace level.
+type loggingTransport struct {
+	next http.RoundTripper
+}
+
+func (l *loggingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	url := redactURL(req.URL.String())
+	logger.Debugf("%s %s", req.Method, url)
+	started := time.Now()
+	resp, err := l.next.RoundTrip(req)
+	if err != nil {
+		logger.Debugf("%s %s failed after %s: %s", req.Method, url, time.Since(started).Round(time.Millisecond), err)
+		return resp, err
+	}
+	logger.Debugf("%s %s responded with %d in %s", req.Method, url, resp.StatusCode, time.Since(started).Round(time.Millisecond))
+	if remaining := resp.Header.Get("X-RateLimit-Remaining"); remaining != "" {
+		logger.Tracef("Rate limit: %s of %s left, resets at %s", remaining, resp.Header.Get("X-RateLimit-Limit"), resp.Header.Get("X-RateLimit-Reset"))
+	}
+	return resp, err
+}
+
+// retryBackoff is the wait before the first retry. It doubles for each
+// following one, up to -backoff-cap.
+var retryBackoff = time.Second
+
+// defaultBackoffCap is the default of -backoff-cap.
+const defaultBackoffCap = 30 * time.Second
+
+// retrySleep waits between retries.
+var retrySleep = time.Sleep
+
+// jitterMode is the value of the -jitter flag: how retry waits are
+// randomized so many clients retrying at once don't all hit GitHub together.
+type jitterMode string
+
+const (
+	// jitterFull waits a random time between 0 and the backoff.
+	jitterFull jitterMode = ""
+	// jitterEqual waits half the backoff plus a random time up to the other
+	// half, so it never retries too soon.
+	jitterEqual jitterMode = "equal"
+	// jitterNone waits exactly the backoff.
+	jitterNone jitterMode = "none"
+)
+
+func (m *jitterMode) String() string {
+	return string(*m)
+}
+
+func (m *jitterMode) Set(value string) error {
+	switch value {
+	case "full":
+		*m = jitterFull
+	case "equal":
+		*m = jitterEqual
+	case "none":
+		*m = jitterNone
+	default:
+		return fmt.Errorf("expected full, equal or none, got %q", value)
+	}
+	return nil
+}
+
+func (m *jitterMode) completionValues() []string {
+	return []string{"full", "equal", "none"}
+}
+
+// jittered returns the wait for backoff with mode's jitter.
+func jittered(backoff time.Duration, mode jitterMode) time.Duration {
+	if backoff <= 0 {
+		return backoff
+	}
+	switch mode {
+	case jitterNone:
+		return backoff
+	case jitterEqual:
+		half := backoff / 2
+		return half + time.Duration(rand.Int63n(int64(backoff-half)+1))
+	}
+	return time.Duration(rand.Int63n(int64(backoff) + 1))
+}
+
+// parseRetryStatuses parses -retry-on-status. An empty list retries every
+// 5xx response.
+func parseRetryStatuses(list string) (func(int) bool, error) {
+	if strings.TrimSpace(list) == "" {
+		return func(status int) bool { return status >= 500 && status <= 599 }, nil
+	}
+	codes := map[int]bool{}
+	for _, item := range splitList(list) {
+		code, err := strconv.Atoi(item)
+		if err != nil || code < 100 || code > 599 {
+			return nil, fmt.Errorf("Error: invalid -retry-on-status code %q", item)
+		}
+		if code >= 200 && code <= 299 {
+			return nil, fmt.Errorf("Error: -retry-on-status can't retry successful responses, got %d", code)
+		}
+		codes[code] = true
+	}
+	return func(status int) bool { return codes[status] }, nil
+}
+
+// sharedRetries counts the retries this process has made, for
+// -retry-budget-shared-across-contexts.
+var sharedRetries = struct {
+	sync.Mutex
+	used      int
+	exhausted bool
+}{}
+
+// takeSharedRetry uses up one of the run's budget of retries, or reports
+// false if there are none left.
+func takeSharedRetry(budget int) bool {
+	sharedRetries.Lock()
+	defer sharedRetries.Unlock()
+	if sharedRetries.used >= budget {
+		if !sharedRetries.exhausted {
+			logger.Warnf("used up the shared budget of %d retries, not retrying any more requests", budget)
+		}
+		sharedRetries.exhausted = true
+		return false
+	}
+	sharedRetries.used++
+	return true
+}
+
+// retryTransport retries requests whose response status is retryable, up to
+// retries times with exponential backoff. Successful responses are never
+// retried. With shared, retries is the budget of every request together.
+// No wait is longer than cap, unless it is 0, and each is randomized by
+// jitter. With attemptTimeout each attempt, including reading its response,
+// gets that long, and an attempt that runs out of time is retried too. With
+// networkErrors, requests failing with one of the networkErrorKind errors
+// are retried as well.
+type retryTransport struct {
+	next           http.RoundTripper
+	retries        int
+	statuses       func(int) bool
+	shared         bool
+	cap            time.Duration
+	jitter         jitterMode
+	attemptTimeout time.Duration
+	networkErrors  bool
+}
+
+func (r *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	backoff := r.capped(retryBackoff)
+	for attempt := 0; ; attempt++ {
+		resp, err := r.roundTrip(req)
+		var reason string
+		switch {
+		case err != nil && r.attemptTimeout > 0 && req.Context().Err() == nil && isTimeout(err):
+			reason = "timed out after " + r.attemptTimeout.String()
+		case err != nil && r.networkErrors && req.Context().Err() == nil && networkErrorKind(err) != "":
+			reason = fmt.Sprintf("failed with a network error (%s): %s", networkErrorKind(err), err)
+		case err != nil:
+			return resp, err
+		case r.statuses(resp.StatusCode):
+			reason = fmt.Sprintf("responded with %d", resp.StatusCode)
+		}
+		if reason == "" || attempt >= r.retries {
+			return resp, err
+		}
+		if req.Body != nil && req.GetBody == nil {
+			return resp, err
+		}
+		if r.shared && !takeSharedRetry(r.retries) {
+			return resp, err
+		}
+
+		wait := jittered(backoff, r.jitter)
+		logger.Warnf("%s %s %s, retrying in %s", req.Method, redactURL(req.URL.String()), reason, wait.Round(time.Millisecond))
+		if resp != nil {
+			io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		waitStarted := time.Now()
+		retrySleep(wait)
+		timeRetryWait(time.Since(waitStarted))
+		backoff = r.capped(backoff * 2)
+
+		if req.GetBody != nil {
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			req = req.Clone(req.Context())
+			req.Body = body
+		}
+	}
+}
+
+// roundTrip sends one attempt of req, bounded by attemptTimeout.
+func (r *retryTransport) roundTrip(req *http.Request) (*http.Response, error) {
+	if r.attemptTimeout <= 0 {
+		return r.next.RoundTrip(req)
+	}
+	ctx, cancel := context.WithTimeout(req.Context(), r.attemptTimeout)
+	resp, err := r.next.RoundTrip(req.WithContext(ctx))
+	if err != nil {
+		cancel()
+		return nil, err
+	}
+	resp.Body = &cancelOnClose{ReadCloser: resp.Body, cancel: cancel}
+	return resp, nil
+}
+
+// cancelOnClose releases an attempt's context once its body is closed.
+type cancelOnClose struct {
+	io.ReadCloser
+	cancel context.CancelFunc
+}
+
+func (c *cancelOnClose) Close() error {
+	err := c.ReadCloser.Close()
+	c.cancel()
+	return err
+}
+
+// isTimeout reports whether err is a request running out of time.
+func isTimeout(err error) bool {
+	if errors.Is(err, context.DeadlineExceeded) {
+		return true
+	}
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
+
+// networkErrorKind classifies err as a transient network error worth
+// retrying: a connection reset, the connection closing mid response, or a
+// timeout. It returns "" for any other error, such as a refused connection or
+// a TLS failure, which retrying wouldn't fix.
+func networkErrorKind(err error) string {
+	switch {
+	case isConnectionReset(err):
+		return "connection reset"
+	case errors.Is(err, io.EOF), errors.Is(err, io.ErrUnexpectedEOF):
+		return "EOF"
+	case isTimeout(err):
+		return "timeout"
+	}
+	return ""
+}
+
+// capped bounds backoff by the transport's cap.
+func (r *retryTransport) capped(backoff time.Duration) time.Duration {
+	if r.cap > 0 && backoff > r.cap {
+		return r.cap
+	}
+	return backoff
+}
+
+// proxyURL returns the proxy given by -proxy with any -proxy-auth credentials
+// applied, or nil to use the proxy environment variables. Credentials
+// embedded in HTTPS_PROXY are honored by the default transport.
+func proxyURL(flags Flags) (*url.URL, error) {
+	if flags.Proxy == "" {
+		if flags.ProxyAuth != "" {
+			return nil, fmt.Errorf("Error: -proxy-auth requires -proxy")
+		}
+		return nil, nil
+	}
+
+	proxy, err := url.Parse(flags.Proxy)
+	if err != nil || proxy.Host == "" {
+		return nil, fmt.Errorf("Error: invalid -proxy %q", redactURL(flags.Proxy))
+	}
+
+	if flags.ProxyAuth != "" {
+		parts := strings.SplitN(flags.ProxyAuth, ":", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("Error: -proxy-auth must be in the form username:password")
+		}
+		proxy.User = url.UserPassword(parts[0], parts[1])
+	}
+	return proxy, nil
+}
+
+// redactURL hides the password in a URL so it can be logged.
+func redactURL(rawURL string) string {
+	parsed, err := url.Parse(rawURL)
+	if err != nil {
+		if i := strings.LastIndex(rawURL, "@"); i >= 0 {
+			return "xxxxx" + rawURL[i:]
+		}
+		return rawURL
+	}
+	return parsed.Redacted()
+}
+
+// gzipThreshold is the smallest request body -gzip-request compresses;
+// smaller bodies, like commit statuses, aren't worth it.
+const gzipThreshold = 1024
+
+// compressBody gzips body when -gzip-request is set and body is large
+// enough. It returns the body to send and its Content-Encoding, if any.
+func compressBody(flags Flags, body []byte) ([]byte, string) {
+	if !flags.GzipRequest || len(body) < gzipThreshold {
+		return body, ""
+	}
+	var compressed bytes.Buffer
+	writer := gzip.NewWriter(&compressed)
+	writer.Write(body)
+	if err := writer.Close(); err != nil {
+		return body, ""
+	}
+	return compressed.Bytes(), "gzip"
+}
@@ -0,0 +1,189 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"net/url"
+	"sort"
+	"text/template"
+	"time"
+)
+
+const defaultIssueLabel = "ci-failure"
+
+const defaultIssueTemplate = `{{.Context}} is failing on {{.Repository}}.
+
+First failure: {{.SHA}} ({{.State}}) at {{.Time}}.
+{{if .TargetUrl}}
+Details: {{.TargetUrl}}
+{{end}}
+This issue is maintained by gh-status-reporter. Further failures are added
+as comments{{if .CloseOnSuccess}} and it is closed when {{.Context}} passes again{{end}}.
+`
+
+// issue is the subset of a GitHub issue used for failure tracking.
+type issue struct {
+	Number  int    `json:"number"`
+	Title   string `json:"title"`
+	HTMLURL string `json:"html_url"`
+}
+
+// issueTemplateData is available to -issue-template.
+type issueTemplateData struct {
+	Context        string
+	Repository     string
+	SHA            string
+	State          string
+	TargetUrl      string
+	Time           string
+	CloseOnSuccess bool
+}
+
+func trackingIssueTitle(context string) string {
+	return "CI failure: " + context
+}
+
+func issueLabel(flags Flags) string {
+	if flags.IssueLabel != "" {
+		return flags.IssueLabel
+	}
+	return defaultIssueLabel
+}
+
+// trackFailure opens or comments on the tracking issue for a failed context,
+// and closes it on success when -close-on-success is set. Problems are
+// printed as warnings and never fail the run.
+func trackFailure(target statusTarget, flags Flags, state string) {
+	var err error
+	switch {
+	case state == "failure" || state == "error":
+		err = recordFailure(target, flags, state)
+	case state == "success" && flags.CloseOnSuccess:
+		err = recordRecovery(target, flags)
+	}
+	if err != nil {
+		logger.Warnf("could not update the tracking issue for %s: %s", flags.Context, err)
+	}
+}
+
+// findTrackingIssues returns the open issues with the marker label and the
+// context's title, oldest first.
+func findTrackingIssues(target statusTarget, flags Flags) ([]issue, error) {
+	query := url.Values{"state": {"open"}, "labels": {issueLabel(flags)}, "per_page": {"100"}}
+	var issues []issue
+	if err := getGithubJSON(githubAPIURL+"/repos/"+target.OrgRepo+"/issues?"+query.Encode(), flags, &issues); err != nil {
+		return nil, err
+	}
+
+	title := trackingIssueTitle(flags.Context)
+	var matching []issue
+	for _, candidate := range issues {
+		if candidate.Title == title {
+			matching = append(matching, candidate)
+		}
+	}
+	sort.Slice(matching, func(i, j int) bool { return matching[i].Number < matching[j].Number })
+	return matching, nil
+}
+
+func recordFailure(target statusTarget, flags Flags, state string) error {
+	existing, err := findTrackingIssues(target, flags)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 {
+		return commentOnIssue(target, flags, existing[0].Number, occurrenceComment(target, flags, state))
+	}
+
+	body, err := renderIssueBody(target, flags, state)
+	if err != nil {
+		return err
+	}
+	var created issue
+	if err := githubJSON("POST", githubAPIURL+"/repos/"+target.OrgRepo+"/issues", flags, map[string]interface{}{
+		"title":  trackingIssueTitle(flags.Context),
+		"body":   body,
+		"labels": []string{issueLabel(flags)},
+	}, &created); err != nil {
+		return err
+	}
+
+	// A concurrent run may have created the same issue. Keep the oldest and
+	// fold ours into it.
+	existing, err = findTrackingIssues(target, flags)
+	if err != nil {
+		return err
+	}
+	if len(existing) > 0 && existing[0].Number != created.Number {
+		if err := closeIssue(target, flags, created.Number, fmt.Sprintf("Duplicate of #%d.", existing[0].Number)); err != nil {
+			return err
+		}
+		return commentOnIssue(target, flags, existing[0].Number, occurrenceComment(target, flags, state))
+	}
+	logger.Infof("Opened tracking issue %s", created.HTMLURL)
+	return nil
+}
+
+func recordRecovery(target statusTarget, flags Flags) error {
+	existing, err := findTrackingIssues(target, flags)
+	if err != nil {
+		return err
+	}
+	for _, open := range existing {
+		message := fmt.Sprintf("Recovered: %s passed on %s at %s.", flags.Context, target.SHA, now().Format(time.RFC3339))
+		if err := closeIssue(target, flags, open.Number, message); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func occurrenceComment(target statusTarget, flags Flags, state string) string {
+	comment := fmt.Sprintf("Failed again (%s) on %s at %s.", state, target.SHA, now().Format(time.RFC3339))
+	if targetURL := statusTargetURL(flags, state); targetURL != "" {
+		comment += "\n\nDetails: " + targetURL
+	}
+	return comment
+}
+
+func renderIssueBody(target statusTarget, flags Flags, state string) (string, error) {
+	text := defaultIssueTemplate
+	if flags.IssueTemplate != "" {
+		contents, err := ioutil.ReadFile(flags.IssueTemplate)
+		if err != nil {
+			return "", fmt.Errorf("Error reading issue template: %s", err)
+		}
+		text = string(contents)
+	}
+
+	tmpl, err := template.New("issue").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing issue template: %s", err)
+	}
+	var body bytes.Buffer
+	err = tmpl.Execute(&body, issueTemplateData{
+		Context:        flags.Context,
+		Repository:     target.OrgRepo,
+		SHA:            target.SHA,
+		State:          state,
+		TargetUrl:      statusTargetURL(flags, state),
+		Time:           now().Format(time.RFC3339),
+		CloseOnSuccess: flags.CloseOnSuccess,
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error rendering issue template: %s", err)
+	}
+	return body.String(), nil
+}
+
+func commentOnIssue(target statusTarget, flags Flags, number int, comment string) error {
+	return githubJSON("POST", issueURL(target, number)+"/comments", flags, map[string]string{"body": comment}, nil)
+}
+
+func closeIssue(target statusTarget, flags Flags, number int, comment string) error {
+	if err := commentOnIssue(target, flags, number, comment); err != nil {
+		return err
+	}
+	return githubJSON("PATCH", issueURL(target, number), flags, map[string]string{"state": "closed"}, nil)
+}
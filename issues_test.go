@@ -0,0 +1,144 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+)
+
+type issueServer struct {
+	mu       sync.Mutex
+	issues   []issue
+	requests []string
+	bodies   []string
+	// racer is an issue another run creates right after ours.
+	racer *issue
+}
+
+func (s *issueServer) handle(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.requests = append(s.requests, r.Method+" "+r.URL.Path)
+	body, _ := ioutil.ReadAll(r.Body)
+	if len(body) > 0 {
+		s.bodies = append(s.bodies, string(body))
+	}
+
+	switch {
+	case r.Method == "GET" && r.URL.Path == "/repos/org/repo/issues":
+		if r.URL.Query().Get("labels") != "ci-failure" || r.URL.Query().Get("state") != "open" {
+			t := r.URL.Query()
+			http.Error(w, fmt.Sprintf("unexpected query %v", t), http.StatusBadRequest)
+			return
+		}
+		json.NewEncoder(w).Encode(s.issues)
+	case r.Method == "POST" && r.URL.Path == "/repos/org/repo/issues":
+		var created issue
+		json.Unmarshal(body, &created)
+		created.Number = 100 + len(s.issues)
+		s.issues = append(s.issues, created)
+		if s.racer != nil {
+			s.issues = append(s.issues, *s.racer)
+		}
+		w.WriteHeader(http.StatusCreated)
+		json.NewEncoder(w).Encode(created)
+	case r.Method == "PATCH":
+		w.Write([]byte(`{}`))
+	case r.Method == "POST" && strings.HasSuffix(r.URL.Path, "/comments"):
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte(`{}`))
+	default:
+		w.WriteHeader(http.StatusTeapot)
+	}
+}
+
+func TestTrackFailureOpensIssue(t *testing.T) {
+	server := &issueServer{}
+	defer withGithubAPI(t, server.handle)()
+
+	trackFailure(statusTarget{"org/repo", "deadbeef"}, *defaultFlags(), "failure")
+
+	expected := []string{"GET /repos/org/repo/issues", "POST /repos/org/repo/issues", "GET /repos/org/repo/issues"}
+	if !reflect.DeepEqual(server.requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, server.requests)
+	}
+	if server.issues[0].Title != "CI failure: ci" {
+		t.Errorf("Unexpected issue title %q", server.issues[0].Title)
+	}
+	if !strings.Contains(server.bodies[0], "deadbeef") || !strings.Contains(server.bodies[0], `"labels":["ci-failure"]`) {
+		t.Errorf("Unexpected issue body %s", server.bodies[0])
+	}
+}
+
+func TestTrackFailureCommentsOnExistingIssue(t *testing.T) {
+	server := &issueServer{issues: []issue{{Number: 5, Title: "CI failure: other"}, {Number: 7, Title: "CI failure: ci"}}}
+	defer withGithubAPI(t, server.handle)()
+
+	trackFailure(statusTarget{"org/repo", "deadbeef"}, *defaultFlags(), "error")
+
+	expected := []string{"GET /repos/org/repo/issues", "POST /repos/org/repo/issues/7/comments"}
+	if !reflect.DeepEqual(server.requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, server.requests)
+	}
+	if !strings.Contains(server.bodies[0], "Failed again (error) on deadbeef") {
+		t.Errorf("Unexpected comment %s", server.bodies[0])
+	}
+}
+
+func TestTrackFailureDeduplicatesConcurrentCreate(t *testing.T) {
+	server := &issueServer{racer: &issue{Number: 42, Title: "CI failure: ci"}}
+	defer withGithubAPI(t, server.handle)()
+
+	trackFailure(statusTarget{"org/repo", "deadbeef"}, *defaultFlags(), "failure")
+
+	expected := []string{
+		"GET /repos/org/repo/issues",
+		"POST /repos/org/repo/issues",
+		"GET /repos/org/repo/issues",
+		"POST /repos/org/repo/issues/100/comments",
+		"PATCH /repos/org/repo/issues/100",
+		"POST /repos/org/repo/issues/42/comments",
+	}
+	if !reflect.DeepEqual(server.requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, server.requests)
+	}
+}
+
+func TestTrackFailureClosesOnSuccess(t *testing.T) {
+	server := &issueServer{issues: []issue{{Number: 7, Title: "CI failure: ci"}}}
+	defer withGithubAPI(t, server.handle)()
+
+	flags := defaultFlags()
+	trackFailure(statusTarget{"org/repo", "deadbeef"}, *flags, "success")
+	if len(server.requests) != 0 {
+		t.Errorf("Expected no requests without -close-on-success, got %q", server.requests)
+	}
+
+	flags.CloseOnSuccess = true
+	trackFailure(statusTarget{"org/repo", "deadbeef"}, *flags, "success")
+	expected := []string{"GET /repos/org/repo/issues", "POST /repos/org/repo/issues/7/comments", "PATCH /repos/org/repo/issues/7"}
+	if !reflect.DeepEqual(server.requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, server.requests)
+	}
+	if !strings.Contains(server.bodies[1], `"state":"closed"`) {
+		t.Errorf("Expected the issue to be closed, got %s", server.bodies[1])
+	}
+}
+
+func TestRenderIssueBodyTemplate(t *testing.T) {
+	flags := defaultFlags()
+	flags.IssueTemplate = writeTempFile(t, "issue.tmpl", "{{.Context}} broke at {{.SHA}} in {{.Repository}}")
+
+	body, err := renderIssueBody(statusTarget{"org/repo", "cafe"}, *flags, "failure")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if body != "ci broke at cafe in org/repo" {
+		t.Errorf("Unexpected body %q", body)
+	}
+}
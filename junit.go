@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/xml"
+	"fmt"
+	"io/ioutil"
+)
+
+type junitTestSuites struct {
+	XMLName xml.Name         `xml:"testsuites"`
+	Suites  []junitTestSuite `xml:"testsuite"`
+}
+
+type junitTestSuite struct {
+	Name     string          `xml:"name,attr"`
+	Tests    int             `xml:"tests,attr"`
+	Failures int             `xml:"failures,attr"`
+	Time     string          `xml:"time,attr"`
+	Cases    []junitTestCase `xml:"testcase"`
+}
+
+type junitTestCase struct {
+	Name      string        `xml:"name,attr"`
+	ClassName string        `xml:"classname,attr"`
+	Time      string        `xml:"time,attr"`
+	Failure   *junitFailure `xml:"failure,omitempty"`
+	SystemOut string        `xml:"system-out,omitempty"`
+}
+
+type junitFailure struct {
+	Message string `xml:"message,attr"`
+}
+
+// renderJUnitReport summarizes the wrapped command as a single JUnit testcase
+// named after the status context.
+func renderJUnitReport(flags Flags, result *commandResult) ([]byte, error) {
+	seconds := fmt.Sprintf("%.3f", result.Duration.Seconds())
+
+	testCase := junitTestCase{
+		Name:      flags.Context,
+		ClassName: flags.OrgRepo,
+		Time:      seconds,
+		SystemOut: result.Output.String(),
+	}
+	failures := 0
+	if result.Err != nil {
+		testCase.Failure = &junitFailure{Message: fmt.Sprintf("command exited with code %d: %s", result.ExitCode, result.Err)}
+		failures = 1
+	}
+
+	report := junitTestSuites{
+		Suites: []junitTestSuite{{
+			Name:     "gh-status-reporter",
+			Tests:    1,
+			Failures: failures,
+			Time:     seconds,
+			Cases:    []junitTestCase{testCase},
+		}},
+	}
+
+	body, err := xml.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append([]byte(xml.Header), append(body, '\n')...), nil
+}
+
+func writeJUnitReport(path string, flags Flags, result *commandResult) error {
+	body, err := renderJUnitReport(flags, result)
+	if err != nil {
+		return fmt.Errorf("Error rendering JUnit report: %s", err)
+	}
+	if err := ioutil.WriteFile(path, body, 0644); err != nil {
+		return fmt.Errorf("Error writing JUnit report %s: %s", path, err)
+	}
+	return nil
+}
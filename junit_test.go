@@ -0,0 +1,62 @@
+package main
+
+import (
+	"bytes"
+	"encoding/xml"
+	"errors"
+	"io"
+	"strings"
+	"testing"
+	"time"
+)
+
+func checkWellFormed(t *testing.T, body []byte) {
+	decoder := xml.NewDecoder(bytes.NewReader(body))
+	for {
+		_, err := decoder.Token()
+		if err == io.EOF {
+			return
+		}
+		if err != nil {
+			t.Fatalf("Expected well-formed XML, got %s\n%s", err, body)
+		}
+	}
+}
+
+func TestRenderJUnitReportSuccess(t *testing.T) {
+	result := &commandResult{Duration: 1500 * time.Millisecond, Output: newTailBuffer(64)}
+	result.Output.Write([]byte("all <good> & done\n"))
+
+	body, err := renderJUnitReport(*defaultFlags(), result)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	checkWellFormed(t, body)
+
+	var report junitTestSuites
+	if err := xml.Unmarshal(body, &report); err != nil {
+		t.Fatal(err)
+	}
+	testCase := report.Suites[0].Cases[0]
+	if testCase.Name != "ci" || testCase.Time != "1.500" || testCase.Failure != nil {
+		t.Errorf("Unexpected testcase %+v", testCase)
+	}
+	if testCase.SystemOut != "all <good> & done\n" {
+		t.Errorf("Expected system-out to round trip, got %q", testCase.SystemOut)
+	}
+}
+
+func TestRenderJUnitReportFailure(t *testing.T) {
+	result := &commandResult{Err: errors.New("exit status 2"), ExitCode: 2, Output: newTailBuffer(64)}
+	result.Output.Write([]byte("bad byte \x01\n"))
+
+	body, err := renderJUnitReport(*defaultFlags(), result)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	checkWellFormed(t, body)
+
+	if !strings.Contains(string(body), `failures="1"`) || !strings.Contains(string(body), "<failure") {
+		t.Errorf("Expected a failure element, got\n%s", body)
+	}
+}
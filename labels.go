@@ -0,0 +1,148 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"strconv"
+)
+
+const defaultLabelColor = "ededed"
+
+// labelChange records a label added to or removed from a pull request.
+type labelChange struct {
+	PullRequest int    `json:"pull_request"`
+	Label       string `json:"label"`
+	Action      string `json:"action"`
+}
+
+// errNoLabelAccess means the token may not change labels, typically because
+// the pull request comes from a fork.
+var errNoLabelAccess = fmt.Errorf("token lacks permission to change labels")
+
+// labelPlan returns the labels to add and remove for the final state.
+func labelPlan(flags Flags, state string) (add []string, remove []string) {
+	if state == "success" {
+		return splitList(flags.LabelOnSuccess), splitList(flags.UnlabelOnSuccess)
+	}
+	return splitList(flags.LabelOnFailure), nil
+}
+
+// updateLabels applies the label flags for state to every pull request.
+// Problems are printed as warnings and never fail the run.
+func updateLabels(target statusTarget, pulls []pullRequest, flags Flags, state string) []labelChange {
+	add, remove := labelPlan(flags, state)
+	if len(add) == 0 && len(remove) == 0 {
+		return nil
+	}
+
+	if pulls == nil {
+		var err error
+		pulls, err = openPullRequests(target, flags)
+		if err != nil {
+			logger.Warnf("could not find pull requests to label: %s", err)
+			return nil
+		}
+	}
+
+	if flags.CreateLabels {
+		for _, label := range add {
+			if err := ensureLabel(target, flags, label); err != nil {
+				logger.Warnf("could not create label %q: %s", label, err)
+			}
+		}
+	}
+
+	var changes []labelChange
+	for _, pull := range pulls {
+		if len(add) > 0 {
+			err := addLabels(target, flags, pull.Number, add)
+			if err == errNoLabelAccess {
+				logger.Warnf("not updating labels on #%d: %s (pull requests from forks can't be labeled with their token)", pull.Number, err)
+				return changes
+			}
+			if err != nil {
+				logger.Warnf("could not add labels to #%d: %s", pull.Number, err)
+			} else {
+				for _, label := range add {
+					changes = append(changes, labelChange{pull.Number, label, "added"})
+				}
+			}
+		}
+		for _, label := range remove {
+			removed, err := removeLabel(target, flags, pull.Number, label)
+			if err == errNoLabelAccess {
+				logger.Warnf("not updating labels on #%d: %s (pull requests from forks can't be labeled with their token)", pull.Number, err)
+				return changes
+			}
+			if err != nil {
+				logger.Warnf("could not remove label %q from #%d: %s", label, pull.Number, err)
+			} else if removed {
+				changes = append(changes, labelChange{pull.Number, label, "removed"})
+			}
+		}
+	}
+	return changes
+}
+
+func issueURL(target statusTarget, number int) string {
+	return githubAPIURL + "/repos/" + target.OrgRepo + "/issues/" + strconv.Itoa(number)
+}
+
+// addLabels adds labels to an issue or pull request. Adding a label that is
+// already present is not an error.
+func addLabels(target statusTarget, flags Flags, number int, labels []string) error {
+	response, err := githubRequest("POST", issueURL(target, number)+"/labels", flags, map[string][]string{"labels": labels})
+	if err != nil {
+		return err
+	}
+	return labelResponseError(response)
+}
+
+// removeLabel removes a label, reporting whether it was present.
+func removeLabel(target statusTarget, flags Flags, number int, label string) (bool, error) {
+	response, err := githubRequest("DELETE", issueURL(target, number)+"/labels/"+url.PathEscape(label), flags, nil)
+	if err != nil {
+		return false, err
+	}
+	if response.StatusCode == http.StatusNotFound {
+		return false, nil
+	}
+	return true, labelResponseError(response)
+}
+
+// ensureLabel creates label in the repository if it doesn't exist yet.
+func ensureLabel(target statusTarget, flags Flags, label string) error {
+	labelsURL := githubAPIURL + "/repos/" + target.OrgRepo + "/labels"
+	response, err := githubRequest("GET", labelsURL+"/"+url.PathEscape(label), flags, nil)
+	if err != nil {
+		return err
+	}
+	if response.StatusCode == http.StatusOK {
+		return nil
+	}
+	if response.StatusCode != http.StatusNotFound {
+		return labelResponseError(response)
+	}
+
+	response, err = githubRequest("POST", labelsURL, flags, map[string]string{"name": label, "color": defaultLabelColor})
+	if err != nil {
+		return err
+	}
+	// 422 means another run created the label in the meantime.
+	if response.StatusCode == http.StatusUnprocessableEntity {
+		return nil
+	}
+	return labelResponseError(response)
+}
+
+func labelResponseError(response *apiResponse) error {
+	switch {
+	case response.ok():
+		return nil
+	case response.StatusCode == http.StatusForbidden:
+		return errNoLabelAccess
+	default:
+		return response.error(fmt.Sprintf("Github responded with %d", response.StatusCode))
+	}
+}
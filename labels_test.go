@@ -0,0 +1,118 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type labelServer struct {
+	mu       sync.Mutex
+	requests []string
+	labels   map[string]bool
+	forbid   bool
+}
+
+func (l *labelServer) handle(w http.ResponseWriter, r *http.Request) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.requests = append(l.requests, r.Method+" "+r.URL.Path)
+
+	switch {
+	case r.URL.Path == "/repos/org/repo/commits/deadbeef/pulls":
+		w.Write([]byte(`[{"number": 7, "state": "open"}]`))
+	case l.forbid && r.Method != "GET":
+		w.WriteHeader(http.StatusForbidden)
+	case r.Method == "GET" && r.URL.Path == "/repos/org/repo/labels/ci-failing":
+		if !l.labels["ci-failing"] {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	case r.Method == "POST" && r.URL.Path == "/repos/org/repo/labels":
+		body, _ := ioutil.ReadAll(r.Body)
+		var label map[string]string
+		json.Unmarshal(body, &label)
+		l.labels[label["name"]] = true
+		w.WriteHeader(http.StatusCreated)
+	case r.Method == "POST" && r.URL.Path == "/repos/org/repo/issues/7/labels":
+		w.Write([]byte(`[]`))
+	case r.Method == "DELETE" && r.URL.Path == "/repos/org/repo/issues/7/labels/ci-failing":
+		w.Write([]byte(`[]`))
+	case r.Method == "DELETE":
+		w.WriteHeader(http.StatusNotFound)
+	default:
+		w.WriteHeader(http.StatusTeapot)
+	}
+}
+
+func TestUpdateLabelsOnFailureCreatesLabels(t *testing.T) {
+	server := &labelServer{labels: map[string]bool{}}
+	defer withGithubAPI(t, server.handle)()
+
+	flags := defaultFlags()
+	flags.LabelOnFailure = "ci-failing"
+	flags.CreateLabels = true
+
+	changes := updateLabels(statusTarget{"org/repo", "deadbeef"}, nil, *flags, "failure")
+
+	expectedChanges := []labelChange{{7, "ci-failing", "added"}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Expected changes %+v, got %+v", expectedChanges, changes)
+	}
+	expectedRequests := []string{
+		"GET /repos/org/repo/commits/deadbeef/pulls",
+		"GET /repos/org/repo/labels/ci-failing",
+		"POST /repos/org/repo/labels",
+		"POST /repos/org/repo/issues/7/labels",
+	}
+	if !reflect.DeepEqual(server.requests, expectedRequests) {
+		t.Errorf("Expected requests %q, got %q", expectedRequests, server.requests)
+	}
+}
+
+func TestUpdateLabelsOnSuccessRemovesLabels(t *testing.T) {
+	server := &labelServer{labels: map[string]bool{}}
+	defer withGithubAPI(t, server.handle)()
+
+	flags := defaultFlags()
+	flags.LabelOnFailure = "ci-failing"
+	flags.UnlabelOnSuccess = "ci-failing,not-present"
+
+	changes := updateLabels(statusTarget{"org/repo", "deadbeef"}, []pullRequest{{Number: 7}}, *flags, "success")
+
+	expectedChanges := []labelChange{{7, "ci-failing", "removed"}}
+	if !reflect.DeepEqual(changes, expectedChanges) {
+		t.Errorf("Expected changes %+v, got %+v", expectedChanges, changes)
+	}
+}
+
+func TestUpdateLabelsWithoutAccess(t *testing.T) {
+	server := &labelServer{labels: map[string]bool{}, forbid: true}
+	defer withGithubAPI(t, server.handle)()
+
+	flags := defaultFlags()
+	flags.LabelOnSuccess = "ci-passing"
+	flags.UnlabelOnSuccess = "ci-failing"
+
+	changes := updateLabels(statusTarget{"org/repo", "deadbeef"}, []pullRequest{{Number: 7}, {Number: 8}}, *flags, "success")
+	if len(changes) != 0 {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+	if len(server.requests) != 1 {
+		t.Errorf("Expected to give up after the first forbidden request, got %q", server.requests)
+	}
+}
+
+func TestUpdateLabelsNothingToDo(t *testing.T) {
+	server := &labelServer{labels: map[string]bool{}}
+	defer withGithubAPI(t, server.handle)()
+
+	if changes := updateLabels(statusTarget{"org/repo", "deadbeef"}, nil, *defaultFlags(), "failure"); changes != nil {
+		t.Errorf("Expected no changes, got %+v", changes)
+	}
+	if len(server.requests) != 0 {
+		t.Errorf("Expected no requests, got %q", server.requests)
+	}
+}
@@ -0,0 +1,78 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// linkNextPattern matches the rel="next" entry of a Link header.
+var linkNextPattern = regexp.MustCompile(`<([^>]+)>;\s*rel="next"`)
+
+// nextPageURL returns the URL of the next page from a Link header, or "" on
+// the last page.
+func nextPageURL(link string) string {
+	for _, entry := range strings.Split(link, ",") {
+		if match := linkNextPattern.FindStringSubmatch(entry); match != nil {
+			return match[1]
+		}
+	}
+	return ""
+}
+
+// listCommitStatuses fetches every status posted to target, newest first,
+// including the ones later statuses superseded, following the Link header
+// through all pages.
+func listCommitStatuses(target statusTarget, flags Flags) ([]commitStatus, error) {
+	var statuses []commitStatus
+	url := githubAPIURL + "/repos/" + target.OrgRepo + "/commits/" + target.SHA + "/statuses?per_page=100"
+	for page := 1; url != ""; page++ {
+		response, err := githubRequest("GET", url, flags, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !response.ok() {
+			return nil, response.error("Error listing statuses")
+		}
+		var pageStatuses []commitStatus
+		if err := json.Unmarshal(response.Body, &pageStatuses); err != nil {
+			return nil, fmt.Errorf("Error parsing response from Github: %s", err)
+		}
+		logger.Debugf("Page %d has %d statuses", page, len(pageStatuses))
+		statuses = append(statuses, pageStatuses...)
+		url = nextPageURL(response.Header.Get("Link"))
+	}
+	return statuses, nil
+}
+
+// runListCommand implements -list: it prints every status on the SHA as a
+// line of text, or with -log-format json as a JSON object per line.
+func runListCommand(flags Flags, format string, out io.Writer) error {
+	if flags.OrgRepo == "" {
+		return errors.New("Error: No Github organization/repository provided")
+	}
+	if flags.SHA == "" {
+		return errors.New("Error: No SHA provided")
+	}
+
+	statuses, err := listCommitStatuses(statusTarget{OrgRepo: flags.OrgRepo, SHA: flags.SHA}, flags)
+	if err != nil {
+		return err
+	}
+	for _, status := range statuses {
+		if format == "json" {
+			encoded, _ := json.Marshal(status)
+			fmt.Fprintf(out, "%s\n", encoded)
+			continue
+		}
+		fmt.Fprintf(out, "%s  %-7s  %s", status.CreatedAt, status.State, status.Context)
+		if status.Description != "" {
+			fmt.Fprintf(out, "  %s", status.Description)
+		}
+		fmt.Fprintln(out)
+	}
+	return nil
+}
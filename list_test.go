@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestNextPageURL(t *testing.T) {
+	link := `<https://api.github.com/repositories/1/commits/deadbeef/statuses?page=3>; rel="next", <https://api.github.com/repositories/1/commits/deadbeef/statuses?page=5>; rel="last"`
+	if next := nextPageURL(link); next != "https://api.github.com/repositories/1/commits/deadbeef/statuses?page=3" {
+		t.Errorf("Expected the next page, got %q", next)
+	}
+	if next := nextPageURL(`<https://api.github.com/x?page=1>; rel="first", <https://api.github.com/x?page=4>; rel="prev"`); next != "" {
+		t.Errorf("Expected no next page on the last page, got %q", next)
+	}
+	if next := nextPageURL(""); next != "" {
+		t.Errorf("Expected no next page without a Link header, got %q", next)
+	}
+}
+
+// paginatedStatuses serves two pages of statuses for org/repo@deadbeef.
+func paginatedStatuses(t *testing.T) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/org/repo/commits/deadbeef/statuses" {
+			t.Errorf("Unexpected request for %s", r.URL.Path)
+		}
+		if r.URL.Query().Get("page") == "2" {
+			fmt.Fprint(w, `[{"state":"pending","context":"ci/test","created_at":"2017-06-01T12:00:00Z"}]`)
+			return
+		}
+		w.Header().Set("Link", fmt.Sprintf(`<%s/repos/org/repo/commits/deadbeef/statuses?per_page=100&page=2>; rel="next"`, githubAPIURL))
+		fmt.Fprint(w, `[{"state":"success","context":"ci/test","description":"tests passed","created_at":"2017-06-01T12:05:00Z"},
+			{"state":"failure","context":"ci/lint","created_at":"2017-06-01T12:01:00Z"}]`)
+	}
+}
+
+func TestRunListCommandFollowsPages(t *testing.T) {
+	defer withGithubAPI(t, paginatedStatuses(t))()
+	flags := defaultFlags()
+	flags.OrgRepo = "org/repo"
+
+	var out bytes.Buffer
+	if err := runListCommand(*flags, "text", &out); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := "2017-06-01T12:05:00Z  success  ci/test  tests passed\n" +
+		"2017-06-01T12:01:00Z  failure  ci/lint\n" +
+		"2017-06-01T12:00:00Z  pending  ci/test\n"
+	if out.String() != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, out.String())
+	}
+}
+
+func TestRunListCommandJSON(t *testing.T) {
+	defer withGithubAPI(t, paginatedStatuses(t))()
+	flags := defaultFlags()
+	flags.OrgRepo = "org/repo"
+
+	var out bytes.Buffer
+	if err := runListCommand(*flags, "json", &out); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	if len(lines) != 3 || !strings.Contains(lines[0], `"state":"success"`) || !strings.Contains(lines[2], `"created_at":"2017-06-01T12:00:00Z"`) {
+		t.Errorf("Expected a JSON object per status, got:\n%s", out.String())
+	}
+}
+
+func TestRunListCommandError(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message":"Not Found"}`)
+	})()
+	flags := defaultFlags()
+	flags.OrgRepo = "org/repo"
+
+	if err := runListCommand(*flags, "text", &bytes.Buffer{}); err == nil || !strings.Contains(err.Error(), "Error listing statuses") {
+		t.Errorf("Expected the API error, got %v", err)
+	}
+}
+
+func TestValidateFlagsList(t *testing.T) {
+	flags := Flags{List: true}
+	if err := validateFlags(flags, nil, ""); err != nil {
+		t.Errorf("Expected -list to need no command or context, got %s", err)
+	}
+	if err := validateFlags(flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), "-list runs no command") {
+		t.Errorf("Expected -list with a command to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,208 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+// liveOutputLines is how many of the last output lines -live-output shows.
+const liveOutputLines = 100
+
+// maxCommentLength is the longest comment body Github accepts.
+const maxCommentLength = 65535
+
+// maxLiveOutputInterval bounds how far -live-output backs off when it is
+// rate limited.
+const maxLiveOutputInterval = 10 * time.Minute
+
+// ansiEscape matches terminal escape sequences such as colors, which
+// Markdown would show as garbage.
+var ansiEscape = regexp.MustCompile(`\x1b\[[0-9;?]*[ -/]*[@-~]|\x1b\][^\x07\x1b]*(\x07|\x1b\\)|\x1b[@-_]`)
+
+// liveOutput keeps the last lines of the command's output and periodically
+// posts them with post while it runs, for -live-output. An update is only
+// posted when there is new output since the last one.
+type liveOutput struct {
+	interval time.Duration
+	post     func(body string) error
+	render   func(tail string, elapsed time.Duration) string
+
+	mu      sync.Mutex
+	lines   []string
+	written int64
+	posted  int64
+
+	started time.Time
+	stop    chan struct{}
+	done    chan struct{}
+}
+
+func newLiveOutput(interval time.Duration, render func(string, time.Duration) string, post func(string) error) *liveOutput {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return &liveOutput{interval: interval, render: render, post: post}
+}
+
+// stream returns a writer for one of the command's output streams. Each
+// stream has its own line buffer so stdout and stderr lines don't mix.
+func (l *liveOutput) stream() io.Writer {
+	return &liveOutputStream{live: l}
+}
+
+// count records that size bytes of output arrived.
+func (l *liveOutput) count(size int) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.written += int64(size)
+}
+
+// add keeps a complete line of output, without its escape sequences.
+func (l *liveOutput) add(line string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.lines = append(l.lines, strings.TrimRight(ansiEscape.ReplaceAllString(line, ""), "\r"))
+	if len(l.lines) > liveOutputLines {
+		l.lines = l.lines[len(l.lines)-liveOutputLines:]
+	}
+}
+
+// Start begins posting updates in the background.
+func (l *liveOutput) Start() {
+	l.started = time.Now()
+	l.stop = make(chan struct{})
+	l.done = make(chan struct{})
+	go func() {
+		defer close(l.done)
+		interval := l.interval
+		for {
+			select {
+			case <-l.stop:
+				return
+			case <-time.After(interval):
+				interval = l.update(interval)
+			}
+		}
+	}()
+}
+
+// Stop ends the updates, waiting for one in flight to finish. No update is
+// posted after Stop returns.
+func (l *liveOutput) Stop() {
+	if l.stop == nil {
+		return
+	}
+	close(l.stop)
+	<-l.done
+}
+
+// update posts the tail if there is new output and returns the interval
+// until the next update, doubled while Github rate limits the updates.
+func (l *liveOutput) update(interval time.Duration) time.Duration {
+	l.mu.Lock()
+	changed := l.written != l.posted
+	l.posted = l.written
+	lines := append([]string(nil), l.lines...)
+	l.mu.Unlock()
+	if !changed {
+		return interval
+	}
+
+	body := l.render(strings.Join(lines, "\n"), time.Since(l.started).Round(time.Second))
+	for len(body) > maxCommentLength && len(lines) > 1 {
+		lines = lines[1:]
+		body = l.render(strings.Join(lines, "\n"), time.Since(l.started).Round(time.Second))
+	}
+	err := l.post(body)
+	if apiErr, ok := err.(*APIError); ok && (apiErr.StatusCode == http.StatusForbidden || apiErr.StatusCode == http.StatusTooManyRequests) {
+		if interval *= 2; interval > maxLiveOutputInterval {
+			interval = maxLiveOutputInterval
+		}
+		logger.Warnf("live output update was rate limited, next one in %s", interval)
+		return interval
+	}
+	if err != nil {
+		logger.Warnf("could not update the live output: %s", err)
+	}
+	return l.interval
+}
+
+// liveOutputStream splits one output stream into lines for its liveOutput.
+type liveOutputStream struct {
+	live *liveOutput
+	line []byte
+}
+
+func (s *liveOutputStream) Write(p []byte) (int, error) {
+	s.live.count(len(p))
+	for rest := p; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			s.buffer(rest)
+			break
+		}
+		s.buffer(rest[:i])
+		s.live.add(string(s.line))
+		rest = rest[i+1:]
+		s.line = s.line[:0]
+	}
+	return len(p), nil
+}
+
+func (s *liveOutputStream) buffer(p []byte) {
+	if room := maxProgressLine - len(s.line); len(p) > room {
+		p = p[:room]
+	}
+	s.line = append(s.line, p...)
+}
+
+// codeFence returns a backtick fence longer than any run of backticks in
+// text, so the output can't end the code block early.
+func codeFence(text string) string {
+	fence := "```"
+	for strings.Contains(text, fence) {
+		fence += "`"
+	}
+	return fence
+}
+
+// renderLiveOutput is the -pr-comment body while the command runs. It
+// carries the comment's marker, so the final comment replaces it.
+func renderLiveOutput(context, tail string, elapsed time.Duration) string {
+	fence := codeFence(tail)
+	return fmt.Sprintf("%s\n### %s: running for %s\n\nLatest output:\n\n%s\n%s\n%s\n",
+		prCommentMarker(context), context, elapsed, fence, tail, fence)
+}
+
+// liveCommenter posts -live-output updates to the sticky -pr-comment of the
+// pull requests the final comment goes to, looking them up on first use.
+type liveCommenter struct {
+	target statusTarget
+	flags  Flags
+	pulls  []pullRequest
+}
+
+func (c *liveCommenter) post(body string) error {
+	if c.flags.PRNumber > 0 {
+		c.pulls = []pullRequest{{Number: c.flags.PRNumber}}
+	}
+	if c.pulls == nil {
+		pulls, err := openPullRequests(c.target, c.flags)
+		if err != nil {
+			return err
+		}
+		c.pulls = append([]pullRequest{}, pulls...)
+	}
+	for _, pull := range c.pulls {
+		if err := upsertComment(c.target, c.flags, pull.Number, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,145 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+// recordLiveOutput returns a liveOutput whose updates are appended to posts,
+// failing with err.
+func recordLiveOutput(posts *[]string, err *error) *liveOutput {
+	return newLiveOutput(time.Minute, func(tail string, elapsed time.Duration) string {
+		return renderLiveOutput("ci", tail, 0)
+	}, func(body string) error {
+		*posts = append(*posts, body)
+		return *err
+	})
+}
+
+func TestLiveOutputKeepsTail(t *testing.T) {
+	var posts []string
+	var err error
+	live := recordLiveOutput(&posts, &err)
+	stream := live.stream()
+	for i := 1; i <= 150; i++ {
+		fmt.Fprintf(stream, "\x1b[32mline %d\x1b[0m\r\n", i)
+	}
+	stream.Write([]byte("partial"))
+
+	live.update(time.Minute)
+	if len(posts) != 1 {
+		t.Fatalf("Expected an update, got %d", len(posts))
+	}
+	body := posts[0]
+	if !strings.HasPrefix(body, "<!-- gh-status-reporter:ci -->\n### ci: running for 0s\n") {
+		t.Errorf("Expected the marker and elapsed time, got:\n%s", body)
+	}
+	if strings.Contains(body, "line 50\n") || !strings.Contains(body, "```\nline 51\n") || !strings.Contains(body, "line 150\n```\n") {
+		t.Errorf("Expected the last %d lines, got:\n%s", liveOutputLines, body)
+	}
+	if strings.Contains(body, "\x1b") || strings.Contains(body, "\r") || strings.Contains(body, "partial") {
+		t.Errorf("Expected escape sequences and the partial line to be left out, got:\n%s", body)
+	}
+}
+
+func TestLiveOutputSkipsUnchanged(t *testing.T) {
+	var posts []string
+	var err error
+	live := recordLiveOutput(&posts, &err)
+	live.update(time.Minute)
+	if len(posts) != 0 {
+		t.Errorf("Expected no update without output, got %q", posts)
+	}
+	live.stream().Write([]byte("building\n"))
+	live.update(time.Minute)
+	live.update(time.Minute)
+	if len(posts) != 1 {
+		t.Errorf("Expected a single update for the same output, got %d", len(posts))
+	}
+	live.stream().Write([]byte("50%"))
+	if live.update(time.Minute); len(posts) != 2 {
+		t.Errorf("Expected a partial line to count as new output, got %d updates", len(posts))
+	}
+}
+
+func TestLiveOutputBacksOffWhenRateLimited(t *testing.T) {
+	out := withLogger(t, logWarn)
+	var posts []string
+	err := error(&APIError{Message: "Error", StatusCode: http.StatusForbidden})
+	live := recordLiveOutput(&posts, &err)
+	stream := live.stream()
+
+	stream.Write([]byte("a\n"))
+	if next := live.update(time.Minute); next != 2*time.Minute {
+		t.Errorf("Expected the interval to double, got %s", next)
+	}
+	stream.Write([]byte("b\n"))
+	if next := live.update(8 * time.Minute); next != maxLiveOutputInterval {
+		t.Errorf("Expected the interval to be capped, got %s", next)
+	}
+	err = errors.New("connection reset")
+	stream.Write([]byte("c\n"))
+	if next := live.update(8 * time.Minute); next != time.Minute {
+		t.Errorf("Expected other failures to keep the interval, got %s", next)
+	}
+	if !strings.Contains(out.String(), "rate limited, next one in 2m0s") || !strings.Contains(out.String(), "could not update the live output: connection reset") {
+		t.Errorf("Expected the failures to be logged, got:\n%s", out)
+	}
+}
+
+func TestLiveOutputFitsInComment(t *testing.T) {
+	var posts []string
+	var err error
+	live := recordLiveOutput(&posts, &err)
+	stream := live.stream()
+	for i := 0; i < liveOutputLines; i++ {
+		stream.Write([]byte(strings.Repeat("x", 2000) + "\n"))
+	}
+	live.update(time.Minute)
+	if len(posts) != 1 || len(posts[0]) > maxCommentLength || !strings.Contains(posts[0], strings.Repeat("x", 2000)) {
+		t.Errorf("Expected the oldest lines to be dropped to fit, got %d bytes", len(posts[0]))
+	}
+}
+
+func TestCodeFence(t *testing.T) {
+	if fence := codeFence("plain"); fence != "```" {
+		t.Errorf("Unexpected fence %q", fence)
+	}
+	if fence := codeFence("a ```` b"); fence != "`````" {
+		t.Errorf("Expected a longer fence, got %q", fence)
+	}
+}
+
+func TestLiveCommenterEditsStickyComment(t *testing.T) {
+	var requests, bodies []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		if r.Method == "GET" {
+			comments, _ := json.Marshal([]issueComment{{ID: 9, Body: prCommentMarker("ci") + "\nearlier run"}})
+			w.Write(comments)
+			return
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		bodies = append(bodies, string(body))
+		w.Write([]byte("{}"))
+	})()
+
+	flags := defaultFlags()
+	flags.PRComment, flags.PRNumber = true, 7
+	commenter := &liveCommenter{target: statusTarget{"org/repo", "deadbeef"}, flags: *flags}
+	if err := commenter.post(renderLiveOutput("ci", "compiling", 90*time.Second)); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if strings.Join(requests, ", ") != "GET /repos/org/repo/issues/7/comments, PATCH /repos/org/repo/issues/comments/9" {
+		t.Errorf("Unexpected requests %q", requests)
+	}
+	if len(bodies) != 1 || !strings.Contains(bodies[0], `running for 1m30s`) || !strings.Contains(bodies[0], "compiling") {
+		t.Errorf("Unexpected comment %q", bodies)
+	}
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// acquireLock takes the -lock-file lock, so overlapping runs for the same
+// context don't interleave their statuses. When another run holds it,
+// acquireLock waits for it with wait and fails otherwise. The lock is held
+// until the process exits.
+func acquireLock(path string, wait bool) error {
+	file, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening -lock-file: %s", err)
+	}
+	locked, err := lockFile(file, false)
+	if err == nil && !locked {
+		if !wait {
+			file.Close()
+			return fmt.Errorf("Error: %s holds the lock %s; pass -lock-wait to wait for it", lockHolder(path), path)
+		}
+		logger.Infof("Waiting for %s to release the lock %s", lockHolder(path), path)
+		locked, err = lockFile(file, true)
+	}
+	if err != nil {
+		file.Close()
+		return fmt.Errorf("Error locking %s: %s", path, err)
+	}
+
+	// The pid is only for the error other runs print.
+	file.Truncate(0)
+	file.WriteAt([]byte(strconv.Itoa(os.Getpid())+"\n"), 0)
+	logger.Debugf("Locked %s", path)
+	onExit(func() { file.Close() })
+	return nil
+}
+
+// lockHolder describes the run holding the lock at path by the pid it
+// wrote.
+func lockHolder(path string) string {
+	contents, _ := ioutil.ReadFile(path)
+	if pid, err := strconv.Atoi(strings.TrimSpace(string(contents))); err == nil {
+		return fmt.Sprintf("another run (pid %d)", pid)
+	}
+	return "another run"
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLockFile(t *testing.T) {
+	if !canLock {
+		t.Skip("locking files isn't supported on this platform")
+	}
+	path := filepath.Join(t.TempDir(), "ci.lock")
+	first, _ := os.Create(path)
+	defer first.Close()
+	second, _ := os.Open(path)
+	defer second.Close()
+
+	if locked, err := lockFile(first, false); !locked || err != nil {
+		t.Fatalf("Expected the lock, got %t, %v", locked, err)
+	}
+	if locked, err := lockFile(second, false); locked || err != nil {
+		t.Errorf("Expected the lock to be held, got %t, %v", locked, err)
+	}
+	first.Close()
+	if locked, err := lockFile(second, false); !locked || err != nil {
+		t.Errorf("Expected the lock once released, got %t, %v", locked, err)
+	}
+}
+
+func TestCLILockFileContention(t *testing.T) {
+	if !canLock {
+		t.Skip("locking files isn't supported on this platform")
+	}
+	dir := t.TempDir()
+	lock, started := filepath.Join(dir, "ci.lock"), filepath.Join(dir, "started")
+	run := func(extra ...string) (string, int) {
+		args := append([]string{"-replay", filepath.Join("testdata", "replay-failure.json"), "-lock-file", lock}, extra...)
+		args = append(args, "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+			"sh", "-c", `touch "$STARTED"; sleep 1; exit 3`)
+		return runCLIWithEnv(t, []string{"STARTED=" + started}, args...)
+	}
+	waitForHolder := func() {
+		for deadline := time.Now().Add(10 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+			if _, err := os.Stat(started); err == nil {
+				return
+			}
+		}
+		t.Fatal("The first run never started its command")
+	}
+
+	done := make(chan int)
+	go func() { _, code := run(); done <- code }()
+	waitForHolder()
+	out, code := run()
+	if code != 1 || !strings.Contains(out, "holds the lock "+lock) || strings.Contains(out, "pending") {
+		t.Errorf("Expected the second run to fail without posting, got %d:\n%s", code, out)
+	}
+	if code := <-done; code != 1 {
+		t.Errorf("Expected the first run to report its command's failure, got %d", code)
+	}
+
+	os.Remove(started)
+	go func() { _, code := run(); done <- code }()
+	waitForHolder()
+	os.Remove(started)
+	out, code = run("-lock-wait", "-v")
+	if code != 1 || !strings.Contains(out, "Waiting for another run (pid") {
+		t.Errorf("Expected the second run to wait for the lock and report, got %d:\n%s", code, out)
+	}
+	if _, err := os.Stat(started); err != nil {
+		t.Errorf("Expected the waiting run to run its command")
+	}
+	<-done
+}
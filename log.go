@@ -0,0 +1,130 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// logLevel is the value of the -log-level flag: the most detailed messages
+// that are logged.
+type logLevel int
+
+const (
+	logError logLevel = iota
+	logWarn
+	logInfo
+	logDebug
+	logTrace
+)
+
+var logLevelNames = []string{"error", "warn", "info", "debug", "trace"}
+
+func (l *logLevel) String() string {
+	return logLevelNames[*l]
+}
+
+func (l *logLevel) Set(value string) error {
+	for i, name := range logLevelNames {
+		if value == name {
+			*l = logLevel(i)
+			return nil
+		}
+	}
+	return fmt.Errorf("expected %s, got %q", strings.Join(logLevelNames, ", "), value)
+}
+
+func (l *logLevel) completionValues() []string {
+	return logLevelNames
+}
+
+// leveledLogger writes gh-status-reporter's own diagnostics, as opposed to the
+// command's output, to stderr. It is safe for concurrent use.
+type leveledLogger struct {
+	mu    sync.Mutex
+	out   io.Writer
+	level logLevel
+	json  bool
+}
+
+// logger is used for every diagnostic message. configureLogger sets it up
+// from the flags.
+var logger = &leveledLogger{out: os.Stderr, level: logInfo}
+
+// configureLogger applies -log-level, -log-format and their shorthands -quiet,
+// -v and -vv.
+func configureLogger(l *leveledLogger, level logLevel, format string, quiet, verbose, veryVerbose bool) error {
+	switch format {
+	case "text", "json":
+	default:
+		return fmt.Errorf("Error: -log-format must be text or json, got %q", format)
+	}
+	if quiet && (verbose || veryVerbose) {
+		return fmt.Errorf("Error: -quiet can't be used with -v or -vv")
+	}
+	switch {
+	case quiet:
+		level = logError
+	case veryVerbose:
+		level = logTrace
+	case verbose:
+		level = logDebug
+	}
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.level, l.json = level, format == "json"
+	return nil
+}
+
+// enabled reports whether messages at level are logged.
+func (l *leveledLogger) enabled(level logLevel) bool {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return level <= l.level
+}
+
+// logf logs a message at level. In text format warnings, debug and trace
+// messages are prefixed with their level, and errors with "Error: " unless
+// they already start with it, as most errors in this tree do.
+func (l *leveledLogger) logf(level logLevel, format string, args ...interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	if level > l.level {
+		return
+	}
+	message := fmt.Sprintf(format, args...)
+
+	if l.json {
+		encoded, _ := json.Marshal(struct {
+			Time    string `json:"time"`
+			Level   string `json:"level"`
+			Message string `json:"msg"`
+		}{time.Now().UTC().Format(time.RFC3339), logLevelNames[level], strings.TrimPrefix(message, "Error: ")})
+		fmt.Fprintf(l.out, "%s\n", encoded)
+		return
+	}
+
+	switch level {
+	case logError:
+		if !strings.HasPrefix(message, "Error") {
+			message = "Error: " + message
+		}
+	case logWarn:
+		message = "Warning: " + message
+	case logDebug:
+		message = "Debug: " + message
+	case logTrace:
+		message = "Trace: " + message
+	}
+	fmt.Fprintf(l.out, "%s\n", message)
+}
+
+func (l *leveledLogger) Errorf(format string, args ...interface{}) { l.logf(logError, format, args...) }
+func (l *leveledLogger) Warnf(format string, args ...interface{})  { l.logf(logWarn, format, args...) }
+func (l *leveledLogger) Infof(format string, args ...interface{})  { l.logf(logInfo, format, args...) }
+func (l *leveledLogger) Debugf(format string, args ...interface{}) { l.logf(logDebug, format, args...) }
+func (l *leveledLogger) Tracef(format string, args ...interface{}) { l.logf(logTrace, format, args...) }
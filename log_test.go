@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+)
+
+// withLogger captures the logger's output at level until the test ends.
+func withLogger(t *testing.T, level logLevel) *bytes.Buffer {
+	var out bytes.Buffer
+	original := logger
+	logger = &leveledLogger{out: &out, level: level}
+	t.Cleanup(func() { logger = original })
+	return &out
+}
+
+func TestLoggerLevels(t *testing.T) {
+	out := withLogger(t, logInfo)
+	logger.Errorf("Error: invalid -r")
+	logger.Errorf("command timed out")
+	logger.Warnf("could not read the status")
+	logger.Infof("Posting to deadbeef")
+	logger.Debugf("GET /user")
+	logger.Tracef("Rate limit: 4999 of 5000 left")
+
+	expected := "Error: invalid -r\nError: command timed out\nWarning: could not read the status\nPosting to deadbeef\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+
+	out.Reset()
+	logger.level = logTrace
+	logger.Debugf("GET /user")
+	logger.Tracef("Rate limit")
+	if out.String() != "Debug: GET /user\nTrace: Rate limit\n" {
+		t.Errorf("Unexpected trace output %q", out.String())
+	}
+}
+
+func TestLoggerJSON(t *testing.T) {
+	out := withLogger(t, logInfo)
+	if err := configureLogger(logger, logInfo, "json", false, false, false); err != nil {
+		t.Fatal(err)
+	}
+	logger.Errorf("Error: invalid -r")
+	logger.Warnf("slow")
+
+	var entries []map[string]string
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		var entry map[string]string
+		if err := json.Unmarshal([]byte(line), &entry); err != nil {
+			t.Fatalf("Expected one JSON object per line, got %q", line)
+		}
+		entries = append(entries, entry)
+	}
+	if len(entries) != 2 || entries[0]["level"] != "error" || entries[0]["msg"] != "invalid -r" || entries[1]["level"] != "warn" || entries[1]["time"] == "" {
+		t.Errorf("Unexpected entries %v", entries)
+	}
+}
+
+func TestConfigureLogger(t *testing.T) {
+	l := &leveledLogger{}
+	for _, test := range []struct {
+		quiet, verbose, veryVerbose bool
+		level                       logLevel
+	}{
+		{false, false, false, logWarn},
+		{true, false, false, logError},
+		{false, true, false, logDebug},
+		{false, true, true, logTrace},
+	} {
+		if err := configureLogger(l, logWarn, "text", test.quiet, test.verbose, test.veryVerbose); err != nil || l.level != test.level {
+			t.Errorf("Expected level %s for %+v, got %s %v", logLevelNames[test.level], test, logLevelNames[l.level], err)
+		}
+	}
+
+	if err := configureLogger(l, logInfo, "text", true, true, false); err == nil {
+		t.Errorf("Expected -quiet and -v to conflict")
+	}
+	if err := configureLogger(l, logInfo, "xml", false, false, false); err == nil {
+		t.Errorf("Expected -log-format xml to be rejected")
+	}
+
+	var level logLevel
+	if err := level.Set("debug"); err != nil || level != logDebug {
+		t.Errorf("Expected debug, got %s %v", level.String(), err)
+	}
+	if err := level.Set("verbose"); err == nil {
+		t.Errorf("Expected an unknown level to be rejected")
+	}
+}
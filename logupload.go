@@ -0,0 +1,182 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+// defaultLogUploadMethod is the default of -log-upload-method.
+const defaultLogUploadMethod = "PUT"
+
+// expandLogUploadURL renders the -log-upload-url template once the context
+// is final. Values are path escaped so they can be used as path segments,
+// except the repository, whose slash separates two segments.
+func expandLogUploadURL(flags *Flags) error {
+	if flags.LogUploadURL == "" {
+		return nil
+	}
+	data := flagTemplateData{
+		Repository: flags.OrgRepo,
+		SHA:        url.PathEscape(flags.SHA),
+		ShortSHA:   url.PathEscape(flags.SHA),
+		Context:    url.PathEscape(flags.Context),
+	}
+	if len(flags.SHA) > 7 {
+		data.ShortSHA = url.PathEscape(flags.SHA[:7])
+	}
+	if strings.Contains(flags.LogUploadURL, ".Branch") {
+		data.Branch = url.PathEscape(resolveBranch(*flags))
+	}
+	expanded, err := expandFlagTemplate("-log-upload-url", flags.LogUploadURL, data, flagTemplateFuncs(flags.AllowTemplateShell))
+	if err != nil {
+		return err
+	}
+	flags.LogUploadURL = expanded
+	return validateLogUploadURL(expanded)
+}
+
+// validateLogUploadURL checks that -log-upload-url is an http or https URL.
+func validateLogUploadURL(uploadURL string) error {
+	if parsed, err := url.Parse(uploadURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Error: -log-upload-url %q is not an http or https URL", redactURL(uploadURL))
+	}
+	return nil
+}
+
+// parseLogUploadHeaders parses the -log-upload-header "Name: value" entries.
+func parseLogUploadHeaders(headers []string) (http.Header, error) {
+	return parseHeaderFlags("-log-upload-header", headers)
+}
+
+// parseHeaderFlags parses the "Name: value" entries of the header flag
+// flagName.
+func parseHeaderFlags(flagName string, headers []string) (http.Header, error) {
+	parsed := http.Header{}
+	for _, header := range headers {
+		parts := strings.SplitN(header, ":", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			return nil, fmt.Errorf("Error: invalid %s %q, expected Name: value", flagName, header)
+		}
+		parsed.Add(strings.TrimSpace(parts[0]), strings.TrimSpace(parts[1]))
+	}
+	return parsed, nil
+}
+
+// jsonField returns the string at the dotted path field of a JSON object,
+// e.g. data.url.
+func jsonField(body []byte, field string) (string, error) {
+	var value interface{}
+	if err := json.Unmarshal(body, &value); err != nil {
+		return "", fmt.Errorf("Error parsing the log upload response: %s", err)
+	}
+	for _, key := range strings.Split(field, ".") {
+		object, ok := value.(map[string]interface{})
+		if !ok {
+			return "", fmt.Errorf("Error: the log upload response has no %s field", field)
+		}
+		value = object[key]
+	}
+	text, ok := value.(string)
+	if !ok || text == "" {
+		return "", fmt.Errorf("Error: the log upload response has no %s field", field)
+	}
+	return text, nil
+}
+
+// captureLogFile creates the temporary file the command's output is written
+// to for -log-upload-url without -output-file, so the log is streamed from
+// disk rather than held in memory. It's removed at exit.
+func captureLogFile() (string, error) {
+	file, err := ioutil.TempFile("", "gh-status-reporter-log")
+	if err != nil {
+		return "", fmt.Errorf("Error creating the log file to upload: %s", err)
+	}
+	file.Close()
+	onExit(func() { os.Remove(file.Name()) })
+	return file.Name(), nil
+}
+
+// uploadLog streams the log at path to -log-upload-url and returns the URL
+// the endpoint answers with: the -log-upload-url-field of a JSON response,
+// or else the Location header. No Github credentials are sent, only the
+// -log-upload-header headers.
+func uploadLog(flags Flags, path string) (string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", fmt.Errorf("Error opening the log %s: %s", path, err)
+	}
+	defer file.Close()
+	info, err := file.Stat()
+	if err != nil {
+		return "", fmt.Errorf("Error opening the log %s: %s", path, err)
+	}
+
+	req, err := http.NewRequest(flags.LogUploadMethod, flags.LogUploadURL, file)
+	if err != nil {
+		return "", fmt.Errorf("Error creating the log upload request: %s", err)
+	}
+	req.ContentLength = info.Size()
+	req.Header.Set("Content-Type", "text/plain; charset=utf-8")
+	headers, err := parseLogUploadHeaders(flags.LogUploadHeaders)
+	if err != nil {
+		return "", err
+	}
+	for name, values := range headers {
+		req.Header[name] = values
+	}
+	if flags.DryRun {
+		logger.Infof("Dry run: would %s the %d byte log to %s", req.Method, info.Size(), redactURL(flags.LogUploadURL))
+		recordPlannedPost(req.Method, flags.LogUploadURL, "")
+		return "", nil
+	}
+
+	transport, err := baseTransport(flags)
+	if err != nil {
+		return "", err
+	}
+	client := &http.Client{Transport: transport, Timeout: flags.HTTPTimeout}
+	logger.Debugf("Uploading the %d byte log to %s", info.Size(), redactURL(flags.LogUploadURL))
+	resp, err := client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("Error uploading the log: %s", err)
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return "", fmt.Errorf("Error reading the log upload response: %s", err)
+	}
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return "", fmt.Errorf("Error: %s %s responded with %d", req.Method, redactURL(flags.LogUploadURL), resp.StatusCode)
+	}
+
+	if flags.LogUploadURLField != "" {
+		return jsonField(body, flags.LogUploadURLField)
+	}
+	location, err := resp.Location()
+	if err != nil {
+		return "", fmt.Errorf("Error: the log upload response has no Location header; use -log-upload-url-field to read the URL from the body")
+	}
+	return location.String(), nil
+}
+
+// applyLogUpload uploads the command's log and makes its URL the target URL
+// of the final status. If the upload fails the status is posted with the
+// target URL it would have had anyway.
+func applyLogUpload(flags *Flags, path string) {
+	browseURL, err := uploadLog(*flags, path)
+	if err != nil {
+		logger.Warnf("could not upload the log, posting the status without a link to it: %s", err)
+		return
+	}
+	if browseURL == "" {
+		return
+	}
+	logger.Infof("Uploaded the log to %s", browseURL)
+	flags.TargetUrl, flags.TargetURLOnSuccess, flags.TargetURLOnFailure = browseURL, "", ""
+}
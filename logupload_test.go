@@ -0,0 +1,125 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestUploadLog(t *testing.T) {
+	var method, path, body, header, authorization string
+	var length int64
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, _ := ioutil.ReadAll(r.Body)
+		method, path, body, length = r.Method, r.URL.Path, string(contents), r.ContentLength
+		header, authorization = r.Header.Get("X-Artifact-Token"), r.Header.Get("Authorization")
+		w.Header().Set("Location", "/browse/42")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	flags := defaultFlags()
+	flags.LogUploadURL, flags.LogUploadMethod = ts.URL+"/logs/deadbeef.log", "PUT"
+	flags.LogUploadHeaders = stringSlice{"X-Artifact-Token: secret"}
+	log := writeTempFile(t, "log", "line 1\nline 2\n")
+	browseURL, err := uploadLog(*flags, log)
+	if err != nil || browseURL != ts.URL+"/browse/42" {
+		t.Errorf("Expected the resolved Location, got %q, %v", browseURL, err)
+	}
+	if method != "PUT" || path != "/logs/deadbeef.log" || body != "line 1\nline 2\n" || length != 14 {
+		t.Errorf("Unexpected upload %s %s of %d bytes: %q", method, path, length, body)
+	}
+	if header != "secret" || authorization != "" {
+		t.Errorf("Expected only the configured headers, got %q and Authorization %q", header, authorization)
+	}
+}
+
+func TestUploadLogURLField(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"data": {"url": "https://artifacts.example.com/browse/7"}}`))
+	}))
+	defer ts.Close()
+
+	flags := defaultFlags()
+	flags.LogUploadURL, flags.LogUploadMethod, flags.LogUploadURLField = ts.URL, "POST", "data.url"
+	log := writeTempFile(t, "log", "output")
+	if browseURL, err := uploadLog(*flags, log); err != nil || browseURL != "https://artifacts.example.com/browse/7" {
+		t.Errorf("Expected the URL from the JSON field, got %q, %v", browseURL, err)
+	}
+	flags.LogUploadURLField = "data.missing"
+	if _, err := uploadLog(*flags, log); err == nil || !strings.Contains(err.Error(), "has no data.missing field") {
+		t.Errorf("Expected a missing field to be an error, got %v", err)
+	}
+	flags.LogUploadURLField = ""
+	if _, err := uploadLog(*flags, log); err == nil || !strings.Contains(err.Error(), "no Location header") {
+		t.Errorf("Expected a missing Location to be an error, got %v", err)
+	}
+}
+
+func TestApplyLogUploadFailure(t *testing.T) {
+	out := withLogger(t, logWarn)
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer ts.Close()
+
+	flags := defaultFlags()
+	flags.LogUploadURL, flags.LogUploadMethod = ts.URL, "PUT"
+	flags.TargetUrl = "https://ci.example.com/1"
+	applyLogUpload(flags, writeTempFile(t, "log", "output"))
+	if flags.TargetUrl != "https://ci.example.com/1" {
+		t.Errorf("Expected the target URL to be kept, got %q", flags.TargetUrl)
+	}
+	if !strings.Contains(out.String(), "could not upload the log") || !strings.Contains(out.String(), "responded with 503") {
+		t.Errorf("Expected a warning, got:\n%s", out)
+	}
+}
+
+func TestExpandLogUploadURL(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = "ci/unit tests"
+	flags.SHA = "0123456789abcdef"
+	flags.LogUploadURL = "https://artifacts.example.com/{{.Repository}}/{{.ShortSHA}}/{{.Context}}.log"
+	if err := expandLogUploadURL(flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if expected := "https://artifacts.example.com/christopher-bui/gh-status-reporter/0123456/ci%2Funit%20tests.log"; flags.LogUploadURL != expected {
+		t.Errorf("Expected %q, got %q", expected, flags.LogUploadURL)
+	}
+
+	flags.LogUploadURL = "{{.SHA}}"
+	if err := expandLogUploadURL(flags); err == nil || !strings.Contains(err.Error(), "is not an http or https URL") {
+		t.Errorf("Expected the expanded URL to be validated, got %v", err)
+	}
+}
+
+func TestCLIUploadsLog(t *testing.T) {
+	var uploaded, path string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		contents, _ := ioutil.ReadAll(r.Body)
+		uploaded, path = string(contents), r.URL.Path
+		w.Header().Set("Location", "https://artifacts.example.com/browse/1")
+		w.WriteHeader(http.StatusCreated)
+	}))
+	defer ts.Close()
+
+	contents, _ := ioutil.ReadFile(filepath.Join("testdata", "replay-failure.json"))
+	var interactions []interaction
+	json.Unmarshal(contents, &interactions)
+	interactions[1].Body = strings.Replace(interactions[1].Body, `"target_url":""`, `"target_url":"https://artifacts.example.com/browse/1"`, 1)
+	encoded, _ := json.Marshal(interactions)
+	fixtures := writeTempFile(t, "fixtures.json", string(encoded))
+
+	out, code := runCLI(t, "-replay", fixtures, "-log-upload-url", ts.URL+"/logs/{{.SHA}}/{{.Context}}.log",
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", "echo hello; exit 3")
+	if code != 1 || strings.Contains(out, "Error") || strings.Contains(out, "could not") {
+		t.Errorf("Expected the failure to link to the uploaded log, got %d:\n%s", code, out)
+	}
+	if uploaded != "hello\n" || path != "/logs/deadbeef/ci.log" {
+		t.Errorf("Unexpected upload to %s: %q", path, uploaded)
+	}
+}
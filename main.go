@@ -6,166 +6,1536 @@ import (
 	"errors"
 	"flag"
 	"fmt"
-	"io/ioutil"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"regexp"
+	"runtime"
+	"strings"
+	"time"
+	"unicode/utf8"
 )
 
 type CommitStatusParams struct {
 	State       string `json:"state"`
 	TargetUrl   string `json:"target_url"`
 	Description string `json:"description"`
-	Context     string `json:"context"`
+	Context     string `json:"context,omitempty"`
 }
 
 type Flags struct {
-	OrgRepo     string
-	SHA         string
-	Dev         string
-	Context     string
-	Description string
-	TargetUrl   string
-	Username    string
-	Auth        string
+	OrgRepo                  string
+	SHA                      string
+	SHAFile                  string
+	Dev                      bool
+	Context                  string
+	Description              string
+	TargetUrl                string
+	DefaultDescriptions      map[string]string
+	EnvPrefix                string
+	TargetURLOnSuccess       string
+	TargetURLOnFailure       string
+	Username                 string
+	Auth                     string
+	EnvFiles                 []string
+	Env                      []string
+	EnvExpand                bool
+	EnvIsolate               bool
+	EnvAllowlist             string
+	InjectStatusEnv          bool
+	EnvAllow                 []string
+	JUnitOut                 string
+	MaskEnv                  []string
+	MaskStrings              []string
+	Repos                    string
+	Strict                   bool
+	Timestamps               timestampMode
+	OutputPrefix             string
+	TimeoutStatus            timeoutStatus
+	MissingCommandStatus     missingCommandStatus
+	RunTimeout               time.Duration
+	CmdTimeout               time.Duration
+	TimeoutGrace             time.Duration
+	NotifyPlugins            []string
+	PluginTimeout            time.Duration
+	PluginStrict             bool
+	SkipIfSame               bool
+	Branch                   string
+	OnlyBranches             string
+	OnlyOnCI                 bool
+	SkipBranches             string
+	APIURL                   string
+	Proxy                    string
+	ProxyAuth                string
+	JSONReport               string
+	RequirePR                requirePRMode
+	FailIfAlreadySuccess     bool
+	FailIfPending            bool
+	PendingMaxAge            time.Duration
+	ContentHash              string
+	SkipIfUnchanged          bool
+	RequireSignedCommit      bool
+	RequireSignedCommitError bool
+	LabelOnFailure           string
+	LabelOnSuccess           string
+	UnlabelOnSuccess         string
+	CreateLabels             bool
+	TimestampDescription     bool
+	ReporterID               string
+	TruncateWordBoundary     bool
+	DescriptionNewlines      newlineMode
+	IssueOnFailure           bool
+	IssueLabel               string
+	IssueTemplate            string
+	CloseOnSuccess           bool
+	ProgressRegex            string
+	ProgressInterval         time.Duration
+	CountRegex               string
+	LiveOutput               bool
+	LiveOutputInterval       time.Duration
+	PRComment                bool
+	AllowEmptyContext        bool
+	SanitizeContext          bool
+	BadgeFile                string
+	CacheDir                 string
+	PromTextfile             string
+	EchoMaxLines             int
+	MaxDuration              time.Duration
+	MaxDurationWarn          bool
+	VerifyResponse           bool
+	CheckScopes              bool
+	CommandRetries           int
+	RetryOnOutput            []string
+	RetryCommandOn           string
+	FailOnFlaky              bool
+	FailOnStderr             bool
+	FailOnStderrExit         bool
+	FailOnOutput             []string
+	RequireOutput            []string
+	AnnotateFormats          []string
+	AnnotateFile             string
+	BudgetTotal              bool
+	GzipRequest              bool
+	Record                   string
+	Replay                   string
+	PreferHeadSHA            bool
+	UseRunAttemptURL         bool
+	GraphQL                  bool
+	Retries                  int
+	RetryOnStatus            string
+	SharedRetries            bool
+	BackoffCap               time.Duration
+	Jitter                   jitterMode
+	OTel                     bool
+	Timings                  bool
+	Transport                transportMode
+	RollupContext            string
+	PostOrder                postOrder
+	AbortOnFailure           bool
+	ContextDescriptions      string
+	MaxAPICalls              int
+	ResponseBodyLimit        int
+	MaxBodyLogBytes          int
+	MaxAPICallsSoft          bool
+	ConnectTimeout           time.Duration
+	NoDualStack              bool
+	NoRetryOnNetworkErrors   bool
+	FallbackDelay            time.Duration
+	PreferIPv4               bool
+	ResponseHeaderTimeout    time.Duration
+	HTTPTimeout              time.Duration
+	HTTPAttemptTimeout       time.Duration
+	DedupeWindow             time.Duration
+	ContextMap               string
+	ShutdownTimeout          time.Duration
+	Stdin                    stdinMode
+	StdinFile                string
+	StdinString              string
+	NoStdin                  bool
+	OutputFile               string
+	EventsFile               string
+	LogUploadURL             string
+	LogUploadMethod          string
+	LogUploadHeaders         stringSlice
+	LogUploadURLField        string
+	Artifacts                stringSlice
+	ArtifactsFile            string
+	OutputFileMode           outputFileMode
+	GithubOutput             string
+	StepSummary              string
+	SummaryOn                summaryMode
+	Nice                     int
+	Ionice                   string
+	OOMScoreAdj              int
+	Image                    string
+	ContainerRuntime         string
+	Volumes                  []string
+	DockerArgs               []string
+	PRCommentTemplate        string
+	PayloadURL               string
+	PayloadTemplate          string
+	WebhookURL               string
+	WebhookHeaders           stringSlice
+	WebhookStrict            bool
+	PRNumber                 int
+	AllPRCommits             bool
+	IncludeFirstParent       bool
+	DryRun                   bool
+	DryRunExitZero           bool
+	Range                    string
+	AllowTemplateShell       bool
+	ContextSuffix            contextSuffixMode
+	RunAttempt               string
+	Watch                    []string
+	WatchDebounce            time.Duration
+	VaultAddr                string
+	VaultPath                string
+	VaultField               string
+	VaultRole                string
+	VaultToken               string
+	CredentialHelper         string
+	AWSSecretID              string
+	AWSSSMParameter          string
+	AWSSecretKey             string
+	StateFile                string
+	StateFileCleanup         bool
+	LockFile                 string
+	LockWait                 bool
+	AsyncFinal               bool
+	AsyncLog                 string
+	ScriptFile               string
+	CmdFD                    string
+	List                     bool
+	HealthCheck              bool
+	SkipCommand              bool
+	SHAsStdin                bool
+	SHAsInterval             time.Duration
+	State                    string
+	NoPrompt                 bool
+	// RangeBase is the resolved base SHA of -range; SHA is its head.
+	RangeBase string
+	// ContextMappings is the parsed -context-map: provider to context to
+	// the context to use for it.
+	ContextMappings map[string]map[string]string
+	// ArtifactLinks are the -artifact and -artifacts-file links, collected
+	// once the command has finished.
+	ArtifactLinks []artifact
 }
 
+var commitSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{7,40}$`)
+
+// validateRequiredFlags checks the flags needed to report statuses. Every
+// problem is returned at once, naming both the flag and its environment
+// variable.
 func validateRequiredFlags(flags Flags) error {
-	if flags.OrgRepo == "" {
-		return errors.New("Error: No Github organization/repository provided")
+	var errs multiError
+	if flags.OrgRepo == "" && flags.Repos == "" {
+		errs = append(errs, errors.New("Error: No Github organization/repository provided; set -r or "+flags.envName("ORG_REPO")))
+	} else if _, err := parseRepos(flags.OrgRepo + "," + flags.Repos); err != nil {
+		errs = append(errs, err)
+	}
+
+	// -range supplies the SHA once it is resolved.
+	if flags.Range == "" && !flags.SHAsStdin {
+		if flags.SHA == "" {
+			errs = append(errs, errors.New("Error: No SHA provided; set -s or "+flags.envName("SHA")))
+		} else if !commitSHAPattern.MatchString(flags.SHA) && flags.SHAFile != "" {
+			errs = append(errs, fmt.Errorf("Error: -sha-file %s has %q, which is not a commit SHA", flags.SHAFile, flags.SHA))
+		} else if !commitSHAPattern.MatchString(flags.SHA) {
+			errs = append(errs, fmt.Errorf("Error: -s (%s) %q is not a commit SHA", flags.envName("SHA"), flags.SHA))
+		}
+	}
+
+	if flags.Context == "" && !flags.AllowEmptyContext {
+		errs = append(errs, errors.New("Error: No Github commit status context provided; set -c or "+flags.envName("CONTEXT")))
+	} else if flags.Context != "" && !strings.Contains(flags.Context, "{{") {
+		context := flags.Context
+		if flags.SanitizeContext {
+			context = sanitizeContext(context)
+		}
+		if err := validateContext(context); err != nil {
+			errs = append(errs, err)
+		}
 	}
 
-	if flags.SHA == "" {
-		return errors.New("Error: No SHA provided")
+	if flags.Auth == "" && flags.Transport != transportGH {
+		errs = append(errs, fmt.Errorf("Error: No auth token or password provided; checked %s", authSourcesChecked(flags)))
 	}
 
-	if flags.Context == "" {
-		return errors.New("Error: No Github commit status context provided")
+	if len(errs) > 0 {
+		return errs
 	}
+	return nil
+}
 
-	if flags.Auth == "" {
-		return errors.New("Error: No auth token or password provided")
+// validateFlags checks the flags before anything runs and returns every
+// problem at once. Unless the run is a subcommand, the required flags are
+// checked too; -dev checks only the flags for running the command.
+func validateFlags(flags Flags, command []string, subcommand string) error {
+	var errs multiError
+	if subcommand == "" && len(command) == 0 && flags.ScriptFile == "" && flags.CmdFD == "" && !flags.List && !flags.HealthCheck && !flags.SkipCommand && !flags.SHAsStdin {
+		errs = append(errs, errors.New("Error: no command given"))
+	}
+	if flags.List && (len(command) > 0 || flags.ScriptFile != "") {
+		errs = append(errs, errors.New("Error: -list runs no command"))
+	}
+	if flags.CmdFD != "" {
+		if err := validateCmdFD(flags, command); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.HealthCheck && (len(command) > 0 || flags.ScriptFile != "") {
+		errs = append(errs, errors.New("Error: -healthcheck runs no command"))
+	}
+	if err := validateSkipCommandFlags(flags, command); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateSHAsStdinFlags(flags, command); err != nil {
+		if multi, ok := err.(multiError); ok {
+			errs = append(errs, multi...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if flags.Dev && flags.SkipCommand {
+		errs = append(errs, errors.New("Error: -dev only runs the command, so it can't be used with -skip-command"))
+	}
+	if flags.Dev {
+		// -dev only runs the command, so only the flags that change how it
+		// runs are checked.
+		errs = append(errs, validateCommandFlags(flags)...)
+		if len(errs) > 0 {
+			return errs
+		}
+		return nil
+	}
+	if subcommand == "" && !flags.List && !flags.HealthCheck {
+		if err := validateRequiredFlags(flags); err != nil {
+			errs = append(errs, err.(multiError)...)
+		}
+	}
+
+	for _, target := range []struct{ name, value string }{
+		{"-t (" + flags.envName("TARGET_URL") + ")", flags.TargetUrl},
+		{"-target-url-on-success (" + flags.envName("TARGET_URL_ON_SUCCESS") + ")", flags.TargetURLOnSuccess},
+		{"-target-url-on-failure (" + flags.envName("TARGET_URL_ON_FAILURE") + ")", flags.TargetURLOnFailure},
+	} {
+		if target.value == "" || strings.Contains(target.value, "{{") {
+			continue
+		}
+		if parsed, err := url.Parse(target.value); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+			errs = append(errs, fmt.Errorf("Error: %s %q is not an http or https URL", target.name, target.value))
+		}
+	}
+	if flags.Range != "" {
+		if _, _, err := parseRange(flags.Range); err != nil {
+			errs = append(errs, err)
+		}
+		if flags.PreferHeadSHA {
+			errs = append(errs, errors.New("Error: -range and -prefer-head-sha can't be used together"))
+		}
+		if flags.SHAFile != "" {
+			errs = append(errs, errors.New("Error: -range and -sha-file can't be used together"))
+		}
+	}
+	if flags.Record != "" && flags.Replay != "" {
+		errs = append(errs, errors.New("Error: -record and -replay can't be used together"))
+	}
+	for _, patterns := range []struct {
+		name     string
+		patterns []string
+	}{{"-fail-on-output", flags.FailOnOutput}, {"-require-output", flags.RequireOutput}} {
+		if _, err := compilePatterns(patterns.name, patterns.patterns); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseContextMap(flags.ContextMap); err != nil {
+		if multi, ok := err.(multiError); ok {
+			errs = append(errs, multi...)
+		} else {
+			errs = append(errs, err)
+		}
+	}
+	if _, err := parseAnnotationFormats(flags.AnnotateFormats); err != nil {
+		errs = append(errs, err)
+	}
+	if flags.AnnotateFile != "" && len(flags.AnnotateFormats) == 0 {
+		errs = append(errs, errors.New("Error: -annotate-file requires -annotate-format"))
+	}
+	if flags.PRNumber != 0 && !flags.PRComment && !flags.AllPRCommits {
+		errs = append(errs, errors.New("Error: -pr-number requires -pr-comment or -all-pr-commits"))
+	} else if flags.PRNumber < 0 {
+		errs = append(errs, fmt.Errorf("Error: -pr-number must be a pull request number, got %d", flags.PRNumber))
+	}
+	if flags.AllPRCommits && flags.PRNumber == 0 {
+		errs = append(errs, errors.New("Error: -all-pr-commits requires -pr-number"))
+	}
+	if flags.FailOnStderrExit && !flags.FailOnStderr {
+		errs = append(errs, errors.New("Error: -fail-on-stderr-exit requires -fail-on-stderr"))
+	}
+	if _, err := parseRetryStatuses(flags.RetryOnStatus); err != nil {
+		errs = append(errs, err)
+	}
+	if flags.AsyncFinal && len(flags.Watch) > 0 {
+		errs = append(errs, errors.New("Error: -async-final can't be used with -watch"))
+	}
+	if flags.LiveOutput && !flags.PRComment {
+		errs = append(errs, errors.New("Error: -live-output requires -pr-comment"))
+	}
+	if flags.LiveOutputInterval < 0 {
+		errs = append(errs, errors.New("Error: -live-output-interval must not be negative"))
+	}
+	if flags.LogUploadURL != "" {
+		if !strings.Contains(flags.LogUploadURL, "{{") {
+			if err := validateLogUploadURL(flags.LogUploadURL); err != nil {
+				errs = append(errs, err)
+			}
+		}
+		if _, err := parseLogUploadHeaders(flags.LogUploadHeaders); err != nil {
+			errs = append(errs, err)
+		}
+		if len(flags.Watch) > 0 {
+			errs = append(errs, errors.New("Error: -log-upload-url can't be used with -watch"))
+		}
+	} else if len(flags.LogUploadHeaders) > 0 || flags.LogUploadURLField != "" {
+		errs = append(errs, errors.New("Error: -log-upload-header and -log-upload-url-field require -log-upload-url"))
+	}
+	for _, value := range flags.Artifacts {
+		if _, err := parseArtifact(value); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.ResponseBodyLimit < 0 {
+		errs = append(errs, fmt.Errorf("Error: -response-body-limit must not be negative, got %d", flags.ResponseBodyLimit))
+	}
+	if utf8.RuneCountInString(flags.ReporterID) > maxReporterIDLength {
+		errs = append(errs, fmt.Errorf("Error: -reporter-id must be at most %d characters, got %d", maxReporterIDLength, utf8.RuneCountInString(flags.ReporterID)))
+	}
+	if flags.HTTPAttemptTimeout < 0 {
+		errs = append(errs, fmt.Errorf("Error: -http-attempt-timeout must not be negative, got %s", flags.HTTPAttemptTimeout))
+	}
+	if flags.FallbackDelay < 0 {
+		errs = append(errs, fmt.Errorf("Error: -fallback-delay must not be negative, got %s", flags.FallbackDelay))
+	} else if flags.FallbackDelay > 0 && flags.NoDualStack {
+		errs = append(errs, errors.New("Error: -fallback-delay can't be used with -dial-dual-stack=false"))
+	}
+	if flags.RunTimeout < 0 {
+		errs = append(errs, errors.New("Error: -run-timeout must not be negative"))
+	}
+	if flags.MaxBodyLogBytes < 0 {
+		errs = append(errs, fmt.Errorf("Error: -max-body-log-bytes must not be negative, got %d", flags.MaxBodyLogBytes))
+	}
+	if flags.Transport == transportGH {
+		if _, err := ghPath(); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.RollupContext != "" {
+		if subcommand != "pipeline" {
+			errs = append(errs, errors.New("Error: -rollup-context is only used by the pipeline subcommand"))
+		} else if err := validateContext(flags.RollupContext); err != nil {
+			errs = append(errs, fmt.Errorf("Error: -rollup-context: %s", strings.TrimPrefix(err.Error(), "Error: ")))
+		}
+	}
+	if flags.ContextDescriptions != "" {
+		if subcommand != "pipeline" {
+			errs = append(errs, errors.New("Error: -context-descriptions is only used by the pipeline subcommand"))
+		} else if _, err := parseContextDescriptions(flags.ContextDescriptions); err != nil {
+			if multi, ok := err.(multiError); ok {
+				errs = append(errs, multi...)
+			} else {
+				errs = append(errs, err)
+			}
+		}
+	}
+	if flags.PostOrder != postOrderInput && subcommand != "pipeline" {
+		errs = append(errs, errors.New("Error: -post-order is only used by the pipeline subcommand"))
+	}
+	if flags.AbortOnFailure && subcommand != "pipeline" {
+		errs = append(errs, errors.New("Error: -abort-on-failure is only used by the pipeline subcommand"))
+	}
+	if flags.PendingMaxAge < 0 {
+		errs = append(errs, fmt.Errorf("Error: -pending-max-age must not be negative, got %s", flags.PendingMaxAge))
+	} else if flags.PendingMaxAge > 0 && !flags.FailIfPending {
+		errs = append(errs, errors.New("Error: -pending-max-age is only used with -fail-if-pending"))
+	}
+	if flags.BackoffCap < 0 {
+		errs = append(errs, fmt.Errorf("Error: -backoff-cap must not be negative, got %s", flags.BackoffCap))
+	}
+	if flags.RequireSignedCommitError && !flags.RequireSignedCommit {
+		errs = append(errs, errors.New("Error: -require-signed-commit-error requires -require-signed-commit"))
+	}
+	if flags.LockWait && flags.LockFile == "" {
+		errs = append(errs, errors.New("Error: -lock-wait requires -lock-file"))
+	}
+	if flags.LockFile != "" && !canLock {
+		errs = append(errs, errors.New("Error: -lock-file isn't supported on this platform"))
+	}
+	if flags.SharedRetries && flags.Retries == 0 {
+		errs = append(errs, errors.New("Error: -retry-budget-shared-across-contexts requires -retries"))
+	}
+	if flags.MaxAPICalls < 0 {
+		errs = append(errs, fmt.Errorf("Error: -max-api-calls must not be negative, got %d", flags.MaxAPICalls))
+	} else if flags.MaxAPICallsSoft && flags.MaxAPICalls == 0 {
+		errs = append(errs, errors.New("Error: -max-api-calls-soft requires -max-api-calls"))
+	}
+	errs = append(errs, validateCommandFlags(flags)...)
+	for _, err := range []error{validateDryRunFlags(flags), validateBranchPatterns(flags), validatePayloadFlags(flags), validateWebhookFlags(flags), validateStdinFlags(flags), validateContentHashFlags(flags)} {
+		if err != nil {
+			errs = append(errs, err)
+		}
 	}
 
+	if len(errs) > 0 {
+		return errs
+	}
 	return nil
 }
 
-func setGithubCommitStatus(url string, flags Flags, state string) error {
-	params := &CommitStatusParams{
+// validateCommandFlags checks the flags that change how the command runs.
+func validateCommandFlags(flags Flags) multiError {
+	var errs multiError
+	if len(flags.RetryOnOutput) > 0 {
+		if flags.CommandRetries == 0 {
+			errs = append(errs, errors.New("Error: -retry-on-output requires -command-retries"))
+		}
+		if _, err := compilePatterns("-retry-on-output", flags.RetryOnOutput); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.RetryCommandOn != "" {
+		if flags.CommandRetries == 0 {
+			errs = append(errs, errors.New("Error: -retry-command-on requires -command-retries"))
+		}
+		if _, err := parseRetryExitCodes(flags.RetryCommandOn); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.Image != "" {
+		if err := validateContainerFlags(flags); err != nil {
+			errs = append(errs, err)
+		}
+	}
+	if flags.ScriptFile != "" {
+		if err := validateScriptFlags(flags); err != nil {
+			errs = append(errs, err.(multiError)...)
+		}
+	}
+	if err := validateWatchPatterns(flags); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateEnvAllow(flags); err != nil {
+		errs = append(errs, err)
+	}
+	if err := validateEnvAllowlist(flags); err != nil {
+		errs = append(errs, err)
+	}
+	return errs
+}
+
+// exitIfInvalid prints every validation problem in err, followed by a pointer
+// to -help, and exits.
+func exitIfInvalid(err error) {
+	if err != nil {
+		exitWithError(&kindError{errorKindConfig, err}, 1, err.Error()+"\nRun with -help to see all flags.")
+	}
+}
+
+// statusParams builds the commit status posted for state.
+func statusParams(flags Flags, state string) *CommitStatusParams {
+	return &CommitStatusParams{
 		State:       state,
-		TargetUrl:   flags.TargetUrl,
-		Description: flags.Description,
+		TargetUrl:   statusTargetURL(flags, state),
+		Description: statusDescription(flags, state),
 		Context:     flags.Context,
 	}
+}
+
+// statusTargetURL returns the target URL for state: -target-url-on-success or
+// -target-url-on-failure for the matching final states, otherwise -t.
+func statusTargetURL(flags Flags, state string) string {
+	switch {
+	case state == "success" && flags.TargetURLOnSuccess != "":
+		return flags.TargetURLOnSuccess
+	case (state == "failure" || state == "error") && flags.TargetURLOnFailure != "":
+		return flags.TargetURLOnFailure
+	}
+	return flags.TargetUrl
+}
+
+// commitStatusMethod is how commit statuses are set. Github can't update a
+// status; each POST creates one that supersedes the context's last.
+const commitStatusMethod = "POST"
+
+// setGithubCommitStatus sends the status for state to url with method. POST
+// creates and must answer 201 Created; any other method updates an existing
+// object and must answer 200 OK.
+func setGithubCommitStatus(method, url string, flags Flags, state string) error {
+	params := statusParams(flags, state)
+
+	key := dedupeKey(url, params)
+	if flags.DedupeWindow > 0 {
+		if ago, ok := recentlyPosted(key, flags.DedupeWindow); ok {
+			logger.Infof("Not posting %s on %s again, the same status was posted %s ago", state, params.Context, ago.Round(time.Millisecond))
+			return nil
+		}
+	}
 
 	requestBody, err := json.Marshal(params)
 	if err != nil {
 		return fmt.Errorf("Error converting %q to json %s.", params, err)
 	}
 
-	req, err := http.NewRequest("POST", url, bytes.NewReader(requestBody))
+	req, err := http.NewRequest(method, url, bytes.NewReader(requestBody))
+	if err != nil {
+		return fmt.Errorf("Error creating request to Github: %s", err)
+	}
 	req.SetBasicAuth(flags.Username, flags.Auth)
 
-	client := &http.Client{}
+	client, err := newHTTPClient(flags)
+	if err != nil {
+		return err
+	}
 
 	resp, err := client.Do(req)
 	if err != nil {
-		return fmt.Errorf("Error executing request to Github: %s", err)
+		return githubAPIError(fmt.Errorf("Error executing request to Github: %s", err))
 	}
 
-	responseBody, err := ioutil.ReadAll(resp.Body)
+	response, err := readAPIResponse(method, url, resp, flags.ResponseBodyLimit)
 	if err != nil {
-		return fmt.Errorf("Error reading response body: %q %s", resp.Body, err)
+		return err
 	}
 
-	if resp.StatusCode != http.StatusCreated {
-		return fmt.Errorf("Error creating commit status on Github.\n%s", responseBody)
+	expected, action := http.StatusOK, "updating"
+	if method == "POST" {
+		expected, action = http.StatusCreated, "creating"
+	}
+	if resp.StatusCode != expected {
+		apiErr := response.error("Error " + action + " commit status on Github")
+		apiErr.LogLimit = flags.MaxBodyLogBytes
+		return apiErr
 	}
+	rememberPost(key)
 
+	if flags.VerifyResponse && !flags.DryRun {
+		return verifyStatusResponse(response.Body, flags.SHA, *params)
+	}
 	return nil
 }
 
+// runUnreported runs the command without reporting any statuses and exits
+// with its exit code.
+func runUnreported(subprocess *exec.Cmd, options commandOptions, flags Flags, report *runReport, reason string) {
+	logger.Infof("Not reporting statuses: %s", reason)
+	result := runCommandAttempts(subprocess, options)
+	timeCommand(result)
+	writeReports(flags, result, report)
+	exitIfCommandFailed(flags, result, dryRunExitCode(flags, result.ExitCode))
+	exit(0)
+}
+
+// exitIfCommandFailed exits with code if the command failed. The failure has
+// been reported already, so only -json-errors prints anything.
+func exitIfCommandFailed(flags Flags, result *commandResult, code int) {
+	if result.Err != nil && code != 0 {
+		exitWithError(&kindError{errorKindCommand, result.Err}, code, "")
+	}
+}
+
+// resolveToken sets flags.Auth from the configured token source when -a
+// isn't given, and asks for it on a terminal when no source has one. doctor
+// reports problems with the token source as a check instead.
+func resolveToken(flags *Flags, subcommand string) {
+	if flags.Auth != "" {
+		logger.Debugf("Using the token from -a or %s", flags.envName("AUTH"))
+	} else if subcommand != "doctor" {
+		source, err := configuredTokenSource(*flags)
+		exitIfError(err)
+		if source != nil {
+			token, err := source.Token()
+			exitIfError(err)
+			logger.Debugf("Using the token from %s", source)
+			flags.Auth = token
+		}
+	}
+	flags.Auth = resolveAuth(flags.Auth, os.Getenv)
+	if flags.Auth == "" && flags.CredentialHelper != "" && subcommand != "doctor" {
+		source := &credentialHelperSource{command: flags.CredentialHelper, apiURL: githubAPIURL}
+		token, err := source.Token()
+		exitIfError(err)
+		logger.Debugf("Using the token from %s", source)
+		flags.Auth = token
+		// Basic auth setups get the user from the helper too, unless -u
+		// names one.
+		if flags.Username == "" {
+			flags.Username = source.username
+		}
+	}
+	if subcommand == "" && shouldPromptForToken(*flags, os.Stdin, os.Stderr) {
+		token, err := promptToken(os.Stdin, os.Stderr, disableEcho)
+		exitIfError(err)
+		flags.Auth = token
+	}
+	fatalErrors.Secrets = append(fatalErrors.Secrets, flags.Auth, flags.VaultToken, flags.ProxyAuth)
+}
+
+// resolveReportingFlags works out the SHA and context statuses are posted
+// with from the validated flags.
+func resolveReportingFlags(flags *Flags) {
+	exitIfError(resolveSHA(flags))
+	applyRunAttemptURL(flags, os.Getenv)
+	exitIfError(expandFlagTemplates(flags))
+	exitIfError(applyContextSuffix(flags, gitCommitter))
+	if flags.SanitizeContext {
+		applySanitizeContext(flags)
+	}
+	exitIfError(expandLogUploadURL(flags))
+	mappings, err := parseContextMap(flags.ContextMap)
+	exitIfError(err)
+	flags.ContextMappings = mappings
+	if flags.Context != "" {
+		// Templates and suffixes can make a context invalid after all.
+		exitIfError(validateContext(flags.Context))
+		if warning := contextWhitespaceWarning(flags.Context); warning != "" {
+			logger.Warnf("%s", warning)
+		}
+	}
+}
+
 func exitIfError(err error) {
 	if err != nil {
-		fmt.Printf("%s\n", err.Error())
-		os.Exit(1)
+		exitWithError(err, 1, err.Error())
 	}
 }
 
 func main() {
-	orgRepo := flag.String("r", os.Getenv("BUILD_ORG_REPO"), "Required: Github repository in the form of organization/repository, e.g google/cadvisor")
-	sha := flag.String("s", os.Getenv("BUILD_SHA"), "Required: Github commit status SHA")
-	context := flag.String("c", os.Getenv("BUILD_CONTEXT"), "Required: Github commit status context")
-	description := flag.String("d", os.Getenv("BUILD_DESCRIPTION"), "Optional: Github commit status description")
-	targetUrl := flag.String("t", os.Getenv("BUILD_TARGET_URL"), "Optional: Github commit status target_url")
-	username := flag.String("u", os.Getenv("BUILD_USER"), "Optional: Github username for basic auth")
-	auth := flag.String("a", os.Getenv("BUILD_AUTH"), "Required: Github password or token for basic auth")
-	dev := flag.String("dev", os.Getenv("BUILD_DEV"), "Optional: If provided, then ignores required flags and executes command as-is; without any status reporting")
+	if payload := os.Getenv(asyncFinalEnv); payload != "" {
+		os.Exit(runAsyncFinal(payload))
+	}
+	orgRepo := envString("r", "ORG_REPO", "Required: Github repository in the form of organization/repository, e.g google/cadvisor")
+	sha := envString("s", "SHA", "Required: Github commit status SHA")
+	shaFile := envString("sha-file", "SHA_FILE", "Optional: Read the SHA from this file instead of -s")
+	commitRange := envString("range", "RANGE", "Optional: Post to both ends of a commit range base..head instead of -s; branch and tag names are resolved with git")
+	preferHeadSHA := flag.Bool("prefer-head-sha", false, "Optional: On pull_request events, post to the pull request's head SHA from $GITHUB_EVENT_PATH instead of -s")
+	useRunAttemptURL := envBool("use-run-attempt-url", "USE_RUN_ATTEMPT_URL", "Optional: When -t is empty, link the statuses to the GitHub Actions run attempt from GITHUB_SERVER_URL, GITHUB_REPOSITORY, GITHUB_RUN_ID and GITHUB_RUN_ATTEMPT")
+	context := envString("c", "CONTEXT", "Required: Github commit status context")
+	description := envString("d", "DESCRIPTION", "Optional: Github commit status description")
+	defaultDescriptionPending := envString("default-description-pending", "DEFAULT_DESCRIPTION_PENDING", "Optional: Description of the pending status when -d is empty instead of \"Waiting for build...\"")
+	defaultDescriptionSuccess := envString("default-description-success", "DEFAULT_DESCRIPTION_SUCCESS", "Optional: Description of the success status when -d is empty instead of \"Build passed\"")
+	defaultDescriptionFailure := envString("default-description-failure", "DEFAULT_DESCRIPTION_FAILURE", "Optional: Description of the failure status when -d is empty instead of \"Build failed\"")
+	defaultDescriptionError := envString("default-description-error", "DEFAULT_DESCRIPTION_ERROR", "Optional: Description of the error status when -d is empty instead of \"Build errored\"")
+	targetUrl := envString("t", "TARGET_URL", "Optional: Github commit status target_url")
+	targetURLOnSuccess := envString("target-url-on-success", "TARGET_URL_ON_SUCCESS", "Optional: target_url of the success status instead of -t, e.g. the artifacts page")
+	targetURLOnFailure := envString("target-url-on-failure", "TARGET_URL_ON_FAILURE", "Optional: target_url of the failure and error statuses instead of -t, e.g. the build log")
+	awsSecretID := envString("aws-secret-id", "AWS_SECRET_ID", "Optional: Read the Github token from this AWS Secrets Manager secret when -a isn't set")
+	credentialHelper := envString("credential-helper", "CREDENTIAL_HELPER", "Optional: Run this git style credential helper, e.g. \"git credential fill\", and use the password= it prints as the token when no other source has one")
+	awsSSMParameter := envString("aws-ssm-parameter", "AWS_SSM_PARAMETER", "Optional: Read the Github token from this SSM Parameter Store parameter, decrypted, when -a isn't set")
+	awsSecretKey := envString("aws-secret-key", "AWS_SECRET_KEY", "Optional: Field holding the token when the AWS secret is a JSON object")
+	doctorOutput := flag.String("output", "text", "Optional: With the doctor and verify subcommands, print the result as text or json")
+	allowStates := flag.String("allow", "", "Optional: With the verify subcommand, comma separated states that pass besides success, e.g. pending")
+	combined := flag.Bool("combined", false, "Optional: With the verify subcommand, verify the commit's combined state instead of the -c context")
+	writeTest := flag.Bool("write-test", false, "Optional: With the doctor subcommand, also post a throwaway status on the gh-status-reporter/doctor context")
+	stateFile := envString("state-file", "STATE_FILE", "Optional: Keep the last posted state in this JSON file, replaced atomically at each transition, for other processes to poll")
+	stateFileCleanup := flag.Bool("state-file-cleanup", false, "Optional: Remove the -state-file when the reporter exits instead of leaving the final state")
+	lockFile := envString("lock-file", "LOCK_FILE", "Optional: Hold an exclusive lock on this file while reporting, so overlapping runs for the same context fail instead of interleaving their statuses")
+	lockWait := envBool("lock-wait", "LOCK_WAIT", "Optional: Wait for the run holding the -lock-file instead of failing")
+	vaultAddr := flag.String("vault-addr", os.Getenv("VAULT_ADDR"), "Optional: Vault server for -vault-path; defaults to $VAULT_ADDR")
+	vaultPath := envString("vault-path", "VAULT_PATH", "Optional: Read the Github token from this Vault secret, e.g. secret/data/ci/github, when -a isn't set")
+	vaultField := flag.String("vault-field", defaultVaultField, "Optional: Field of the -vault-path secret holding the token")
+	vaultRole := envString("vault-role", "VAULT_ROLE", "Optional: Log in to Vault with the pod's Kubernetes service account and this role instead of $VAULT_TOKEN")
+	asyncFinal := flag.Bool("async-final", false, "Optional: Post the final status from a detached background process and exit with the command's exit code at once")
+	asyncLog := envString("async-log", "ASYNC_LOG", "Optional: File -async-final appends the outcome of each background post to as JSON lines; defaults to gh-status-reporter-async.log in the temporary directory")
+	var watch stringSlice
+	flag.Var(&watch, "watch", "Optional: For local development, rerun the command and update the status whenever files matching this glob change; repeatable")
+	watchDebounce := flag.Duration("watch-debounce", defaultWatchDebounce, "Optional: How long files must stop changing before -watch reruns the command")
+	var contextSuffixFlag contextSuffixMode
+	flag.Var(&contextSuffixFlag, "status-context-suffix", "Optional: Add the run attempt (attempt), e.g. ci/test#2, the commit's committer (committer) or the short -content-hash (content-hash) to the context so reruns are told apart")
+	runAttempt := flag.String("run-attempt", os.Getenv("GITHUB_RUN_ATTEMPT"), "Optional: Run attempt number for -status-context-suffix=attempt; defaults to $GITHUB_RUN_ATTEMPT")
+	allowTemplateShell := flag.Bool("allow-template-shell", false, "Optional: Let -c, -d and -t templates run shell commands with {{sh \"command\"}}; only use with trusted flag values")
+	allowEmptyContext := flag.Bool("allow-empty-context", false, "Optional: Don't require -c; Github then uses the \"default\" context")
+	sanitizeContext := flag.Bool("sanitize-context", false, "Optional: Trim and collapse whitespace and strip control characters from the context before posting")
+	username := envString("u", "USER", "Optional: Github username for basic auth")
+	auth := envString("a", "AUTH", "Required: Github password or token for basic auth; defaults to $GH_TOKEN or $GITHUB_TOKEN, or $GH_ENTERPRISE_TOKEN for Enterprise Server")
+	dryRun := flag.Bool("dry-run", false, "Optional: Run the command and read from Github, but print status posts and other changes instead of making them")
+	dryRunExitZero := flag.Bool("dry-run-exit-zero", false, "Optional: With -dry-run, exit 0 even if the command fails")
+	list := flag.Bool("list", false, "Optional: Print every status posted to -s, including superseded ones, instead of running a command")
+	noPrompt := flag.Bool("no-prompt", false, "Optional: Never ask for a token on the terminal when none is configured; fail straight away instead")
+	healthCheck := flag.Bool("healthcheck", false, "Optional: Check the API can be reached and the token can post statuses, then exit, without posting or running a command")
+	skipCommand := flag.Bool("skip-command", false, "Optional: Run no command, only post pending and then the -state final status, for results computed elsewhere")
+	skipState := flag.String("state", "", "Optional: With -skip-command or -shas-stdin, the final state to post: passed, failed, broken, queued or running, the GitHub state success, failure, error or pending, or an alias such as ok or fail; defaults to success")
+	shasStdin := flag.Bool("shas-stdin", false, "Optional: Run no command, only post the -state final status to every newline separated SHA read from stdin")
+	shasInterval := flag.Duration("shas-interval", 0, "Optional: With -shas-stdin, the minimum time between starting posts to successive SHAs")
+	scriptFile := flag.String("f", "", "Optional: Run this script instead of a command, via its #! line or sh; - reads it from stdin. Arguments after -- are passed to it. With the pipeline subcommand, the pipeline file")
+	cmdFD := flag.String("cmd-fd", "", "Optional: Read the command to run from this already open file descriptor, one argument per line, instead of the arguments")
+	parallel := flag.Int("parallel", runtime.NumCPU(), "Optional: With the pipeline subcommand, how many stages run at once; with -shas-stdin, how many SHAs are posted to at once")
+	rollupContext := envString("rollup-context", "ROLLUP_CONTEXT", "Optional: With the pipeline subcommand, also post this context, success only if every stage succeeded; overrides the pipeline file's rollup")
+	keepGoing := flag.Bool("keep-going", false, "Optional: With the pipeline subcommand, keep starting stages that don't depend on a failed one instead of stopping at the first failure")
+	abortOnFailure := flag.Bool("abort-on-failure", false, "Optional: With the pipeline subcommand, stop the running stages at the first failure and post them and the stages that hadn't started as error")
+	var dev, noReport devMode
+	envVar(&dev, "dev", "DEV", "Optional: Run the command as-is without validating flags, calling the API or reporting any status, and exit with its exit code")
+	flag.Var(&noReport, "no-report", "Optional: Same as -dev")
+	var envFiles, envAssignments stringSlice
+	flag.Var(&envFiles, "env-file", "Optional: dotenv file applied to the command's environment; repeatable, later files override earlier ones")
+	flag.Var(&envAssignments, "env", "Optional: KEY=VALUE applied to the command's environment after any env files; repeatable")
+	jsonReport := envString("json-report", "JSON_REPORT", "Optional: Write a JSON summary of the run to this file")
+	var requirePR requirePRMode
+	flag.Var(&requirePR, "require-pr", "Optional: Only report statuses when an open pull request contains the commit; with -require-pr=fail, refuse to run the command instead")
+	requireSignedCommit := flag.Bool("require-signed-commit", false, "Optional: Report failure instead of success unless Github has verified the commit's GPG or SSH signature")
+	requireSignedCommitError := flag.Bool("require-signed-commit-error", false, "Optional: With -require-signed-commit, exit with an error before running the command when the commit isn't verified")
+	junitOut := envString("junit-out", "JUNIT_OUT", "Optional: Write a JUnit XML summary of the command to this file")
+	repos := envString("repos", "REPOS", "Optional: Comma separated list of additional organization/repository names to post the same status to")
+	verifyResponse := flag.Bool("verify-response", false, "Optional: Check that the status Github reports creating has the SHA, state and context that were posted")
+	checkScopes := flag.Bool("check-scopes", false, "Optional: Check that the token has the repo:status scope before running the command")
+	strict := flag.Bool("strict", false, "Optional: Fail if posting to any repository fails, instead of only when all of them fail, and with -only-on-ci when not on CI")
+	cmdTimeout := flag.Duration("cmd-timeout", 0, "Optional: Stop the command if it runs longer than this duration, e.g. 30m")
+	runTimeout := flag.Duration("run-timeout", 0, "Optional: Stop the whole run if it takes longer than this, e.g. 1h, posting an error status and exiting with 124; bounds waits, retries and the command together")
+	var timeoutState timeoutStatus
+	flag.Var(&timeoutState, "timeout-status", "Optional: State posted when -cmd-timeout stops the command: error, the default, or failure")
+	var missingCommand missingCommandStatus
+	flag.Var(&missingCommand, "missing-command-status", "Optional: Outcome when the command isn't installed: error, the default, failure, success, or skip, which posts success saying it was skipped")
+	maxDuration := flag.Duration("max-duration", 0, "Optional: Report failure if the command succeeds but takes longer than this duration; it is not stopped")
+	maxDurationWarn := flag.Bool("max-duration-warn", false, "Optional: With -max-duration, keep success and only add a warning to the description")
+	budgetTotal := flag.Bool("budget-total", false, "Optional: Measure -max-duration against all command attempts together instead of only the last one")
+	commandRetries := flag.Int("command-retries", 0, "Optional: Run the command again up to this many times if it fails")
+	retryCommandOn := flag.String("retry-command-on", "", "Optional: With -command-retries, only retry a failed command that exited with one of these comma separated codes, e.g. 2,137; a signal n counts as 128+n")
+	failOnStderr := flag.Bool("fail-on-stderr", false, "Optional: Report failure if the command wrote anything to stderr, even if it exited 0; the exit code is unchanged")
+	failOnStderrExit := flag.Bool("fail-on-stderr-exit", false, "Optional: With -fail-on-stderr, also exit 1 when the command wrote to stderr")
+	failOnFlaky := flag.Bool("fail-on-flaky", false, "Optional: Report failure if the command only passed after a retry")
+	timeoutGrace := flag.Duration("timeout-grace", 0, "Optional: When -cmd-timeout fires, send SIGTERM and wait this long before SIGKILL")
+	var notifyPlugins stringSlice
+	flag.Var(&notifyPlugins, "notify-plugin", "Optional: Executable run with a JSON event on stdin at each status transition; repeatable")
+	pluginTimeout := flag.Duration("plugin-timeout", defaultPluginTimeout, "Optional: How long each notify plugin invocation may run")
+	pluginStrict := flag.Bool("plugin-strict", false, "Optional: Exit non-zero if any notify plugin invocation fails")
+	var timestamps timestampMode
+	flag.Var(&timestamps, "timestamps", "Optional: Prefix each line of the command's output with an RFC3339 timestamp, or the offset from command start with -timestamps=relative")
+	outputPrefix := flag.String("output-prefix", "", "Optional: Start each line of the command's output with this, e.g. \"[build] \", to tell it apart from the reporter's own messages")
+	skipIfSame := flag.Bool("skip-if-same", false, "Optional: Skip posting a status when the context already has the same state, description and target_url")
+	branch := envString("branch", "BRANCH", "Optional: Branch being built, for -only-branches and -skip-branches; detected from CI or git when empty")
+	onlyOnCI := envBool("only-on-ci", "ONLY_ON_CI", "Optional: Only post statuses when a CI environment variable such as CI=true is set; elsewhere just run the command, or fail with -strict")
+	onlyBranches := envString("only-branches", "ONLY_BRANCHES", "Optional: Comma separated branch globs; statuses are only reported for matching branches")
+	skipBranches := envString("skip-branches", "SKIP_BRANCHES", "Optional: Comma separated branch globs; statuses are never reported for matching branches")
+	apiURL := flag.String("api-url", os.Getenv("GITHUB_API_URL"), "Optional: Github API URL, e.g. https://github.example.com/api/v3 for Enterprise Server; defaults to $GITHUB_API_URL or https://api.github.com")
+	proxy := envString("proxy", "PROXY", "Optional: Proxy URL for requests to Github; defaults to the HTTPS_PROXY environment variable")
+	proxyAuth := envString("proxy-auth", "PROXY_AUTH", "Optional: Proxy credentials in the form username:password")
+	failIfAlreadySuccess := flag.Bool("fail-if-already-success", false, "Optional: Exit with an error, without running the command, if the context is already success on the SHA")
+	failIfPending := flag.Bool("fail-if-pending", false, "Optional: Exit with an error, without running the command, if the context is already pending on the SHA because another build is running")
+	pendingMaxAge := flag.Duration("pending-max-age", 0, "Optional: With -fail-if-pending, ignore pending statuses older than this, e.g. 2h, left over from builds that died")
+	contentHash := envString("content-hash", "CONTENT_HASH", "Optional: Hash of the content being built, e.g. sha256:..., recorded as (content <12 digits>) at the end of the description")
+	skipIfUnchanged := flag.Bool("skip-if-unchanged", false, "Optional: Don't run the command, and exit 0, if the context is already success for the same -content-hash")
+	labelOnFailure := envString("label-on-failure", "LABEL_ON_FAILURE", "Optional: Comma separated labels added to the commit's pull requests when the command fails")
+	labelOnSuccess := envString("label-on-success", "LABEL_ON_SUCCESS", "Optional: Comma separated labels added to the commit's pull requests when the command succeeds")
+	unlabelOnSuccess := envString("unlabel-on-success", "UNLABEL_ON_SUCCESS", "Optional: Comma separated labels removed from the commit's pull requests when the command succeeds")
+	createLabels := flag.Bool("create-labels", false, "Optional: Create missing labels for -label-on-failure and -label-on-success")
+	echoMaxLines := flag.Int("echo-max-lines", 0, "Optional: Only echo the first and last N lines of each of the command's output streams; everything is still captured")
+	timestampDescription := flag.Bool("timestamp-description", false, "Optional: Append the local time the command finished to the final status description")
+	reporterID := envString("reporter-id", "REPORTER_ID", "Optional: Name of this tool or instance, appended to each description in brackets and included in the -webhook-url and plugin events")
+	truncateWordBoundary := flag.Bool("truncate-word-boundary", false, "Optional: Cut descriptions that are too long after the last whole word instead of mid-word")
+	var descriptionNewlines newlineMode
+	flag.Var(&descriptionNewlines, "description-newlines", "Optional: What to do with newlines in the description: space, the default, replaces each with a space, strip removes them and keep posts them as is")
+	issueOnFailure := flag.Bool("issue-on-failure", false, "Optional: Open a tracking issue for the context when it fails, or comment on the existing one")
+	issueLabel := flag.String("issue-label", defaultIssueLabel, "Optional: Label marking tracking issues opened by -issue-on-failure")
+	issueTemplate := envString("issue-template", "ISSUE_TEMPLATE", "Optional: Go text/template file for the body of new tracking issues")
+	progressRegex := envString("progress-regex", "PROGRESS_REGEX", "Optional: Regular expression matched against each output line; the first group, or the whole match, is added to the pending description")
+	countRegex := envString("count-regex", "COUNT_REGEX", "Optional: Regular expression with named groups, e.g. (?P<passed>--- PASS)|(?P<failed>--- FAIL); matching output lines are counted per group and the counts are added to the descriptions")
+	liveOutput := flag.Bool("live-output", false, "Optional: While the command runs, keep the -pr-comment updated with the last lines of its output")
+	liveOutputInterval := flag.Duration("live-output-interval", defaultProgressInterval, "Optional: Minimum time between -live-output updates")
+	progressInterval := flag.Duration("progress-interval", defaultProgressInterval, "Optional: Minimum time between -progress-regex status updates")
+	githubOutput := flag.String("github-output", os.Getenv("GITHUB_OUTPUT"), "Optional: GitHub Actions output file the final state, status_url and description are appended to; defaults to $GITHUB_OUTPUT")
+	stepSummary := flag.String("step-summary", os.Getenv("GITHUB_STEP_SUMMARY"), "Optional: GitHub Actions step summary file a Markdown table of the final status is appended to; defaults to $GITHUB_STEP_SUMMARY")
+	var summaryOn summaryMode
+	flag.Var(&summaryOn, "summary-on", "Optional: Which final states get the -step-summary table: always, the default, failure or success; the others get a one line title")
+	promTextfile := envString("prom-textfile", "PROM_TEXTFILE", "Optional: Write Prometheus metrics of the run to this file for the node_exporter textfile collector")
+	gzipRequest := flag.Bool("gzip-request", false, "Optional: Gzip large request bodies sent to Github, such as issue and pull request comments")
+	record := envString("record", "RECORD", "Optional: Save every Github API request and response, without credentials, to this fixture file")
+	replay := envString("replay", "REPLAY", "Optional: Answer Github API requests from a -record fixture file instead of the network")
+	graphql := flag.Bool("graphql", false, "Optional: Read statuses with one Github GraphQL query instead of REST requests; posting stays on REST")
+	connectTimeout := flag.Duration("connect-timeout", 0, "Optional: How long connecting to Github may take; defaults to 30s")
+	dialDualStack := flag.Bool("dial-dual-stack", true, "Optional: Race IPv4 against a slow IPv6 connect (happy eyeballs); -dial-dual-stack=false tries the addresses in order")
+	fallbackDelay := flag.Duration("fallback-delay", 0, "Optional: How long an IPv6 connect gets before IPv4 is tried too; defaults to 300ms")
+	preferIPv4 := flag.Bool("prefer-ipv4", false, "Optional: Only connect over IPv4, for networks with broken IPv6")
+	responseHeaderTimeout := flag.Duration("response-header-timeout", 0, "Optional: How long to wait for Github to start responding once a request is sent")
+	httpTimeout := flag.Duration("http-timeout", 0, "Optional: Upper bound for each Github API request as a whole, including retries")
+	httpAttemptTimeout := flag.Duration("http-attempt-timeout", 0, "Optional: Upper bound for each attempt of a Github API request, including reading the response; an attempt that runs out is retried with -retries within -http-timeout")
+	shutdownTimeout := flag.Duration("shutdown-timeout", defaultShutdownTimeout, "Optional: On SIGINT or SIGTERM, stop the command and allow this long to post the final status; 0 exits at once without posting")
+	contextMap := envString("context-map", "CONTEXT_MAP", "Optional: Rename the context per provider, a repository or plugins, as provider:from=to pairs or JSON {\"provider\": {\"from\": \"to\"}}")
+	dedupeWindow := flag.Duration("dedupe-window", 0, "Optional: Skip posting a status identical to one this process posted within this duration")
+	retries := flag.Int("retries", 0, "Optional: Retry Github API requests that fail with a retryable status up to this many times")
+	contextDescriptions := envString("context-descriptions", "CONTEXT_DESCRIPTIONS", "Optional: With the pipeline subcommand, the description of each stage's context as context=description pairs or JSON {\"context\": \"description\"}; other contexts use -d")
+	var order postOrder
+	flag.Var(&order, "post-order", "Optional: With the pipeline subcommand, post the stages' statuses in the pipeline file's order, input, the default, or by name with alpha")
+	var transport transportMode
+	flag.Var(&transport, "transport", "Optional: How to reach the Github API: http, the default, or gh to send every request with gh api and the host and login gh is set up with instead of -a")
+	backoffCap := flag.Duration("backoff-cap", defaultBackoffCap, "Optional: Longest wait between two -retries, however many retries came before; 0 doesn't cap the doubling")
+	var jitter jitterMode
+	flag.Var(&jitter, "jitter", "Optional: How -retries waits are randomized: full, the default, waits up to the backoff, equal at least half of it, none exactly the backoff")
+	sharedRetries := flag.Bool("retry-budget-shared-across-contexts", false, "Optional: Make -retries a single budget of retries for every request of the run, across contexts and repositories, instead of one per request")
+	otel := flag.Bool("otel", false, "Optional: Export OpenTelemetry spans of the run, its API calls and the command over OTLP to $OTEL_EXPORTER_OTLP_ENDPOINT")
+	timings := flag.Bool("timings", false, "Optional: Print where the run spent its time at exit: the pending post, the command, the final post and waits between API retries")
+	retryOnStatus := envString("retry-on-status", "RETRY_ON_STATUS", "Optional: Comma separated response status codes that -retries retries, e.g. 500,502,503,429; defaults to any 5xx")
+	retryOnNetworkErrors := flag.Bool("retry-on-network-errors", true, "Optional: With -retries, also retry requests that fail with a connection reset, an EOF or a timeout; false only retries -retry-on-status responses")
+	responseBodyLimit := flag.Int("response-body-limit", defaultResponseBodyLimit, "Optional: Read at most this many bytes of each Github API response, so a proxy's huge error page can't bloat memory and logs; 0 is unlimited")
+	maxBodyLogBytes := flag.Int("max-body-log-bytes", defaultMaxBodyLogBytes, "Optional: Show at most this many bytes of a failed status post's response body in the error; 0 shows all of it")
+	maxAPICalls := flag.Int("max-api-calls", 0, "Optional: Refuse to send more than this many Github API requests, retries included; 0 is unlimited")
+	maxAPICallsSoft := flag.Bool("max-api-calls-soft", false, "Optional: Only warn about statuses -max-api-calls kept from being posted instead of failing the run")
+	cacheDir := envString("cache-dir", "CACHE_DIR", "Optional: Directory where ETags of Github API reads are kept, so unchanged responses don't count against the rate limit")
+	badgeFile := envString("badge-file", "BADGE_FILE", "Optional: Write an SVG badge of the final state to this file; with the badge subcommand, where to write the badge instead of stdout")
+	prComment := flag.Bool("pr-comment", false, "Optional: Post a summary comment on the commit's pull requests, editing the previous one for the context")
+	prCommentTemplate := envString("pr-comment-template", "PR_COMMENT_TEMPLATE", "Optional: Go text/template file for the -pr-comment body")
+	payloadURL := envString("payload-url", "PAYLOAD_URL", "Optional: Also POST each status as JSON to this URL, for status systems other than Github")
+	payloadTemplate := envString("payload-template", "PAYLOAD_TEMPLATE", "Optional: Go text/template file rendering the JSON body posted to -payload-url")
+	webhookURL := envString("webhook-url", "WEBHOOK_URL", "Optional: Also POST each status transition as a JSON event with the sha, context, state, description, target_url and timestamp to this URL")
+	var webhookHeaders stringSlice
+	flag.Var(&webhookHeaders, "webhook-header", "Optional: Header sent with each -webhook-url post, as \"Name: value\"; repeatable")
+	webhookStrict := flag.Bool("webhook-strict", false, "Optional: Fail the run if any -webhook-url post failed")
+	prNumber := flag.Int("pr-number", 0, "Optional: Post the -pr-comment on this pull request instead of the open ones containing the commit")
+	allPRCommits := flag.Bool("all-pr-commits", false, "Optional: Also post the final status to every commit of the -pr-number pull request, for branch protection that checks each commit")
+	includeFirstParent := flag.Bool("include-first-parent", false, "Optional: When the SHA is a merge commit, also post every status to its first parent")
+	closeOnSuccess := flag.Bool("close-on-success", false, "Optional: Close the -issue-on-failure tracking issue when the context passes again")
+	logUploadURL := envString("log-upload-url", "LOG_UPLOAD_URL", "Optional: After the command, upload its output to this URL and link the final status to the URL the endpoint answers with; may use {{.SHA}}, {{.Context}} and the other template fields")
+	logUploadMethod := flag.String("log-upload-method", defaultLogUploadMethod, "Optional: HTTP method -log-upload-url is sent with")
+	var logUploadHeaders stringSlice
+	flag.Var(&logUploadHeaders, "log-upload-header", "Optional: Header sent with the -log-upload-url upload, as \"Name: value\"; repeatable")
+	logUploadURLField := flag.String("log-upload-url-field", "", "Optional: Dotted JSON field of the -log-upload-url response holding the log's URL, e.g. data.url; without it the Location header is used")
+	var artifacts stringSlice
+	flag.Var(&artifacts, "artifact", "Optional: Link to something the build produced, as Name=URL, listed in the -pr-comment and -json-report; repeatable")
+	artifactsFile := envString("artifacts-file", "ARTIFACTS_FILE", "Optional: File the command writes more -artifact links to, as a JSON list or lines of a name and a URL")
+	outputFile := envString("output-file", "OUTPUT_FILE", "Optional: Also write the command's complete combined output to this file")
+	eventsFile := envString("events-file", "EVENTS_FILE", "Optional: Write the run's timeline to this file as JSON lines: resolved_sha, pending_posted, command_started, command_exited and terminal_posted")
+	outputFileMode := outputFileTruncate
+	flag.Var(&outputFileMode, "output-file-mode", "Optional: What to do with an existing -output-file: truncate, append or rotate, which keeps the last 5 as file.1 to file.5")
+	var stdin stdinMode
+	flag.Var(&stdin, "stdin", "Optional: The command's stdin: inherit, null or close; defaults to inherit on a terminal and null otherwise")
+	stdinFile := flag.String("stdin-file", "", "Optional: Feed this file to the command as its stdin")
+	stdinString := flag.String("stdin-string", "", "Optional: Feed this string to the command as its stdin")
+	noStdin := flag.Bool("no-stdin", false, "Optional: Give the command a closed stdin, like -stdin close")
+	nice := flag.Int("nice", 0, "Optional: Run the command with this niceness, from -20 to 19")
+	ionice := envString("ionice", "IONICE", "Optional: Linux only; run the command with this I/O scheduling class and level, e.g. idle or best-effort/7")
+	oomScoreAdj := flag.Int("oom-score-adj", 0, "Optional: Linux only; oom_score_adj of the command, up to 1000 to make it the preferred OOM victim")
+	image := envString("image", "IMAGE", "Optional: Run the command in this container image, with the working directory mounted")
+	containerRuntime := flag.String("container-runtime", "docker", "Optional: Container runtime for -image, docker or podman")
+	var volumes, dockerArgs, retryOnOutput, failOnOutput, requireOutput, annotateFormats stringSlice
+	flag.Var(&annotateFormats, "annotate-format", "Optional: Parse the command's output for annotations with these parsers: "+strings.Join(annotationFormats(), ", ")+"; comma separated or repeatable")
+	annotateFile := flag.String("annotate-file", "", "Optional: With -annotate-format, also parse this file the command writes, e.g. eslint's -o report")
+	flag.Var(&failOnOutput, "fail-on-output", "Optional: Report failure and exit 1 if a line of the command's output matches this regexp, even if it exited 0; repeatable")
+	flag.Var(&requireOutput, "require-output", "Optional: Report failure and exit 1 unless the command's output matches this regexp; repeatable")
+	flag.Var(&retryOnOutput, "retry-on-output", "Optional: With -command-retries, only retry a failed command whose output matches this regexp; repeatable")
+	flag.Var(&volumes, "volume", "Optional: Extra -v volume for the -image container, e.g. /cache:/cache; repeatable")
+	flag.Var(&dockerArgs, "docker-arg", "Optional: Extra argument for the container runtime's run command, e.g. --network=host; repeatable")
+	var maskEnv, maskStrings stringSlice
+	flag.Var(&maskEnv, "mask-env", "Optional: Comma separated environment variable names whose values are replaced with *** in the command's output; repeatable")
+	flag.Var(&maskStrings, "mask-string", "Optional: Literal value replaced with *** in the command's output; repeatable")
+	envExpand := flag.Bool("env-expand", false, "Optional: Expand $VAR references in unquoted and double-quoted env file values")
+	envIsolate := flag.Bool("env-isolate", false, "Optional: Start the command with only PATH, HOME, TMPDIR, LANG and the -env-allow variables instead of the whole environment")
+	envAllowlist := flag.String("env-allowlist", "", "Optional: Comma separated globs, e.g. PATH,HOME,CI_*,*_VERSION; the command only gets the variables they match, plus -env-file and -env")
+	injectStatusEnv := flag.Bool("inject-status-env", false, "Optional: Set STATUS_CONTEXT, STATUS_SHA, STATUS_ORG_REPO and STATUS_TARGET_URL for the command to the status it is reported under")
+	var envAllow stringSlice
+	flag.Var(&envAllow, "env-allow", "Optional: With -env-isolate, pass this variable, or every variable starting with PREFIX_ for PREFIX_*, to the command; repeatable")
+	envPrefix := flag.String("env-prefix", envPrefixDefault(os.Getenv), "Optional: Prefix of the environment variables that set flags, like BUILD_ORG_REPO for -r; GHSR_ORG_REPO and the like are always read too. Defaults to $GH_STATUS_REPORTER_ENV_PREFIX or BUILD_")
+	paramsEnv := flag.String("params-env", "", "Optional: Variable holding a JSON object whose org_repo, sha, context, description and target_url set -r, -s, -c, -d and -t when neither they nor their variables are set. Defaults to the -env-prefix followed by PARAMS_JSON, e.g. BUILD_PARAMS_JSON")
+	level := logInfo
+	envVar(&level, "log-level", "LOG_LEVEL", "Optional: Most detailed diagnostics to write to stderr: error, warn, info, debug or trace")
+	logFormat := flag.String("log-format", "text", "Optional: Format of the diagnostics, text or json with one object per line")
+	quiet := flag.Bool("quiet", false, "Optional: Only log errors, like -log-level error")
+	verbose := flag.Bool("v", false, "Optional: Log debug messages, like -log-level debug")
+	jsonErrors := flag.Bool("json-errors", false, "Optional: On a fatal error, print {\"error\", \"kind\", \"exit_code\"} as JSON to stderr, with kind github_api, config or command")
+	veryVerbose := flag.Bool("vv", false, "Optional: Log everything, like -log-level trace")
 
-	flag.Parse()
+	flag.Usage = usage
+
+	// The subcommands take the same flags but run no command.
+	arguments := os.Args[1:]
+	subcommand := ""
+	if len(arguments) > 0 && isSubcommand(arguments[0]) {
+		subcommand, arguments = arguments[0], arguments[1:]
+	}
+	flag.CommandLine.Parse(arguments)
+	envOrigins, err := applyEnvSettings(flag.CommandLine, *envPrefix, os.Getenv)
+	exitIfError(err)
+	if *paramsEnv == "" {
+		*paramsEnv = *envPrefix + "PARAMS_JSON"
+	}
+	exitIfError(applyParamsJSON(flag.CommandLine, *paramsEnv, os.Getenv, envOrigins))
+	fatalErrors.JSON = *jsonErrors
+	exitIfError(configureLogger(logger, level, *logFormat, *quiet, *verbose, *veryVerbose))
+
+	if subcommand == "completion" {
+		exitIfError(writeCompletion(flag.CommandLine, flag.Arg(0), os.Stdout))
+		exit(0)
+	}
 
 	flags := &Flags{
 		OrgRepo:     *orgRepo,
 		SHA:         *sha,
-		Dev:         *dev,
+		SHAFile:     *shaFile,
+		Dev:         dev.enabled || noReport.enabled,
 		Context:     *context,
 		Description: *description,
 		TargetUrl:   *targetUrl,
-		Username:    *username,
-		Auth:        *auth,
+		DefaultDescriptions: map[string]string{
+			"pending": *defaultDescriptionPending,
+			"success": *defaultDescriptionSuccess,
+			"failure": *defaultDescriptionFailure,
+			"error":   *defaultDescriptionError,
+		},
+		EnvPrefix:                *envPrefix,
+		TargetURLOnSuccess:       *targetURLOnSuccess,
+		TargetURLOnFailure:       *targetURLOnFailure,
+		Username:                 *username,
+		Auth:                     *auth,
+		EnvFiles:                 envFiles,
+		Env:                      envAssignments,
+		EnvExpand:                *envExpand,
+		EnvIsolate:               *envIsolate,
+		EnvAllowlist:             *envAllowlist,
+		InjectStatusEnv:          *injectStatusEnv,
+		EnvAllow:                 envAllow,
+		JUnitOut:                 *junitOut,
+		MaskEnv:                  maskEnv,
+		MaskStrings:              maskStrings,
+		Repos:                    *repos,
+		Strict:                   *strict,
+		Timestamps:               timestamps,
+		OutputPrefix:             *outputPrefix,
+		TimeoutStatus:            timeoutState,
+		MissingCommandStatus:     missingCommand,
+		RunTimeout:               *runTimeout,
+		CmdTimeout:               *cmdTimeout,
+		TimeoutGrace:             *timeoutGrace,
+		NotifyPlugins:            notifyPlugins,
+		PluginTimeout:            *pluginTimeout,
+		PluginStrict:             *pluginStrict,
+		SkipIfSame:               *skipIfSame,
+		Branch:                   *branch,
+		OnlyBranches:             *onlyBranches,
+		OnlyOnCI:                 *onlyOnCI,
+		SkipBranches:             *skipBranches,
+		APIURL:                   *apiURL,
+		Proxy:                    *proxy,
+		ProxyAuth:                *proxyAuth,
+		JSONReport:               *jsonReport,
+		RequirePR:                requirePR,
+		FailIfAlreadySuccess:     *failIfAlreadySuccess,
+		FailIfPending:            *failIfPending,
+		PendingMaxAge:            *pendingMaxAge,
+		ContentHash:              *contentHash,
+		SkipIfUnchanged:          *skipIfUnchanged,
+		RequireSignedCommit:      *requireSignedCommit,
+		RequireSignedCommitError: *requireSignedCommitError,
+		LabelOnFailure:           *labelOnFailure,
+		LabelOnSuccess:           *labelOnSuccess,
+		UnlabelOnSuccess:         *unlabelOnSuccess,
+		CreateLabels:             *createLabels,
+		TimestampDescription:     *timestampDescription,
+		ReporterID:               *reporterID,
+		TruncateWordBoundary:     *truncateWordBoundary,
+		DescriptionNewlines:      descriptionNewlines,
+		IssueOnFailure:           *issueOnFailure,
+		IssueLabel:               *issueLabel,
+		IssueTemplate:            *issueTemplate,
+		CloseOnSuccess:           *closeOnSuccess,
+		ProgressRegex:            *progressRegex,
+		ProgressInterval:         *progressInterval,
+		CountRegex:               *countRegex,
+		LiveOutput:               *liveOutput,
+		LiveOutputInterval:       *liveOutputInterval,
+		PRComment:                *prComment,
+		AllowEmptyContext:        *allowEmptyContext,
+		SanitizeContext:          *sanitizeContext,
+		PRCommentTemplate:        *prCommentTemplate,
+		PayloadURL:               *payloadURL,
+		PayloadTemplate:          *payloadTemplate,
+		WebhookURL:               *webhookURL,
+		WebhookHeaders:           webhookHeaders,
+		WebhookStrict:            *webhookStrict,
+		PRNumber:                 *prNumber,
+		AllPRCommits:             *allPRCommits,
+		IncludeFirstParent:       *includeFirstParent,
+		BadgeFile:                *badgeFile,
+		CacheDir:                 *cacheDir,
+		PromTextfile:             *promTextfile,
+		EchoMaxLines:             *echoMaxLines,
+		MaxDuration:              *maxDuration,
+		MaxDurationWarn:          *maxDurationWarn,
+		VerifyResponse:           *verifyResponse,
+		CheckScopes:              *checkScopes,
+		CommandRetries:           *commandRetries,
+		RetryOnOutput:            retryOnOutput,
+		RetryCommandOn:           *retryCommandOn,
+		FailOnOutput:             failOnOutput,
+		RequireOutput:            requireOutput,
+		AnnotateFormats:          annotateFormats,
+		AnnotateFile:             *annotateFile,
+		FailOnFlaky:              *failOnFlaky,
+		FailOnStderr:             *failOnStderr,
+		FailOnStderrExit:         *failOnStderrExit,
+		BudgetTotal:              *budgetTotal,
+		GzipRequest:              *gzipRequest,
+		Record:                   *record,
+		Replay:                   *replay,
+		PreferHeadSHA:            *preferHeadSHA,
+		UseRunAttemptURL:         *useRunAttemptURL,
+		GraphQL:                  *graphql,
+		Retries:                  *retries,
+		SharedRetries:            *sharedRetries,
+		BackoffCap:               *backoffCap,
+		Jitter:                   jitter,
+		OTel:                     *otel,
+		Timings:                  *timings,
+		Transport:                transport,
+		RollupContext:            *rollupContext,
+		PostOrder:                order,
+		AbortOnFailure:           *abortOnFailure,
+		ContextDescriptions:      *contextDescriptions,
+		RetryOnStatus:            *retryOnStatus,
+		MaxAPICalls:              *maxAPICalls,
+		ResponseBodyLimit:        *responseBodyLimit,
+		MaxBodyLogBytes:          *maxBodyLogBytes,
+		MaxAPICallsSoft:          *maxAPICallsSoft,
+		ConnectTimeout:           *connectTimeout,
+		NoDualStack:              !*dialDualStack,
+		NoRetryOnNetworkErrors:   !*retryOnNetworkErrors,
+		FallbackDelay:            *fallbackDelay,
+		PreferIPv4:               *preferIPv4,
+		ResponseHeaderTimeout:    *responseHeaderTimeout,
+		HTTPTimeout:              *httpTimeout,
+		HTTPAttemptTimeout:       *httpAttemptTimeout,
+		DedupeWindow:             *dedupeWindow,
+		ContextMap:               *contextMap,
+		ShutdownTimeout:          *shutdownTimeout,
+		Stdin:                    stdin,
+		StdinFile:                *stdinFile,
+		StdinString:              *stdinString,
+		NoStdin:                  *noStdin,
+		OutputFile:               *outputFile,
+		EventsFile:               *eventsFile,
+		LogUploadURL:             *logUploadURL,
+		LogUploadMethod:          *logUploadMethod,
+		LogUploadHeaders:         logUploadHeaders,
+		Artifacts:                artifacts,
+		ArtifactsFile:            *artifactsFile,
+		LogUploadURLField:        *logUploadURLField,
+		OutputFileMode:           outputFileMode,
+		GithubOutput:             *githubOutput,
+		StepSummary:              *stepSummary,
+		SummaryOn:                summaryOn,
+		Nice:                     *nice,
+		Ionice:                   *ionice,
+		OOMScoreAdj:              *oomScoreAdj,
+		Image:                    *image,
+		ContainerRuntime:         *containerRuntime,
+		Volumes:                  volumes,
+		DockerArgs:               dockerArgs,
+		DryRun:                   *dryRun,
+		DryRunExitZero:           *dryRunExitZero,
+		Range:                    *commitRange,
+		AllowTemplateShell:       *allowTemplateShell,
+		ContextSuffix:            contextSuffixFlag,
+		RunAttempt:               *runAttempt,
+		AsyncFinal:               *asyncFinal,
+		AsyncLog:                 *asyncLog,
+		Watch:                    watch,
+		WatchDebounce:            *watchDebounce,
+		VaultAddr:                *vaultAddr,
+		VaultPath:                *vaultPath,
+		VaultField:               *vaultField,
+		VaultRole:                *vaultRole,
+		VaultToken:               os.Getenv("VAULT_TOKEN"),
+		CredentialHelper:         *credentialHelper,
+		AWSSecretID:              *awsSecretID,
+		AWSSSMParameter:          *awsSSMParameter,
+		AWSSecretKey:             *awsSecretKey,
+		StateFile:                *stateFile,
+		StateFileCleanup:         *stateFileCleanup,
+		LockFile:                 *lockFile,
+		LockWait:                 *lockWait,
+		ScriptFile:               *scriptFile,
+		CmdFD:                    *cmdFD,
+		List:                     *list,
+		HealthCheck:              *healthCheck,
+		SkipCommand:              *skipCommand,
+		SHAsStdin:                *shasStdin,
+		SHAsInterval:             *shasInterval,
+		State:                    *skipState,
+		NoPrompt:                 *noPrompt,
+	}
+	truncateAtWords = flags.TruncateWordBoundary
+
+	if len(os.Args) == 1 {
+		flag.Usage()
+		exit(1)
+	}
+
+	if warning := dev.deprecationWarning(flags.envName("DEV")); warning != "" {
+		logger.Warnf("%s", warning)
+	}
+
+	exitIfInvalid(applyAPIURL(*flags))
+	// -dev reports nothing, so it needs no token and makes no API calls.
+	if !flags.Dev {
+		resolveToken(flags, subcommand)
+		exitIfInvalid(applySHAFile(flags, flagOrigins(envOrigins)))
+	}
+	exitIfInvalid(validateFlags(*flags, flag.Args(), subcommand))
+	if flags.RunTimeout > 0 {
+		runDeadline = startRunTimeout(flags.RunTimeout, exitProcess)
+	}
+	if flags.EventsFile != "" {
+		events, err = openEventLog(flags.EventsFile)
+		exitIfError(err)
+	}
+	if !flags.Dev {
+		resolveReportingFlags(flags)
+		events.record(runEvent{Event: "resolved_sha", Repository: flags.OrgRepo, SHA: flags.SHA, Context: flags.Context})
+	}
+	if flags.OTel {
+		tracer = startTracing(*flags, os.Getenv)
+	}
+	if flags.DryRun && *logFormat == "json" {
+		onExit(func() { printDryRunPlan(os.Stderr) })
+	}
+	if flags.Timings {
+		format := *logFormat
+		onExit(func() { printTimings(os.Stderr, format) })
+	}
+
+	if flags.HealthCheck {
+		exit(runHealthCheck(*flags, *logFormat, os.Stdout))
+	}
+	if flags.List {
+		exitIfError(runListCommand(*flags, *logFormat, os.Stdout))
+		exit(0)
+	}
+
+	switch subcommand {
+	case "badge":
+		exitIfError(runBadgeCommand(*flags))
+		exit(0)
+	case "doctor":
+		exit(runDoctorCommand(*flags, *doctorOutput, *writeTest, flagOrigins(envOrigins), os.Stdout))
+	case "pipeline":
+		exit(runPipelineCommand(*flags, *parallel, *keepGoing))
+	case "verify":
+		exit(runVerifyCommand(*flags, *allowStates, *combined, *doctorOutput, os.Stdout))
+	}
+
+	if flags.SkipCommand {
+		exitIfError(runSkipCommand(*flags))
+		exit(0)
+	}
+	if flags.SHAsStdin {
+		exitIfError(runSHAsStdin(*flags, *parallel, os.Stdin))
+		exit(0)
 	}
 
 	var cmd string
 	var args []string
-
-	if flag.NArg() > 0 {
-		cmd = flag.Args()[0]
-		args = flag.Args()[1:]
+	if flags.ScriptFile != "" {
+		args, err = scriptArgs(arguments, flag.Args())
+		exitIfInvalid(err)
+		cmd, args, err = scriptCommand(flags.ScriptFile, args)
+		exitIfError(err)
+	} else if flags.CmdFD != "" {
+		command, err := readCommandFD(flags.CmdFD)
+		exitIfError(err)
+		cmd, args = command[0], command[1:]
 	} else {
-		fmt.Printf("Error: no command given")
-		os.Exit(1)
+		cmd, args = flag.Args()[0], flag.Args()[1:]
 	}
 
-	if *dev != "" {
-		subprocess := exec.Command(cmd, args...)
-		subprocess.Stdin, subprocess.Stdout, subprocess.Stderr = os.Stdin, os.Stdout, os.Stderr
-		err := subprocess.Run()
-		if err == nil {
-			os.Exit(0)
-		} else {
-			os.Exit(1)
+	commandEnv, err := buildCommandEnv(filterCommandEnv(*flags, os.Environ()), flags.EnvFiles, flags.Env, flags.EnvExpand)
+	exitIfError(err)
+
+	secrets, err := collectSecrets(commandEnv, flags.MaskEnv, flags.MaskStrings)
+	exitIfError(err)
+	if flags.CredentialHelper != "" && len(flags.Auth) >= minMaskLength {
+		// The helper's token is masked in the command's output too.
+		secrets = append(secrets, flags.Auth)
+	}
+	fatalErrors.Secrets = append(fatalErrors.Secrets, secrets...)
+	options := commandOptions{
+		Secrets:      secrets,
+		Timestamps:   flags.Timestamps,
+		OutputPrefix: flags.OutputPrefix,
+		EchoMaxLines: flags.EchoMaxLines,
+		Retries:      flags.CommandRetries,
+		Timeout:      flags.CmdTimeout,
+		TimeoutGrace: flags.TimeoutGrace,
+	}
+
+	options.RetryOnOutput, err = compilePatterns("-retry-on-output", flags.RetryOnOutput)
+	exitIfError(err)
+	options.RetryOnExitCodes, err = parseRetryExitCodes(flags.RetryCommandOn)
+	exitIfError(err)
+
+	if flags.LogUploadURL != "" && flags.OutputFile == "" && !flags.Dev {
+		flags.OutputFile, err = captureLogFile()
+		exitIfError(err)
+	}
+	if flags.OutputFile != "" {
+		options.OutputFile, err = openOutputFile(flags.OutputFile, flags.OutputFileMode)
+		exitIfError(err)
+	}
+
+	cmd, args, err = applyPriorities(*flags, cmd, args)
+	exitIfError(err)
+	if flags.OOMScoreAdj != 0 {
+		options.AfterStart = func(process *os.Process) error {
+			return setOOMScoreAdj(process, flags.OOMScoreAdj)
 		}
-	} else {
-		err := validateRequiredFlags(*flags)
+	}
+
+	var subprocess *exec.Cmd
+	if flags.Image != "" {
+		subprocess, options.Kill, err = containerCommand(*flags, os.Environ(), commandEnv, append([]string{cmd}, args...))
 		exitIfError(err)
+		options.Container = true
+	} else {
+		subprocess = exec.Command(cmd, args...)
 	}
+	subprocess.Env = commandEnv
+	subprocess.Stdin, err = commandInput(*flags, os.Stdin)
+	exitIfError(err)
 
-	url := "https://api.github.com/repos/" + *orgRepo + "/statuses/" + *sha
+	if flags.Dev {
+		result := runCommandAttempts(subprocess, options)
+		timeCommand(result)
+		writeReports(*flags, result, newRunReport(*flags, nil))
+		exitIfCommandFailed(*flags, result, commandExitCode(result.Err))
+		exit(0)
+	}
 
-	subprocess := exec.Command(cmd, args...)
-	subprocess.Stdin, subprocess.Stdout, subprocess.Stderr = os.Stdin, os.Stdout, os.Stderr
+	targets, err := statusTargets(*flags)
+	exitIfError(err)
+	if flags.IncludeFirstParent {
+		targets, err = firstParentTargets(targets, *flags)
+		exitIfError(err)
+	}
+	deadlineReport := runDeadline.report(targets, *flags)
+	report := newRunReport(*flags, targets)
+	if flags.InjectStatusEnv {
+		subprocess.Env = append(subprocess.Env, statusEnv(targets[0], *flags)...)
+	}
 
-	err := setGithubCommitStatus(url, *flags, "pending")
+	reason, err := checkOnlyOnCI(*flags, os.Getenv)
 	exitIfError(err)
+	if reason != "" {
+		runUnreported(subprocess, options, *flags, report, reason)
+	}
+
+	if flags.CheckScopes {
+		exitIfError(checkTokenScopes(*flags))
+	}
 
-	err = subprocess.Run()
+	if flags.OnlyBranches != "" || flags.SkipBranches != "" {
+		if ok, reason := shouldReportBranch(*flags, resolveBranch(*flags)); !ok {
+			runUnreported(subprocess, options, *flags, report, reason)
+		}
+	}
 
-	if err == nil {
-		err = setGithubCommitStatus(url, *flags, "success")
+	if flags.RequirePR != requirePROff {
+		pulls, err := openPullRequests(targets[0], *flags)
 		exitIfError(err)
-		os.Exit(0)
+		if len(pulls) == 0 {
+			reason := fmt.Sprintf("no open pull request contains %s", targets[0].SHA)
+			if flags.RequirePR == requirePRFail {
+				exitIfError(fmt.Errorf("Error: %s", reason))
+			}
+			runUnreported(subprocess, options, *flags, report, reason)
+		}
+		report.PullRequests = pulls
+		subprocess.Env = append(subprocess.Env, pullRequestEnv(pulls)...)
 	}
 
-	if err.Error() != "0" {
-		err = setGithubCommitStatus(url, *flags, "failure")
+	if flags.LockFile != "" {
+		exitIfError(acquireLock(flags.LockFile, flags.LockWait))
+	}
+
+	unsigned := ""
+	if flags.RequireSignedCommit {
+		unsigned, err = verifyCommitSignature(targets[0], *flags)
 		exitIfError(err)
-		os.Exit(1)
+		if unsigned != "" && flags.RequireSignedCommitError {
+			exitIfError(fmt.Errorf("Error: %s", unsigned))
+		}
 	}
 
-	if err != nil {
-		err = setGithubCommitStatus(url, *flags, "error")
+	if flags.FailIfAlreadySuccess {
+		exitIfError(checkNotAlreadySucceeded(targets, *flags))
+	}
+	if flags.FailIfPending {
+		exitIfError(checkNoBuildInFlight(targets, *flags))
+	}
+	if flags.SkipIfUnchanged {
+		unchanged, err := contentUnchanged(targets, *flags)
 		exitIfError(err)
-		fmt.Printf("Error: executing command %s with args %q: %s\n", cmd, args, err)
-		os.Exit(1)
+		if unchanged {
+			logger.Infof("%s already passed for content %s, not running the command", flags.Context, shortContentHash(flags.ContentHash))
+			exit(0)
+		}
+	}
+
+	var prCommits []string
+	if flags.AllPRCommits {
+		prCommits, err = listPullRequestCommits(targets[0].OrgRepo, flags.PRNumber, *flags)
+		exitIfError(err)
+		logger.Infof("Posting the final status to the %d commits of pull request #%d", len(prCommits), flags.PRNumber)
+	}
+
+	if flags.ProgressRegex != "" || flags.CountRegex != "" {
+		pattern, err := compileProgressRegex(*flags)
+		exitIfError(err)
+		counter, err := compileCountRegex(flags.CountRegex)
+		exitIfError(err)
+		options.Progress = newProgressReporter(pattern, flags.ProgressInterval, func(progress string) {
+			postProgress(targets, *flags, progress)
+		})
+		options.Progress.counter = counter
+	}
+
+	if flags.LiveOutput {
+		commenter := &liveCommenter{target: targets[0], flags: *flags, pulls: report.PullRequests}
+		context := flags.Context
+		options.Live = newLiveOutput(flags.LiveOutputInterval, func(tail string, elapsed time.Duration) string {
+			return renderLiveOutput(context, tail, elapsed)
+		}, commenter.post)
+	}
+
+	plugins := &pluginNotifier{Plugins: flags.NotifyPlugins, Timeout: flags.PluginTimeout}
+	statusReporter := &reporter{flags: *flags, targets: targets, plugins: plugins, deadline: deadlineReport}
+
+	if len(flags.Watch) > 0 {
+		interrupts := make(chan os.Signal, 1)
+		signal.Notify(interrupts, os.Interrupt)
+		code := runWatch(subprocess, options, statusReporter, &fileWatcher{Patterns: flags.Watch, Debounce: flags.WatchDebounce}, interrupts)
+		cleanupStateFile(*flags)
+		exit(code)
+	}
+
+	var shutdown *shutdownHandler
+	if flags.ShutdownTimeout > 0 {
+		stopCleanupOnSignal()
+		signals := make(chan os.Signal, 2)
+		signal.Notify(signals, cleanupSignals...)
+		shutdown = handleShutdown(flags.ShutdownTimeout, signals)
+		options.Interrupt = shutdown.Interrupt
+	}
+	if runDeadline != nil {
+		options.Interrupt = anyInterrupt(options.Interrupt, runDeadline.Interrupt)
+	}
+
+	err = statusReporter.report("pending", nil)
+	exitIfError(err)
+
+	if options.Progress != nil {
+		options.Progress.Start()
+	}
+	if options.Live != nil {
+		options.Live.Start()
+	}
+	span := tracer.start("command", otlpKindInternal)
+	if span != nil {
+		subprocess.Env = setEnv(subprocess.Env, "TRACEPARENT", span.traceparent())
+	}
+	events.record(startedEvent(subprocess.Args, secrets))
+	result := runCommandAttempts(subprocess, options)
+	timeCommand(result)
+	events.record(exitedEvent(result))
+	span.set("process.executable.name", cmd)
+	span.set("process.exit.code", result.ExitCode)
+	if result.Err != nil {
+		span.fail(result.Err.Error())
+	}
+	span.end()
+	if options.Progress != nil {
+		options.Progress.Stop()
+	}
+	if options.Live != nil {
+		options.Live.Stop()
+	}
+	if result.TimedOut {
+		logger.Errorf("%s", result.Err)
+	}
+
+	// A command that exited 0 can still fail the run for being flaky, slow
+	// or writing to stderr.
+	flags.Description = applyTimeoutStatus(*flags, result)
+	flags.Description = applyMissingCommand(*flags, result)
+	flags.Description = applyCounts(*flags, options.Progress)
+	flags.Description = applyFlakiness(*flags, result)
+	flags.Description = applyRetryReasons(*flags, result)
+	flags.Description = applyDurationBudget(*flags, result)
+	flags.Description = applyStderrCheck(*flags, result)
+	flags.Description = applySignedCommit(*flags, result, unsigned)
+	flags.Description = applyOutputChecks(*flags, result)
+	flags.Description = shutdown.apply(*flags, result)
+	statusReporter.flags.Description = flags.Description
+	if result.Err != nil && result.ExitCode == 0 {
+		logger.Errorf("%s", result.Err)
+	}
+
+	if flags.LogUploadURL != "" {
+		applyLogUpload(&statusReporter.flags, flags.OutputFile)
+	}
+	state := commandState(result)
+	tracer.setRun("github.state", state)
+	if len(flags.Artifacts) > 0 || flags.ArtifactsFile != "" {
+		flags.ArtifactLinks = collectArtifacts(*flags)
+		statusReporter.flags.ArtifactLinks = flags.ArtifactLinks
+		report.Artifacts = flags.ArtifactLinks
+		applyArtifactTargetURL(&statusReporter.flags, state)
+	}
+	report.Annotations = reportAnnotations(*flags, result, secrets)
+	if flags.AllPRCommits {
+		statusReporter.targets = pullRequestCommitTargets(targets, prCommits)
+	}
+	err = statusReporter.report(state, result)
+	report.Labels = updateLabels(targets[0], report.PullRequests, *flags, state)
+	if flags.IssueOnFailure {
+		trackFailure(targets[0], *flags, state)
+	}
+	if flags.PRComment {
+		commentOnPullRequests(targets[0], report.PullRequests, *flags, state, result)
+	}
+	if flags.BadgeFile != "" {
+		if err := writeBadge(flags.BadgeFile, flags.Context, state); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	if flags.GithubOutput != "" {
+		if err := writeGithubOutputs(flags.GithubOutput, runOutputs(targets[0], *flags, state)); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	if flags.StepSummary != "" {
+		summary := stepSummaryFor(statusReporter.targets, statusReporter.flags, state, result)
+		if err := appendStepSummary(flags.StepSummary, summary); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	if flags.PromTextfile != "" {
+		metrics := renderPromMetrics(*flags, report.Repositories, state, result, statusReporter.postFailures)
+		if err := writePromTextfile(flags.PromTextfile, metrics); err != nil {
+			logger.Warnf("%s", err)
+		}
+	}
+	writeReports(*flags, result, report)
+	cleanupStateFile(*flags)
+	exitIfError(err)
+	if sig := shutdown.received(); sig != nil {
+		exit(signalExitCode(sig))
+	}
+
+	if flags.WebhookStrict && statusReporter.webhookFailures > 0 {
+		exitIfError(fmt.Errorf("Error: %d -webhook-url posts failed", statusReporter.webhookFailures))
+	}
+	if flags.PluginStrict && plugins.Failures() > 0 {
+		exitIfError(fmt.Errorf("Error: %d notify plugin invocations failed", plugins.Failures()))
+	}
+	if !result.StatusOnly {
+		exitIfCommandFailed(*flags, result, dryRunExitCode(*flags, 1))
 	}
+	exit(0)
 }
@@ -1,11 +1,13 @@
 package main
 
 import (
+	"encoding/json"
 	"fmt"
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -41,6 +43,105 @@ func TestValidateRequiredFlags(t *testing.T) {
 	}
 }
 
+func TestValidateRequiredFlagsAllowEmptyContext(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = ""
+	flags.AllowEmptyContext = true
+
+	if err := validateRequiredFlags(*flags); err != nil {
+		t.Errorf("Got error for an empty context with -allow-empty-context.\n%s", err.Error())
+	}
+
+	flags.SHA = ""
+	if err := validateRequiredFlags(*flags); err == nil {
+		t.Errorf("Should have gotten error with missing SHA")
+	}
+}
+
+func TestValidateFlagsReportsEveryProblem(t *testing.T) {
+	flags := defaultFlags()
+	flags.OrgRepo = "not-a-repo"
+	flags.SHA = "main"
+	flags.Context = ""
+	flags.TargetUrl = "ci.example.com/build/1"
+	flags.Record, flags.Replay = "a.json", "b.json"
+
+	err := validateFlags(*flags, nil, "")
+	errs, ok := err.(multiError)
+	if !ok {
+		t.Fatalf("Expected a multiError, got %v", err)
+	}
+	for _, expected := range []string{
+		"Error: no command given",
+		`Error: "not-a-repo" is not in the form organization/repository`,
+		`Error: -s (BUILD_SHA) "main" is not a commit SHA`,
+		"Error: No Github commit status context provided; set -c or BUILD_CONTEXT",
+		`Error: -t (BUILD_TARGET_URL) "ci.example.com/build/1" is not an http or https URL`,
+		"Error: -record and -replay can't be used together",
+	} {
+		if !strings.Contains(errs.Error(), expected) {
+			t.Errorf("Expected %q to be reported, got:\n%s", expected, errs)
+		}
+	}
+	if len(errs) != 6 {
+		t.Errorf("Expected 6 problems, got %d:\n%s", len(errs), errs)
+	}
+}
+
+func TestValidateFlagsDevSkipsRequiredFlags(t *testing.T) {
+	flags := &Flags{Dev: true}
+	if err := validateFlags(*flags, []string{"true"}, ""); err != nil {
+		t.Errorf("Expected -dev not to need the required flags, got %s", err)
+	}
+}
+
+func TestStatusParamsOmitEmptyContext(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = ""
+
+	body, _ := json.Marshal(statusParams(*flags, "success"))
+	expectedBody := "{\"state\":\"success\",\"target_url\":\"\",\"description\":\"unit test\"}"
+	if string(body) != expectedBody {
+		t.Errorf("Expected request body to be: %q, got %q", expectedBody, body)
+	}
+}
+
+func TestStatusTargetURLPerState(t *testing.T) {
+	flags := defaultFlags()
+	flags.TargetUrl = "https://ci.example.com/build/1"
+	flags.TargetURLOnSuccess = "https://ci.example.com/build/1/artifacts"
+	flags.TargetURLOnFailure = "https://ci.example.com/build/1/log"
+
+	for state, expected := range map[string]string{
+		"pending": flags.TargetUrl,
+		"success": flags.TargetURLOnSuccess,
+		"failure": flags.TargetURLOnFailure,
+		"error":   flags.TargetURLOnFailure,
+	} {
+		if params := statusParams(*flags, state); params.TargetUrl != expected {
+			t.Errorf("Expected %s to link to %s, got %s", state, expected, params.TargetUrl)
+		}
+	}
+
+	flags.TargetURLOnSuccess, flags.TargetURLOnFailure = "", ""
+	for _, state := range []string{"success", "failure", "error"} {
+		if params := statusParams(*flags, state); params.TargetUrl != flags.TargetUrl {
+			t.Errorf("Expected %s to fall back to -t, got %s", state, params.TargetUrl)
+		}
+	}
+}
+
+func TestValidateFlagsTargetURLOverrides(t *testing.T) {
+	flags := defaultFlags()
+	flags.TargetURLOnSuccess = "artifacts/1"
+	flags.TargetURLOnFailure = "https://ci.example.com/log/{{.SHA}}"
+
+	err := validateFlags(*flags, []string{"true"}, "")
+	if err == nil || err.Error() != `Error: -target-url-on-success (BUILD_TARGET_URL_ON_SUCCESS) "artifacts/1" is not an http or https URL` {
+		t.Errorf("Expected only the relative success URL to be rejected, got %v", err)
+	}
+}
+
 func TestSetGithubCommitStatusHappyPath(t *testing.T) {
 	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		expectedMethod := "POST"
@@ -64,7 +165,7 @@ func TestSetGithubCommitStatusHappyPath(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	setGithubCommitStatus(ts.URL, *defaultFlags(), "pending")
+	setGithubCommitStatus("POST", ts.URL, *defaultFlags(), "pending")
 }
 
 func TestSetGithubCommitStatusCommitStatus(t *testing.T) {
@@ -74,9 +175,47 @@ func TestSetGithubCommitStatusCommitStatus(t *testing.T) {
 	}))
 	defer ts.Close()
 
-	err := setGithubCommitStatus(ts.URL, *defaultFlags(), "pending")
+	err := setGithubCommitStatus("POST", ts.URL, *defaultFlags(), "pending")
 	expectedError := "Error creating commit status on Github.\n404 - Not Found\n"
 	if err.Error() != expectedError {
 		t.Errorf("Expected to get an error: %q, got %q", expectedError, err.Error())
 	}
 }
+
+func TestSetGithubCommitStatusMethods(t *testing.T) {
+	var method string
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		method = r.Method
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprintln(w, "{}")
+	}))
+	defer ts.Close()
+
+	for _, test := range []string{"POST", "PATCH"} {
+		if err := setGithubCommitStatus(test, ts.URL, *defaultFlags(), "success"); err != nil {
+			t.Errorf("%s: got unexpected error: %s", test, err)
+		}
+		if method != test {
+			t.Errorf("Expected a %s request, got %s", test, method)
+		}
+	}
+}
+
+func TestSetGithubCommitStatusMethodResponses(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		// Answer as if the method were the other one.
+		if r.Method == "PATCH" {
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer ts.Close()
+
+	if err := setGithubCommitStatus("POST", ts.URL, *defaultFlags(), "success"); err == nil || !strings.HasPrefix(err.Error(), "Error creating commit status") {
+		t.Errorf("Expected a POST answered with 200 to fail, got %v", err)
+	}
+	if err := setGithubCommitStatus("PATCH", ts.URL, *defaultFlags(), "success"); err == nil || !strings.HasPrefix(err.Error(), "Error updating commit status") {
+		t.Errorf("Expected a PATCH answered with 201 to fail, got %v", err)
+	}
+}
@@ -0,0 +1,141 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+// minMaskLength is the shortest secret that may be masked. Shorter values
+// would blank out common characters all over the output.
+const minMaskLength = 4
+
+const maskReplacement = "***"
+
+// collectSecrets resolves the values to mask from -mask-env names, looked up
+// in env, and -mask-string literals. Unset variables are skipped.
+func collectSecrets(env []string, maskEnv []string, maskStrings []string) ([]string, error) {
+	var secrets []string
+
+	for _, names := range maskEnv {
+		for _, name := range strings.Split(names, ",") {
+			name = strings.TrimSpace(name)
+			if name == "" {
+				continue
+			}
+			value, _ := lookupEnv(env, name)
+			if value == "" {
+				continue
+			}
+			if len(value) < minMaskLength {
+				return nil, fmt.Errorf("Error: value of %s is shorter than %d characters and can't be masked", name, minMaskLength)
+			}
+			secrets = append(secrets, value)
+		}
+	}
+
+	for _, value := range maskStrings {
+		if len(value) < minMaskLength {
+			return nil, fmt.Errorf("Error: -mask-string values must be at least %d characters", minMaskLength)
+		}
+		secrets = append(secrets, value)
+	}
+
+	return secrets, nil
+}
+
+// maskingWriter replaces secrets in a stream before passing it on. A secret
+// may be split across writes, so a trailing partial match is held back until
+// the next write or Flush decides it.
+type maskingWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	byFirst map[byte][]string
+	pending []byte
+}
+
+func newMaskingWriter(out io.Writer, secrets []string) *maskingWriter {
+	byFirst := make(map[byte][]string)
+	for _, secret := range secrets {
+		if secret == "" {
+			continue
+		}
+		byFirst[secret[0]] = append(byFirst[secret[0]], secret)
+	}
+	// Check longer secrets first so one that contains another is masked whole.
+	for _, candidates := range byFirst {
+		sort.Slice(candidates, func(i, j int) bool { return len(candidates[i]) > len(candidates[j]) })
+	}
+	return &maskingWriter{out: out, byFirst: byFirst}
+}
+
+func (m *maskingWriter) Write(p []byte) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.byFirst) == 0 {
+		return m.out.Write(p)
+	}
+
+	output, held := m.mask(append(m.pending, p...), false)
+	m.pending = append([]byte{}, held...)
+
+	if _, err := m.out.Write(output); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// mask returns buf with secrets replaced, and the trailing bytes that may be
+// the start of a secret cut off by the end of this write, which are held back
+// unless final is set.
+func (m *maskingWriter) mask(buf []byte, final bool) ([]byte, []byte) {
+	output := make([]byte, 0, len(buf))
+	i := 0
+scan:
+	for i < len(buf) {
+		for _, secret := range m.byFirst[buf[i]] {
+			rest := buf[i:]
+			if len(rest) >= len(secret) {
+				if string(rest[:len(secret)]) == secret {
+					output = append(output, maskReplacement...)
+					i += len(secret)
+					continue scan
+				}
+			} else if !final && string(rest) == secret[:len(rest)] {
+				// Possible secret cut off by the end of this write.
+				break scan
+			}
+		}
+		output = append(output, buf[i])
+		i++
+	}
+	return output, buf[i:]
+}
+
+// maskText returns text with secrets masked, for logging.
+func maskText(text string, secrets []string) string {
+	var masked strings.Builder
+	writer := newMaskingWriter(&masked, secrets)
+	writer.Write([]byte(text))
+	writer.Flush()
+	return masked.String()
+}
+
+// Flush writes out any held back bytes. It must be called once the stream ends.
+func (m *maskingWriter) Flush() error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if len(m.pending) == 0 {
+		return nil
+	}
+	// The stream has ended, so what was held back can only hold complete
+	// secrets, such as a shorter one at the start of a longer one's prefix.
+	output, _ := m.mask(m.pending, true)
+	m.pending = nil
+	_, err := m.out.Write(output)
+	return err
+}
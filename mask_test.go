@@ -0,0 +1,91 @@
+package main
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func TestMaskingWriterSplitAcrossWrites(t *testing.T) {
+	var out bytes.Buffer
+	writer := newMaskingWriter(&out, []string{"hunter22", "s3cr3t-token"})
+
+	chunks := []string{"password=hun", "ter", "22 and s3cr3t", "-tok", "en done\nhun"}
+	for _, chunk := range chunks {
+		writer.Write([]byte(chunk))
+	}
+	writer.Flush()
+
+	expected := "password=*** and *** done\nhun"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestMaskingWriterByteAtATime(t *testing.T) {
+	var out bytes.Buffer
+	writer := newMaskingWriter(&out, []string{"abcd", "abcdef", "xyzw"})
+
+	for _, b := range []byte("abcdefg abcdx xyzwxyzw") {
+		writer.Write([]byte{b})
+	}
+	writer.Flush()
+
+	expected := "***g ***x ******"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestMaskingWriterFlushMasksHeldBackSecrets(t *testing.T) {
+	for _, test := range []struct {
+		secrets        []string
+		text, expected string
+	}{
+		{[]string{"abc", "abcdef"}, "output ends in abcde", "output ends in ***de"},
+		{[]string{"xxtoken1", "token"}, "output ends in xxtoken", "output ends in xx***"},
+	} {
+		var out bytes.Buffer
+		writer := newMaskingWriter(&out, test.secrets)
+		writer.Write([]byte(test.text))
+		writer.Flush()
+		if out.String() != test.expected {
+			t.Errorf("Expected %q with %q, got %q", test.expected, test.secrets, out.String())
+		}
+		if masked := maskText(test.text, test.secrets); masked != test.expected {
+			t.Errorf("Expected maskText to give %q with %q, got %q", test.expected, test.secrets, masked)
+		}
+	}
+}
+
+func TestMaskingWriterLargeInput(t *testing.T) {
+	var out bytes.Buffer
+	writer := newMaskingWriter(&out, []string{"aaaab"})
+
+	input := strings.Repeat("a", 1<<20)
+	writer.Write([]byte(input))
+	writer.Flush()
+
+	if out.Len() != len(input) {
+		t.Errorf("Expected %d bytes through, got %d", len(input), out.Len())
+	}
+}
+
+func TestCollectSecrets(t *testing.T) {
+	env := []string{"TOKEN=abcdef123", "SHORT=abc", "EMPTY="}
+
+	secrets, err := collectSecrets(env, []string{"TOKEN,EMPTY,UNSET"}, []string{"literal-value"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if strings.Join(secrets, " ") != "abcdef123 literal-value" {
+		t.Errorf("Unexpected secrets %q", secrets)
+	}
+
+	if _, err := collectSecrets(env, []string{"SHORT"}, nil); err == nil {
+		t.Error("Expected an error for a short env value")
+	}
+	if _, err := collectSecrets(env, nil, []string{"abc"}); err == nil {
+		t.Error("Expected an error for a short literal")
+	}
+}
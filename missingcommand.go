@@ -0,0 +1,74 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"os/exec"
+)
+
+// missingCommandStatus is the value of the -missing-command-status flag: the
+// outcome when the command isn't installed.
+type missingCommandStatus string
+
+const (
+	missingCommandError   missingCommandStatus = ""
+	missingCommandFailure missingCommandStatus = "failure"
+	missingCommandSuccess missingCommandStatus = "success"
+	missingCommandSkip    missingCommandStatus = "skip"
+)
+
+func (s *missingCommandStatus) String() string {
+	return string(*s)
+}
+
+func (s *missingCommandStatus) Set(value string) error {
+	switch value {
+	case "error":
+		*s = missingCommandError
+	case "failure", "success", "skip":
+		*s = missingCommandStatus(value)
+	default:
+		return fmt.Errorf("expected error, failure, success or skip, got %q", value)
+	}
+	return nil
+}
+
+func (s *missingCommandStatus) completionValues() []string {
+	return []string{"error", "failure", "success", "skip"}
+}
+
+// commandNotFound returns the name of the command when err is the command
+// failing to start because it isn't on PATH or doesn't exist.
+func commandNotFound(err error) (string, bool) {
+	var execErr *exec.Error
+	if errors.As(err, &execErr) && errors.Is(execErr.Err, exec.ErrNotFound) {
+		return execErr.Name, true
+	}
+	return "", false
+}
+
+// applyMissingCommand reports a command that wasn't found as an error, or as
+// -missing-command-status says, and returns the description to report. With
+// success and skip the command counts as having passed, so the reporter
+// exits 0; skip also says so in the description.
+func applyMissingCommand(flags Flags, result *commandResult) string {
+	name, ok := commandNotFound(result.Err)
+	if !ok {
+		return flags.Description
+	}
+	switch flags.MissingCommandStatus {
+	case missingCommandError:
+		result.Errored = true
+	case missingCommandSuccess, missingCommandSkip:
+		logger.Warnf("%s was not found, reporting success as -missing-command-status is %s", name, flags.MissingCommandStatus)
+		result.Err, result.ExitCode = nil, 0
+		if flags.MissingCommandStatus == missingCommandSkip {
+			note := "skipped, " + name + " is not installed"
+			if flags.Description == "" {
+				return "Skipped, " + name + " is not installed"
+			}
+			return appendSuffix(flags.Description, note)
+		}
+	}
+	return flags.Description
+}
@@ -0,0 +1,86 @@
+package main
+
+import (
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyMissingCommand(t *testing.T) {
+	for _, test := range []struct {
+		status      missingCommandStatus
+		state       string
+		description string
+	}{
+		{missingCommandError, "error", "unit test"},
+		{missingCommandFailure, "failure", "unit test"},
+		{missingCommandSuccess, "success", "unit test"},
+		{missingCommandSkip, "success", "unit test (skipped, gh-status-reporter-missing is not installed)"},
+	} {
+		result := runCommand(exec.Command("gh-status-reporter-missing"), commandOptions{Timeout: 5 * time.Second})
+		flags := defaultFlags()
+		flags.MissingCommandStatus = test.status
+		description := applyMissingCommand(*flags, result)
+		if state := commandState(result); state != test.state {
+			t.Errorf("Expected %s for -missing-command-status %q, got %s", test.state, test.status, state)
+		}
+		if description != test.description {
+			t.Errorf("Expected the description %q for -missing-command-status %q, got %q", test.description, test.status, description)
+		}
+	}
+
+	flags := defaultFlags()
+	flags.Description, flags.MissingCommandStatus = "", missingCommandSkip
+	result := runCommand(exec.Command("gh-status-reporter-missing"), commandOptions{})
+	if description := applyMissingCommand(*flags, result); description != "Skipped, gh-status-reporter-missing is not installed" {
+		t.Errorf("Unexpected skip description without -d: %q", description)
+	}
+
+	// A command that ran and failed is left alone.
+	result = runCommand(exec.Command("sh", "-c", "exit 127"), commandOptions{})
+	if applyMissingCommand(*flags, result); commandState(result) != "failure" {
+		t.Errorf("Expected a failing command to stay a failure, got %s", commandState(result))
+	}
+}
+
+func TestMissingCommandIsNotRetried(t *testing.T) {
+	result := runCommandAttempts(exec.Command("gh-status-reporter-missing"), commandOptions{Retries: 3})
+	if len(result.Attempts) != 1 {
+		t.Errorf("Expected a missing command to be tried once, got %d attempts", len(result.Attempts))
+	}
+}
+
+func TestCLIMissingCommandStatus(t *testing.T) {
+	for _, test := range []struct {
+		status   string
+		expected string
+		code     int
+	}{
+		{"", `"state":"error"`, 1},
+		{"success", `"state":"success"`, 0},
+		{"skip", `"state":"success"`, 0},
+	} {
+		args := []string{"-dry-run", "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token"}
+		if test.status != "" {
+			args = append(args, "-missing-command-status", test.status)
+		}
+		out, code := runCLI(t, append(args, "gh-status-reporter-missing")...)
+		if code != test.code || !strings.Contains(out, test.expected) {
+			t.Errorf("Expected %s and exit code %d with -missing-command-status %q, got %d:\n%s", test.expected, test.code, test.status, code, out)
+		}
+	}
+}
+
+func TestMissingCommandStatusSet(t *testing.T) {
+	var status missingCommandStatus
+	if err := status.Set("skip"); err != nil || status != missingCommandSkip {
+		t.Errorf("Expected skip to be accepted, got %q %v", status, err)
+	}
+	if err := status.Set("error"); err != nil || status != missingCommandError {
+		t.Errorf("Expected error to be the default, got %q %v", status, err)
+	}
+	if err := status.Set("ignore"); err == nil {
+		t.Errorf("Expected an unknown outcome to be rejected")
+	}
+}
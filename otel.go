@@ -0,0 +1,338 @@
+package main
+
+import (
+	"bytes"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+// runStarted is when the process started, the start of the -otel run span
+// and of the -timings total.
+var runStarted = time.Now()
+
+// OTLP span kinds and status codes.
+const (
+	otlpKindInternal = 1
+	otlpKindClient   = 3
+	otlpStatusError  = 2
+)
+
+// otelExportTimeout bounds exporting the spans at exit.
+const otelExportTimeout = 10 * time.Second
+
+// traceparentPattern matches a W3C traceparent such as the TRACEPARENT a CI
+// system sets, whose trace the run joins.
+var traceparentPattern = regexp.MustCompile(`^[0-9a-f]{2}-([0-9a-f]{32})-([0-9a-f]{16})-[0-9a-f]{2}$`)
+
+// otlpValue is an OTLP attribute value; int64s are strings in OTLP JSON.
+type otlpValue struct {
+	StringValue *string `json:"stringValue,omitempty"`
+	IntValue    *string `json:"intValue,omitempty"`
+}
+
+type otlpAttribute struct {
+	Key   string    `json:"key"`
+	Value otlpValue `json:"value"`
+}
+
+type otlpStatus struct {
+	Code    int    `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+type otlpSpan struct {
+	TraceID           string          `json:"traceId"`
+	SpanID            string          `json:"spanId"`
+	ParentSpanID      string          `json:"parentSpanId,omitempty"`
+	Name              string          `json:"name"`
+	Kind              int             `json:"kind"`
+	StartTimeUnixNano string          `json:"startTimeUnixNano"`
+	EndTimeUnixNano   string          `json:"endTimeUnixNano"`
+	Attributes        []otlpAttribute `json:"attributes,omitempty"`
+	Status            otlpStatus      `json:"status"`
+}
+
+type otlpResource struct {
+	Attributes []otlpAttribute `json:"attributes"`
+}
+
+type otlpScope struct {
+	Name string `json:"name"`
+}
+
+type otlpScopeSpans struct {
+	Scope otlpScope  `json:"scope"`
+	Spans []otlpSpan `json:"spans"`
+}
+
+type otlpResourceSpans struct {
+	Resource   otlpResource     `json:"resource"`
+	ScopeSpans []otlpScopeSpans `json:"scopeSpans"`
+}
+
+// otlpRequest is the body of an OTLP/HTTP JSON trace export.
+type otlpRequest struct {
+	ResourceSpans []otlpResourceSpans `json:"resourceSpans"`
+}
+
+// otelAttribute returns the OTLP attribute for a string or int value.
+func otelAttribute(key string, value interface{}) otlpAttribute {
+	var text string
+	attribute := otlpAttribute{Key: key}
+	switch v := value.(type) {
+	case int:
+		text = strconv.Itoa(v)
+		attribute.Value.IntValue = &text
+	default:
+		text = fmt.Sprint(v)
+		attribute.Value.StringValue = &text
+	}
+	return attribute
+}
+
+// otelID returns a random trace or span ID of size bytes as hex.
+func otelID(size int) string {
+	id := make([]byte, size)
+	rand.Read(id)
+	return hex.EncodeToString(id)
+}
+
+func unixNano(t time.Time) string {
+	return strconv.FormatInt(t.UnixNano(), 10)
+}
+
+// tracer is the -otel tracer, nil when tracing is off. Its methods and the
+// spans it starts do nothing when it is nil, so the instrumentation costs a
+// nil check when -otel isn't set.
+var tracer *otelTracer
+
+// otelTracer collects the spans of a run and exports them once, at exit.
+type otelTracer struct {
+	endpoint string
+	headers  http.Header
+	service  string
+	client   *http.Client
+	root     *otelSpan
+
+	mu       sync.Mutex
+	spans    []otlpSpan
+	exitCode *int
+	flushed  bool
+}
+
+// startTracing returns the tracer for -otel with the run span started, or
+// nil with a warning when no OTLP endpoint is configured. The spans are
+// exported when the process exits.
+func startTracing(flags Flags, getenv func(string) string) *otelTracer {
+	endpoint := getenv("OTEL_EXPORTER_OTLP_TRACES_ENDPOINT")
+	if endpoint == "" && getenv("OTEL_EXPORTER_OTLP_ENDPOINT") != "" {
+		endpoint = strings.TrimSuffix(getenv("OTEL_EXPORTER_OTLP_ENDPOINT"), "/") + "/v1/traces"
+	}
+	if endpoint == "" {
+		logger.Warnf("-otel is set but OTEL_EXPORTER_OTLP_ENDPOINT isn't, not exporting spans")
+		return nil
+	}
+	transport, err := baseTransport(flags)
+	if err != nil {
+		logger.Warnf("could not set up span exports, not exporting spans: %s", err)
+		return nil
+	}
+
+	t := &otelTracer{
+		endpoint: endpoint,
+		headers:  parseOTLPHeaders(getenv("OTEL_EXPORTER_OTLP_HEADERS")),
+		service:  getenv("OTEL_SERVICE_NAME"),
+		client:   &http.Client{Transport: transport, Timeout: otelExportTimeout},
+	}
+	if t.service == "" {
+		t.service = "gh-status-reporter"
+	}
+	traceID, parentID := otelID(16), ""
+	if match := traceparentPattern.FindStringSubmatch(getenv("TRACEPARENT")); match != nil {
+		traceID, parentID = match[1], match[2]
+	}
+	t.root = &otelSpan{tracer: t, data: otlpSpan{TraceID: traceID, SpanID: otelID(8), ParentSpanID: parentID, Name: "gh-status-reporter", Kind: otlpKindInternal}, start: runStarted}
+	for _, attribute := range []struct {
+		key, value string
+	}{{"github.repository", flags.OrgRepo}, {"github.sha", flags.SHA}, {"github.context", flags.Context}} {
+		if attribute.value != "" {
+			t.root.set(attribute.key, attribute.value)
+		}
+	}
+	onExit(t.flush)
+	return t
+}
+
+// parseOTLPHeaders parses OTEL_EXPORTER_OTLP_HEADERS: comma separated
+// key=value pairs with URL encoded values.
+func parseOTLPHeaders(value string) http.Header {
+	headers := http.Header{}
+	for _, pair := range strings.Split(value, ",") {
+		parts := strings.SplitN(pair, "=", 2)
+		if len(parts) != 2 || strings.TrimSpace(parts[0]) == "" {
+			continue
+		}
+		decoded, err := url.QueryUnescape(strings.TrimSpace(parts[1]))
+		if err != nil {
+			decoded = strings.TrimSpace(parts[1])
+		}
+		headers.Add(strings.TrimSpace(parts[0]), decoded)
+	}
+	return headers
+}
+
+// start starts a span that is a child of the run span.
+func (t *otelTracer) start(name string, kind int) *otelSpan {
+	if t == nil {
+		return nil
+	}
+	return &otelSpan{tracer: t, data: otlpSpan{TraceID: t.root.data.TraceID, SpanID: otelID(8), ParentSpanID: t.root.data.SpanID, Name: name, Kind: kind}, start: time.Now()}
+}
+
+// setRun adds an attribute to the run span, such as the final state.
+func (t *otelTracer) setRun(key string, value interface{}) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.root.set(key, value)
+}
+
+// setExitCode records the process's exit code on the run span.
+func (t *otelTracer) setExitCode(code int) {
+	if t == nil {
+		return
+	}
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.exitCode = &code
+}
+
+// flush ends the run span and exports every ended span. It only runs once;
+// failures are warnings.
+func (t *otelTracer) flush() {
+	t.mu.Lock()
+	if t.flushed {
+		t.mu.Unlock()
+		return
+	}
+	t.flushed = true
+	root := t.root
+	if t.exitCode != nil {
+		root.set("process.exit.code", *t.exitCode)
+		if *t.exitCode != 0 {
+			root.data.Status = otlpStatus{Code: otlpStatusError, Message: fmt.Sprintf("exited with %d", *t.exitCode)}
+		}
+	}
+	root.data.StartTimeUnixNano, root.data.EndTimeUnixNano = unixNano(root.start), unixNano(time.Now())
+	spans := append(t.spans, root.data)
+	t.mu.Unlock()
+
+	body := otlpRequest{ResourceSpans: []otlpResourceSpans{{
+		Resource:   otlpResource{Attributes: []otlpAttribute{otelAttribute("service.name", t.service)}},
+		ScopeSpans: []otlpScopeSpans{{Scope: otlpScope{Name: "gh-status-reporter"}, Spans: spans}},
+	}}}
+	if err := t.export(body); err != nil {
+		logger.Warnf("could not export the -otel spans: %s", err)
+		return
+	}
+	logger.Debugf("Exported %d spans to %s", len(spans), redactURL(t.endpoint))
+}
+
+func (t *otelTracer) export(body otlpRequest) error {
+	encoded, err := json.Marshal(body)
+	if err != nil {
+		return fmt.Errorf("Error converting the spans to json: %s", err)
+	}
+	req, err := http.NewRequest("POST", t.endpoint, bytes.NewReader(encoded))
+	if err != nil {
+		return fmt.Errorf("Error creating the span export request: %s", err)
+	}
+	for name, values := range t.headers {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("Error exporting spans: %s", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Error: %s responded with %d", redactURL(t.endpoint), resp.StatusCode)
+	}
+	return nil
+}
+
+// otelSpan is a span being recorded. A nil span ignores every call.
+type otelSpan struct {
+	tracer *otelTracer
+	data   otlpSpan
+	start  time.Time
+}
+
+func (s *otelSpan) set(key string, value interface{}) {
+	if s == nil {
+		return
+	}
+	s.data.Attributes = append(s.data.Attributes, otelAttribute(key, value))
+}
+
+// fail marks the span as an error.
+func (s *otelSpan) fail(message string) {
+	if s == nil {
+		return
+	}
+	s.data.Status = otlpStatus{Code: otlpStatusError, Message: message}
+}
+
+// end records the span for export.
+func (s *otelSpan) end() {
+	if s == nil {
+		return
+	}
+	s.data.StartTimeUnixNano, s.data.EndTimeUnixNano = unixNano(s.start), unixNano(time.Now())
+	s.tracer.mu.Lock()
+	defer s.tracer.mu.Unlock()
+	s.tracer.spans = append(s.tracer.spans, s.data)
+}
+
+// traceparent returns the W3C traceparent of span, for the command to join
+// the trace.
+func (s *otelSpan) traceparent() string {
+	return "00-" + s.data.TraceID + "-" + s.data.SpanID + "-01"
+}
+
+// tracingTransport records a client span for each request attempt.
+type tracingTransport struct {
+	next http.RoundTripper
+}
+
+func (t *tracingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	span := tracer.start(req.Method, otlpKindClient)
+	span.set("http.request.method", req.Method)
+	span.set("url.full", redactURL(req.URL.String()))
+	defer span.end()
+	resp, err := t.next.RoundTrip(req)
+	if err != nil {
+		span.fail(err.Error())
+		return resp, err
+	}
+	span.set("http.response.status_code", resp.StatusCode)
+	if resp.StatusCode >= 400 {
+		span.fail(strconv.Itoa(resp.StatusCode))
+	}
+	return resp, err
+}
@@ -0,0 +1,162 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// withCollector starts an OTLP collector and returns the environment that
+// points -otel at it and the exports it receives. The tracer and its exit
+// cleanup are removed when the test ends.
+func withCollector(t *testing.T) (map[string]string, *[]otlpRequest, *[]http.Header) {
+	var exports []otlpRequest
+	var headers []http.Header
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := ioutil.ReadAll(r.Body)
+		var export otlpRequest
+		if r.URL.Path != "/v1/traces" || json.Unmarshal(body, &export) != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		exports = append(exports, export)
+		headers = append(headers, r.Header)
+	}))
+	exitCleanups.Lock()
+	original := exitCleanups.funcs
+	exitCleanups.Unlock()
+	t.Cleanup(func() {
+		server.Close()
+		tracer = nil
+		exitCleanups.Lock()
+		exitCleanups.funcs = original
+		exitCleanups.Unlock()
+	})
+	return map[string]string{"OTEL_EXPORTER_OTLP_ENDPOINT": server.URL + "/"}, &exports, &headers
+}
+
+func spanAttribute(span otlpSpan, key string) string {
+	for _, attribute := range span.Attributes {
+		if attribute.Key != key {
+			continue
+		}
+		if attribute.Value.IntValue != nil {
+			return *attribute.Value.IntValue
+		}
+		return *attribute.Value.StringValue
+	}
+	return ""
+}
+
+func TestTracingExportsSpans(t *testing.T) {
+	env, exports, headers := withCollector(t)
+	env["OTEL_EXPORTER_OTLP_HEADERS"] = "x-api-key=se%20cret, x-team = ci"
+	env["OTEL_SERVICE_NAME"] = "builds"
+	flags := defaultFlags()
+	tracer = startTracing(*flags, func(name string) string { return env[name] })
+	if tracer == nil {
+		t.Fatal("Expected a tracer")
+	}
+
+	span := tracer.start("command", otlpKindInternal)
+	span.set("process.exit.code", 2)
+	span.fail("exit status 2")
+	span.end()
+	tracer.setRun("github.state", "failure")
+	tracer.setExitCode(2)
+	tracer.flush()
+
+	if len(*exports) != 1 {
+		t.Fatalf("Expected one export, got %d", len(*exports))
+	}
+	if (*headers)[0].Get("X-Api-Key") != "se cret" || (*headers)[0].Get("X-Team") != "ci" {
+		t.Errorf("Expected the OTEL_EXPORTER_OTLP_HEADERS, got %v", (*headers)[0])
+	}
+	resource := (*exports)[0].ResourceSpans[0]
+	if len(resource.Resource.Attributes) != 1 || *resource.Resource.Attributes[0].Value.StringValue != "builds" {
+		t.Errorf("Expected the service name, got %+v", resource.Resource.Attributes)
+	}
+	spans := resource.ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected the command and run spans, got %+v", spans)
+	}
+	command, run := spans[0], spans[1]
+	if run.Name != "gh-status-reporter" || run.ParentSpanID != "" || len(run.TraceID) != 32 || len(run.SpanID) != 16 {
+		t.Errorf("Unexpected run span %+v", run)
+	}
+	if command.TraceID != run.TraceID || command.ParentSpanID != run.SpanID {
+		t.Errorf("Expected the command span to be a child of the run span, got %+v", command)
+	}
+	if command.Status.Code != otlpStatusError || spanAttribute(command, "process.exit.code") != "2" {
+		t.Errorf("Unexpected command span %+v", command)
+	}
+	for key, value := range map[string]string{"github.repository": flags.OrgRepo, "github.sha": "deadbeef", "github.context": "ci", "github.state": "failure", "process.exit.code": "2"} {
+		if got := spanAttribute(run, key); got != value {
+			t.Errorf("Expected the run span's %s to be %q, got %q", key, value, got)
+		}
+	}
+	if run.Status.Code != otlpStatusError {
+		t.Errorf("Expected the run span to fail with the exit code, got %+v", run.Status)
+	}
+}
+
+func TestTracingJoinsTraceparent(t *testing.T) {
+	env, exports, _ := withCollector(t)
+	env["TRACEPARENT"] = "00-0af7651916cd43dd8448eb211c80319c-b7ad6b7169203331-01"
+	tracer = startTracing(*defaultFlags(), func(name string) string { return env[name] })
+	span := tracer.start("command", otlpKindInternal)
+	if !strings.HasPrefix(span.traceparent(), "00-0af7651916cd43dd8448eb211c80319c-") {
+		t.Errorf("Expected the command to join the trace, got %s", span.traceparent())
+	}
+	tracer.flush()
+	run := (*exports)[0].ResourceSpans[0].ScopeSpans[0].Spans[0]
+	if run.TraceID != "0af7651916cd43dd8448eb211c80319c" || run.ParentSpanID != "b7ad6b7169203331" {
+		t.Errorf("Expected the run span to join the TRACEPARENT trace, got %+v", run)
+	}
+}
+
+func TestTracingWithoutEndpoint(t *testing.T) {
+	out := withLogger(t, logWarn)
+	if started := startTracing(*defaultFlags(), func(string) string { return "" }); started != nil {
+		t.Errorf("Expected no tracer without an endpoint")
+	}
+	if !strings.Contains(out.String(), "OTEL_EXPORTER_OTLP_ENDPOINT isn't") {
+		t.Errorf("Expected a warning, got %q", out)
+	}
+
+	var off *otelTracer
+	span := off.start("command", otlpKindInternal)
+	span.set("key", "value")
+	span.fail("failed")
+	span.end()
+	off.setRun("github.state", "success")
+	off.setExitCode(1)
+}
+
+func TestTracingTransportRecordsAPICalls(t *testing.T) {
+	env, exports, _ := withCollector(t)
+	tracer = startTracing(*defaultFlags(), func(name string) string { return env[name] })
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnprocessableEntity)
+		w.Write([]byte(`{"message": "Validation Failed"}`))
+	})()
+
+	if err := setGithubCommitStatus("POST", githubAPIURL+"/repos/org/repo/statuses/deadbeef", *defaultFlags(), "success"); err == nil {
+		t.Fatal("Expected the status to fail")
+	}
+	tracer.flush()
+	spans := (*exports)[0].ResourceSpans[0].ScopeSpans[0].Spans
+	if len(spans) != 2 {
+		t.Fatalf("Expected an API call span and the run span, got %+v", spans)
+	}
+	call := spans[0]
+	if call.Name != "POST" || call.Kind != otlpKindClient || spanAttribute(call, "http.response.status_code") != "422" || call.Status.Code != otlpStatusError {
+		t.Errorf("Unexpected API call span %+v", call)
+	}
+	if !strings.Contains(spanAttribute(call, "url.full"), "/repos/org/repo/statuses/deadbeef") {
+		t.Errorf("Unexpected url.full %q", spanAttribute(call, "url.full"))
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// flusher is implemented by writers that hold back output until a later
+// write or the end of the stream.
+type flusher interface {
+	Flush() error
+}
+
+// linePrefixWriter copies its input line by line, starting each line with
+// the result of prefix. The prefix is computed when the first byte of a line
+// arrives. A trailing partial line is written out by Flush.
+type linePrefixWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	prefix  func() string
+	line    []byte
+	started bool
+}
+
+func newLinePrefixWriter(out io.Writer, prefix func() string) *linePrefixWriter {
+	return &linePrefixWriter{out: out, prefix: prefix}
+}
+
+func (l *linePrefixWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for rest := p; len(rest) > 0; {
+		if !l.started {
+			l.line = append(l.line[:0], l.prefix()...)
+			l.started = true
+		}
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			l.line = append(l.line, rest...)
+			break
+		}
+		l.line = append(l.line, rest[:i+1]...)
+		rest = rest[i+1:]
+		l.started = false
+		if _, err := l.out.Write(l.line); err != nil {
+			return 0, err
+		}
+	}
+	return len(p), nil
+}
+
+func (l *linePrefixWriter) Flush() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if !l.started {
+		return nil
+	}
+	l.started = false
+	_, err := l.out.Write(l.line)
+	return err
+}
+
+// headTailWriter passes through the first max lines written to it and keeps
+// only the last max lines after that. Flush writes a marker counting the
+// lines dropped in between, followed by the kept lines.
+type headTailWriter struct {
+	mu      sync.Mutex
+	out     io.Writer
+	max     int
+	head    int
+	tail    [][]byte
+	line    []byte
+	omitted int
+}
+
+func newHeadTailWriter(out io.Writer, max int) *headTailWriter {
+	return &headTailWriter{out: out, max: max}
+}
+
+func (h *headTailWriter) Write(p []byte) (int, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	for rest := p; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		chunk := rest
+		if i >= 0 {
+			chunk = rest[:i+1]
+		}
+		rest = rest[len(chunk):]
+
+		if h.head < h.max {
+			if _, err := h.out.Write(chunk); err != nil {
+				return 0, err
+			}
+			if i >= 0 {
+				h.head++
+			}
+			continue
+		}
+		h.line = append(h.line, chunk...)
+		if i >= 0 {
+			h.keep(h.line)
+			h.line = nil
+		}
+	}
+	return len(p), nil
+}
+
+// keep adds a complete line to the tail, dropping the oldest one if full.
+func (h *headTailWriter) keep(line []byte) {
+	if len(h.tail) == h.max {
+		h.tail = h.tail[1:]
+		h.omitted++
+	}
+	h.tail = append(h.tail, line)
+}
+
+func (h *headTailWriter) Flush() error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	if len(h.line) > 0 {
+		h.keep(h.line)
+		h.line = nil
+	}
+	if h.omitted > 0 {
+		if _, err := fmt.Fprintf(h.out, "... (%d lines omitted) ...\n", h.omitted); err != nil {
+			return err
+		}
+	}
+	for _, line := range h.tail {
+		if _, err := h.out.Write(line); err != nil {
+			return err
+		}
+	}
+	h.tail, h.omitted = nil, 0
+	return nil
+}
+
+// timestampMode is the value of the -timestamps flag. It may be given without
+// a value, which selects absolute timestamps.
+type timestampMode string
+
+const (
+	timestampsOff      timestampMode = ""
+	timestampsAbsolute timestampMode = "absolute"
+	timestampsRelative timestampMode = "relative"
+)
+
+func (m *timestampMode) String() string {
+	return string(*m)
+}
+
+func (m *timestampMode) Set(value string) error {
+	switch value {
+	case "true", "absolute":
+		*m = timestampsAbsolute
+	case "relative":
+		*m = timestampsRelative
+	case "false", "":
+		*m = timestampsOff
+	default:
+		return fmt.Errorf("expected absolute or relative, got %q", value)
+	}
+	return nil
+}
+
+func (m *timestampMode) IsBoolFlag() bool {
+	return true
+}
+
+// prefixFunc returns the line prefix for mode, measuring relative offsets from
+// start, or nil when timestamps are off.
+func (m timestampMode) prefixFunc(start time.Time) func() string {
+	switch m {
+	case timestampsAbsolute:
+		return func() string {
+			return time.Now().Format(time.RFC3339) + " "
+		}
+	case timestampsRelative:
+		return func() string {
+			return fmt.Sprintf("+%.3fs ", time.Since(start).Seconds())
+		}
+	}
+	return nil
+}
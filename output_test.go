@@ -0,0 +1,146 @@
+package main
+
+import (
+	"bytes"
+	"flag"
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLinePrefixWriter(t *testing.T) {
+	var out bytes.Buffer
+	n := 0
+	writer := newLinePrefixWriter(&out, func() string {
+		n++
+		return "[" + string(rune('0'+n)) + "] "
+	})
+
+	writer.Write([]byte("first\nsec"))
+	writer.Write([]byte("ond\n\nlast"))
+	if out.String() != "[1] first\n[2] second\n[3] \n" {
+		t.Errorf("Unexpected output before flush %q", out.String())
+	}
+
+	writer.Flush()
+	if out.String() != "[1] first\n[2] second\n[3] \n[4] last" {
+		t.Errorf("Expected the partial line to be flushed, got %q", out.String())
+	}
+}
+
+func TestTimestampModeFlag(t *testing.T) {
+	for args, expected := range map[string]timestampMode{
+		"-timestamps":          timestampsAbsolute,
+		"-timestamps=relative": timestampsRelative,
+		"-timestamps=absolute": timestampsAbsolute,
+	} {
+		var mode timestampMode
+		flags := flag.NewFlagSet("test", flag.ContinueOnError)
+		flags.Var(&mode, "timestamps", "")
+		if err := flags.Parse([]string{args}); err != nil {
+			t.Fatalf("Got unexpected error for %s: %s", args, err)
+		}
+		if mode != expected {
+			t.Errorf("Expected %s to select %q, got %q", args, expected, mode)
+		}
+	}
+
+	var mode timestampMode
+	if err := mode.Set("yesterday"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
+
+func TestRunCommandTimestamps(t *testing.T) {
+	var stdout bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "echo one; printf two")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stdout
+
+	result := runCommand(subprocess, commandOptions{Timestamps: timestampsRelative})
+
+	pattern := regexp.MustCompile(`^\+\d+\.\d{3}s one\n\+\d+\.\d{3}s two$`)
+	if !pattern.MatchString(stdout.String()) {
+		t.Errorf("Expected relative timestamps on every line, got %q", stdout.String())
+	}
+	if result.Output.String() != "one\ntwo" {
+		t.Errorf("Expected captured output without timestamps, got %q", result.Output.String())
+	}
+
+	prefix := timestampsAbsolute.prefixFunc(time.Now())()
+	if _, err := time.Parse(time.RFC3339+" ", prefix); err != nil {
+		t.Errorf("Expected an RFC3339 prefix, got %q", prefix)
+	}
+}
+
+func TestRunCommandOutputPrefix(t *testing.T) {
+	var stdout, stderr bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "echo one; echo oops >&2; printf 'two\nthree'")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stderr
+
+	result := runCommand(subprocess, commandOptions{OutputPrefix: "[build] "})
+
+	if stdout.String() != "[build] one\n[build] two\n[build] three" {
+		t.Errorf("Expected every stdout line to be prefixed, got %q", stdout.String())
+	}
+	if stderr.String() != "[build] oops\n" {
+		t.Errorf("Expected stderr lines to be prefixed, got %q", stderr.String())
+	}
+	if strings.Contains(result.Output.String(), "[build]") {
+		t.Errorf("Expected captured output without the prefix, got %q", result.Output.String())
+	}
+
+	stdout.Reset()
+	subprocess = exec.Command("sh", "-c", "echo one")
+	subprocess.Stdout = &stdout
+	runCommand(subprocess, commandOptions{OutputPrefix: "[build] ", Timestamps: timestampsRelative})
+	if !regexp.MustCompile(`^\[build\] \+\d+\.\d{3}s one\n$`).MatchString(stdout.String()) {
+		t.Errorf("Expected the prefix before the timestamp, got %q", stdout.String())
+	}
+}
+
+func TestHeadTailWriterKeepsFirstAndLastLines(t *testing.T) {
+	var out bytes.Buffer
+	limited := newHeadTailWriter(&out, 2)
+	for i := 1; i <= 7; i++ {
+		fmt.Fprintf(limited, "line %d\n", i)
+	}
+	if out.String() != "line 1\nline 2\n" {
+		t.Errorf("Expected only the head to be written live, got %q", out.String())
+	}
+
+	limited.Flush()
+	expected := "line 1\nline 2\n... (3 lines omitted) ...\nline 6\nline 7\n"
+	if out.String() != expected {
+		t.Errorf("Expected %q, got %q", expected, out.String())
+	}
+}
+
+func TestHeadTailWriterShortOutput(t *testing.T) {
+	var out bytes.Buffer
+	limited := newHeadTailWriter(&out, 2)
+	limited.Write([]byte("a\nb\nc"))
+	limited.Flush()
+	if out.String() != "a\nb\nc" {
+		t.Errorf("Expected no marker when nothing is omitted, got %q", out.String())
+	}
+}
+
+func TestRunCommandEchoMaxLines(t *testing.T) {
+	var stdout bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "for i in 1 2 3 4 5 6; do echo $i; done")
+	subprocess.Stdout = &stdout
+
+	result := runCommand(subprocess, commandOptions{EchoMaxLines: 1, Timestamps: timestampsRelative})
+	if strings.Count(stdout.String(), "\n") != 3 || !strings.Contains(stdout.String(), "... (4 lines omitted) ...\n") {
+		t.Errorf("Expected the first and last line around a marker, got %q", stdout.String())
+	}
+	if !strings.HasSuffix(stdout.String(), " 6\n") {
+		t.Errorf("Expected the last line to keep its timestamp, got %q", stdout.String())
+	}
+	if result.Output.String() != "1\n2\n3\n4\n5\n6\n" {
+		t.Errorf("Expected all output to be captured, got %q", result.Output.String())
+	}
+}
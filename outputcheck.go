@@ -0,0 +1,81 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+// maxQuotedLineLength is how much of a matching output line the description
+// quotes.
+const maxQuotedLineLength = 60
+
+// compilePatterns compiles the regexps given with the repeatable flag name.
+func compilePatterns(name string, patterns []string) ([]*regexp.Regexp, error) {
+	var compiled []*regexp.Regexp
+	for _, pattern := range patterns {
+		re, err := regexp.Compile(pattern)
+		if err != nil {
+			return nil, fmt.Errorf("Error: invalid %s %q: %s", name, pattern, err)
+		}
+		compiled = append(compiled, re)
+	}
+	return compiled, nil
+}
+
+// firstMatchingLine returns the first line of output any of patterns
+// matches.
+func firstMatchingLine(patterns []*regexp.Regexp, output string) (string, bool) {
+	for _, line := range strings.Split(output, "\n") {
+		if retryPattern(patterns, line) != nil {
+			return line, true
+		}
+	}
+	return "", false
+}
+
+// quoteOutputLine prepares an output line for a description: control
+// characters and runs of whitespace become single spaces, and long lines are
+// shortened.
+func quoteOutputLine(line string) string {
+	line = strings.Map(func(r rune) rune {
+		if r < ' ' || r == 0x7f {
+			return ' '
+		}
+		return r
+	}, line)
+	return fmt.Sprintf("%q", truncateDescription(strings.Join(strings.Fields(line), " "), maxQuotedLineLength))
+}
+
+// applyOutputChecks fails the command when its captured output matches a
+// -fail-on-output pattern or lacks one of the -require-output patterns,
+// whatever its exit code, and returns the description to report. Only the
+// captured tail of the output is checked.
+func applyOutputChecks(flags Flags, result *commandResult) string {
+	if len(flags.FailOnOutput) == 0 && len(flags.RequireOutput) == 0 {
+		return flags.Description
+	}
+	output := result.Output.String()
+	var note string
+	failPatterns, _ := compilePatterns("-fail-on-output", flags.FailOnOutput)
+	if line, ok := firstMatchingLine(failPatterns, output); ok {
+		note = "output matched " + quoteOutputLine(line)
+	}
+	if required, _ := compilePatterns("-require-output", flags.RequireOutput); note == "" {
+		for _, pattern := range required {
+			if !pattern.MatchString(output) {
+				note = fmt.Sprintf("output lacks %q", pattern)
+				break
+			}
+		}
+	}
+	if note == "" {
+		return flags.Description
+	}
+	if result.Err == nil {
+		result.Err = errors.New(note)
+	}
+	result.StatusOnly = false
+	return appendSuffix(flags.Description, note)
+}
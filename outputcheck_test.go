@@ -0,0 +1,89 @@
+package main
+
+import (
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+// outputResult is a command result whose captured output is output.
+func outputResult(output string, err error) *commandResult {
+	result := &commandResult{Output: newTailBuffer(outputTailSize), Err: err}
+	result.Output.Write([]byte(output))
+	return result
+}
+
+func TestApplyOutputChecksFailOnOutput(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnOutput = []string{`^ERROR:`, `panic:`}
+	result := outputResult("Plan: 2 to add\nERROR: provider\tcrashed  \nERROR: again\n", nil)
+
+	expected := `unit test (output matched "ERROR: provider crashed")`
+	if description := applyOutputChecks(*flags, result); description != expected {
+		t.Errorf("Expected %q, got %q", expected, description)
+	}
+	if commandState(result) != "failure" {
+		t.Errorf("Expected a failure despite exit code 0, got %s", commandState(result))
+	}
+}
+
+func TestApplyOutputChecksQuotesShortenedLine(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnOutput = []string{`ERROR`}
+	result := outputResult("ERROR: "+strings.Repeat("x", 100)+"\n", nil)
+
+	expected := `unit test (output matched "ERROR: ` + strings.Repeat("x", maxQuotedLineLength-len("ERROR: ...")) + `...")`
+	if description := applyOutputChecks(*flags, result); description != expected {
+		t.Errorf("Expected the quoted line to be shortened to %q, got %q", expected, description)
+	}
+}
+
+func TestApplyOutputChecksRequireOutput(t *testing.T) {
+	flags := defaultFlags()
+	flags.RequireOutput = []string{`SMOKE OK`}
+
+	result := outputResult("starting\nSMOKE OK\n", nil)
+	if description := applyOutputChecks(*flags, result); description != "unit test" || result.Err != nil {
+		t.Errorf("Expected output with the marker to pass, got %q and %v", description, result.Err)
+	}
+
+	result = outputResult("starting\n", nil)
+	if description := applyOutputChecks(*flags, result); description != `unit test (output lacks "SMOKE OK")` {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if commandState(result) != "failure" {
+		t.Errorf("Expected a missing marker to fail, got %s", commandState(result))
+	}
+}
+
+func TestApplyOutputChecksKeepsCommandFailure(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnOutput = []string{`ERROR:`}
+	cause := errors.New("exit status 2")
+	result := outputResult("ERROR: boom\n", cause)
+
+	if description := applyOutputChecks(*flags, result); description != `unit test (output matched "ERROR: boom")` {
+		t.Errorf("Expected the matching line on a failed command too, got %q", description)
+	}
+	if result.Err != cause {
+		t.Errorf("Expected the command's own error to be kept, got %v", result.Err)
+	}
+}
+
+func TestValidateFlagsOutputPatterns(t *testing.T) {
+	flags := defaultFlags()
+	flags.RequireOutput = []string{"(unclosed"}
+	if err := validateFlags(*flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), `invalid -require-output "(unclosed"`) {
+		t.Errorf("Expected the invalid pattern to be rejected, got %v", err)
+	}
+}
+
+func TestCLIFailOnOutputExitsNonZero(t *testing.T) {
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-fail-on-output.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"-fail-on-output", "^ERROR:", "sh", "-c", "echo 'ERROR: provider crashed'")
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,88 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"sync"
+)
+
+// outputFileMode is the value of the -output-file-mode flag: what happens to
+// an existing -output-file.
+type outputFileMode string
+
+const (
+	outputFileTruncate outputFileMode = "truncate"
+	outputFileAppend   outputFileMode = "append"
+	// outputFileRotate keeps the previous logs as file.1 to file.N.
+	outputFileRotate outputFileMode = "rotate"
+)
+
+// outputFileRotations is how many previous logs rotate mode keeps.
+const outputFileRotations = 5
+
+func (m *outputFileMode) String() string {
+	return string(*m)
+}
+
+func (m *outputFileMode) Set(value string) error {
+	switch outputFileMode(value) {
+	case outputFileTruncate, outputFileAppend, outputFileRotate:
+		*m = outputFileMode(value)
+	default:
+		return fmt.Errorf("expected truncate, append or rotate, got %q", value)
+	}
+	return nil
+}
+
+func (m *outputFileMode) completionValues() []string {
+	return []string{"truncate", "append", "rotate"}
+}
+
+// lockedWriter serializes writes so stdout and stderr can't interleave
+// within a single write.
+type lockedWriter struct {
+	mu   sync.Mutex
+	file *os.File
+}
+
+func (w *lockedWriter) Write(p []byte) (int, error) {
+	w.mu.Lock()
+	defer w.mu.Unlock()
+	return w.file.Write(p)
+}
+
+// rotateOutputFile shifts path to path.1, path.1 to path.2 and so on,
+// dropping the oldest.
+func rotateOutputFile(path string) error {
+	os.Remove(path + "." + strconv.Itoa(outputFileRotations))
+	for i := outputFileRotations - 1; i >= 1; i-- {
+		older := path + "." + strconv.Itoa(i)
+		if err := os.Rename(older, path+"."+strconv.Itoa(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(path, path+".1"); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// openOutputFile opens -output-file for the command's combined output,
+// which is written straight to it so it needn't fit in memory.
+func openOutputFile(path string, mode outputFileMode) (*lockedWriter, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_TRUNC
+	switch mode {
+	case outputFileAppend:
+		flags = os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	case outputFileRotate:
+		if err := rotateOutputFile(path); err != nil {
+			return nil, fmt.Errorf("Error rotating output file %s: %s", path, err)
+		}
+	}
+	file, err := os.OpenFile(path, flags, 0644)
+	if err != nil {
+		return nil, fmt.Errorf("Error opening output file: %s", err)
+	}
+	return &lockedWriter{file: file}, nil
+}
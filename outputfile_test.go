@@ -0,0 +1,91 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+)
+
+func TestOutputFileMatchesCommandOutput(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	file, err := openOutputFile(path, outputFileTruncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var echoed lockedBuffer
+	subprocess := exec.Command("sh", "-c", "echo out; echo sup3rsecret; echo done; echo err 1>&2")
+	subprocess.Stdout, subprocess.Stderr = &echoed, &echoed
+	runCommand(subprocess, commandOptions{OutputFile: file, EchoMaxLines: 1, Secrets: []string{"sup3rsecret"}})
+	file.file.Close()
+
+	written, _ := ioutil.ReadFile(path)
+	// The two streams are relayed independently, so only stdout's order is
+	// certain.
+	if !strings.Contains(string(written), "out\n***\ndone\n") || !strings.Contains(string(written), "err\n") || len(written) != len("out\n***\ndone\nerr\n") {
+		t.Errorf("Expected the complete masked output in the file, got %q", written)
+	}
+	if echoed.String() == string(written) {
+		t.Errorf("Expected -echo-max-lines to limit only the echoed output")
+	}
+}
+
+func TestOpenOutputFileModes(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	write := func(mode outputFileMode, contents string) {
+		file, err := openOutputFile(path, mode)
+		if err != nil {
+			t.Fatal(err)
+		}
+		file.Write([]byte(contents))
+		file.file.Close()
+	}
+	read := func(path string) string {
+		contents, _ := ioutil.ReadFile(path)
+		return string(contents)
+	}
+
+	write(outputFileTruncate, "first\n")
+	write(outputFileAppend, "second\n")
+	if read(path) != "first\nsecond\n" {
+		t.Errorf("Expected append to keep the existing log, got %q", read(path))
+	}
+	write(outputFileTruncate, "third\n")
+	if read(path) != "third\n" {
+		t.Errorf("Expected truncate to replace the log, got %q", read(path))
+	}
+
+	for i := 0; i < outputFileRotations+2; i++ {
+		write(outputFileRotate, string(rune('a'+i)))
+	}
+	if read(path) != "g" || read(path+".1") != "f" || read(path+".5") != "b" {
+		t.Errorf("Expected the last logs to rotate, got %q %q %q", read(path), read(path+".1"), read(path+".5"))
+	}
+	if _, err := os.Stat(path + ".6"); !os.IsNotExist(err) {
+		t.Errorf("Expected only %d rotated logs", outputFileRotations)
+	}
+}
+
+func TestOutputFileTimestamps(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "build.log")
+	file, err := openOutputFile(path, outputFileTruncate)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var echoed lockedBuffer
+	subprocess := exec.Command("sh", "-c", "echo one; printf two")
+	subprocess.Stdout, subprocess.Stderr = &echoed, &echoed
+	runCommand(subprocess, commandOptions{OutputFile: file, Timestamps: timestampsRelative, OutputPrefix: "[build] "})
+	file.file.Close()
+
+	written, _ := ioutil.ReadFile(path)
+	pattern := regexp.MustCompile(`^\+\d+\.\d{3}s one\n\+\d+\.\d{3}s two$`)
+	if !pattern.MatchString(string(written)) {
+		t.Errorf("Expected timestamped lines without the -output-prefix in the file, got %q", written)
+	}
+}
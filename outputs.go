@@ -0,0 +1,60 @@
+package main
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"strings"
+)
+
+// githubOutput is one step output for GitHub Actions.
+type githubOutput struct {
+	Name, Value string
+}
+
+// formatGithubOutput formats an output in the GITHUB_OUTPUT file format.
+// Values with newlines use the heredoc form with a random delimiter that
+// can't appear in the value.
+func formatGithubOutput(output githubOutput) string {
+	if !strings.ContainsAny(output.Value, "\r\n") {
+		return output.Name + "=" + output.Value + "\n"
+	}
+	delimiter := outputDelimiter()
+	for strings.Contains(output.Value, delimiter) {
+		delimiter = outputDelimiter()
+	}
+	return output.Name + "<<" + delimiter + "\n" + output.Value + "\n" + delimiter + "\n"
+}
+
+func outputDelimiter() string {
+	random := make([]byte, 8)
+	rand.Read(random)
+	return "ghadelimiter_" + hex.EncodeToString(random)
+}
+
+// writeGithubOutputs appends outputs to the GITHUB_OUTPUT file at path,
+// which Actions reads after the step.
+func writeGithubOutputs(path string, outputs []githubOutput) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening -github-output %s: %s", path, err)
+	}
+	defer file.Close()
+
+	for _, output := range outputs {
+		if _, err := file.WriteString(formatGithubOutput(output)); err != nil {
+			return fmt.Errorf("Error writing -github-output %s: %s", path, err)
+		}
+	}
+	return nil
+}
+
+// runOutputs are the step outputs describing the final status.
+func runOutputs(target statusTarget, flags Flags, state string) []githubOutput {
+	return []githubOutput{
+		{"state", state},
+		{"status_url", target.url()},
+		{"description", statusDescription(flags, state)},
+	}
+}
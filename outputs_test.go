@@ -0,0 +1,35 @@
+package main
+
+import (
+	"io/ioutil"
+	"regexp"
+	"testing"
+)
+
+func TestFormatGithubOutput(t *testing.T) {
+	if line := formatGithubOutput(githubOutput{"state", "success"}); line != "state=success\n" {
+		t.Errorf("Unexpected output %q", line)
+	}
+
+	multiline := formatGithubOutput(githubOutput{"description", "line one\nline two"})
+	pattern := regexp.MustCompile(`^description<<(ghadelimiter_[0-9a-f]{16})\nline one\nline two\n(ghadelimiter_[0-9a-f]{16})\n$`)
+	match := pattern.FindStringSubmatch(multiline)
+	if match == nil || match[1] != match[2] {
+		t.Errorf("Expected the heredoc form, got %q", multiline)
+	}
+}
+
+func TestWriteGithubOutputsAppends(t *testing.T) {
+	path := writeTempFile(t, "github_output", "earlier=1\n")
+	flags := defaultFlags()
+
+	err := writeGithubOutputs(path, runOutputs(statusTarget{"org/repo", "deadbeef"}, *flags, "failure"))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	expected := "earlier=1\nstate=failure\nstatus_url=" + githubAPIURL + "/repos/org/repo/statuses/deadbeef\ndescription=unit test\n"
+	if string(contents) != expected {
+		t.Errorf("Expected %q, got %q", expected, contents)
+	}
+}
@@ -0,0 +1,57 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+)
+
+// paramsJSON is the JSON object -params-env reads: the commit status and
+// where to post it.
+type paramsJSON struct {
+	CommitStatusParams
+	SHA     string `json:"sha"`
+	OrgRepo string `json:"org_repo"`
+}
+
+// applyParamsJSON sets the flags that have no value yet, neither from the
+// command line nor from their own variables, from the JSON object in the
+// variable env. origins gets env for each flag it sets.
+func applyParamsJSON(flags *flag.FlagSet, env string, getenv func(string) string, origins map[string]string) error {
+	value := getenv(env)
+	if value == "" {
+		return nil
+	}
+	decoder := json.NewDecoder(bytes.NewReader([]byte(value)))
+	decoder.DisallowUnknownFields()
+	var params paramsJSON
+	if err := decoder.Decode(&params); err != nil {
+		return fmt.Errorf("Error parsing %s: %s", env, err)
+	}
+	if decoder.More() {
+		return fmt.Errorf("Error parsing %s: it must hold a single JSON object", env)
+	}
+	if params.State != "" {
+		return fmt.Errorf("Error: %s can't set the state; it comes from the command's result", env)
+	}
+
+	given := map[string]bool{}
+	flags.Visit(func(f *flag.Flag) { given[f.Name] = true })
+	for _, field := range []struct{ name, value string }{
+		{"r", params.OrgRepo},
+		{"s", params.SHA},
+		{"c", params.Context},
+		{"d", params.Description},
+		{"t", params.TargetUrl},
+	} {
+		if field.value == "" || given[field.name] || origins[field.name] != "" {
+			continue
+		}
+		if err := flags.Set(field.name, field.value); err != nil {
+			return fmt.Errorf("Error: invalid %s: %s", env, err)
+		}
+		origins[field.name] = env
+	}
+	return nil
+}
@@ -0,0 +1,74 @@
+package main
+
+import (
+	"flag"
+	"strings"
+	"testing"
+)
+
+// paramsFlagSet defines the flags a params JSON object can set.
+func paramsFlagSet(args ...string) (*flag.FlagSet, map[string]*string) {
+	flags := flag.NewFlagSet("test", flag.ContinueOnError)
+	values := map[string]*string{}
+	for _, name := range []string{"r", "s", "c", "d", "t"} {
+		values[name] = flags.String(name, "", "")
+	}
+	flags.Parse(args)
+	return flags, values
+}
+
+func TestApplyParamsJSON(t *testing.T) {
+	flags, values := paramsFlagSet("-c", "ci/flag")
+	origins := map[string]string{"d": "BUILD_DESCRIPTION"}
+	*values["d"] = "from the variable"
+	env := fakeEnv(map[string]string{"BUILD_PARAMS_JSON": `{"org_repo":"org/repo","sha":"deadbeef","context":"ci/json","description":"from json","target_url":"https://ci.example.com/1"}`})
+
+	if err := applyParamsJSON(flags, "BUILD_PARAMS_JSON", env, origins); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	for name, expected := range map[string]string{
+		"r": "org/repo",
+		"s": "deadbeef",
+		"c": "ci/flag",
+		"d": "from the variable",
+		"t": "https://ci.example.com/1",
+	} {
+		if *values[name] != expected {
+			t.Errorf("Expected -%s to be %q, got %q", name, expected, *values[name])
+		}
+	}
+	if origins["s"] != "BUILD_PARAMS_JSON" || origins["c"] != "" || origins["d"] != "BUILD_DESCRIPTION" {
+		t.Errorf("Expected only the flags set from the JSON to have it as their origin, got %v", origins)
+	}
+}
+
+func TestApplyParamsJSONRejectsInvalid(t *testing.T) {
+	for value, expected := range map[string]string{
+		`{"contxt":"ci"}`:     `Error parsing BUILD_PARAMS_JSON: json: unknown field "contxt"`,
+		`{"context":"ci"`:     "Error parsing BUILD_PARAMS_JSON: unexpected EOF",
+		`["ci"]`:              "Error parsing BUILD_PARAMS_JSON: json: cannot unmarshal array",
+		`{"context":"a"} {}`:  "it must hold a single JSON object",
+		`{"state":"success"}`: "BUILD_PARAMS_JSON can't set the state",
+	} {
+		flags, _ := paramsFlagSet()
+		err := applyParamsJSON(flags, "BUILD_PARAMS_JSON", fakeEnv(map[string]string{"BUILD_PARAMS_JSON": value}), map[string]string{})
+		if err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s, got %v", expected, value, err)
+		}
+	}
+}
+
+func TestApplyParamsJSONUnset(t *testing.T) {
+	flags, values := paramsFlagSet()
+	if err := applyParamsJSON(flags, "BUILD_PARAMS_JSON", fakeEnv(nil), map[string]string{}); err != nil || *values["c"] != "" {
+		t.Errorf("Expected an unset variable to change nothing, got %q, %v", *values["c"], err)
+	}
+}
+
+func TestCLIReadsParamsEnv(t *testing.T) {
+	out, code := runCLIWithEnv(t, []string{"CI_PARAMS={\"org_repo\":\"org/repo\",\"sha\":\"deadbeef\",\"context\":\"ci\",\"description\":\"unit test\"}"},
+		"-replay", "testdata/replay-failure.json", "-params-env", "CI_PARAMS", "-a", "token", "sh", "-c", "exit 3")
+	if code != 1 || strings.Contains(out, "Error") {
+		t.Errorf("Expected the statuses from the JSON params to be posted, got %d:\n%s", code, out)
+	}
+}
@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"text/template"
+	"time"
+)
+
+// defaultPayloadTemplate posts the fields of a Github commit status.
+const defaultPayloadTemplate = `{"state": {{json .State}}, "target_url": {{json .TargetUrl}}, "description": {{json .Description}}, "context": {{json .Context}}, "sha": {{json .SHA}}, "repository": {{json .Repository}}}`
+
+// payloadTimeout bounds each post to -payload-url and -webhook-url.
+const payloadTimeout = 30 * time.Second
+
+// payloadTemplateFuncs are the functions available to -payload-template.
+// json encodes a value, quoting and escaping strings.
+var payloadTemplateFuncs = template.FuncMap{
+	"json": func(value interface{}) (string, error) {
+		encoded, err := json.Marshal(value)
+		return string(encoded), err
+	},
+}
+
+// loadPayloadTemplate parses the -payload-template file, or the default
+// template without one.
+func loadPayloadTemplate(path string) (*template.Template, error) {
+	text := defaultPayloadTemplate
+	if path != "" {
+		contents, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading -payload-template: %s", err)
+		}
+		text = string(contents)
+	}
+	tmpl, err := template.New("payload").Funcs(payloadTemplateFuncs).Option("missingkey=error").Parse(text)
+	if err != nil {
+		return nil, fmt.Errorf("Error parsing -payload-template: %s", err)
+	}
+	return tmpl, nil
+}
+
+// validatePayloadFlags checks -payload-url and -payload-template.
+func validatePayloadFlags(flags Flags) error {
+	if flags.PayloadURL == "" {
+		if flags.PayloadTemplate != "" {
+			return errors.New("Error: -payload-template requires -payload-url")
+		}
+		return nil
+	}
+	if parsed, err := url.Parse(flags.PayloadURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Error: -payload-url %q is not an absolute http or https URL", redactURL(flags.PayloadURL))
+	}
+	_, err := loadPayloadTemplate(flags.PayloadTemplate)
+	return err
+}
+
+// renderPayload renders event with tmpl and checks the result is JSON.
+func renderPayload(tmpl *template.Template, event statusEvent) ([]byte, error) {
+	var b bytes.Buffer
+	if err := tmpl.Execute(&b, event); err != nil {
+		return nil, fmt.Errorf("Error rendering -payload-template: %s", err)
+	}
+	if !json.Valid(b.Bytes()) {
+		return nil, fmt.Errorf("Error: -payload-template rendered invalid JSON for the %s event: %s", event.State, truncateDescription(b.String(), 200))
+	}
+	return b.Bytes(), nil
+}
+
+// postPayload sends event to -payload-url, rendered with
+// -payload-template.
+func postPayload(flags Flags, event statusEvent) error {
+	tmpl, err := loadPayloadTemplate(flags.PayloadTemplate)
+	if err != nil {
+		return err
+	}
+	body, err := renderPayload(tmpl, event)
+	if err != nil {
+		return err
+	}
+	return postJSON(flags, "-payload-url", flags.PayloadURL, nil, body)
+}
+
+// postJSON posts body to endpoint, a custom endpoint given by flagName,
+// with header added. With -dry-run the body is only logged.
+func postJSON(flags Flags, flagName, endpoint string, header http.Header, body []byte) error {
+	if flags.DryRun {
+		logger.Infof("Would post %s to %s", body, redactURL(endpoint))
+		recordPlannedPost("POST", endpoint, string(body))
+		return nil
+	}
+
+	transport, err := baseTransport(flags)
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest("POST", endpoint, bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("Error creating the %s request: %s", flagName, err)
+	}
+	for name, values := range header {
+		req.Header[name] = values
+	}
+	req.Header.Set("Content-Type", "application/json")
+	resp, err := (&http.Client{Transport: transport, Timeout: payloadTimeout}).Do(req)
+	if err != nil {
+		return fmt.Errorf("Error posting to %s: %s", flagName, err)
+	}
+	defer resp.Body.Close()
+	io.Copy(ioutil.Discard, io.LimitReader(resp.Body, maxErrorBody))
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("Error: %s responded with %d", redactURL(endpoint), resp.StatusCode)
+	}
+	return nil
+}
@@ -0,0 +1,122 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRenderPayload(t *testing.T) {
+	event := statusEvent{State: "failure", Description: `unit "test"`, Context: "ci", SHA: "deadbeef", Repository: "org/repo"}
+	tmpl, err := loadPayloadTemplate("")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	body, err := renderPayload(tmpl, event)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := `{"state": "failure", "target_url": "", "description": "unit \"test\"", "context": "ci", "sha": "deadbeef", "repository": "org/repo"}`
+	if string(body) != expected {
+		t.Errorf("Expected %s, got %s", expected, body)
+	}
+
+	path := writeTempFile(t, "payload.tmpl", `{"status": {{if eq .State "success"}}"green"{{else}}"red"{{end}}, "build": {"name": {{json .Context}}}}`)
+	if tmpl, err = loadPayloadTemplate(path); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if body, _ := renderPayload(tmpl, event); string(body) != `{"status": "red", "build": {"name": "ci"}}` {
+		t.Errorf("Unexpected payload %s", body)
+	}
+
+	tmpl, _ = loadPayloadTemplate(writeTempFile(t, "payload.tmpl", `{"description": "{{.Description}}"}`))
+	if _, err := renderPayload(tmpl, event); err == nil || !strings.Contains(err.Error(), "rendered invalid JSON for the failure event") {
+		t.Errorf("Expected unescaped quotes to be invalid JSON, got %v", err)
+	}
+	tmpl, _ = loadPayloadTemplate(writeTempFile(t, "payload.tmpl", `{{.Missing}}`))
+	if _, err := renderPayload(tmpl, event); err == nil {
+		t.Errorf("Expected an error for an unknown field")
+	}
+}
+
+func TestValidatePayloadFlags(t *testing.T) {
+	for _, test := range []struct {
+		url, template, message string
+	}{
+		{"", "", ""},
+		{"https://status.example.com/api", "", ""},
+		{"", "payload.tmpl", "requires -payload-url"},
+		{"status.example.com", "", "not an absolute http or https URL"},
+		{"https://status.example.com/api", writeTempFile(t, "payload.tmpl", "{{.State"), "Error parsing -payload-template"},
+		{"https://status.example.com/api", "/nonexistent/payload.tmpl", "Error reading -payload-template"},
+	} {
+		err := validatePayloadFlags(Flags{PayloadURL: test.url, PayloadTemplate: test.template})
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %q and %q, got %v", test.message, test.url, test.template, err)
+		}
+	}
+}
+
+func TestReporterPostsPayload(t *testing.T) {
+	var payloads []map[string]interface{}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var payload map[string]interface{}
+		if err := json.Unmarshal(body, &payload); err != nil {
+			t.Errorf("Expected JSON, got %s", body)
+		}
+		payloads = append(payloads, payload)
+	}))
+	defer server.Close()
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.PayloadURL = server.URL + "/statuses"
+	flags.PayloadTemplate = writeTempFile(t, "payload.tmpl", `{"commit": {{json .SHA}}, "state": {{json .State}}, "event": {{json .Event}}{{with .ExitCode}}, "exit_code": {{.}}{{end}}}`)
+	statusReporter := &reporter{flags: *flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+	if err := statusReporter.report("pending", nil); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if err := statusReporter.report("failure", &commandResult{ExitCode: 2}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if len(payloads) != 2 {
+		t.Fatalf("Expected a payload per status, got %v", payloads)
+	}
+	if payloads[0]["commit"] != "deadbeef" || payloads[0]["state"] != "pending" || payloads[0]["exit_code"] != nil {
+		t.Errorf("Unexpected pending payload %v", payloads[0])
+	}
+	if payloads[1]["state"] != "failure" || payloads[1]["event"] != "completed" || payloads[1]["exit_code"] != 2.0 {
+		t.Errorf("Unexpected final payload %v", payloads[1])
+	}
+}
+
+func TestReporterWarnsOnPayloadFailure(t *testing.T) {
+	out := withLogger(t, logWarn)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer server.Close()
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.PayloadURL = server.URL
+	statusReporter := &reporter{flags: *flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+	if err := statusReporter.report("pending", nil); err != nil {
+		t.Errorf("Expected a failed payload not to fail the status, got %s", err)
+	}
+	if !strings.Contains(out.String(), "could not post pending to -payload-url") || !strings.Contains(out.String(), "responded with 500") {
+		t.Errorf("Expected a warning, got %q", out)
+	}
+}
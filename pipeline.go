@@ -0,0 +1,680 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// stageCommand is a stage's command: a string run with sh -c, or an array
+// run as-is.
+type stageCommand []string
+
+func (c *stageCommand) UnmarshalJSON(data []byte) error {
+	var line string
+	if err := json.Unmarshal(data, &line); err == nil {
+		*c = stageCommand{"sh", "-c", line}
+		if strings.TrimSpace(line) == "" {
+			*c = nil
+		}
+		return nil
+	}
+	var args []string
+	if err := json.Unmarshal(data, &args); err != nil {
+		return errors.New("command must be a string or an array of strings")
+	}
+	*c = args
+	return nil
+}
+
+// stageTimeout is a stage's timeout, written like 10m.
+type stageTimeout time.Duration
+
+func (t *stageTimeout) UnmarshalJSON(data []byte) error {
+	var value string
+	if err := json.Unmarshal(data, &value); err != nil {
+		return errors.New(`timeout must be a duration string such as "10m"`)
+	}
+	duration, err := time.ParseDuration(value)
+	if err != nil || duration <= 0 {
+		return fmt.Errorf("timeout %q is not a positive duration", value)
+	}
+	*t = stageTimeout(duration)
+	return nil
+}
+
+// pipelineStage is one stage of a pipeline file. Its name is the status
+// context it reports to.
+type pipelineStage struct {
+	Name    string            `json:"name"`
+	Command stageCommand      `json:"command"`
+	Needs   []string          `json:"needs"`
+	Env     map[string]string `json:"env"`
+	Workdir string            `json:"workdir"`
+	Timeout stageTimeout      `json:"timeout"`
+}
+
+// pipelineFile is the contents of a pipeline -f file. Run, if set, is an
+// expression like "build && test || notify" deciding which stages run,
+// instead of their needs. Rollup, if set, is a context reporting the state
+// of the whole pipeline.
+type pipelineFile struct {
+	Stages []pipelineStage `json:"stages"`
+	Run    string          `json:"run"`
+	Rollup string          `json:"rollup"`
+
+	// steps is Run parsed.
+	steps []runStep
+}
+
+// runStep is a stage of a run expression with the operator before it: "&&"
+// runs the stage only if the last stage that ran succeeded, "||" only if it
+// didn't. The first step has no operator.
+type runStep struct {
+	Op   string
+	Name string
+}
+
+// stageOutcome is how a stage ended. Result is nil for a stage that never
+// ran, with Reason saying why.
+type stageOutcome struct {
+	State  string
+	Reason string
+	Result *commandResult
+}
+
+// stageReport is a stage's entry in the pipeline's -json-report.
+type stageReport struct {
+	Name            string           `json:"name"`
+	Needs           []string         `json:"needs,omitempty"`
+	State           string           `json:"state"`
+	Reason          string           `json:"reason,omitempty"`
+	ExitCode        int              `json:"exit_code"`
+	DurationSeconds float64          `json:"duration_seconds"`
+	TimedOut        bool             `json:"timed_out"`
+	Attempts        []commandAttempt `json:"attempts,omitempty"`
+}
+
+// pipelineReport is the -json-report of the pipeline subcommand.
+type pipelineReport struct {
+	Repositories []string      `json:"repositories"`
+	SHA          string        `json:"sha"`
+	State        string        `json:"state"`
+	Rollup       string        `json:"rollup,omitempty"`
+	Stages       []stageReport `json:"stages"`
+}
+
+// postOrder is the value of the -post-order flag: the order the pipeline
+// posts its contexts in.
+type postOrder string
+
+const (
+	postOrderInput postOrder = ""
+	postOrderAlpha postOrder = "alpha"
+)
+
+func (o *postOrder) String() string {
+	return string(*o)
+}
+
+func (o *postOrder) Set(value string) error {
+	switch value {
+	case "input":
+		*o = postOrderInput
+	case "alpha":
+		*o = postOrderAlpha
+	default:
+		return fmt.Errorf("expected alpha or input, got %q", value)
+	}
+	return nil
+}
+
+func (o *postOrder) completionValues() []string {
+	return []string{"alpha", "input"}
+}
+
+// stagesByName returns the stages sorted by name.
+func stagesByName(stages []pipelineStage) []pipelineStage {
+	sorted := append([]pipelineStage(nil), stages...)
+	sort.SliceStable(sorted, func(i, j int) bool { return sorted[i].Name < sorted[j].Name })
+	return sorted
+}
+
+// loadPipeline reads and validates the pipeline file at path, YAML or JSON,
+// with its stages in an order where every stage comes after the ones it
+// needs.
+func loadPipeline(path string) (*pipelineFile, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading the pipeline: %s", err)
+	}
+	if !bytes.HasPrefix(bytes.TrimSpace(contents), []byte("{")) {
+		// YAML is decoded like the JSON it maps to, so both are checked the
+		// same way.
+		document, err := parseYAML(contents)
+		if err != nil {
+			return nil, fmt.Errorf("Error parsing the pipeline %s: %s", path, err)
+		}
+		contents, _ = json.Marshal(document)
+	}
+	decoder := json.NewDecoder(bytes.NewReader(contents))
+	decoder.DisallowUnknownFields()
+	var file pipelineFile
+	if err := decoder.Decode(&file); err != nil {
+		return nil, fmt.Errorf("Error parsing the pipeline %s: %s", path, err)
+	}
+	if len(file.Stages) == 0 {
+		return nil, fmt.Errorf("Error: the pipeline %s has no stages", path)
+	}
+	if file.Run != "" {
+		steps, err := parseRunExpression(file.Run)
+		if err != nil {
+			return nil, err
+		}
+		file.steps = steps
+	}
+	if err := validatePipeline(file); err != nil {
+		return nil, err
+	}
+	sorted, err := sortStages(file.Stages)
+	if err != nil {
+		return nil, err
+	}
+	file.Stages = sorted
+	return &file, nil
+}
+
+// parseRunExpression parses a run expression: stage names joined by && and
+// ||. As in the shell, both have the same precedence and are evaluated left
+// to right.
+func parseRunExpression(expr string) ([]runStep, error) {
+	var steps []runStep
+	op, rest := "", expr
+	for {
+		next := strings.Index(rest, "&&")
+		if or := strings.Index(rest, "||"); or >= 0 && (next < 0 || or < next) {
+			next = or
+		}
+		name := rest
+		if next >= 0 {
+			name = rest[:next]
+		}
+		if name = strings.TrimSpace(name); name == "" {
+			return nil, fmt.Errorf("Error: run %q has an operator without a stage on both sides", expr)
+		}
+		steps = append(steps, runStep{Op: op, Name: name})
+		if next < 0 {
+			return steps, nil
+		}
+		op, rest = rest[next:next+2], rest[next+2:]
+	}
+}
+
+// validatePipeline checks every stage, the run expression and the rollup
+// context, and returns all problems at once.
+func validatePipeline(file pipelineFile) error {
+	stages := file.Stages
+	var errs multiError
+	names := map[string]bool{}
+	for i, stage := range stages {
+		if stage.Name == "" {
+			errs = append(errs, fmt.Errorf("Error: stage %d has no name", i+1))
+			continue
+		}
+		if names[stage.Name] {
+			errs = append(errs, fmt.Errorf("Error: there is more than one stage named %q", stage.Name))
+		}
+		names[stage.Name] = true
+		if err := validateContext(stage.Name); err != nil {
+			errs = append(errs, fmt.Errorf("Error: stage %q: %s", stage.Name, strings.TrimPrefix(err.Error(), "Error: ")))
+		}
+		if len(stage.Command) == 0 {
+			errs = append(errs, fmt.Errorf("Error: stage %q has no command", stage.Name))
+		}
+	}
+	for _, stage := range stages {
+		for _, need := range stage.Needs {
+			switch {
+			case need == stage.Name:
+				errs = append(errs, fmt.Errorf("Error: stage %q needs itself", stage.Name))
+			case !names[need]:
+				errs = append(errs, fmt.Errorf("Error: stage %q needs %q, which is not a stage", stage.Name, need))
+			}
+		}
+		if file.Run != "" && len(stage.Needs) > 0 {
+			errs = append(errs, fmt.Errorf("Error: stage %q has needs, which can't be used with run", stage.Name))
+		}
+	}
+	if file.Run != "" {
+		ran := map[string]bool{}
+		for _, step := range file.steps {
+			switch {
+			case !names[step.Name]:
+				errs = append(errs, fmt.Errorf("Error: run has %q, which is not a stage", step.Name))
+			case ran[step.Name]:
+				errs = append(errs, fmt.Errorf("Error: run has stage %q more than once", step.Name))
+			}
+			ran[step.Name] = true
+		}
+		for _, stage := range stages {
+			if stage.Name != "" && !ran[stage.Name] {
+				errs = append(errs, fmt.Errorf("Error: stage %q is not in run", stage.Name))
+			}
+		}
+	}
+	if file.Rollup != "" {
+		if err := validateContext(file.Rollup); err != nil {
+			errs = append(errs, fmt.Errorf("Error: rollup: %s", strings.TrimPrefix(err.Error(), "Error: ")))
+		} else if names[file.Rollup] {
+			errs = append(errs, fmt.Errorf("Error: rollup %q is also the name of a stage", file.Rollup))
+		}
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// sortStages orders stages so each comes after the stages it needs, keeping
+// the file's order otherwise, and reports a cycle as an error naming it.
+func sortStages(stages []pipelineStage) ([]pipelineStage, error) {
+	byName := map[string]pipelineStage{}
+	for _, stage := range stages {
+		byName[stage.Name] = stage
+	}
+
+	var sorted []pipelineStage
+	// 1 while a stage's needs are being visited, 2 once it is sorted.
+	marks := map[string]int{}
+	var path []string
+	var visit func(name string) error
+	visit = func(name string) error {
+		switch marks[name] {
+		case 1:
+			start := 0
+			for path[start] != name {
+				start++
+			}
+			return fmt.Errorf("Error: the stages form a cycle: %s -> %s", strings.Join(path[start:], " -> "), name)
+		case 2:
+			return nil
+		}
+		marks[name] = 1
+		path = append(path, name)
+		for _, need := range byName[name].Needs {
+			if err := visit(need); err != nil {
+				return err
+			}
+		}
+		path = path[:len(path)-1]
+		marks[name] = 2
+		sorted = append(sorted, byName[name])
+		return nil
+	}
+	for _, stage := range stages {
+		if err := visit(stage.Name); err != nil {
+			return nil, err
+		}
+	}
+	return sorted, nil
+}
+
+// schedulePipeline runs the sorted stages with run, up to parallel at once,
+// starting each one once the stages it needs have succeeded. A stage whose
+// dependency didn't succeed is skipped as an error. Unless keepGoing is set,
+// no new stage starts after one fails. When abort is set it is closed on the
+// first failure, for run to stop the stages still running. finish is called
+// as each stage ends.
+func schedulePipeline(stages []pipelineStage, parallel int, keepGoing bool, abort chan struct{}, run func(pipelineStage) *commandResult, finish func(pipelineStage, *stageOutcome)) map[string]*stageOutcome {
+	if parallel < 1 {
+		parallel = 1
+	}
+	type finished struct {
+		stage  pipelineStage
+		result *commandResult
+	}
+	outcomes := map[string]*stageOutcome{}
+	started := map[string]bool{}
+	done := make(chan finished)
+	running := 0
+	failed := ""
+	settle := func(stage pipelineStage, outcome *stageOutcome) {
+		outcomes[stage.Name] = outcome
+		finish(stage, outcome)
+	}
+
+	for {
+		for _, stage := range stages {
+			if outcomes[stage.Name] != nil || started[stage.Name] {
+				continue
+			}
+			ready, blocked := true, ""
+			for _, need := range stage.Needs {
+				if outcome := outcomes[need]; outcome == nil {
+					ready = false
+				} else if outcome.State != "success" {
+					blocked = need
+					break
+				}
+			}
+			switch {
+			case blocked != "":
+				settle(stage, &stageOutcome{State: "error", Reason: "dependency failed: " + blocked})
+			case failed != "" && abort != nil:
+				settle(stage, &stageOutcome{State: "error", Reason: fmt.Sprintf("skipped, %s failed", failed)})
+			case failed != "" && !keepGoing:
+				settle(stage, &stageOutcome{State: "error", Reason: fmt.Sprintf("cancelled, %s failed", failed)})
+			case ready && running < parallel:
+				started[stage.Name] = true
+				running++
+				go func(stage pipelineStage) { done <- finished{stage, run(stage)} }(stage)
+			}
+		}
+		if running == 0 {
+			return outcomes
+		}
+		f := <-done
+		running--
+		state := commandState(f.result)
+		outcome := &stageOutcome{State: state, Result: f.result}
+		if f.result.Interrupted && failed != "" {
+			outcome.Reason = fmt.Sprintf("aborted, %s failed", failed)
+		} else if state != "success" && failed == "" {
+			failed = f.stage.Name
+			if abort != nil {
+				close(abort)
+			}
+		}
+		settle(f.stage, outcome)
+	}
+}
+
+// runExpression runs the stages of a run expression one at a time, skipping
+// those their operator rules out, and returns their outcomes and the state of
+// the whole expression: that of the last stage that ran, as in the shell. A
+// stage skipped after a failure is an error, while one skipped because the
+// stage before it succeeded, such as the fallback of an ||, is a success.
+func runExpression(steps []runStep, stages []pipelineStage, run func(pipelineStage) *commandResult, finish func(pipelineStage, *stageOutcome)) (map[string]*stageOutcome, string) {
+	byName := map[string]pipelineStage{}
+	for _, stage := range stages {
+		byName[stage.Name] = stage
+	}
+	outcomes := map[string]*stageOutcome{}
+	state, last := "", ""
+	for _, step := range steps {
+		stage := byName[step.Name]
+		var outcome *stageOutcome
+		switch {
+		case step.Op == "&&" && state != "success":
+			outcome = &stageOutcome{State: "error", Reason: fmt.Sprintf("skipped, %s failed", last)}
+		case step.Op == "||" && state == "success":
+			outcome = &stageOutcome{State: "success", Reason: fmt.Sprintf("skipped, %s succeeded", last)}
+		default:
+			result := run(stage)
+			outcome = &stageOutcome{State: commandState(result), Result: result}
+			state, last = outcome.State, stage.Name
+		}
+		outcomes[stage.Name] = outcome
+		finish(stage, outcome)
+	}
+	return outcomes, state
+}
+
+// prefixWriter writes each line to out behind prefix, so the output of
+// stages running at once stays readable. mu is shared by every stage.
+type prefixWriter struct {
+	mu      *sync.Mutex
+	out     io.Writer
+	prefix  string
+	partial []byte
+}
+
+func (w *prefixWriter) Write(p []byte) (int, error) {
+	w.partial = append(w.partial, p...)
+	for {
+		i := bytes.IndexByte(w.partial, '\n')
+		if i < 0 {
+			return len(p), nil
+		}
+		w.mu.Lock()
+		fmt.Fprintf(w.out, "%s%s", w.prefix, w.partial[:i+1])
+		w.mu.Unlock()
+		w.partial = w.partial[i+1:]
+	}
+}
+
+// Flush writes a final line that didn't end in a newline.
+func (w *prefixWriter) Flush() {
+	if len(w.partial) > 0 {
+		w.Write([]byte("\n"))
+	}
+}
+
+// stageRunner runs the stages' commands with the environment, masking and
+// retries of a normal run.
+type stageRunner struct {
+	flags Flags
+	env   []string
+	// statusEnv holds each stage's -inject-status-env variables.
+	statusEnv map[string][]string
+	// abort stops the running stages with -abort-on-failure.
+	abort chan struct{}
+	mu    sync.Mutex
+}
+
+func (r *stageRunner) run(stage pipelineStage) *commandResult {
+	env := append([]string{}, r.env...)
+	names := make([]string, 0, len(stage.Env))
+	for name := range stage.Env {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		env = setEnv(env, name, stage.Env[name])
+	}
+	env = append(env, r.statusEnv[stage.Name]...)
+
+	subprocess := exec.Command(stage.Command[0], stage.Command[1:]...)
+	subprocess.Env = env
+	subprocess.Dir = stage.Workdir
+	prefix := r.flags.OutputPrefix + "[" + stage.Name + "] "
+	stdout := &prefixWriter{mu: &r.mu, out: os.Stdout, prefix: prefix}
+	stderr := &prefixWriter{mu: &r.mu, out: os.Stderr, prefix: prefix}
+	subprocess.Stdout, subprocess.Stderr = stdout, stderr
+
+	options := commandOptions{
+		Retries:      r.flags.CommandRetries,
+		Timeout:      r.flags.CmdTimeout,
+		TimeoutGrace: r.flags.TimeoutGrace,
+	}
+	if r.abort != nil {
+		options.Interrupt = r.abort
+	}
+	if runDeadline != nil {
+		options.Interrupt = anyInterrupt(options.Interrupt, runDeadline.Interrupt)
+	}
+	if stage.Timeout > 0 {
+		options.Timeout = time.Duration(stage.Timeout)
+	}
+	secrets, err := collectSecrets(env, r.flags.MaskEnv, r.flags.MaskStrings)
+	if err != nil {
+		return &commandResult{Err: err, Errored: true, ExitCode: 1, Output: newTailBuffer(outputTailSize)}
+	}
+	options.Secrets = secrets
+
+	result := runCommandAttempts(subprocess, options)
+	applyTimeoutStatus(r.flags, result)
+	applyMissingCommand(r.flags, result)
+	stdout.Flush()
+	stderr.Flush()
+	return result
+}
+
+// runPipelineCommand implements the pipeline subcommand: it posts pending
+// for every stage and the rollup, runs them and posts each one's final
+// state. It returns the exit code: non-zero unless every stage succeeded, or
+// with a run expression, unless the expression did.
+func runPipelineCommand(flags Flags, parallel int, keepGoing bool) int {
+	if flags.ScriptFile == "" || flags.ScriptFile == "-" {
+		exitIfInvalid(errors.New("Error: the pipeline subcommand needs the pipeline file, e.g. -f pipeline.json"))
+	}
+	file, err := loadPipeline(flags.ScriptFile)
+	exitIfInvalid(err)
+	if file.Run != "" && keepGoing {
+		exitIfInvalid(errors.New("Error: -keep-going can't be used with a pipeline that has run"))
+	}
+	if flags.AbortOnFailure && (keepGoing || file.Run != "") {
+		exitIfInvalid(errors.New("Error: -abort-on-failure can't be used with -keep-going or a pipeline that has run"))
+	}
+	if flags.RollupContext != "" {
+		file.Rollup = flags.RollupContext
+		exitIfInvalid(validatePipeline(*file))
+	}
+	stages, pending := file.Stages, file.Stages
+	if flags.PostOrder == postOrderAlpha {
+		// Pending goes out strictly by name. Stages that can start at the
+		// same time start, and those settled together are posted, by name
+		// too, while still coming after the stages they need.
+		pending = stagesByName(stages)
+		stages, _ = sortStages(pending)
+	}
+	reason, err := checkOnlyOnCI(flags, os.Getenv)
+	exitIfError(err)
+	if reason != "" {
+		logger.Infof("Not reporting statuses: %s", reason)
+		flags.Dev = true
+	}
+
+	var targets []statusTarget
+	if !flags.Dev {
+		checked := flags
+		checked.Context = stages[0].Name
+		exitIfInvalid(validateRequiredFlags(checked))
+		targets, err = statusTargets(flags)
+		exitIfError(err)
+	}
+	// Already validated with the other flags.
+	descriptions, _ := parseContextDescriptions(flags.ContextDescriptions)
+	reporters := map[string]*reporter{}
+	for _, stage := range pending {
+		stageFlags := flags
+		stageFlags.Context = stage.Name
+		if description, ok := descriptions[stage.Name]; ok {
+			stageFlags.Description = description
+		}
+		reporters[stage.Name] = &reporter{flags: stageFlags, targets: targets}
+		if !flags.Dev {
+			reporters[stage.Name].deadline = runDeadline.report(targets, stageFlags)
+			exitIfError(reporters[stage.Name].report("pending", nil))
+		}
+	}
+	var rollup *reporter
+	if file.Rollup != "" {
+		rollupFlags := flags
+		rollupFlags.Context = file.Rollup
+		if description, ok := descriptions[file.Rollup]; ok {
+			rollupFlags.Description = description
+		}
+		rollup = &reporter{flags: rollupFlags, targets: targets}
+		if !flags.Dev {
+			rollup.deadline = runDeadline.report(targets, rollupFlags)
+			exitIfError(rollup.report("pending", nil))
+		}
+	}
+
+	env, err := buildCommandEnv(filterCommandEnv(flags, os.Environ()), flags.EnvFiles, flags.Env, flags.EnvExpand)
+	exitIfError(err)
+	runner := &stageRunner{flags: flags, env: env, statusEnv: map[string][]string{}}
+	if flags.InjectStatusEnv && len(targets) > 0 {
+		for _, stage := range stages {
+			runner.statusEnv[stage.Name] = statusEnv(targets[0], reporters[stage.Name].flags)
+		}
+	}
+	if flags.AbortOnFailure {
+		runner.abort = make(chan struct{})
+	}
+	code := 0
+	report := &pipelineReport{SHA: flags.SHA, State: "success", Rollup: file.Rollup}
+	for _, target := range targets {
+		report.Repositories = append(report.Repositories, target.OrgRepo)
+	}
+	finish := func(stage pipelineStage, outcome *stageOutcome) {
+		logger.Infof("Stage %s: %s%s", stage.Name, outcome.State, reasonSuffix(outcome.Reason))
+		if flags.Dev {
+			return
+		}
+		r := reporters[stage.Name]
+		if outcome.Reason != "" {
+			r.flags.Description = outcome.Reason
+		}
+		if err := r.report(outcome.State, outcome.Result); err != nil {
+			logger.Errorf("%s", err)
+			code = 1
+		}
+	}
+	var outcomes map[string]*stageOutcome
+	state := ""
+	if file.Run != "" {
+		outcomes, state = runExpression(file.steps, stages, runner.run, finish)
+	} else {
+		outcomes = schedulePipeline(stages, parallel, keepGoing, runner.abort, runner.run, finish)
+	}
+
+	succeeded := 0
+	for _, stage := range stages {
+		outcome := outcomes[stage.Name]
+		if outcome.State == "success" {
+			succeeded++
+		}
+		entry := stageReport{Name: stage.Name, Needs: stage.Needs, State: outcome.State, Reason: outcome.Reason}
+		if result := outcome.Result; result != nil {
+			entry.ExitCode, entry.DurationSeconds, entry.TimedOut = result.ExitCode, result.Duration.Seconds(), result.TimedOut
+			if flags.CommandRetries > 0 {
+				entry.Attempts = result.Attempts
+			}
+		}
+		if outcome.State == "failure" || (outcome.State == "error" && report.State == "success") {
+			report.State = outcome.State
+		}
+		report.Stages = append(report.Stages, entry)
+	}
+	if state != "" {
+		report.State = state
+	}
+	if report.State != "success" {
+		code = 1
+	}
+	if rollup != nil {
+		logger.Infof("Pipeline %s: %s", file.Rollup, report.State)
+		rollup.flags.Description = fmt.Sprintf("%d of %d stages succeeded", succeeded, len(stages))
+		if !flags.Dev {
+			if err := rollup.report(report.State, nil); err != nil {
+				logger.Errorf("%s", err)
+				code = 1
+			}
+		}
+	}
+	if flags.JSONReport != "" {
+		if err := writeJSONReport(flags.JSONReport, report); err != nil {
+			logger.Errorf("%s", err)
+		}
+	}
+	return code
+}
+
+// reasonSuffix formats why a stage didn't run for a log line.
+func reasonSuffix(reason string) string {
+	if reason == "" {
+		return ""
+	}
+	return " (" + reason + ")"
+}
@@ -0,0 +1,628 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func stageNames(stages []pipelineStage) []string {
+	var names []string
+	for _, stage := range stages {
+		names = append(names, stage.Name)
+	}
+	return names
+}
+
+func TestLoadPipeline(t *testing.T) {
+	path := writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/test", "command": "make test", "needs": ["ci/build"], "timeout": "10m", "env": {"GOFLAGS": "-race"}},
+		{"name": "ci/build", "command": ["make", "build"], "workdir": "src"},
+		{"name": "ci/lint", "command": "make lint"}
+	]}`)
+	file, err := loadPipeline(path)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	stages := file.Stages
+	if names := stageNames(stages); !reflect.DeepEqual(names, []string{"ci/build", "ci/test", "ci/lint"}) {
+		t.Errorf("Expected each stage after the ones it needs, got %q", names)
+	}
+	test := stages[1]
+	if strings.Join(test.Command, " ") != "sh -c make test" || time.Duration(test.Timeout) != 10*time.Minute || test.Env["GOFLAGS"] != "-race" {
+		t.Errorf("Unexpected stage %+v", test)
+	}
+	if build := stages[0]; strings.Join(build.Command, " ") != "make build" || build.Workdir != "src" {
+		t.Errorf("Unexpected stage %+v", build)
+	}
+}
+
+func TestLoadPipelineYAML(t *testing.T) {
+	path := writeTempFile(t, "pipeline.yaml", `# Stages run after the ones they need.
+stages:
+  - name: ci/test
+    command: |
+      make test
+      make bench
+    needs: [ci/build]
+    timeout: 10m
+    env:
+      GOFLAGS: -race
+      RETRIES: 3
+  - name: ci/build
+    command: [make, build]
+    workdir: src
+  - name: ci/lint
+    command: make lint
+rollup: ci/all
+`)
+	file, err := loadPipeline(path)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if names := stageNames(file.Stages); !reflect.DeepEqual(names, []string{"ci/build", "ci/test", "ci/lint"}) || file.Rollup != "ci/all" {
+		t.Errorf("Expected each stage after the ones it needs and the rollup, got %q and %q", names, file.Rollup)
+	}
+	test := file.Stages[1]
+	if strings.Join(test.Command, " ") != "sh -c make test\nmake bench\n" || time.Duration(test.Timeout) != 10*time.Minute || test.Env["RETRIES"] != "3" {
+		t.Errorf("Unexpected stage %+v", test)
+	}
+	if build := file.Stages[0]; strings.Join(build.Command, " ") != "make build" || build.Workdir != "src" {
+		t.Errorf("Unexpected stage %+v", build)
+	}
+
+	for contents, expected := range map[string]string{
+		"stages:\n  - name: a\n    command: true\n    need: [b]\n":  `unknown field "need"`,
+		"stages:\n  - name: a\n   command: true\n":                  `line 3: unexpected "command: true"`,
+		"stages:\n  - name: a\n    command: true\n    needs: [a]\n": `stage "a" needs itself`,
+	} {
+		if _, err := loadPipeline(writeTempFile(t, "pipeline.yaml", contents)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", contents, expected, err)
+		}
+	}
+}
+
+func TestLoadPipelineRejectsInvalid(t *testing.T) {
+	for contents, expected := range map[string]string{
+		`{"stages": [{"name": "a", "command": "true", "need": ["b"]}]}`: `unknown field "need"`,
+		`{"stages": []}`: "has no stages",
+		`{"stages": [{"name": "a", "command": "true", "needs": ["b"]}]}`:                   `stage "a" needs "b", which is not a stage`,
+		`{"stages": [{"name": "a", "command": "true"}, {"name": "a", "command": "true"}]}`: `more than one stage named "a"`,
+		`{"stages": [{"name": "a", "command": ""}]}`:                                       `stage "a" has no command`,
+		`{"stages": [{"name": "a", "command": "true", "needs": ["a"]}]}`:                   `stage "a" needs itself`,
+		`{"stages": [{"name": "a", "command": "true", "timeout": "soon"}]}`:                `timeout "soon" is not a positive duration`,
+		`{"stages": [{"name": "a", "command": "true", "needs": ["c"]}, {"name": "b", "command": "true", "needs": ["a"]}, {"name": "c", "command": "true", "needs": ["b"]}]}`: "the stages form a cycle: a -> c -> b -> a",
+	} {
+		if _, err := loadPipeline(writeTempFile(t, "pipeline.json", contents)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s, got %v", expected, contents, err)
+		}
+	}
+}
+
+// fakeStages runs each stage by exiting with its code in codes, recording
+// the order stages start in and the most that ran at once.
+type fakeStages struct {
+	mu      sync.Mutex
+	codes   map[string]int
+	started []string
+	running int
+	most    int
+}
+
+func (f *fakeStages) run(stage pipelineStage) *commandResult {
+	f.mu.Lock()
+	f.started = append(f.started, stage.Name)
+	f.running++
+	if f.running > f.most {
+		f.most = f.running
+	}
+	f.mu.Unlock()
+	time.Sleep(20 * time.Millisecond)
+	f.mu.Lock()
+	f.running--
+	f.mu.Unlock()
+
+	result := &commandResult{ExitCode: f.codes[stage.Name]}
+	if result.ExitCode != 0 {
+		result.Err = fmt.Errorf("exit status %d", result.ExitCode)
+	}
+	return result
+}
+
+func outcomeStates(outcomes map[string]*stageOutcome) map[string]string {
+	states := map[string]string{}
+	for name, outcome := range outcomes {
+		states[name] = outcome.State + reasonSuffix(outcome.Reason)
+	}
+	return states
+}
+
+var diamond = []pipelineStage{
+	{Name: "build"},
+	{Name: "lint"},
+	{Name: "unit", Needs: []string{"build"}},
+	{Name: "e2e", Needs: []string{"build"}},
+	{Name: "deploy", Needs: []string{"unit", "e2e"}},
+}
+
+func TestSchedulePipelineRunsIndependentStagesInParallel(t *testing.T) {
+	stages := &fakeStages{}
+	var finished []string
+	outcomes := schedulePipeline(diamond, 2, false, nil, stages.run, func(stage pipelineStage, outcome *stageOutcome) {
+		finished = append(finished, stage.Name)
+	})
+	for name, state := range outcomeStates(outcomes) {
+		if state != "success" {
+			t.Errorf("Expected %s to succeed, got %s", name, state)
+		}
+	}
+	if stages.most != 2 {
+		t.Errorf("Expected two stages at once, got at most %d", stages.most)
+	}
+	if finished[len(finished)-1] != "deploy" || len(finished) != 5 {
+		t.Errorf("Expected deploy to finish last, got %q", finished)
+	}
+}
+
+func TestSchedulePipelineSkipsDependentsOfFailedStage(t *testing.T) {
+	stages := &fakeStages{codes: map[string]int{"unit": 1}}
+	outcomes := schedulePipeline(diamond, 1, true, nil, stages.run, func(pipelineStage, *stageOutcome) {})
+	expected := map[string]string{
+		"build":  "success",
+		"lint":   "success",
+		"unit":   "failure",
+		"e2e":    "success",
+		"deploy": "error (dependency failed: unit)",
+	}
+	if states := outcomeStates(outcomes); !reflect.DeepEqual(states, expected) {
+		t.Errorf("Expected %v with -keep-going, got %v", expected, states)
+	}
+}
+
+func TestSchedulePipelineFailsFast(t *testing.T) {
+	stages := &fakeStages{codes: map[string]int{"build": 2}}
+	outcomes := schedulePipeline(diamond, 1, false, nil, stages.run, func(pipelineStage, *stageOutcome) {})
+	if !reflect.DeepEqual(stages.started, []string{"build"}) {
+		t.Errorf("Expected nothing to start after the failure, got %q", stages.started)
+	}
+	states := outcomeStates(outcomes)
+	if states["lint"] != "error (cancelled, build failed)" || states["unit"] != "error (dependency failed: build)" {
+		t.Errorf("Unexpected outcomes %v", states)
+	}
+}
+
+func TestRunPipelineCommandAbortsOnFailure(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	var mu sync.Mutex
+	final := map[string]string{}
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		mu.Lock()
+		if status.State != "pending" {
+			final[status.Context] = status.State + " " + status.Description
+		}
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.AbortOnFailure = true
+	flags.Description = ""
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/build", "command": "true"},
+		{"name": "ci/slow", "command": "sleep 10"},
+		{"name": "ci/test", "command": "exit 1", "needs": ["ci/build"]},
+		{"name": "ci/package", "command": "true", "needs": ["ci/build"]},
+		{"name": "ci/deploy", "command": "true", "needs": ["ci/test"]}
+	]}`)
+
+	started := time.Now()
+	if code := runPipelineCommand(*flags, 2, false); code != 1 {
+		t.Errorf("Expected an aborted pipeline to exit 1, got %d", code)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected the running stage to be stopped, took %s", elapsed)
+	}
+	expected := map[string]string{
+		"ci/build":   "success Build passed",
+		"ci/slow":    "error aborted, ci/test failed",
+		"ci/test":    "failure Build failed",
+		"ci/package": "error skipped, ci/test failed",
+		"ci/deploy":  "error dependency failed: ci/test",
+	}
+	if !reflect.DeepEqual(final, expected) {
+		t.Errorf("Expected every stage to get a final status %v, got %v", expected, final)
+	}
+
+	if err := validateFlags(*flags, nil, ""); err == nil || !strings.Contains(err.Error(), "-abort-on-failure is only used by the pipeline subcommand") {
+		t.Errorf("Expected -abort-on-failure to be pipeline only, got %v", err)
+	}
+}
+
+func TestRunPipelineCommandPostsEachStage(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		mu.Lock()
+		posted = append(posted, status.Context+"="+status.State+" "+status.Description)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.Description = ""
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/build", "command": "exit 3"},
+		{"name": "ci/test", "command": "true", "needs": ["ci/build"]}
+	]}`)
+	flags.JSONReport = writeTempFile(t, "report.json", "")
+
+	if code := runPipelineCommand(*flags, 2, false); code != 1 {
+		t.Errorf("Expected a failed pipeline to exit 1, got %d", code)
+	}
+	sort.Strings(posted[2:])
+	expected := []string{
+		"ci/build=pending Waiting for build...",
+		"ci/test=pending Waiting for build...",
+		"ci/build=failure Build failed",
+		"ci/test=error dependency failed: ci/build",
+	}
+	sort.Strings(expected[2:])
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("Expected statuses %q, got %q", expected, posted)
+	}
+
+	contents, _ := ioutil.ReadFile(flags.JSONReport)
+	var report pipelineReport
+	if err := json.Unmarshal(contents, &report); err != nil {
+		t.Fatalf("Expected a JSON report, got %s: %s", err, contents)
+	}
+	if report.State != "failure" || len(report.Stages) != 2 || report.Stages[0].ExitCode != 3 || report.Stages[1].Reason != "dependency failed: ci/build" {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestParseRunExpression(t *testing.T) {
+	steps, err := parseRunExpression("ci/build && ci/test (linux) ||ci/notify")
+	expected := []runStep{{"", "ci/build"}, {"&&", "ci/test (linux)"}, {"||", "ci/notify"}}
+	if err != nil || !reflect.DeepEqual(steps, expected) {
+		t.Errorf("Expected %v, got %v, %v", expected, steps, err)
+	}
+	for _, expr := range []string{"a &&", "|| a", "a && || b", "  "} {
+		if _, err := parseRunExpression(expr); err == nil || !strings.Contains(err.Error(), "without a stage on both sides") {
+			t.Errorf("Expected %q to be rejected, got %v", expr, err)
+		}
+	}
+}
+
+func TestLoadPipelineRejectsInvalidRun(t *testing.T) {
+	for contents, expected := range map[string]string{
+		`{"run": "a && c", "stages": [{"name": "a", "command": "true"}, {"name": "b", "command": "true"}]}`:                 `run has "c", which is not a stage`,
+		`{"run": "a", "stages": [{"name": "a", "command": "true"}, {"name": "b", "command": "true"}]}`:                      `stage "b" is not in run`,
+		`{"run": "a || a", "stages": [{"name": "a", "command": "true"}]}`:                                                   `run has stage "a" more than once`,
+		`{"run": "a && b", "stages": [{"name": "a", "command": "true"}, {"name": "b", "command": "true", "needs": ["a"]}]}`: `stage "b" has needs, which can't be used with run`,
+		`{"rollup": "a", "stages": [{"name": "a", "command": "true"}]}`:                                                     `rollup "a" is also the name of a stage`,
+		`{"rollup": "ci\n", "stages": [{"name": "a", "command": "true"}]}`:                                                  "rollup: context",
+	} {
+		if _, err := loadPipeline(writeTempFile(t, "pipeline.json", contents)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q for %s, got %v", expected, contents, err)
+		}
+	}
+}
+
+func TestRunExpression(t *testing.T) {
+	stages := []pipelineStage{{Name: "build"}, {Name: "test"}, {Name: "fallback"}, {Name: "deploy"}}
+	for _, test := range []struct {
+		expr    string
+		codes   map[string]int
+		started []string
+		state   string
+		states  map[string]string
+	}{
+		{"build && test || fallback", nil, []string{"build", "test"}, "success",
+			map[string]string{"build": "success", "test": "success", "fallback": "success (skipped, test succeeded)"}},
+		{"build && test || fallback", map[string]int{"build": 1}, []string{"build", "fallback"}, "success",
+			map[string]string{"build": "failure", "test": "error (skipped, build failed)", "fallback": "success"}},
+		{"build && test || fallback", map[string]int{"test": 1, "fallback": 2}, []string{"build", "test", "fallback"}, "failure",
+			map[string]string{"build": "success", "test": "failure", "fallback": "failure"}},
+		{"build || fallback && deploy", nil, []string{"build", "deploy"}, "success",
+			map[string]string{"build": "success", "fallback": "success (skipped, build succeeded)", "deploy": "success"}},
+		{"build && test && deploy", map[string]int{"build": 1}, []string{"build"}, "failure",
+			map[string]string{"build": "failure", "test": "error (skipped, build failed)", "deploy": "error (skipped, build failed)"}},
+	} {
+		steps, err := parseRunExpression(test.expr)
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		fake := &fakeStages{codes: test.codes}
+		var finished []string
+		outcomes, state := runExpression(steps, stages, fake.run, func(stage pipelineStage, outcome *stageOutcome) {
+			finished = append(finished, stage.Name)
+		})
+		if state != test.state || !reflect.DeepEqual(fake.started, test.started) || len(finished) != len(steps) {
+			t.Errorf("%s with %v: expected %s after running %q, got %s after %q", test.expr, test.codes, test.state, test.started, state, fake.started)
+		}
+		if states := outcomeStates(outcomes); !reflect.DeepEqual(states, test.states) {
+			t.Errorf("%s with %v: expected %v, got %v", test.expr, test.codes, test.states, states)
+		}
+	}
+}
+
+func TestRunPipelineCommandRunExpressionAndRollup(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		posted = append(posted, status.Context+"="+status.State+" "+status.Description)
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.Description = ""
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"run": "ci/build && ci/test || ci/notify", "rollup": "ci/all", "stages": [
+		{"name": "ci/build", "command": "exit 3"},
+		{"name": "ci/test", "command": "true"},
+		{"name": "ci/notify", "command": "true"}
+	]}`)
+	flags.JSONReport = writeTempFile(t, "report.json", "")
+
+	if code := runPipelineCommand(*flags, 2, false); code != 0 {
+		t.Errorf("Expected a recovered pipeline to exit 0, got %d", code)
+	}
+	expected := []string{
+		"ci/build=pending Waiting for build...",
+		"ci/test=pending Waiting for build...",
+		"ci/notify=pending Waiting for build...",
+		"ci/all=pending Waiting for build...",
+		"ci/build=failure Build failed",
+		"ci/test=error skipped, ci/build failed",
+		"ci/notify=success Build passed",
+		"ci/all=success 1 of 3 stages succeeded",
+	}
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("Expected statuses %q, got %q", expected, posted)
+	}
+
+	contents, _ := ioutil.ReadFile(flags.JSONReport)
+	var report pipelineReport
+	json.Unmarshal(contents, &report)
+	if report.State != "success" || report.Rollup != "ci/all" || len(report.Stages) != 3 {
+		t.Errorf("Unexpected report %+v", report)
+	}
+}
+
+func TestRunPipelineCommandRollupContext(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		mu.Lock()
+		posted = append(posted, status.Context+"="+status.State+" "+status.Description)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.Description = ""
+	flags.RollupContext = "ci/all"
+	for _, test := range []struct {
+		lint, rollup string
+		code         int
+	}{
+		{"true", "ci/all=success 3 of 3 stages succeeded", 0},
+		{"exit 1", "ci/all=failure 2 of 3 stages succeeded", 1},
+	} {
+		posted = nil
+		flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"rollup": "ci/ignored", "stages": [
+			{"name": "ci/build", "command": "true"},
+			{"name": "ci/lint", "command": "`+test.lint+`"},
+			{"name": "ci/test", "command": "true", "needs": ["ci/build"]}
+		]}`)
+		if code := runPipelineCommand(*flags, 1, true); code != test.code {
+			t.Errorf("Expected exit code %d, got %d", test.code, code)
+		}
+		if len(posted) != 8 || posted[3] != "ci/all=pending Waiting for build..." || posted[7] != test.rollup {
+			t.Errorf("Expected the rollup pending first and %q last, got %q", test.rollup, posted)
+		}
+	}
+}
+
+func TestValidateFlagsRollupContext(t *testing.T) {
+	flags := defaultFlags()
+	flags.RollupContext = "ci/all"
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "only used by the pipeline subcommand") {
+		t.Errorf("Expected -rollup-context to need the pipeline subcommand, got %v", err)
+	}
+	flags.RollupContext = "ci\n"
+	if err := validateFlags(*flags, nil, "pipeline"); err == nil || !strings.Contains(err.Error(), "-rollup-context: context") {
+		t.Errorf("Expected an invalid -rollup-context to be rejected, got %v", err)
+	}
+}
+
+func TestRunPipelineCommandPostOrder(t *testing.T) {
+	withLogger(t, logError)
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		mu.Lock()
+		posted = append(posted, status.Context+"="+status.State)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/zeta", "command": "true"},
+		{"name": "ci/beta", "command": "true", "needs": ["ci/gamma"]},
+		{"name": "ci/alpha", "command": "true"},
+		{"name": "ci/gamma", "command": "true"}
+	]}`)
+	for _, test := range []struct {
+		order    postOrder
+		expected []string
+	}{
+		{postOrderInput, []string{
+			"ci/zeta=pending", "ci/gamma=pending", "ci/beta=pending", "ci/alpha=pending",
+			"ci/zeta=success", "ci/gamma=success", "ci/beta=success", "ci/alpha=success",
+		}},
+		{postOrderAlpha, []string{
+			"ci/alpha=pending", "ci/beta=pending", "ci/gamma=pending", "ci/zeta=pending",
+			"ci/alpha=success", "ci/gamma=success", "ci/beta=success", "ci/zeta=success",
+		}},
+	} {
+		withPostedStatuses(t)
+		posted = nil
+		flags.PostOrder = test.order
+		if code := runPipelineCommand(*flags, 1, false); code != 0 {
+			t.Errorf("Expected -post-order %q to succeed, got %d", test.order, code)
+		}
+		if !reflect.DeepEqual(posted, test.expected) {
+			t.Errorf("Expected -post-order %q to post %q, got %q", test.order, test.expected, posted)
+		}
+	}
+}
+
+func TestValidateFlagsPostOrder(t *testing.T) {
+	flags := defaultFlags()
+	flags.PostOrder = postOrderAlpha
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-post-order is only used by the pipeline subcommand") {
+		t.Errorf("Expected -post-order to need the pipeline subcommand, got %v", err)
+	}
+	if err := validateFlags(*flags, nil, "pipeline"); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	var order postOrder
+	if err := order.Set("reverse"); err == nil {
+		t.Errorf("Expected an unknown order to be rejected")
+	}
+}
+
+func TestRunPipelineCommandContextDescriptions(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var status CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&status)
+		mu.Lock()
+		posted = append(posted, status.Context+"="+status.State+" "+status.Description)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.ContextDescriptions = "ci/lint=Linting,ci/all=Everything"
+	flags.RollupContext = "ci/all"
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/lint", "command": "true"},
+		{"name": "ci/test", "command": "true"}
+	]}`)
+	if code := runPipelineCommand(*flags, 1, false); code != 0 {
+		t.Errorf("Expected the pipeline to succeed, got %d", code)
+	}
+	expected := []string{
+		"ci/lint=pending Linting",
+		"ci/test=pending unit test",
+		"ci/all=pending Everything",
+		"ci/lint=success Linting",
+		"ci/test=success unit test",
+		"ci/all=success 2 of 2 stages succeeded",
+	}
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("Expected statuses %q, got %q", expected, posted)
+	}
+}
+
+func TestValidateFlagsContextDescriptions(t *testing.T) {
+	flags := defaultFlags()
+	flags.ContextDescriptions = "ci/lint=Linting"
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-context-descriptions is only used by the pipeline subcommand") {
+		t.Errorf("Expected -context-descriptions to need the pipeline subcommand, got %v", err)
+	}
+	flags.ContextDescriptions = "ci/lint"
+	if err := validateFlags(*flags, nil, "pipeline"); err == nil || !strings.Contains(err.Error(), "not in the form context=description") {
+		t.Errorf("Expected an invalid entry to be rejected, got %v", err)
+	}
+}
+
+func TestRunPipelineCommandFiltersStageEnv(t *testing.T) {
+	withLogger(t, logError)
+	os.Setenv("GH_TOKEN", "sup3rsecret")
+	os.Setenv("GH_REPO", "org/repo")
+	defer os.Unsetenv("GH_TOKEN")
+	defer os.Unsetenv("GH_REPO")
+
+	for _, setup := range []func(*Flags){
+		func(flags *Flags) { flags.EnvAllowlist = "GH_*,PATH" },
+		func(flags *Flags) { flags.EnvIsolate = true },
+	} {
+		out := filepath.Join(t.TempDir(), "env")
+		flags := defaultFlags()
+		flags.Dev = true
+		setup(flags)
+		flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [{"name": "ci/env", "command": "env > `+out+`"}]}`)
+		if code := runPipelineCommand(*flags, 1, false); code != 0 {
+			t.Fatalf("Expected the pipeline to succeed, got %d", code)
+		}
+		env, _ := ioutil.ReadFile(out)
+		if strings.Contains(string(env), "GH_TOKEN=") {
+			t.Errorf("Expected the token to be kept from the stage, got:\n%s", env)
+		}
+		if !strings.Contains(string(env), "PATH=") {
+			t.Errorf("Expected PATH to be passed to the stage, got:\n%s", env)
+		}
+	}
+}
+
+func TestRunPipelineCommandInjectsStatusEnv(t *testing.T) {
+	withPostedStatuses(t)
+	withLogger(t, logError)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	dir := t.TempDir()
+	flags := defaultFlags()
+	flags.Auth = "token"
+	flags.InjectStatusEnv = true
+	flags.ScriptFile = writeTempFile(t, "pipeline.json", `{"stages": [
+		{"name": "ci/build", "command": "echo $STATUS_CONTEXT $STATUS_SHA > `+dir+`/build"},
+		{"name": "ci/test", "command": "echo $STATUS_CONTEXT $STATUS_ORG_REPO > `+dir+`/test", "needs": ["ci/build"]}
+	]}`)
+	if code := runPipelineCommand(*flags, 1, false); code != 0 {
+		t.Fatalf("Expected the pipeline to succeed, got %d", code)
+	}
+	for stage, expected := range map[string]string{"build": "ci/build deadbeef\n", "test": "ci/test christopher-bui/gh-status-reporter\n"} {
+		if contents, _ := ioutil.ReadFile(filepath.Join(dir, stage)); string(contents) != expected {
+			t.Errorf("Expected %s to get its own status variables %q, got %q", stage, expected, contents)
+		}
+	}
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+)
+
+const defaultPluginTimeout = 10 * time.Second
+
+// statusEvent describes a state transition of a run. It is the JSON payload
+// given to notification plugins.
+type statusEvent struct {
+	Event       string `json:"event"`
+	Repository  string `json:"repository"`
+	SHA         string `json:"sha"`
+	Context     string `json:"context"`
+	State       string `json:"state"`
+	Description string `json:"description"`
+	TargetUrl   string `json:"target_url"`
+	Timestamp   string `json:"timestamp"`
+	ExitCode    *int   `json:"exit_code,omitempty"`
+	ReporterID  string `json:"reporter_id,omitempty"`
+}
+
+// newStatusEvent builds the event for posting state to target. result is nil
+// until the command has finished.
+func newStatusEvent(flags Flags, target statusTarget, state string, result *commandResult) statusEvent {
+	event := statusEvent{
+		Event:       "pending",
+		Repository:  target.OrgRepo,
+		SHA:         target.SHA,
+		Context:     flags.Context,
+		State:       state,
+		Description: statusDescription(flags, state),
+		TargetUrl:   statusTargetURL(flags, state),
+		Timestamp:   time.Now().UTC().Format(time.RFC3339),
+		ReporterID:  flags.ReporterID,
+	}
+	if result != nil {
+		event.Event = "completed"
+		exitCode := result.ExitCode
+		event.ExitCode = &exitCode
+	}
+	return event
+}
+
+// pluginNotifier runs each notification plugin for every event. Plugins run
+// concurrently with their stdin set to the JSON event, and their failures are
+// counted rather than returned.
+type pluginNotifier struct {
+	Plugins []string
+	Timeout time.Duration
+
+	mu       sync.Mutex
+	failures int
+}
+
+func (n *pluginNotifier) notify(event statusEvent) {
+	payload, err := json.Marshal(event)
+	if err != nil {
+		n.fail(fmt.Errorf("Error converting %+v to json %s.", event, err))
+		return
+	}
+
+	var wg sync.WaitGroup
+	for _, plugin := range n.Plugins {
+		wg.Add(1)
+		go func(plugin string) {
+			defer wg.Done()
+			if err := n.run(plugin, event, payload); err != nil {
+				n.fail(fmt.Errorf("Warning: notify plugin %s failed on %s event: %s", plugin, event.Event, err))
+			}
+		}(plugin)
+	}
+	wg.Wait()
+}
+
+func (n *pluginNotifier) run(plugin string, event statusEvent, payload []byte) error {
+	timeout := n.Timeout
+	if timeout <= 0 {
+		timeout = defaultPluginTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, plugin)
+	cmd.Stdin = bytes.NewReader(payload)
+	cmd.Stdout, cmd.Stderr = os.Stderr, os.Stderr
+	cmd.Env = append(os.Environ(),
+		"STATUS_EVENT="+event.Event,
+		"STATUS_STATE="+event.State,
+		"STATUS_ORG_REPO="+event.Repository,
+		"STATUS_SHA="+event.SHA,
+		"STATUS_CONTEXT="+event.Context,
+		"STATUS_TARGET_URL="+event.TargetUrl,
+	)
+
+	err := cmd.Run()
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("timed out after %s", timeout)
+	}
+	return err
+}
+
+func (n *pluginNotifier) fail(err error) {
+	logger.Errorf("%s", err)
+	n.mu.Lock()
+	n.failures++
+	n.mu.Unlock()
+}
+
+// Failures returns how many plugin invocations have failed so far.
+func (n *pluginNotifier) Failures() int {
+	n.mu.Lock()
+	defer n.mu.Unlock()
+	return n.failures
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExamplePluginReceivesEvents(t *testing.T) {
+	plugin, err := filepath.Abs("examples/notify-plugin.sh")
+	if err != nil {
+		t.Fatal(err)
+	}
+	log := writeTempFile(t, "events.jsonl", "")
+	os.Setenv("NOTIFY_PLUGIN_LOG", log)
+	defer os.Unsetenv("NOTIFY_PLUGIN_LOG")
+
+	flags := defaultFlags()
+	target := statusTarget{OrgRepo: flags.OrgRepo, SHA: flags.SHA}
+	notifier := &pluginNotifier{Plugins: []string{plugin}}
+	notifier.notify(newStatusEvent(*flags, target, "pending", nil))
+	notifier.notify(newStatusEvent(*flags, target, "failure", &commandResult{ExitCode: 2}))
+
+	if notifier.Failures() != 0 {
+		t.Fatalf("Expected the example plugin to succeed, got %d failures", notifier.Failures())
+	}
+
+	file, err := os.Open(log)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	var events []statusEvent
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		var event statusEvent
+		if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+			t.Fatalf("Expected a JSON event per line, got %q: %s", scanner.Text(), err)
+		}
+		events = append(events, event)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected 2 events, got %d", len(events))
+	}
+	if events[0].Event != "pending" || events[0].State != "pending" || events[0].ExitCode != nil {
+		t.Errorf("Unexpected pending event %+v", events[0])
+	}
+	if events[1].Event != "completed" || events[1].State != "failure" || *events[1].ExitCode != 2 {
+		t.Errorf("Unexpected completed event %+v", events[1])
+	}
+	if events[1].SHA != "deadbeef" || events[1].Context != "ci" || events[1].Repository != flags.OrgRepo {
+		t.Errorf("Unexpected event metadata %+v", events[1])
+	}
+}
+
+func TestPluginEnvironment(t *testing.T) {
+	out := writeTempFile(t, "env.txt", "")
+	plugin := writeTempFile(t, "plugin.sh", "#!/bin/sh\necho \"$STATUS_EVENT $STATUS_STATE $STATUS_ORG_REPO $STATUS_SHA $STATUS_CONTEXT\" > "+out+"\n")
+	os.Chmod(plugin, 0700)
+
+	flags := defaultFlags()
+	notifier := &pluginNotifier{Plugins: []string{plugin}}
+	notifier.notify(newStatusEvent(*flags, statusTarget{OrgRepo: "org/a", SHA: "cafe"}, "success", &commandResult{}))
+
+	contents, _ := ioutil.ReadFile(out)
+	if strings.TrimSpace(string(contents)) != "completed success org/a cafe ci" {
+		t.Errorf("Unexpected plugin environment %q", contents)
+	}
+}
+
+func TestPluginFailuresAreCounted(t *testing.T) {
+	failing := writeTempFile(t, "fail.sh", "#!/bin/sh\nexit 3\n")
+	slow := writeTempFile(t, "slow.sh", "#!/bin/sh\nexec sleep 10\n")
+	os.Chmod(failing, 0700)
+	os.Chmod(slow, 0700)
+
+	notifier := &pluginNotifier{Plugins: []string{failing, slow, "gh-status-reporter-missing-plugin"}, Timeout: 200 * time.Millisecond}
+	start := time.Now()
+	notifier.notify(newStatusEvent(*defaultFlags(), statusTarget{}, "pending", nil))
+
+	if notifier.Failures() != 3 {
+		t.Errorf("Expected 3 failures, got %d", notifier.Failures())
+	}
+	if time.Since(start) > 5*time.Second {
+		t.Errorf("Expected the slow plugin to be timed out, took %s", time.Since(start))
+	}
+}
@@ -0,0 +1,177 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/template"
+	"time"
+)
+
+const defaultPRCommentTemplate = `### {{.Context}}: {{.State}}
+
+| | |
+|---|---|
+| Commit | {{.SHA}} |
+| Exit code | {{.ExitCode}}{{if .TimedOut}} (timed out){{end}} |
+| Duration | {{.Duration}} |
+{{- if .Description}}
+| Description | {{.Description}} |
+{{- end}}
+{{if .TargetUrl}}
+[Details]({{.TargetUrl}})
+{{end}}
+{{- if .ArtifactList}}
+{{.ArtifactList}}
+{{- end}}`
+
+// issueComment is the subset of a GitHub issue comment used to find the
+// sticky -pr-comment.
+type issueComment struct {
+	ID   int64  `json:"id"`
+	Body string `json:"body"`
+}
+
+// prCommentData is available to -pr-comment-template.
+type prCommentData struct {
+	Context     string
+	Repository  string
+	SHA         string
+	State       string
+	Description string
+	TargetUrl   string
+	ExitCode    int
+	TimedOut    bool
+	Duration    time.Duration
+	PullRequest int
+	// Artifacts are the -artifact links, and ArtifactList the same rendered
+	// as a Markdown list.
+	Artifacts    []artifact
+	ArtifactList string
+}
+
+// prCommentMarker identifies the comment for a context so later runs edit it
+// instead of adding another one.
+func prCommentMarker(context string) string {
+	return "<!-- gh-status-reporter:" + context + " -->"
+}
+
+// commentOnPullRequests posts or updates the summary comment on the
+// -pr-number pull request, or else on every open pull request containing the
+// commit. pulls is looked up when nil. Problems are printed as warnings and
+// never fail the run.
+func commentOnPullRequests(target statusTarget, pulls []pullRequest, flags Flags, state string, result *commandResult) {
+	if flags.PRNumber > 0 {
+		pulls = []pullRequest{{Number: flags.PRNumber}}
+	}
+	if pulls == nil {
+		var err error
+		pulls, err = openPullRequests(target, flags)
+		if err != nil {
+			logger.Warnf("could not find pull requests to comment on: %s", err)
+			return
+		}
+	}
+	if len(pulls) == 0 {
+		logger.Infof("No open pull request contains %s, not posting a -pr-comment.", target.SHA)
+		return
+	}
+
+	for _, pull := range pulls {
+		body, err := renderPRComment(target, flags, state, result, pull.Number)
+		if err != nil {
+			logger.Warnf("could not render the pull request comment: %s", err)
+			return
+		}
+		if err := upsertComment(target, flags, pull.Number, body); err != nil {
+			logger.Warnf("could not comment on #%d: %s", pull.Number, err)
+		}
+	}
+}
+
+// findMarkedComment returns the comment carrying marker on issue number,
+// following the Link header through all pages, or nil if there is none.
+func findMarkedComment(target statusTarget, flags Flags, number int, marker string) (*issueComment, error) {
+	url := issueURL(target, number) + "/comments?per_page=100"
+	for url != "" {
+		response, err := githubRequest("GET", url, flags, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !response.ok() {
+			return nil, response.error("")
+		}
+		var comments []issueComment
+		if err := json.Unmarshal(response.Body, &comments); err != nil {
+			return nil, fmt.Errorf("Error parsing response from Github: %s", err)
+		}
+		for _, comment := range comments {
+			if strings.Contains(comment.Body, marker) {
+				return &comment, nil
+			}
+		}
+		url = nextPageURL(response.Header.Get("Link"))
+	}
+	return nil, nil
+}
+
+// upsertComment edits the comment carrying the context's marker on issue
+// number, or creates one if there is none, including when it was deleted
+// since it was found.
+func upsertComment(target statusTarget, flags Flags, number int, body string) error {
+	comment, err := findMarkedComment(target, flags, number, prCommentMarker(flags.Context))
+	if err != nil {
+		return err
+	}
+	if comment != nil {
+		url := githubAPIURL + "/repos/" + target.OrgRepo + "/issues/comments/" + strconv.FormatInt(comment.ID, 10)
+		err := githubJSON("PATCH", url, flags, map[string]string{"body": body}, nil)
+		if apiErr, ok := err.(*APIError); !ok || apiErr.StatusCode != http.StatusNotFound {
+			return err
+		}
+		logger.Infof("The comment on #%d was deleted, posting a new one", number)
+	}
+	return commentOnIssue(target, flags, number, body)
+}
+
+// renderPRComment renders the -pr-comment body for pull request number. The
+// marker is always included so custom templates are updated in place too.
+func renderPRComment(target statusTarget, flags Flags, state string, result *commandResult, number int) (string, error) {
+	text := defaultPRCommentTemplate
+	if flags.PRCommentTemplate != "" {
+		contents, err := ioutil.ReadFile(flags.PRCommentTemplate)
+		if err != nil {
+			return "", fmt.Errorf("Error reading pull request comment template: %s", err)
+		}
+		text = string(contents)
+	}
+
+	tmpl, err := template.New("pr-comment").Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing pull request comment template: %s", err)
+	}
+	var body bytes.Buffer
+	body.WriteString(prCommentMarker(flags.Context) + "\n")
+	err = tmpl.Execute(&body, prCommentData{
+		Context:      flags.Context,
+		Repository:   target.OrgRepo,
+		SHA:          target.SHA,
+		State:        state,
+		Description:  statusDescription(flags, state),
+		TargetUrl:    statusTargetURL(flags, state),
+		ExitCode:     result.ExitCode,
+		TimedOut:     result.TimedOut,
+		Duration:     result.Duration.Round(time.Second),
+		PullRequest:  number,
+		Artifacts:    flags.ArtifactLinks,
+		ArtifactList: renderArtifacts(flags.ArtifactLinks),
+	})
+	if err != nil {
+		return "", fmt.Errorf("Error rendering pull request comment template: %s", err)
+	}
+	return body.String(), nil
+}
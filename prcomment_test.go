@@ -0,0 +1,184 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderPRCommentDefaultTemplate(t *testing.T) {
+	flags := defaultFlags()
+	flags.TargetUrl = "https://ci.example.com/1"
+	result := &commandResult{ExitCode: 2, Duration: 90*time.Second + 400*time.Millisecond}
+
+	body, err := renderPRComment(statusTarget{"org/repo", "deadbeef"}, *flags, "failure", result, 7)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := `<!-- gh-status-reporter:ci -->
+### ci: failure
+
+| | |
+|---|---|
+| Commit | deadbeef |
+| Exit code | 2 |
+| Duration | 1m30s |
+| Description | unit test |
+
+[Details](https://ci.example.com/1)
+`
+	if body != expected {
+		t.Errorf("Expected comment:\n%s\ngot:\n%s", expected, body)
+	}
+}
+
+func TestRenderPRCommentCustomTemplate(t *testing.T) {
+	flags := defaultFlags()
+	flags.PRCommentTemplate = writeTempFile(t, "comment.tmpl", "#{{.PullRequest}} {{.Context}} {{.State}}")
+
+	body, err := renderPRComment(statusTarget{"org/repo", "deadbeef"}, *flags, "success", &commandResult{}, 7)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if body != "<!-- gh-status-reporter:ci -->\n#7 ci success" {
+		t.Errorf("Unexpected comment %q", body)
+	}
+}
+
+func TestCommentOnPullRequestsEditsPreviousComment(t *testing.T) {
+	var requests []string
+	var bodies []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		body, _ := ioutil.ReadAll(r.Body)
+		if len(body) > 0 {
+			bodies = append(bodies, string(body))
+		}
+		switch r.URL.Path {
+		case "/repos/org/repo/issues/7/comments":
+			if r.Method == "GET" {
+				json.NewEncoder(w).Encode([]issueComment{
+					{ID: 1, Body: "looks good"},
+					{ID: 2, Body: "<!-- gh-status-reporter:other -->\nother context"},
+					{ID: 3, Body: "<!-- gh-status-reporter:ci -->\nold summary"},
+				})
+				return
+			}
+		case "/repos/org/repo/issues/8/comments":
+			if r.Method == "GET" {
+				w.Write([]byte(`[]`))
+				return
+			}
+			w.WriteHeader(http.StatusCreated)
+		}
+		w.Write([]byte(`{}`))
+	})()
+
+	pulls := []pullRequest{{Number: 7}, {Number: 8}}
+	commentOnPullRequests(statusTarget{"org/repo", "deadbeef"}, pulls, *defaultFlags(), "success", &commandResult{})
+
+	expected := []string{
+		"GET /repos/org/repo/issues/7/comments",
+		"PATCH /repos/org/repo/issues/comments/3",
+		"GET /repos/org/repo/issues/8/comments",
+		"POST /repos/org/repo/issues/8/comments",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, requests)
+	}
+	for _, body := range bodies {
+		if !strings.Contains(body, "ci: success") {
+			t.Errorf("Expected the summary in %s", body)
+		}
+	}
+}
+
+func TestCommentOnPullRequestsWithoutPullRequests(t *testing.T) {
+	var requests []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		w.Write([]byte(`[]`))
+	})()
+
+	commentOnPullRequests(statusTarget{"org/repo", "deadbeef"}, nil, *defaultFlags(), "success", &commandResult{})
+	if !reflect.DeepEqual(requests, []string{"GET /repos/org/repo/commits/deadbeef/pulls"}) {
+		t.Errorf("Expected only the pull request lookup, got %q", requests)
+	}
+}
+
+func TestCommentOnPullRequestNumberFindsCommentOnLaterPage(t *testing.T) {
+	var requests []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.RequestURI())
+		switch {
+		case r.Method == "GET" && r.URL.Query().Get("page") == "":
+			w.Header().Set("Link", "<"+githubAPIURL+"/repos/org/repo/issues/9/comments?per_page=100&page=2>; rel=\"next\"")
+			json.NewEncoder(w).Encode([]issueComment{{ID: 1, Body: "first page"}})
+		case r.Method == "GET":
+			json.NewEncoder(w).Encode([]issueComment{{ID: 5, Body: "<!-- gh-status-reporter:ci -->\nold summary"}})
+		default:
+			w.Write([]byte(`{}`))
+		}
+	})()
+
+	flags := defaultFlags()
+	flags.PRNumber = 9
+	commentOnPullRequests(statusTarget{"org/repo", "deadbeef"}, nil, *flags, "success", &commandResult{})
+	expected := []string{
+		"GET /repos/org/repo/issues/9/comments?per_page=100",
+		"GET /repos/org/repo/issues/9/comments?per_page=100&page=2",
+		"PATCH /repos/org/repo/issues/comments/5",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, requests)
+	}
+}
+
+func TestUpsertCommentRecreatesDeletedComment(t *testing.T) {
+	var requests []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests = append(requests, r.Method+" "+r.URL.Path)
+		switch r.Method {
+		case "GET":
+			json.NewEncoder(w).Encode([]issueComment{{ID: 3, Body: "<!-- gh-status-reporter:ci -->\nold summary"}})
+		case "PATCH":
+			w.WriteHeader(http.StatusNotFound)
+			w.Write([]byte(`{"message":"Not Found"}`))
+		default:
+			w.WriteHeader(http.StatusCreated)
+			w.Write([]byte(`{}`))
+		}
+	})()
+	logs := withLogger(t, logInfo)
+
+	if err := upsertComment(statusTarget{"org/repo", "deadbeef"}, *defaultFlags(), 7, "summary"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := []string{
+		"GET /repos/org/repo/issues/7/comments",
+		"PATCH /repos/org/repo/issues/comments/3",
+		"POST /repos/org/repo/issues/7/comments",
+	}
+	if !reflect.DeepEqual(requests, expected) {
+		t.Errorf("Expected requests %q, got %q", expected, requests)
+	}
+	if !strings.Contains(logs.String(), "The comment on #7 was deleted, posting a new one") {
+		t.Errorf("Expected the recreation to be logged, got %q", logs)
+	}
+}
+
+func TestValidateFlagsPRNumber(t *testing.T) {
+	flags := defaultFlags()
+	flags.PRNumber = 7
+	if err := validateFlags(*flags, []string{"make"}, ""); err == nil || !strings.Contains(err.Error(), "-pr-number requires -pr-comment") {
+		t.Errorf("Expected -pr-number without -pr-comment to be rejected, got %v", err)
+	}
+	flags.PRComment = true
+	if err := validateFlags(*flags, []string{"make"}, ""); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+}
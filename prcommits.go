@@ -0,0 +1,59 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+// maxPullRequestCommits is the most commits Github lists for a pull request.
+const maxPullRequestCommits = 250
+
+// listPullRequestCommits returns the SHAs of pull request number's commits,
+// oldest first, following the Link header through all pages.
+func listPullRequestCommits(repo string, number int, flags Flags) ([]string, error) {
+	var shas []string
+	url := githubAPIURL + "/repos/" + repo + "/pulls/" + strconv.Itoa(number) + "/commits?per_page=100"
+	for page := 1; url != ""; page++ {
+		response, err := githubRequest("GET", url, flags, nil)
+		if err != nil {
+			return nil, err
+		}
+		if !response.ok() {
+			return nil, response.error(fmt.Sprintf("Error listing the commits of pull request #%d", number))
+		}
+		var commits []struct {
+			SHA string `json:"sha"`
+		}
+		if err := json.Unmarshal(response.Body, &commits); err != nil {
+			return nil, fmt.Errorf("Error parsing response from Github: %s", err)
+		}
+		logger.Debugf("Page %d has %d commits", page, len(commits))
+		for _, commit := range commits {
+			shas = append(shas, commit.SHA)
+		}
+		url = nextPageURL(response.Header.Get("Link"))
+	}
+	if len(shas) >= maxPullRequestCommits {
+		logger.Warnf("pull request #%d may have more commits than the %d Github lists, only those get the final status", number, maxPullRequestCommits)
+	}
+	return shas, nil
+}
+
+// pullRequestCommitTargets adds a target in the first target's repository
+// for each of shas that isn't a target already, for -all-pr-commits.
+func pullRequestCommitTargets(targets []statusTarget, shas []string) []statusTarget {
+	all := append([]statusTarget{}, targets...)
+	seen := map[statusTarget]bool{}
+	for _, target := range targets {
+		seen[target] = true
+	}
+	for _, sha := range shas {
+		target := statusTarget{OrgRepo: targets[0].OrgRepo, SHA: sha}
+		if !seen[target] {
+			seen[target] = true
+			all = append(all, target)
+		}
+	}
+	return all
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestListPullRequestCommitsFollowsPages(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/repos/org/repo/pulls/7/commits" {
+			t.Errorf("Unexpected request %s", r.URL)
+		}
+		switch r.URL.Query().Get("page") {
+		case "":
+			w.Header().Set("Link", fmt.Sprintf(`<%s/repos/org/repo/pulls/7/commits?per_page=100&page=2>; rel="next", <%s/repos/org/repo/pulls/7/commits?per_page=100&page=2>; rel="last"`, githubAPIURL, githubAPIURL))
+			fmt.Fprint(w, `[{"sha": "aaa"}, {"sha": "bbb"}]`)
+		case "2":
+			fmt.Fprint(w, `[{"sha": "ccc"}]`)
+		}
+	})()
+
+	shas, err := listPullRequestCommits("org/repo", 7, *defaultFlags())
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if !reflect.DeepEqual(shas, []string{"aaa", "bbb", "ccc"}) {
+		t.Errorf("Expected the commits of both pages, got %q", shas)
+	}
+}
+
+func TestListPullRequestCommitsFails(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "Not Found"}`)
+	})()
+	if _, err := listPullRequestCommits("org/repo", 7, *defaultFlags()); err == nil || !strings.Contains(err.Error(), "pull request #7") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
+
+func TestPullRequestCommitTargets(t *testing.T) {
+	targets := []statusTarget{{"org/repo", "ccc"}, {"org/mirror", "ccc"}}
+	expected := []statusTarget{{"org/repo", "ccc"}, {"org/mirror", "ccc"}, {"org/repo", "aaa"}, {"org/repo", "bbb"}}
+	if all := pullRequestCommitTargets(targets, []string{"aaa", "bbb", "ccc"}); !reflect.DeepEqual(all, expected) {
+		t.Errorf("Expected %v, got %v", expected, all)
+	}
+}
+
+func TestAllPRCommitsAggregatesErrors(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		sha := r.URL.Path[strings.LastIndex(r.URL.Path, "/")+1:]
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		posted = append(posted, sha+"="+params.State)
+		mu.Unlock()
+		if sha == "bbb" || sha == "ddd" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"message": "No commit found"}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	out := withLogger(t, logWarn)
+	flags := defaultFlags()
+	flags.AllPRCommits, flags.PRNumber = true, 7
+	targets := pullRequestCommitTargets([]statusTarget{{"org/repo", "ccc"}}, []string{"aaa", "bbb", "ccc", "ddd"})
+	if err := postStatus(targets, *flags, "success"); err != nil {
+		t.Fatalf("Expected failures on some commits to be tolerated, got %s", err)
+	}
+	sort.Strings(posted)
+	if strings.Join(posted, " ") != "aaa=success bbb=success ccc=success ddd=success" {
+		t.Errorf("Expected the status on every commit, got %q", posted)
+	}
+	if !strings.Contains(out.String(), "2 of 4") || !strings.Contains(out.String(), "org/repo: bbb: ") || !strings.Contains(out.String(), "org/repo: ddd: ") {
+		t.Errorf("Expected the failed commits to be reported together, got:\n%s", out)
+	}
+
+	flags.Strict = true
+	if err := postStatus(targets, *flags, "success"); err == nil || !strings.Contains(err.Error(), "bbb") || !strings.Contains(err.Error(), "ddd") {
+		t.Errorf("Expected -strict to fail with both errors, got %v", err)
+	}
+}
+
+func TestAllPRCommitsRequiresPRNumber(t *testing.T) {
+	flags := defaultFlags()
+	flags.AllPRCommits = true
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-all-pr-commits requires -pr-number") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+	flags.PRNumber = 7
+	if err := validateFlags(*flags, []string{"true"}, ""); err != nil {
+		t.Errorf("Expected -pr-number to be allowed with -all-pr-commits, got %s", err)
+	}
+}
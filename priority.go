@@ -0,0 +1,108 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ioniceClasses maps -ionice class names to ionice's numeric classes.
+var ioniceClasses = map[string]string{"realtime": "1", "best-effort": "2", "idle": "3"}
+
+// parseIonice parses -ionice as class or class/level, e.g. idle or
+// best-effort/7, into ionice arguments.
+func parseIonice(value string) ([]string, error) {
+	parts := strings.SplitN(value, "/", 2)
+	class, ok := ioniceClasses[parts[0]]
+	if !ok {
+		return nil, fmt.Errorf("Error: -ionice class must be realtime, best-effort or idle, got %q", parts[0])
+	}
+	args := []string{"-c", class}
+	if len(parts) == 2 {
+		level, err := strconv.Atoi(parts[1])
+		if err != nil || level < 0 || level > 7 || class == "3" {
+			return nil, fmt.Errorf("Error: invalid -ionice level %q; realtime and best-effort take 0-7, idle none", parts[1])
+		}
+		args = append(args, "-n", parts[1])
+	}
+	return args, nil
+}
+
+// priorityWrapper validates -nice, -ionice and -oom-score-adj for goos and
+// returns the commands to prepend to the command so the priorities apply
+// from its first instruction. Problems are reported before anything runs.
+func priorityWrapper(flags Flags, goos string, lookPath func(string) (string, error)) ([]string, error) {
+	if flags.Nice == 0 && flags.Ionice == "" && flags.OOMScoreAdj == 0 {
+		return nil, nil
+	}
+	if flags.Image != "" {
+		return nil, fmt.Errorf("Error: -nice, -ionice and -oom-score-adj can't be used with -image")
+	}
+	if goos == "windows" || goos == "plan9" {
+		return nil, fmt.Errorf("Error: -nice, -ionice and -oom-score-adj are not supported on %s", goos)
+	}
+	if flags.Nice < -20 || flags.Nice > 19 {
+		return nil, fmt.Errorf("Error: -nice must be between -20 and 19, got %d", flags.Nice)
+	}
+	if flags.OOMScoreAdj < -1000 || flags.OOMScoreAdj > 1000 {
+		return nil, fmt.Errorf("Error: -oom-score-adj must be between -1000 and 1000, got %d", flags.OOMScoreAdj)
+	}
+	if (flags.Ionice != "" || flags.OOMScoreAdj != 0) && goos != "linux" {
+		return nil, fmt.Errorf("Error: -ionice and -oom-score-adj are only supported on linux")
+	}
+
+	var wrapper []string
+	if flags.Nice != 0 {
+		path, err := lookPath("nice")
+		if err != nil {
+			return nil, fmt.Errorf("Error: -nice needs the nice utility: %s", err)
+		}
+		wrapper = append(wrapper, path, "-n", strconv.Itoa(flags.Nice))
+	}
+	if flags.Ionice != "" {
+		args, err := parseIonice(flags.Ionice)
+		if err != nil {
+			return nil, err
+		}
+		path, err := lookPath("ionice")
+		if err != nil {
+			return nil, fmt.Errorf("Error: -ionice needs the ionice utility: %s", err)
+		}
+		wrapper = append(append(wrapper, path), args...)
+	}
+	return wrapper, nil
+}
+
+// applyPriorities wraps cmd and args with the priority utilities.
+func applyPriorities(flags Flags, cmd string, args []string) (string, []string, error) {
+	wrapper, err := priorityWrapper(flags, runtime.GOOS, exec.LookPath)
+	if err != nil || len(wrapper) == 0 {
+		return cmd, args, err
+	}
+	if flags.Nice != 0 {
+		logger.Infof("Running the command with nice %d", flags.Nice)
+	}
+	if flags.Ionice != "" {
+		logger.Infof("Running the command with ionice %s", flags.Ionice)
+	}
+	return wrapper[0], append(append(wrapper[1:], cmd), args...), nil
+}
+
+// setOOMScoreAdj makes process more or less likely to be chosen by the OOM
+// killer, which its children inherit, and confirms the value applied.
+func setOOMScoreAdj(process *os.Process, score int) error {
+	path := fmt.Sprintf("/proc/%d/oom_score_adj", process.Pid)
+	if err := ioutil.WriteFile(path, []byte(strconv.Itoa(score)), 0644); err != nil {
+		return fmt.Errorf("Error setting -oom-score-adj: %s", err)
+	}
+	applied, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("Error reading back -oom-score-adj: %s", err)
+	}
+	logger.Infof("Set oom_score_adj of the command to %s", strings.TrimSpace(string(applied)))
+	return nil
+}
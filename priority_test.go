@@ -0,0 +1,93 @@
+package main
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func fakeLookPath(name string) (string, error) {
+	return "/usr/bin/" + name, nil
+}
+
+func TestPriorityWrapper(t *testing.T) {
+	flags := defaultFlags()
+	flags.Nice = 10
+	flags.Ionice = "best-effort/7"
+	wrapper, err := priorityWrapper(*flags, "linux", fakeLookPath)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	expected := "/usr/bin/nice -n 10 /usr/bin/ionice -c 2 -n 7"
+	if strings.Join(wrapper, " ") != expected {
+		t.Errorf("Expected %q, got %q", expected, strings.Join(wrapper, " "))
+	}
+
+	if wrapper, err := priorityWrapper(*defaultFlags(), "windows", fakeLookPath); err != nil || wrapper != nil {
+		t.Errorf("Expected no wrapper without priority flags, got %q %v", wrapper, err)
+	}
+}
+
+func TestPriorityWrapperRejectsInvalidValues(t *testing.T) {
+	for _, test := range []struct {
+		goos   string
+		modify func(*Flags)
+	}{
+		{"linux", func(f *Flags) { f.Nice = 20 }},
+		{"linux", func(f *Flags) { f.Nice = -21 }},
+		{"linux", func(f *Flags) { f.OOMScoreAdj = 1001 }},
+		{"linux", func(f *Flags) { f.Ionice = "fast" }},
+		{"linux", func(f *Flags) { f.Ionice = "best-effort/8" }},
+		{"linux", func(f *Flags) { f.Ionice = "idle/3" }},
+		{"linux", func(f *Flags) { f.Nice, f.Image = 5, "alpine" }},
+		{"darwin", func(f *Flags) { f.Ionice = "idle" }},
+		{"darwin", func(f *Flags) { f.OOMScoreAdj = 500 }},
+		{"windows", func(f *Flags) { f.Nice = 5 }},
+	} {
+		flags := defaultFlags()
+		test.modify(flags)
+		if _, err := priorityWrapper(*flags, test.goos, fakeLookPath); err == nil {
+			t.Errorf("Expected an error for %+v on %s", flags, test.goos)
+		}
+	}
+}
+
+func TestPrioritiesApplyToCommand(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("reads the niceness from /proc")
+	}
+	if _, err := exec.LookPath("nice"); err != nil {
+		t.Skip("nice is not installed")
+	}
+
+	flags := defaultFlags()
+	flags.Nice = 5
+	flags.OOMScoreAdj = 500
+	cmd, args, err := applyPriorities(*flags, "sh", []string{"-c", "cut -d' ' -f19 /proc/$$/stat; cat /proc/$$/oom_score_adj"})
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	var stdout bytes.Buffer
+	subprocess := exec.Command(cmd, args...)
+	subprocess.Stdout = &stdout
+	result := runCommand(subprocess, commandOptions{AfterStart: func(process *os.Process) error {
+		return setOOMScoreAdj(process, flags.OOMScoreAdj)
+	}})
+	if result.Err != nil {
+		t.Fatalf("Got unexpected error: %s", result.Err)
+	}
+	lines := strings.Fields(stdout.String())
+	if len(lines) != 2 || lines[0] != "5" {
+		t.Errorf("Expected the command to run with niceness 5, got %q", stdout.String())
+	}
+	// Whether the score is applied before sh reads it is racy, so only check
+	// it was written.
+	if score, _ := ioutil.ReadFile("/proc/self/oom_score_adj"); strings.TrimSpace(string(score)) == "500" {
+		t.Errorf("Expected the reporter's own oom_score_adj to be left alone")
+	}
+}
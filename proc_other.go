@@ -0,0 +1,68 @@
+//go:build windows || plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"strings"
+)
+
+// cleanupSignals are the signals that run the exit cleanups before ending
+// the process.
+var cleanupSignals = []os.Signal{os.Interrupt}
+
+// setProcessGroup is a no-op on platforms without Unix process groups.
+func setProcessGroup(cmd *exec.Cmd) {}
+
+// terminateProcessGroup kills the command directly, as there is no portable
+// way to ask it to exit gracefully on these platforms.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+func killProcessGroup(cmd *exec.Cmd) error {
+	return cmd.Process.Kill()
+}
+
+// signalExitCode is 1 on these platforms, which have no shell convention
+// for processes killed by a signal.
+func signalExitCode(sig os.Signal) int {
+	return 1
+}
+
+// commandExitCode is the command's exit code, or 1 if it didn't exit
+// normally.
+func commandExitCode(err error) int {
+	return exitCode(err)
+}
+
+// canLock is false as there is no flock on these platforms.
+const canLock = false
+
+func lockFile(file *os.File, wait bool) (bool, error) {
+	return false, errors.New("locking files isn't supported on this platform")
+}
+
+// canHideInput is false as there is no stty on these platforms, so there is
+// no token prompt.
+const canHideInput = false
+
+func disableEcho(terminal *os.File) (func(), error) {
+	return nil, errors.New("hiding input isn't supported on this platform")
+}
+
+// canDetach is false as a process started here may be ended along with this
+// one, e.g. by a Windows job object, so -async-final posts synchronously.
+const canDetach = false
+
+func detachProcess(cmd *exec.Cmd) {}
+
+// isConnectionReset reports whether err is the peer resetting the
+// connection, by its message as these platforms' errors don't all have an
+// ECONNRESET.
+func isConnectionReset(err error) bool {
+	message := err.Error()
+	return strings.Contains(message, "connection reset") || strings.Contains(message, "forcibly closed")
+}
@@ -0,0 +1,123 @@
+//go:build !windows && !plan9
+
+package main
+
+import (
+	"errors"
+	"os"
+	"os/exec"
+	"sync"
+	"syscall"
+)
+
+// cleanupSignals are the signals that run the exit cleanups before ending
+// the process.
+var cleanupSignals = []os.Signal{os.Interrupt, syscall.SIGTERM, syscall.SIGHUP}
+
+// setProcessGroup starts cmd in its own process group so signals reach any
+// processes it spawns.
+func setProcessGroup(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+}
+
+// signalTarget is the pid to signal for cmd: its process group if it was
+// started in one, otherwise the process alone.
+func signalTarget(cmd *exec.Cmd) int {
+	if cmd.SysProcAttr != nil && cmd.SysProcAttr.Setpgid {
+		return -cmd.Process.Pid
+	}
+	return cmd.Process.Pid
+}
+
+// terminateProcessGroup asks cmd's process group to exit with SIGTERM.
+func terminateProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(signalTarget(cmd), syscall.SIGTERM)
+}
+
+// killProcessGroup sends SIGKILL to cmd's process group.
+func killProcessGroup(cmd *exec.Cmd) error {
+	return syscall.Kill(signalTarget(cmd), syscall.SIGKILL)
+}
+
+// signalExitCode is the exit code a shell reports for a process killed by
+// sig.
+func signalExitCode(sig os.Signal) int {
+	if number, ok := sig.(syscall.Signal); ok {
+		return 128 + int(number)
+	}
+	return 1
+}
+
+// commandExitCode is the exit code a shell reports for a command that ended
+// with err: 128+n when a signal n killed it.
+func commandExitCode(err error) int {
+	if exitErr, ok := err.(*exec.ExitError); ok {
+		if status, ok := exitErr.Sys().(syscall.WaitStatus); ok && status.Signaled() {
+			return signalExitCode(status.Signal())
+		}
+	}
+	return exitCode(err)
+}
+
+// canLock reports whether lockFile works on this platform.
+const canLock = true
+
+// lockFile takes an exclusive flock on file. Without wait it returns false
+// instead of blocking when another process holds the lock.
+func lockFile(file *os.File, wait bool) (bool, error) {
+	how := syscall.LOCK_EX
+	if !wait {
+		how |= syscall.LOCK_NB
+	}
+	for {
+		err := syscall.Flock(int(file.Fd()), how)
+		switch err {
+		case nil:
+			return true, nil
+		case syscall.EWOULDBLOCK:
+			return false, nil
+		case syscall.EINTR:
+			continue
+		}
+		return false, err
+	}
+}
+
+// canHideInput reports whether disableEcho works on this platform.
+const canHideInput = true
+
+// disableEcho stops terminal from echoing what is typed, with stty, and
+// returns the function that turns echoing back on.
+func disableEcho(terminal *os.File) (func(), error) {
+	stty := func(arg string) error {
+		cmd := exec.Command("stty", arg)
+		cmd.Stdin = terminal
+		return cmd.Run()
+	}
+	if err := stty("-echo"); err != nil {
+		return nil, err
+	}
+	var once sync.Once
+	return func() { once.Do(func() { stty("echo") }) }, nil
+}
+
+// canDetach reports whether detachProcess works on this platform.
+const canDetach = true
+
+// detachProcess starts cmd in a session of its own, so it outlives this
+// process and isn't signaled along with the terminal or CI step.
+func detachProcess(cmd *exec.Cmd) {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setsid = true
+}
+
+// isConnectionReset reports whether err is the peer resetting the
+// connection.
+func isConnectionReset(err error) bool {
+	return errors.Is(err, syscall.ECONNRESET)
+}
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+)
+
+const defaultProgressInterval = 30 * time.Second
+
+// maxProgressLine bounds how much of a single output line is buffered while
+// looking for -progress-regex matches.
+const maxProgressLine = 4096
+
+// progressReporter watches the command's output for lines matching pattern
+// and periodically re-posts the pending status with the latest match and,
+// with a counter, the running -count-regex counts. Updates are sent at most
+// once per interval, and only when the progress text has changed since the
+// last one.
+type progressReporter struct {
+	pattern  *regexp.Regexp
+	counter  *resultCounter
+	interval time.Duration
+	post     func(progress string)
+
+	mu     sync.Mutex
+	latest string
+	posted string
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func newProgressReporter(pattern *regexp.Regexp, interval time.Duration, post func(string)) *progressReporter {
+	if interval <= 0 {
+		interval = defaultProgressInterval
+	}
+	return &progressReporter{pattern: pattern, interval: interval, post: post}
+}
+
+// compileProgressRegex validates -progress-regex and -progress-interval. The
+// pattern is nil without -progress-regex.
+func compileProgressRegex(flags Flags) (*regexp.Regexp, error) {
+	if flags.ProgressInterval < 0 {
+		return nil, fmt.Errorf("Error: -progress-interval must not be negative")
+	}
+	if flags.ProgressRegex == "" {
+		return nil, nil
+	}
+	pattern, err := regexp.Compile(flags.ProgressRegex)
+	if err != nil {
+		return nil, fmt.Errorf("Error: invalid -progress-regex %q: %s", flags.ProgressRegex, err)
+	}
+	return pattern, nil
+}
+
+// progressText extracts the progress from line: the first capture group if
+// the pattern has one, otherwise the whole match.
+func progressText(pattern *regexp.Regexp, line string) (string, bool) {
+	match := pattern.FindStringSubmatch(line)
+	if match == nil {
+		return "", false
+	}
+	text := match[0]
+	if len(match) > 1 {
+		text = match[1]
+	}
+	text = strings.TrimSpace(text)
+	return text, text != ""
+}
+
+// stream returns a writer for one of the command's output streams. Each
+// stream has its own line buffer so stdout and stderr lines don't mix.
+func (p *progressReporter) stream() io.Writer {
+	return &progressStream{reporter: p}
+}
+
+func (p *progressReporter) observe(line string) {
+	if p.counter != nil {
+		p.counter.observe(line)
+	}
+	if p.pattern == nil {
+		return
+	}
+	if text, ok := progressText(p.pattern, line); ok {
+		p.mu.Lock()
+		p.latest = text
+		p.mu.Unlock()
+	}
+}
+
+// Start begins posting progress updates in the background.
+func (p *progressReporter) Start() {
+	p.stop = make(chan struct{})
+	p.done = make(chan struct{})
+	go func() {
+		defer close(p.done)
+		ticker := time.NewTicker(p.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.update()
+			}
+		}
+	}()
+}
+
+// Stop ends progress updates, waiting for one in flight to finish. No update
+// is posted after Stop returns.
+func (p *progressReporter) Stop() {
+	if p.stop == nil {
+		return
+	}
+	close(p.stop)
+	<-p.done
+}
+
+func (p *progressReporter) update() {
+	counts := ""
+	if p.counter != nil {
+		counts = p.counter.summary()
+	}
+	p.mu.Lock()
+	latest := p.latest
+	if counts != "" && latest != "" {
+		latest += ", " + counts + " so far"
+	} else if counts != "" {
+		latest = counts + " so far"
+	}
+	changed := latest != p.posted
+	p.posted = latest
+	p.mu.Unlock()
+
+	if changed {
+		p.post(latest)
+	}
+}
+
+// progressStream splits one output stream into lines for its reporter.
+type progressStream struct {
+	reporter *progressReporter
+	line     []byte
+}
+
+func (s *progressStream) Write(p []byte) (int, error) {
+	for rest := p; len(rest) > 0; {
+		i := bytes.IndexByte(rest, '\n')
+		if i < 0 {
+			s.buffer(rest)
+			break
+		}
+		s.buffer(rest[:i])
+		rest = rest[i+1:]
+		s.reporter.observe(string(s.line))
+		s.line = s.line[:0]
+	}
+	return len(p), nil
+}
+
+func (s *progressStream) buffer(p []byte) {
+	if room := maxProgressLine - len(s.line); len(p) > room {
+		p = p[:room]
+	}
+	s.line = append(s.line, p...)
+}
+
+// progressDescription is the pending description carrying progress.
+func progressDescription(flags Flags, progress string) string {
+	return appendSuffix(flags.Description, truncateDescription(progress, maxDescriptionLength/2))
+}
+
+// postProgress re-posts the pending status to targets with progress in the
+// description. Failures are only printed; they never affect the run.
+func postProgress(targets []statusTarget, flags Flags, progress string) {
+	// After -run-timeout the timeout posts the final status.
+	runDeadline.hold()
+	flags.Description = progressDescription(flags, progress)
+	if err := postStatus(targets, flags, "pending"); err != nil {
+		logger.Warnf("failed to post progress %q: %s", progress, err)
+	}
+}
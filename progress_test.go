@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bytes"
+	"os/exec"
+	"regexp"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestProgressText(t *testing.T) {
+	for _, test := range []struct {
+		pattern, line, expected string
+		ok                      bool
+	}{
+		{`step \d+/\d+`, "running step 3/10 now", "step 3/10", true},
+		{`\[(\d+%)\]`, "[ 42%] compiling", "", false},
+		{`\[\s*(\d+%)\]`, "[ 42%] compiling", "42%", true},
+		{`step \d+/\d+`, "unrelated", "", false},
+		{`^(\s*)`, "   x", "", false},
+	} {
+		text, ok := progressText(regexp.MustCompile(test.pattern), test.line)
+		if text != test.expected || ok != test.ok {
+			t.Errorf("Expected %q to give (%q, %v) for %q, got (%q, %v)", test.pattern, test.expected, test.ok, test.line, text, ok)
+		}
+	}
+}
+
+func TestProgressStreamSplitsLines(t *testing.T) {
+	progress := newProgressReporter(regexp.MustCompile(`step \d+`), time.Second, nil)
+	stream := progress.stream()
+	stream.Write([]byte("step 1\nste"))
+	stream.Write([]byte("p 2"))
+	if progress.latest != "step 1" {
+		t.Errorf("Expected partial lines to be held back, got %q", progress.latest)
+	}
+	stream.Write([]byte("\nother\n"))
+	if progress.latest != "step 2" {
+		t.Errorf("Expected latest progress %q, got %q", "step 2", progress.latest)
+	}
+}
+
+func TestProgressUpdatesOnlyWhenChanged(t *testing.T) {
+	var posted []string
+	progress := newProgressReporter(regexp.MustCompile(`step \d+`), time.Second, func(p string) {
+		posted = append(posted, p)
+	})
+
+	progress.update()
+	progress.observe("step 1")
+	progress.update()
+	progress.update()
+	progress.observe("step 2")
+	progress.observe("step 3")
+	progress.update()
+
+	expected := []string{"step 1", "step 3"}
+	if strings.Join(posted, ",") != strings.Join(expected, ",") {
+		t.Errorf("Expected updates %q, got %q", expected, posted)
+	}
+}
+
+func TestRunCommandReportsProgress(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	progress := newProgressReporter(regexp.MustCompile(`step (\d+/\d+)`), 20*time.Millisecond, func(p string) {
+		mu.Lock()
+		posted = append(posted, p)
+		mu.Unlock()
+	})
+
+	var stdout, stderr bytes.Buffer
+	subprocess := exec.Command("sh", "-c", "echo step 1/2; sleep 0.2; echo step 2/2 1>&2; sleep 0.2")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stderr
+
+	progress.Start()
+	runCommand(subprocess, commandOptions{Progress: progress})
+	progress.Stop()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(posted, ",") != "1/2,2/2" {
+		t.Errorf("Expected progress updates 1/2 and 2/2, got %q", posted)
+	}
+}
+
+func TestProgressDescription(t *testing.T) {
+	flags := defaultFlags()
+	if description := progressDescription(*flags, "step 3/10"); description != "unit test (step 3/10)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+
+	description := progressDescription(*flags, strings.Repeat("x", 500))
+	if len(description) > maxDescriptionLength {
+		t.Errorf("Expected description within %d characters, got %d", maxDescriptionLength, len(description))
+	}
+}
+
+func TestCompileProgressRegexRejectsInvalidPattern(t *testing.T) {
+	flags := defaultFlags()
+	flags.ProgressRegex = "step ("
+	if _, err := compileProgressRegex(*flags); err == nil {
+		t.Errorf("Expected an error for an invalid -progress-regex")
+	}
+}
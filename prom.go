@@ -0,0 +1,79 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strconv"
+	"strings"
+)
+
+// promMetric is one metric family in a textfile collector file.
+type promMetric struct {
+	Name string
+	Help string
+	Type string
+	// Value returns the sample for a repository.
+	Value func(repo string) float64
+}
+
+var promLabelEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// promLabels formats a label set in the exposition format.
+func promLabels(names []string, values []string) string {
+	pairs := make([]string, len(names))
+	for i, name := range names {
+		pairs[i] = name + `="` + promLabelEscaper.Replace(values[i]) + `"`
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+// renderPromMetrics renders the metrics of a finished run, one series per
+// repository, in the Prometheus text exposition format.
+func renderPromMetrics(flags Flags, repos []string, state string, result *commandResult, postFailures map[string]int) []byte {
+	finished := float64(now().Unix())
+	flaky := 0
+	if len(result.Attempts) > 1 && result.ExitCode == 0 {
+		flaky = 1
+	}
+	metrics := []promMetric{
+		{"ghsr_command_duration_seconds", "How long the command ran.", "gauge",
+			func(string) float64 { return result.Duration.Seconds() }},
+		{"ghsr_command_exit_code", "Exit code of the command.", "gauge",
+			func(string) float64 { return float64(result.ExitCode) }},
+		{"ghsr_command_attempts", "How many times the command was run.", "gauge",
+			func(string) float64 { return float64(len(result.Attempts)) }},
+		{"ghsr_command_flaky_total", "Runs where the command only passed after a retry.", "counter",
+			func(string) float64 { return float64(flaky) }},
+		{"ghsr_status_post_failures_total", "Commit status posts that failed during the run.", "counter",
+			func(repo string) float64 { return float64(postFailures[repo]) }},
+		{"ghsr_last_run_timestamp_seconds", "Unix time the run finished.", "gauge",
+			func(string) float64 { return finished }},
+	}
+
+	var out bytes.Buffer
+	for _, metric := range metrics {
+		fmt.Fprintf(&out, "# HELP %s %s\n", metric.Name, metric.Help)
+		fmt.Fprintf(&out, "# TYPE %s %s\n", metric.Name, metric.Type)
+		for _, repo := range repos {
+			labels := promLabels([]string{"repo", "context", "state"}, []string{repo, flags.Context, state})
+			fmt.Fprintf(&out, "%s%s %s\n", metric.Name, labels, strconv.FormatFloat(metric.Value(repo), 'g', -1, 64))
+		}
+	}
+	return out.Bytes()
+}
+
+// writePromTextfile atomically replaces path with the run's metrics, so the
+// textfile collector never reads a partial file.
+func writePromTextfile(path string, metrics []byte) error {
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, metrics, 0644); err != nil {
+		return fmt.Errorf("Error writing metrics %s: %s", path, err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("Error writing metrics %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,123 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+var (
+	promCommentPattern = regexp.MustCompile(`^# (HELP|TYPE) ([a-zA-Z_:][a-zA-Z0-9_:]*) (.*)$`)
+	promSamplePattern  = regexp.MustCompile(`^([a-zA-Z_:][a-zA-Z0-9_:]*)\{((?:[a-zA-Z_][a-zA-Z0-9_]*="(?:[^"\\\n]|\\[\\"n])*",?)*)\} (\S+)$`)
+)
+
+// parsePromText checks metrics against the text exposition format and
+// returns the samples by series.
+func parsePromText(t *testing.T, metrics []byte) map[string]float64 {
+	samples := map[string]float64{}
+	types := map[string]string{}
+	scanner := bufio.NewScanner(bytes.NewReader(metrics))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if match := promCommentPattern.FindStringSubmatch(line); match != nil {
+			if match[1] == "TYPE" {
+				if match[3] != "gauge" && match[3] != "counter" {
+					t.Errorf("Unexpected metric type in %q", line)
+				}
+				types[match[2]] = match[3]
+			}
+			continue
+		}
+		match := promSamplePattern.FindStringSubmatch(line)
+		if match == nil {
+			t.Errorf("Invalid exposition line %q", line)
+			continue
+		}
+		if types[match[1]] == "" {
+			t.Errorf("Sample %q comes before its TYPE line", line)
+		}
+		value, err := strconv.ParseFloat(match[3], 64)
+		if err != nil {
+			t.Errorf("Invalid sample value in %q", line)
+		}
+		samples[match[1]+"{"+match[2]+"}"] = value
+	}
+	return samples
+}
+
+func TestRenderPromMetrics(t *testing.T) {
+	defer withClock(time.Unix(1500000000, 0))()
+
+	result := &commandResult{ExitCode: 2, Duration: 1500 * time.Millisecond, Attempts: []commandAttempt{{ExitCode: 2}}}
+	metrics := renderPromMetrics(*defaultFlags(), []string{"org/one", "org/two"}, "failure", result, map[string]int{"org/two": 3})
+	samples := parsePromText(t, metrics)
+
+	expected := map[string]float64{
+		`ghsr_command_duration_seconds{repo="org/one",context="ci",state="failure"}`:   1.5,
+		`ghsr_command_duration_seconds{repo="org/two",context="ci",state="failure"}`:   1.5,
+		`ghsr_command_exit_code{repo="org/one",context="ci",state="failure"}`:          2,
+		`ghsr_command_exit_code{repo="org/two",context="ci",state="failure"}`:          2,
+		`ghsr_command_attempts{repo="org/one",context="ci",state="failure"}`:           1,
+		`ghsr_command_attempts{repo="org/two",context="ci",state="failure"}`:           1,
+		`ghsr_command_flaky_total{repo="org/one",context="ci",state="failure"}`:        0,
+		`ghsr_command_flaky_total{repo="org/two",context="ci",state="failure"}`:        0,
+		`ghsr_status_post_failures_total{repo="org/one",context="ci",state="failure"}`: 0,
+		`ghsr_status_post_failures_total{repo="org/two",context="ci",state="failure"}`: 3,
+		`ghsr_last_run_timestamp_seconds{repo="org/one",context="ci",state="failure"}`: 1500000000,
+		`ghsr_last_run_timestamp_seconds{repo="org/two",context="ci",state="failure"}`: 1500000000,
+	}
+	if len(samples) != len(expected) {
+		t.Errorf("Expected %d samples, got %d:\n%s", len(expected), len(samples), metrics)
+	}
+	for series, value := range expected {
+		if got, ok := samples[series]; !ok || got != value {
+			t.Errorf("Expected %s to be %v, got %v (present: %v)", series, value, got, ok)
+		}
+	}
+}
+
+func TestRenderPromMetricsEscapesLabels(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = "ci \"unit\"\\path\nnext"
+
+	metrics := renderPromMetrics(*flags, []string{"org/repo"}, "success", &commandResult{}, nil)
+	parsePromText(t, metrics)
+	if !strings.Contains(string(metrics), `context="ci \"unit\"\\path\nnext"`) {
+		t.Errorf("Expected the context to be escaped, got:\n%s", metrics)
+	}
+}
+
+func TestWritePromTextfileReplacesFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ghsr.prom")
+	ioutil.WriteFile(path, []byte("old"), 0644)
+
+	if err := writePromTextfile(path, []byte("new\n")); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	contents, _ := ioutil.ReadFile(path)
+	if string(contents) != "new\n" {
+		t.Errorf("Expected the file to be replaced, got %q", contents)
+	}
+	matches, _ := filepath.Glob(path + "*")
+	if len(matches) != 1 {
+		t.Errorf("Expected no temporary file to be left behind, got %q", matches)
+	}
+}
+
+func TestRenderPromMetricsFlaky(t *testing.T) {
+	result := &commandResult{Attempts: []commandAttempt{{ExitCode: 1}, {ExitCode: 0}}}
+	samples := parsePromText(t, renderPromMetrics(*defaultFlags(), []string{"org/repo"}, "success", result, nil))
+
+	if samples[`ghsr_command_flaky_total{repo="org/repo",context="ci",state="success"}`] != 1 {
+		t.Errorf("Expected a flaky run to be counted, got %v", samples)
+	}
+	if samples[`ghsr_command_attempts{repo="org/repo",context="ci",state="success"}`] != 2 {
+		t.Errorf("Expected two attempts, got %v", samples)
+	}
+}
@@ -0,0 +1,37 @@
+package main
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+)
+
+// shouldPromptForToken reports whether to ask for a token: when no source
+// had one, on an interactive terminal, and unless -no-prompt is given.
+func shouldPromptForToken(flags Flags, stdin, stderr *os.File) bool {
+	return flags.Auth == "" && flags.Transport != transportGH && !flags.NoPrompt && canHideInput && isInteractive(stdin) && isInteractive(stderr)
+}
+
+// promptToken asks for a token on terminal, with hide turning off the echo
+// of what is typed while it is read.
+func promptToken(terminal *os.File, prompt io.Writer, hide func(*os.File) (func(), error)) (string, error) {
+	restore, err := hide(terminal)
+	if err != nil {
+		return "", fmt.Errorf("Error hiding the token input: %s", err)
+	}
+	// Echo must come back even if the prompt is interrupted.
+	onExit(restore)
+	cleanupOnSignal()
+	defer stopCleanupOnSignal()
+
+	fmt.Fprint(prompt, "GitHub token (input hidden): ")
+	line, err := bufio.NewReader(terminal).ReadString('\n')
+	restore()
+	fmt.Fprintln(prompt)
+	if err != nil && err != io.EOF {
+		return "", fmt.Errorf("Error reading the token: %s", err)
+	}
+	return strings.TrimSpace(line), nil
+}
@@ -0,0 +1,64 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestPromptTokenHidesInput(t *testing.T) {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reader.Close()
+	writer.WriteString("  ghp_typed\n")
+	writer.Close()
+
+	var hidden, restored bool
+	hide := func(*os.File) (func(), error) {
+		hidden = true
+		return func() { restored = true }, nil
+	}
+	var prompt bytes.Buffer
+	token, err := promptToken(reader, &prompt, hide)
+	if err != nil || token != "ghp_typed" {
+		t.Fatalf("Expected the typed token, got %q, %v", token, err)
+	}
+	if !hidden || !restored {
+		t.Errorf("Expected echo to be turned off and back on, got %t and %t", hidden, restored)
+	}
+	if prompt.String() != "GitHub token (input hidden): \n" {
+		t.Errorf("Unexpected prompt %q", prompt.String())
+	}
+}
+
+func TestShouldPromptForTokenOnlyInteractively(t *testing.T) {
+	null, err := os.Open(os.DevNull)
+	if err != nil {
+		t.Skipf("No null device: %s", err)
+	}
+	defer null.Close()
+	reader, writer, _ := os.Pipe()
+	defer reader.Close()
+	defer writer.Close()
+
+	if shouldPromptForToken(Flags{}, null, null) {
+		t.Error("Expected no prompt with the null device as stdin")
+	}
+	if shouldPromptForToken(Flags{}, reader, writer) {
+		t.Error("Expected no prompt with pipes as stdin and stderr")
+	}
+	if shouldPromptForToken(Flags{Auth: "token"}, reader, writer) {
+		t.Error("Expected no prompt when a token is configured")
+	}
+}
+
+func TestCLIMissingTokenFailsWithoutPrompt(t *testing.T) {
+	out, code := runCLIWithEnv(t, []string{"GH_TOKEN=", "GITHUB_TOKEN=", "GH_ENTERPRISE_TOKEN="},
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "true")
+	if code != 1 || !strings.Contains(out, "No auth token or password provided") || strings.Contains(out, "GitHub token (input hidden)") {
+		t.Errorf("Expected the missing token error straight away, got %d:\n%s", code, out)
+	}
+}
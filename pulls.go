@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// pullRequest is the subset of a GitHub pull request the reporter uses.
+type pullRequest struct {
+	Number  int    `json:"number"`
+	HTMLURL string `json:"html_url"`
+	State   string `json:"state"`
+	Title   string `json:"title"`
+}
+
+// requirePRMode is the value of the -require-pr flag. It may be given
+// without a value, which skips reporting when there is no open pull request.
+type requirePRMode string
+
+const (
+	requirePROff  requirePRMode = ""
+	requirePRSkip requirePRMode = "skip"
+	requirePRFail requirePRMode = "fail"
+)
+
+func (m *requirePRMode) String() string {
+	return string(*m)
+}
+
+func (m *requirePRMode) Set(value string) error {
+	switch value {
+	case "true", "skip":
+		*m = requirePRSkip
+	case "fail":
+		*m = requirePRFail
+	case "false", "":
+		*m = requirePROff
+	default:
+		return fmt.Errorf("expected skip or fail, got %q", value)
+	}
+	return nil
+}
+
+func (m *requirePRMode) IsBoolFlag() bool {
+	return true
+}
+
+// openPullRequests lists the open pull requests that contain target's commit.
+func openPullRequests(target statusTarget, flags Flags) ([]pullRequest, error) {
+	url := githubAPIURL + "/repos/" + target.OrgRepo + "/commits/" + target.SHA + "/pulls?per_page=100"
+
+	var pulls []pullRequest
+	if err := getGithubJSON(url, flags, &pulls); err != nil {
+		return nil, err
+	}
+
+	var open []pullRequest
+	for _, pull := range pulls {
+		if pull.State == "open" {
+			open = append(open, pull)
+		}
+	}
+	return open, nil
+}
+
+// pullRequestEnv describes pulls to the command. STATUS_PR_NUMBER and
+// STATUS_PR_URL hold the first pull request, STATUS_PR_NUMBERS and
+// STATUS_PR_URLS all of them, space separated.
+func pullRequestEnv(pulls []pullRequest) []string {
+	if len(pulls) == 0 {
+		return nil
+	}
+	var numbers, urls []string
+	for _, pull := range pulls {
+		numbers = append(numbers, strconv.Itoa(pull.Number))
+		urls = append(urls, pull.HTMLURL)
+	}
+	return []string{
+		"STATUS_PR_NUMBER=" + numbers[0],
+		"STATUS_PR_URL=" + urls[0],
+		"STATUS_PR_NUMBERS=" + strings.Join(numbers, " "),
+		"STATUS_PR_URLS=" + strings.Join(urls, " "),
+	}
+}
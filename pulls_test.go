@@ -0,0 +1,56 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"reflect"
+	"testing"
+)
+
+func TestOpenPullRequests(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		expectedPath := "/repos/christopher-bui/gh-status-reporter/commits/deadbeef/pulls"
+		if r.URL.Path != expectedPath {
+			t.Errorf("Expected request to %s, got %s", expectedPath, r.URL.Path)
+		}
+		fmt.Fprintln(w, `[
+		  {"number": 12, "html_url": "https://github.com/o/r/pull/12", "state": "open"},
+		  {"number": 9, "html_url": "https://github.com/o/r/pull/9", "state": "closed"},
+		  {"number": 15, "html_url": "https://github.com/o/r/pull/15", "state": "open"}
+		]`)
+	})()
+
+	flags := defaultFlags()
+	pulls, err := openPullRequests(statusTarget{flags.OrgRepo, flags.SHA}, *flags)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(pulls) != 2 || pulls[0].Number != 12 || pulls[1].Number != 15 {
+		t.Errorf("Expected open pull requests 12 and 15, got %+v", pulls)
+	}
+
+	expectedEnv := []string{
+		"STATUS_PR_NUMBER=12",
+		"STATUS_PR_URL=https://github.com/o/r/pull/12",
+		"STATUS_PR_NUMBERS=12 15",
+		"STATUS_PR_URLS=https://github.com/o/r/pull/12 https://github.com/o/r/pull/15",
+	}
+	if env := pullRequestEnv(pulls); !reflect.DeepEqual(env, expectedEnv) {
+		t.Errorf("Expected env %q, got %q", expectedEnv, env)
+	}
+	if env := pullRequestEnv(nil); env != nil {
+		t.Errorf("Expected no env without pull requests, got %q", env)
+	}
+}
+
+func TestRequirePRModeFlag(t *testing.T) {
+	var mode requirePRMode
+	for value, expected := range map[string]requirePRMode{"true": requirePRSkip, "fail": requirePRFail, "false": requirePROff} {
+		if err := mode.Set(value); err != nil || mode != expected {
+			t.Errorf("Expected %q to select %q, got %q (%v)", value, expected, mode, err)
+		}
+	}
+	if err := mode.Set("sometimes"); err == nil {
+		t.Error("Expected an error for an unknown mode")
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+	"os/exec"
+	"regexp"
+	"strings"
+)
+
+var fullSHAPattern = regexp.MustCompile(`^[0-9a-fA-F]{40}$`)
+
+// parseRange splits a -range value of the form base..head.
+func parseRange(value string) (string, string, error) {
+	parts := strings.Split(value, "..")
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" || strings.HasPrefix(parts[1], ".") {
+		return "", "", fmt.Errorf("Error: -range must be in the form base..head, got %q", value)
+	}
+	return parts[0], parts[1], nil
+}
+
+// resolveRef returns the commit SHA ref names. Full SHAs are used as-is, so a
+// checkout is only needed for branch, tag and abbreviated names.
+func resolveRef(ref string) (string, error) {
+	if fullSHAPattern.MatchString(ref) {
+		return strings.ToLower(ref), nil
+	}
+	out, err := exec.Command("git", "rev-parse", "--verify", "--quiet", ref+"^{commit}").Output()
+	if err != nil {
+		return "", fmt.Errorf("Error: can't resolve %q to a commit", ref)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// resolveRange resolves both endpoints of a -range value.
+func resolveRange(value string) (string, string, error) {
+	base, head, err := parseRange(value)
+	if err != nil {
+		return "", "", err
+	}
+	if base, err = resolveRef(base); err != nil {
+		return "", "", err
+	}
+	if head, err = resolveRef(head); err != nil {
+		return "", "", err
+	}
+	return base, head, nil
+}
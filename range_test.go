@@ -0,0 +1,79 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+func TestParseRange(t *testing.T) {
+	base, head, err := parseRange("v1.0..main")
+	if err != nil || base != "v1.0" || head != "main" {
+		t.Errorf("Expected v1.0 and main, got %q %q %v", base, head, err)
+	}
+	for _, value := range []string{"main", "..main", "v1.0..", "a..b..c", "a...b", ""} {
+		if _, _, err := parseRange(value); err == nil {
+			t.Errorf("Expected %q to be rejected", value)
+		}
+	}
+}
+
+func TestResolveRange(t *testing.T) {
+	dir, err := ioutil.TempDir("", "gh-status-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	git := func(args ...string) string {
+		cmd := exec.Command("git", append([]string{"-c", "user.name=test", "-c", "user.email=test@example.com"}, args...)...)
+		cmd.Dir = dir
+		out, err := cmd.Output()
+		if err != nil {
+			t.Skipf("git is not usable: %s", err)
+		}
+		return strings.TrimSpace(string(out))
+	}
+	git("init", "-q")
+	git("commit", "-q", "--allow-empty", "-m", "first")
+	git("tag", "v1.0")
+	git("commit", "-q", "--allow-empty", "-m", "second")
+	first, second := git("rev-parse", "v1.0"), git("rev-parse", "HEAD")
+
+	wd, _ := os.Getwd()
+	os.Chdir(dir)
+	defer os.Chdir(wd)
+
+	base, head, err := resolveRange("v1.0..HEAD")
+	if err != nil || base != first || head != second {
+		t.Errorf("Expected %s..%s, got %s..%s %v", first, second, base, head, err)
+	}
+	if _, _, err := resolveRange("v1.0..no-such-branch"); err == nil {
+		t.Errorf("Expected an unknown ref to be rejected")
+	}
+
+	// Full SHAs don't need the commit to be present.
+	sha := strings.Repeat("ab", 20)
+	if base, _, err := resolveRange(sha + "..HEAD"); err != nil || base != sha {
+		t.Errorf("Expected %s to be used as-is, got %s %v", sha, base, err)
+	}
+}
+
+func TestStatusTargetsForRange(t *testing.T) {
+	flags := defaultFlags()
+	flags.Repos = "org/other"
+	flags.RangeBase = "cafe"
+	targets, err := statusTargets(*flags)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	var got []string
+	for _, target := range targets {
+		got = append(got, target.OrgRepo+"@"+target.SHA)
+	}
+	expected := "christopher-bui/gh-status-reporter@deadbeef christopher-bui/gh-status-reporter@cafe org/other@deadbeef org/other@cafe"
+	if strings.Join(got, " ") != expected {
+		t.Errorf("Expected %q, got %q", expected, strings.Join(got, " "))
+	}
+}
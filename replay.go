@@ -0,0 +1,236 @@
+package main
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"sync"
+)
+
+// interaction is one recorded request to the Github API and its response.
+// Credentials are never recorded. BodySHA256 is the hash of the normalized
+// body, so a fixture can leave out a large body and keep only its hash.
+type interaction struct {
+	Method     string            `json:"method"`
+	Path       string            `json:"path"`
+	Body       string            `json:"body,omitempty"`
+	BodySHA256 string            `json:"body_sha256,omitempty"`
+	Status     int               `json:"status"`
+	Headers    map[string]string `json:"headers,omitempty"`
+	Response   string            `json:"response"`
+}
+
+// bodyHash returns the hex SHA-256 of a normalized request body.
+func bodyHash(normalized string) string {
+	sum := sha256.Sum256([]byte(normalized))
+	return hex.EncodeToString(sum[:])
+}
+
+// key returns what replayed requests are matched by: the method, the path
+// and the hash of the normalized body. The body is hashed when the fixture
+// has it, so an edited body doesn't need its hash updated.
+func (i interaction) key() string {
+	hash := i.BodySHA256
+	if i.Body != "" || hash == "" {
+		hash = bodyHash(normalizeBody([]byte(i.Body), ""))
+	}
+	return i.Method + " " + i.Path + " " + hash
+}
+
+// recordedHeaders are the response headers kept in fixtures.
+var recordedHeaders = []string{"Content-Type", "ETag", "Link", "X-Github-Request-Id"}
+
+// normalizeBody returns a canonical form of a request body so fixtures match
+// regardless of key order, whitespace or compression.
+func normalizeBody(body []byte, encoding string) string {
+	if encoding == "gzip" {
+		if reader, err := gzip.NewReader(bytes.NewReader(body)); err == nil {
+			if decompressed, err := ioutil.ReadAll(reader); err == nil {
+				body = decompressed
+			}
+		}
+	}
+	var decoded interface{}
+	if json.Unmarshal(body, &decoded) != nil {
+		return string(body)
+	}
+	canonical, _ := json.Marshal(decoded)
+	return string(canonical)
+}
+
+// readRequestBody reads and restores req's body.
+func readRequestBody(req *http.Request) ([]byte, error) {
+	if req.Body == nil {
+		return nil, nil
+	}
+	body, err := ioutil.ReadAll(req.Body)
+	req.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	req.Body = ioutil.NopCloser(bytes.NewReader(body))
+	return body, nil
+}
+
+// recordingTransport passes requests through and appends every interaction
+// to a fixture file, which is rewritten after each one so nothing is lost
+// when the process exits.
+type recordingTransport struct {
+	next http.RoundTripper
+	path string
+
+	mu           sync.Mutex
+	interactions []interaction
+}
+
+func (r *recordingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := r.next.RoundTrip(req)
+	if err != nil {
+		return nil, err
+	}
+	responseBody, err := ioutil.ReadAll(resp.Body)
+	resp.Body.Close()
+	if err != nil {
+		return nil, err
+	}
+	resp.Body = ioutil.NopCloser(bytes.NewReader(responseBody))
+
+	normalized := normalizeBody(body, req.Header.Get("Content-Encoding"))
+	recorded := interaction{
+		Method:     req.Method,
+		Path:       req.URL.RequestURI(),
+		Body:       normalized,
+		BodySHA256: bodyHash(normalized),
+		Status:     resp.StatusCode,
+		Response:   string(responseBody),
+	}
+	for _, name := range recordedHeaders {
+		if value := resp.Header.Get(name); value != "" {
+			if recorded.Headers == nil {
+				recorded.Headers = map[string]string{}
+			}
+			recorded.Headers[name] = value
+		}
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.interactions = append(r.interactions, recorded)
+	if err := writeFixtures(r.path, r.interactions); err != nil {
+		logger.Warnf("%s", err)
+	}
+	return resp, nil
+}
+
+func writeFixtures(path string, interactions []interaction) error {
+	contents, err := json.MarshalIndent(interactions, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error converting fixtures to json %s.", err)
+	}
+	tmp := path + ".tmp"
+	if err := ioutil.WriteFile(tmp, append(contents, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing -record fixtures %s: %s", path, err)
+	}
+	return os.Rename(tmp, path)
+}
+
+// replayTransport serves responses from a fixture file without touching the
+// network. Each recorded interaction answers one request, in the order they
+// were recorded; a request without an unused match fails.
+type replayTransport struct {
+	path string
+
+	mu      sync.Mutex
+	pending map[string][]interaction
+}
+
+func loadReplayTransport(path string) (*replayTransport, error) {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("Error reading -replay fixtures: %s", err)
+	}
+	var interactions []interaction
+	if err := json.Unmarshal(contents, &interactions); err != nil {
+		return nil, fmt.Errorf("Error parsing -replay fixtures %s: %s", path, err)
+	}
+	pending := map[string][]interaction{}
+	for _, recorded := range interactions {
+		pending[recorded.key()] = append(pending[recorded.key()], recorded)
+	}
+	return &replayTransport{path: path, pending: pending}, nil
+}
+
+func (r *replayTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	body, err := readRequestBody(req)
+	if err != nil {
+		return nil, err
+	}
+	path := req.URL.RequestURI()
+	normalized := normalizeBody(body, req.Header.Get("Content-Encoding"))
+
+	key := interaction{Method: req.Method, Path: path, Body: normalized}.key()
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if matches := r.pending[key]; len(matches) > 0 {
+		recorded := matches[0]
+		r.pending[key] = matches[1:]
+		resp := &http.Response{
+			StatusCode: recorded.Status,
+			Status:     fmt.Sprintf("%d %s", recorded.Status, http.StatusText(recorded.Status)),
+			Proto:      "HTTP/1.1",
+			ProtoMajor: 1,
+			ProtoMinor: 1,
+			Header:     http.Header{},
+			Body:       ioutil.NopCloser(bytes.NewReader([]byte(recorded.Response))),
+			Request:    req,
+		}
+		for name, value := range recorded.Headers {
+			resp.Header.Set(name, value)
+		}
+		return resp, nil
+	}
+	return nil, fmt.Errorf("no recorded response in %s for %s %s %s", r.path, req.Method, path, normalized)
+}
+
+var (
+	transportsMu sync.Mutex
+	recorders    = map[string]*recordingTransport{}
+	replays      = map[string]*replayTransport{}
+)
+
+// fixtureTransport returns the transport for -record or -replay, shared by
+// every client in the process, or nil if neither is set.
+func fixtureTransport(flags Flags, next http.RoundTripper) (http.RoundTripper, error) {
+	transportsMu.Lock()
+	defer transportsMu.Unlock()
+
+	switch {
+	case flags.Record != "" && flags.Replay != "":
+		return nil, fmt.Errorf("Error: -record and -replay can't be used together")
+	case flags.Replay != "":
+		if replays[flags.Replay] == nil {
+			replay, err := loadReplayTransport(flags.Replay)
+			if err != nil {
+				return nil, err
+			}
+			replays[flags.Replay] = replay
+		}
+		return replays[flags.Replay], nil
+	case flags.Record != "":
+		if recorders[flags.Record] == nil {
+			recorders[flags.Record] = &recordingTransport{next: next, path: flags.Record}
+		}
+		return recorders[flags.Record], nil
+	}
+	return nil, nil
+}
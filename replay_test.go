@@ -0,0 +1,152 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestRecordThenReplay(t *testing.T) {
+	fixtures := filepath.Join(t.TempDir(), "fixtures.json")
+	restore := withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("ETag", `"abc"`)
+		if r.Method == "POST" {
+			w.WriteHeader(http.StatusCreated)
+		}
+		fmt.Fprint(w, `{"state":"success","statuses":[{"context":"ci","state":"success"}]}`)
+	})
+
+	flags := defaultFlags()
+	flags.Record = fixtures
+	target := statusTarget{flags.OrgRepo, flags.SHA}
+	if err := setGithubCommitStatus("POST", target.url(), *flags, "pending"); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if _, err := getCombinedStatus(target, *flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	restore()
+
+	contents, _ := ioutil.ReadFile(fixtures)
+	if strings.Contains(string(contents), "token") || strings.Contains(string(contents), "Authorization") {
+		t.Errorf("Expected credentials to be left out of the fixtures, got %s", contents)
+	}
+	var recorded []interaction
+	json.Unmarshal(contents, &recorded)
+	if len(recorded) != 2 || recorded[0].Method != "POST" || recorded[0].Path != "/repos/christopher-bui/gh-status-reporter/statuses/deadbeef" {
+		t.Fatalf("Unexpected fixtures %+v", recorded)
+	}
+
+	// Replay never needs the server that was recorded.
+	flags.Record, flags.Replay = "", fixtures
+	if err := setGithubCommitStatus("POST", target.url(), *flags, "pending"); err != nil {
+		t.Errorf("Expected the recorded status post to replay, got %s", err)
+	}
+	status, err := getCombinedStatus(target, *flags)
+	if err != nil || status.find("ci") == nil {
+		t.Errorf("Expected the recorded combined status to replay, got %+v %v", status, err)
+	}
+}
+
+func TestReplayFailsOnUnmatchedRequest(t *testing.T) {
+	fixtures := writeTempFile(t, "fixtures.json", `[
+  {"method": "POST", "path": "/repos/org/repo/statuses/deadbeef", "body": "{\"context\":\"ci\",\"description\":\"unit test\",\"state\":\"pending\",\"target_url\":\"\"}", "status": 201, "response": "{}"}
+]`)
+	flags := defaultFlags()
+	flags.Replay = fixtures
+	target := statusTarget{"org/repo", "deadbeef"}
+
+	if err := setGithubCommitStatus("POST", target.url(), *flags, "pending"); err != nil {
+		t.Errorf("Expected a match regardless of key order, got %s", err)
+	}
+	err := setGithubCommitStatus("POST", target.url(), *flags, "pending")
+	if err == nil || !strings.Contains(err.Error(), "no recorded response") {
+		t.Errorf("Expected each interaction to answer only once, got %v", err)
+	}
+	err = setGithubCommitStatus("POST", target.url(), *flags, "success")
+	if err == nil || !strings.Contains(err.Error(), `POST /repos/org/repo/statuses/deadbeef {"context":"ci","description":"unit test","state":"success","target_url":""}`) {
+		t.Errorf("Expected the unmatched request to be described, got %v", err)
+	}
+}
+
+func TestReplayBehavesLikeRecordedRun(t *testing.T) {
+	withLogger(t, logError)
+	fixtures := filepath.Join(t.TempDir(), "fixtures.json")
+	restore := withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		if params.State == "failure" {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			fmt.Fprint(w, `{"message": "No commit found for SHA: deadbeef"}`)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprint(w, `{"state": "`+params.State+`"}`)
+	})
+
+	run := func(flags Flags) []string {
+		var outcomes []string
+		statusReporter := &reporter{flags: flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+		for _, state := range []string{"pending", "failure"} {
+			result := &commandResult{Err: errors.New("exit status 1"), ExitCode: 1}
+			if state == "pending" {
+				result = nil
+			}
+			err := statusReporter.report(state, result)
+			outcomes = append(outcomes, fmt.Sprintf("%s: %v", state, err))
+		}
+		return outcomes
+	}
+	flags := defaultFlags()
+	flags.Record = fixtures
+	recorded := run(*flags)
+	restore()
+
+	flags.Record, flags.Replay = "", fixtures
+	if replayed := run(*flags); !reflect.DeepEqual(replayed, recorded) {
+		t.Errorf("Expected the replay to behave like the recording %q, got %q", recorded, replayed)
+	}
+	if !strings.Contains(recorded[1], "No commit found") {
+		t.Errorf("Expected the recorded failure, got %q", recorded)
+	}
+}
+
+func TestReplayMatchesBodyHash(t *testing.T) {
+	body := `{"context":"ci","description":"unit test","state":"pending","target_url":""}`
+	fixtures := writeTempFile(t, "fixtures.json", `[
+  {"method": "POST", "path": "/repos/org/repo/statuses/deadbeef", "body_sha256": "`+bodyHash(body)+`", "status": 201, "response": "{}"},
+  {"method": "POST", "path": "/repos/org/repo/statuses/deadbeef", "body": "{\"state\": \"success\"}", "body_sha256": "stale", "status": 201, "response": "{}"}
+]`)
+	flags := defaultFlags()
+	flags.Replay = fixtures
+	target := statusTarget{"org/repo", "deadbeef"}
+	if err := setGithubCommitStatus("POST", target.url(), *flags, "pending"); err != nil {
+		t.Errorf("Expected a fixture with only the body's hash to match, got %s", err)
+	}
+
+	// A body in the fixture is hashed itself, so editing it needs no new hash.
+	transport, err := loadReplayTransport(fixtures)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	req, _ := http.NewRequest("POST", "https://api.github.com/repos/org/repo/statuses/deadbeef", strings.NewReader(`{"state":"success"}`))
+	if _, err := transport.RoundTrip(req); err != nil {
+		t.Errorf("Expected the edited body to match, got %s", err)
+	}
+}
+
+func TestNormalizeBody(t *testing.T) {
+	if normalizeBody([]byte(`{ "b": 1, "a": [true] }`), "") != `{"a":[true],"b":1}` {
+		t.Errorf("Expected JSON bodies to be canonicalized")
+	}
+	compressed, encoding := compressBody(Flags{GzipRequest: true}, []byte(`{"a":"`+strings.Repeat("x", gzipThreshold)+`"}`))
+	if normalizeBody(compressed, encoding) != `{"a":"`+strings.Repeat("x", gzipThreshold)+`"}` {
+		t.Errorf("Expected gzipped bodies to be decompressed")
+	}
+}
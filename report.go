@@ -0,0 +1,70 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+)
+
+// runReport is the machine readable summary of a run written by -json-report.
+type runReport struct {
+	Repositories    []string      `json:"repositories"`
+	SHA             string        `json:"sha"`
+	Context         string        `json:"context"`
+	State           string        `json:"state"`
+	ExitCode        int           `json:"exit_code"`
+	DurationSeconds float64       `json:"duration_seconds"`
+	TimedOut        bool          `json:"timed_out"`
+	PullRequests    []pullRequest `json:"pull_requests,omitempty"`
+	Labels          []labelChange `json:"labels,omitempty"`
+	// Attempts is the history of every run of the command with
+	// -command-retries.
+	Attempts []commandAttempt `json:"attempts,omitempty"`
+	// Annotations are the problems the -annotate-format parsers found.
+	Annotations []annotation `json:"annotations,omitempty"`
+	// Artifacts are the -artifact and -artifacts-file links.
+	Artifacts []artifact `json:"artifacts,omitempty"`
+}
+
+func newRunReport(flags Flags, targets []statusTarget) *runReport {
+	report := &runReport{SHA: flags.SHA, Context: flags.Context}
+	for _, target := range targets {
+		report.Repositories = append(report.Repositories, target.OrgRepo)
+	}
+	return report
+}
+
+// writeJSONReport writes report, a *runReport or *pipelineReport, to path.
+func writeJSONReport(path string, report interface{}) error {
+	body, err := json.MarshalIndent(report, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error converting %+v to json %s.", report, err)
+	}
+	if err := ioutil.WriteFile(path, append(body, '\n'), 0644); err != nil {
+		return fmt.Errorf("Error writing JSON report %s: %s", path, err)
+	}
+	return nil
+}
+
+// writeReports writes the optional report files describing the command's
+// result. Failures are printed but don't prevent the status from being posted.
+func writeReports(flags Flags, result *commandResult, report *runReport) {
+	report.State = commandState(result)
+	report.ExitCode = result.ExitCode
+	report.DurationSeconds = result.Duration.Seconds()
+	report.TimedOut = result.TimedOut
+	if flags.CommandRetries > 0 {
+		report.Attempts = result.Attempts
+	}
+
+	if flags.JUnitOut != "" {
+		if err := writeJUnitReport(flags.JUnitOut, flags, result); err != nil {
+			logger.Errorf("%s", err)
+		}
+	}
+	if flags.JSONReport != "" {
+		if err := writeJSONReport(flags.JSONReport, report); err != nil {
+			logger.Errorf("%s", err)
+		}
+	}
+}
@@ -0,0 +1,33 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"testing"
+	"time"
+)
+
+func TestWriteReportsJSON(t *testing.T) {
+	flags := defaultFlags()
+	flags.JSONReport = writeTempFile(t, "report.json", "")
+	report := newRunReport(*flags, []statusTarget{{flags.OrgRepo, flags.SHA}})
+	report.PullRequests = []pullRequest{{Number: 3, HTMLURL: "https://github.com/o/r/pull/3", State: "open"}}
+
+	result := &commandResult{Duration: 2 * time.Second, Output: newTailBuffer(10)}
+	writeReports(*flags, result, report)
+
+	body, err := ioutil.ReadFile(flags.JSONReport)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var written runReport
+	if err := json.Unmarshal(body, &written); err != nil {
+		t.Fatalf("Expected valid JSON, got %s\n%s", err, body)
+	}
+	if written.State != "success" || written.DurationSeconds != 2 || written.SHA != "deadbeef" || written.Context != "ci" {
+		t.Errorf("Unexpected report %+v", written)
+	}
+	if len(written.Repositories) != 1 || len(written.PullRequests) != 1 || written.PullRequests[0].Number != 3 {
+		t.Errorf("Unexpected repositories or pull requests in %+v", written)
+	}
+}
@@ -0,0 +1,196 @@
+package main
+
+import (
+	"sync"
+	"time"
+)
+
+// runTimeoutExitCode is the exit code of a run stopped by -run-timeout, the
+// code timeout(1) uses.
+const runTimeoutExitCode = 124
+
+// runTimeoutPostLimit bounds posting the error status once -run-timeout has
+// fired, retries included, so a hanging API can't keep the run going.
+var runTimeoutPostLimit = 10 * time.Second
+
+// runDeadline is the -run-timeout handler, nil when there is no run timeout.
+// Its methods do nothing when it is nil.
+var runDeadline *runTimeout
+
+// runTimeout bounds the whole run. When it fires it stops the command
+// through Interrupt, posts the error status for every status reported so far
+// and exits with runTimeoutExitCode, whatever else is still going on.
+type runTimeout struct {
+	Interrupt chan struct{}
+	timeout   time.Duration
+	exit      func(int)
+
+	mu      sync.Mutex
+	fired   bool
+	reports []*timeoutReport
+}
+
+// timeoutReport is a status the timeout posts the error status for: the
+// commits and the flags, and so the context, it is posted with.
+type timeoutReport struct {
+	targets []statusTarget
+	flags   Flags
+	// posting is closed once the final status in flight has been posted.
+	posting chan struct{}
+	// done is set once the final status has been posted, so the error
+	// status isn't posted over it.
+	done bool
+}
+
+// startRunTimeout starts the -run-timeout clock.
+func startRunTimeout(timeout time.Duration, exit func(int)) *runTimeout {
+	r := &runTimeout{Interrupt: make(chan struct{}), timeout: timeout, exit: exit}
+	time.AfterFunc(timeout, r.fire)
+	return r
+}
+
+// report adds a status that gets the error status if the run times out
+// before its final status is posted. A pipeline adds one for each stage and
+// the rollup.
+func (r *runTimeout) report(targets []statusTarget, flags Flags) *timeoutReport {
+	if r == nil {
+		return nil
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report := &timeoutReport{targets: targets, flags: flags}
+	r.reports = append(r.reports, report)
+	return report
+}
+
+// begin is called before posting the final status of report. Like hold it
+// blocks for good once the run has timed out; otherwise it marks the status
+// as in flight, so that fire waits for it rather than racing it.
+func (r *runTimeout) begin(report *timeoutReport) {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	if r.fired {
+		r.mu.Unlock()
+		select {}
+	}
+	if report != nil {
+		report.posting = make(chan struct{})
+	}
+	r.mu.Unlock()
+}
+
+// end is called once the final status of report has been posted, or failed
+// to be.
+func (r *runTimeout) end(report *timeoutReport, posted bool) {
+	if r == nil || report == nil {
+		return
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	report.done = posted
+	close(report.posting)
+	report.posting = nil
+}
+
+// hold blocks for good once the run has timed out, so that nothing else
+// posts a status or exits while fire posts the error status and exits.
+func (r *runTimeout) hold() {
+	if r == nil {
+		return
+	}
+	r.mu.Lock()
+	fired := r.fired
+	r.mu.Unlock()
+	if fired {
+		select {}
+	}
+}
+
+func (r *runTimeout) fire() {
+	r.mu.Lock()
+	r.fired = true
+	var posting []chan struct{}
+	for _, report := range r.reports {
+		if report.posting != nil {
+			posting = append(posting, report.posting)
+		}
+	}
+	r.mu.Unlock()
+	close(r.Interrupt)
+
+	// A final status already in flight gets to finish first.
+	expired := time.After(runTimeoutPostLimit)
+wait:
+	for _, posted := range posting {
+		select {
+		case <-posted:
+		case <-expired:
+			break wait
+		}
+	}
+	r.mu.Lock()
+	var reports []*timeoutReport
+	for _, report := range r.reports {
+		if !report.done {
+			reports = append(reports, report)
+		}
+	}
+	finished := len(r.reports) > 0
+	r.mu.Unlock()
+
+	if len(reports) == 0 {
+		if finished {
+			logger.Errorf("The run exceeded -run-timeout %s after its statuses were posted, exiting", r.timeout)
+		} else {
+			logger.Errorf("The run exceeded -run-timeout %s before it had a status to report, exiting without posting a status", r.timeout)
+		}
+		r.exit(runTimeoutExitCode)
+		return
+	}
+	logger.Errorf("The run exceeded -run-timeout %s, posting the error status", r.timeout)
+	// The statuses are posted at once so that together they still take at
+	// most runTimeoutPostLimit.
+	var wg sync.WaitGroup
+	for _, report := range reports {
+		flags := report.flags
+		if flags.HTTPTimeout == 0 || flags.HTTPTimeout > runTimeoutPostLimit {
+			flags.HTTPTimeout = runTimeoutPostLimit
+		}
+		if flags.Description == "" {
+			flags.Description = "Run exceeded overall timeout"
+		} else {
+			flags.Description = appendSuffix(flags.Description, "run exceeded overall timeout")
+		}
+		wg.Add(1)
+		go func(targets []statusTarget) {
+			defer wg.Done()
+			if err := postStatus(targets, flags, "error"); err != nil {
+				logger.Errorf("%s", err)
+			}
+		}(report.targets)
+	}
+	wg.Wait()
+	r.exit(runTimeoutExitCode)
+}
+
+// anyInterrupt returns a channel that is closed once either a or b is. A
+// nil channel is never closed.
+func anyInterrupt(a, b <-chan struct{}) <-chan struct{} {
+	switch {
+	case a == nil:
+		return b
+	case b == nil:
+		return a
+	}
+	either := make(chan struct{})
+	go func() {
+		select {
+		case <-a:
+		case <-b:
+		}
+		close(either)
+	}()
+	return either
+}
@@ -0,0 +1,157 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRunTimeoutPostsErrorWhenAPIHangs(t *testing.T) {
+	withLogger(t, logError)
+	defer func(limit time.Duration) { runTimeoutPostLimit = limit }(runTimeoutPostLimit)
+	runTimeoutPostLimit = 100 * time.Millisecond
+	posts := make(chan string, 1)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		posts <- params.State + ": " + params.Description
+		<-r.Context().Done()
+	})()
+
+	exited := make(chan int, 1)
+	deadline := startRunTimeout(50*time.Millisecond, func(code int) { exited <- code })
+	flags := defaultFlags()
+	flags.Retries = 0
+	deadline.report([]statusTarget{{"org/repo", "deadbeef"}}, *flags)
+
+	select {
+	case code := <-exited:
+		if code != runTimeoutExitCode {
+			t.Errorf("Expected exit code %d, got %d", runTimeoutExitCode, code)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("Expected the run to exit even though the API hangs")
+	}
+	select {
+	case <-deadline.Interrupt:
+	default:
+		t.Errorf("Expected the command to be interrupted")
+	}
+	if post := <-posts; post != "error: unit test (run exceeded overall timeout)" {
+		t.Errorf("Expected the error status, got %q", post)
+	}
+}
+
+func TestRunTimeoutSkipsPostedStatuses(t *testing.T) {
+	out := withLogger(t, logError)
+	posts := make(chan string, 2)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		posts <- params.Context + "=" + params.State
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	exited := make(chan int, 1)
+	deadline := &runTimeout{Interrupt: make(chan struct{}), timeout: time.Second, exit: func(code int) { exited <- code }}
+	flags := defaultFlags()
+	posted := deadline.report([]statusTarget{{"org/repo", "deadbeef"}}, *flags)
+	deadline.begin(posted)
+	deadline.end(posted, true)
+	flags.Context = "ci/in-flight"
+	inFlight := deadline.report([]statusTarget{{"org/repo", "deadbeef"}}, *flags)
+	deadline.begin(inFlight)
+	flags.Context = "ci/failed"
+	failed := deadline.report([]statusTarget{{"org/repo", "deadbeef"}}, *flags)
+	deadline.begin(failed)
+	deadline.end(failed, false)
+
+	go deadline.fire()
+	select {
+	case <-exited:
+		t.Fatal("Expected the timeout to wait for the status in flight")
+	case <-time.After(50 * time.Millisecond):
+	}
+	deadline.end(inFlight, true)
+	if code := <-exited; code != runTimeoutExitCode {
+		t.Errorf("Expected exit code %d, got %d", runTimeoutExitCode, code)
+	}
+	close(posts)
+	var got []string
+	for post := range posts {
+		got = append(got, post)
+	}
+	if len(got) != 1 || got[0] != "ci/failed=error" {
+		t.Errorf("Expected only the unposted status to get the error status, got %q:\n%s", got, out)
+	}
+}
+
+func TestRunTimeoutAfterStatusesExits(t *testing.T) {
+	out := withLogger(t, logError)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected nothing to be posted, got %s %s", r.Method, r.URL.Path)
+	})()
+	exited := make(chan int, 1)
+	deadline := &runTimeout{Interrupt: make(chan struct{}), timeout: time.Second, exit: func(code int) { exited <- code }}
+	posted := deadline.report([]statusTarget{{"org/repo", "deadbeef"}}, *defaultFlags())
+	deadline.begin(posted)
+	deadline.end(posted, true)
+	deadline.fire()
+	if code := <-exited; code != runTimeoutExitCode {
+		t.Errorf("Expected exit code %d, got %d", runTimeoutExitCode, code)
+	}
+	if !strings.Contains(out.String(), "after its statuses were posted") {
+		t.Errorf("Expected the posted statuses to be logged, got %q", out)
+	}
+}
+
+func TestRunTimeoutBeforeTargetsExits(t *testing.T) {
+	out := withLogger(t, logError)
+	exited := make(chan int, 1)
+	startRunTimeout(time.Millisecond, func(code int) { exited <- code })
+	if code := <-exited; code != runTimeoutExitCode {
+		t.Errorf("Expected exit code %d, got %d", runTimeoutExitCode, code)
+	}
+	if !strings.Contains(out.String(), "without posting a status") {
+		t.Errorf("Expected the missing status to be logged, got %q", out)
+	}
+}
+
+func TestCLIRunTimeoutStopsHeartbeatLoop(t *testing.T) {
+	started := time.Now()
+	out, code := runCLI(t, "-dry-run", "-run-timeout", "300ms", "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"sh", "-c", "while :; do echo heartbeat; sleep 0.05; done")
+	if code != runTimeoutExitCode {
+		t.Errorf("Expected exit code %d, got %d:\n%s", runTimeoutExitCode, code, out)
+	}
+	if elapsed := time.Since(started); elapsed > 5*time.Second {
+		t.Errorf("Expected -run-timeout to end the run, took %s", elapsed)
+	}
+	if !strings.Contains(out, "heartbeat") || !strings.Contains(out, `"description":"unit test (run exceeded overall timeout)","state":"error"`) {
+		t.Errorf("Expected the error status after the heartbeats, got:\n%s", out)
+	}
+	if strings.Count(out, "would POST") != 2 {
+		t.Errorf("Expected only the pending and the timeout's error status, got:\n%s", out)
+	}
+}
+
+func TestCLIRunTimeoutPostsPipelineErrors(t *testing.T) {
+	pipeline := writeTempFile(t, "pipeline.json", `{"rollup": "ci/all", "stages": [
+		{"name": "lint", "command": "true"},
+		{"name": "build", "command": ["sleep", "10"], "needs": ["lint"]}
+	]}`)
+	out, code := runCLI(t, "pipeline", "-dry-run", "-run-timeout", "300ms", "-f", pipeline, "-r", "org/repo", "-s", "deadbeef", "-d", "unit test", "-a", "token")
+	if code != runTimeoutExitCode {
+		t.Errorf("Expected exit code %d, got %d:\n%s", runTimeoutExitCode, code, out)
+	}
+	for _, context := range []string{"build", "ci/all"} {
+		if !strings.Contains(out, `"context":"`+context+`","description":"unit test (run exceeded overall timeout)","state":"error"`) {
+			t.Errorf("Expected the error status for %s, got:\n%s", context, out)
+		}
+	}
+	if strings.Contains(out, `"context":"lint","description":"unit test (run exceeded overall timeout)"`) {
+		t.Errorf("Expected the finished stage to keep its status, got:\n%s", out)
+	}
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+)
+
+// appTokenPrefixes mark Github App installation tokens, including the
+// GITHUB_TOKEN of Actions. Their access comes from the app's permissions, so
+// they don't report OAuth scopes.
+var appTokenPrefixes = []string{"ghs_", "v1."}
+
+// statusScopes are the OAuth scopes that allow creating commit statuses.
+var statusScopes = []string{"repo:status", "repo"}
+
+// parseScopes splits an X-OAuth-Scopes header.
+func parseScopes(header string) []string {
+	var scopes []string
+	for _, scope := range strings.Split(header, ",") {
+		if scope = strings.TrimSpace(scope); scope != "" {
+			scopes = append(scopes, scope)
+		}
+	}
+	return scopes
+}
+
+// isAppToken reports whether token is a Github App installation token.
+func isAppToken(token string) bool {
+	for _, prefix := range appTokenPrefixes {
+		if strings.HasPrefix(token, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// checkTokenScopes fails early when the token's scopes don't allow posting
+// statuses, instead of letting the first POST fail. App tokens and
+// fine-grained tokens, which don't send X-OAuth-Scopes, are not checked.
+func checkTokenScopes(flags Flags) error {
+	if isAppToken(flags.Auth) {
+		logger.Infof("Skipping the scope check for a Github App token.")
+		return nil
+	}
+	response, err := githubRequest("GET", githubAPIURL+"/user", flags, nil)
+	if err != nil {
+		return err
+	}
+	if !response.ok() {
+		return response.error("Error checking the token's scopes")
+	}
+	header, reported := response.Header["X-Oauth-Scopes"]
+	if !reported {
+		logger.Infof("Skipping the scope check, the token doesn't report OAuth scopes.")
+		return nil
+	}
+	scopes := parseScopes(strings.Join(header, ","))
+	for _, scope := range scopes {
+		for _, allowed := range statusScopes {
+			if scope == allowed {
+				return nil
+			}
+		}
+	}
+	granted := strings.Join(scopes, ", ")
+	if granted == "" {
+		granted = "none"
+	}
+	return fmt.Errorf("Error: the token lacks the repo:status scope needed to post statuses (it has: %s)", granted)
+}
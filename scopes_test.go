@@ -0,0 +1,64 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+// recordedScopesHeader is X-OAuth-Scopes as Github sent it for a classic
+// token without repo access.
+const recordedScopesHeader = "gist, read:org, workflow"
+
+func withScopes(t *testing.T, header string, reported bool) func() {
+	return withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/user" {
+			t.Errorf("Unexpected request to %s", r.URL.Path)
+		}
+		if reported {
+			w.Header().Set("X-OAuth-Scopes", header)
+		}
+		fmt.Fprint(w, `{"login":"octocat"}`)
+	})
+}
+
+func TestCheckTokenScopesMissing(t *testing.T) {
+	defer withScopes(t, recordedScopesHeader, true)()
+
+	err := checkTokenScopes(*defaultFlags())
+	if err == nil || err.Error() != "Error: the token lacks the repo:status scope needed to post statuses (it has: gist, read:org, workflow)" {
+		t.Errorf("Expected a missing scope error, got %v", err)
+	}
+}
+
+func TestCheckTokenScopesPresent(t *testing.T) {
+	for _, header := range []string{"repo:status", "read:org, repo"} {
+		restore := withScopes(t, header, true)
+		if err := checkTokenScopes(*defaultFlags()); err != nil {
+			t.Errorf("Expected %q to allow posting statuses, got %s", header, err)
+		}
+		restore()
+	}
+
+	defer withScopes(t, "", true)()
+	if err := checkTokenScopes(*defaultFlags()); err == nil || !strings.Contains(err.Error(), "(it has: none)") {
+		t.Errorf("Expected a token without scopes to fail, got %v", err)
+	}
+}
+
+func TestCheckTokenScopesSkipped(t *testing.T) {
+	defer withScopes(t, "", false)()
+	if err := checkTokenScopes(*defaultFlags()); err != nil {
+		t.Errorf("Expected tokens that don't report scopes to be skipped, got %s", err)
+	}
+
+	flags := defaultFlags()
+	flags.Auth = "ghs_installation"
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		t.Errorf("Expected no request for an App token")
+	})()
+	if err := checkTokenScopes(*flags); err != nil {
+		t.Errorf("Expected App tokens to be skipped, got %s", err)
+	}
+}
@@ -0,0 +1,192 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// exitCleanups are run before the process exits, including on signals, for
+// files that must not outlive the run.
+var exitCleanups struct {
+	sync.Mutex
+	funcs []func()
+}
+
+// onExit registers f to run before the process exits.
+func onExit(f func()) {
+	exitCleanups.Lock()
+	defer exitCleanups.Unlock()
+	exitCleanups.funcs = append(exitCleanups.funcs, f)
+}
+
+// runExitCleanups runs the registered cleanups once, most recent first.
+func runExitCleanups() {
+	exitCleanups.Lock()
+	funcs := exitCleanups.funcs
+	exitCleanups.funcs = nil
+	exitCleanups.Unlock()
+	for i := len(funcs) - 1; i >= 0; i-- {
+		funcs[i]()
+	}
+}
+
+// exit runs the exit cleanups and exits with code. Once -run-timeout has
+// fired it blocks instead, leaving the exit to the timeout.
+func exit(code int) {
+	runDeadline.hold()
+	exitProcess(code)
+}
+
+// exitProcess is exit without waiting for -run-timeout.
+func exitProcess(code int) {
+	tracer.setExitCode(code)
+	runExitCleanups()
+	os.Exit(code)
+}
+
+// cleanupSignalChannel receives the signals cleanupOnSignal handles.
+var cleanupSignalChannel chan os.Signal
+
+// cleanupOnSignal runs the exit cleanups when one of cleanupSignals arrives
+// and then delivers the signal again, so the process ends as it would have
+// without the handler.
+func cleanupOnSignal() {
+	signals := make(chan os.Signal, 1)
+	cleanupSignalChannel = signals
+	signal.Notify(signals, cleanupSignals...)
+	go func() {
+		sig := <-signals
+		runExitCleanups()
+		signal.Stop(signals)
+		if process, err := os.FindProcess(os.Getpid()); err != nil || process.Signal(sig) != nil {
+			os.Exit(1)
+		}
+	}()
+}
+
+// stopCleanupOnSignal stops cleanupOnSignal, for when a handler that exits
+// through exit takes over the signals.
+func stopCleanupOnSignal() {
+	if cleanupSignalChannel != nil {
+		signal.Stop(cleanupSignalChannel)
+	}
+}
+
+// scriptArgs returns the arguments for the -f script: the positional
+// arguments, which must follow --. rawArgs are the arguments flags were
+// parsed from and args the positional ones left over.
+func scriptArgs(rawArgs, args []string) ([]string, error) {
+	if len(args) == 0 {
+		return nil, nil
+	}
+	if i := len(rawArgs) - len(args) - 1; i < 0 || rawArgs[i] != "--" {
+		return nil, errors.New("Error: -f can't be combined with a command; pass arguments to the script after --")
+	}
+	return args, nil
+}
+
+// validateScriptFlags checks -f against the flags it can't be used with.
+func validateScriptFlags(flags Flags) error {
+	var errs multiError
+	if flags.Image != "" {
+		errs = append(errs, errors.New("Error: -f and -image can't be used together"))
+	}
+	if flags.ScriptFile == "-" && flags.Stdin == stdinInherit {
+		errs = append(errs, errors.New("Error: -f - reads the script from stdin, so the command can't inherit it with -stdin inherit"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// stdinScript copies the script on stdin into a temporary file only the
+// current user can read, which is removed when the process exits.
+func stdinScript(stdin io.Reader) (string, error) {
+	file, err := ioutil.TempFile("", "gh-status-reporter-script-")
+	if err != nil {
+		return "", fmt.Errorf("Error creating a file for the script: %s", err)
+	}
+	onExit(func() { os.Remove(file.Name()) })
+	cleanupOnSignal()
+
+	_, err = io.Copy(file, stdin)
+	if closeErr := file.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", fmt.Errorf("Error reading the script from stdin: %s", err)
+	}
+	logger.Debugf("Wrote the script from stdin to %s", file.Name())
+	return file.Name(), nil
+}
+
+// readShebang returns the interpreter and its optional argument from the
+// first line of path, or an empty interpreter if there is no #! line. Like
+// the kernel, everything after the interpreter is a single argument.
+func readShebang(path string) (string, string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return "", "", err
+	}
+	defer file.Close()
+
+	line, err := bufio.NewReader(file).ReadString('\n')
+	if err != nil && err != io.EOF {
+		return "", "", err
+	}
+	if !strings.HasPrefix(line, "#!") {
+		return "", "", nil
+	}
+	fields := strings.SplitN(strings.TrimSpace(line[2:]), " ", 2)
+	if len(fields) == 1 {
+		return fields[0], "", nil
+	}
+	return fields[0], strings.TrimSpace(fields[1]), nil
+}
+
+// scriptCommand returns the command line that runs the script at path with
+// args: the file itself when it is executable, otherwise the interpreter
+// from its #! line, or sh when it has none.
+func scriptCommand(path string, args []string) (string, []string, error) {
+	if path == "-" {
+		var err error
+		if path, err = stdinScript(os.Stdin); err != nil {
+			return "", nil, err
+		}
+	}
+	path, err := filepath.Abs(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error resolving the script path: %s", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading the script: %s", err)
+	}
+	if info.IsDir() {
+		return "", nil, fmt.Errorf("Error: the script %s is a directory", path)
+	}
+	if info.Mode()&0111 != 0 {
+		return path, args, nil
+	}
+
+	interpreter, arg, err := readShebang(path)
+	if err != nil {
+		return "", nil, fmt.Errorf("Error reading the script: %s", err)
+	}
+	switch {
+	case interpreter == "":
+		return "sh", append([]string{path}, args...), nil
+	case arg == "":
+		return interpreter, append([]string{path}, args...), nil
+	}
+	return interpreter, append([]string{arg, path}, args...), nil
+}
@@ -0,0 +1,100 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestScriptArgs(t *testing.T) {
+	args, err := scriptArgs([]string{"-f", "build.sh", "--", "a", "b"}, []string{"a", "b"})
+	if err != nil || strings.Join(args, " ") != "a b" {
+		t.Errorf("Expected the arguments after --, got %q, %v", args, err)
+	}
+	if args, err := scriptArgs([]string{"-f", "build.sh"}, nil); err != nil || args != nil {
+		t.Errorf("Expected no arguments, got %q, %v", args, err)
+	}
+	if _, err := scriptArgs([]string{"-f", "build.sh", "make", "test"}, []string{"make", "test"}); err == nil {
+		t.Error("Expected an error for a command given with -f")
+	}
+}
+
+func TestScriptCommand(t *testing.T) {
+	dir := t.TempDir()
+	executable := filepath.Join(dir, "executable.sh")
+	ioutil.WriteFile(executable, []byte("#!/bin/sh\necho hi\n"), 0755)
+	shebang := filepath.Join(dir, "shebang.py")
+	ioutil.WriteFile(shebang, []byte("#!/usr/bin/env python3 -u\nprint('hi')\n"), 0644)
+	plain := filepath.Join(dir, "plain.sh")
+	ioutil.WriteFile(plain, []byte("echo hi\n"), 0644)
+
+	for _, test := range []struct {
+		path     string
+		expected string
+	}{
+		{executable, executable + " a"},
+		{shebang, "/usr/bin/env python3 -u " + shebang + " a"},
+		{plain, "sh " + plain + " a"},
+	} {
+		cmd, args, err := scriptCommand(test.path, []string{"a"})
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		if got := strings.Join(append([]string{cmd}, args...), " "); got != test.expected {
+			t.Errorf("Expected %q for %s, got %q", test.expected, filepath.Base(test.path), got)
+		}
+	}
+
+	if _, _, err := scriptCommand(filepath.Join(dir, "missing.sh"), nil); err == nil {
+		t.Error("Expected an error for a missing script")
+	}
+}
+
+func TestRunExitCleanupsRunsOnce(t *testing.T) {
+	var ran []string
+	onExit(func() { ran = append(ran, "first") })
+	onExit(func() { ran = append(ran, "second") })
+	runExitCleanups()
+	runExitCleanups()
+	if strings.Join(ran, " ") != "second first" {
+		t.Errorf("Expected each cleanup to run once, most recent first, got %q", ran)
+	}
+}
+
+func TestCLIRunsScriptFile(t *testing.T) {
+	script := filepath.Join(t.TempDir(), "build.sh")
+	ioutil.WriteFile(script, []byte("echo \"building $1 $2\"\nexit 3\n"), 0644)
+
+	out, code := runCLI(t, "-dev", "-f", script, "--", "a", "b")
+	if !strings.Contains(out, "building a b") {
+		t.Errorf("Expected the script's output with its arguments, got:\n%s", out)
+	}
+	if code != 3 {
+		t.Errorf("Expected -dev to exit with the script's exit code, got %d", code)
+	}
+
+	out, code = runCLI(t, "-dev", "-f", script, "make")
+	if code != 1 || !strings.Contains(out, "pass arguments to the script after --") {
+		t.Errorf("Expected a command with -f to be rejected, got %d:\n%s", code, out)
+	}
+}
+
+func TestCLIRunsScriptFromStdinAndRemovesIt(t *testing.T) {
+	tmp := t.TempDir()
+	cmd := exec.Command(os.Args[0], "-dev", "-f", "-", "--", "a")
+	cmd.Env = append(os.Environ(), "GHSR_TEST_MAIN=1", "TMPDIR="+tmp)
+	cmd.Stdin = strings.NewReader("echo \"from stdin $1\"\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Error running the CLI: %s\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "from stdin a") {
+		t.Errorf("Expected the script's output, got:\n%s", out)
+	}
+	if entries, _ := ioutil.ReadDir(tmp); len(entries) != 0 {
+		t.Errorf("Expected the script's temporary file to be removed, found %s", entries[0].Name())
+	}
+}
@@ -0,0 +1,118 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"sync"
+)
+
+// readSHAFile returns the trimmed SHA written to path.
+func readSHAFile(path string) (string, error) {
+	contents, err := ioutil.ReadFile(path)
+	if os.IsNotExist(err) {
+		return "", fmt.Errorf("Error: -sha-file %s doesn't exist", path)
+	} else if err != nil {
+		return "", fmt.Errorf("Error reading -sha-file %s: %s", path, err)
+	}
+	sha := strings.TrimSpace(string(contents))
+	if sha == "" {
+		return "", fmt.Errorf("Error: -sha-file %s is empty", path)
+	}
+	return sha, nil
+}
+
+// applySHAFile sets flags.SHA from -sha-file. -s and -sha-file can't both
+// be given on the command line, or both in the environment; when one is on
+// the command line and the other in the environment, the command line wins.
+// origins is where each flag got its value, as returned by flagOrigins.
+func applySHAFile(flags *Flags, origins map[string]string) error {
+	if flags.SHAFile == "" {
+		return nil
+	}
+	if flags.SHA != "" {
+		shaGiven, fileGiven := origins["s"] == "-s", origins["sha-file"] == "-sha-file"
+		switch {
+		case shaGiven && fileGiven:
+			return errors.New("Error: -s and -sha-file can't be used together")
+		case !shaGiven && !fileGiven:
+			return fmt.Errorf("Error: %s and %s can't both be set", flags.envName("SHA"), flags.envName("SHA_FILE"))
+		case shaGiven:
+			logger.Debugf("Using -s instead of -sha-file from %s", origins["sha-file"])
+			flags.SHAFile = ""
+			return nil
+		}
+		logger.Debugf("Using -sha-file instead of -s from %s", origins["s"])
+	}
+
+	sha, err := readSHAFile(flags.SHAFile)
+	if err != nil {
+		return err
+	}
+	flags.SHA = sha
+	return nil
+}
+
+// shaResolvers look up the SHA of -prefer-head-sha and -range.
+var shaResolvers = struct {
+	eventHead   func(path string) (string, error)
+	commitRange func(value string) (string, string, error)
+}{eventHeadSHA, resolveRange}
+
+// resolvedSHA is the commit this run reports on, worked out once by
+// resolveSHA.
+var resolvedSHA = struct {
+	sync.Mutex
+	done      bool
+	sha, base string
+}{}
+
+// resolveSHA sets flags.SHA, and flags.RangeBase for -range, to the commit
+// statuses are posted to. It is resolved on the first call only; later ones
+// reuse the result, so every feature reports on the same commit even if the
+// event file or a branch moves in the meantime.
+func resolveSHA(flags *Flags) error {
+	resolvedSHA.Lock()
+	defer resolvedSHA.Unlock()
+	if !resolvedSHA.done {
+		sha, base, source, err := lookupSHA(*flags)
+		if err != nil {
+			return err
+		}
+		logger.Debugf("Reporting on %s from %s", sha, source)
+		resolvedSHA.sha, resolvedSHA.base, resolvedSHA.done = sha, base, true
+	}
+	flags.SHA, flags.RangeBase = resolvedSHA.sha, resolvedSHA.base
+	return nil
+}
+
+// lookupSHA returns the commit statuses are posted to and where it came
+// from: the head of -range, which can't be combined with the others, the
+// pull request head for -prefer-head-sha on a pull request event, or else -s
+// or -sha-file, already read by applySHAFile.
+func lookupSHA(flags Flags) (sha, base, source string, err error) {
+	if flags.Range != "" {
+		base, head, err := shaResolvers.commitRange(flags.Range)
+		if err != nil {
+			return "", "", "", err
+		}
+		logger.Infof("Posting to %s and %s", base, head)
+		return head, base, "-range " + flags.Range, nil
+	}
+	if flags.PreferHeadSHA {
+		head, err := shaResolvers.eventHead(os.Getenv("GITHUB_EVENT_PATH"))
+		if err != nil {
+			return "", "", "", err
+		}
+		if head != "" && head != flags.SHA {
+			logger.Infof("Posting to pull request head %s instead of %s", head, flags.SHA)
+			return head, "", "the pull request head in GITHUB_EVENT_PATH", nil
+		}
+	}
+	if flags.SHAFile != "" {
+		return flags.SHA, "", "-sha-file " + flags.SHAFile, nil
+	}
+	return flags.SHA, "", "-s", nil
+}
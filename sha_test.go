@@ -0,0 +1,144 @@
+package main
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestReadSHAFile(t *testing.T) {
+	path := writeTempFile(t, "sha", "  deadbeef\n\n")
+	if sha, err := readSHAFile(path); err != nil || sha != "deadbeef" {
+		t.Errorf("Expected the trimmed SHA, got %q, %v", sha, err)
+	}
+
+	missing := filepath.Join(t.TempDir(), "sha")
+	if _, err := readSHAFile(missing); err == nil || !strings.Contains(err.Error(), missing) {
+		t.Errorf("Expected an error naming the missing file, got %v", err)
+	}
+	empty := writeTempFile(t, "empty", " \n")
+	if _, err := readSHAFile(empty); err == nil || !strings.Contains(err.Error(), empty+" is empty") {
+		t.Errorf("Expected an error naming the empty file, got %v", err)
+	}
+}
+
+func TestApplySHAFilePrecedence(t *testing.T) {
+	path := writeTempFile(t, "sha", "deadbeef\n")
+	for _, test := range []struct {
+		name     string
+		sha      string
+		origins  map[string]string
+		expected string
+		err      string
+	}{
+		{"file only", "", map[string]string{"sha-file": "-sha-file"}, "deadbeef", ""},
+		{"file from the environment", "", map[string]string{"sha-file": "BUILD_SHA_FILE"}, "deadbeef", ""},
+		{"flag beats the environment", "cafebabe", map[string]string{"s": "-s", "sha-file": "BUILD_SHA_FILE"}, "cafebabe", ""},
+		{"file beats the environment", "cafebabe", map[string]string{"s": "BUILD_SHA", "sha-file": "-sha-file"}, "deadbeef", ""},
+		{"both flags", "cafebabe", map[string]string{"s": "-s", "sha-file": "-sha-file"}, "", "-s and -sha-file can't be used together"},
+		{"both variables", "cafebabe", map[string]string{"s": "BUILD_SHA", "sha-file": "BUILD_SHA_FILE"}, "", "BUILD_SHA and BUILD_SHA_FILE can't both be set"},
+	} {
+		flags := &Flags{SHA: test.sha, SHAFile: path}
+		err := applySHAFile(flags, test.origins)
+		if test.err != "" {
+			if err == nil || !strings.Contains(err.Error(), test.err) {
+				t.Errorf("%s: expected %q, got %v", test.name, test.err, err)
+			}
+			continue
+		}
+		if err != nil || flags.SHA != test.expected {
+			t.Errorf("%s: expected SHA %q, got %q, %v", test.name, test.expected, flags.SHA, err)
+		}
+	}
+}
+
+func TestValidateRequiredFlagsNamesSHAFile(t *testing.T) {
+	flags := defaultFlags()
+	flags.SHA, flags.SHAFile = "not-a-sha", ".ci/sha"
+	if err := validateRequiredFlags(*flags); err == nil || !strings.Contains(err.Error(), `-sha-file .ci/sha has "not-a-sha"`) {
+		t.Errorf("Expected the file's SHA to be validated, got %v", err)
+	}
+}
+
+func TestCLIReadsSHAFile(t *testing.T) {
+	path := writeTempFile(t, "sha", "deadbeef\n")
+	out, code := runCLIWithEnv(t, []string{"BUILD_SHA=cafebabe"}, "-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-sha-file", path, "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", "exit 3")
+	if code != 1 || strings.Contains(out, "Error") {
+		t.Errorf("Expected the failure to be posted to the SHA from the file, got %d:\n%s", code, out)
+	}
+
+	out, code = runCLI(t, "-r", "org/repo", "-sha-file", filepath.Join(t.TempDir(), "sha"), "-c", "ci", "-a", "token", "true")
+	if code != 1 || !strings.Contains(out, "doesn't exist") {
+		t.Errorf("Expected a missing -sha-file to be a configuration error, got %d:\n%s", code, out)
+	}
+}
+
+// withSHAResolvers resets the resolved SHA and replaces the lookups for the
+// test, counting the calls to them in calls.
+func withSHAResolvers(t *testing.T, head string, calls *int) {
+	original := shaResolvers
+	reset := func() {
+		resolvedSHA.Lock()
+		resolvedSHA.done, resolvedSHA.sha, resolvedSHA.base = false, "", ""
+		resolvedSHA.Unlock()
+	}
+	reset()
+	shaResolvers.eventHead = func(string) (string, error) {
+		*calls++
+		return head, nil
+	}
+	shaResolvers.commitRange = func(string) (string, string, error) {
+		*calls++
+		return "1111111111111111111111111111111111111111", head, nil
+	}
+	t.Cleanup(func() {
+		shaResolvers = original
+		reset()
+	})
+}
+
+func TestResolveSHAOnce(t *testing.T) {
+	var calls int
+	withSHAResolvers(t, "cafebabe", &calls)
+	flags := defaultFlags()
+	flags.PreferHeadSHA = true
+	for i := 0; i < 3; i++ {
+		copied := *flags
+		if err := resolveSHA(&copied); err != nil || copied.SHA != "cafebabe" {
+			t.Errorf("Expected the pull request head, got %q, %v", copied.SHA, err)
+		}
+	}
+	if calls != 1 {
+		t.Errorf("Expected the SHA to be resolved once, got %d lookups", calls)
+	}
+}
+
+func TestLookupSHAPrecedence(t *testing.T) {
+	logs := withLogger(t, logDebug)
+	var calls int
+	withSHAResolvers(t, "cafebabe", &calls)
+	flags := defaultFlags()
+
+	if sha, _, source, _ := lookupSHA(*flags); sha != "deadbeef" || source != "-s" {
+		t.Errorf("Expected -s, got %s from %s", sha, source)
+	}
+	flags.SHAFile = "sha.txt"
+	if sha, _, source, _ := lookupSHA(*flags); sha != "deadbeef" || source != "-sha-file sha.txt" {
+		t.Errorf("Expected -sha-file, got %s from %s", sha, source)
+	}
+	flags.SHAFile, flags.PreferHeadSHA = "", true
+	if sha, _, source, _ := lookupSHA(*flags); sha != "cafebabe" || !strings.Contains(source, "pull request head") {
+		t.Errorf("Expected the pull request head, got %s from %s", sha, source)
+	}
+	flags.PreferHeadSHA, flags.Range = false, "v1.0.0..main"
+	if sha, base, source, _ := lookupSHA(*flags); sha != "cafebabe" || base != "1111111111111111111111111111111111111111" || source != "-range v1.0.0..main" {
+		t.Errorf("Expected the range, got %s..%s from %s", base, sha, source)
+	}
+
+	withSHAResolvers(t, "", &calls)
+	flags.Range, flags.PreferHeadSHA = "", true
+	if err := resolveSHA(flags); err != nil || flags.SHA != "deadbeef" || !strings.Contains(logs.String(), "Reporting on deadbeef from -s") {
+		t.Errorf("Expected -s outside pull request events, got %q, %v:\n%s", flags.SHA, err, logs)
+	}
+}
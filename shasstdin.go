@@ -0,0 +1,156 @@
+package main
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// validateSHAsStdinFlags checks -shas-stdin and -shas-interval.
+func validateSHAsStdinFlags(flags Flags, command []string) error {
+	if !flags.SHAsStdin {
+		if flags.SHAsInterval != 0 {
+			return errors.New("Error: -shas-interval requires -shas-stdin")
+		}
+		return nil
+	}
+	var errs multiError
+	if len(command) > 0 || flags.ScriptFile != "" || flags.CmdFD != "" {
+		errs = append(errs, errors.New("Error: -shas-stdin runs no command"))
+	}
+	if flags.SkipCommand {
+		errs = append(errs, errors.New("Error: -shas-stdin and -skip-command can't be used together"))
+	}
+	if flags.Range != "" || flags.AllPRCommits {
+		errs = append(errs, errors.New("Error: -shas-stdin posts to the SHAs it reads, so it can't be used with -range or -all-pr-commits"))
+	}
+	if flags.SHAsInterval < 0 {
+		errs = append(errs, errors.New("Error: -shas-interval must not be negative"))
+	}
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// readSHAs reads the newline separated SHAs of -shas-stdin, ignoring
+// surrounding whitespace, blank lines and repeats. Every line that isn't a
+// commit SHA is reported, by line number, before anything is posted.
+func readSHAs(r io.Reader) ([]string, error) {
+	var shas []string
+	var errs multiError
+	seen := map[string]bool{}
+	scanner := bufio.NewScanner(r)
+	for line := 1; scanner.Scan(); line++ {
+		sha := strings.TrimSpace(scanner.Text())
+		switch {
+		case sha == "" || seen[sha]:
+		case !commitSHAPattern.MatchString(sha):
+			errs = append(errs, fmt.Errorf("Error: -shas-stdin line %d %q is not a commit SHA", line, sha))
+		default:
+			seen[sha] = true
+			shas = append(shas, sha)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("Error reading -shas-stdin: %s", err)
+	}
+	if len(errs) > 0 {
+		return nil, errs
+	}
+	if len(shas) == 0 {
+		return nil, errors.New("Error: -shas-stdin read no SHAs")
+	}
+	return shas, nil
+}
+
+// throttle spaces out calls to wait so that at most one returns per
+// interval. A zero interval never waits.
+type throttle struct {
+	interval time.Duration
+
+	mu   sync.Mutex
+	next time.Time
+}
+
+func (t *throttle) wait() {
+	if t.interval <= 0 {
+		return
+	}
+	t.mu.Lock()
+	now := time.Now()
+	slot := t.next
+	if slot.Before(now) {
+		slot = now
+	}
+	t.next = slot.Add(t.interval)
+	t.mu.Unlock()
+	time.Sleep(slot.Sub(now))
+}
+
+// runSHAsStdin implements -shas-stdin: it posts the -state final status to
+// every SHA read from stdin, in each repository, without running anything.
+// Up to parallel SHAs are posted at once, starting no more than one per
+// -shas-interval. Failures are collected and, like -all-pr-commits, fail the
+// run with -strict or when every post failed.
+func runSHAsStdin(flags Flags, parallel int, stdin io.Reader) error {
+	shas, err := readSHAs(stdin)
+	if err != nil {
+		return err
+	}
+	if parallel < 1 {
+		parallel = 1
+	}
+	state := skippedCommandState(flags)
+	logger.Infof("Not running a command, reporting %s to %d commits", state, len(shas))
+
+	var mu sync.Mutex
+	var targets []statusTarget
+	var errs multiError
+	failed := map[string]bool{}
+	limit := &throttle{interval: flags.SHAsInterval}
+	queue := make(chan string)
+	var wg sync.WaitGroup
+	for i := 0; i < parallel; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for sha := range queue {
+				shaFlags := flags
+				shaFlags.SHA = sha
+				shaTargets, err := statusTargets(shaFlags)
+				if err != nil {
+					mu.Lock()
+					failed[sha] = true
+					errs = append(errs, err)
+					mu.Unlock()
+					continue
+				}
+				deadline := runDeadline.report(shaTargets, shaFlags)
+				limit.wait()
+				runDeadline.begin(deadline)
+				shaErrs := postEachStatus(shaTargets, shaFlags, state)
+				runDeadline.end(deadline, len(shaErrs) == 0)
+				mu.Lock()
+				targets = append(targets, shaTargets...)
+				for _, err := range shaErrs {
+					failed[sha] = true
+					errs = append(errs, fmt.Errorf("%s: %s", sha, err))
+				}
+				mu.Unlock()
+			}
+		}()
+	}
+	for _, sha := range shas {
+		queue <- sha
+	}
+	close(queue)
+	wg.Wait()
+
+	logger.Infof("Posted %s to %d of %d commits", state, len(shas)-len(failed), len(shas))
+	return tolerateFailures(errs, targets, flags, state)
+}
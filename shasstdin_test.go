@@ -0,0 +1,111 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"os"
+	"os/exec"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func TestReadSHAs(t *testing.T) {
+	shas, err := readSHAs(strings.NewReader("aaaaaaa\n  bbbbbbbb \n\naaaaaaa\r\ncccccccccccccccccccccccccccccccccccccccc"))
+	expected := []string{"aaaaaaa", "bbbbbbbb", strings.Repeat("c", 40)}
+	if err != nil || !reflect.DeepEqual(shas, expected) {
+		t.Errorf("Expected %q, got %q, %v", expected, shas, err)
+	}
+
+	_, err = readSHAs(strings.NewReader("aaaaaaa\nmain\nbbbbbbb\nabc\n"))
+	errs, ok := err.(multiError)
+	if !ok || len(errs) != 2 || !strings.Contains(errs[0].Error(), `line 2 "main"`) || !strings.Contains(errs[1].Error(), `line 4 "abc"`) {
+		t.Errorf("Expected every invalid line to be reported, got %v", err)
+	}
+	if _, err := readSHAs(strings.NewReader("\n\n")); err == nil || !strings.Contains(err.Error(), "read no SHAs") {
+		t.Errorf("Expected an error for no SHAs, got %v", err)
+	}
+}
+
+func TestValidateSHAsStdinFlags(t *testing.T) {
+	for _, test := range []struct {
+		flags   Flags
+		command []string
+		message string
+	}{
+		{Flags{}, nil, ""},
+		{Flags{SHAsStdin: true, SHAsInterval: time.Second}, nil, ""},
+		{Flags{SHAsInterval: time.Second}, nil, "requires -shas-stdin"},
+		{Flags{SHAsStdin: true}, []string{"make"}, "runs no command"},
+		{Flags{SHAsStdin: true, SkipCommand: true}, nil, "-skip-command"},
+		{Flags{SHAsStdin: true, Range: "a..b"}, nil, "-range"},
+		{Flags{SHAsStdin: true, SHAsInterval: -time.Second}, nil, "must not be negative"},
+	} {
+		err := validateSHAsStdinFlags(test.flags, test.command)
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %+v, got %v", test.message, test.flags, err)
+		}
+	}
+}
+
+func TestRunSHAsStdinPostsEachSHA(t *testing.T) {
+	withLogger(t, logError)
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		posted = append(posted, r.URL.Path+"="+params.State)
+		mu.Unlock()
+		if strings.HasSuffix(r.URL.Path, "/bbbbbbb") {
+			w.WriteHeader(http.StatusUnprocessableEntity)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.OrgRepo, flags.Repos, flags.State = "org/repo", "org/mirror", "failed"
+	flags.SHAsInterval = 10 * time.Millisecond
+	started := time.Now()
+	if err := runSHAsStdin(*flags, 2, strings.NewReader("aaaaaaa\nbbbbbbb\nccccccc\n")); err != nil {
+		t.Errorf("Expected the failed SHA to only be a warning, got %s", err)
+	}
+	if elapsed := time.Since(started); elapsed < 20*time.Millisecond {
+		t.Errorf("Expected -shas-interval to space out the posts, took %s", elapsed)
+	}
+	sort.Strings(posted)
+	expected := []string{}
+	for _, repo := range []string{"mirror", "repo"} {
+		for _, sha := range []string{"aaaaaaa", "bbbbbbb", "ccccccc"} {
+			expected = append(expected, "/repos/org/"+repo+"/statuses/"+sha+"=failure")
+		}
+	}
+	sort.Strings(expected)
+	if !reflect.DeepEqual(posted, expected) {
+		t.Errorf("Expected only the -state final status to each SHA, got %q", posted)
+	}
+
+	flags.Strict = true
+	err := runSHAsStdin(*flags, 2, strings.NewReader("aaaaaaa\nbbbbbbb\n"))
+	if err == nil || strings.Count(err.Error(), "bbbbbbb: ") != 2 {
+		t.Errorf("Expected -strict to fail with both of bbbbbbb's posts, got %v", err)
+	}
+}
+
+func TestCLISHAsStdin(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "-dry-run", "-shas-stdin", "-state", "passed", "-r", "org/repo", "-c", "ci", "-d", "unit test", "-a", "token")
+	cmd.Env = append(os.Environ(), "GHSR_TEST_MAIN=1")
+	cmd.Stdin = strings.NewReader("aaaaaaa\nbbbbbbb\nccccccc\n")
+	out, err := cmd.CombinedOutput()
+	if err != nil {
+		t.Fatalf("Expected the posts to pass, got %s:\n%s", err, out)
+	}
+	if !strings.Contains(string(out), "Posted success to 3 of 3 commits") {
+		t.Errorf("Expected a summary of the posts, got:\n%s", out)
+	}
+}
@@ -0,0 +1,69 @@
+package main
+
+import (
+	"errors"
+	"os"
+	"sync"
+	"time"
+)
+
+const defaultShutdownTimeout = 10 * time.Second
+
+// shutdownHandler turns the first shutdown signal into a graceful stop: the
+// command is stopped through Interrupt and the run carries on to post the
+// final status. If that takes longer than the timeout, or a second signal
+// arrives, the process exits without posting.
+type shutdownHandler struct {
+	Interrupt chan struct{}
+	exit      func(int)
+
+	mu  sync.Mutex
+	sig os.Signal
+}
+
+// handleShutdown starts handling the shutdown signals arriving on signals.
+func handleShutdown(timeout time.Duration, signals <-chan os.Signal) *shutdownHandler {
+	h := &shutdownHandler{Interrupt: make(chan struct{}), exit: exit}
+	go func() {
+		sig := <-signals
+		h.mu.Lock()
+		h.sig = sig
+		h.mu.Unlock()
+		logger.Warnf("Received %s, stopping the command and posting the final status within %s", sig, timeout)
+		close(h.Interrupt)
+
+		select {
+		case again := <-signals:
+			logger.Errorf("Received %s again, exiting without posting the final status", again)
+		case <-time.After(timeout):
+			logger.Errorf("Shutting down took longer than %s, exiting without posting the final status", timeout)
+		}
+		h.exit(signalExitCode(sig))
+	}()
+	return h
+}
+
+// received returns the shutdown signal, or nil if none arrived. It is safe
+// to call on a nil handler.
+func (h *shutdownHandler) received() os.Signal {
+	if h == nil {
+		return nil
+	}
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.sig
+}
+
+// apply reports a run cut short by a shutdown signal as an error, whether
+// or not the command got to exit on its own first, and returns the
+// description to report.
+func (h *shutdownHandler) apply(flags Flags, result *commandResult) string {
+	if h.received() == nil {
+		return flags.Description
+	}
+	if result.Err == nil {
+		result.Err = errors.New("command cancelled")
+	}
+	result.Errored, result.StatusOnly = true, false
+	return appendSuffix(flags.Description, "cancelled")
+}
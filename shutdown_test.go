@@ -0,0 +1,98 @@
+package main
+
+import (
+	"bufio"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestShutdownStopsCommandAndReportsError(t *testing.T) {
+	withLogger(t, logError)
+	signals := make(chan os.Signal, 2)
+	shutdown := handleShutdown(time.Minute, signals)
+	shutdown.exit = func(code int) { t.Errorf("Expected no forced exit, got %d", code) }
+
+	go func() {
+		time.Sleep(100 * time.Millisecond)
+		signals <- os.Interrupt
+	}()
+	started := time.Now()
+	result := runCommandAttempts(exec.Command("sleep", "10"), commandOptions{Retries: 3, Interrupt: shutdown.Interrupt})
+	if time.Since(started) > 5*time.Second {
+		t.Errorf("Expected the command to be stopped, it ran for %s", time.Since(started))
+	}
+	if !result.Interrupted || len(result.Attempts) != 1 {
+		t.Errorf("Expected one interrupted attempt, got %+v", result)
+	}
+
+	if description := shutdown.apply(*defaultFlags(), result); description != "unit test (cancelled)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if state := commandState(result); state != "error" {
+		t.Errorf("Expected a cancelled run to be an error, got %s", state)
+	}
+	if shutdown.received() != os.Interrupt {
+		t.Errorf("Expected the signal to be recorded, got %v", shutdown.received())
+	}
+}
+
+func TestShutdownExitsAfterTimeout(t *testing.T) {
+	withLogger(t, logError)
+	signals := make(chan os.Signal, 2)
+	shutdown := handleShutdown(50*time.Millisecond, signals)
+	exited := make(chan int, 1)
+	shutdown.exit = func(code int) { exited <- code }
+
+	signals <- os.Interrupt
+	select {
+	case code := <-exited:
+		if code != signalExitCode(os.Interrupt) {
+			t.Errorf("Expected exit code %d, got %d", signalExitCode(os.Interrupt), code)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("Expected the shutdown timeout to force an exit")
+	}
+}
+
+func TestShutdownApplyWithoutSignal(t *testing.T) {
+	var shutdown *shutdownHandler
+	result := &commandResult{}
+	if description := shutdown.apply(*defaultFlags(), result); description != "unit test" || result.Err != nil {
+		t.Errorf("Expected the run to be left alone, got %q and %v", description, result.Err)
+	}
+}
+
+func TestCLIPostsFinalStatusOnSIGTERM(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("SIGTERM can't be sent on Windows")
+	}
+	cmd := exec.Command(os.Args[0], "-replay", filepath.Join("testdata", "replay-cancelled.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+		"sh", "-c", "echo started; sleep 10")
+	cmd.Env = append(os.Environ(), "GHSR_TEST_MAIN=1")
+	var stderr strings.Builder
+	cmd.Stderr = &stderr
+	stdout, _ := cmd.StdoutPipe()
+	if err := cmd.Start(); err != nil {
+		t.Fatalf("Error running the CLI: %s", err)
+	}
+	if line, _ := bufio.NewReader(stdout).ReadString('\n'); line != "started\n" {
+		t.Fatalf("Expected the command to start, got %q", line)
+	}
+	cmd.Process.Signal(syscall.SIGTERM)
+
+	err := cmd.Wait()
+	exitErr, ok := err.(*exec.ExitError)
+	if !ok || exitErr.ExitCode() != 128+int(syscall.SIGTERM) {
+		t.Errorf("Expected exit code %d, got %v:\n%s", 128+int(syscall.SIGTERM), err, stderr.String())
+	}
+	if !strings.Contains(stderr.String(), "Received terminated") {
+		t.Errorf("Expected the shutdown to be logged, got:\n%s", stderr.String())
+	}
+}
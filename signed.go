@@ -0,0 +1,56 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// commitVerification is the signature verification Github reports for a
+// commit.
+type commitVerification struct {
+	Verified bool   `json:"verified"`
+	Reason   string `json:"reason"`
+}
+
+// verifyCommitSignature returns why target's commit doesn't satisfy
+// -require-signed-commit, or "" if its GPG or SSH signature is verified.
+func verifyCommitSignature(target statusTarget, flags Flags) (string, error) {
+	var commit struct {
+		Commit struct {
+			Verification commitVerification `json:"verification"`
+		} `json:"commit"`
+	}
+	url := githubAPIURL + "/repos/" + target.OrgRepo + "/commits/" + target.SHA
+	if err := getGithubJSON(url, flags, &commit); err != nil {
+		return "", fmt.Errorf("Error checking the signature of %s: %s", target.SHA, err)
+	}
+	return unsignedReason(target.SHA, commit.Commit.Verification), nil
+}
+
+// unsignedReason describes an unverified commit with the reason Github gives,
+// such as unsigned or bad_email.
+func unsignedReason(sha string, verification commitVerification) string {
+	if verification.Verified {
+		return ""
+	}
+	if len(sha) > 7 {
+		sha = sha[:7]
+	}
+	reason := verification.Reason
+	if reason == "" {
+		reason = "unverified"
+	}
+	return fmt.Sprintf("commit %s is not signed: %s", sha, reason)
+}
+
+// applySignedCommit fails a successful run whose commit isn't verified,
+// with reason from verifyCommitSignature in the description. The exit code
+// is unchanged.
+func applySignedCommit(flags Flags, result *commandResult, reason string) string {
+	if reason == "" || result.Err != nil {
+		return flags.Description
+	}
+	result.Err = errors.New(reason)
+	result.StatusOnly = true
+	return appendSuffix(flags.Description, reason)
+}
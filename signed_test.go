@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func TestVerifyCommitSignature(t *testing.T) {
+	payload := ""
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != "GET" || r.URL.Path != "/repos/org/repo/commits/deadbeefcafe" {
+			t.Errorf("Unexpected request %s %s", r.Method, r.URL.Path)
+		}
+		fmt.Fprint(w, payload)
+	})()
+
+	target := statusTarget{"org/repo", "deadbeefcafe"}
+	for verification, expected := range map[string]string{
+		`{"verified": true, "reason": "valid"}`:         "",
+		`{"verified": false, "reason": "unsigned"}`:     "commit deadbee is not signed: unsigned",
+		`{"verified": false, "reason": "bad_email"}`:    "commit deadbee is not signed: bad_email",
+		`{"verified": false, "reason": ""}`:             "commit deadbee is not signed: unverified",
+		`{"verified": true, "signature": "-----BEGIN"}`: "",
+	} {
+		payload = `{"sha": "deadbeefcafe", "commit": {"message": "m", "verification": ` + verification + `}}`
+		reason, err := verifyCommitSignature(target, *defaultFlags())
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		if reason != expected {
+			t.Errorf("Expected %q for %s, got %q", expected, verification, reason)
+		}
+	}
+}
+
+func TestVerifyCommitSignatureFailsClosed(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNotFound)
+		fmt.Fprint(w, `{"message": "No commit found for SHA: deadbeef"}`)
+	})()
+	if _, err := verifyCommitSignature(statusTarget{"org/repo", "deadbeef"}, *defaultFlags()); err == nil || !strings.Contains(err.Error(), "Error checking the signature of deadbeef") {
+		t.Errorf("Expected an error when the commit can't be checked, got %v", err)
+	}
+}
+
+func TestApplySignedCommit(t *testing.T) {
+	flags := defaultFlags()
+	result := &commandResult{}
+	if description := applySignedCommit(*flags, result, ""); description != "unit test" || commandState(result) != "success" {
+		t.Errorf("Expected a verified commit to keep success, got %q and %s", description, commandState(result))
+	}
+
+	description := applySignedCommit(*flags, result, "commit deadbee is not signed: unsigned")
+	if description != "unit test (commit deadbee is not signed: unsigned)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if commandState(result) != "failure" || !result.StatusOnly {
+		t.Errorf("Expected a status-only failure, got %s and %t", commandState(result), result.StatusOnly)
+	}
+
+	failed := &commandResult{Err: errors.New("exit status 1"), ExitCode: 1}
+	if description := applySignedCommit(*flags, failed, "commit deadbee is not signed: unsigned"); description != "unit test" || failed.StatusOnly {
+		t.Errorf("Expected a failed command to be left alone, got %q", description)
+	}
+}
+
+func TestRequireSignedCommitErrorNeedsRequireSignedCommit(t *testing.T) {
+	flags := defaultFlags()
+	flags.RequireSignedCommitError = true
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "requires -require-signed-commit") {
+		t.Errorf("Expected an error, got %v", err)
+	}
+}
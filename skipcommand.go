@@ -0,0 +1,67 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// validateSkipCommandFlags checks -skip-command and -state.
+func validateSkipCommandFlags(flags Flags, command []string) error {
+	if !flags.SkipCommand {
+		if flags.State == "" {
+			return nil
+		}
+		if !flags.SHAsStdin {
+			return errors.New("Error: -state requires -skip-command or -shas-stdin")
+		}
+		_, err := providerState("github", flags.State)
+		return err
+	}
+	if len(command) > 0 || flags.ScriptFile != "" {
+		return errors.New("Error: -skip-command runs no command")
+	}
+	if flags.State == "" {
+		return nil
+	}
+	_, err := providerState("github", flags.State)
+	return err
+}
+
+// skippedCommandState is the state -skip-command ends with: -state mapped to
+// a GitHub state, or success.
+func skippedCommandState(flags Flags) string {
+	if flags.State == "" {
+		return "success"
+	}
+	state, _ := providerState("github", flags.State)
+	return state
+}
+
+// runSkipCommand implements -skip-command: it posts pending and then the
+// -state final status without running anything, for results computed
+// elsewhere.
+func runSkipCommand(flags Flags) error {
+	targets, err := statusTargets(flags)
+	if err != nil {
+		return err
+	}
+	plugins := &pluginNotifier{Plugins: flags.NotifyPlugins, Timeout: flags.PluginTimeout}
+	statusReporter := &reporter{flags: flags, targets: targets, plugins: plugins, deadline: runDeadline.report(targets, flags)}
+	if err := statusReporter.report("pending", nil); err != nil {
+		return err
+	}
+
+	state := skippedCommandState(flags)
+	if state == "pending" {
+		// -state queued or running leaves the build in progress for
+		// whatever computes the result to finish.
+		logger.Infof("Not running a command, leaving the status pending")
+		return nil
+	}
+	result := &commandResult{}
+	if state != "success" {
+		result.Err, result.ExitCode, result.Errored = fmt.Errorf("-state %s", state), 1, state == "error"
+	}
+	logger.Infof("Not running a command, reporting %s", state)
+	return statusReporter.report(state, result)
+}
@@ -0,0 +1,77 @@
+package main
+
+import (
+	"encoding/json"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestValidateSkipCommandFlags(t *testing.T) {
+	for _, test := range []struct {
+		skip    bool
+		state   string
+		command []string
+		message string
+	}{
+		{true, "", nil, ""},
+		{true, "failure", nil, ""},
+		{true, "error", nil, ""},
+		{false, "", []string{"make"}, ""},
+		{true, "failed", nil, ""},
+		{true, "running", nil, ""},
+		{true, "skipped", nil, "-state must be one of queued, running, passed, failed, broken, a github state (error, failure, pending, success) or an alias ("},
+		{true, "OK", nil, ""},
+		{true, "", []string{"make"}, "-skip-command runs no command"},
+		{false, "success", []string{"make"}, "-state requires -skip-command"},
+	} {
+		err := validateSkipCommandFlags(Flags{SkipCommand: test.skip, State: test.state}, test.command)
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %+v, got %v", test.message, test, err)
+		}
+	}
+
+	flags := defaultFlags()
+	flags.SkipCommand = true
+	if err := validateFlags(*flags, nil, ""); err != nil {
+		t.Errorf("Expected -skip-command to need no command, got %s", err)
+	}
+	flags.Dev = true
+	if err := validateFlags(*flags, nil, ""); err == nil || !strings.Contains(err.Error(), "can't be used with -skip-command") {
+		t.Errorf("Expected -dev to be rejected, got %v", err)
+	}
+}
+
+func TestRunSkipCommandPostsLifecycle(t *testing.T) {
+	for state, expected := range map[string]string{"": "pending success", "failure": "pending failure", "error": "pending error", "passed": "pending success", "broken": "pending error", "queued": "pending"} {
+		var states []string
+		teardown := withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+			var params CommitStatusParams
+			json.NewDecoder(r.Body).Decode(&params)
+			states = append(states, params.State)
+			w.WriteHeader(http.StatusCreated)
+		})
+
+		flags := defaultFlags()
+		flags.SkipCommand, flags.State = true, state
+		if err := runSkipCommand(*flags); err != nil {
+			t.Errorf("Got unexpected error: %s", err)
+		}
+		teardown()
+		if strings.Join(states, " ") != expected {
+			t.Errorf("Expected %q for -state %q, got %q", expected, state, states)
+		}
+	}
+}
+
+func TestCLISkipCommand(t *testing.T) {
+	out, code := runCLI(t, "-replay", filepath.Join("testdata", "replay-failure.json"), "-skip-command", "-state", "failure",
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token")
+	if code != 0 {
+		t.Errorf("Expected exit code 0 once both statuses are posted, got %d:\n%s", code, out)
+	}
+	if strings.Contains(out, "Error") {
+		t.Errorf("Expected the replayed pending and failure posts to match, got:\n%s", out)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// runState is the -state-file contents: the state last posted.
+type runState struct {
+	SHA       string `json:"sha"`
+	Context   string `json:"context"`
+	State     string `json:"state"`
+	UpdatedAt string `json:"updated_at"`
+}
+
+// writeStateFile replaces path with state. The file is written next to path
+// and renamed into place, so readers polling it never see a partial write.
+func writeStateFile(path string, state runState) error {
+	contents, err := json.MarshalIndent(state, "", "  ")
+	if err != nil {
+		return fmt.Errorf("Error converting %+v to json %s.", state, err)
+	}
+	tmp, err := ioutil.TempFile(filepath.Dir(path), filepath.Base(path)+".tmp")
+	if err != nil {
+		return fmt.Errorf("Error writing state file %s: %s", path, err)
+	}
+	_, err = tmp.Write(append(contents, '\n'))
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), path)
+	}
+	if err != nil {
+		os.Remove(tmp.Name())
+		return fmt.Errorf("Error writing state file %s: %s", path, err)
+	}
+	return nil
+}
+
+// recordState writes the state just posted to -state-file, if set.
+func recordState(flags Flags, state string) {
+	if flags.StateFile == "" {
+		return
+	}
+	err := writeStateFile(flags.StateFile, runState{
+		SHA:       flags.SHA,
+		Context:   flags.Context,
+		State:     state,
+		UpdatedAt: now().UTC().Format(time.RFC3339),
+	})
+	if err != nil {
+		logger.Warnf("%s", err)
+	}
+}
+
+// cleanupStateFile removes -state-file at exit with -state-file-cleanup.
+func cleanupStateFile(flags Flags) {
+	if flags.StateFile != "" && flags.StateFileCleanup {
+		if err := os.Remove(flags.StateFile); err != nil && !os.IsNotExist(err) {
+			logger.Warnf("Error removing state file: %s", err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func readStateFile(t *testing.T, path string) runState {
+	contents, err := ioutil.ReadFile(path)
+	if err != nil {
+		t.Fatalf("Error reading state file: %s", err)
+	}
+	var state runState
+	if err := json.Unmarshal(contents, &state); err != nil {
+		t.Fatalf("Error parsing state file: %s", err)
+	}
+	return state
+}
+
+func TestStateFileFollowsTransitions(t *testing.T) {
+	defer withClock(time.Date(2017, 6, 1, 12, 30, 0, 0, time.UTC))()
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	dir, err := ioutil.TempDir("", "gh-status-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	flags := defaultFlags()
+	flags.StateFile = filepath.Join(dir, "state.json")
+	targets, _ := statusTargets(*flags)
+	r := &reporter{flags: *flags, targets: targets}
+
+	for _, state := range []string{"pending", "failure"} {
+		if err := r.report(state, nil); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+		expected := runState{SHA: "deadbeef", Context: "ci", State: state, UpdatedAt: "2017-06-01T12:30:00Z"}
+		if got := readStateFile(t, flags.StateFile); got != expected {
+			t.Errorf("Expected %+v, got %+v", expected, got)
+		}
+	}
+
+	files, _ := ioutil.ReadDir(dir)
+	if len(files) != 1 {
+		t.Errorf("Expected no temporary files to be left behind, got %d files", len(files))
+	}
+
+	flags.StateFileCleanup = true
+	cleanupStateFile(*flags)
+	if _, err := os.Stat(flags.StateFile); !os.IsNotExist(err) {
+		t.Errorf("Expected -state-file-cleanup to remove the file, got %v", err)
+	}
+}
+
+func TestStateFileNotWrittenWhenPostFails(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})()
+
+	flags := defaultFlags()
+	flags.StateFile = filepath.Join(os.TempDir(), "gh-status-reporter-failed-state.json")
+	defer os.Remove(flags.StateFile)
+	targets, _ := statusTargets(*flags)
+	r := &reporter{flags: *flags, targets: targets}
+	if err := r.report("pending", nil); err == nil {
+		t.Fatalf("Expected the post to fail")
+	}
+	if _, err := os.Stat(flags.StateFile); !os.IsNotExist(err) {
+		t.Errorf("Expected no state file for a state that wasn't posted")
+	}
+}
+
+func TestStateFileWriteFailureWarns(t *testing.T) {
+	logs := withLogger(t, logWarn)
+	flags := defaultFlags()
+	flags.StateFile = filepath.Join(t.TempDir(), "missing", "state.json")
+	recordState(*flags, "pending")
+	if !strings.HasPrefix(logs.String(), "Warning: ") {
+		t.Errorf("Expected a warning when the state file can't be written, got %q", logs)
+	}
+}
@@ -0,0 +1,104 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// The provider agnostic state vocabulary -state accepts, so scripts don't
+// depend on one provider's state names.
+const (
+	stateQueued  = "queued"
+	stateRunning = "running"
+	statePassed  = "passed"
+	stateFailed  = "failed"
+	stateBroken  = "broken"
+)
+
+// canonicalStates lists the vocabulary in lifecycle order.
+var canonicalStates = []string{stateQueued, stateRunning, statePassed, stateFailed, stateBroken}
+
+// providerStates maps the canonical states to each provider's own. Statuses
+// are only posted to GitHub, whose commit statuses have no separate queued and
+// running states.
+var providerStates = map[string]map[string]string{
+	"github": {
+		stateQueued:  "pending",
+		stateRunning: "pending",
+		statePassed:  "success",
+		stateFailed:  "failure",
+		stateBroken:  "error",
+	},
+}
+
+// stateAliases are the other names scripts and other status tools use for
+// the canonical states.
+var stateAliases = map[string]string{
+	"ok":          statePassed,
+	"pass":        statePassed,
+	"succeeded":   statePassed,
+	"successful":  statePassed,
+	"fail":        stateFailed,
+	"failing":     stateFailed,
+	"err":         stateBroken,
+	"errored":     stateBroken,
+	"waiting":     stateQueued,
+	"started":     stateRunning,
+	"in_progress": stateRunning,
+}
+
+// normalizeState returns state lower cased and trimmed, with an alias
+// replaced by the canonical state it stands for.
+func normalizeState(state string) string {
+	state = strings.ToLower(strings.TrimSpace(state))
+	if canonical, ok := stateAliases[state]; ok {
+		return canonical
+	}
+	return state
+}
+
+// providerState returns provider's name for state, which is a canonical
+// state, one of its aliases or already one of the provider's.
+func providerState(provider, state string) (string, error) {
+	mapping, ok := providerStates[provider]
+	if !ok {
+		return "", fmt.Errorf("Error: no state mapping for provider %q", provider)
+	}
+	given := state
+	state = normalizeState(state)
+	if mapped, ok := mapping[state]; ok {
+		return mapped, nil
+	}
+	for _, native := range mapping {
+		if native == state {
+			return state, nil
+		}
+	}
+	return "", fmt.Errorf("Error: -state must be one of %s, a %s state (%s) or an alias (%s), got %q", strings.Join(canonicalStates, ", "), provider, strings.Join(nativeStates(provider), ", "), strings.Join(aliasNames(), ", "), given)
+}
+
+// nativeStates returns the provider's states the canonical ones map to,
+// sorted.
+func nativeStates(provider string) []string {
+	seen := map[string]bool{}
+	var states []string
+	for _, native := range providerStates[provider] {
+		if !seen[native] {
+			seen[native] = true
+			states = append(states, native)
+		}
+	}
+	sort.Strings(states)
+	return states
+}
+
+// aliasNames returns the state aliases, sorted.
+func aliasNames() []string {
+	var names []string
+	for alias := range stateAliases {
+		names = append(names, alias)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,66 @@
+package main
+
+import (
+	"strconv"
+	"strings"
+	"testing"
+)
+
+func TestProviderStates(t *testing.T) {
+	expected := map[string]map[string]string{
+		"github": {"queued": "pending", "running": "pending", "passed": "success", "failed": "failure", "broken": "error"},
+	}
+	for provider, mapping := range providerStates {
+		if _, ok := expected[provider]; !ok {
+			t.Errorf("Provider %q has no expected mapping", provider)
+		}
+		for _, state := range canonicalStates {
+			if _, ok := mapping[state]; !ok {
+				t.Errorf("Provider %q doesn't map %q", provider, state)
+			}
+		}
+	}
+	for provider, mapping := range expected {
+		for state, native := range mapping {
+			if got, err := providerState(provider, state); err != nil || got != native {
+				t.Errorf("Expected %s %q to map to %q, got %q %v", provider, state, native, got, err)
+			}
+			if got, err := providerState(provider, native); err != nil || got != native {
+				t.Errorf("Expected the %s state %q to be kept, got %q %v", provider, native, got, err)
+			}
+		}
+	}
+
+	if _, err := providerState("github", "skipped"); err == nil || !strings.Contains(err.Error(), "-state must be one of") {
+		t.Errorf("Expected an unknown state to be rejected, got %v", err)
+	}
+	if _, err := providerState("gitlab", "passed"); err == nil || !strings.Contains(err.Error(), `no state mapping for provider "gitlab"`) {
+		t.Errorf("Expected an unknown provider to be rejected, got %v", err)
+	}
+}
+
+func TestStateAliases(t *testing.T) {
+	for alias, expected := range map[string]string{
+		"ok": "success", "pass": "success", "Succeeded": "success", "successful": "success",
+		"fail": "failure", "FAILING": "failure",
+		"err": "error", "errored": "error",
+		"waiting": "pending", "started": "pending", "in_progress": "pending",
+		" passed ": "success", "Failure": "failure",
+	} {
+		if got, err := providerState("github", alias); err != nil || got != expected {
+			t.Errorf("Expected %q to map to %q, got %q %v", alias, expected, got, err)
+		}
+	}
+	for alias, canonical := range stateAliases {
+		if _, ok := providerStates["github"][canonical]; !ok {
+			t.Errorf("Alias %q maps to %q, which isn't a canonical state", alias, canonical)
+		}
+	}
+
+	for _, unknown := range []string{"skipped", "okay", "cancelled", ""} {
+		_, err := providerState("github", unknown)
+		if err == nil || !strings.Contains(err.Error(), "an alias (err, errored, fail,") || !strings.Contains(err.Error(), "got "+strconv.Quote(unknown)) {
+			t.Errorf("Expected %q to be rejected with the accepted states, got %v", unknown, err)
+		}
+	}
+}
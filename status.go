@@ -0,0 +1,279 @@
+package main
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+var githubAPIURL = "https://api.github.com"
+
+var orgRepoPattern = regexp.MustCompile(`^[A-Za-z0-9_.-]+/[A-Za-z0-9_.-]+$`)
+
+// statusTarget is a repository and commit that statuses are posted to.
+type statusTarget struct {
+	OrgRepo string
+	SHA     string
+}
+
+func (t statusTarget) url() string {
+	return githubAPIURL + "/repos/" + t.OrgRepo + "/statuses/" + t.SHA
+}
+
+// multiError collects the errors from operations that continue past failures.
+type multiError []error
+
+func (m multiError) Error() string {
+	messages := make([]string, len(m))
+	for i, err := range m {
+		messages[i] = err.Error()
+	}
+	return strings.Join(messages, "\n")
+}
+
+// parseRepos splits a comma separated -repos value, validating each entry.
+func parseRepos(repos string) ([]string, error) {
+	var parsed []string
+	for _, repo := range strings.Split(repos, ",") {
+		repo = strings.TrimSpace(repo)
+		if repo == "" {
+			continue
+		}
+		if !orgRepoPattern.MatchString(repo) {
+			return nil, fmt.Errorf("Error: %q is not in the form organization/repository", repo)
+		}
+		parsed = append(parsed, repo)
+	}
+	return parsed, nil
+}
+
+// statusTargets returns every repository/SHA pair the run reports to. With
+// -range each repository has the head SHA first and then the base SHA.
+func statusTargets(flags Flags) ([]statusTarget, error) {
+	repos, err := parseRepos(flags.OrgRepo + "," + flags.Repos)
+	if err != nil {
+		return nil, err
+	}
+
+	shas := []string{flags.SHA}
+	if flags.RangeBase != "" && flags.RangeBase != flags.SHA {
+		shas = append(shas, flags.RangeBase)
+	}
+
+	var targets []statusTarget
+	seen := make(map[string]bool)
+	for _, repo := range repos {
+		if seen[repo] {
+			continue
+		}
+		seen[repo] = true
+		for _, sha := range shas {
+			targets = append(targets, statusTarget{OrgRepo: repo, SHA: sha})
+		}
+	}
+	return targets, nil
+}
+
+// targetError is a failure to post to one target.
+type targetError struct {
+	Target statusTarget
+	Err    error
+}
+
+func (e *targetError) Error() string {
+	return fmt.Sprintf("%s: %s", e.Target.OrgRepo, e.Err)
+}
+
+// postStatus posts state to every target. Failures for individual targets are
+// collected rather than stopping the others. Unless flags.Strict is set, the
+// returned error is nil as long as at least one post succeeded; the failures
+// are printed instead.
+func postStatus(targets []statusTarget, flags Flags, state string) error {
+	return tolerateFailures(postEachStatus(targets, flags, state), targets, flags, state)
+}
+
+// postEachStatus posts state to every target, returning a *targetError for
+// each one that failed.
+func postEachStatus(targets []statusTarget, flags Flags, state string) multiError {
+	var errs multiError
+	for _, target := range targets {
+		name := target.OrgRepo
+		if flags.RangeBase != "" || flags.AllPRCommits {
+			name += "@" + target.SHA
+		}
+		targetFlags := providerFlags(flags, target.OrgRepo)
+		targetFlags.SHA = target.SHA
+		if flags.SkipIfSame && statusUnchanged(target, targetFlags, state) {
+			logger.Infof("%s: status unchanged, skipping.", name)
+			continue
+		}
+		if err := setGithubCommitStatus(commitStatusMethod, target.url(), targetFlags, state); err != nil {
+			if flags.RangeBase != "" || flags.AllPRCommits {
+				err = fmt.Errorf("%s: %s", target.SHA, err)
+			}
+			errs = append(errs, &targetError{target, err})
+		}
+	}
+	return errs
+}
+
+// tolerateFailures decides whether the failed posts in errs fail the run.
+func tolerateFailures(errs multiError, targets []statusTarget, flags Flags, state string) error {
+	if len(errs) == 0 {
+		return nil
+	}
+	if flags.MaxAPICallsSoft && apiBudgetExhausted() {
+		logger.Warnf("failed to post %s status with the -max-api-calls budget used up:\n%s", state, errs)
+		return nil
+	}
+	if flags.Strict || len(errs) == len(targets) {
+		return errs
+	}
+	logger.Warnf("failed to post %s status to %d of %d repositories:\n%s", state, len(errs), len(targets), errs)
+	return nil
+}
+
+// statusUnchanged reports whether target already has the status that posting
+// state would create. If the current status can't be read it returns false so
+// the status is posted anyway.
+func statusUnchanged(target statusTarget, flags Flags, state string) bool {
+	current, err := getCombinedStatus(target, flags)
+	if err != nil {
+		logger.Warnf("could not read current status of %s: %s", target.OrgRepo, err)
+		return false
+	}
+	return sameStatus(current.find(flags.Context), *statusParams(flags, state))
+}
+
+// checkNotAlreadySucceeded returns an error if the context is already success
+// on any target, or if that can't be determined.
+func checkNotAlreadySucceeded(targets []statusTarget, flags Flags) error {
+	for _, target := range targets {
+		current, err := getCombinedStatus(target, flags)
+		if err != nil {
+			return err
+		}
+		context := providerFlags(flags, target.OrgRepo).Context
+		if existing := current.find(context); existing != nil && existing.State == "success" {
+			return fmt.Errorf("Error: %s is already success on %s@%s, refusing to run the command again", context, target.OrgRepo, target.SHA)
+		}
+	}
+	return nil
+}
+
+// checkNoBuildInFlight returns an error if the context is already pending on
+// any target, which means another build is still running, or if that can't
+// be determined. With -pending-max-age a pending status older than that is
+// taken to be left over from a build that died, and ignored.
+func checkNoBuildInFlight(targets []statusTarget, flags Flags) error {
+	for _, target := range targets {
+		current, err := getCombinedStatus(target, flags)
+		if err != nil {
+			return err
+		}
+		context := providerFlags(flags, target.OrgRepo).Context
+		existing := current.find(context)
+		if existing == nil || existing.State != "pending" {
+			continue
+		}
+		since := existing.UpdatedAt
+		if since == "" {
+			since = existing.CreatedAt
+		}
+		if posted, err := time.Parse(time.RFC3339, since); err == nil && flags.PendingMaxAge > 0 {
+			if age := now().Sub(posted); age > flags.PendingMaxAge {
+				logger.Infof("Ignoring the pending %s on %s@%s from %s ago, older than -pending-max-age", context, target.OrgRepo, target.SHA, age.Round(time.Second))
+				continue
+			}
+		}
+		return fmt.Errorf("Error: %s is already pending on %s@%s since %s, another build seems to be running; refusing to run the command", context, target.OrgRepo, target.SHA, since)
+	}
+	return nil
+}
+
+// reporter reports each state transition of a run to every target and to any
+// notification plugins.
+type reporter struct {
+	flags   Flags
+	targets []statusTarget
+	plugins *pluginNotifier
+	// deadline is the status -run-timeout posts the error status for.
+	deadline *timeoutReport
+
+	// postFailures counts failed posts per repository.
+	postFailures map[string]int
+	// webhookFailures counts failed -webhook-url posts.
+	webhookFailures int
+}
+
+// report posts state to the targets. result is nil until the command has
+// finished.
+func (r *reporter) report(state string, result *commandResult) error {
+	// After -run-timeout the timeout posts the final status.
+	final := state != "pending"
+	if final {
+		runDeadline.begin(r.deadline)
+	} else {
+		runDeadline.hold()
+	}
+	defer timeStatusPost(state, time.Now())
+	var err error
+	if result == nil || !r.flags.AsyncFinal || !postInBackground(r.targets, r.flags, state) {
+		err = r.post(state)
+		events.record(postedEvent(r.flags, state, err))
+	}
+	if final {
+		runDeadline.end(r.deadline, err == nil)
+	}
+	if r.plugins != nil && len(r.plugins.Plugins) > 0 {
+		for _, target := range r.targets {
+			r.plugins.notify(newStatusEvent(providerFlags(r.flags, pluginsProvider), target, state, result))
+		}
+	}
+	if r.flags.PayloadURL != "" {
+		for _, target := range r.targets {
+			if err := postPayload(r.flags, newStatusEvent(providerFlags(r.flags, target.OrgRepo), target, state, result)); err != nil {
+				logger.Warnf("could not post %s to -payload-url: %s", state, strings.TrimPrefix(err.Error(), "Error: "))
+			}
+		}
+	}
+	if r.flags.WebhookURL != "" {
+		for _, target := range r.targets {
+			if err := postWebhook(r.flags, newStatusEvent(providerFlags(r.flags, target.OrgRepo), target, state, result)); err != nil {
+				logger.Warnf("could not post %s to -webhook-url: %s", state, strings.TrimPrefix(err.Error(), "Error: "))
+				r.webhookFailures++
+			}
+		}
+	}
+	return err
+}
+
+// post posts state to the targets, counting the failures.
+func (r *reporter) post(state string) error {
+	errs := postEachStatus(r.targets, r.flags, state)
+	for _, err := range errs {
+		if failed, ok := err.(*targetError); ok {
+			if r.postFailures == nil {
+				r.postFailures = map[string]int{}
+			}
+			r.postFailures[failed.Target.OrgRepo]++
+		}
+	}
+	err := tolerateFailures(errs, r.targets, r.flags, state)
+	if err == nil {
+		recordState(r.flags, state)
+	}
+	return err
+}
+
+// commandState maps the command's result to a commit status state.
+func commandState(result *commandResult) string {
+	if result.Err == nil {
+		return "success"
+	}
+	if result.Errored {
+		return "error"
+	}
+	return "failure"
+}
@@ -0,0 +1,180 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+// withGithubAPI points githubAPIURL at a test server for the duration of a test.
+func withGithubAPI(t *testing.T, handler http.HandlerFunc) func() {
+	ts := httptest.NewServer(handler)
+	original := githubAPIURL
+	githubAPIURL = ts.URL
+	return func() {
+		githubAPIURL = original
+		ts.Close()
+	}
+}
+
+func TestParseRepos(t *testing.T) {
+	repos, err := parseRepos("org/a, org/b,,")
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if strings.Join(repos, " ") != "org/a org/b" {
+		t.Errorf("Unexpected repos %q", repos)
+	}
+
+	for _, invalid := range []string{"org", "org/a/b", "org/a,nope"} {
+		if _, err := parseRepos(invalid); err == nil {
+			t.Errorf("Expected error for %q", invalid)
+		}
+	}
+}
+
+func TestStatusTargetsIncludesPrimaryRepoOnce(t *testing.T) {
+	flags := defaultFlags()
+	flags.Repos = "org/a," + flags.OrgRepo
+
+	targets, err := statusTargets(*flags)
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if len(targets) != 2 || targets[0].OrgRepo != flags.OrgRepo || targets[1].OrgRepo != "org/a" {
+		t.Errorf("Unexpected targets %+v", targets)
+	}
+}
+
+func TestPostStatusPartialFailure(t *testing.T) {
+	var mu sync.Mutex
+	var posted []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		posted = append(posted, r.URL.Path)
+		mu.Unlock()
+		if strings.HasPrefix(r.URL.Path, "/repos/org/broken/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	targets := []statusTarget{{"org/a", "deadbeef"}, {"org/broken", "deadbeef"}, {"org/c", "deadbeef"}}
+
+	flags := defaultFlags()
+	if err := postStatus(targets, *flags, "pending"); err != nil {
+		t.Errorf("Expected partial failure to be tolerated, got %s", err)
+	}
+	if len(posted) != 3 {
+		t.Errorf("Expected every repository to be posted to, got %q", posted)
+	}
+
+	flags.Strict = true
+	err := postStatus(targets, *flags, "pending")
+	if err == nil || !strings.Contains(err.Error(), "org/broken") {
+		t.Errorf("Expected strict mode to report org/broken, got %v", err)
+	}
+
+	flags.Strict = false
+	err = postStatus(targets[1:2], *flags, "pending")
+	if err == nil {
+		t.Error("Expected an error when every post fails")
+	}
+}
+
+func TestReporterCountsPostFailures(t *testing.T) {
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasPrefix(r.URL.Path, "/repos/org/broken/") {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	targets := []statusTarget{{"org/a", "deadbeef"}, {"org/broken", "deadbeef"}}
+	statusReporter := &reporter{flags: *defaultFlags(), targets: targets}
+	statusReporter.report("pending", nil)
+	if err := statusReporter.report("success", &commandResult{}); err != nil {
+		t.Errorf("Expected partial failure to be tolerated, got %s", err)
+	}
+
+	if statusReporter.postFailures["org/broken"] != 2 || statusReporter.postFailures["org/a"] != 0 {
+		t.Errorf("Expected two failures for org/broken only, got %v", statusReporter.postFailures)
+	}
+}
+
+func TestCheckNotAlreadySucceeded(t *testing.T) {
+	state := "failure"
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"state": "` + state + `", "statuses": [{"context": "ci", "state": "` + state + `"}]}`))
+	})()
+
+	flags := defaultFlags()
+	targets := []statusTarget{{flags.OrgRepo, flags.SHA}}
+	if err := checkNotAlreadySucceeded(targets, *flags); err != nil {
+		t.Errorf("Expected no error for a failed context, got %s", err)
+	}
+
+	state = "success"
+	err := checkNotAlreadySucceeded(targets, *flags)
+	if err == nil || !strings.Contains(err.Error(), "already success") {
+		t.Errorf("Expected an already success error, got %v", err)
+	}
+
+	flags.Context = "deploy"
+	if err := checkNotAlreadySucceeded(targets, *flags); err != nil {
+		t.Errorf("Expected no error for a context without statuses, got %s", err)
+	}
+}
+
+func TestCheckNoBuildInFlight(t *testing.T) {
+	state, createdAt := "pending", "2026-10-14T10:00:00Z"
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(`{"state": "` + state + `", "statuses": [{"context": "ci", "state": "` + state + `", "created_at": "` + createdAt + `", "updated_at": "` + createdAt + `"}]}`))
+	})()
+	defer withClock(time.Date(2026, 10, 14, 10, 30, 0, 0, time.UTC))()
+	withLogger(t, logError)
+
+	flags := defaultFlags()
+	flags.FailIfPending = true
+	targets := []statusTarget{{flags.OrgRepo, flags.SHA}}
+	err := checkNoBuildInFlight(targets, *flags)
+	if err == nil || !strings.Contains(err.Error(), "ci is already pending on christopher-bui/gh-status-reporter@deadbeef since 2026-10-14T10:00:00Z") {
+		t.Errorf("Expected a fresh pending status to block the build, got %v", err)
+	}
+
+	flags.PendingMaxAge = time.Hour
+	if err := checkNoBuildInFlight(targets, *flags); err == nil {
+		t.Errorf("Expected a pending status younger than -pending-max-age to block the build")
+	}
+	flags.PendingMaxAge = 10 * time.Minute
+	if err := checkNoBuildInFlight(targets, *flags); err != nil {
+		t.Errorf("Expected a stale pending status to be ignored, got %s", err)
+	}
+
+	state = "failure"
+	flags.PendingMaxAge = 0
+	if err := checkNoBuildInFlight(targets, *flags); err != nil {
+		t.Errorf("Expected no error for a finished build, got %s", err)
+	}
+	flags.Context = "deploy"
+	if err := checkNoBuildInFlight(targets, *flags); err != nil {
+		t.Errorf("Expected no error for a context without statuses, got %s", err)
+	}
+}
+
+func TestValidateFlagsPendingMaxAge(t *testing.T) {
+	flags := defaultFlags()
+	flags.PendingMaxAge = time.Hour
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "-pending-max-age is only used with -fail-if-pending") {
+		t.Errorf("Expected -pending-max-age to need -fail-if-pending, got %v", err)
+	}
+	flags.FailIfPending, flags.PendingMaxAge = true, -time.Minute
+	if err := validateFlags(*flags, []string{"true"}, ""); err == nil || !strings.Contains(err.Error(), "must not be negative") {
+		t.Errorf("Expected a negative -pending-max-age to be rejected, got %v", err)
+	}
+}
@@ -0,0 +1,20 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+)
+
+// applyStderrCheck turns a successful command that wrote to stderr into a
+// failure when -fail-on-stderr is set, and returns the description to report.
+// Only the status fails; the exit code stays the command's own unless
+// -fail-on-stderr-exit is set too.
+func applyStderrCheck(flags Flags, result *commandResult) string {
+	if !flags.FailOnStderr || result.Err != nil || result.StderrBytes == 0 {
+		return flags.Description
+	}
+	note := fmt.Sprintf("wrote %d bytes to stderr", result.StderrBytes)
+	result.Err = errors.New(note)
+	result.StatusOnly = !flags.FailOnStderrExit
+	return appendSuffix(flags.Description, note)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"errors"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRunCommandCountsStderr(t *testing.T) {
+	var stdout, stderr strings.Builder
+	subprocess := exec.Command("sh", "-c", "echo out; printf oops >&2")
+	subprocess.Stdout, subprocess.Stderr = &stdout, &stderr
+	if result := runCommand(subprocess, commandOptions{}); result.StderrBytes != 4 {
+		t.Errorf("Expected 4 bytes of stderr, got %d", result.StderrBytes)
+	}
+}
+
+func TestApplyStderrCheck(t *testing.T) {
+	flags := defaultFlags()
+	flags.FailOnStderr = true
+	result := &commandResult{StderrBytes: 12}
+
+	if description := applyStderrCheck(*flags, result); description != "unit test (wrote 12 bytes to stderr)" {
+		t.Errorf("Unexpected description %q", description)
+	}
+	if commandState(result) != "failure" || !result.StatusOnly {
+		t.Errorf("Expected a status-only failure, got %s and %t", commandState(result), result.StatusOnly)
+	}
+
+	flags.FailOnStderrExit = true
+	result = &commandResult{StderrBytes: 12}
+	applyStderrCheck(*flags, result)
+	if result.StatusOnly {
+		t.Errorf("Expected -fail-on-stderr-exit to fail the exit code too")
+	}
+}
+
+func TestApplyStderrCheckIgnoresOtherRuns(t *testing.T) {
+	flags := defaultFlags()
+	for _, result := range []*commandResult{
+		{StderrBytes: 0},
+		{StderrBytes: 3, Err: errors.New("exit status 1"), ExitCode: 1},
+	} {
+		flags.FailOnStderr = true
+		before := result.Err
+		if description := applyStderrCheck(*flags, result); description != "unit test" || result.Err != before || result.StatusOnly {
+			t.Errorf("Expected %+v to be left alone, got %q", result, description)
+		}
+	}
+
+	flags.FailOnStderr = false
+	result := &commandResult{StderrBytes: 3}
+	if applyStderrCheck(*flags, result); result.Err != nil {
+		t.Errorf("Expected stderr to be ignored without -fail-on-stderr")
+	}
+}
+
+func TestCLIFailOnStderrExitCode(t *testing.T) {
+	for _, test := range []struct {
+		flags []string
+		code  int
+	}{
+		{[]string{"-fail-on-stderr"}, 0},
+		{[]string{"-fail-on-stderr", "-fail-on-stderr-exit"}, 1},
+	} {
+		args := append(test.flags, "-replay", filepath.Join("testdata", "replay-stderr.json"),
+			"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token",
+			"sh", "-c", "echo oops >&2")
+		out, code := runCLI(t, args...)
+		if code != test.code {
+			t.Errorf("Expected exit code %d with %v, got %d:\n%s", test.code, test.flags, code, out)
+		}
+	}
+}
@@ -0,0 +1,117 @@
+package main
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+)
+
+// stdinMode is the value of the -stdin flag: what the command's stdin is
+// connected to.
+type stdinMode string
+
+const (
+	// stdinAuto inherits stdin when it is a terminal and uses null otherwise,
+	// so a pipe a CI tool never closes can't hang the command.
+	stdinAuto    stdinMode = ""
+	stdinInherit stdinMode = "inherit"
+	stdinNull    stdinMode = "null"
+	stdinClose   stdinMode = "close"
+)
+
+func (m *stdinMode) String() string {
+	return string(*m)
+}
+
+func (m *stdinMode) Set(value string) error {
+	switch stdinMode(value) {
+	case stdinInherit, stdinNull, stdinClose:
+		*m = stdinMode(value)
+	default:
+		return fmt.Errorf("expected inherit, null or close, got %q", value)
+	}
+	return nil
+}
+
+func (m *stdinMode) completionValues() []string {
+	return []string{"inherit", "null", "close"}
+}
+
+// isTerminal reports whether f is a character device such as a terminal.
+func isTerminal(f *os.File) bool {
+	info, err := f.Stat()
+	return err == nil && info.Mode()&os.ModeCharDevice != 0
+}
+
+// isInteractive reports whether f is a terminal, unlike isTerminal telling
+// it apart from the null device, which is a character device too.
+func isInteractive(f *os.File) bool {
+	if !isTerminal(f) {
+		return false
+	}
+	info, err := f.Stat()
+	null, nullErr := os.Stat(os.DevNull)
+	return err != nil || nullErr != nil || !os.SameFile(info, null)
+}
+
+// commandStdin returns the reader to use as the command's stdin for mode.
+// nil connects it to the null device. close gives the command a pipe that
+// is already closed for writing, so reads end immediately.
+func commandStdin(mode stdinMode, stdin *os.File) (io.Reader, error) {
+	if mode == stdinAuto {
+		mode = stdinNull
+		if isTerminal(stdin) {
+			mode = stdinInherit
+		}
+	}
+
+	switch mode {
+	case stdinInherit:
+		return stdin, nil
+	case stdinClose:
+		reader, writer, err := os.Pipe()
+		if err != nil {
+			return nil, fmt.Errorf("Error creating stdin pipe: %s", err)
+		}
+		writer.Close()
+		return reader, nil
+	}
+	return nil, nil
+}
+
+// validateStdinFlags checks that at most one way of setting the command's
+// stdin is used.
+func validateStdinFlags(flags Flags) error {
+	set := 0
+	for _, isSet := range []bool{flags.Stdin != stdinAuto, flags.StdinFile != "", flags.StdinString != "", flags.NoStdin} {
+		if isSet {
+			set++
+		}
+	}
+	if set > 1 {
+		return errors.New("Error: only one of -stdin, -stdin-file, -stdin-string and -no-stdin can be used")
+	}
+	return nil
+}
+
+// commandInput returns the command's stdin for -stdin-file, -stdin-string,
+// -no-stdin or otherwise -stdin. The file and string are read into memory,
+// so a retried command reads them again from the start.
+func commandInput(flags Flags, stdin *os.File) (io.Reader, error) {
+	switch {
+	case flags.StdinFile != "":
+		data, err := ioutil.ReadFile(flags.StdinFile)
+		if err != nil {
+			return nil, fmt.Errorf("Error reading -stdin-file: %s", err)
+		}
+		return bytes.NewReader(data), nil
+	case flags.StdinString != "":
+		return bytes.NewReader([]byte(flags.StdinString)), nil
+	case flags.NoStdin:
+		return commandStdin(stdinClose, stdin)
+	}
+	return commandStdin(flags.Stdin, stdin)
+}
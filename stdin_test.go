@@ -0,0 +1,121 @@
+package main
+
+import (
+	"bytes"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+	"time"
+)
+
+// parentStdin returns a pipe standing in for the reporter's stdin with input
+// written to it, left open like a CI tool's pipe.
+func parentStdin(t *testing.T, input string) *os.File {
+	reader, writer, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	writer.Write([]byte(input))
+	t.Cleanup(func() {
+		writer.Close()
+		reader.Close()
+	})
+	return reader
+}
+
+func TestCommandStdinModes(t *testing.T) {
+	for _, test := range []struct {
+		mode     stdinMode
+		expected string
+	}{
+		{stdinInherit, "from parent"},
+		{stdinNull, ""},
+		{stdinClose, ""},
+		// A pipe isn't a terminal, so the default doesn't inherit it.
+		{stdinAuto, ""},
+	} {
+		stdin, err := commandStdin(test.mode, parentStdin(t, "from parent\n"))
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+
+		var stdout bytes.Buffer
+		subprocess := exec.Command("sh", "-c", "read line; printf %s \"$line\"")
+		subprocess.Stdin, subprocess.Stdout = stdin, &stdout
+		result := runCommand(subprocess, commandOptions{Timeout: 5 * time.Second})
+		if result.TimedOut {
+			t.Errorf("Expected -stdin %q not to hang", test.mode)
+		}
+		if stdout.String() != test.expected {
+			t.Errorf("Expected the command to read %q with -stdin %q, got %q", test.expected, test.mode, stdout.String())
+		}
+	}
+}
+
+func TestStdinModeSet(t *testing.T) {
+	var mode stdinMode
+	if err := mode.Set("close"); err != nil || mode != stdinClose {
+		t.Errorf("Expected close to be accepted, got %q %v", mode, err)
+	}
+	if err := mode.Set("tty"); err == nil {
+		t.Errorf("Expected an unknown mode to be rejected")
+	}
+}
+
+func TestCommandInputFeedsFileAndString(t *testing.T) {
+	for _, flags := range []Flags{
+		{StdinFile: writeTempFile(t, "input.txt", "from file\nsecond line\n")},
+		{StdinString: "from file\nsecond line\n"},
+	} {
+		stdin, err := commandInput(flags, parentStdin(t, "from parent\n"))
+		if err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+
+		var stdout bytes.Buffer
+		subprocess := exec.Command("cat")
+		subprocess.Stdin, subprocess.Stdout = stdin, &stdout
+		result := runCommand(subprocess, commandOptions{Timeout: 5 * time.Second})
+		if result.Err != nil || stdout.String() != "from file\nsecond line\n" {
+			t.Errorf("Expected the command to echo the input for %+v, got %q %v", flags, stdout.String(), result.Err)
+		}
+
+		// A retry is fed the input again.
+		stdout.Reset()
+		retry := cloneCommand(subprocess)
+		runCommand(retry, commandOptions{Timeout: 5 * time.Second})
+		if stdout.String() != "from file\nsecond line\n" {
+			t.Errorf("Expected the retry to read the input again, got %q", stdout.String())
+		}
+	}
+
+	if _, err := commandInput(Flags{StdinFile: "/nonexistent/input.txt"}, os.Stdin); err == nil || !strings.Contains(err.Error(), "Error reading -stdin-file") {
+		t.Errorf("Expected a missing -stdin-file to be an error, got %v", err)
+	}
+	stdin, err := commandInput(Flags{NoStdin: true}, parentStdin(t, "from parent\n"))
+	if err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	var stdout bytes.Buffer
+	subprocess := exec.Command("cat")
+	subprocess.Stdin, subprocess.Stdout = stdin, &stdout
+	if result := runCommand(subprocess, commandOptions{Timeout: 5 * time.Second}); result.TimedOut || stdout.Len() != 0 {
+		t.Errorf("Expected -no-stdin to give cat a closed stdin, got %q", stdout.String())
+	}
+}
+
+func TestValidateStdinFlags(t *testing.T) {
+	if err := validateStdinFlags(Flags{StdinFile: "input.txt"}); err != nil {
+		t.Errorf("Got unexpected error: %s", err)
+	}
+	for _, flags := range []Flags{
+		{Stdin: stdinNull, StdinFile: "input.txt"},
+		{StdinString: "input", NoStdin: true},
+		{Stdin: stdinInherit, NoStdin: true},
+	} {
+		if err := validateStdinFlags(flags); err == nil || !strings.Contains(err.Error(), "only one of") {
+			t.Errorf("Expected %+v to be rejected, got %v", flags, err)
+		}
+	}
+}
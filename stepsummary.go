@@ -0,0 +1,98 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"time"
+)
+
+// renderStepSummary renders the final status of every target as a Markdown
+// table for the GitHub Actions step summary, linking the description to the
+// target URL when there is one.
+func renderStepSummary(targets []statusTarget, flags Flags, state string, result *commandResult) string {
+	var b strings.Builder
+	b.WriteString("| Context | Commit | State | Duration | Description |\n")
+	b.WriteString("| --- | --- | --- | --- | --- |\n")
+	for _, target := range targets {
+		targetFlags := providerFlags(flags, target.OrgRepo)
+		sha := target.SHA
+		if len(sha) > 7 {
+			sha = sha[:7]
+		}
+		description := markdownEscaper.Replace(statusDescription(targetFlags, state))
+		if link := statusTargetURL(targetFlags, state); link != "" {
+			description = fmt.Sprintf("[%s](%s)", description, markdownURLEscaper.Replace(link))
+		}
+		fmt.Fprintf(&b, "| %s | %s@%s | %s | %s | %s |\n", markdownEscaper.Replace(targetFlags.Context),
+			target.OrgRepo, sha, state, result.Duration.Round(time.Second), description)
+	}
+	b.WriteString("\n")
+	return b.String()
+}
+
+// summaryMode is the value of the -summary-on flag: which final states get
+// the full step summary table.
+type summaryMode string
+
+const (
+	summaryAlways  summaryMode = ""
+	summaryFailure summaryMode = "failure"
+	summarySuccess summaryMode = "success"
+)
+
+func (m *summaryMode) String() string {
+	return string(*m)
+}
+
+func (m *summaryMode) Set(value string) error {
+	switch value {
+	case "always":
+		*m = summaryAlways
+	case "failure", "success":
+		*m = summaryMode(value)
+	default:
+		return fmt.Errorf("expected always, failure or success, got %q", value)
+	}
+	return nil
+}
+
+func (m *summaryMode) completionValues() []string {
+	return []string{"always", "failure", "success"}
+}
+
+// wantsTable reports whether a run ending in state gets the full table with
+// -summary-on m. Error and failure both count as failures.
+func (m summaryMode) wantsTable(state string) bool {
+	switch m {
+	case summaryFailure:
+		return state != "success"
+	case summarySuccess:
+		return state == "success"
+	}
+	return true
+}
+
+// stepSummaryFor returns what a run ending in state appends to the step
+// summary: the table from renderStepSummary, or, when -summary-on leaves
+// the state out, just a line with the context and state.
+func stepSummaryFor(targets []statusTarget, flags Flags, state string, result *commandResult) string {
+	if flags.SummaryOn.wantsTable(state) {
+		return renderStepSummary(targets, flags, state, result)
+	}
+	return fmt.Sprintf("**%s**: %s\n\n", markdownEscaper.Replace(flags.Context), state)
+}
+
+// appendStepSummary appends summary to the step summary file at path, after
+// whatever earlier steps and runs wrote there.
+func appendStepSummary(path, summary string) error {
+	file, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0644)
+	if err != nil {
+		return fmt.Errorf("Error opening -step-summary %s: %s", path, err)
+	}
+	defer file.Close()
+	if _, err := file.WriteString(summary); err != nil {
+		return fmt.Errorf("Error writing -step-summary %s: %s", path, err)
+	}
+	return nil
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRenderStepSummary(t *testing.T) {
+	flags := defaultFlags()
+	flags.Context = "ci|lint"
+	flags.TargetUrl = "https://ci.example.com/build (1)"
+	targets := []statusTarget{{"org/repo", "deadbeefcafe"}}
+	summary := renderStepSummary(targets, *flags, "failure", &commandResult{Duration: 62400 * time.Millisecond})
+	expected := "| Context | Commit | State | Duration | Description |\n" +
+		"| --- | --- | --- | --- | --- |\n" +
+		"| ci\\|lint | org/repo@deadbee | failure | 1m2s | [unit test](https://ci.example.com/build%20%281%29) |\n\n"
+	if summary != expected {
+		t.Errorf("Expected:\n%s\ngot:\n%s", expected, summary)
+	}
+
+	flags.TargetUrl, flags.Description = "", ""
+	summary = renderStepSummary(targets, *flags, "success", &commandResult{})
+	if !strings.Contains(summary, "| success | 0s | Build passed |\n") {
+		t.Errorf("Expected the default description without a link, got:\n%s", summary)
+	}
+}
+
+func TestStepSummaryOn(t *testing.T) {
+	targets := []statusTarget{{"org/repo", "deadbeefcafe"}}
+	for _, test := range []struct {
+		mode  summaryMode
+		state string
+		table bool
+	}{
+		{summaryAlways, "success", true},
+		{summaryAlways, "failure", true},
+		{summaryFailure, "success", false},
+		{summaryFailure, "failure", true},
+		{summaryFailure, "error", true},
+		{summarySuccess, "success", true},
+		{summarySuccess, "failure", false},
+	} {
+		flags := defaultFlags()
+		flags.SummaryOn = test.mode
+		summary := stepSummaryFor(targets, *flags, test.state, &commandResult{})
+		if table := strings.Contains(summary, "| Context |"); table != test.table {
+			t.Errorf("With -summary-on %q and %s expected a table %v, got:\n%s", test.mode, test.state, test.table, summary)
+		}
+		if !test.table && summary != "**ci**: "+test.state+"\n\n" {
+			t.Errorf("With -summary-on %q and %s expected a title, got %q", test.mode, test.state, summary)
+		}
+	}
+
+	var mode summaryMode
+	if err := mode.Set("sometimes"); err == nil {
+		t.Errorf("Expected an error for an unknown -summary-on")
+	}
+	if err := mode.Set("always"); err != nil || mode != summaryAlways {
+		t.Errorf("Expected always to be the default, got %q, %v", mode, err)
+	}
+}
+
+func TestAppendStepSummary(t *testing.T) {
+	path := writeTempFile(t, "summary.md", "# Earlier step\n\n")
+	for _, summary := range []string{"first\n", "second\n"} {
+		if err := appendStepSummary(path, summary); err != nil {
+			t.Fatalf("Got unexpected error: %s", err)
+		}
+	}
+	if contents, _ := ioutil.ReadFile(path); string(contents) != "# Earlier step\n\nfirst\nsecond\n" {
+		t.Errorf("Expected the summaries to be appended, got %q", contents)
+	}
+	if err := appendStepSummary(filepath.Join(t.TempDir(), "missing", "summary.md"), "x"); err == nil || !strings.Contains(err.Error(), "Error opening -step-summary") {
+		t.Errorf("Expected an error for a missing directory, got %v", err)
+	}
+}
+
+func TestCLIWritesStepSummary(t *testing.T) {
+	summary := writeTempFile(t, "summary.md", "")
+	out, code := runCLIWithEnv(t, []string{"GITHUB_STEP_SUMMARY=" + summary},
+		"-replay", filepath.Join("testdata", "replay-failure.json"),
+		"-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token", "sh", "-c", "exit 3")
+	if code != 1 {
+		t.Errorf("Expected exit code 1, got %d:\n%s", code, out)
+	}
+	contents, _ := ioutil.ReadFile(summary)
+	if !strings.Contains(string(contents), "| ci | org/repo@deadbee | failure | 0s | unit test |\n") {
+		t.Errorf("Expected the final status in the step summary, got:\n%s", contents)
+	}
+}
@@ -0,0 +1,114 @@
+package main
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"text/template"
+	"time"
+)
+
+// templateShellTimeout bounds each {{sh}} call in a flag template.
+var templateShellTimeout = 10 * time.Second
+
+// flagTemplateData is what -c, -d and -t templates can refer to. Context is
+// only known to -log-upload-url, which is rendered after -c.
+type flagTemplateData struct {
+	Repository string
+	SHA        string
+	ShortSHA   string
+	Branch     string
+	Context    string
+}
+
+// templateShell runs command with sh and returns its trimmed stdout. The
+// command's stderr goes to ours so failures can be diagnosed.
+func templateShell(command string) (string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), templateShellTimeout)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "sh", "-c", command)
+	cmd.Stderr = os.Stderr
+	// Kill the whole group so a child holding stdout open can't outlive the
+	// timeout.
+	setProcessGroup(cmd)
+	cmd.Cancel = func() error { return killProcessGroup(cmd) }
+	out, err := cmd.Output()
+	if ctx.Err() == context.DeadlineExceeded {
+		return "", fmt.Errorf("%q timed out after %s", command, templateShellTimeout)
+	}
+	if err != nil {
+		return "", fmt.Errorf("%q failed: %s", command, err)
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// flagTemplateFuncs are the functions available to flag templates. sh runs
+// arbitrary commands, so it only works with -allow-template-shell.
+func flagTemplateFuncs(allowShell bool) template.FuncMap {
+	return template.FuncMap{
+		"env": os.Getenv,
+		"sh": func(command string) (string, error) {
+			if !allowShell {
+				return "", errors.New("sh is disabled; pass -allow-template-shell to run commands from templates")
+			}
+			return templateShell(command)
+		},
+	}
+}
+
+// expandFlagTemplate renders text, the value of the flag name, as a Go
+// text/template. Values without {{ are returned unchanged.
+func expandFlagTemplate(name, text string, data flagTemplateData, funcs template.FuncMap) (string, error) {
+	if !strings.Contains(text, "{{") {
+		return text, nil
+	}
+	tmpl, err := template.New(name).Funcs(funcs).Parse(text)
+	if err != nil {
+		return "", fmt.Errorf("Error parsing %s template: %s", name, err)
+	}
+	var out bytes.Buffer
+	if err := tmpl.Execute(&out, data); err != nil {
+		return "", fmt.Errorf("Error rendering %s template: %s", name, err)
+	}
+	return out.String(), nil
+}
+
+// expandFlagTemplates renders the -c, -d and -t templates in place.
+func expandFlagTemplates(flags *Flags) error {
+	values := []struct {
+		name  string
+		value *string
+	}{
+		{"-c", &flags.Context},
+		{"-d", &flags.Description},
+		{"-t", &flags.TargetUrl},
+		{"-target-url-on-success", &flags.TargetURLOnSuccess},
+		{"-target-url-on-failure", &flags.TargetURLOnFailure},
+	}
+	templated := false
+	for _, v := range values {
+		templated = templated || strings.Contains(*v.value, "{{")
+	}
+	if !templated {
+		return nil
+	}
+
+	data := flagTemplateData{Repository: flags.OrgRepo, SHA: flags.SHA, ShortSHA: flags.SHA, Branch: resolveBranch(*flags)}
+	if len(data.ShortSHA) > 7 {
+		data.ShortSHA = data.ShortSHA[:7]
+	}
+	funcs := flagTemplateFuncs(flags.AllowTemplateShell)
+	for _, v := range values {
+		expanded, err := expandFlagTemplate(v.name, *v.value, data, funcs)
+		if err != nil {
+			return err
+		}
+		*v.value = expanded
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package main
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestExpandFlagTemplates(t *testing.T) {
+	flags := defaultFlags()
+	flags.SHA = "0123456789abcdef"
+	flags.Branch = "main"
+	flags.Context = "ci/{{.Branch}}"
+	flags.Description = "Build of {{.ShortSHA}} in {{.Repository}}"
+	if err := expandFlagTemplates(flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if flags.Context != "ci/main" {
+		t.Errorf("Expected context ci/main, got %q", flags.Context)
+	}
+	if flags.Description != "Build of 0123456 in christopher-bui/gh-status-reporter" {
+		t.Errorf("Expected the description to be rendered, got %q", flags.Description)
+	}
+}
+
+func TestTemplateShellRequiresOptIn(t *testing.T) {
+	flags := defaultFlags()
+	flags.Description = `{{sh "echo hello"}}`
+	err := expandFlagTemplates(flags)
+	if err == nil || !strings.Contains(err.Error(), "-allow-template-shell") {
+		t.Errorf("Expected sh to be refused without -allow-template-shell, got %v", err)
+	}
+
+	flags.AllowTemplateShell = true
+	if err := expandFlagTemplates(flags); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if flags.Description != "hello" {
+		t.Errorf("Expected the trimmed output of the command, got %q", flags.Description)
+	}
+}
+
+func TestTemplateShellTimesOut(t *testing.T) {
+	original := templateShellTimeout
+	templateShellTimeout = 50 * time.Millisecond
+	defer func() { templateShellTimeout = original }()
+
+	flags := defaultFlags()
+	flags.AllowTemplateShell = true
+	flags.TargetUrl = `{{sh "sleep 5"}}`
+	err := expandFlagTemplates(flags)
+	if err == nil || !strings.Contains(err.Error(), "timed out") {
+		t.Errorf("Expected the command to time out, got %v", err)
+	}
+}
@@ -0,0 +1,47 @@
+package main
+
+import "fmt"
+
+// timeoutStatus is the value of the -timeout-status flag: the state posted
+// for a command stopped by -cmd-timeout.
+type timeoutStatus string
+
+const (
+	timeoutError   timeoutStatus = ""
+	timeoutFailure timeoutStatus = "failure"
+)
+
+func (s *timeoutStatus) String() string {
+	return string(*s)
+}
+
+func (s *timeoutStatus) Set(value string) error {
+	switch value {
+	case "error":
+		*s = timeoutError
+	case "failure":
+		*s = timeoutFailure
+	default:
+		return fmt.Errorf("expected failure or error, got %q", value)
+	}
+	return nil
+}
+
+func (s *timeoutStatus) completionValues() []string {
+	return []string{"failure", "error"}
+}
+
+// applyTimeoutStatus reports a command stopped by its timeout as an error,
+// or as a failure with -timeout-status failure, and returns the description
+// to report, which says how long the command was given.
+func applyTimeoutStatus(flags Flags, result *commandResult) string {
+	if !result.TimedOut {
+		return flags.Description
+	}
+	result.Errored = flags.TimeoutStatus != timeoutFailure
+	note := "timed out after " + shortDuration(flags.CmdTimeout)
+	if flags.Description == "" {
+		return "Command " + note
+	}
+	return appendSuffix(flags.Description, note)
+}
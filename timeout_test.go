@@ -0,0 +1,71 @@
+package main
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestApplyTimeoutStatus(t *testing.T) {
+	flags := defaultFlags()
+	flags.CmdTimeout = 30 * time.Minute
+	result := &commandResult{}
+	if description := applyTimeoutStatus(*flags, result); description != "unit test" || commandState(result) != "success" {
+		t.Errorf("Expected a command that didn't time out to be left alone, got %q", description)
+	}
+
+	for _, test := range []struct {
+		status   timeoutStatus
+		expected string
+	}{
+		{timeoutError, "error"},
+		{timeoutFailure, "failure"},
+	} {
+		flags.TimeoutStatus = test.status
+		result := &commandResult{Err: errors.New("command timed out after 30m0s"), TimedOut: true}
+		if description := applyTimeoutStatus(*flags, result); description != "unit test (timed out after 30m)" {
+			t.Errorf("Unexpected description %q", description)
+		}
+		if state := commandState(result); state != test.expected {
+			t.Errorf("Expected -timeout-status %q to post %s, got %s", test.status, test.expected, state)
+		}
+	}
+
+	flags.Description = ""
+	if description := applyTimeoutStatus(*flags, &commandResult{Err: errors.New("command timed out after 30m0s"), TimedOut: true}); description != "Command timed out after 30m" {
+		t.Errorf("Unexpected description without -d %q", description)
+	}
+}
+
+func TestTimeoutStatusSet(t *testing.T) {
+	var status timeoutStatus
+	if err := status.Set("failure"); err != nil || status != timeoutFailure {
+		t.Errorf("Expected failure, got %q, %v", status, err)
+	}
+	if err := status.Set("error"); err != nil || status != timeoutError {
+		t.Errorf("Expected error, got %q, %v", status, err)
+	}
+	if err := status.Set("success"); err == nil {
+		t.Errorf("Expected success to be rejected")
+	}
+}
+
+func TestCLITimeoutStatus(t *testing.T) {
+	for _, test := range []struct {
+		args     []string
+		expected string
+	}{
+		{nil, `"state":"error"`},
+		{[]string{"-timeout-status", "failure"}, `"state":"failure"`},
+	} {
+		args := append([]string{"-dry-run", "-cmd-timeout", "100ms", "-r", "org/repo", "-s", "deadbeef", "-c", "ci", "-d", "unit test", "-a", "token"}, test.args...)
+		out, code := runCLI(t, append(args, "sleep", "5")...)
+		if code == 0 {
+			t.Errorf("Expected the timed out command to fail the run:\n%s", out)
+		}
+		if !strings.Contains(out, test.expected) || !strings.Contains(out, `"description":"unit test (timed out after 100ms)"`) {
+			t.Errorf("Expected %s for a timeout with %q, got:\n%s", test.expected, test.args, out)
+		}
+	}
+}
@@ -0,0 +1,94 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"sync"
+	"time"
+)
+
+// runTimings is where the run spent its time, for -timings. All durations
+// come from time.Since, so they use the monotonic clock.
+var runTimings struct {
+	sync.Mutex
+	pendingPost time.Duration
+	command     time.Duration
+	finalPost   time.Duration
+	retryWaits  time.Duration
+	retries     int
+}
+
+// timingReport is the -timings breakdown, printed as one JSON object with
+// -log-format json.
+type timingReport struct {
+	PendingPostSeconds float64 `json:"pending_post_seconds"`
+	CommandSeconds     float64 `json:"command_seconds"`
+	FinalPostSeconds   float64 `json:"final_post_seconds"`
+	RetryWaitSeconds   float64 `json:"retry_wait_seconds"`
+	Retries            int     `json:"retries"`
+	TotalSeconds       float64 `json:"total_seconds"`
+}
+
+// timeStatusPost records how long posting state took since started.
+func timeStatusPost(state string, started time.Time) {
+	runTimings.Lock()
+	defer runTimings.Unlock()
+	if state == "pending" {
+		runTimings.pendingPost += time.Since(started)
+	} else {
+		runTimings.finalPost += time.Since(started)
+	}
+}
+
+// timeCommand records the command's run time: every attempt of it.
+func timeCommand(result *commandResult) {
+	runTimings.Lock()
+	defer runTimings.Unlock()
+	for _, attempt := range result.Attempts {
+		runTimings.command += time.Duration(attempt.DurationSeconds * float64(time.Second))
+	}
+}
+
+// timeRetryWait records a wait between two API request attempts.
+func timeRetryWait(waited time.Duration) {
+	runTimings.Lock()
+	defer runTimings.Unlock()
+	runTimings.retryWaits += waited
+	runTimings.retries++
+}
+
+func currentTimings() timingReport {
+	runTimings.Lock()
+	defer runTimings.Unlock()
+	return timingReport{
+		PendingPostSeconds: runTimings.pendingPost.Seconds(),
+		CommandSeconds:     runTimings.command.Seconds(),
+		FinalPostSeconds:   runTimings.finalPost.Seconds(),
+		RetryWaitSeconds:   runTimings.retryWaits.Seconds(),
+		Retries:            runTimings.retries,
+		TotalSeconds:       time.Since(runStarted).Seconds(),
+	}
+}
+
+// printTimings writes the -timings breakdown to out as a table, or with
+// format json as a JSON object.
+func printTimings(out io.Writer, format string) {
+	timings := currentTimings()
+	if format == "json" {
+		encoded, _ := json.Marshal(struct {
+			Timings timingReport `json:"timings"`
+		}{timings})
+		fmt.Fprintf(out, "%s\n", encoded)
+		return
+	}
+	seconds := func(s float64) time.Duration {
+		return time.Duration(s * float64(time.Second)).Round(time.Millisecond)
+	}
+	fmt.Fprintf(out, "Timings:\n")
+	fmt.Fprintf(out, "  %-13s %s\n", "pending post", seconds(timings.PendingPostSeconds))
+	fmt.Fprintf(out, "  %-13s %s\n", "command", seconds(timings.CommandSeconds))
+	fmt.Fprintf(out, "  %-13s %s\n", "final post", seconds(timings.FinalPostSeconds))
+	fmt.Fprintf(out, "  %-13s %s (%d retries)\n", "retry waits", seconds(timings.RetryWaitSeconds), timings.Retries)
+	fmt.Fprintf(out, "  %-13s %s\n", "total", seconds(timings.TotalSeconds))
+}
@@ -0,0 +1,75 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func withTimings(t *testing.T) {
+	reset := func() {
+		runTimings.Lock()
+		runTimings.pendingPost, runTimings.command, runTimings.finalPost, runTimings.retryWaits, runTimings.retries = 0, 0, 0, 0, 0
+		runTimings.Unlock()
+	}
+	reset()
+	t.Cleanup(reset)
+}
+
+func TestTimingsArePopulated(t *testing.T) {
+	withTimings(t)
+	withLogger(t, logError)
+	defer withRetryBackoff(5 * time.Millisecond)()
+	calls := 0
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		if calls++; calls == 2 {
+			w.WriteHeader(http.StatusBadGateway)
+			return
+		}
+		time.Sleep(2 * time.Millisecond)
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.Retries, flags.Jitter = 1, jitterNone
+	statusReporter := &reporter{flags: *flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+	if err := statusReporter.report("pending", nil); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	timeCommand(&commandResult{Attempts: []commandAttempt{{DurationSeconds: 1.5}, {DurationSeconds: 0.25}}})
+	if err := statusReporter.report("success", &commandResult{}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	timings := currentTimings()
+	if timings.PendingPostSeconds < 0.002 || timings.FinalPostSeconds < 0.002 {
+		t.Errorf("Expected the status posts to be timed, got %+v", timings)
+	}
+	if timings.CommandSeconds != 1.75 {
+		t.Errorf("Expected every command attempt to count, got %v", timings.CommandSeconds)
+	}
+	if timings.Retries != 1 || timings.RetryWaitSeconds < 0.005 {
+		t.Errorf("Expected the retry wait to be timed, got %+v", timings)
+	}
+	if timings.TotalSeconds <= 0 {
+		t.Errorf("Expected the total, got %+v", timings)
+	}
+
+	var table, encoded bytes.Buffer
+	printTimings(&table, "text")
+	for _, row := range []string{"pending post", "command       1.75s", "final post", "retry waits", "(1 retries)", "total"} {
+		if !strings.Contains(table.String(), row) {
+			t.Errorf("Expected %q in the table, got:\n%s", row, table.String())
+		}
+	}
+	printTimings(&encoded, "json")
+	var parsed struct {
+		Timings timingReport `json:"timings"`
+	}
+	if err := json.Unmarshal(encoded.Bytes(), &parsed); err != nil || parsed.Timings.CommandSeconds != 1.75 || parsed.Timings.Retries != 1 {
+		t.Errorf("Unexpected JSON timings %s: %v", encoded.String(), err)
+	}
+}
@@ -0,0 +1,171 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strings"
+)
+
+// kubernetesTokenPath is where Kubernetes mounts the pod's service account
+// token, which -vault-role exchanges for a Vault token.
+var kubernetesTokenPath = "/var/run/secrets/kubernetes.io/serviceaccount/token"
+
+const defaultVaultField = "token"
+
+// vaultResponse is the envelope of every Vault API response.
+type vaultResponse struct {
+	RequestID string          `json:"request_id"`
+	Data      json.RawMessage `json:"data"`
+	Auth      *struct {
+		ClientToken string `json:"client_token"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+// vaultClient talks to the Vault HTTP API at addr.
+type vaultClient struct {
+	addr   string
+	token  string
+	client *http.Client
+}
+
+func newVaultClient(flags Flags) (*vaultClient, error) {
+	// Vault gets the same proxy, timeouts and TLS settings as Github, but
+	// never goes through -record, so the secret isn't written to fixtures.
+	transport, err := baseTransport(flags)
+	if err != nil {
+		return nil, err
+	}
+	return &vaultClient{
+		addr:   strings.TrimSuffix(flags.VaultAddr, "/"),
+		token:  flags.VaultToken,
+		client: &http.Client{Transport: transport, Timeout: flags.HTTPTimeout},
+	}, nil
+}
+
+func (v *vaultClient) request(method, path string, body interface{}) (*vaultResponse, error) {
+	var requestBody io.Reader
+	if body != nil {
+		encoded, err := json.Marshal(body)
+		if err != nil {
+			return nil, fmt.Errorf("Error converting Vault request to json %s.", err)
+		}
+		requestBody = bytes.NewReader(encoded)
+	}
+	req, err := http.NewRequest(method, v.addr+"/v1/"+strings.TrimPrefix(path, "/"), requestBody)
+	if err != nil {
+		return nil, fmt.Errorf("Error creating request to Vault: %s", err)
+	}
+	if v.token != "" {
+		req.Header.Set("X-Vault-Token", v.token)
+	}
+
+	resp, err := v.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("Error executing request to Vault: %s", err)
+	}
+	defer resp.Body.Close()
+	contents, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxErrorBody))
+	if err != nil {
+		return nil, fmt.Errorf("Error reading Vault response: %s", err)
+	}
+
+	var response vaultResponse
+	json.Unmarshal(contents, &response)
+	if resp.StatusCode != http.StatusOK {
+		message := fmt.Sprintf("Error: Vault %s %s responded with %d", method, path, resp.StatusCode)
+		if response.RequestID != "" {
+			message += " (request ID " + response.RequestID + ")"
+		}
+		if len(response.Errors) > 0 {
+			message += ": " + strings.Join(response.Errors, "; ")
+		}
+		return nil, fmt.Errorf("%s", message)
+	}
+	return &response, nil
+}
+
+// login exchanges the pod's Kubernetes service account token for a Vault
+// token with role.
+func (v *vaultClient) login(role string) error {
+	jwt, err := ioutil.ReadFile(kubernetesTokenPath)
+	if err != nil {
+		return fmt.Errorf("Error reading the Kubernetes service account token for -vault-role: %s", err)
+	}
+	response, err := v.request("POST", "auth/kubernetes/login", map[string]string{"role": role, "jwt": strings.TrimSpace(string(jwt))})
+	if err != nil {
+		return err
+	}
+	if response.Auth == nil || response.Auth.ClientToken == "" {
+		return fmt.Errorf("Error: Vault Kubernetes login for role %q returned no token (request ID %s)", role, response.RequestID)
+	}
+	v.token = response.Auth.ClientToken
+	return nil
+}
+
+// secretField returns field from the data of a secret read from either the
+// KV v1 engine, where it is at the top level, or KV v2, where it is nested
+// under data next to metadata.
+func secretField(data json.RawMessage, field string) (string, bool) {
+	var secret map[string]interface{}
+	if err := json.Unmarshal(data, &secret); err != nil {
+		return "", false
+	}
+	if nested, ok := secret["data"].(map[string]interface{}); ok {
+		if _, v2 := secret["metadata"]; v2 {
+			secret = nested
+		}
+	}
+	value, ok := secret[field].(string)
+	return value, ok && value != ""
+}
+
+// vaultSource is the tokenSource for -vault-path.
+type vaultSource struct {
+	flags Flags
+}
+
+func (v *vaultSource) String() string {
+	return "Vault secret " + v.flags.VaultPath
+}
+
+func (v *vaultSource) Token() (string, error) {
+	return fetchVaultToken(v.flags)
+}
+
+// fetchVaultToken reads the Github token from -vault-path. The token only
+// ever lives in memory for the run.
+func fetchVaultToken(flags Flags) (string, error) {
+	if flags.VaultAddr == "" {
+		return "", fmt.Errorf("Error: -vault-path needs -vault-addr or VAULT_ADDR")
+	}
+	vault, err := newVaultClient(flags)
+	if err != nil {
+		return "", err
+	}
+	if flags.VaultRole != "" {
+		if err := vault.login(flags.VaultRole); err != nil {
+			return "", err
+		}
+	} else if vault.token == "" {
+		return "", fmt.Errorf("Error: -vault-path needs VAULT_TOKEN or -vault-role")
+	}
+
+	response, err := vault.request("GET", flags.VaultPath, nil)
+	if err != nil {
+		return "", err
+	}
+	field := flags.VaultField
+	if field == "" {
+		field = defaultVaultField
+	}
+	token, ok := secretField(response.Data, field)
+	if !ok {
+		return "", fmt.Errorf("Error: Vault secret %s has no %q field (request ID %s)", flags.VaultPath, field, response.RequestID)
+	}
+	return token, nil
+}
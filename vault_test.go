@@ -0,0 +1,92 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func vaultFlags(addr string) Flags {
+	return Flags{VaultAddr: addr, VaultPath: "secret/data/ci/github", VaultField: "token", VaultToken: "s.root"}
+}
+
+func TestFetchVaultTokenKVVersions(t *testing.T) {
+	for _, body := range []string{
+		`{"request_id":"r1","data":{"token":"ghp_v1"}}`,
+		`{"request_id":"r2","data":{"data":{"token":"ghp_v1"},"metadata":{"version":3}}}`,
+	} {
+		ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if r.URL.Path != "/v1/secret/data/ci/github" || r.Header.Get("X-Vault-Token") != "s.root" {
+				t.Errorf("Unexpected Vault request %s with token %q", r.URL.Path, r.Header.Get("X-Vault-Token"))
+			}
+			fmt.Fprint(w, body)
+		}))
+		token, err := fetchVaultToken(vaultFlags(ts.URL))
+		ts.Close()
+		if err != nil || token != "ghp_v1" {
+			t.Errorf("Expected ghp_v1 from %s, got %q %v", body, token, err)
+		}
+	}
+}
+
+func TestFetchVaultTokenKubernetesLogin(t *testing.T) {
+	original := kubernetesTokenPath
+	kubernetesTokenPath = writeTempFile(t, "token", "service-account-jwt\n")
+	defer func() { kubernetesTokenPath = original }()
+
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case "/v1/auth/kubernetes/login":
+			var login map[string]string
+			json.NewDecoder(r.Body).Decode(&login)
+			if login["role"] != "ci" || login["jwt"] != "service-account-jwt" {
+				t.Errorf("Unexpected login %v", login)
+			}
+			fmt.Fprint(w, `{"auth":{"client_token":"s.k8s"}}`)
+		default:
+			if r.Header.Get("X-Vault-Token") != "s.k8s" {
+				t.Errorf("Expected the login token, got %q", r.Header.Get("X-Vault-Token"))
+			}
+			fmt.Fprint(w, `{"data":{"github":"ghp_k8s"}}`)
+		}
+	}))
+	defer ts.Close()
+
+	flags := vaultFlags(ts.URL)
+	flags.VaultToken, flags.VaultRole, flags.VaultField = "", "ci", "github"
+	if token, err := fetchVaultToken(flags); err != nil || token != "ghp_k8s" {
+		t.Errorf("Expected ghp_k8s, got %q %v", token, err)
+	}
+}
+
+func TestFetchVaultTokenErrors(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if strings.HasSuffix(r.URL.Path, "/missing") {
+			fmt.Fprint(w, `{"request_id":"req-2","data":{"password":"x"}}`)
+			return
+		}
+		w.WriteHeader(http.StatusForbidden)
+		fmt.Fprint(w, `{"request_id":"req-1","errors":["permission denied"]}`)
+	}))
+	defer ts.Close()
+
+	_, err := fetchVaultToken(vaultFlags(ts.URL))
+	expected := "Error: Vault GET secret/data/ci/github responded with 403 (request ID req-1): permission denied"
+	if err == nil || err.Error() != expected {
+		t.Errorf("Expected %q, got %v", expected, err)
+	}
+
+	flags := vaultFlags(ts.URL)
+	flags.VaultPath = "secret/missing"
+	if _, err := fetchVaultToken(flags); err == nil || !strings.Contains(err.Error(), "req-2") {
+		t.Errorf("Expected a missing field error with the request ID, got %v", err)
+	}
+
+	flags = vaultFlags("")
+	if _, err := fetchVaultToken(flags); err == nil {
+		t.Errorf("Expected an error without a Vault address")
+	}
+}
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// statusResponse is the part of the created status that -verify-response
+// checks. Github doesn't always include sha, but the status url ends in it.
+type statusResponse struct {
+	SHA     string `json:"sha"`
+	URL     string `json:"url"`
+	State   string `json:"state"`
+	Context string `json:"context"`
+}
+
+// verifyStatusResponse checks that the status Github reports creating is the
+// one that was posted. A mismatch points at something between us and Github
+// rewriting requests.
+func verifyStatusResponse(body []byte, sha string, params CommitStatusParams) error {
+	var created statusResponse
+	if err := json.Unmarshal(body, &created); err != nil {
+		return fmt.Errorf("Error: -verify-response could not parse the response: %s", err)
+	}
+
+	respondedSHA := created.SHA
+	if i := strings.LastIndex(created.URL, "/statuses/"); respondedSHA == "" && i >= 0 {
+		respondedSHA = created.URL[i+len("/statuses/"):]
+	}
+	if respondedSHA != "" && !strings.EqualFold(respondedSHA, sha) {
+		return fmt.Errorf("Error: Github created the status on %s instead of %s", respondedSHA, sha)
+	}
+	if created.State != "" && created.State != params.State {
+		return fmt.Errorf("Error: Github created a %s status instead of %s", created.State, params.State)
+	}
+	if created.Context != "" && params.Context != "" && created.Context != params.Context {
+		return fmt.Errorf("Error: Github created the status for context %q instead of %q", created.Context, params.Context)
+	}
+	return nil
+}
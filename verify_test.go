@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestVerifyStatusResponse(t *testing.T) {
+	params := CommitStatusParams{State: "success", Context: "ci"}
+	for _, test := range []struct {
+		body, err string
+	}{
+		{`{"url":"https://api.github.com/repos/o/r/statuses/deadbeef","state":"success","context":"ci"}`, ""},
+		{`{"sha":"DEADBEEF","state":"success"}`, ""},
+		{`{}`, ""},
+		{`{"url":"https://api.github.com/repos/o/r/statuses/cafe","state":"success","context":"ci"}`, "on cafe instead of deadbeef"},
+		{`{"sha":"cafe"}`, "on cafe instead of deadbeef"},
+		{`{"state":"failure","context":"ci"}`, "a failure status instead of success"},
+		{`{"state":"success","context":"lint"}`, `context "lint" instead of "ci"`},
+		{`not json`, "could not parse"},
+	} {
+		err := verifyStatusResponse([]byte(test.body), "deadbeef", params)
+		if test.err == "" && err != nil {
+			t.Errorf("Expected %s to verify, got %s", test.body, err)
+		}
+		if test.err != "" && (err == nil || !strings.Contains(err.Error(), test.err)) {
+			t.Errorf("Expected %s to fail with %q, got %v", test.body, test.err, err)
+		}
+	}
+}
+
+func TestSetGithubCommitStatusVerifyResponse(t *testing.T) {
+	ts := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+		fmt.Fprintln(w, `{"url":"https://api.github.com/repos/o/r/statuses/0000000","state":"pending","context":"ci"}`)
+	}))
+	defer ts.Close()
+
+	flags := defaultFlags()
+	if err := setGithubCommitStatus("POST", ts.URL, *flags, "pending"); err != nil {
+		t.Errorf("Expected the response to be ignored without -verify-response, got %s", err)
+	}
+
+	flags.VerifyResponse = true
+	if err := setGithubCommitStatus("POST", ts.URL, *flags, "pending"); err == nil {
+		t.Errorf("Expected a SHA mismatch to be reported")
+	}
+}
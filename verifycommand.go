@@ -0,0 +1,131 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"strings"
+)
+
+// Exit codes of the verify subcommand besides 0 for a passing state and 1
+// for errors.
+const (
+	verifyExitFailed = 3
+	verifyExitAbsent = 4
+)
+
+// commitStates are the states a commit status can have.
+var commitStates = []string{"pending", "success", "failure", "error"}
+
+// parseAllowedStates parses -allow into the states verify passes besides
+// success.
+func parseAllowedStates(list string) (map[string]bool, error) {
+	allowed := map[string]bool{"success": true}
+	for _, state := range splitList(list) {
+		valid := false
+		for _, known := range commitStates {
+			valid = valid || state == known
+		}
+		if !valid {
+			return nil, fmt.Errorf("Error: invalid -allow state %q, must be one of %s", state, strings.Join(commitStates, ", "))
+		}
+		allowed[state] = true
+	}
+	return allowed, nil
+}
+
+// verifyResult is what verify found: the state, and the status, check run
+// or combined status it came from. Observed is nil if the context is absent.
+type verifyResult struct {
+	State    string
+	Observed interface{}
+	Summary  string
+}
+
+// observeState finds the state verify checks in current: the rollup with
+// combined, otherwise context's status or, with -graphql, its check run.
+func observeState(current *combinedStatus, target statusTarget, context string, combined bool) verifyResult {
+	where := target.OrgRepo + "@" + target.SHA
+	if combined {
+		if len(current.Statuses) == 0 && len(current.CheckRuns) == 0 {
+			return verifyResult{Summary: "no statuses on " + where}
+		}
+		summary := fmt.Sprintf("%s on %s from %d statuses", current.State, where, len(current.Statuses))
+		if len(current.CheckRuns) > 0 {
+			summary += fmt.Sprintf(" and %d check runs", len(current.CheckRuns))
+		}
+		return verifyResult{State: current.State, Observed: current, Summary: summary}
+	}
+
+	if context == "" {
+		context = defaultContext
+	}
+	if status := current.find(context); status != nil {
+		summary := fmt.Sprintf("%s is %s on %s", context, status.State, where)
+		if status.Description != "" {
+			summary += ": " + status.Description
+		}
+		return verifyResult{State: status.State, Observed: status, Summary: summary}
+	}
+	for i, run := range current.CheckRuns {
+		if run.Name == context {
+			summary := fmt.Sprintf("check run %s is %s on %s", context, run.State, where)
+			if run.Conclusion != "" {
+				summary += ", concluded " + run.Conclusion
+			}
+			return verifyResult{State: run.State, Observed: &current.CheckRuns[i], Summary: summary}
+		}
+	}
+	return verifyResult{Summary: fmt.Sprintf("%s has no status on %s", context, where)}
+}
+
+// runVerifyCommand implements the verify subcommand: it reads the context's
+// latest state on the SHA once, prints what it found as a line of text or
+// with -output json as the observed status object, and returns 0 if the
+// state is success or in allow, 3 for any other state and 4 if the context
+// has no status.
+func runVerifyCommand(flags Flags, allow string, combined bool, output string, out io.Writer) int {
+	var errs multiError
+	if output != "text" && output != "json" {
+		errs = append(errs, fmt.Errorf("Error: -output must be text or json, got %q", output))
+	}
+	allowed, err := parseAllowedStates(allow)
+	if err != nil {
+		errs = append(errs, err)
+	}
+	if flags.OrgRepo == "" {
+		errs = append(errs, fmt.Errorf("Error: No Github organization/repository provided"))
+	}
+	if flags.SHA == "" {
+		errs = append(errs, fmt.Errorf("Error: No SHA provided"))
+	}
+	if combined && flags.Context != "" {
+		errs = append(errs, fmt.Errorf("Error: -combined verifies every context, so -c can't be used with it"))
+	}
+	if len(errs) > 0 {
+		logger.Errorf("%s", errs)
+		return 1
+	}
+
+	target := statusTarget{OrgRepo: flags.OrgRepo, SHA: flags.SHA}
+	current, err := getCombinedStatus(target, flags)
+	if err != nil {
+		logger.Errorf("%s", err)
+		return 1
+	}
+	result := observeState(current, target, flags.Context, combined)
+
+	if output == "json" {
+		encoded, _ := json.Marshal(result.Observed)
+		fmt.Fprintf(out, "%s\n", encoded)
+	} else {
+		fmt.Fprintln(out, result.Summary)
+	}
+	switch {
+	case result.Observed == nil:
+		return verifyExitAbsent
+	case allowed[result.State]:
+		return 0
+	}
+	return verifyExitFailed
+}
@@ -0,0 +1,99 @@
+package main
+
+import (
+	"bytes"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+const verifyCombinedStatus = `{"state": "failure", "sha": "deadbeef", "statuses": [
+	{"context": "ci/test", "state": "success", "description": "All tests passed"},
+	{"context": "ci/lint", "state": "failure"},
+	{"context": "ci/deploy", "state": "pending"}
+]}`
+
+func TestRunVerifyCommand(t *testing.T) {
+	withLogger(t, logError)
+	var requests int
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		requests++
+		if r.URL.Path != "/repos/christopher-bui/gh-status-reporter/commits/deadbeef/status" {
+			t.Errorf("Unexpected request %s", r.URL.Path)
+		}
+		w.Write([]byte(verifyCombinedStatus))
+	})()
+
+	for _, test := range []struct {
+		context  string
+		allow    string
+		combined bool
+		code     int
+		line     string
+	}{
+		{"ci/test", "", false, 0, "ci/test is success on christopher-bui/gh-status-reporter@deadbeef: All tests passed"},
+		{"ci/lint", "", false, 3, "ci/lint is failure on christopher-bui/gh-status-reporter@deadbeef"},
+		{"ci/deploy", "", false, 3, "ci/deploy is pending"},
+		{"ci/deploy", "pending", false, 0, "ci/deploy is pending"},
+		{"ci/missing", "pending", false, 4, "ci/missing has no status on christopher-bui/gh-status-reporter@deadbeef"},
+		{"", "", true, 3, "failure on christopher-bui/gh-status-reporter@deadbeef from 3 statuses"},
+		{"", "failure", true, 0, "failure on"},
+	} {
+		flags := defaultFlags()
+		flags.Context = test.context
+		var out bytes.Buffer
+		requests = 0
+		code := runVerifyCommand(*flags, test.allow, test.combined, "text", &out)
+		if code != test.code || !strings.HasPrefix(out.String(), test.line) || strings.Count(out.String(), "\n") != 1 {
+			t.Errorf("%s -allow %q: expected %d and %q, got %d and %q", test.context, test.allow, test.code, test.line, code, out.String())
+		}
+		if requests != 1 {
+			t.Errorf("Expected a single request, got %d", requests)
+		}
+	}
+}
+
+func TestRunVerifyCommandJSON(t *testing.T) {
+	withLogger(t, logError)
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(verifyCombinedStatus))
+	})()
+
+	flags := defaultFlags()
+	flags.Context = "ci/lint"
+	var out bytes.Buffer
+	if code := runVerifyCommand(*flags, "", false, "json", &out); code != 3 || !strings.Contains(out.String(), `"context":"ci/lint"`) || !strings.Contains(out.String(), `"state":"failure"`) {
+		t.Errorf("Expected the observed status as JSON, got %d: %s", code, out.String())
+	}
+	out.Reset()
+	flags.Context = "ci/missing"
+	if code := runVerifyCommand(*flags, "", false, "json", &out); code != 4 || out.String() != "null\n" {
+		t.Errorf("Expected null for an absent context, got %d: %s", code, out.String())
+	}
+}
+
+func TestRunVerifyCommandInvalid(t *testing.T) {
+	out := withLogger(t, logError)
+	flags := defaultFlags()
+	flags.Context = "ci"
+	if code := runVerifyCommand(*flags, "pending,green", true, "yaml", &bytes.Buffer{}); code != 1 {
+		t.Errorf("Expected invalid flags to exit 1, got %d", code)
+	}
+	for _, expected := range []string{`-output must be text or json, got "yaml"`, `invalid -allow state "green"`, "-c can't be used with it"} {
+		if !strings.Contains(out.String(), expected) {
+			t.Errorf("Expected %q, got:\n%s", expected, out)
+		}
+	}
+}
+
+func TestObserveStateCheckRuns(t *testing.T) {
+	current := &combinedStatus{State: "pending", CheckRuns: []checkRun{{Name: "build", Status: "completed", Conclusion: "failure", State: "failure"}}}
+	target := statusTarget{OrgRepo: "org/repo", SHA: "deadbeef"}
+	result := observeState(current, target, "build", false)
+	if result.State != "failure" || result.Summary != "check run build is failure on org/repo@deadbeef, concluded failure" {
+		t.Errorf("Expected the check run to be found, got %+v", result)
+	}
+	if result := observeState(&combinedStatus{State: "pending"}, target, "", true); result.Observed != nil {
+		t.Errorf("Expected a commit without statuses to be absent, got %+v", result)
+	}
+}
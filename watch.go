@@ -0,0 +1,138 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// watchPollInterval is how often -watch looks for changed files.
+var watchPollInterval = 500 * time.Millisecond
+
+const defaultWatchDebounce = 300 * time.Millisecond
+
+// fileWatcher polls the files matching Patterns for changes. Directories that
+// match are watched recursively, except for their .git directory.
+type fileWatcher struct {
+	Patterns []string
+	Debounce time.Duration
+}
+
+// snapshot returns the modification time of every watched file.
+func (w *fileWatcher) snapshot() map[string]time.Time {
+	files := map[string]time.Time{}
+	for _, pattern := range w.Patterns {
+		matches, _ := filepath.Glob(pattern)
+		for _, match := range matches {
+			filepath.Walk(match, func(path string, info os.FileInfo, err error) error {
+				if err != nil {
+					return nil
+				}
+				if info.IsDir() && info.Name() == ".git" {
+					return filepath.SkipDir
+				}
+				if !info.IsDir() {
+					files[path] = info.ModTime()
+				}
+				return nil
+			})
+		}
+	}
+	return files
+}
+
+func sameSnapshot(a, b map[string]time.Time) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for path, modified := range a {
+		if other, ok := b[path]; !ok || !other.Equal(modified) {
+			return false
+		}
+	}
+	return true
+}
+
+// wait blocks until the watched files differ from previous and then stop
+// changing for Debounce, so saving several files reruns the command once. It
+// returns the new snapshot, or false if interrupted first.
+func (w *fileWatcher) wait(previous map[string]time.Time, interrupts <-chan os.Signal) (map[string]time.Time, bool) {
+	ticker := time.NewTicker(watchPollInterval)
+	defer ticker.Stop()
+
+	last := previous
+	var changedAt time.Time
+	for {
+		select {
+		case <-interrupts:
+			return nil, false
+		case now := <-ticker.C:
+			current := w.snapshot()
+			if !sameSnapshot(current, last) {
+				last, changedAt = current, now
+				continue
+			}
+			if !changedAt.IsZero() && now.Sub(changedAt) >= w.Debounce {
+				return current, true
+			}
+		}
+	}
+}
+
+// validateWatchPatterns checks that every -watch pattern is a valid glob.
+func validateWatchPatterns(flags Flags) error {
+	for _, pattern := range flags.Watch {
+		if _, err := filepath.Match(pattern, ""); err != nil {
+			return fmt.Errorf("Error: invalid -watch pattern %q", pattern)
+		}
+	}
+	if len(flags.Watch) > 0 && flags.Dev {
+		return fmt.Errorf("Error: -watch and -dev can't be used together")
+	}
+	return nil
+}
+
+// runWatch runs the command and reports its status, then reruns it whenever
+// the watched files change until interrupted. A run that is interrupted is
+// reported as error so no status is left pending. It returns the exit code.
+func runWatch(subprocess *exec.Cmd, options commandOptions, r *reporter, watcher *fileWatcher, interrupts <-chan os.Signal) int {
+	template := cloneCommand(subprocess)
+	description := r.flags.Description
+	for {
+		files := watcher.snapshot()
+		r.flags.Description = description
+		if err := r.report("pending", nil); err != nil {
+			logger.Errorf("%s", err)
+			return 1
+		}
+
+		if options.Progress != nil {
+			options.Progress.Start()
+		}
+		result := runCommandAttempts(cloneCommand(template), options)
+		if options.Progress != nil {
+			options.Progress.Stop()
+		}
+		select {
+		case <-interrupts:
+			r.flags.Description = appendSuffix(description, "interrupted")
+			if err := r.report("error", result); err != nil {
+				logger.Errorf("%s", err)
+			}
+			return 130
+		default:
+		}
+		if err := r.report(commandState(result), result); err != nil {
+			logger.Errorf("%s", err)
+		}
+
+		logger.Infof("Watching %s for changes, press Ctrl-C to exit", strings.Join(watcher.Patterns, ", "))
+		if _, ok := watcher.wait(files, interrupts); !ok {
+			return 0
+		}
+		logger.Infof("Files changed, running the command again")
+	}
+}
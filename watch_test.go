@@ -0,0 +1,120 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func withWatchPollInterval(d time.Duration) func() {
+	original := watchPollInterval
+	watchPollInterval = d
+	return func() { watchPollInterval = original }
+}
+
+func TestFileWatcherWaitsForChanges(t *testing.T) {
+	defer withWatchPollInterval(10 * time.Millisecond)()
+	dir, err := ioutil.TempDir("", "gh-status-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "main.go")
+	ioutil.WriteFile(path, []byte("package main"), 0644)
+
+	watcher := &fileWatcher{Patterns: []string{dir}, Debounce: 50 * time.Millisecond}
+	before := watcher.snapshot()
+	if len(before) != 1 {
+		t.Fatalf("Expected one watched file, got %v", before)
+	}
+
+	go func() {
+		time.Sleep(30 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(dir, "other.go"), []byte("package main"), 0644)
+	}()
+	after, ok := watcher.wait(before, make(chan os.Signal))
+	if !ok || len(after) != 2 {
+		t.Errorf("Expected the new file to end the wait, got %v %v", after, ok)
+	}
+}
+
+func TestFileWatcherInterrupted(t *testing.T) {
+	defer withWatchPollInterval(10 * time.Millisecond)()
+	interrupts := make(chan os.Signal, 1)
+	interrupts <- os.Interrupt
+	watcher := &fileWatcher{Patterns: []string{"testdata"}}
+	if _, ok := watcher.wait(watcher.snapshot(), interrupts); ok {
+		t.Errorf("Expected an interrupt to end the wait")
+	}
+}
+
+func TestRunWatchReportsEachRun(t *testing.T) {
+	defer withWatchPollInterval(10 * time.Millisecond)()
+	var mu sync.Mutex
+	var states []string
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		var params CommitStatusParams
+		json.NewDecoder(r.Body).Decode(&params)
+		mu.Lock()
+		states = append(states, params.State)
+		mu.Unlock()
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	dir, err := ioutil.TempDir("", "gh-status-reporter")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	watched := filepath.Join(dir, "src")
+	os.Mkdir(watched, 0755)
+
+	flags := defaultFlags()
+	targets, _ := statusTargets(*flags)
+	r := &reporter{flags: *flags, targets: targets}
+	interrupts := make(chan os.Signal, 1)
+	watcher := &fileWatcher{Patterns: []string{watched}, Debounce: 20 * time.Millisecond}
+
+	go func() {
+		count := func() int {
+			mu.Lock()
+			defer mu.Unlock()
+			return len(states)
+		}
+		for count() < 2 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		// Let the watcher take its first snapshot before changing a file.
+		time.Sleep(50 * time.Millisecond)
+		ioutil.WriteFile(filepath.Join(watched, "main.go"), []byte("package main"), 0644)
+		for count() < 4 {
+			time.Sleep(5 * time.Millisecond)
+		}
+		interrupts <- os.Interrupt
+	}()
+
+	code := runWatch(exec.Command("true"), commandOptions{}, r, watcher, interrupts)
+	if code != 0 {
+		t.Errorf("Expected exit code 0, got %d", code)
+	}
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(states, " ") != "pending success pending success" {
+		t.Errorf("Expected two reported runs, got %q", states)
+	}
+}
+
+func TestValidateWatchPatterns(t *testing.T) {
+	flags := defaultFlags()
+	flags.Watch = []string{"src/[", "*.go"}
+	if err := validateWatchPatterns(*flags); err == nil {
+		t.Errorf("Expected an invalid glob to be rejected")
+	}
+}
@@ -0,0 +1,38 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/url"
+)
+
+// validateWebhookFlags checks -webhook-url, -webhook-header and
+// -webhook-strict.
+func validateWebhookFlags(flags Flags) error {
+	if flags.WebhookURL == "" {
+		if len(flags.WebhookHeaders) > 0 || flags.WebhookStrict {
+			return errors.New("Error: -webhook-header and -webhook-strict require -webhook-url")
+		}
+		return nil
+	}
+	if parsed, err := url.Parse(flags.WebhookURL); err != nil || (parsed.Scheme != "http" && parsed.Scheme != "https") || parsed.Host == "" {
+		return fmt.Errorf("Error: -webhook-url %q is not an absolute http or https URL", redactURL(flags.WebhookURL))
+	}
+	_, err := parseHeaderFlags("-webhook-header", flags.WebhookHeaders)
+	return err
+}
+
+// postWebhook sends event to -webhook-url as JSON, with the
+// -webhook-header headers.
+func postWebhook(flags Flags, event statusEvent) error {
+	header, err := parseHeaderFlags("-webhook-header", flags.WebhookHeaders)
+	if err != nil {
+		return err
+	}
+	body, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("Error converting the webhook event to json: %s", err)
+	}
+	return postJSON(flags, "-webhook-url", flags.WebhookURL, header, body)
+}
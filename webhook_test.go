@@ -0,0 +1,100 @@
+package main
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestValidateWebhookFlags(t *testing.T) {
+	for _, test := range []struct {
+		flags   Flags
+		message string
+	}{
+		{Flags{}, ""},
+		{Flags{WebhookURL: "https://hooks.example.com/ci", WebhookHeaders: stringSlice{"Authorization: Bearer secret"}, WebhookStrict: true}, ""},
+		{Flags{WebhookHeaders: stringSlice{"Authorization: Bearer secret"}}, "require -webhook-url"},
+		{Flags{WebhookStrict: true}, "require -webhook-url"},
+		{Flags{WebhookURL: "hooks.example.com/ci"}, "not an absolute http or https URL"},
+		{Flags{WebhookURL: "https://hooks.example.com/ci", WebhookHeaders: stringSlice{"Authorization"}}, "-webhook-header"},
+	} {
+		err := validateWebhookFlags(test.flags)
+		if (test.message == "" && err != nil) || (test.message != "" && (err == nil || !strings.Contains(err.Error(), test.message))) {
+			t.Errorf("Expected %q for %+v, got %v", test.message, test.flags, err)
+		}
+	}
+}
+
+func TestReporterPostsWebhook(t *testing.T) {
+	var events []statusEvent
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer secret" {
+			t.Errorf("Expected the -webhook-header, got %q", r.Header.Get("Authorization"))
+		}
+		if r.Header.Get("Content-Type") != "application/json" {
+			t.Errorf("Unexpected content type %q", r.Header.Get("Content-Type"))
+		}
+		body, _ := ioutil.ReadAll(r.Body)
+		var event statusEvent
+		if err := json.Unmarshal(body, &event); err != nil {
+			t.Errorf("Expected JSON, got %s", body)
+		}
+		events = append(events, event)
+	}))
+	defer server.Close()
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.WebhookURL = server.URL + "/ci"
+	flags.WebhookHeaders = stringSlice{"Authorization: Bearer secret"}
+	flags.ReporterID = "ci-runner-7"
+	statusReporter := &reporter{flags: *flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+	if err := statusReporter.report("pending", nil); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+	if err := statusReporter.report("success", &commandResult{}); err != nil {
+		t.Fatalf("Got unexpected error: %s", err)
+	}
+
+	if len(events) != 2 {
+		t.Fatalf("Expected an event per transition, got %+v", events)
+	}
+	for i, state := range []string{"pending", "success"} {
+		event := events[i]
+		if event.State != state || event.SHA != "deadbeef" || event.Context != "ci" || event.Description != "unit test [ci-runner-7]" || event.ReporterID != "ci-runner-7" || event.Timestamp == "" {
+			t.Errorf("Unexpected %s event %+v", state, event)
+		}
+	}
+	if statusReporter.webhookFailures != 0 {
+		t.Errorf("Expected no failures, got %d", statusReporter.webhookFailures)
+	}
+}
+
+func TestReporterWarnsOnWebhookFailure(t *testing.T) {
+	out := withLogger(t, logWarn)
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusBadGateway)
+	}))
+	defer server.Close()
+	defer withGithubAPI(t, func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusCreated)
+	})()
+
+	flags := defaultFlags()
+	flags.WebhookURL = server.URL
+	statusReporter := &reporter{flags: *flags, targets: []statusTarget{{"org/repo", "deadbeef"}}}
+	if err := statusReporter.report("pending", nil); err != nil {
+		t.Errorf("Expected a failed webhook not to fail the status, got %s", err)
+	}
+	if !strings.Contains(out.String(), "could not post pending to -webhook-url") || !strings.Contains(out.String(), "responded with 502") {
+		t.Errorf("Expected a warning, got %q", out)
+	}
+	if statusReporter.webhookFailures != 1 {
+		t.Errorf("Expected one failure to be counted, got %d", statusReporter.webhookFailures)
+	}
+}
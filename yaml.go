@@ -0,0 +1,522 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+// parseYAML parses the subset of YAML pipeline files are written in into
+// map[string]interface{}, []interface{}, string and nil values, the shapes
+// encoding/json decodes from. It handles block mappings and sequences,
+// one-line flow collections, plain and quoted scalars, | and > block scalars
+// and comments. Anchors, tags and multiple documents are rejected. Every
+// scalar is a string, so `timeout: 10m` and `DEBUG: 1` alike decode into
+// string fields.
+func parseYAML(contents []byte) (interface{}, error) {
+	text := strings.TrimPrefix(string(contents), "\ufeff")
+	parser := &yamlParser{lines: strings.Split(strings.ReplaceAll(text, "\r\n", "\n"), "\n")}
+	line, ok, err := parser.next()
+	if err != nil || !ok {
+		return nil, err
+	}
+	value, err := parser.parseBlock(line.indent)
+	if err != nil {
+		return nil, err
+	}
+	if line, ok, err := parser.next(); err != nil {
+		return nil, err
+	} else if ok {
+		return nil, yamlErrorf(line.number, "unexpected %q; check its indentation", line.text)
+	}
+	return value, nil
+}
+
+// yamlError is a syntax error on a line of the file, numbered from 1.
+type yamlError struct {
+	line    int
+	message string
+}
+
+func (e *yamlError) Error() string {
+	return fmt.Sprintf("line %d: %s", e.line, e.message)
+}
+
+func yamlErrorf(line int, format string, args ...interface{}) error {
+	return &yamlError{line, fmt.Sprintf(format, args...)}
+}
+
+// yamlLine is a line with content: its indentation and its text without
+// the indentation, trailing spaces or comment.
+type yamlLine struct {
+	number int
+	indent int
+	text   string
+}
+
+type yamlParser struct {
+	lines []string
+	// pos is the index of the next line to read.
+	pos int
+	// started is set once the document has content, after which --- can't
+	// start it.
+	started bool
+}
+
+// next returns the next line with content without consuming it.
+func (p *yamlParser) next() (yamlLine, bool, error) {
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		content := strings.TrimLeft(raw, " ")
+		indent := len(raw) - len(content)
+		if strings.HasPrefix(content, "\t") {
+			return yamlLine{}, false, yamlErrorf(p.pos+1, "tabs can't be used for indentation")
+		}
+		text, err := stripYAMLComment(content)
+		if err != nil {
+			return yamlLine{}, false, yamlErrorf(p.pos+1, "%s", err)
+		}
+		if text == "" || (indent == 0 && text == "---" && !p.started) {
+			continue
+		}
+		if indent == 0 && (text == "---" || text == "...") {
+			return yamlLine{}, false, yamlErrorf(p.pos+1, "only one document is supported")
+		}
+		p.started = true
+		return yamlLine{number: p.pos + 1, indent: indent, text: text}, true, nil
+	}
+	return yamlLine{}, false, nil
+}
+
+// parseBlock parses the mapping, sequence or scalar starting on the next
+// line, which is indented by indent.
+func (p *yamlParser) parseBlock(indent int) (interface{}, error) {
+	line, _, _ := p.next()
+	switch {
+	case isYAMLSequenceItem(line.text):
+		return p.parseSequence(indent)
+	case yamlKeyEnd(line.text) >= 0:
+		return p.parseMapping(indent)
+	}
+	p.pos++
+	value, err := parseYAMLInline(line.text, line.number)
+	if err != nil {
+		return nil, err
+	}
+	if next, ok, err := p.next(); err != nil {
+		return nil, err
+	} else if ok && next.indent >= indent && indent > 0 {
+		return nil, yamlErrorf(next.number, "multi-line plain values aren't supported; use | or quotes")
+	}
+	return value, nil
+}
+
+func isYAMLSequenceItem(text string) bool {
+	return text == "-" || strings.HasPrefix(text, "- ")
+}
+
+// parseSequence parses the "- " items indented by indent.
+func (p *yamlParser) parseSequence(indent int) (interface{}, error) {
+	items := []interface{}{}
+	for {
+		line, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent || (line.indent == indent && !isYAMLSequenceItem(line.text)) {
+			return items, nil
+		}
+		if line.indent > indent || !isYAMLSequenceItem(line.text) {
+			return nil, yamlErrorf(line.number, "unexpected %q; check its indentation", line.text)
+		}
+		rest := strings.TrimLeft(strings.TrimPrefix(line.text, "-"), " ")
+		if rest == "" {
+			p.pos++
+			item, err := p.parseNested(indent, false)
+			if err != nil {
+				return nil, err
+			}
+			items = append(items, item)
+			continue
+		}
+		// The item's content starts a block of its own at its column, so
+		// "- name: a" continues with keys lined up under name.
+		column := line.indent + len(line.text) - len(rest)
+		p.lines[p.pos] = strings.Repeat(" ", column) + p.lines[p.pos][column:]
+		item, err := p.parseBlock(column)
+		if err != nil {
+			return nil, err
+		}
+		items = append(items, item)
+	}
+}
+
+// parseMapping parses the "key: value" entries indented by indent.
+func (p *yamlParser) parseMapping(indent int) (interface{}, error) {
+	entries := map[string]interface{}{}
+	for {
+		line, ok, err := p.next()
+		if err != nil {
+			return nil, err
+		}
+		if !ok || line.indent < indent {
+			return entries, nil
+		}
+		end := yamlKeyEnd(line.text)
+		if line.indent > indent || end < 0 || isYAMLSequenceItem(line.text) {
+			return nil, yamlErrorf(line.number, "unexpected %q; check its indentation", line.text)
+		}
+		key, err := parseYAMLKey(line.text[:end], line.number)
+		if err != nil {
+			return nil, err
+		}
+		if _, ok := entries[key]; ok {
+			return nil, yamlErrorf(line.number, "duplicate key %q", key)
+		}
+		rest := strings.TrimSpace(line.text[end+1:])
+		p.pos++
+		var value interface{}
+		switch {
+		case rest == "":
+			value, err = p.parseNested(indent, true)
+		case rest[0] == '|' || rest[0] == '>':
+			value, err = p.parseBlockScalar(indent, rest, line.number)
+		default:
+			value, err = parseYAMLInline(rest, line.number)
+		}
+		if err != nil {
+			return nil, err
+		}
+		entries[key] = value
+	}
+}
+
+// parseNested parses the value of a key or "-" with nothing after it: a
+// block indented further, or for a key a sequence at the key's own
+// indentation, or else null.
+func (p *yamlParser) parseNested(indent int, key bool) (interface{}, error) {
+	line, ok, err := p.next()
+	if err != nil || !ok {
+		return nil, err
+	}
+	if line.indent > indent || (key && line.indent == indent && isYAMLSequenceItem(line.text)) {
+		return p.parseBlock(line.indent)
+	}
+	return nil, nil
+}
+
+// parseBlockScalar parses a | (literal) or > (folded) value on the lines
+// indented further than indent. header is the indicator with its optional
+// - (strip) or + (keep) chomping.
+func (p *yamlParser) parseBlockScalar(indent int, header string, number int) (interface{}, error) {
+	folded, chomp := header[0] == '>', header[1:]
+	if chomp != "" && chomp != "-" && chomp != "+" {
+		return nil, yamlErrorf(number, "unsupported block scalar header %q", header)
+	}
+	var lines []string
+	blockIndent := -1
+	for ; p.pos < len(p.lines); p.pos++ {
+		raw := p.lines[p.pos]
+		content := strings.TrimLeft(raw, " ")
+		if content == "" {
+			lines = append(lines, "")
+			continue
+		}
+		lineIndent := len(raw) - len(content)
+		if blockIndent < 0 {
+			if lineIndent <= indent {
+				break
+			}
+			blockIndent = lineIndent
+		}
+		if lineIndent < blockIndent {
+			break
+		}
+		lines = append(lines, strings.TrimRight(raw[blockIndent:], " "))
+	}
+	// Trailing blank lines belong to the scalar only with +.
+	trailing := 0
+	for len(lines) > 0 && lines[len(lines)-1] == "" {
+		lines = lines[:len(lines)-1]
+		trailing++
+	}
+	var value string
+	if folded {
+		// Lines are joined with spaces, and each blank line between them
+		// is a newline instead.
+		for i, line := range lines {
+			switch {
+			case line == "":
+				value += "\n"
+			case i > 0 && lines[i-1] != "":
+				value += " "
+			}
+			value += line
+		}
+	} else {
+		value = strings.Join(lines, "\n")
+	}
+	switch {
+	case len(lines) == 0:
+	case chomp == "":
+		value += "\n"
+	case chomp == "+":
+		value += strings.Repeat("\n", trailing+1)
+	}
+	return value, nil
+}
+
+// yamlKeyEnd returns the index of the colon ending a mapping key in text,
+// or -1 when text isn't a "key: value" entry.
+func yamlKeyEnd(text string) int {
+	if text == "" || strings.ContainsRune("[{", rune(text[0])) {
+		return -1
+	}
+	start := 0
+	if text[0] == '"' || text[0] == '\'' {
+		end, err := yamlQuoteEnd(text, 0)
+		if err != nil {
+			return -1
+		}
+		start = end
+	}
+	for i := start; i < len(text); i++ {
+		if text[i] == ':' && (i+1 == len(text) || text[i+1] == ' ') {
+			return i
+		}
+	}
+	return -1
+}
+
+// parseYAMLKey returns a mapping key, unquoting it if it is quoted.
+func parseYAMLKey(text string, number int) (string, error) {
+	key := strings.TrimSpace(text)
+	if key != "" && (key[0] == '"' || key[0] == '\'') {
+		value, err := parseYAMLInline(key, number)
+		if err != nil {
+			return "", err
+		}
+		return value.(string), nil
+	}
+	if key == "" || strings.ContainsRune("&*!|>%@`?", rune(key[0])) {
+		return "", yamlErrorf(number, "unsupported key %q", key)
+	}
+	return key, nil
+}
+
+// parseYAMLInline parses a value written on one line: a flow sequence or
+// mapping, a quoted string or a plain scalar.
+func parseYAMLInline(text string, number int) (interface{}, error) {
+	value, end, err := parseYAMLFlow(text, 0, false)
+	if err == nil && strings.TrimSpace(text[end:]) != "" {
+		err = fmt.Errorf("unexpected %q after the value", strings.TrimSpace(text[end:]))
+	}
+	if err != nil {
+		return nil, yamlErrorf(number, "%s", err)
+	}
+	return value, nil
+}
+
+// parseYAMLFlow parses the value starting at text[i], returning it and the
+// index after it. Inside a flow collection, plain scalars end at , ] and }.
+func parseYAMLFlow(text string, i int, inFlow bool) (interface{}, int, error) {
+	for i < len(text) && text[i] == ' ' {
+		i++
+	}
+	if i == len(text) {
+		if inFlow {
+			return nil, i, errors.New("unterminated flow collection; it must fit on one line")
+		}
+		return nil, i, nil
+	}
+	switch text[i] {
+	case '[':
+		items := []interface{}{}
+		i++
+		for {
+			if j := skipYAMLSpaces(text, i); j < len(text) && text[j] == ']' {
+				return items, j + 1, nil
+			}
+			item, end, err := parseYAMLFlow(text, i, true)
+			if err != nil {
+				return nil, end, err
+			}
+			items = append(items, item)
+			i = skipYAMLSpaces(text, end)
+			if i < len(text) && text[i] == ',' {
+				i++
+				continue
+			}
+			if i < len(text) && text[i] == ']' {
+				return items, i + 1, nil
+			}
+			if i == len(text) {
+				return nil, i, errors.New("unterminated flow collection; it must fit on one line")
+			}
+			return nil, i, errors.New("expected , or ] in a flow sequence")
+		}
+	case '{':
+		entries := map[string]interface{}{}
+		i++
+		for {
+			if j := skipYAMLSpaces(text, i); j < len(text) && text[j] == '}' {
+				return entries, j + 1, nil
+			}
+			key, end, err := parseYAMLFlow(text, i, true)
+			if err != nil {
+				return nil, end, err
+			}
+			name, ok := key.(string)
+			if !ok {
+				return nil, end, errors.New("flow mapping keys must be scalars")
+			}
+			i = skipYAMLSpaces(text, end)
+			if i == len(text) || text[i] != ':' {
+				return nil, i, fmt.Errorf("expected : after %q in a flow mapping", name)
+			}
+			if _, ok := entries[name]; ok {
+				return nil, i, fmt.Errorf("duplicate key %q", name)
+			}
+			value, end, err := parseYAMLFlow(text, i+1, true)
+			if err != nil {
+				return nil, end, err
+			}
+			entries[name] = value
+			i = skipYAMLSpaces(text, end)
+			if i < len(text) && text[i] == ',' {
+				i++
+				continue
+			}
+			if i < len(text) && text[i] == '}' {
+				return entries, i + 1, nil
+			}
+			if i == len(text) {
+				return nil, i, errors.New("unterminated flow collection; it must fit on one line")
+			}
+			return nil, i, errors.New("expected , or } in a flow mapping")
+		}
+	case '"', '\'':
+		end, err := yamlQuoteEnd(text, i)
+		if err != nil {
+			return nil, i, err
+		}
+		value, err := unquoteYAML(text[i:end])
+		return value, end, err
+	case '&', '*', '!', '|', '>', '%', '@', '`':
+		return nil, i, fmt.Errorf("unsupported YAML syntax %q", text[i:])
+	}
+	end := len(text)
+	if inFlow {
+		if j := strings.IndexAny(text[i:], ",]}"); j >= 0 {
+			end = i + j
+		}
+		// In a flow mapping, ": " ends a key too.
+		if j := strings.Index(text[i:end], ": "); j >= 0 {
+			end = i + j
+		} else if strings.HasSuffix(text[i:end], ":") {
+			end--
+		}
+	}
+	value := strings.TrimSpace(text[i:end])
+	if value == "~" || value == "null" || value == "Null" || value == "NULL" {
+		return nil, end, nil
+	}
+	return value, end, nil
+}
+
+func skipYAMLSpaces(text string, i int) int {
+	for i < len(text) && text[i] == ' ' {
+		i++
+	}
+	return i
+}
+
+// yamlQuoteEnd returns the index after the quoted string starting at
+// text[i].
+func yamlQuoteEnd(text string, i int) (int, error) {
+	quote := text[i]
+	for j := i + 1; j < len(text); j++ {
+		switch {
+		case quote == '"' && text[j] == '\\':
+			j++
+		case text[j] == quote && quote == '\'' && j+1 < len(text) && text[j+1] == '\'':
+			j++
+		case text[j] == quote:
+			return j + 1, nil
+		}
+	}
+	return 0, errors.New("unterminated quoted string")
+}
+
+// unquoteYAML decodes a single or double quoted string.
+func unquoteYAML(quoted string) (string, error) {
+	inner := quoted[1 : len(quoted)-1]
+	if quoted[0] == '\'' {
+		return strings.ReplaceAll(inner, "''", "'"), nil
+	}
+	var value strings.Builder
+	for i := 0; i < len(inner); i++ {
+		if inner[i] != '\\' {
+			value.WriteByte(inner[i])
+			continue
+		}
+		i++
+		switch escape := inner[i]; escape {
+		case '\\', '"', '/', ' ':
+			value.WriteByte(escape)
+		case 'n':
+			value.WriteByte('\n')
+		case 't':
+			value.WriteByte('\t')
+		case 'r':
+			value.WriteByte('\r')
+		case '0':
+			value.WriteByte(0)
+		case 'e':
+			value.WriteByte(0x1b)
+		case 'x', 'u', 'U':
+			size := map[byte]int{'x': 2, 'u': 4, 'U': 8}[escape]
+			if i+1+size > len(inner) {
+				return "", fmt.Errorf("invalid escape in %s", quoted)
+			}
+			code, err := strconv.ParseUint(inner[i+1:i+1+size], 16, 32)
+			if err != nil || !utf8.ValidRune(rune(code)) {
+				return "", fmt.Errorf("invalid escape in %s", quoted)
+			}
+			value.WriteRune(rune(code))
+			i += size
+		default:
+			return "", fmt.Errorf("invalid escape \\%c in %s", escape, quoted)
+		}
+	}
+	return value.String(), nil
+}
+
+// stripYAMLComment removes a # comment and trailing spaces from a line's
+// content. A # only starts a comment at the start or after a space, and
+// never inside a quoted string.
+func stripYAMLComment(content string) (string, error) {
+	for i := 0; i < len(content); i++ {
+		switch c := content[i]; {
+		case c == '#' && (i == 0 || content[i-1] == ' '):
+			return strings.TrimRight(content[:i], " "), nil
+		case (c == '"' || c == '\'') && startsYAMLScalar(content, i):
+			end, err := yamlQuoteEnd(content, i)
+			if err != nil {
+				return "", err
+			}
+			i = end - 1
+		}
+	}
+	return strings.TrimRight(content, " "), nil
+}
+
+// startsYAMLScalar says whether content[i] is the first character of a
+// value, where a quote opens a quoted string rather than being part of a
+// plain one such as don't.
+func startsYAMLScalar(content string, i int) bool {
+	before := strings.TrimRight(content[:i], " ")
+	return before == "" || strings.ContainsRune(":-[{,", rune(before[len(before)-1]))
+}
@@ -0,0 +1,50 @@
+package main
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseYAML(t *testing.T) {
+	for contents, expected := range map[string]interface{}{
+		"a: b\nc: 'it''s'\nd: \"tab\\there\"\n":                map[string]interface{}{"a": "b", "c": "it's", "d": "tab\there"},
+		"---\n# comment\nlist:\n  - one\n  - two # trailing\n": map[string]interface{}{"list": []interface{}{"one", "two"}},
+		"list:\n- one\n- two\nnext: x\n":                       map[string]interface{}{"list": []interface{}{"one", "two"}, "next": "x"},
+		"flow: [a, 'b, c', {k: v}]\nempty: []\n":               map[string]interface{}{"flow": []interface{}{"a", "b, c", map[string]interface{}{"k": "v"}}, "empty": []interface{}{}},
+		"- name: a\n  needs: [b]\n-\n  name: b\n":              []interface{}{map[string]interface{}{"name": "a", "needs": []interface{}{"b"}}, map[string]interface{}{"name": "b"}},
+		"url: http://example.com/a#b\nnull: ~\nnothing:\n":     map[string]interface{}{"url": "http://example.com/a#b", "null": nil, "nothing": nil},
+		"run: |\n  one\n    two\n\n  # kept\nafter: x\n":       map[string]interface{}{"run": "one\n  two\n\n# kept\n", "after": "x"},
+		"run: |-\n  one\n  two\n\n":                            map[string]interface{}{"run": "one\ntwo"},
+		"run: >\n  one\n  two\n\n  three\n":                    map[string]interface{}{"run": "one two\nthree\n"},
+		"say: echo don't # stop\n":                             map[string]interface{}{"say": "echo don't"},
+	} {
+		value, err := parseYAML([]byte(contents))
+		if err != nil {
+			t.Errorf("Got unexpected error for %q: %s", contents, err)
+		} else if !reflect.DeepEqual(value, expected) {
+			t.Errorf("Expected %q to parse to %#v, got %#v", contents, expected, value)
+		}
+	}
+}
+
+func TestParseYAMLRejectsInvalid(t *testing.T) {
+	for contents, expected := range map[string]string{
+		"a: b\na: c\n":       `line 2: duplicate key "a"`,
+		"a:\n\tb: c\n":       "line 2: tabs can't be used for indentation",
+		"a: b\n  c: d\n":     `line 2: unexpected "c: d"`,
+		"a: [b, c\n":         "line 1: unterminated flow collection",
+		"a: 'b\n":            "line 1: unterminated quoted string",
+		"a: &anchor b\n":     "line 1: unsupported YAML syntax",
+		"a: b\n---\nc: d\n":  "line 2: only one document is supported",
+		"a:\n  - b\n c: d\n": `line 3: unexpected "c: d"`,
+		"a: \"\\q\"\n":       `line 1: invalid escape \q`,
+		"a: |2\n  b\n":       `line 1: unsupported block scalar header "|2"`,
+		"- a\nb: c\n":        `line 2: unexpected "b: c"`,
+		"a:\n  b\n  c\n":     "line 3: multi-line plain values aren't supported",
+	} {
+		if _, err := parseYAML([]byte(contents)); err == nil || !strings.Contains(err.Error(), expected) {
+			t.Errorf("Expected %q to fail with %q, got %v", contents, expected, err)
+		}
+	}
+}